@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+
+	repl "github.com/jjo/promql-cli/pkg/repl"
+)
+
+// The help subcommand, --help-all and the man page are rendered from the ffcli commands and
+// their flag sets, and from the REPL metadata (repl.AdHocCommands, repl.KeyBindings and
+// repl.EnvVars), so that they can't drift from what the binary accepts.
+
+// helpTopics describes the topics of promql-cli help, besides subcommands and ad-hoc commands.
+var helpTopics = [][2]string{
+	{"commands", "REPL ad-hoc commands"},
+	{".<command>", "one ad-hoc command, e.g. .scrape"},
+	{"keys", "REPL keyboard shortcuts"},
+	{"env", "environment variables"},
+	{"all", "everything above (same as --help-all)"},
+	{"man", "the promql-cli(1) man page, in roff: promql-cli help man > promql-cli.1"},
+}
+
+// runHelp writes the help of the topic in args, or the list of topics.
+func runHelp(w io.Writer, root *ffcli.Command, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("help takes a single topic, got %d", len(args))
+	}
+	if len(args) == 0 {
+		writeCommandHelp(w, root)
+		mustFprint(w, "\nHELP TOPICS\n")
+		for _, sub := range root.Subcommands {
+			mustFprint(w, fmt.Sprintf("  %-12s %s\n", sub.Name, sub.ShortHelp))
+		}
+		for _, t := range helpTopics {
+			mustFprint(w, fmt.Sprintf("  %-12s %s\n", t[0], t[1]))
+		}
+		return nil
+	}
+	topic := args[0]
+	for _, sub := range root.Subcommands {
+		if sub.Name == topic {
+			writeSubcommandHelp(w, sub)
+			return nil
+		}
+	}
+	switch topic {
+	case "commands", "repl":
+		for _, cmd := range repl.AdHocCommands {
+			repl.WriteAdHocCommandHelp(w, cmd)
+		}
+	case "keys":
+		repl.WriteKeyBindings(w)
+	case "env":
+		repl.WriteEnvVars(w)
+	case "all":
+		writeHelpAll(w, root)
+	case "man":
+		writeManPage(w, root)
+	default:
+		cmd := repl.FindAdHocCommand(topic)
+		if cmd == nil {
+			return fmt.Errorf("unknown help topic %q, see promql-cli help", topic)
+		}
+		repl.WriteAdHocCommandHelp(w, *cmd)
+	}
+	return nil
+}
+
+// writeCommandHelp writes the usage, description and flags of cmd.
+func writeCommandHelp(w io.Writer, cmd *ffcli.Command) {
+	mustFprint(w, "USAGE\n  "+commandUsage(cmd)+"\n")
+	if cmd.ShortHelp != "" {
+		mustFprint(w, "\n"+cmd.ShortHelp+"\n")
+	}
+	if cmd.LongHelp != "" {
+		mustFprint(w, "\n"+cmd.LongHelp+"\n")
+	}
+	if flags := commandFlags(cmd); len(flags) > 0 {
+		mustFprint(w, "\nFLAGS\n")
+		for _, f := range flags {
+			mustFprint(w, "  "+f.spec+"\n")
+			mustFprint(w, "    "+f.usage+"\n")
+		}
+	}
+}
+
+// writeSubcommandHelp writes the help of a subcommand and of its own subcommands, e.g.
+// analyze query-log.
+func writeSubcommandHelp(w io.Writer, sub *ffcli.Command) {
+	writeCommandHelp(w, sub)
+	for _, nested := range sub.Subcommands {
+		mustFprint(w, "\n")
+		writeCommandHelp(w, nested)
+	}
+}
+
+// writeHelpAll writes the help of promql-cli, every subcommand, ad-hoc command, keyboard
+// shortcut and environment variable.
+func writeHelpAll(w io.Writer, root *ffcli.Command) {
+	writeCommandHelp(w, root)
+	for _, sub := range root.Subcommands {
+		mustFprint(w, "\n\n"+strings.ToUpper(sub.Name)+" SUBCOMMAND\n\n")
+		writeSubcommandHelp(w, sub)
+	}
+	mustFprint(w, "\n\nREPL AD-HOC COMMANDS\n\n")
+	for _, cmd := range repl.AdHocCommands {
+		repl.WriteAdHocCommandHelp(w, cmd)
+	}
+	mustFprint(w, "\n\nKEYBOARD SHORTCUTS\n\n")
+	repl.WriteKeyBindings(w)
+	mustFprint(w, "\n\nENVIRONMENT\n\n")
+	repl.WriteEnvVars(w)
+}
+
+// helpFlag is a flag as rendered by help: its spelling with a value placeholder, and its
+// usage with the default value.
+type helpFlag struct {
+	spec  string
+	usage string
+}
+
+// commandFlags returns the flags of cmd, in lexical order.
+func commandFlags(cmd *ffcli.Command) []helpFlag {
+	if cmd.FlagSet == nil {
+		return nil
+	}
+	home, _ := os.UserHomeDir()
+	var flags []helpFlag
+	cmd.FlagSet.VisitAll(func(f *flag.Flag) {
+		name, usage := flag.UnquoteUsage(f)
+		spec := "--" + f.Name
+		if len(f.Name) == 1 {
+			spec = "-" + f.Name
+		}
+		if name != "" {
+			spec += " " + name
+		}
+		if def := f.DefValue; def != "" && def != "false" && def != "0" && def != "[]" {
+			// Defaults under $HOME (e.g. --config) are shown as ~/..., not as the path of whoever renders them
+			if rest, ok := strings.CutPrefix(def, home+"/"); ok && home != "" {
+				def = "~/" + rest
+			}
+			usage += " (default: " + def + ")"
+		}
+		flags = append(flags, helpFlag{spec: spec, usage: usage})
+	})
+	return flags
+}
+
+// commandUsage returns the usage line of cmd.
+func commandUsage(cmd *ffcli.Command) string {
+	if cmd.ShortUsage != "" {
+		return cmd.ShortUsage
+	}
+	return "promql-cli " + cmd.Name
+}
+
+// writeManPage writes the promql-cli(1) man page in roff.
+func writeManPage(w io.Writer, root *ffcli.Command) {
+	mustFprint(w, fmt.Sprintf(".TH PROMQL-CLI 1 %q %q \"promql-cli manual\"\n", manDate(), "promql-cli "+version))
+	mustFprint(w, ".SH NAME\npromql-cli \\- "+roffEscape(root.ShortHelp)+"\n")
+	mustFprint(w, ".SH SYNOPSIS\n")
+	for _, sub := range manCommands(root) {
+		mustFprint(w, ".B\n"+roffEscape(commandUsage(sub))+"\n.br\n")
+	}
+	if root.LongHelp != "" {
+		mustFprint(w, ".SH DESCRIPTION\n"+roffEscape(root.LongHelp)+"\n")
+	}
+	mustFprint(w, ".SH GLOBAL OPTIONS\n")
+	writeManFlags(w, root)
+	mustFprint(w, ".SH COMMANDS\n")
+	for _, sub := range manCommands(root) {
+		mustFprint(w, ".SS "+roffEscape(sub.Name)+"\n")
+		mustFprint(w, ".B\n"+roffEscape(commandUsage(sub))+"\n.PP\n")
+		if sub.ShortHelp != "" {
+			mustFprint(w, roffEscape(sub.ShortHelp)+"\n")
+		}
+		writeManFlags(w, sub)
+	}
+	mustFprint(w, ".SH REPL COMMANDS\n")
+	for _, cmd := range repl.AdHocCommands {
+		mustFprint(w, ".TP\n.B\n"+roffEscape(cmd.Usage)+"\n"+roffEscape(cmd.Description)+"\n")
+		for _, ex := range cmd.Examples {
+			mustFprint(w, ".br\n"+roffEscape("Example: "+ex)+"\n")
+		}
+	}
+	mustFprint(w, ".SH KEYBOARD SHORTCUTS\n")
+	for _, k := range repl.KeyBindings {
+		mustFprint(w, ".TP\n.B\n"+roffEscape(k.Keys)+"\n"+roffEscape(k.Action)+"\n")
+	}
+	mustFprint(w, ".SH ENVIRONMENT\n")
+	for _, e := range repl.EnvVars {
+		mustFprint(w, ".TP\n.B\n"+roffEscape(e.Name)+"\n"+roffEscape(e.Description)+"\n")
+	}
+	mustFprint(w, ".SH FILES\n.TP\n.B\n~/.promql\\-cli.yaml\nConfig file with flag defaults and profiles.\n"+
+		".TP\n.B\n~/.promql\\-cli_history\nREPL history.\n")
+}
+
+// manCommands returns the subcommands of root, each followed by its own subcommands.
+func manCommands(root *ffcli.Command) []*ffcli.Command {
+	var cmds []*ffcli.Command
+	for _, sub := range root.Subcommands {
+		cmds = append(cmds, sub)
+		cmds = append(cmds, sub.Subcommands...)
+	}
+	return cmds
+}
+
+// writeManFlags writes the flags of cmd as roff tagged paragraphs.
+func writeManFlags(w io.Writer, cmd *ffcli.Command) {
+	for _, f := range commandFlags(cmd) {
+		mustFprint(w, ".TP\n.B\n"+roffEscape(f.spec)+"\n"+roffEscape(f.usage)+"\n")
+	}
+}
+
+// manDate returns the man page date: the build date when set, today otherwise.
+func manDate() string {
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		return t.Format("2006-01-02")
+	}
+	return time.Now().Format("2006-01-02")
+}
+
+// roffEscape escapes s for use as roff text: backslashes and hyphens, and a leading dot or
+// quote that roff would take for a request.
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if strings.HasPrefix(l, ".") || strings.HasPrefix(l, "'") {
+			lines[i] = `\&` + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mustFprint writes s to w; help output errors (e.g. a closed pipe) are not actionable.
+func mustFprint(w io.Writer, s string) {
+	_, _ = io.WriteString(w, s)
+}
@@ -2,20 +2,28 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"github.com/prometheus/prometheus/promql"
 	promparser "github.com/prometheus/prometheus/promql/parser"
+	promstorage "github.com/prometheus/prometheus/storage"
+	"golang.org/x/term"
 
 	ai "github.com/jjo/promql-cli/pkg/ai"
+	"github.com/jjo/promql-cli/pkg/api"
 	repl "github.com/jjo/promql-cli/pkg/repl"
 	sstorage "github.com/jjo/promql-cli/pkg/storage"
 )
@@ -60,11 +68,43 @@ func normalizeLongOpts(args []string) []string {
 // main is the entry point of the application.
 // It provides a command-line interface for loading metrics and executing PromQL queries.
 func main() {
+	// Normalize GNU-style long options ("--long") to stdlib format ("-long")
+	norm := normalizeLongOpts(os.Args[1:])
+
+	// The config file provides flag defaults and engine limits, so it is read before parsing.
+	configPath, configExplicit := configFlagValue(norm)
+	if !configExplicit {
+		configPath = repl.DefaultConfigPath()
+	}
+	cfg, err := repl.InitConfig(configPath, configExplicit)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// The engine is built once flags are parsed (see below), with these limits.
+	engineOpts := cfg.EngineOpts(repl.DefaultEngineOpts())
+	var engine *promql.Engine
+
 	// Root (global) flags
 	rootFlags := flag.NewFlagSet("promql-cli", flag.ContinueOnError)
-	replBackend := rootFlags.String("repl", "readline", "REPL backend: prompt|readline")
+	rootFlags.String("config", configPath, "config file with defaults and profiles (env PROMQL_CLI_CONFIG)")
+	replBackend := rootFlags.String("repl", cmp.Or(cfg.Repl, "readline"), "REPL backend: prompt|readline")
 	silent := rootFlags.Bool("silent", false, "suppress startup output")
 	rootFlags.BoolVar(silent, "s", *silent, "shorthand for --silent")
+	helpAll := rootFlags.Bool("help-all", false, "show the help of every subcommand, REPL command, keyboard shortcut and environment variable (see also: promql-cli help)")
+
+	storageBackend := rootFlags.String("storage", "memory", "storage backend: memory|tsdb (tsdb persists samples under --data-dir)")
+	dataDir := rootFlags.String("data-dir", "./data", "data directory for --storage tsdb")
+	profileSpec := rootFlags.String("profile", "", "profile promql-cli itself: cpu=<file>,trace=<file>,heap=<file>,allocs|goroutine|block|mutex=<file>,http=<addr> (REPL: .pprof)")
+	rootFlags.Func("load-workers", "goroutines parsing loaded metrics files, 0 for one per CPU, 1 to parse sequentially (REPL: .load)", repl.SetLoadWorkers)
+	rootFlags.Func("tz", "time zone of displayed timestamps: UTC|local|<location>|<offset>, e.g. Europe/Madrid (REPL: .tz)", repl.SetTimeZone)
+	rootFlags.Func("time-format", "how displayed timestamps are written: rfc3339|unix|relative (REPL: .time_format)", repl.SetTimeFormat)
+	rootFlags.Func("color", "use ANSI colors: auto|always|never (auto: when stdout is a terminal and NO_COLOR is unset)", repl.SetColorMode)
+
+	rootFlags.DurationVar(&engineOpts.Timeout, "engine.timeout", engineOpts.Timeout, "PromQL query timeout (REPL: .engine set timeout)")
+	rootFlags.IntVar(&engineOpts.MaxSamples, "engine.max-samples", engineOpts.MaxSamples, "maximum samples a query may load into memory")
+	rootFlags.DurationVar(&engineOpts.LookbackDelta, "engine.lookback-delta", engineOpts.LookbackDelta, "how far back instant selectors look for the latest sample")
 
 	// Composite AI flag (preferred)
 	var aiConfig ai.AIConfig
@@ -72,31 +112,83 @@ func main() {
 
 	// Prepare shared state
 	storage := sstorage.NewSimpleStorage()
-	engine := promql.NewEngine(promql.EngineOpts{
-		Logger:                   nil,
-		Reg:                      nil,
-		MaxSamples:               50000000,
-		Timeout:                  30 * time.Second,
-		LookbackDelta:            5 * time.Minute,
-		EnableAtModifier:         true,
-		EnableNegativeOffset:     true,
-		NoStepSubqueryIntervalFn: func(_ int64) int64 { return 60 * 1000 },
-	})
+
+	// openStorage opens the persistent backend selected by --storage, if any.
+	// The returned function persists the in-memory store and closes the backend.
+	openStorage := func() (func() error, error) {
+		switch strings.ToLower(*storageBackend) {
+		case "", "memory":
+			return func() error { return nil }, nil
+		case "tsdb":
+			backend, err := sstorage.OpenTSDB(*dataDir)
+			if err != nil {
+				return nil, err
+			}
+			repl.SetPersistentStore(backend)
+			return func() error {
+				defer repl.SetPersistentStore(nil)
+				n, err := backend.Persist(storage)
+				if cerr := backend.Close(); err == nil {
+					err = cerr
+				}
+				if err == nil && !*silent && n > 0 {
+					fmt.Fprintf(os.Stderr, "Persisted %d samples to %s\n", n, backend.Dir)
+				}
+				return err
+			}, nil
+		default:
+			return nil, fmt.Errorf("unknown storage backend %q (expected memory|tsdb)", *storageBackend)
+		}
+	}
 
 	// load subcommand
 	loadFlags := flag.NewFlagSet("load", flag.ContinueOnError)
+	loadStream := loadFlags.Bool("stream", false, "stream large files line by line with progress reporting")
+	loadMaxSamples := loadFlags.Int64("max-samples", 0, "stop loading after N samples (implies --stream)")
+	loadTSDB := loadFlags.String("tsdb", "", "load from a Prometheus TSDB data directory, snapshot or block instead of a file (REPL: .load_tsdb)")
+	loadMatch := loadFlags.String("match", "", "series selector for --tsdb, e.g. '{job=\"api\"}' (default: all series)")
+	loadStart := loadFlags.String("start", "", "earliest sample for --tsdb: now-<dur>|RFC3339|unix (default: unbounded)")
+	loadEnd := loadFlags.String("end", "", "latest sample for --tsdb: now-<dur>|RFC3339|unix (default: unbounded)")
 	loadCmd := &ffcli.Command{
 		Name:       "load",
-		ShortUsage: "promql-cli [--repl=...] load <file.prom>",
+		ShortUsage: "promql-cli [--repl=...] load [--stream] [--max-samples N] <file.prom> | load --tsdb <dir> [--match <selector>] [--start ...] [--end ...]",
+		ShortHelp:  "Parse and load a metrics file or Prometheus TSDB, printing a summary",
 		FlagSet:    loadFlags,
-		Exec: func(_ context.Context, args []string) error {
+		Exec: func(_ context.Context, args []string) (err error) {
 			// Apply AI configuration (composite/env/profile)
 			ai.ConfigureAIComposite(map[string]string(aiConfig))
-			if len(args) != 1 {
-				return fmt.Errorf("load requires <file.prom>")
+			if *loadTSDB == "" && len(args) != 1 || *loadTSDB != "" && len(args) != 0 {
+				return fmt.Errorf("load requires <file.prom> or --tsdb <dir>")
+			}
+			if *loadTSDB == "" && (*loadMatch != "" || *loadStart != "" || *loadEnd != "") {
+				return fmt.Errorf("--match, --start and --end require --tsdb")
+			}
+			closeStorage, err := openStorage()
+			if err != nil {
+				return fmt.Errorf("storage: %w", err)
+			}
+			defer func() {
+				if cerr := closeStorage(); err == nil && cerr != nil {
+					err = fmt.Errorf("storage: %w", cerr)
+				}
+			}()
+			if *loadTSDB != "" {
+				series, samples, err := repl.LoadTSDB(context.Background(), *loadTSDB, *loadMatch, *loadStart, *loadEnd, storage)
+				if err != nil {
+					return fmt.Errorf("failed to load TSDB: %w", err)
+				}
+				if !*silent {
+					fmt.Printf("Successfully loaded %d series, %d samples from TSDB %s\n", series, samples, *loadTSDB)
+					printStorageInfo(storage)
+				}
+				return nil
 			}
 			metricsFile := args[0]
-			if err := loadMetricsFromFile(storage, metricsFile, "", ""); err != nil {
+			stream := streamOptions{enabled: *loadStream, maxSamples: *loadMaxSamples}
+			if !*silent {
+				stream.progress = os.Stderr
+			}
+			if err := loadMetricsFromFile(storage, metricsFile, "", "", stream); err != nil {
 				return fmt.Errorf("failed to load metrics: %w", err)
 			}
 			if !*silent {
@@ -116,30 +208,105 @@ func main() {
 	queryFile := queryFlags.String("file", "", "file containing PromQL expressions (one per line)")
 	queryFlags.StringVar(queryFile, "f", "", "shorthand for --file")
 	rulesSpec := queryFlags.String("rules", "", "Prometheus rules: directory of .yml/.yaml or a glob (e.g., /path/*.yaml)")
-	output := queryFlags.String("output", "", "output format for -q (json)")
-	queryFlags.StringVar(output, "o", "", "shorthand for --output")
+	output := queryFlags.String("output", cfg.Output, "output format for query results: text|json|jsonl|table|csv|tsv|markdown")
+	queryFlags.StringVar(output, "o", cfg.Output, "shorthand for --output")
+	sortOrder := queryFlags.String("sort", "", "order of printed results: value|metric [asc|desc] (e.g. 'value desc')")
+	limit := queryFlags.Int("limit", 0, "print at most N series per result (0: no limit)")
+	humanize := queryFlags.Bool("humanize", false, "with -o table, render values with units inferred from metric names, e.g. 1.2M, 3.4GiB, 1h 2m (REPL: .humanize)")
+	queryStats := queryFlags.Bool("stats", false, "print engine stats (exec time, samples loaded, peak samples, series) after each result (stderr for -q)")
 	initCommands := queryFlags.String("command", "", "semicolon-separated pre-commands")
 	queryFlags.StringVar(initCommands, "c", "", "shorthand for --command")
 	timestamp := queryFlags.String("timestamp", "", "timestamp override for metrics file: now|remove|<timespec>")
 	regex := queryFlags.String("regex", "", "regex filter for series when loading metrics file")
+	queryStream := queryFlags.Bool("stream", false, "stream the metrics file line by line with progress reporting (large files)")
+	queryMaxSamples := queryFlags.Int64("max-samples", 0, "stop loading the metrics file after N samples (implies --stream)")
+	remoteURL := queryFlags.String("remote", "", "Prometheus URL to query alongside local metrics (HTTP API)")
+	remoteRead := queryFlags.String("remote-read", "", "load raw series via remote_read: '<url> <selector> [start] [end] [auth=...]'")
+	remoteProxyURL := queryFlags.String("remote-url", "", "Prometheus URL to run all queries against instead of local metrics (proxy mode)")
+	rangeStart := queryFlags.String("start", "", "range query start for -q: now-<dur>|RFC3339|unix (default: end-1h)")
+	rangeEnd := queryFlags.String("end", "", "range query end for -q: now|now-<dur>|RFC3339|unix (default: now)")
+	rangeStep := queryFlags.String("step", "", "range query step for -q, e.g. 30s, 1m (default: 1m)")
+	watchInterval := queryFlags.Duration("watch", 0, "re-run the -q query every interval (e.g. 5s), highlighting changes, until Ctrl-C")
+	lintOnly := queryFlags.Bool("lint", false, "lint the -q/-f expressions for common mistakes instead of running them; exits non-zero on findings")
+	reportSpec := queryFlags.String("report", "", "with -f, write a per-expression pass/fail report (errors, empty results, failed '# expect:' lines): <file>.xml (JUnit) or <file>.tap (TAP)")
+	assertSpec := queryFlags.String("assert", "", "exit non-zero unless the -q result is: non-empty|empty|'value <op> N' (every value, op one of > >= < <= == !=); -f files use '# expect: ...' lines")
 
 	queryCmd := &ffcli.Command{
 		Name:       "query",
 		ShortUsage: "promql-cli [--repl=...] query [flags] [<file.prom>]",
+		ShortHelp:  "Start the interactive REPL, or run the -q/-f queries or those piped on stdin (optionally loading a metrics file)",
 		FlagSet:    queryFlags,
-		Exec: func(_ context.Context, args []string) error {
+		Exec: func(_ context.Context, args []string) (err error) {
 			// Apply AI configuration (composite/env/profile)
 			ai.ConfigureAIComposite(map[string]string(aiConfig))
 
+			closeStorage, err := openStorage()
+			if err != nil {
+				return fmt.Errorf("storage: %w", err)
+			}
+			defer func() {
+				if cerr := closeStorage(); err == nil && cerr != nil {
+					err = fmt.Errorf("storage: %w", cerr)
+				}
+			}()
+
+			if err := repl.SetOutputFormat(*output); err != nil {
+				return err
+			}
+			if *sortOrder != "" {
+				if err := repl.SetResultSort(*sortOrder); err != nil {
+					return fmt.Errorf("--sort: %w", err)
+				}
+			}
+			if err := repl.SetResultLimit(*limit); err != nil {
+				return fmt.Errorf("--limit: %w", err)
+			}
+			repl.SetHumanize(*humanize)
+			repl.SetQueryStats(*queryStats)
+
+			// Keep stdout clean for one-off queries rendered in a machine-readable format
+			// (e.g. -o json with --start/--end/--step), so output can be piped.
+			if format := strings.ToLower(*output); *oneOffQuery != "" && format != "" && format != "text" {
+				*querySilent = true
+			}
+			// Without -q/-f, queries piped on stdin run as in the REPL, with only their results
+			// on stdout, e.g. cat queries.promql | promql-cli query metrics.prom
+			stdinQueries := *oneOffQuery == "" && *queryFile == "" && !term.IsTerminal(int(os.Stdin.Fd()))
+			if stdinQueries {
+				*querySilent = true
+			}
+
+			if *remoteURL != "" && *remoteProxyURL != "" {
+				return fmt.Errorf("--remote and --remote-url are mutually exclusive")
+			}
+			if *remoteURL != "" {
+				if err := repl.ConnectRemote(*remoteURL); err != nil {
+					return fmt.Errorf("remote: %w", err)
+				}
+			}
+			if *remoteProxyURL != "" {
+				if err := repl.ConnectRemoteProxy(*remoteProxyURL); err != nil {
+					return fmt.Errorf("remote: %w", err)
+				}
+				if !*querySilent {
+					fmt.Printf("Proxy mode: queries run against %s\n", *remoteProxyURL)
+				}
+			}
+
 			// Optional positional metrics file
 			var metricsFile string
 			if len(args) > 0 {
 				metricsFile = args[0]
 			}
 			if metricsFile != "" {
-				if err := loadMetricsFromFile(storage, metricsFile, *timestamp, *regex); err != nil {
+				stream := streamOptions{enabled: *queryStream, maxSamples: *queryMaxSamples}
+				if !*querySilent {
+					stream.progress = os.Stderr
+				}
+				if err := loadMetricsFromFile(storage, metricsFile, *timestamp, *regex, stream); err != nil {
 					return fmt.Errorf("failed to load metrics: %w", err)
 				}
+				repl.RunLoadHooks(storage, metricsFile)
 				if !*querySilent {
 					fmt.Printf("Loaded metrics from %s\n", metricsFile)
 					printStorageInfo(storage)
@@ -147,8 +314,23 @@ func main() {
 				}
 			}
 
+			if *remoteRead != "" {
+				series, samples, err := repl.RemoteReadSpec(context.Background(), *remoteRead, storage)
+				if err != nil {
+					return fmt.Errorf("remote read: %w", err)
+				}
+				if !*querySilent {
+					fmt.Printf("Remote read: loaded %d series, %d samples\n", series, samples)
+				}
+			}
+
 			if *initCommands != "" {
+				// Background scrapes started by the init commands need the REPL to hold the
+				// store lock while it runs queries
+				repl.SetInteractive(*oneOffQuery == "" && *queryFile == "" && !stdinQueries && !*lintOnly && *watchInterval == 0)
 				repl.RunInitCommands(engine, storage, *initCommands, *querySilent)
+				// .engine set may have rebuilt the engine
+				engine = repl.CurrentEngine()
 			}
 
 			// Load and evaluate rules if provided
@@ -185,61 +367,462 @@ func main() {
 				}
 			}
 
+			if *lintOnly {
+				var queries []string
+				if *oneOffQuery != "" {
+					queries = append(queries, *oneOffQuery)
+				}
+				if *queryFile != "" {
+					fileQueries, err := repl.ReadQueriesFromFile(*queryFile)
+					if err != nil {
+						return err
+					}
+					queries = append(queries, fileQueries...)
+				}
+				if len(queries) == 0 {
+					return fmt.Errorf("--lint requires -q or -f")
+				}
+				if n := repl.LintQueries(os.Stdout, storage, queries); n > 0 {
+					return fmt.Errorf("lint: %d issues found", n)
+				}
+				if !*querySilent {
+					fmt.Printf("No issues found (%d expressions)\n", len(queries))
+				}
+				return nil
+			}
+
+			var assertion repl.Assertion
+			if *assertSpec != "" {
+				if *oneOffQuery == "" || *queryFile != "" || *watchInterval != 0 {
+					return fmt.Errorf("--assert requires -q and cannot be combined with -f or --watch (-f files use '# expect: ...' lines)")
+				}
+				if assertion, err = repl.ParseAssertion(*assertSpec); err != nil {
+					return fmt.Errorf("--assert: %w", err)
+				}
+			}
+
+			if *reportSpec != "" && (*queryFile == "" || *lintOnly) {
+				return fmt.Errorf("--report requires -f and cannot be combined with --lint")
+			}
+
 			if *queryFile != "" {
-				if err := repl.ExecuteQueriesFromFile(engine, storage, *queryFile); err != nil {
+				if err := repl.ExecuteQueriesFromFileReport(engine, storage, *queryFile, *reportSpec); err != nil {
 					return fmt.Errorf("error executing queries from file: %w", err)
 				}
 				return nil
 			}
 
+			if *watchInterval != 0 {
+				if *oneOffQuery == "" {
+					return fmt.Errorf("--watch requires -q")
+				}
+				if *rangeStart != "" || *rangeEnd != "" || *rangeStep != "" {
+					return fmt.Errorf("--watch cannot be combined with --start/--end/--step")
+				}
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				return repl.RunWatch(ctx, engine, storage, *oneOffQuery, repl.WatchOptions{Interval: *watchInterval, Clear: true}, os.Stdout)
+			}
+
+			if *oneOffQuery != "" && (*rangeStart != "" || *rangeEnd != "" || *rangeStep != "") {
+				start, end, step, err := repl.ParseRangeSpec(*rangeStart, *rangeEnd, *rangeStep)
+				if err != nil {
+					return fmt.Errorf("range query: %w", err)
+				}
+				res, err := repl.RunRangeQuery(engine, storage, *oneOffQuery, start, end, step, engineOpts.Timeout)
+				if err != nil {
+					return fmt.Errorf("error: %w", err)
+				}
+				if err := repl.PrintResult(res); err != nil {
+					return fmt.Errorf("failed to render %s output: %w", *output, err)
+				}
+				repl.PrintQueryStats(os.Stderr)
+				if *assertSpec != "" {
+					if err := assertion.Check(res); err != nil {
+						return fmt.Errorf("assertion failed: %w", err)
+					}
+				}
+				return nil
+			}
+
 			if *oneOffQuery != "" {
-				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-				q, err := engine.NewInstantQuery(ctx, storage, nil, *oneOffQuery, time.Now())
+				res, err := repl.RunInstantQuery(engine, storage, *oneOffQuery, time.Now(), engineOpts.Timeout)
 				if err != nil {
-					cancel()
-					return fmt.Errorf("error creating query: %w", err)
+					return fmt.Errorf("error: %w", err)
 				}
-				res := q.Exec(ctx)
-				cancel()
-				if res.Err != nil {
-					return fmt.Errorf("error: %w", res.Err)
+				if err := repl.PrintResult(res); err != nil {
+					return fmt.Errorf("failed to render %s output: %w", *output, err)
 				}
-				if strings.EqualFold(*output, "json") {
-					if err := repl.PrintResultJSON(res); err != nil {
-						return fmt.Errorf("failed to render JSON: %w", err)
+				repl.PrintQueryStats(os.Stderr)
+				if *assertSpec != "" {
+					if err := assertion.Check(res); err != nil {
+						return fmt.Errorf("assertion failed: %w", err)
 					}
-				} else {
-					repl.PrintUpstreamQueryResult(res)
 				}
 				return nil
 			}
 
+			if stdinQueries {
+				return repl.ExecuteQueriesFromReader(engine, storage, os.Stdin)
+			}
+
 			// Interactive REPL
 			repl.RunInteractiveQueriesDispatch(engine, storage, *querySilent, *replBackend)
 			return nil
 		},
 	}
 
+	// bench subcommand
+	benchFlags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	var benchQueries stringList
+	benchFlags.Var(&benchQueries, "query", "PromQL expression to benchmark (repeatable)")
+	benchFlags.Var(&benchQueries, "q", "shorthand for --query")
+	benchFile := benchFlags.String("file", "", "file containing PromQL expressions to benchmark (one per line)")
+	benchFlags.StringVar(benchFile, "f", "", "shorthand for --file")
+	benchRuns := benchFlags.Int("n", 10, "number of runs per query")
+	benchOutput := benchFlags.String("output", "text", "output format: text|json")
+	benchFlags.StringVar(benchOutput, "o", "text", "shorthand for --output")
+	benchCmd := &ffcli.Command{
+		Name:       "bench",
+		ShortUsage: "promql-cli bench [-n N] [-o text|json] -q <expr> [-q <expr>...] [-f queries.promql] [<file.prom>]",
+		ShortHelp:  "Benchmark queries: latency percentiles, samples and memory",
+		FlagSet:    benchFlags,
+		Exec: func(_ context.Context, args []string) error {
+			queries := []string(benchQueries)
+			if *benchFile != "" {
+				data, err := os.ReadFile(*benchFile)
+				if err != nil {
+					return fmt.Errorf("failed to read queries: %w", err)
+				}
+				for _, line := range strings.Split(string(data), "\n") {
+					if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+						queries = append(queries, line)
+					}
+				}
+			}
+			if len(queries) == 0 {
+				return fmt.Errorf("bench requires at least one -q or -f")
+			}
+			if *benchOutput != "text" && *benchOutput != "json" {
+				return fmt.Errorf("unknown bench output format %q (expected text|json)", *benchOutput)
+			}
+			if len(args) > 0 {
+				if err := loadMetricsFromFile(storage, args[0], "", "", streamOptions{}); err != nil {
+					return fmt.Errorf("failed to load metrics: %w", err)
+				}
+			}
+			now := time.Now()
+			results := make([]repl.BenchResult, 0, len(queries))
+			for _, q := range queries {
+				res, err := repl.RunBench(engine, storage, q, *benchRuns, now)
+				if err != nil {
+					return fmt.Errorf("%s: %w", q, err)
+				}
+				results = append(results, res)
+			}
+			return repl.WriteBenchResults(os.Stdout, results, *benchOutput == "json")
+		},
+	}
+
+	// analyze subcommand, with an analysis per nested subcommand
+	queryLogFlags := flag.NewFlagSet("query-log", flag.ContinueOnError)
+	queryLogTop := queryLogFlags.Int("top", 20, "number of expressions to show, 0 for all")
+	queryLogSort := queryLogFlags.String("sort", "count", "order of the expressions: count|total|max|avg (seconds)")
+	queryLogReplay := queryLogFlags.Bool("replay", false, "evaluate the shown expressions against the loaded store at its newest sample, timing them")
+	queryLogOutput := queryLogFlags.String("output", "text", "output format: text|json")
+	queryLogFlags.StringVar(queryLogOutput, "o", "text", "shorthand for --output")
+	queryLogCmd := &ffcli.Command{
+		Name:       "query-log",
+		ShortUsage: "promql-cli analyze query-log [--top N] [--sort count|total|max|avg] [--replay] [-o text|json] <query.log> [<file.prom>]",
+		ShortHelp:  "Aggregate a Prometheus query log by expression: most frequent and slowest, optionally replayed against the store",
+		FlagSet:    queryLogFlags,
+		Exec: func(_ context.Context, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				return fmt.Errorf("analyze query-log requires <query.log> [<file.prom>]")
+			}
+			if *queryLogOutput != "text" && *queryLogOutput != "json" {
+				return fmt.Errorf("unknown output format %q (expected text|json)", *queryLogOutput)
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read query log: %w", err)
+			}
+			defer func() { _ = f.Close() }()
+			ql, err := repl.ParseQueryLog(f)
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+			if err := repl.SortQueryLogStats(ql.Stats, *queryLogSort); err != nil {
+				return err
+			}
+			if *queryLogReplay {
+				if len(args) == 2 {
+					if err := loadMetricsFromFile(storage, args[1], "", "", streamOptions{}); err != nil {
+						return fmt.Errorf("failed to load metrics: %w", err)
+					}
+				}
+				shown := ql.Stats
+				if *queryLogTop > 0 && len(shown) > *queryLogTop {
+					shown = shown[:*queryLogTop]
+				}
+				// Evaluate at the newest sample, so that a store loaded from an older capture
+				// still has data within the lookback
+				at := time.Now()
+				if newest := storage.MaxTimestamp(); newest > 0 {
+					at = time.UnixMilli(newest)
+				}
+				repl.ReplayQueryLog(engine, storage, shown, at)
+			}
+			return repl.WriteQueryLog(os.Stdout, ql, *queryLogTop, *queryLogOutput == "json")
+		},
+	}
+	analyzeCmd := &ffcli.Command{
+		Name:        "analyze",
+		ShortUsage:  "promql-cli analyze query-log [flags] <query.log> [<file.prom>]",
+		ShortHelp:   "Analyze Prometheus artifacts, e.g. its query log, for capacity planning",
+		Subcommands: []*ffcli.Command{queryLogCmd},
+		Exec: func(_ context.Context, args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("unknown analysis %q (expected query-log)", args[0])
+			}
+			return fmt.Errorf("analyze requires an analysis: query-log")
+		},
+	}
+
+	// test subcommand
+	testCmd := &ffcli.Command{
+		Name:       "test",
+		ShortUsage: "promql-cli test <rules.test.yaml> [<rules.test.yaml>...]",
+		ShortHelp:  "Run promtool-compatible rule unit tests (input_series, promql_expr_test, alert_rule_test)",
+		Exec: func(_ context.Context, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("test requires at least one <rules.test.yaml>")
+			}
+			passed, failed := 0, 0
+			for _, path := range args {
+				results, err := repl.RunRuleTestFile(engine, path, os.Stdout)
+				if err != nil {
+					return fmt.Errorf("test: %w", err)
+				}
+				for _, r := range results {
+					if len(r.Failures) == 0 {
+						passed++
+					} else {
+						failed++
+					}
+				}
+			}
+			fmt.Printf("%d passed, %d failed\n", passed, failed)
+			if failed > 0 {
+				return fmt.Errorf("%d rule unit tests failed", failed)
+			}
+			return nil
+		},
+	}
+
+	// replay subcommand
+	replayFlags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	replayCheck := replayFlags.Bool("check", false, "don't show the output: diff it against the recorded one, failing on mismatches")
+	replayCmd := &ffcli.Command{
+		Name:       "replay",
+		ShortUsage: "promql-cli replay [--check] <recording.jsonl> [<file.prom>]",
+		ShortHelp:  "Re-run the commands of a .record file, optionally checking their output against the recording",
+		FlagSet:    replayFlags,
+		Exec: func(_ context.Context, args []string) error {
+			ai.ConfigureAIComposite(map[string]string(aiConfig))
+			if len(args) < 1 || len(args) > 2 {
+				return fmt.Errorf("replay requires <recording.jsonl> [<file.prom>]")
+			}
+			if len(args) == 2 {
+				if err := loadMetricsFromFile(storage, args[1], "", "", streamOptions{}); err != nil {
+					return fmt.Errorf("failed to load metrics: %w", err)
+				}
+			}
+			res, err := repl.Replay(engine, storage, args[0], *replayCheck, os.Stdout)
+			if err != nil {
+				return fmt.Errorf("replay: %w", err)
+			}
+			if *replayCheck {
+				fmt.Printf("%d commands replayed, %d mismatches\n", res.Commands, res.Mismatches)
+				if res.Mismatches > 0 {
+					return fmt.Errorf("%d replayed outputs differ from %s", res.Mismatches, args[0])
+				}
+			}
+			return nil
+		},
+	}
+
+	// serve subcommand
+	serveFlags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	serveListen := serveFlags.String("listen", ":9091", "address to serve the Prometheus HTTP API on")
+	serveCommands := serveFlags.String("command", "", "semicolon-separated pre-commands (e.g. '.seed ...; .scrape ...')")
+	serveFlags.StringVar(serveCommands, "c", "", "shorthand for --command")
+	serveRules := serveFlags.String("rules", "", "Prometheus rules to evaluate once after loading: directory of .yml/.yaml or a glob")
+	serveCmd := &ffcli.Command{
+		Name:       "serve",
+		ShortUsage: "promql-cli serve [--listen :9091] [-c '<commands>'] [--rules <spec>] [<file.prom>...]",
+		ShortHelp:  "Expose the loaded metrics through a Prometheus-compatible HTTP API (e.g. as a Grafana data source)",
+		FlagSet:    serveFlags,
+		Exec: func(ctx context.Context, args []string) (err error) {
+			ai.ConfigureAIComposite(map[string]string(aiConfig))
+			closeStorage, err := openStorage()
+			if err != nil {
+				return fmt.Errorf("storage: %w", err)
+			}
+			defer func() {
+				if cerr := closeStorage(); err == nil && cerr != nil {
+					err = fmt.Errorf("storage: %w", cerr)
+				}
+			}()
+			for _, f := range args {
+				if err := loadMetricsFromFile(storage, f, "", "", streamOptions{}); err != nil {
+					return fmt.Errorf("failed to load metrics: %w", err)
+				}
+			}
+			if *serveCommands != "" {
+				repl.RunInitCommands(engine, storage, *serveCommands, *silent)
+				engine = repl.CurrentEngine()
+			}
+			if *serveRules != "" {
+				files, err := repl.ResolveRuleSpec(*serveRules)
+				if err != nil {
+					return fmt.Errorf("rules: %w", err)
+				}
+				if _, _, err := repl.EvaluateRulesOnStorage(engine, storage, files, time.Now(), func(s string) {}); err != nil {
+					return fmt.Errorf("rules evaluation failed: %w", err)
+				}
+			}
+
+			handler := api.NewHandler(engine, func() promstorage.Queryable { return repl.QueryableFor(storage) }, api.Options{
+				Version: version,
+				Metadata: func() map[string][2]string {
+					md := make(map[string][2]string, len(storage.MetricsType))
+					for name, typ := range storage.MetricsType {
+						md[name] = [2]string{typ, storage.MetricsHelp[name]}
+					}
+					return md
+				},
+			})
+			srv := &http.Server{Addr: *serveListen, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+			ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				_ = srv.Shutdown(shutdownCtx)
+			}()
+			if !*silent {
+				printStorageInfo(storage)
+				fmt.Printf("Serving Prometheus HTTP API on %s (Ctrl-C to stop)\n", *serveListen)
+			}
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	// fmt subcommand
+	fmtFlags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fmtWrite := fmtFlags.Bool("w", false, "write the result back to the files instead of stdout")
+	fmtCmd := &ffcli.Command{
+		Name:       "fmt",
+		ShortUsage: "promql-cli fmt [-w] [<file.promql>...]",
+		ShortHelp:  "Pretty-print the PromQL expressions of query files (or stdin), keeping comments and ad-hoc commands",
+		FlagSet:    fmtFlags,
+		Exec: func(_ context.Context, args []string) error {
+			if len(args) == 0 {
+				if *fmtWrite {
+					return fmt.Errorf("fmt -w requires files")
+				}
+				data, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return err
+				}
+				out, err := repl.PrettifyQueryFile(string(data))
+				fmt.Print(out)
+				if err != nil {
+					return fmt.Errorf("<stdin>: %w", err)
+				}
+				return nil
+			}
+			failed := 0
+			for _, path := range args {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				out, perr := repl.PrettifyQueryFile(string(data))
+				if perr != nil {
+					failed++
+					fmt.Fprintf(os.Stderr, "%s: %v\n", path, perr)
+				}
+				switch {
+				case !*fmtWrite:
+					fmt.Print(out)
+				case out != string(data):
+					if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+						return err
+					}
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d files have expressions that don't parse (left as they are)", failed, len(args))
+			}
+			return nil
+		},
+	}
+
 	// version subcommand
 	versionCmd := &ffcli.Command{
-		Name: "version",
-		Exec: func(_ context.Context, _ []string) error { printVersion(); return nil },
+		Name:      "version",
+		ShortHelp: "Show version information",
+		Exec:      func(_ context.Context, _ []string) error { printVersion(); return nil },
+	}
+
+	// help subcommand, rendered from the commands and REPL metadata (see help.go)
+	helpCmd := &ffcli.Command{
+		Name:       "help",
+		ShortUsage: "promql-cli help [<subcommand>|commands|.<command>|keys|env|all|man]",
+		ShortHelp:  "Show the help of a subcommand, the REPL commands, keyboard shortcuts or environment variables, or the man page",
 	}
 
 	root := &ffcli.Command{
 		Name:       "promql-cli",
 		ShortUsage: "promql-cli [--repl=prompt|readline] <subcommand> [flags]",
+		ShortHelp:  "Load Prometheus metrics and query them with PromQL, interactively or in scripts",
 		FlagSet:    rootFlags,
 		Subcommands: []*ffcli.Command{
-			loadCmd, queryCmd, versionCmd,
+			loadCmd, queryCmd, benchCmd, analyzeCmd, testCmd, replayCmd, serveCmd, fmtCmd, versionCmd, helpCmd,
 		},
-		Exec: func(_ context.Context, _ []string) error { return flag.ErrHelp },
+	}
+	helpCmd.Exec = func(_ context.Context, args []string) error { return runHelp(os.Stdout, root, args) }
+	root.Exec = func(_ context.Context, _ []string) error {
+		if *helpAll {
+			writeHelpAll(os.Stdout, root)
+			return nil
+		}
+		return flag.ErrHelp
 	}
 
-	// Normalize GNU-style long options ("--long") to stdlib format ("-long")
-	norm := normalizeLongOpts(os.Args[1:])
-	// Parse args and run
-	if err := root.ParseAndRun(context.Background(), norm); err != nil {
+	// Parse args, build the engine with the resulting limits, and run
+	err = root.Parse(norm)
+	if err == nil && *profileSpec != "" {
+		err = repl.StartProfiling(*profileSpec)
+	}
+	if err == nil {
+		if engineOpts.Timeout <= 0 || engineOpts.MaxSamples <= 0 || engineOpts.LookbackDelta <= 0 {
+			err = errors.New("--engine.timeout, --engine.max-samples and --engine.lookback-delta must be positive")
+		} else {
+			engine = repl.NewEngine(engineOpts)
+			err = root.Run(context.Background())
+		}
+	}
+	if perr := repl.StopProfiling(); err == nil && perr != nil {
+		err = fmt.Errorf("profile: %w", perr)
+	}
+	if err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			root.FlagSet.Usage()
 			os.Exit(1)
@@ -249,6 +832,28 @@ func main() {
 	}
 }
 
+// configFlagValue returns the value of the -config flag in the normalized args, if given.
+func configFlagValue(args []string) (string, bool) {
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		if v, ok := strings.CutPrefix(a, "-config="); ok {
+			return v, true
+		}
+		if a == "-config" && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ", ") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
 // printVersion prints a human-readable version string.
 func printVersion() {
 	fmt.Printf("promql-cli %s\n", version)
@@ -259,7 +864,7 @@ func printVersion() {
 // loadMetricsFromFile loads metrics from a file into the provided storage.
 // It handles file opening, reading, and error reporting.
 // Options like timestamp and regex can be provided to filter/transform the loaded data.
-func loadMetricsFromFile(storage *sstorage.SimpleStorage, filename string, timestampSpec string, regexSpec string) error {
+func loadMetricsFromFile(storage *sstorage.SimpleStorage, filename string, timestampSpec string, regexSpec string, stream streamOptions) error {
 	file, err := os.Open(filename)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
@@ -298,8 +903,21 @@ func loadMetricsFromFile(storage *sstorage.SimpleStorage, filename string, times
 	}
 
 	// Load metrics
+	if stream.enabled || stream.maxSamples > 0 {
+		st, err := repl.StreamLoad(storage, file, re, stream.maxSamples, stream.progress)
+		if err != nil {
+			return err
+		}
+		if st.Truncated && stream.progress != nil {
+			_, _ = fmt.Fprintf(stream.progress, "Stopped after --max-samples=%d\n", stream.maxSamples)
+		}
+		if tsMode != "keep" {
+			repl.ApplyTimestampOverride(storage, beforeCounts, tsMode, tsFixed)
+		}
+		return nil
+	}
 	if re == nil {
-		if err := storage.LoadFromReader(file); err != nil {
+		if err := storage.LoadParallel(file, repl.LoadWorkers()); err != nil {
 			return err
 		}
 		if tsMode != "keep" {
@@ -308,7 +926,7 @@ func loadMetricsFromFile(storage *sstorage.SimpleStorage, filename string, times
 	} else {
 		// Load with regex filtering (same logic as .load command)
 		tmp := sstorage.NewSimpleStorage()
-		if err := tmp.LoadFromReader(file); err != nil {
+		if err := tmp.LoadParallel(file, repl.LoadWorkers()); err != nil {
 			return err
 		}
 		repl.ApplyFilteredLoad(storage, tmp, re, tsMode, tsFixed)
@@ -317,6 +935,14 @@ func loadMetricsFromFile(storage *sstorage.SimpleStorage, filename string, times
 	return nil
 }
 
+// streamOptions selects the streaming loader for large files (see repl.StreamLoad).
+type streamOptions struct {
+	enabled    bool
+	maxSamples int64
+	// progress receives the loading progress line (nil disables it).
+	progress io.Writer
+}
+
 // printStorageInfo displays a summary of the loaded metrics.
 // It shows the total number of metrics and samples, plus examples.
 func printStorageInfo(storage *sstorage.SimpleStorage) {
@@ -9,32 +9,37 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
-	"time"
 
 	sstorage "github.com/jjo/promql-cli/pkg/storage"
 )
 
 // AISuggestQueries produces PromQL query suggestions for a free-text intent using a selected AI provider.
-// Provider selection via env PROMQL_CLI_AI_PROVIDER: ollama|openai|claude|grok (default: ollama)
+// Provider selection via env PROMQL_CLI_AI_PROVIDER: ollama|openai|openai-compat|azure|claude|grok (default: ollama)
 // Models and endpoints via envs: see per-provider functions below.
 // Global AI configuration (flags override env).
 var (
-	aiProviderFlag       string
-	aiNumAnswersFlag     int
-	aiOpenAIModelFlag    string
-	aiOpenAIBaseFlag     string
-	aiAnthropicModelFlag string
-	aiAnthropicBaseFlag  string
-	aiXAIModelFlag       string
-	aiXAIBaseFlag        string
-	aiOllamaModelFlag    string
-	aiOllamaHostFlag     string
+	aiProviderFlag        string
+	aiNumAnswersFlag      int
+	aiOpenAIModelFlag     string
+	aiOpenAIBaseFlag      string
+	aiAnthropicModelFlag  string
+	aiAnthropicBaseFlag   string
+	aiXAIModelFlag        string
+	aiXAIBaseFlag         string
+	aiOllamaModelFlag     string
+	aiOllamaHostFlag      string
+	aiAzureEndpointFlag   string
+	aiAzureDeploymentFlag string
+	aiAzureAPIVersionFlag string
 )
 
+// defaultAzureAPIVersion is the Azure OpenAI REST API version used unless configured.
+const defaultAzureAPIVersion = "2024-06-01"
+
 func ConfigureAIFromFlags(provider string, openaiModel, openaiBase, claudeModel, claudeBase, xaiModel, xaiBase, ollamaModel, ollamaHost string) {
 	aiProviderFlag = strings.ToLower(strings.TrimSpace(provider))
 	aiOpenAIModelFlag = strings.TrimSpace(openaiModel)
@@ -53,6 +58,27 @@ func AISuggestQueries(storage *sstorage.SimpleStorage, intent string) ([]AISugge
 
 // AISuggestQueriesCtx is like AISuggestQueries but allows cancellation via context.
 func AISuggestQueriesCtx(ctx context.Context, storage *sstorage.SimpleStorage, intent string) ([]AISuggestion, error) {
+	pctx := buildAIPromptContext(storage)
+	text, err := aiCompleteCtx(ctx, buildAIPrompt(pctx, intent))
+	if err != nil {
+		return nil, err
+	}
+	sug := parseAISuggestions(text)
+	if len(sug) == 0 && os.Getenv("PROMQL_CLI_AI_DEBUG") == "true" {
+		fmt.Fprintln(os.Stderr, "AI raw response:")
+		fmt.Fprintln(os.Stderr, text)
+	}
+	return sug, nil
+}
+
+// aiCompleteCtx sends prompt, redacted, to the configured provider and returns its raw text
+// answer, or the cached answer to the same prompt.
+func aiCompleteCtx(ctx context.Context, prompt string) (string, error) {
+	return aiCompleteCached(redactPrompt(prompt), func(prompt string) (string, error) { return aiProviderComplete(ctx, prompt) })
+}
+
+// aiProviderComplete sends prompt to the configured provider.
+func aiProviderComplete(ctx context.Context, prompt string) (string, error) {
 	provider := aiProviderFlag
 	if provider == "" {
 		provider = strings.ToLower(strings.TrimSpace(os.Getenv("PROMQL_CLI_AI_PROVIDER")))
@@ -60,71 +86,28 @@ func AISuggestQueriesCtx(ctx context.Context, storage *sstorage.SimpleStorage, i
 	if provider == "" {
 		provider = "ollama"
 	}
-	pctx := buildAIPromptContext(storage)
-	prompt := buildAIPrompt(pctx, intent)
-
 	switch provider {
 	case "ollama":
 		return aiOllama(ctx, prompt)
 	case "openai":
 		return aiOpenAI(ctx, prompt)
+	case "openai-compat":
+		return aiOpenAICompat(ctx, prompt)
+	case "azure":
+		return aiAzure(ctx, prompt)
 	case "claude":
 		return aiClaude(ctx, prompt)
 	case "grok":
 		return aiGrok(ctx, prompt)
 	default:
-		return nil, fmt.Errorf("unknown AI provider: %s", provider)
+		return "", fmt.Errorf("unknown AI provider: %s", provider)
 	}
 }
 
-type promptContext struct {
-	Metrics []metricInfo
-	NowRFC  string
-	NumAns  int
-}
-
 type AISuggestion struct {
 	Query   string
 	Explain string
 }
-type metricInfo struct {
-	Name   string
-	Help   string
-	Labels []string
-}
-
-func buildAIPromptContext(storage *sstorage.SimpleStorage) promptContext {
-	// desired number of answers
-	num := aiDesiredNum()
-	var metrics []metricInfo
-	for name, samples := range storage.Metrics {
-		// Collect label names (excluding __name__)
-		labelSet := map[string]bool{}
-		for _, s := range samples {
-			for k := range s.Labels {
-				if k != "__name__" {
-					labelSet[k] = true
-				}
-			}
-		}
-		var labels []string
-		for k := range labelSet {
-			labels = append(labels, k)
-		}
-		sort.Strings(labels)
-		help := ""
-		if storage.MetricsHelp != nil {
-			help = storage.MetricsHelp[name]
-		}
-		metrics = append(metrics, metricInfo{Name: name, Help: help, Labels: labels})
-	}
-	// Sort metrics by name and cap to 60 to keep prompt small
-	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
-	if len(metrics) > 60 {
-		metrics = metrics[:60]
-	}
-	return promptContext{Metrics: metrics, NowRFC: time.Now().UTC().Format(time.RFC3339), NumAns: num}
-}
 
 func buildAIPrompt(ctx promptContext, intent string) string {
 	var b strings.Builder
@@ -135,32 +118,8 @@ func buildAIPrompt(ctx promptContext, intent string) string {
 	b.WriteString(" concise answers.\n\n")
 	b.WriteString("Current time: ")
 	b.WriteString(ctx.NowRFC)
-	b.WriteString("\n\nMetrics:\n")
-	for _, m := range ctx.Metrics {
-		b.WriteString("- ")
-		b.WriteString(m.Name)
-		if m.Help != "" {
-			b.WriteString(" (help: ")
-			if len(m.Help) > 120 {
-				b.WriteString(m.Help[:120])
-				b.WriteString("...")
-			} else {
-				b.WriteString(m.Help)
-			}
-			b.WriteString(")")
-		}
-		if len(m.Labels) > 0 {
-			b.WriteString(" labels: {")
-			for i, l := range m.Labels {
-				if i > 0 {
-					b.WriteString(", ")
-				}
-				b.WriteString(l)
-			}
-			b.WriteString("}")
-		}
-		b.WriteString("\n")
-	}
+	b.WriteString("\n\n")
+	writeAIContext(&b, ctx, nil)
 	b.WriteString("\nTask: ")
 	b.WriteString(intent)
 	b.WriteString("\n")
@@ -168,7 +127,7 @@ func buildAIPrompt(ctx promptContext, intent string) string {
 }
 
 // Provider: Ollama (local)
-func aiOllama(ctx context.Context, prompt string) ([]AISuggestion, error) {
+func aiOllama(ctx context.Context, prompt string) (string, error) {
 	host := aiOllamaHostFlag
 	if host == "" {
 		host = os.Getenv("PROMQL_CLI_OLLAMA_HOST")
@@ -189,7 +148,7 @@ func aiOllama(ctx context.Context, prompt string) ([]AISuggestion, error) {
 		"messages": []map[string]string{{"role": "system", "content": "You write PromQL."}, {"role": "user", "content": prompt}},
 		"stream":   false,
 	}
-	return postAndExtractAISuggestions(ctx, url, "", reqBody, func(r io.Reader) (string, error) {
+	return postAIRequest(ctx, url, nil, reqBody, func(r io.Reader) (string, error) {
 		var resp struct {
 			Message struct {
 				Content string `json:"content"`
@@ -203,10 +162,10 @@ func aiOllama(ctx context.Context, prompt string) ([]AISuggestion, error) {
 }
 
 // Provider: OpenAI-compatible
-func aiOpenAI(ctx context.Context, prompt string) ([]AISuggestion, error) {
+func aiOpenAI(ctx context.Context, prompt string) (string, error) {
 	apiKey := os.Getenv("OPENAI_API_KEY")
 	if apiKey == "" {
-		return nil, errors.New("missing OPENAI_API_KEY")
+		return "", errors.New("missing OPENAI_API_KEY")
 	}
 	base := aiOpenAIBaseFlag
 	if base == "" {
@@ -229,29 +188,44 @@ func aiOpenAI(ctx context.Context, prompt string) ([]AISuggestion, error) {
 		"messages":    []map[string]string{{"role": "system", "content": "You write PromQL."}, {"role": "user", "content": prompt}},
 		"temperature": 0.2,
 	}
-	return postAndExtractAISuggestions(ctx, url, head, reqBody, func(r io.Reader) (string, error) {
-		var resp struct {
-			Choices []struct {
-				Message struct {
-					Content string `json:"content"`
-				} `json:"message"`
-			} `json:"choices"`
-		}
-		if err := json.NewDecoder(r).Decode(&resp); err != nil {
-			return "", err
-		}
-		if len(resp.Choices) == 0 {
-			return "", errors.New("no choices")
-		}
-		return resp.Choices[0].Message.Content, nil
-	})
+	return postAIRequest(ctx, url, map[string]string{"Authorization": head}, reqBody, extractChatCompletion)
+}
+
+// Provider: Azure OpenAI. Requests go to a deployment rather than a model:
+// <endpoint>/openai/deployments/<deployment>/chat/completions?api-version=<apiver>
+func aiAzure(ctx context.Context, prompt string) (string, error) {
+	apiKey := os.Getenv("AZURE_OPENAI_API_KEY")
+	if apiKey == "" {
+		return "", errors.New("missing AZURE_OPENAI_API_KEY")
+	}
+	endpoint := firstNonEmpty(aiAzureEndpointFlag, os.Getenv("AZURE_OPENAI_ENDPOINT"))
+	if endpoint == "" {
+		return "", errors.New("missing Azure OpenAI endpoint (--ai 'provider=azure base=https://<resource>.openai.azure.com' or AZURE_OPENAI_ENDPOINT)")
+	}
+	deployment := firstNonEmpty(aiAzureDeploymentFlag, os.Getenv("AZURE_OPENAI_DEPLOYMENT"))
+	if deployment == "" {
+		return "", errors.New("missing Azure OpenAI deployment (--ai 'provider=azure deployment=<name>' or AZURE_OPENAI_DEPLOYMENT)")
+	}
+	apiVersion := firstNonEmpty(aiAzureAPIVersionFlag, os.Getenv("AZURE_OPENAI_API_VERSION"), defaultAzureAPIVersion)
+	reqBody := map[string]any{
+		"messages":    []map[string]string{{"role": "system", "content": "You write PromQL."}, {"role": "user", "content": prompt}},
+		"temperature": 0.2,
+	}
+	return postAIRequest(ctx, azureChatCompletionsURL(endpoint, deployment, apiVersion),
+		map[string]string{"api-key": apiKey}, reqBody, extractChatCompletion)
+}
+
+// azureChatCompletionsURL builds the chat completions URL of an Azure OpenAI deployment.
+func azureChatCompletionsURL(endpoint, deployment, apiVersion string) string {
+	return strings.TrimRight(endpoint, "/") + "/openai/deployments/" + url.PathEscape(deployment) +
+		"/chat/completions?api-version=" + url.QueryEscape(apiVersion)
 }
 
 // Provider: Claude (Anthropic)
-func aiClaude(ctx context.Context, prompt string) ([]AISuggestion, error) {
+func aiClaude(ctx context.Context, prompt string) (string, error) {
 	apiKey := os.Getenv("ANTHROPIC_API_KEY")
 	if apiKey == "" {
-		return nil, errors.New("missing ANTHROPIC_API_KEY")
+		return "", errors.New("missing ANTHROPIC_API_KEY")
 	}
 	base := aiAnthropicBaseFlag
 	if base == "" {
@@ -268,7 +242,6 @@ func aiClaude(ctx context.Context, prompt string) ([]AISuggestion, error) {
 		model = "claude-3-5-sonnet-20240620"
 	}
 	url := strings.TrimRight(base, "/") + "/messages"
-	head := apiKey // special header form used below
 	reqBody := map[string]any{
 		"model":      model,
 		"max_tokens": 800,
@@ -277,14 +250,28 @@ func aiClaude(ctx context.Context, prompt string) ([]AISuggestion, error) {
 			"content": []map[string]string{{"type": "text", "text": prompt}},
 		}},
 	}
-	return postAndExtractAISuggestionsAnthropic(ctx, url, head, reqBody)
+	headers := map[string]string{"x-api-key": apiKey, "anthropic-version": "2023-06-01"}
+	return postAIRequest(ctx, url, headers, reqBody, func(r io.Reader) (string, error) {
+		var resp struct {
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		}
+		if err := json.NewDecoder(r).Decode(&resp); err != nil {
+			return "", err
+		}
+		if len(resp.Content) == 0 {
+			return "", errors.New("no content")
+		}
+		return resp.Content[0].Text, nil
+	})
 }
 
 // Provider: Grok (xAI) — OpenAI-compatible style
-func aiGrok(ctx context.Context, prompt string) ([]AISuggestion, error) {
+func aiGrok(ctx context.Context, prompt string) (string, error) {
 	apiKey := os.Getenv("XAI_API_KEY")
 	if apiKey == "" {
-		return nil, errors.New("missing XAI_API_KEY")
+		return "", errors.New("missing XAI_API_KEY")
 	}
 	base := aiXAIBaseFlag
 	if base == "" {
@@ -307,100 +294,66 @@ func aiGrok(ctx context.Context, prompt string) ([]AISuggestion, error) {
 		"messages":    []map[string]string{{"role": "system", "content": "You write PromQL."}, {"role": "user", "content": prompt}},
 		"temperature": 0.2,
 	}
-	return postAndExtractAISuggestions(ctx, url, head, reqBody, func(r io.Reader) (string, error) {
-		var resp struct {
-			Choices []struct {
-				Message struct {
-					Content string `json:"content"`
-				} `json:"message"`
-			} `json:"choices"`
-		}
-		if err := json.NewDecoder(r).Decode(&resp); err != nil {
-			return "", err
-		}
-		if len(resp.Choices) == 0 {
-			return "", errors.New("no choices")
-		}
-		return resp.Choices[0].Message.Content, nil
-	})
+	return postAIRequest(ctx, url, map[string]string{"Authorization": head}, reqBody, extractChatCompletion)
 }
 
 // Helpers
-func postAndExtractAISuggestions(ctx context.Context, url, bearer string, body any, extract func(io.Reader) (string, error)) ([]AISuggestion, error) {
-	buf := &bytes.Buffer{}
-	if err := json.NewEncoder(buf).Encode(body); err != nil {
-		return nil, err
-	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, buf)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if bearer != "" {
-		req.Header.Set("Authorization", bearer)
-	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		// Check if the error is due to context cancellation
-		if ctx.Err() != nil {
-			return nil, ctx.Err()
-		}
-		return nil, err
-	}
-	defer func() { _ = resp.Body.Close() }()
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("AI HTTP %d: %s", resp.StatusCode, string(b))
+
+// extractChatCompletion returns the first choice of an OpenAI-style chat completions response.
+func extractChatCompletion(r io.Reader) (string, error) {
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
 	}
-	text, err := extract(resp.Body)
-	if err != nil {
-		return nil, err
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return "", err
 	}
-	sug := parseAISuggestions(text)
-	if len(sug) == 0 && os.Getenv("PROMQL_CLI_AI_DEBUG") == "true" {
-		fmt.Fprintln(os.Stderr, "AI raw response:")
-		fmt.Fprintln(os.Stderr, text)
+	if len(resp.Choices) == 0 {
+		return "", errors.New("no choices")
 	}
-	return sug, nil
+	return resp.Choices[0].Message.Content, nil
 }
 
-func postAndExtractAISuggestionsAnthropic(ctx context.Context, url, apiKey string, body any) ([]AISuggestion, error) {
+// postAIRequest posts body as JSON to url and returns the answer text found by extract,
+// recording the tokens the response reports.
+func postAIRequest(ctx context.Context, url string, headers map[string]string, body any, extract func(io.Reader) (string, error)) (string, error) {
 	buf := &bytes.Buffer{}
 	if err := json.NewEncoder(buf).Encode(body); err != nil {
-		return nil, err
+		return "", err
 	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, buf)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", apiKey)
-	req.Header.Set("anthropic-version", "2023-06-01")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		// Check if the error is due to context cancellation
 		if ctx.Err() != nil {
-			return nil, ctx.Err()
+			return "", ctx.Err()
 		}
-		return nil, err
+		return "", err
 	}
 	defer func() { _ = resp.Body.Close() }()
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("AI HTTP %d: %s", resp.StatusCode, string(b))
-	}
-	var ar struct {
-		Content []struct {
-			Text string `json:"text"`
-		} `json:"content"`
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
-		return nil, err
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("AI HTTP %d: %s", resp.StatusCode, string(b))
 	}
-	if len(ar.Content) == 0 {
-		return nil, errors.New("no content")
+	text, err := extract(bytes.NewReader(b))
+	if err == nil {
+		promptTokens, completionTokens := responseTokens(b)
+		recordAIUsage(false, promptTokens, completionTokens)
 	}
-	return parseAISuggestions(ar.Content[0].Text), nil
+	return text, err
 }
 
 // parseAISuggestions tries JSON {answers:[{query,explain}]} first, then {queries:[...]}, then code/lines.
@@ -0,0 +1,131 @@
+package ai
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultAICacheTTL is how long AI answers are reused unless --ai cache= sets it.
+const defaultAICacheTTL = time.Hour
+
+var (
+	// aiCacheTTL is how long answers are served from the cache, 0 to disable it.
+	aiCacheTTL = defaultAICacheTTL
+	// aiOfflineFlag only serves cached answers, never calling the provider.
+	aiOfflineFlag bool
+	// aiCacheDir overrides the cache directory, for tests.
+	aiCacheDir string
+)
+
+// errAIOffline is returned in offline mode for prompts without a cached answer.
+var errAIOffline = errors.New("AI offline mode: no cached answer for this request (run it once online, or drop --ai offline)")
+
+// aiCacheEntry is a cached AI answer, one file per prompt.
+type aiCacheEntry struct {
+	Created  time.Time `json:"created"`
+	Provider string    `json:"provider"`
+	Model    string    `json:"model"`
+	Response string    `json:"response"`
+}
+
+// aiCachePath returns the file caching the answer of the current provider, endpoint and
+// model to prompt, under the user cache directory.
+func aiCachePath(prompt string) (string, error) {
+	dir := aiCacheDir
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(base, "promql-cli", "ai")
+	}
+	cfg := CurrentAIConfig()
+	h := sha256.New()
+	for _, part := range []string{cfg["provider"], cfg["base"], firstNonEmpty(cfg["model"], cfg["deployment"]), cacheablePrompt(prompt)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return filepath.Join(dir, hex.EncodeToString(h.Sum(nil))+".json"), nil
+}
+
+// cacheablePrompt drops the current time line of prompt, which changes every second, so
+// that asking again hits the cache.
+func cacheablePrompt(prompt string) string {
+	var b strings.Builder
+	for line := range strings.Lines(prompt) {
+		if !strings.HasPrefix(line, "Current time: ") {
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
+
+// aiCacheGet returns the cached answer to prompt, if it is younger than aiCacheTTL (any age
+// in offline mode), and its age.
+func aiCacheGet(prompt string) (string, time.Duration, bool) {
+	path, err := aiCachePath(prompt)
+	if err != nil {
+		return "", 0, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", 0, false
+	}
+	var e aiCacheEntry
+	if json.Unmarshal(data, &e) != nil {
+		return "", 0, false
+	}
+	age := time.Since(e.Created)
+	if !aiOfflineFlag && age > aiCacheTTL {
+		_ = os.Remove(path)
+		return "", 0, false
+	}
+	return e.Response, age, true
+}
+
+// aiCachePut caches the answer to prompt.
+func aiCachePut(prompt, response string) error {
+	path, err := aiCachePath(prompt)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	cfg := CurrentAIConfig()
+	data, err := json.Marshal(aiCacheEntry{Created: time.Now().UTC(), Provider: cfg["provider"], Model: firstNonEmpty(cfg["model"], cfg["deployment"]), Response: response})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// aiCompleteCached answers prompt from the cache when it can, else asks the provider
+// through complete and caches its answer. In offline mode it never asks.
+func aiCompleteCached(prompt string, complete func(string) (string, error)) (string, error) {
+	if aiCacheTTL > 0 || aiOfflineFlag {
+		if text, age, ok := aiCacheGet(prompt); ok {
+			fmt.Fprintf(os.Stderr, "(cached AI answer from %s ago)\n", age.Round(time.Second))
+			recordAIUsage(true, 0, 0)
+			return text, nil
+		}
+	}
+	if aiOfflineFlag {
+		return "", errAIOffline
+	}
+	text, err := complete(prompt)
+	if err != nil || aiCacheTTL <= 0 || strings.TrimSpace(text) == "" {
+		return text, err
+	}
+	if cerr := aiCachePut(prompt, text); cerr != nil && os.Getenv("PROMQL_CLI_AI_DEBUG") == "true" {
+		fmt.Fprintf(os.Stderr, "AI cache: %v\n", cerr)
+	}
+	return text, nil
+}
@@ -0,0 +1,112 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Defaults of the openai-compat provider: a local OpenAI-compatible server, such as
+// llama.cpp (llama-server), vLLM or LM Studio.
+const defaultCompatBase = "http://localhost:8080/v1"
+
+var (
+	aiCompatModelFlag string
+	aiCompatBaseFlag  string
+	// aiCompatNoSystem folds the system message into the user one, for chat templates
+	// without a system role (system_role=false, or learnt from a rejected request).
+	aiCompatNoSystem bool
+)
+
+// thinkRe matches the reasoning some local models (DeepSeek-R1, Qwen3) put before their answer.
+var thinkRe = regexp.MustCompile(`(?s)<think>.*?</think>`)
+
+// Provider: any OpenAI-compatible server. The API key is optional, and the model, when
+// not configured, is the first one the server lists.
+func aiOpenAICompat(ctx context.Context, prompt string) (string, error) {
+	base := strings.TrimRight(firstNonEmpty(aiCompatBaseFlag, os.Getenv("PROMQL_CLI_OPENAI_COMPAT_BASE"), defaultCompatBase), "/")
+	headers := map[string]string{}
+	if key := os.Getenv("PROMQL_CLI_OPENAI_COMPAT_API_KEY"); key != "" {
+		headers["Authorization"] = "Bearer " + key
+	}
+	if aiCompatModelFlag == "" {
+		models, err := ListCompatModels(ctx, base, headers["Authorization"])
+		if err != nil {
+			return "", fmt.Errorf("no model configured (--ai 'provider=openai-compat model=<name>') and listing the server models failed: %w", err)
+		}
+		if len(models) == 0 {
+			return "", errors.New("no model configured (--ai 'provider=openai-compat model=<name>') and the server lists none")
+		}
+		aiCompatModelFlag = models[0]
+	}
+	text, err := postAIRequest(ctx, base+"/chat/completions", headers, compatRequest(prompt), extractChatCompletion)
+	if err != nil && !aiCompatNoSystem && isRoleError(err) {
+		// The chat template of the model has no system role: ask again without it.
+		aiCompatNoSystem = true
+		text, err = postAIRequest(ctx, base+"/chat/completions", headers, compatRequest(prompt), extractChatCompletion)
+	}
+	return strings.TrimSpace(thinkRe.ReplaceAllString(text, "")), err
+}
+
+// compatRequest returns the chat completions request of prompt, with the system message
+// folded into the user one when the server doesn't take a system role.
+func compatRequest(prompt string) map[string]any {
+	messages := []map[string]string{{"role": "system", "content": "You write PromQL."}, {"role": "user", "content": prompt}}
+	if aiCompatNoSystem {
+		messages = []map[string]string{{"role": "user", "content": "You write PromQL.\n\n" + prompt}}
+	}
+	return map[string]any{
+		"model":       aiCompatModelFlag,
+		"messages":    messages,
+		"temperature": 0.2,
+		"stream":      false,
+	}
+}
+
+// isRoleError tells whether err is a server rejecting the roles of the messages, as chat
+// templates without a system role do (e.g. "System role not supported", "Conversation
+// roles must alternate user/assistant").
+func isRoleError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.HasPrefix(msg, "ai http ") && strings.Contains(msg, "role")
+}
+
+// ListCompatModels returns the IDs of the models an OpenAI-compatible server at base
+// lists, authenticating with auth unless empty.
+func ListCompatModels(ctx context.Context, base, auth string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(base, "/")+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var r struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, d := range r.Data {
+		if d.ID != "" {
+			ids = append(ids, d.ID)
+		}
+	}
+	return ids, nil
+}
@@ -5,12 +5,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // AIConfig implements flag.Value to parse key=value pairs for --ai.
 // Example: --ai "provider=claude model=opus base=https://... answers=3 profile=work"
+// Local OpenAI-compatible servers: provider=openai-compat base=<url> [model=<name>] [system_role=false].
+// Prompt context keys: context_max=<bytes> label_values=<N> redact=<label regex>.
+// Cache keys: cache=<ttl>|off and offline (offline=true), serving only cached answers.
 // Multiple --ai flags merge; values later override earlier ones.
 type AIConfig map[string]string
 
@@ -43,7 +48,8 @@ func mergeKV(dst map[string]string, s string) {
 		for _, tok := range fieldsRespectQuotes(chunk) {
 			k, v, ok := strings.Cut(tok, "=")
 			if !ok {
-				continue
+				// A bare key, e.g. "offline", enables a switch
+				v = "true"
 			}
 			k = strings.ToLower(strings.TrimSpace(k))
 			v = strings.TrimSpace(v)
@@ -89,13 +95,37 @@ func fieldsRespectQuotes(s string) []string {
 	return out
 }
 
+var (
+	// configProfiles and configProfile are the AI profiles and default profile of the
+	// promql-cli config file (see SetConfigProfiles).
+	configProfiles map[string]map[string]string
+	configProfile  string
+	// lastComposite is the last ConfigureAIComposite input, re-applied by ReconfigureAI.
+	lastComposite map[string]string
+)
+
+// SetConfigProfiles sets the AI profiles defined in the promql-cli config file, and the one
+// used when neither --ai profile= nor PROMQL_CLI_AI_PROFILE select one. They take
+// precedence over the profiles of ai.toml with the same name.
+func SetConfigProfiles(profiles map[string]map[string]string, defaultProfile string) {
+	configProfiles, configProfile = profiles, defaultProfile
+}
+
+// ReconfigureAI re-applies the last ConfigureAIComposite settings, e.g. after the config
+// file profiles changed.
+func ReconfigureAI() {
+	ConfigureAIComposite(lastComposite)
+}
+
 // ConfigureAIComposite merges AI configuration from, in precedence order:
 // 1) Composite --ai key=val pairs (CLI)
 // 2) PROMQL_CLI_AI env (key=val pairs)
-// 3) Profile file (~/.config/promql-cli/ai.toml) selected by --ai profile= or PROMQL_CLI_AI_PROFILE
+// 3) Profile selected by --ai profile=, PROMQL_CLI_AI_PROFILE or the config file, from the
+// config file or ~/.config/promql-cli/ai.toml
 // 4) Provider defaults
 // The result populates global ai*Flag variables used by providers.
 func ConfigureAIComposite(kv map[string]string) {
+	lastComposite = kv
 	cfg := map[string]string{}
 
 	// 2) merge env composite first (lower precedence than CLI)
@@ -110,7 +140,7 @@ func ConfigureAIComposite(kv map[string]string) {
 	// 3) profiles (load and merge selected)
 	profile := cfg["profile"]
 	if profile == "" {
-		profile = os.Getenv("PROMQL_CLI_AI_PROFILE")
+		profile = firstNonEmpty(os.Getenv("PROMQL_CLI_AI_PROFILE"), configProfile)
 	}
 	if profMap := loadAIProfile(profile); len(profMap) > 0 {
 		for k, v := range profMap {
@@ -133,6 +163,33 @@ func ConfigureAIComposite(kv map[string]string) {
 		aiAnthropicModelFlag, aiAnthropicBaseFlag = "", ""
 		aiXAIModelFlag, aiXAIBaseFlag = "", ""
 		aiOllamaModelFlag, aiOllamaHostFlag = "", ""
+		aiCompatModelFlag, aiCompatBaseFlag = "", ""
+		clearAzureFlags()
+	case "openai-compat", "openai_compat":
+		aiProviderFlag = "openai-compat"
+		aiCompatModelFlag = firstNonEmpty(cfg["model"], os.Getenv("PROMQL_CLI_OPENAI_COMPAT_MODEL"))
+		aiCompatBaseFlag = firstNonEmpty(cfg["base"], cfg["host"], os.Getenv("PROMQL_CLI_OPENAI_COMPAT_BASE"), defaultCompatBase)
+		switch strings.ToLower(cfg["system_role"]) {
+		case "false", "0", "off", "no":
+			aiCompatNoSystem = true
+		default:
+			aiCompatNoSystem = false
+		}
+		aiOpenAIModelFlag, aiOpenAIBaseFlag = "", ""
+		aiAnthropicModelFlag, aiAnthropicBaseFlag = "", ""
+		aiXAIModelFlag, aiXAIBaseFlag = "", ""
+		aiOllamaModelFlag, aiOllamaHostFlag = "", ""
+		clearAzureFlags()
+	case "azure", "azure-openai", "azure_openai":
+		aiProviderFlag = "azure"
+		aiAzureEndpointFlag = firstNonEmpty(cfg["base"], cfg["endpoint"], cfg["azure_endpoint"], os.Getenv("AZURE_OPENAI_ENDPOINT"))
+		aiAzureDeploymentFlag = firstNonEmpty(cfg["deployment"], cfg["model"], cfg["azure_deployment"], os.Getenv("AZURE_OPENAI_DEPLOYMENT"))
+		aiAzureAPIVersionFlag = firstNonEmpty(cfg["apiver"], cfg["api_version"], cfg["api-version"], os.Getenv("AZURE_OPENAI_API_VERSION"), defaultAzureAPIVersion)
+		aiOpenAIModelFlag, aiOpenAIBaseFlag = "", ""
+		aiAnthropicModelFlag, aiAnthropicBaseFlag = "", ""
+		aiXAIModelFlag, aiXAIBaseFlag = "", ""
+		aiOllamaModelFlag, aiOllamaHostFlag = "", ""
+		aiCompatModelFlag, aiCompatBaseFlag = "", ""
 	case "claude", "anthropic":
 		aiProviderFlag = "claude"
 		aiAnthropicModelFlag = firstNonEmpty(cfg["model"], cfg["claude_model"], cfg["anthropic_model"], os.Getenv("PROMQL_CLI_ANTHROPIC_MODEL"), "claude-3-5-sonnet-20240620")
@@ -140,6 +197,8 @@ func ConfigureAIComposite(kv map[string]string) {
 		aiOpenAIModelFlag, aiOpenAIBaseFlag = "", ""
 		aiXAIModelFlag, aiXAIBaseFlag = "", ""
 		aiOllamaModelFlag, aiOllamaHostFlag = "", ""
+		aiCompatModelFlag, aiCompatBaseFlag = "", ""
+		clearAzureFlags()
 	case "grok", "xai":
 		aiProviderFlag = "grok"
 		aiXAIModelFlag = firstNonEmpty(cfg["model"], cfg["xai_model"], os.Getenv("PROMQL_CLI_XAI_MODEL"), "grok-2")
@@ -147,6 +206,8 @@ func ConfigureAIComposite(kv map[string]string) {
 		aiOpenAIModelFlag, aiOpenAIBaseFlag = "", ""
 		aiAnthropicModelFlag, aiAnthropicBaseFlag = "", ""
 		aiOllamaModelFlag, aiOllamaHostFlag = "", ""
+		aiCompatModelFlag, aiCompatBaseFlag = "", ""
+		clearAzureFlags()
 	case "ollama":
 		// default provider if unspecified
 		aiProviderFlag = "ollama"
@@ -155,6 +216,8 @@ func ConfigureAIComposite(kv map[string]string) {
 		aiOpenAIModelFlag, aiOpenAIBaseFlag = "", ""
 		aiAnthropicModelFlag, aiAnthropicBaseFlag = "", ""
 		aiXAIModelFlag, aiXAIBaseFlag = "", ""
+		aiCompatModelFlag, aiCompatBaseFlag = "", ""
+		clearAzureFlags()
 	default:
 		// Unknown provider; set as-is but don't crash. Fall back to ollama defaults if fields missing.
 		aiProviderFlag = prov
@@ -166,6 +229,95 @@ func ConfigureAIComposite(kv map[string]string) {
 			aiNumAnswersFlag = n
 		}
 	}
+
+	// prompt context: size cap, label values per label, labels whose values are never sent
+	if v := firstNonEmpty(cfg["context_max"], cfg["ctx_max"]); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			aiContextMaxFlag = n
+		}
+	}
+	if v := cfg["label_values"]; v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			aiLabelValuesFlag = n
+		}
+	}
+	// answer cache: TTL, or off, and offline mode
+	aiCacheTTL = defaultAICacheTTL
+	if v := strings.ToLower(cfg["cache"]); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			aiCacheTTL = d
+		} else if v == "off" || v == "false" || v == "0" {
+			aiCacheTTL = 0
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: invalid AI cache TTL %q (expected a duration like 30m, or off)\n", v)
+		}
+	}
+	aiOfflineFlag = false
+	if v := strings.ToLower(cfg["offline"]); v != "" {
+		aiOfflineFlag = v == "true" || v == "1" || v == "on" || v == "yes"
+	}
+	if v, ok := cfg["redact"]; ok {
+		aiRedactFlag = strings.TrimSpace(v)
+		if _, err := regexp.Compile(aiRedactFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: invalid AI redact pattern %q: %v (all label values will be redacted)\n", aiRedactFlag, err)
+		}
+	}
+}
+
+// CurrentAIConfig returns the active AI settings as keys accepted by ConfigureAIComposite
+// (provider, model, base, deployment, apiver, answers and context settings). API keys are never included; they come from the environment.
+func CurrentAIConfig() map[string]string {
+	cfg := map[string]string{}
+	if aiProviderFlag == "" {
+		return cfg
+	}
+	cfg["provider"] = aiProviderFlag
+	switch aiProviderFlag {
+	case "openai":
+		cfg["model"], cfg["base"] = aiOpenAIModelFlag, aiOpenAIBaseFlag
+	case "openai-compat":
+		cfg["model"], cfg["base"] = aiCompatModelFlag, aiCompatBaseFlag
+		if aiCompatNoSystem {
+			cfg["system_role"] = "false"
+		}
+	case "claude":
+		cfg["model"], cfg["base"] = aiAnthropicModelFlag, aiAnthropicBaseFlag
+	case "azure":
+		cfg["base"], cfg["deployment"], cfg["apiver"] = aiAzureEndpointFlag, aiAzureDeploymentFlag, aiAzureAPIVersionFlag
+	case "grok":
+		cfg["model"], cfg["base"] = aiXAIModelFlag, aiXAIBaseFlag
+	case "ollama":
+		cfg["model"], cfg["base"] = aiOllamaModelFlag, aiOllamaHostFlag
+	}
+	if aiNumAnswersFlag > 0 {
+		cfg["answers"] = strconv.Itoa(aiNumAnswersFlag)
+	}
+	if aiContextMaxFlag > 0 {
+		cfg["context_max"] = strconv.Itoa(aiContextMaxFlag)
+	}
+	if aiLabelValuesFlag >= 0 {
+		cfg["label_values"] = strconv.Itoa(aiLabelValuesFlag)
+	}
+	cfg["redact"] = aiRedactFlag
+	if aiCacheTTL != defaultAICacheTTL {
+		cfg["cache"] = "off"
+		if aiCacheTTL > 0 {
+			cfg["cache"] = aiCacheTTL.String()
+		}
+	}
+	if aiOfflineFlag {
+		cfg["offline"] = "true"
+	}
+	for k, v := range cfg {
+		if v == "" {
+			delete(cfg, k)
+		}
+	}
+	return cfg
+}
+
+func clearAzureFlags() {
+	aiAzureEndpointFlag, aiAzureDeploymentFlag, aiAzureAPIVersionFlag = "", "", ""
 }
 
 func firstNonEmpty(ss ...string) string {
@@ -177,7 +329,8 @@ func firstNonEmpty(ss ...string) string {
 	return ""
 }
 
-// loadAIProfile loads the selected profile from ~/.config/promql-cli/ai.toml.
+// loadAIProfile loads the selected profile from the config file profiles or else from
+// ~/.config/promql-cli/ai.toml.
 // Returns a flat map of keys (provider, model, base, answers, host, etc.).
 // A very small TOML subset is supported.
 func loadAIProfile(profile string) map[string]string {
@@ -185,6 +338,13 @@ func loadAIProfile(profile string) map[string]string {
 		// If no explicit profile, still try default if present
 		profile = "default"
 	}
+	if p, ok := configProfiles[profile]; ok {
+		vals := make(map[string]string, len(p))
+		for k, v := range p {
+			vals[strings.ToLower(k)] = v
+		}
+		return vals
+	}
 	home, err := os.UserHomeDir()
 	if err != nil || home == "" {
 		return nil
@@ -0,0 +1,194 @@
+package ai
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// Defaults for the store schema sent along with AI prompts.
+const (
+	defaultAIContextMax  = 8000 // bytes of metrics/rules context
+	defaultAILabelValues = 5    // most frequent values shown per label
+	aiHelpMaxLen         = 120
+)
+
+// Context settings (see ConfigureAIComposite: context_max, label_values, redact).
+var (
+	aiContextMaxFlag   int
+	aiLabelValuesFlag  = -1 // -1: default, 0: label names only
+	aiRedactFlag       string
+	aiRecordingRuleSet []string
+)
+
+// SetRecordingRuleNames sets the names of the active recording rules, which are listed in
+// AI prompts so that suggestions can reuse them.
+func SetRecordingRuleNames(names []string) {
+	aiRecordingRuleSet = append([]string{}, names...)
+	sort.Strings(aiRecordingRuleSet)
+}
+
+type promptContext struct {
+	Metrics  []metricInfo
+	Rules    []string
+	NowRFC   string
+	NumAns   int
+	MaxBytes int
+}
+
+type metricInfo struct {
+	Name   string
+	Type   string
+	Help   string
+	Labels []string
+	// Values holds the most frequent values of each label, Distinct their total count.
+	// Redacted labels have neither.
+	Values   map[string][]string
+	Distinct map[string]int
+}
+
+func buildAIPromptContext(storage *sstorage.SimpleStorage) promptContext {
+	// desired number of answers
+	num := aiDesiredNum()
+	numValues := aiLabelValuesFlag
+	if numValues < 0 {
+		numValues = defaultAILabelValues
+	}
+	redact := redactLabelRegexp()
+	var metrics []metricInfo
+	for name, samples := range storage.Metrics {
+		// Count label values (excluding __name__) over samples
+		counts := map[string]map[string]int{}
+		for _, s := range samples {
+			for k, v := range s.Labels {
+				if k == "__name__" {
+					continue
+				}
+				if counts[k] == nil {
+					counts[k] = map[string]int{}
+				}
+				counts[k][v]++
+			}
+		}
+		m := metricInfo{Name: name, Type: storage.MetricType(name), Values: map[string][]string{}, Distinct: map[string]int{}}
+		if storage.MetricsHelp != nil {
+			m.Help = storage.MetricsHelp[name]
+		}
+		for k, vals := range counts {
+			m.Labels = append(m.Labels, k)
+			if numValues == 0 || (redact != nil && redact.MatchString(k)) {
+				continue
+			}
+			top := make([]string, 0, len(vals))
+			for v := range vals {
+				top = append(top, v)
+			}
+			sort.Slice(top, func(i, j int) bool {
+				if vals[top[i]] != vals[top[j]] {
+					return vals[top[i]] > vals[top[j]]
+				}
+				return top[i] < top[j]
+			})
+			m.Values[k] = top[:min(numValues, len(top))]
+			m.Distinct[k] = len(top)
+		}
+		sort.Strings(m.Labels)
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	maxBytes := aiContextMaxFlag
+	if maxBytes <= 0 {
+		maxBytes = defaultAIContextMax
+	}
+	return promptContext{
+		Metrics:  metrics,
+		Rules:    append([]string{}, aiRecordingRuleSet...),
+		NowRFC:   time.Now().UTC().Format(time.RFC3339),
+		NumAns:   num,
+		MaxBytes: maxBytes,
+	}
+}
+
+// formatMetricInfo renders one schema line, e.g.
+// "- http_requests_total [counter] (help: ...) labels: {code=200|500, job=api|web|...(7)}".
+func formatMetricInfo(m metricInfo) string {
+	var b strings.Builder
+	b.WriteString("- ")
+	b.WriteString(m.Name)
+	if m.Type != "" && m.Type != "untyped" {
+		b.WriteString(" [")
+		b.WriteString(m.Type)
+		b.WriteString("]")
+	}
+	if m.Help != "" {
+		b.WriteString(" (help: ")
+		if len(m.Help) > aiHelpMaxLen {
+			b.WriteString(m.Help[:aiHelpMaxLen])
+			b.WriteString("...")
+		} else {
+			b.WriteString(m.Help)
+		}
+		b.WriteString(")")
+	}
+	if len(m.Labels) > 0 {
+		b.WriteString(" labels: {")
+		for i, l := range m.Labels {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(l)
+			if vals := m.Values[l]; len(vals) > 0 {
+				b.WriteString("=")
+				b.WriteString(strings.Join(vals, "|"))
+				if n := m.Distinct[l]; n > len(vals) {
+					fmt.Fprintf(&b, "|...(%d)", n)
+				}
+			}
+		}
+		b.WriteString("}")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// writeAIContext writes the store schema and recording rule names, limited to the metrics
+// and rules in only when not nil, and cut to ctx.MaxBytes.
+func writeAIContext(b *strings.Builder, ctx promptContext, only map[string]bool) {
+	var rules strings.Builder
+	for _, r := range ctx.Rules {
+		if only == nil || only[r] {
+			rules.WriteString("- " + r + "\n")
+		}
+	}
+	if rules.Len() > 0 {
+		rules.WriteString("(recording rules precompute these series; prefer them when they fit)\n")
+		rules.WriteString("\n")
+		b.WriteString("Recording rules:\n")
+		b.WriteString(rules.String())
+	}
+	budget := ctx.MaxBytes - rules.Len()
+	b.WriteString("Metrics:\n")
+	var metrics []metricInfo
+	for _, m := range ctx.Metrics {
+		if only == nil || only[m.Name] {
+			metrics = append(metrics, m)
+		}
+	}
+	for i, m := range metrics {
+		line := formatMetricInfo(m)
+		if len(line) > budget {
+			fmt.Fprintf(b, "- ... %d more metrics omitted (context size limit)\n", len(metrics)-i)
+			return
+		}
+		budget -= len(line)
+		b.WriteString(line)
+	}
+}
+
+// AIPromptPreview returns the exact prompt that .ai <intent> sends for storage.
+func AIPromptPreview(storage *sstorage.SimpleStorage, intent string) string {
+	return redactPrompt(buildAIPrompt(buildAIPromptContext(storage), intent))
+}
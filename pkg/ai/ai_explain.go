@@ -0,0 +1,114 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// AIExplanation is a structured natural-language explanation of a PromQL query.
+type AIExplanation struct {
+	Purpose        string   // what the query computes, in plain words
+	AggregatesAway []string // labels dropped by aggregations, each with a short note
+	Pitfalls       []string // common mistakes or surprises with this query
+	Result         string   // interpretation of the result, when a result summary was given
+}
+
+// AIExplainQueryCtx asks the configured provider to explain expr. resultSummary, when not
+// empty, describes the result of the last evaluation and is used to interpret it.
+func AIExplainQueryCtx(ctx context.Context, storage *sstorage.SimpleStorage, expr, resultSummary string) (AIExplanation, error) {
+	pctx := buildAIPromptContext(storage)
+	text, err := aiCompleteCtx(ctx, buildAIExplainPrompt(pctx, expr, resultSummary))
+	if err != nil {
+		return AIExplanation{}, err
+	}
+	ex := parseAIExplanation(text)
+	if ex.Purpose == "" && os.Getenv("PROMQL_CLI_AI_DEBUG") == "true" {
+		fmt.Fprintln(os.Stderr, "AI raw response:")
+		fmt.Fprintln(os.Stderr, text)
+	}
+	return ex, nil
+}
+
+func buildAIExplainPrompt(ctx promptContext, expr, resultSummary string) string {
+	var b strings.Builder
+	b.WriteString("You are an expert in monitoring and observability who explains PromQL queries to other engineers.\n")
+	b.WriteString("Explain the query below. Output JSON as {\"purpose\":\"what it computes, 1-3 sentences\",")
+	b.WriteString("\"aggregates_away\":[\"label: why it is dropped\", ...],\"pitfalls\":[\"one short sentence\", ...]")
+	if resultSummary != "" {
+		b.WriteString(",\"result\":\"what the result below means, 1-2 sentences\"")
+	}
+	b.WriteString("}.\n")
+	b.WriteString("aggregates_away lists the labels removed by aggregations (sum, by/without, on/ignoring), empty if none.\n\n")
+	b.WriteString("Current time: ")
+	b.WriteString(ctx.NowRFC)
+	b.WriteString("\n\nQuery:\n")
+	b.WriteString(expr)
+	b.WriteString("\n")
+	// Only describe the metrics the query refers to.
+	b.WriteString("\n")
+	writeAIContext(&b, ctx, exprTokens(expr))
+	if resultSummary != "" {
+		b.WriteString("\nLast result:\n")
+		b.WriteString(resultSummary)
+		if !strings.HasSuffix(resultSummary, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// parseAIExplanation reads the JSON explanation, possibly wrapped in prose or a fenced code
+// block. Anything else is kept verbatim as the purpose.
+func parseAIExplanation(s string) AIExplanation {
+	s = strings.TrimSpace(s)
+	var ex struct {
+		Purpose        string   `json:"purpose"`
+		AggregatesAway []string `json:"aggregates_away"`
+		Pitfalls       []string `json:"pitfalls"`
+		Result         string   `json:"result"`
+	}
+	if l := strings.IndexByte(s, '{'); l != -1 {
+		if r := strings.LastIndexByte(s, '}'); r > l && json.Unmarshal([]byte(s[l:r+1]), &ex) == nil && ex.Purpose != "" {
+			return AIExplanation{
+				Purpose:        strings.TrimSpace(ex.Purpose),
+				AggregatesAway: trimNonEmpty(ex.AggregatesAway),
+				Pitfalls:       trimNonEmpty(ex.Pitfalls),
+				Result:         strings.TrimSpace(ex.Result),
+			}
+		}
+	}
+	if strings.HasPrefix(s, "```") {
+		if idx := strings.Index(s, "\n"); idx != -1 {
+			s = s[idx+1:]
+		}
+		s = strings.TrimSpace(strings.TrimSuffix(s, "```"))
+	}
+	return AIExplanation{Purpose: s}
+}
+
+// exprTokens returns the identifiers of expr, among them the metric names it refers to.
+func exprTokens(expr string) map[string]bool {
+	tokens := map[string]bool{}
+	for _, tok := range strings.FieldsFunc(expr, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != ':'
+	}) {
+		tokens[tok] = true
+	}
+	return tokens
+}
+
+func trimNonEmpty(ss []string) []string {
+	var out []string
+	for _, s := range ss {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
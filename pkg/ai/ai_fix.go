@@ -0,0 +1,74 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// AIFixQueryCtx asks the configured provider to correct expr, which failed with errText,
+// and returns the corrected queries as suggestions.
+func AIFixQueryCtx(ctx context.Context, storage *sstorage.SimpleStorage, expr, errText string) ([]AISuggestion, error) {
+	pctx := buildAIPromptContext(storage)
+	text, err := aiCompleteCtx(ctx, buildAIFixPrompt(pctx, expr, errText))
+	if err != nil {
+		return nil, err
+	}
+	sug := parseAISuggestions(text)
+	if len(sug) == 0 && os.Getenv("PROMQL_CLI_AI_DEBUG") == "true" {
+		fmt.Fprintln(os.Stderr, "AI raw response:")
+		fmt.Fprintln(os.Stderr, text)
+	}
+	return sug, nil
+}
+
+func buildAIFixPrompt(ctx promptContext, expr, errText string) string {
+	var b strings.Builder
+	b.WriteString("You are an expert in monitoring and observability who fixes broken PromQL queries.\n")
+	b.WriteString("The query below fails with the error shown. Correct it, keeping its intent, using only the listed metrics and their labels.\n")
+	b.WriteString("Output JSON as {\"answers\":[{\"query\":\"...\",\"explain\":\"what was wrong, one short sentence\"}, ...]}. Return up to ")
+	fmt.Fprintf(&b, "%d", ctx.NumAns)
+	b.WriteString(" corrected queries.\n\n")
+	b.WriteString("Current time: ")
+	b.WriteString(ctx.NowRFC)
+	b.WriteString("\n\nQuery:\n")
+	b.WriteString(expr)
+	b.WriteString("\n\nError:\n")
+	b.WriteString(strings.TrimSpace(errText))
+	b.WriteString("\n\n")
+	writeAIContext(&b, ctx, fixRelevantMetrics(ctx, expr))
+	return b.String()
+}
+
+// fixRelevantMetrics returns the metrics and rules expr refers to, and for names it refers to
+// that aren't in the store, e.g. misspelled ones, those sharing their first word.
+func fixRelevantMetrics(ctx promptContext, expr string) map[string]bool {
+	used := exprTokens(expr)
+	known := map[string]bool{}
+	for _, m := range ctx.Metrics {
+		known[m.Name] = true
+	}
+	for _, r := range ctx.Rules {
+		known[r] = true
+	}
+	relevant := map[string]bool{}
+	for tok := range used {
+		if known[tok] {
+			relevant[tok] = true
+			continue
+		}
+		if !strings.ContainsAny(tok, "_:") {
+			continue
+		}
+		word, _, _ := strings.Cut(tok, "_")
+		for name := range known {
+			if strings.HasPrefix(name, word+"_") || strings.HasPrefix(name, word+":") {
+				relevant[name] = true
+			}
+		}
+	}
+	return relevant
+}
@@ -0,0 +1,158 @@
+package ai
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// aiRedacted replaces redacted text in prompts.
+const aiRedacted = "<redacted>"
+
+// redactLabelPrefix marks a redaction pattern matching label names rather than text.
+const redactLabelPrefix = "label:"
+
+// Redaction rules applied to every prompt before it is cached or sent: text patterns mask
+// what they match anywhere (metric names, label values, query text), label patterns the
+// values of the labels whose names they match. The redact key of ConfigureAIComposite adds
+// a label pattern of its own.
+var (
+	redactMu     sync.Mutex
+	redactRules  []string // as given, label patterns with their prefix
+	redactText   []*regexp.Regexp
+	redactLabels []string
+)
+
+// SetRedactPatterns replaces the redaction patterns: regexes of text to mask, or
+// "label:<regex>" for the values of the labels whose names match.
+func SetRedactPatterns(patterns []string) error {
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	redactRules, redactText, redactLabels = nil, nil, nil
+	for _, p := range patterns {
+		if err := addRedactPattern(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddRedactPattern adds a redaction pattern, see SetRedactPatterns.
+func AddRedactPattern(pattern string) error {
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	for _, p := range redactRules {
+		if p == pattern {
+			return nil
+		}
+	}
+	return addRedactPattern(pattern)
+}
+
+func addRedactPattern(pattern string) error {
+	label, re, err := parseRedactPattern(pattern)
+	if err != nil {
+		return err
+	}
+	if re != nil {
+		redactText = append(redactText, re)
+	} else {
+		redactLabels = append(redactLabels, label)
+	}
+	redactRules = append(redactRules, pattern)
+	return nil
+}
+
+// CheckRedactPattern reports whether pattern is a valid redaction pattern.
+func CheckRedactPattern(pattern string) error {
+	_, _, err := parseRedactPattern(pattern)
+	return err
+}
+
+// parseRedactPattern returns the label name regex of a label pattern, or the compiled
+// regex of a text one.
+func parseRedactPattern(pattern string) (string, *regexp.Regexp, error) {
+	if label, ok := strings.CutPrefix(pattern, redactLabelPrefix); ok {
+		if label == "" {
+			return "", nil, fmt.Errorf("empty label name pattern")
+		}
+		if _, err := regexp.Compile("^(?:" + label + ")$"); err != nil {
+			return "", nil, fmt.Errorf("invalid label name pattern %q: %w", label, err)
+		}
+		return label, nil, nil
+	}
+	if pattern == "" {
+		return "", nil, fmt.Errorf("empty pattern")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return "", re, nil
+}
+
+// RemoveRedactPattern removes a redaction pattern, telling whether it was set.
+func RemoveRedactPattern(pattern string) bool {
+	redactMu.Lock()
+	rules := redactRules
+	redactMu.Unlock()
+	for i, p := range rules {
+		if p == pattern {
+			_ = SetRedactPatterns(append(append([]string{}, rules[:i]...), rules[i+1:]...))
+			return true
+		}
+	}
+	return false
+}
+
+// RedactPatterns returns the redaction patterns, in the order they were added.
+func RedactPatterns() []string {
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	return append([]string{}, redactRules...)
+}
+
+// redactLabelRegexp returns the regex matching the names of the labels whose values are
+// redacted, or nil when there are none.
+func redactLabelRegexp() *regexp.Regexp {
+	redactMu.Lock()
+	alts := append([]string{}, redactLabels...)
+	redactMu.Unlock()
+	if aiRedactFlag != "" {
+		if _, err := regexp.Compile(aiRedactFlag); err != nil {
+			// ConfigureAIComposite only warns about an invalid pattern and keeps it: fail
+			// closed and redact every label value.
+			return regexp.MustCompile(".*")
+		}
+		alts = append(alts, aiRedactFlag)
+	}
+	if len(alts) == 0 {
+		return nil
+	}
+	return regexp.MustCompile("^(?:(?:" + strings.Join(alts, ")|(?:") + "))$")
+}
+
+// labelPairRe matches label matchers and pairs in query text and results, e.g.
+// customer="acme" or customer=~"ac.*".
+var labelPairRe = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)(\s*(?:=~|!~|!=|=)\s*)"((?:[^"\\]|\\.)*)"`)
+
+// redactPrompt masks in prompt the values of redacted labels and the text matching the
+// redaction patterns.
+func redactPrompt(prompt string) string {
+	if labels := redactLabelRegexp(); labels != nil {
+		prompt = labelPairRe.ReplaceAllStringFunc(prompt, func(pair string) string {
+			m := labelPairRe.FindStringSubmatch(pair)
+			if !labels.MatchString(m[1]) {
+				return pair
+			}
+			return m[1] + m[2] + `"` + aiRedacted + `"`
+		})
+	}
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	for _, re := range redactText {
+		prompt = re.ReplaceAllLiteralString(prompt, aiRedacted)
+	}
+	return prompt
+}
@@ -1,6 +1,13 @@
 package ai
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"strings"
@@ -25,8 +32,8 @@ func TestFieldsRespectQuotes(t *testing.T) {
 
 func TestMergeKV(t *testing.T) {
 	dst := map[string]string{}
-	mergeKV(dst, "provider=openai, model=gpt-4o-mini answers=2")
-	if dst["provider"] != "openai" || dst["model"] != "gpt-4o-mini" || dst["answers"] != "2" {
+	mergeKV(dst, "provider=openai, model=gpt-4o-mini answers=2 offline")
+	if dst["provider"] != "openai" || dst["model"] != "gpt-4o-mini" || dst["answers"] != "2" || dst["offline"] != "true" {
 		t.Fatalf("mergeKV failed, got=%v", dst)
 	}
 }
@@ -69,6 +76,66 @@ func TestConfigureAIComposite_OpenAI(t *testing.T) {
 	}
 }
 
+func TestConfigureAIComposite_ConfigProfiles(t *testing.T) {
+	t.Setenv("PROMQL_CLI_AI", "")
+	t.Setenv("PROMQL_CLI_AI_PROFILE", "")
+	t.Setenv("HOME", t.TempDir())
+	defer SetConfigProfiles(nil, "")
+	SetConfigProfiles(map[string]map[string]string{
+		"local": {"provider": "ollama", "model": "qwen2.5"},
+		"work":  {"Provider": "claude", "model": "opus"},
+	}, "local")
+
+	ConfigureAIComposite(nil)
+	if aiProviderFlag != "ollama" || aiOllamaModelFlag != "qwen2.5" {
+		t.Fatalf("default config profile: got provider=%s model=%s", aiProviderFlag, aiOllamaModelFlag)
+	}
+	ConfigureAIComposite(map[string]string{"profile": "work", "model": "haiku"})
+	if aiProviderFlag != "claude" || aiAnthropicModelFlag != "haiku" {
+		t.Fatalf("--ai overrides profile: got provider=%s model=%s", aiProviderFlag, aiAnthropicModelFlag)
+	}
+	SetConfigProfiles(map[string]map[string]string{"work": {"provider": "openai"}}, "")
+	ReconfigureAI()
+	if aiProviderFlag != "openai" || aiOpenAIModelFlag != "haiku" {
+		t.Fatalf("after ReconfigureAI: got provider=%s model=%s", aiProviderFlag, aiOpenAIModelFlag)
+	}
+}
+
+func TestAzureOpenAI(t *testing.T) {
+	var gotPath, gotAPIVersion, gotKey string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAPIVersion, gotKey = r.URL.Path, r.URL.Query().Get("api-version"), r.Header.Get("api-key")
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"{\"answers\":[{\"query\":\"up\",\"explain\":\"targets\"}]}"}}]}`))
+	}))
+	defer srv.Close()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("PROMQL_CLI_AI", "")
+	t.Setenv("AZURE_OPENAI_API_KEY", "secret")
+	defer ConfigureAIComposite(map[string]string{"provider": "ollama"})
+
+	ConfigureAIComposite(map[string]string{"provider": "azure", "base": srv.URL + "/", "deployment": "gpt4o", "apiver": "2024-06-01"})
+	sugs, err := AISuggestQueriesCtx(context.Background(), sstorage.NewSimpleStorage(), "targets")
+	if err != nil {
+		t.Fatalf("AISuggestQueriesCtx: %v", err)
+	}
+	if gotPath != "/openai/deployments/gpt4o/chat/completions" || gotAPIVersion != "2024-06-01" || gotKey != "secret" {
+		t.Fatalf("unexpected request: path=%q api-version=%q api-key=%q", gotPath, gotAPIVersion, gotKey)
+	}
+	if len(sugs) != 1 || sugs[0].Query != "up" {
+		t.Fatalf("unexpected suggestions: %+v", sugs)
+	}
+	got := CurrentAIConfig()
+	if got["provider"] != "azure" || got["base"] != srv.URL+"/" || got["deployment"] != "gpt4o" || got["apiver"] != "2024-06-01" {
+		t.Fatalf("CurrentAIConfig = %v", got)
+	}
+
+	t.Setenv("AZURE_OPENAI_API_KEY", "")
+	if _, err := aiCompleteCtx(context.Background(), "prompt"); err == nil || !strings.Contains(err.Error(), "AZURE_OPENAI_API_KEY") {
+		t.Fatalf("expected missing key error, got %v", err)
+	}
+}
+
 func TestBuildAIPromptContextAndPrompt(t *testing.T) {
 	st := sstorage.NewSimpleStorage()
 	// Create two series for metric http_requests_total with labels method/code
@@ -142,3 +209,286 @@ func TestCleanCandidate(t *testing.T) {
 		}
 	}
 }
+
+func TestParseAIExplanation(t *testing.T) {
+	fenced := "```json\n{\"purpose\":\"per-job request rate\",\"aggregates_away\":[\"instance: summed\",\" \"],\"pitfalls\":[\"counter resets\"]}\n```"
+	ex := parseAIExplanation(fenced)
+	if ex.Purpose != "per-job request rate" || !reflect.DeepEqual(ex.AggregatesAway, []string{"instance: summed"}) || len(ex.Pitfalls) != 1 {
+		t.Fatalf("parseAIExplanation fenced failed: %+v", ex)
+	}
+	ex = parseAIExplanation("It counts targets that are up.")
+	if ex.Purpose != "It counts targets that are up." || ex.AggregatesAway != nil {
+		t.Fatalf("parseAIExplanation prose failed: %+v", ex)
+	}
+}
+
+func TestBuildAIExplainPrompt(t *testing.T) {
+	st := sstorage.NewSimpleStorage()
+	st.AddSample(map[string]string{"__name__": "http_requests_total", "job": "api", "instance": "a"}, 1, 0)
+	st.AddSample(map[string]string{"__name__": "node_load1", "instance": "a"}, 1, 0)
+	p := buildAIExplainPrompt(buildAIPromptContext(st), "sum by (job) (rate(http_requests_total[5m]))", "vector result:\n{job=\"api\"} => 3\n")
+	if !strings.Contains(p, "- http_requests_total labels: {instance=a, job=api}") || strings.Contains(p, "node_load1") {
+		t.Fatalf("expected only the referenced metric in prompt:\n%s", p)
+	}
+	if !strings.Contains(p, "Last result:\nvector result:") || !strings.Contains(p, `"result"`) {
+		t.Fatalf("expected result summary in prompt:\n%s", p)
+	}
+}
+
+func TestBuildAIFixPrompt(t *testing.T) {
+	st := sstorage.NewSimpleStorage()
+	st.AddSample(map[string]string{"__name__": "http_requests_total", "job": "api"}, 1, 0)
+	st.AddSample(map[string]string{"__name__": "http_request_duration_seconds_sum", "job": "api"}, 1, 0)
+	st.AddSample(map[string]string{"__name__": "node_load1", "instance": "a"}, 1, 0)
+	p := buildAIFixPrompt(buildAIPromptContext(st), "sum(rate(http_request_total[5m])", "1:33: parse error: unclosed left parenthesis")
+	for _, want := range []string{"Query:\nsum(rate(http_request_total[5m])\n", "Error:\n1:33: parse error: unclosed left parenthesis\n", "- http_requests_total", "- http_request_duration_seconds_sum"} {
+		if !strings.Contains(p, want) {
+			t.Errorf("expected %q in prompt:\n%s", want, p)
+		}
+	}
+	if strings.Contains(p, "node_load1") {
+		t.Errorf("expected only the metrics related to the query in prompt:\n%s", p)
+	}
+}
+
+func TestAIPromptContextSchema(t *testing.T) {
+	defer func() {
+		aiContextMaxFlag, aiLabelValuesFlag, aiRedactFlag = 0, -1, ""
+		SetRecordingRuleNames(nil)
+	}()
+	st := sstorage.NewSimpleStorage()
+	content := "# HELP http_requests_total HTTP requests\n# TYPE http_requests_total counter\n" +
+		"http_requests_total{code=\"200\",user=\"alice\"} 1\n" +
+		"http_requests_total{code=\"200\",user=\"bob\"} 1\n" +
+		"http_requests_total{code=\"500\",user=\"bob\"} 1\n" +
+		"# TYPE node_load1 gauge\nnode_load1 0.5\n"
+	if err := st.LoadFromReader(strings.NewReader(content)); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	SetRecordingRuleNames([]string{"job:http_requests:rate5m"})
+
+	aiLabelValuesFlag = 1
+	p := AIPromptPreview(st, "errors")
+	for _, want := range []string{
+		"Recording rules:\n- job:http_requests:rate5m\n",
+		"- http_requests_total [counter] (help: HTTP requests) labels: {code=200|...(2), user=bob|...(2)}\n",
+		"- node_load1 [gauge]\n",
+		"Task: errors\n",
+	} {
+		if !strings.Contains(p, want) {
+			t.Fatalf("prompt missing %q:\n%s", want, p)
+		}
+	}
+
+	ConfigureAIComposite(map[string]string{"provider": "ollama", "redact": "user", "label_values": "5"})
+	p = AIPromptPreview(st, "errors")
+	if !strings.Contains(p, "labels: {code=200|500, user}") || strings.Contains(p, "alice") {
+		t.Fatalf("expected user values to be redacted:\n%s", p)
+	}
+
+	aiRedactFlag = "user("
+	if got := redactPrompt(`up{code="500"}`); got != `up{code="<redacted>"}` {
+		t.Fatalf("expected an invalid redact pattern to redact every label value, got %s", got)
+	}
+	aiRedactFlag = "user"
+
+	aiContextMaxFlag = 150
+	p = AIPromptPreview(st, "errors")
+	if !strings.Contains(p, "- ... 2 more metrics omitted (context size limit)") {
+		t.Fatalf("expected metrics to be cut at the size limit:\n%s", p)
+	}
+}
+
+func TestAICache(t *testing.T) {
+	requests := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{"message":{"content":"{\"answers\":[{\"query\":\"up\",\"explain\":\"targets\"}]}"}}`))
+	})
+	srv, other := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer srv.Close()
+	defer other.Close()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PROMQL_CLI_AI", "")
+	aiCacheDir = t.TempDir()
+	defer func() { aiCacheDir = "" }()
+	defer ConfigureAIComposite(map[string]string{"provider": "ollama"})
+	st := sstorage.NewSimpleStorage()
+	ask := func(intent string) ([]AISuggestion, error) {
+		return AISuggestQueriesCtx(context.Background(), st, intent)
+	}
+
+	ConfigureAIComposite(map[string]string{"provider": "ollama", "host": srv.URL})
+	for range 2 {
+		if sugs, err := ask("targets"); err != nil || len(sugs) != 1 || sugs[0].Query != "up" {
+			t.Fatalf("unexpected answer: %+v %v", sugs, err)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("expected the second ask served from the cache, got %d requests", requests)
+	}
+
+	ConfigureAIComposite(map[string]string{"provider": "ollama", "host": srv.URL, "offline": "true"})
+	if sugs, err := ask("targets"); err != nil || len(sugs) != 1 {
+		t.Fatalf("expected the cached answer offline, got %+v %v", sugs, err)
+	}
+	if _, err := ask("something else"); !errors.Is(err, errAIOffline) || requests != 1 {
+		t.Fatalf("expected an offline error without requests, got %v (%d requests)", err, requests)
+	}
+	if got := CurrentAIConfig(); got["offline"] != "true" {
+		t.Fatalf("expected offline in the current config, got %v", got)
+	}
+
+	ConfigureAIComposite(map[string]string{"provider": "ollama", "host": srv.URL, "model": "other"})
+	if _, err := ask("targets"); err != nil || requests != 2 {
+		t.Fatalf("expected another model to miss the cache, got %v (%d requests)", err, requests)
+	}
+	ConfigureAIComposite(map[string]string{"provider": "ollama", "host": other.URL, "model": "other"})
+	if _, err := ask("targets"); err != nil || requests != 3 {
+		t.Fatalf("expected another server to miss the cache, got %v (%d requests)", err, requests)
+	}
+	ConfigureAIComposite(map[string]string{"provider": "ollama", "host": srv.URL, "cache": "off"})
+	_, _ = ask("targets")
+	_, _ = ask("targets")
+	if requests != 5 || CurrentAIConfig()["cache"] != "off" {
+		t.Fatalf("expected no caching with cache=off, got %d requests", requests)
+	}
+}
+
+func TestAIUsage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"message":{"content":"up"},"prompt_eval_count":1000,"eval_count":200}`))
+	}))
+	defer srv.Close()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PROMQL_CLI_AI", "")
+	aiCacheDir = t.TempDir()
+	defer func() { aiCacheDir = "" }()
+	defer ConfigureAIComposite(map[string]string{"provider": "ollama"})
+	defer SetModelPricing(nil)
+	defer ResetAIUsage()
+
+	ResetAIUsage()
+	SetModelPricing(map[string]ModelPrice{"llama3.1": {Input: 3, Output: 15}})
+	ConfigureAIComposite(map[string]string{"provider": "ollama", "host": srv.URL})
+	for range 2 {
+		if _, err := aiCompleteCtx(context.Background(), "prompt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if u, ok := LastAIUsage(); !ok || FormatAIUsage(u) != "answered from the cache, no tokens (ollama llama3.1), ~$0.0000" {
+		t.Fatalf("expected the cached answer last, got %q", FormatAIUsage(u))
+	}
+	usage := SessionAIUsage()
+	if len(usage) != 1 || usage[0].Calls != 2 || usage[0].Cached != 1 || usage[0].PromptTokens != 1000 || usage[0].CompletionTokens != 200 {
+		t.Fatalf("unexpected session usage: %+v", usage)
+	}
+	if got := FormatAIUsage(usage[0]); got != "1000 prompt + 200 completion tokens (ollama llama3.1), ~$0.0060" {
+		t.Fatalf("unexpected usage line: %q", got)
+	}
+
+	for body, want := range map[string][2]int{
+		`{"usage":{"prompt_tokens":12,"completion_tokens":3}}`: {12, 3},
+		`{"usage":{"input_tokens":7,"output_tokens":2}}`:       {7, 2},
+		`{"choices":[]}`: {0, 0},
+	} {
+		if p, c := responseTokens([]byte(body)); p != want[0] || c != want[1] {
+			t.Errorf("%s: got %d/%d, want %v", body, p, c, want)
+		}
+	}
+}
+
+func TestAIRedact(t *testing.T) {
+	var sent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sent = strings.NewReplacer(`\"`, `"`, `\u003c`, "<", `\u003e`, ">").Replace(string(body))
+		_, _ = w.Write([]byte(`{"message":{"content":"up"}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PROMQL_CLI_AI", "")
+	aiCacheDir = t.TempDir()
+	defer func() { aiCacheDir = "" }()
+	defer ConfigureAIComposite(map[string]string{"provider": "ollama"})
+	defer func() { _ = SetRedactPatterns(nil) }()
+
+	ConfigureAIComposite(map[string]string{"provider": "ollama", "host": srv.URL, "redact": "email"})
+	if err := SetRedactPatterns([]string{`cust-[0-9]+`, "label:tenant"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddRedactPattern("label:("); err == nil {
+		t.Fatalf("expected an invalid label pattern rejected")
+	}
+	query := `sum(rate(orders_total{tenant="acme", email=~".*@acme.com", code="500", id="cust-42"}[5m]))`
+	if _, err := aiCompleteCtx(context.Background(), "Explain: "+query); err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{"acme", "cust-42"} {
+		if strings.Contains(sent, leak) {
+			t.Fatalf("%q sent to the provider: %s", leak, sent)
+		}
+	}
+	if !strings.Contains(sent, `tenant="<redacted>"`) || !strings.Contains(sent, `email=~"<redacted>"`) || !strings.Contains(sent, `code="500"`) || !strings.Contains(sent, `id="<redacted>"`) {
+		t.Fatalf("unexpected redaction: %s", sent)
+	}
+
+	if !RemoveRedactPattern("label:tenant") || RemoveRedactPattern("label:tenant") {
+		t.Fatalf("expected the pattern removed once")
+	}
+	if got := RedactPatterns(); len(got) != 1 || got[0] != `cust-[0-9]+` {
+		t.Fatalf("unexpected patterns: %q", got)
+	}
+}
+
+func TestAIOpenAICompat(t *testing.T) {
+	var requests []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("unexpected Authorization header without an API key")
+		}
+		switch r.URL.Path {
+		case "/v1/models":
+			_, _ = w.Write([]byte(`{"data":[{"id":"qwen3-8b"},{"id":"other"}]}`))
+		case "/v1/chat/completions":
+			var req map[string]any
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			requests = append(requests, req)
+			if strings.Contains(fmt.Sprint(req["messages"]), "role:system") {
+				http.Error(w, `{"error":"System role not supported"}`, http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"<think>counters need rate</think>\nsum(rate(x[5m]))"}}],"usage":{"prompt_tokens":10,"completion_tokens":5}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PROMQL_CLI_AI", "")
+	t.Setenv("PROMQL_CLI_OPENAI_COMPAT_API_KEY", "")
+	aiCacheDir = t.TempDir()
+	defer func() { aiCacheDir = "" }()
+	defer ConfigureAIComposite(map[string]string{"provider": "ollama"})
+
+	ConfigureAIComposite(map[string]string{"provider": "openai-compat", "base": srv.URL + "/v1"})
+	text, err := aiCompleteCtx(context.Background(), "rate of x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "sum(rate(x[5m]))" {
+		t.Fatalf("expected the answer without its reasoning, got %q", text)
+	}
+	if len(requests) != 2 || requests[1]["model"] != "qwen3-8b" || !strings.Contains(fmt.Sprint(requests[1]["messages"]), "You write PromQL.\n\nrate of x") {
+		t.Fatalf("expected a retry without the system role using the listed model, got: %v", requests)
+	}
+	if cfg := CurrentAIConfig(); cfg["model"] != "qwen3-8b" || cfg["system_role"] != "false" {
+		t.Fatalf("unexpected config: %v", cfg)
+	}
+
+	requests = nil
+	ConfigureAIComposite(map[string]string{"provider": "openai-compat", "base": srv.URL + "/v1", "model": "other", "system_role": "false", "cache": "off"})
+	if _, err := aiCompleteCtx(context.Background(), "rate of x"); err != nil || len(requests) != 1 || requests[0]["model"] != "other" {
+		t.Fatalf("expected a single request to the configured model, got %v: %v", err, requests)
+	}
+}
@@ -0,0 +1,150 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ModelPrice is the price of a model in USD per million tokens, for cost estimates.
+type ModelPrice struct {
+	Input  float64 `yaml:"input" json:"input"`
+	Output float64 `yaml:"output" json:"output"`
+}
+
+// AIUsage is the token usage of AI requests: of one request, or the sum of a session's
+// requests to a provider and model.
+type AIUsage struct {
+	Provider         string
+	Model            string
+	Calls            int // requests answered, by the provider or the cache
+	Cached           int // requests answered from the cache, which cost nothing
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64 // estimated, in USD
+	Priced           bool    // a price is configured for the model
+}
+
+var (
+	usageMu    sync.Mutex
+	modelPrice map[string]ModelPrice
+	lastUsage  *AIUsage
+	totalUsage = map[string]*AIUsage{} // by provider and model
+)
+
+// SetModelPricing sets the prices of models, by model name (or deployment, for Azure).
+func SetModelPricing(prices map[string]ModelPrice) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	modelPrice = prices
+}
+
+// LastAIUsage returns the usage of the last AI request, if any was made.
+func LastAIUsage() (AIUsage, bool) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	if lastUsage == nil {
+		return AIUsage{}, false
+	}
+	return *lastUsage, true
+}
+
+// SessionAIUsage returns the usage of the AI requests made so far, by provider and model.
+func SessionAIUsage() []AIUsage {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	out := make([]AIUsage, 0, len(totalUsage))
+	for _, u := range totalUsage {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Provider != out[j].Provider {
+			return out[i].Provider < out[j].Provider
+		}
+		return out[i].Model < out[j].Model
+	})
+	return out
+}
+
+// ResetAIUsage forgets the usage of past requests.
+func ResetAIUsage() {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	lastUsage, totalUsage = nil, map[string]*AIUsage{}
+}
+
+// recordAIUsage adds a request answered by the current provider and model, from the cache
+// or using the given tokens, to the session usage.
+func recordAIUsage(cached bool, promptTokens, completionTokens int) {
+	cfg := CurrentAIConfig()
+	u := AIUsage{Provider: cfg["provider"], Model: firstNonEmpty(cfg["model"], cfg["deployment"]), Calls: 1,
+		PromptTokens: promptTokens, CompletionTokens: completionTokens}
+	if cached {
+		u.Cached = 1
+	}
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	if p, ok := modelPrice[u.Model]; ok {
+		u.Priced = true
+		u.Cost = (float64(promptTokens)*p.Input + float64(completionTokens)*p.Output) / 1e6
+	}
+	lastUsage = &u
+	key := u.Provider + "\x00" + u.Model
+	t := totalUsage[key]
+	if t == nil {
+		t = &AIUsage{Provider: u.Provider, Model: u.Model}
+		totalUsage[key] = t
+	}
+	t.Calls++
+	t.Cached += u.Cached
+	t.PromptTokens += promptTokens
+	t.CompletionTokens += completionTokens
+	t.Cost += u.Cost
+	t.Priced = t.Priced || u.Priced
+}
+
+// responseTokens returns the token counts a provider response reports: usage.prompt_tokens
+// and completion_tokens (OpenAI, Azure, Grok), usage.input_tokens and output_tokens (Claude),
+// or prompt_eval_count and eval_count (Ollama).
+func responseTokens(body []byte) (prompt, completion int) {
+	var resp struct {
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			InputTokens      int `json:"input_tokens"`
+			OutputTokens     int `json:"output_tokens"`
+		} `json:"usage"`
+		PromptEvalCount int `json:"prompt_eval_count"`
+		EvalCount       int `json:"eval_count"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return 0, 0
+	}
+	u := resp.Usage
+	return max(u.PromptTokens, u.InputTokens, resp.PromptEvalCount), max(u.CompletionTokens, u.OutputTokens, resp.EvalCount)
+}
+
+// FormatAIUsage renders usage on one line, e.g. "1200 prompt + 150 completion tokens
+// (claude opus), ~$0.0042".
+func FormatAIUsage(u AIUsage) string {
+	var b strings.Builder
+	switch {
+	case u.Cached == u.Calls && u.Calls > 0:
+		b.WriteString("answered from the cache, no tokens")
+	case u.PromptTokens+u.CompletionTokens == 0:
+		b.WriteString("tokens not reported by the provider")
+	default:
+		fmt.Fprintf(&b, "%d prompt + %d completion tokens", u.PromptTokens, u.CompletionTokens)
+	}
+	if u.Model != "" {
+		fmt.Fprintf(&b, " (%s %s)", u.Provider, u.Model)
+	} else if u.Provider != "" {
+		fmt.Fprintf(&b, " (%s)", u.Provider)
+	}
+	if u.Priced {
+		fmt.Fprintf(&b, ", ~$%.4f", u.Cost)
+	}
+	return b.String()
+}
@@ -0,0 +1,507 @@
+// Package api serves a read-only subset of the Prometheus HTTP API (query, query_range,
+// series, labels and label values) over any storage.Queryable, so tools like Grafana can
+// use data loaded into promql-cli as a Prometheus data source.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// DefaultTimeout bounds query evaluation when the request has no timeout parameter.
+const DefaultTimeout = 30 * time.Second
+
+// maxRangePoints mirrors Prometheus' limit of 11000 points per series for range queries.
+const maxRangePoints = 11000
+
+// Options configures the API handler.
+type Options struct {
+	// Parser parses match[] selectors; nil uses a parser with experimental functions enabled.
+	Parser parser.Parser
+	// Metadata returns the type and help text per metric name for /api/v1/metadata; may be nil.
+	Metadata func() map[string][2]string
+	// Version is reported by /api/v1/status/buildinfo.
+	Version string
+}
+
+// API implements the HTTP handlers.
+type API struct {
+	engine    *promql.Engine
+	queryable func() storage.Queryable
+	opts      Options
+}
+
+// NewHandler returns an http.Handler serving /api/v1/... from queryable, which is called per
+// request so that callers can swap the underlying store.
+func NewHandler(engine *promql.Engine, queryable func() storage.Queryable, opts Options) http.Handler {
+	if opts.Parser == nil {
+		opts.Parser = parser.NewParser(parser.Options{EnableExperimentalFunctions: true})
+	}
+	a := &API{engine: engine, queryable: queryable, opts: opts}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/query", a.query)
+	mux.HandleFunc("/api/v1/query_range", a.queryRange)
+	mux.HandleFunc("/api/v1/series", a.series)
+	mux.HandleFunc("/api/v1/labels", a.labelNames)
+	mux.HandleFunc("/api/v1/label/{name}/values", a.labelValues)
+	mux.HandleFunc("/api/v1/metadata", a.metadata)
+	mux.HandleFunc("/api/v1/status/buildinfo", a.buildInfo)
+	mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("OK\n")) })
+	mux.HandleFunc("/-/ready", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("OK\n")) })
+	return mux
+}
+
+// apiError carries the Prometheus errorType and HTTP status of a failed request.
+type apiError struct {
+	typ    string
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string { return e.err.Error() }
+
+func badData(format string, args ...any) *apiError {
+	return &apiError{typ: "bad_data", status: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+type response struct {
+	Status    string   `json:"status"`
+	Data      any      `json:"data,omitempty"`
+	ErrorType string   `json:"errorType,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+func respond(w http.ResponseWriter, data any, warnings []string) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response{Status: "success", Data: data, Warnings: warnings})
+}
+
+func respondError(w http.ResponseWriter, err error) {
+	var ae *apiError
+	if !errors.As(err, &ae) {
+		ae = &apiError{typ: "internal", status: http.StatusInternalServerError, err: err}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ae.status)
+	_ = json.NewEncoder(w).Encode(response{Status: "error", ErrorType: ae.typ, Error: ae.Error()})
+}
+
+// parseForm accepts GET query parameters and POST form bodies, like Prometheus.
+func parseForm(r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		return &apiError{typ: "bad_data", status: http.StatusMethodNotAllowed, err: fmt.Errorf("method %s not allowed", r.Method)}
+	}
+	if err := r.ParseForm(); err != nil {
+		return badData("invalid form: %v", err)
+	}
+	return nil
+}
+
+// ParseTime parses a Prometheus API timestamp: unix seconds (with optional decimals) or RFC3339.
+func ParseTime(s string) (time.Time, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e9))).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// ParseDuration parses a duration as float seconds or a Prometheus duration like 1m30s.
+func ParseDuration(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f <= 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+		}
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	d, err := model.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+	}
+	return time.Duration(d), nil
+}
+
+func timeParam(r *http.Request, name string, def time.Time) (time.Time, error) {
+	v := r.Form.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	t, err := ParseTime(v)
+	if err != nil {
+		return time.Time{}, badData("invalid parameter %q: %v", name, err)
+	}
+	return t, nil
+}
+
+// queryContext applies the optional timeout parameter.
+func queryContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	timeout := DefaultTimeout
+	if v := r.Form.Get("timeout"); v != "" {
+		d, err := ParseDuration(v)
+		if err != nil {
+			return nil, nil, badData("invalid parameter \"timeout\": %v", err)
+		}
+		timeout = d
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
+}
+
+func (a *API) query(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		respondError(w, err)
+		return
+	}
+	ts, err := timeParam(r, "time", time.Now())
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	ctx, cancel, err := queryContext(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	defer cancel()
+	q, err := a.engine.NewInstantQuery(ctx, a.queryable(), nil, r.Form.Get("query"), ts)
+	if err != nil {
+		respondError(w, badData("%v", err))
+		return
+	}
+	a.exec(ctx, w, q)
+}
+
+func (a *API) queryRange(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		respondError(w, err)
+		return
+	}
+	start, err := timeParam(r, "start", time.Time{})
+	if err == nil && start.IsZero() {
+		err = badData("missing parameter \"start\"")
+	}
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	end, err := timeParam(r, "end", time.Time{})
+	if err == nil && end.IsZero() {
+		err = badData("missing parameter \"end\"")
+	}
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if end.Before(start) {
+		respondError(w, badData("end timestamp must not be before start time"))
+		return
+	}
+	step, err := ParseDuration(r.Form.Get("step"))
+	if err != nil {
+		respondError(w, badData("invalid parameter \"step\": %v", err))
+		return
+	}
+	if end.Sub(start)/step > maxRangePoints {
+		respondError(w, badData("exceeded maximum resolution of %d points per timeseries. Try decreasing the query resolution (?step=XX)", maxRangePoints))
+		return
+	}
+	ctx, cancel, err := queryContext(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	defer cancel()
+	q, err := a.engine.NewRangeQuery(ctx, a.queryable(), nil, r.Form.Get("query"), start, end, step)
+	if err != nil {
+		respondError(w, badData("%v", err))
+		return
+	}
+	a.exec(ctx, w, q)
+}
+
+func (a *API) exec(ctx context.Context, w http.ResponseWriter, q promql.Query) {
+	defer q.Close()
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		var typ string
+		status := http.StatusUnprocessableEntity
+		switch res.Err.(type) {
+		case promql.ErrQueryCanceled:
+			typ = "canceled"
+		case promql.ErrQueryTimeout:
+			typ, status = "timeout", http.StatusServiceUnavailable
+		case promql.ErrStorage:
+			typ, status = "internal", http.StatusInternalServerError
+		default:
+			typ = "execution"
+		}
+		respondError(w, &apiError{typ: typ, status: status, err: res.Err})
+		return
+	}
+	var warnings []string
+	for _, wrn := range res.Warnings {
+		warnings = append(warnings, wrn.Error())
+	}
+	respond(w, map[string]any{"resultType": res.Value.Type(), "result": encodeValue(res.Value)}, warnings)
+}
+
+// encodeValue renders a query result in the Prometheus API JSON shape, with sample values
+// as strings ("+Inf", "NaN") and timestamps as float seconds.
+func encodeValue(v parser.Value) any {
+	switch v := v.(type) {
+	case promql.Vector:
+		out := make([]map[string]any, 0, len(v))
+		for _, s := range v {
+			m := map[string]any{"metric": s.Metric.Map()}
+			if s.H != nil {
+				m["histogram"] = []any{apiTime(s.T), encodeHistogram(s.H)}
+			} else {
+				m["value"] = []any{apiTime(s.T), formatValue(s.F)}
+			}
+			out = append(out, m)
+		}
+		return out
+	case promql.Matrix:
+		out := make([]map[string]any, 0, len(v))
+		for _, s := range v {
+			m := map[string]any{"metric": s.Metric.Map()}
+			if len(s.Floats) > 0 {
+				values := make([][]any, 0, len(s.Floats))
+				for _, p := range s.Floats {
+					values = append(values, []any{apiTime(p.T), formatValue(p.F)})
+				}
+				m["values"] = values
+			}
+			if len(s.Histograms) > 0 {
+				hs := make([][]any, 0, len(s.Histograms))
+				for _, p := range s.Histograms {
+					hs = append(hs, []any{apiTime(p.T), encodeHistogram(p.H)})
+				}
+				m["histograms"] = hs
+			}
+			out = append(out, m)
+		}
+		return out
+	case promql.Scalar:
+		return []any{apiTime(v.T), formatValue(v.V)}
+	case promql.String:
+		return []any{apiTime(v.T), v.V}
+	}
+	return nil
+}
+
+func apiTime(ms int64) json.Number {
+	return json.Number(strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64))
+}
+
+func formatValue(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// encodeHistogram renders a native histogram with buckets as [boundary_rule, lower, upper, count].
+func encodeHistogram(h *histogram.FloatHistogram) map[string]any {
+	buckets := [][]any{}
+	it := h.AllBucketIterator()
+	for it.Next() {
+		b := it.At()
+		if b.Count == 0 {
+			continue
+		}
+		rule := 2
+		switch {
+		case b.LowerInclusive && b.UpperInclusive:
+			rule = 3
+		case b.LowerInclusive:
+			rule = 1
+		case b.UpperInclusive:
+			rule = 0
+		}
+		buckets = append(buckets, []any{rule, formatValue(b.Lower), formatValue(b.Upper), formatValue(b.Count)})
+	}
+	return map[string]any{"count": formatValue(h.Count), "sum": formatValue(h.Sum), "buckets": buckets}
+}
+
+// matcherSets parses the match[] parameters.
+func (a *API) matcherSets(r *http.Request) ([][]*labels.Matcher, error) {
+	var sets [][]*labels.Matcher
+	for _, m := range r.Form["match[]"] {
+		ms, err := a.opts.Parser.ParseMetricSelector(m)
+		if err != nil {
+			return nil, badData("invalid parameter \"match[]\": %v", err)
+		}
+		sets = append(sets, ms)
+	}
+	return sets, nil
+}
+
+// querier opens a querier over [start, end], defaulting to all time.
+func (a *API) querier(r *http.Request) (storage.Querier, error) {
+	start, err := timeParam(r, "start", time.UnixMilli(math.MinInt64/2))
+	if err != nil {
+		return nil, err
+	}
+	end, err := timeParam(r, "end", time.UnixMilli(math.MaxInt64/2))
+	if err != nil {
+		return nil, err
+	}
+	return a.queryable().Querier(start.UnixMilli(), end.UnixMilli())
+}
+
+func (a *API) series(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		respondError(w, err)
+		return
+	}
+	sets, err := a.matcherSets(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if len(sets) == 0 {
+		respondError(w, badData("no match[] parameter provided"))
+		return
+	}
+	q, err := a.querier(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	defer func() { _ = q.Close() }()
+	seen := map[string]labels.Labels{}
+	for _, ms := range sets {
+		ss := q.Select(r.Context(), false, nil, ms...)
+		for ss.Next() {
+			l := ss.At().Labels()
+			seen[l.String()] = l
+		}
+		if err := ss.Err(); err != nil {
+			respondError(w, err)
+			return
+		}
+	}
+	all := make([]labels.Labels, 0, len(seen))
+	for _, l := range seen {
+		all = append(all, l)
+	}
+	sort.Slice(all, func(i, j int) bool { return labels.Compare(all[i], all[j]) < 0 })
+	out := make([]map[string]string, 0, len(all))
+	for _, l := range limit(all, r) {
+		out = append(out, l.Map())
+	}
+	respond(w, out, nil)
+}
+
+func (a *API) labelNames(w http.ResponseWriter, r *http.Request) {
+	a.labelList(w, r, func(ctx context.Context, q storage.Querier, ms []*labels.Matcher) ([]string, error) {
+		names, _, err := q.LabelNames(ctx, nil, ms...)
+		return names, err
+	})
+}
+
+func (a *API) labelValues(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if strings.HasPrefix(name, "U__") {
+		// UTF-8 label names are escaped by clients, e.g. U__http_2e_method for http.method.
+		name = model.UnescapeName(name, model.ValueEncodingEscaping)
+	}
+	a.labelList(w, r, func(ctx context.Context, q storage.Querier, ms []*labels.Matcher) ([]string, error) {
+		values, _, err := q.LabelValues(ctx, name, nil, ms...)
+		return values, err
+	})
+}
+
+// labelList runs list once per match[] set (or once unfiltered) and returns the sorted union.
+func (a *API) labelList(w http.ResponseWriter, r *http.Request, list func(context.Context, storage.Querier, []*labels.Matcher) ([]string, error)) {
+	if err := parseForm(r); err != nil {
+		respondError(w, err)
+		return
+	}
+	sets, err := a.matcherSets(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if len(sets) == 0 {
+		sets = [][]*labels.Matcher{nil}
+	}
+	q, err := a.querier(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	defer func() { _ = q.Close() }()
+	seen := map[string]bool{}
+	for _, ms := range sets {
+		vals, err := list(r.Context(), q, ms)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+		for _, v := range vals {
+			seen[v] = true
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for v := range seen {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	respond(w, limit(out, r), nil)
+}
+
+func (a *API) metadata(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		respondError(w, err)
+		return
+	}
+	type entry struct {
+		Type string `json:"type"`
+		Help string `json:"help"`
+		Unit string `json:"unit"`
+	}
+	out := map[string][]entry{}
+	if a.opts.Metadata != nil {
+		metric := r.Form.Get("metric")
+		for name, md := range a.opts.Metadata() {
+			if metric == "" || metric == name {
+				out[name] = []entry{{Type: md[0], Help: md[1]}}
+			}
+		}
+	}
+	respond(w, out, nil)
+}
+
+func (a *API) buildInfo(w http.ResponseWriter, _ *http.Request) {
+	version := a.opts.Version
+	if version == "" {
+		version = "dev"
+	}
+	respond(w, map[string]string{"version": version, "application": "promql-cli"}, nil)
+}
+
+// limit truncates a result to the optional limit parameter.
+func limit[T any](items []T, r *http.Request) []T {
+	if n, err := strconv.Atoi(r.Form.Get("limit")); err == nil && n > 0 && n < len(items) {
+		return items[:n]
+	}
+	return items
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	store := sstorage.NewSimpleStorage()
+	for i := int64(0); i < 5; i++ {
+		store.AddSample(map[string]string{"__name__": "http_requests_total", "job": "api", "code": "200"}, float64(10*i), i*60000)
+		store.AddSample(map[string]string{"__name__": "http_requests_total", "job": "api", "code": "500"}, float64(i), i*60000)
+	}
+	store.AddSample(map[string]string{"__name__": "up", "job": "db"}, 1, 240000)
+	engine := promql.NewEngine(promql.EngineOpts{MaxSamples: 1000000, Timeout: 10 * time.Second, LookbackDelta: 5 * time.Minute})
+	srv := httptest.NewServer(NewHandler(engine, func() storage.Queryable { return store }, Options{
+		Metadata: func() map[string][2]string { return map[string][2]string{"up": {"gauge", "Target is up."}} },
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// get performs a GET and decodes the API response, checking the HTTP status.
+func get(t *testing.T, srv *httptest.Server, path string, params url.Values, wantStatus int) response {
+	t.Helper()
+	resp, err := http.Get(srv.URL + path + "?" + params.Encode())
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("GET %s: status %d, want %d (%+v)", path, resp.StatusCode, wantStatus, r)
+	}
+	return r
+}
+
+func TestQueryAndQueryRange(t *testing.T) {
+	srv := newTestServer(t)
+	r := get(t, srv, "/api/v1/query", url.Values{"query": {`sum by (code) (http_requests_total)`}, "time": {"240"}}, http.StatusOK)
+	b, _ := json.Marshal(r.Data)
+	if got := string(b); !strings.Contains(got, `"resultType":"vector"`) || !strings.Contains(got, `{"metric":{"code":"500"},"value":[240,"4"]}`) {
+		t.Fatalf("unexpected instant query result: %s", got)
+	}
+
+	// POST form bodies, as sent by Grafana.
+	resp, err := http.PostForm(srv.URL+"/api/v1/query_range", url.Values{
+		"query": {`rate(http_requests_total{code="200"}[2m])`}, "start": {"1970-01-01T00:02:00Z"}, "end": {"240"}, "step": {"1m"},
+	})
+	if err != nil {
+		t.Fatalf("POST query_range: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var rr response
+	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil || rr.Status != "success" {
+		t.Fatalf("unexpected query_range response %+v (%v)", rr, err)
+	}
+	b, _ = json.Marshal(rr.Data)
+	if got := string(b); !strings.Contains(got, `"resultType":"matrix"`) || !strings.Contains(got, `"values":[[120,"0.16666666666666666"],[180,`) {
+		t.Fatalf("unexpected range query result: %s", got)
+	}
+
+	if r := get(t, srv, "/api/v1/query", url.Values{"query": {"sum("}}, http.StatusBadRequest); r.ErrorType != "bad_data" {
+		t.Fatalf("expected bad_data, got %+v", r)
+	}
+	if r := get(t, srv, "/api/v1/query_range", url.Values{"query": {"up"}, "start": {"0"}, "end": {"100000"}, "step": {"1"}}, http.StatusBadRequest); !strings.Contains(r.Error, "maximum resolution") {
+		t.Fatalf("expected a resolution error, got %+v", r)
+	}
+}
+
+func TestSeriesAndLabels(t *testing.T) {
+	srv := newTestServer(t)
+	r := get(t, srv, "/api/v1/series", url.Values{"match[]": {`http_requests_total{code=~"5.."}`, "up"}}, http.StatusOK)
+	b, _ := json.Marshal(r.Data)
+	if got := string(b); got != `[{"__name__":"http_requests_total","code":"500","job":"api"},{"__name__":"up","job":"db"}]` {
+		t.Fatalf("unexpected series: %s", got)
+	}
+	get(t, srv, "/api/v1/series", nil, http.StatusBadRequest)
+
+	r = get(t, srv, "/api/v1/labels", nil, http.StatusOK)
+	if b, _ = json.Marshal(r.Data); string(b) != `["__name__","code","job"]` {
+		t.Fatalf("unexpected labels: %s", b)
+	}
+	r = get(t, srv, "/api/v1/label/job/values", url.Values{"match[]": {"http_requests_total"}}, http.StatusOK)
+	if b, _ = json.Marshal(r.Data); string(b) != `["api"]` {
+		t.Fatalf("unexpected label values: %s", b)
+	}
+	r = get(t, srv, "/api/v1/label/__name__/values", url.Values{"limit": {"1"}}, http.StatusOK)
+	if b, _ = json.Marshal(r.Data); string(b) != `["http_requests_total"]` {
+		t.Fatalf("unexpected limited names: %s", b)
+	}
+	r = get(t, srv, "/api/v1/metadata", nil, http.StatusOK)
+	if b, _ = json.Marshal(r.Data); string(b) != `{"up":[{"help":"Target is up.","type":"gauge","unit":""}]}` {
+		t.Fatalf("unexpected metadata: %s", b)
+	}
+}
@@ -0,0 +1,300 @@
+// Package lint flags common PromQL mistakes that parse fine but rarely do what was meant:
+// rate() over gauges, raw counters, mismatched subquery steps, aggregations that drop labels
+// a later operation needs, and comparisons that filter where a 0/1 result was expected.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Issue is a single lint finding.
+type Issue struct {
+	Rule    string // short rule identifier, e.g. "rate-on-gauge"
+	Message string
+	Expr    string // the offending sub-expression
+	Pos     int    // byte offset of Expr within the linted query
+}
+
+// String formats the issue as "<pos>: [rule] message (in: expr)".
+func (i Issue) String() string {
+	return fmt.Sprintf("%d: [%s] %s (in: %s)", i.Pos, i.Rule, i.Message, i.Expr)
+}
+
+// Options tunes Lint.
+type Options struct {
+	// Parser parses the query; nil uses a parser with experimental functions enabled.
+	Parser parser.Parser
+	// MetricType returns the metadata type (counter, gauge, histogram, summary, untyped) of a
+	// metric name, or "" when unknown. Without it, only naming conventions are used.
+	MetricType func(name string) string
+}
+
+// counterRangeFuncs take a range vector and are meaningful on counters.
+var counterRangeFuncs = map[string]bool{
+	"rate": true, "irate": true, "increase": true, "resets": true, "changes": true,
+	"count_over_time": true, "present_over_time": true, "absent_over_time": true,
+	"last_over_time": true, "timestamp": true,
+}
+
+// counterOnlyFuncs assume their input is a counter.
+var counterOnlyFuncs = map[string]bool{"rate": true, "irate": true, "increase": true, "resets": true}
+
+// rawCounterOK are functions and aggregations where using a counter's raw value is fine.
+var rawCounterOK = map[string]bool{
+	"absent": true, "timestamp": true, "count": true, "group": true, "count_values": true,
+}
+
+// Lint parses expr and returns the issues found, ordered by position.
+func Lint(expr string, opts Options) ([]Issue, error) {
+	p := opts.Parser
+	if p == nil {
+		p = parser.NewParser(parser.Options{EnableExperimentalFunctions: true})
+	}
+	root, err := p.ParseExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return LintExpr(root, opts), nil
+}
+
+// LintExpr runs all checks on a parsed expression.
+func LintExpr(root parser.Expr, opts Options) []Issue {
+	l := &linter{opts: opts}
+	parser.Inspect(root, func(node parser.Node, path []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.Call:
+			l.checkCall(n)
+		case *parser.VectorSelector:
+			l.checkRawCounter(n, path)
+		case *parser.SubqueryExpr:
+			l.checkSubquery(n)
+		case *parser.BinaryExpr:
+			l.checkComparison(n, path)
+			l.checkMatchingLabels(n)
+		}
+		return nil
+	})
+	sort.SliceStable(l.issues, func(i, j int) bool { return l.issues[i].Pos < l.issues[j].Pos })
+	return l.issues
+}
+
+type linter struct {
+	opts   Options
+	issues []Issue
+}
+
+func (l *linter) add(rule string, node parser.Node, format string, args ...any) {
+	l.issues = append(l.issues, Issue{
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+		Expr:    node.String(),
+		Pos:     int(node.PositionRange().Start),
+	})
+}
+
+// kind classifies a metric as "counter", "gauge" or "" (unknown), using metadata first and
+// then the _total/_count/_sum/_bucket naming conventions.
+func (l *linter) kind(name string) string {
+	if l.opts.MetricType != nil {
+		switch l.opts.MetricType(name) {
+		case "counter":
+			return "counter"
+		case "gauge":
+			return "gauge"
+		case "histogram", "summary":
+			if strings.HasSuffix(name, "_count") || strings.HasSuffix(name, "_sum") || strings.HasSuffix(name, "_bucket") {
+				return "counter"
+			}
+			return ""
+		}
+	}
+	for _, suffix := range []string{"_total", "_count", "_sum", "_bucket"} {
+		if strings.HasSuffix(name, suffix) {
+			return "counter"
+		}
+	}
+	return ""
+}
+
+// checkCall flags rate()-style functions over gauges or over aggregated subqueries, and
+// histogram_quantile() over an aggregation that drops the le label.
+func (l *linter) checkCall(c *parser.Call) {
+	name := c.Func.Name
+	if counterOnlyFuncs[name] && len(c.Args) > 0 {
+		switch arg := c.Args[0].(type) {
+		case *parser.MatrixSelector:
+			if vs, ok := arg.VectorSelector.(*parser.VectorSelector); ok && vs.Name != "" && l.kind(vs.Name) == "gauge" {
+				l.add("rate-on-gauge", c, "%s() on gauge %q; use deriv() or delta() for gauges", name, vs.Name)
+			}
+		case *parser.SubqueryExpr:
+			if _, ok := unwrapParens(arg.Expr).(*parser.AggregateExpr); ok {
+				l.add("rate-of-aggregation", c, "%s() over an aggregated subquery misses counter resets of the individual series; aggregate the %s() instead", name, name)
+			}
+		}
+	}
+	if name == "histogram_quantile" && len(c.Args) == 2 {
+		if agg, ok := unwrapParens(c.Args[1]).(*parser.AggregateExpr); ok && !keepsLabel(agg, labels.BucketLabel) {
+			l.add("aggregation-drops-label", agg, "histogram_quantile() needs the %q label but this %s drops it; add it to by (...)", labels.BucketLabel, agg.Op)
+		}
+	}
+}
+
+// checkRawCounter flags counters used without a rate()-style function.
+func (l *linter) checkRawCounter(vs *parser.VectorSelector, path []parser.Node) {
+	if vs.Name == "" || l.kind(vs.Name) != "counter" {
+		return
+	}
+	var inRange bool
+	for i := len(path) - 1; i >= 0; i-- {
+		switch p := path[i].(type) {
+		case *parser.MatrixSelector:
+			inRange = true
+		case *parser.SubqueryExpr:
+			inRange = true
+		case *parser.Call:
+			if inRange {
+				if counterRangeFuncs[p.Func.Name] {
+					return
+				}
+				l.add("counter-without-rate", vs, "counter %q used with %s(); apply rate() or increase() first", vs.Name, p.Func.Name)
+				return
+			}
+			if rawCounterOK[p.Func.Name] {
+				return
+			}
+		case *parser.AggregateExpr:
+			if rawCounterOK[p.Op.String()] {
+				return
+			}
+		}
+	}
+	if inRange {
+		return
+	}
+	l.add("counter-without-rate", vs, "counter %q used without rate()/increase(); its raw value only ever grows and resets on restart", vs.Name)
+}
+
+// checkSubquery flags subqueries whose step doesn't evenly divide the range.
+func (l *linter) checkSubquery(sq *parser.SubqueryExpr) {
+	if sq.Step == 0 {
+		return
+	}
+	switch {
+	case sq.Step > sq.Range:
+		l.add("subquery-step", sq, "subquery step %s is larger than its range %s; it evaluates at most once", fmtDuration(sq.Step), fmtDuration(sq.Range))
+	case sq.Range%sq.Step != 0:
+		l.add("subquery-step", sq, "subquery range %s is not a multiple of its step %s; the number of points varies between evaluations", fmtDuration(sq.Range), fmtDuration(sq.Step))
+	}
+	if m, ok := unwrapParens(sq.Expr).(*parser.Call); ok && len(m.Args) > 0 {
+		if ms, ok := m.Args[0].(*parser.MatrixSelector); ok && ms.Range < sq.Step {
+			l.add("subquery-step", sq, "inner range %s is shorter than the subquery step %s; samples between steps are skipped", fmtDuration(ms.Range), fmtDuration(sq.Step))
+		}
+	}
+}
+
+// checkComparison flags filtering comparisons whose result feeds arithmetic, where a 0/1
+// "bool" result was most likely intended. Filters inside aggregations, as in sum(x > 5),
+// are fine, except for avg() of an equality with a number: the values left all equal that
+// number, as in avg(up == 1), which is 1 rather than the fraction of targets up.
+func (l *linter) checkComparison(b *parser.BinaryExpr, path []parser.Node) {
+	if !b.Op.IsComparisonOperator() || b.ReturnBool || b.Type() != parser.ValueTypeVector {
+		return
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		switch p := path[i].(type) {
+		case *parser.ParenExpr:
+			continue
+		case *parser.BinaryExpr:
+			if !p.Op.IsComparisonOperator() && !p.Op.IsSetOperator() {
+				l.add("comparison-without-bool", b, "%s filters series; the %s on its result only sees matching series. Use '%s bool' for a 0/1 value", b.Op, p.Op, b.Op)
+			}
+			return
+		case *parser.AggregateExpr:
+			if p.Op == parser.AVG && b.Op == parser.EQLC && (isNumber(b.LHS) || isNumber(b.RHS)) {
+				l.add("comparison-without-bool", b, "%s keeps only the series equal to the number, so avg() always returns it; use '%s bool' to average 0/1 values", b.Op, b.Op)
+			}
+			return
+		default:
+			return
+		}
+	}
+}
+
+// isNumber reports whether e is a number literal, possibly in parentheses.
+func isNumber(e parser.Expr) bool {
+	_, ok := unwrapParens(e).(*parser.NumberLiteral)
+	return ok
+}
+
+// checkMatchingLabels flags on(...)/group_left(...) labels dropped by an aggregation on
+// either side of a binary operation.
+func (l *linter) checkMatchingLabels(b *parser.BinaryExpr) {
+	vm := b.VectorMatching
+	if vm == nil || b.LHS.Type() != parser.ValueTypeVector || b.RHS.Type() != parser.ValueTypeVector {
+		return
+	}
+	for i, side := range []parser.Expr{b.LHS, b.RHS} {
+		agg, ok := unwrapParens(side).(*parser.AggregateExpr)
+		if !ok {
+			continue
+		}
+		var need []string
+		if vm.On {
+			need = append(need, vm.MatchingLabels...)
+		}
+		// group_left/group_right copy the included labels from the "one" side.
+		if (vm.Card == parser.CardManyToOne && i == 1) || (vm.Card == parser.CardOneToMany && i == 0) {
+			need = append(need, vm.Include...)
+		}
+		for _, lbl := range need {
+			if !keepsLabel(agg, lbl) {
+				l.add("aggregation-drops-label", agg, "%s drops label %q used by %s", agg.Op, lbl, matchingClause(vm))
+			}
+		}
+	}
+}
+
+// keepsLabel reports whether an aggregation preserves label name in its output.
+func keepsLabel(agg *parser.AggregateExpr, name string) bool {
+	switch agg.Op {
+	case parser.TOPK, parser.BOTTOMK, parser.LIMITK, parser.LIMIT_RATIO:
+		return true
+	}
+	for _, g := range agg.Grouping {
+		if g == name {
+			return !agg.Without
+		}
+	}
+	return agg.Without
+}
+
+func matchingClause(vm *parser.VectorMatching) string {
+	if vm.On {
+		return "on(" + strings.Join(vm.MatchingLabels, ", ") + ")"
+	}
+	if vm.Card == parser.CardManyToOne {
+		return "group_left(" + strings.Join(vm.Include, ", ") + ")"
+	}
+	return "group_right(" + strings.Join(vm.Include, ", ") + ")"
+}
+
+func unwrapParens(e parser.Expr) parser.Expr {
+	for {
+		p, ok := e.(*parser.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.Expr
+	}
+}
+
+func fmtDuration(d time.Duration) string {
+	return model.Duration(d).String()
+}
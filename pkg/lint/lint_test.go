@@ -0,0 +1,61 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+)
+
+func rules(issues []Issue) string {
+	names := make([]string, len(issues))
+	for i, is := range issues {
+		names[i] = is.Rule
+	}
+	return strings.Join(names, ",")
+}
+
+func TestLint(t *testing.T) {
+	types := map[string]string{"node_memory_free_bytes": "gauge", "requests": "counter", "latency": "histogram"}
+	opts := Options{MetricType: func(name string) string {
+		if typ, ok := types[name]; ok {
+			return typ
+		}
+		return types[strings.TrimSuffix(name, "_bucket")]
+	}}
+	for _, tc := range []struct {
+		expr, want string
+	}{
+		{`sum by (job) (rate(http_requests_total[5m]))`, ""},
+		{`rate(node_memory_free_bytes[5m])`, "rate-on-gauge"},
+		{`sum(http_requests_total)`, "counter-without-rate"},
+		{`requests > 10`, "counter-without-rate"},
+		{`count(http_requests_total)`, ""},
+		{`absent(http_requests_total)`, ""},
+		{`avg_over_time(http_requests_total[5m])`, "counter-without-rate"},
+		{`max_over_time(rate(http_requests_total[5m])[1h:1m])`, ""},
+		{`max_over_time(rate(http_requests_total[10m])[1h:7m])`, "subquery-step"},
+		{`max_over_time(rate(http_requests_total[1m])[1h:5m])`, "subquery-step"},
+		{`rate(sum(http_requests_total)[5m:1m])`, "rate-of-aggregation"},
+		{`histogram_quantile(0.9, sum by (job) (rate(latency_bucket[5m])))`, "aggregation-drops-label"},
+		{`histogram_quantile(0.9, sum by (le) (rate(latency_bucket[5m])))`, ""},
+		{`sum by (job) (rate(http_requests_total[5m])) / on (instance) sum by (job) (rate(http_requests_total[5m]))`, "aggregation-drops-label,aggregation-drops-label"},
+		{`sum((up == 1) * 2)`, "comparison-without-bool"},
+		{`avg(up == bool 1)`, ""},
+		{`count(up == 1)`, ""},
+		{`avg(up == 1)`, "comparison-without-bool"},
+		{`sum(up == 1)`, ""},
+		{`sum(node_load1 > 5)`, ""},
+		{`avg by (job) (rate(http_requests_total[5m]) > 0.1)`, ""},
+		{`up == 1`, ""},
+	} {
+		issues, err := Lint(tc.expr, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if got := rules(issues); got != tc.want {
+			t.Errorf("%s: got rules %q, want %q (%v)", tc.expr, got, tc.want, issues)
+		}
+	}
+	if _, err := Lint("sum(", opts); err == nil {
+		t.Fatalf("expected parse error")
+	}
+}
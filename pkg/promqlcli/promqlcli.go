@@ -0,0 +1,165 @@
+// Package promqlcli embeds the promql-cli query evaluator and its ad-hoc commands (the
+// REPL dot-commands, e.g. .labels or .rules) in other Go programs, without the terminal
+// layer.
+//
+// A Session holds a metrics store and the PromQL engine evaluating queries over it:
+//
+//	s := promqlcli.New(promqlcli.Options{})
+//	if err := s.LoadReader(strings.NewReader(metrics)); err != nil {
+//		return err
+//	}
+//	res, err := s.Query(ctx, `sum by (code) (http_requests_total)`, time.Now())
+//	...
+//	err = s.Execute(os.Stdout, ".labels http_requests_total")
+//
+// The settings changed by ad-hoc commands (output format, pinned time, variables, ...)
+// belong to the process, so they are shared by all its sessions.
+package promqlcli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/jjo/promql-cli/pkg/repl"
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// Options configures a Session.
+type Options struct {
+	// Engine sets the PromQL engine options; nil uses the promql-cli defaults
+	// (repl.DefaultEngineOpts).
+	Engine *promql.EngineOpts
+	// Storage is the store queried; nil starts with an empty one.
+	Storage *sstorage.SimpleStorage
+}
+
+// Session is a metrics store with the engine evaluating queries and commands over it.
+type Session struct {
+	storage *sstorage.SimpleStorage
+	engine  *promql.Engine
+}
+
+// executeMu serializes Execute, which redirects the process stdout while a line runs.
+var executeMu sync.Mutex
+
+// New returns a Session with opts. Its engine is its own: building it leaves the engine
+// of the REPL and of other sessions alone.
+func New(opts Options) *Session {
+	eo := repl.DefaultEngineOpts()
+	if opts.Engine != nil {
+		eo = *opts.Engine
+	}
+	storage := opts.Storage
+	if storage == nil {
+		storage = sstorage.NewSimpleStorage()
+	}
+	return &Session{storage: storage, engine: promql.NewEngine(eo)}
+}
+
+// Storage returns the store of the session.
+func (s *Session) Storage() *sstorage.SimpleStorage { return s.storage }
+
+// Engine returns the PromQL engine of the session.
+func (s *Session) Engine() *promql.Engine { return s.engine }
+
+// LoadReader adds the metrics read from r, in the Prometheus text or OpenMetrics format,
+// to the store.
+func (s *Session) LoadReader(r io.Reader) error {
+	return s.storage.LoadFromReader(r)
+}
+
+// Query evaluates expr as an instant query at t.
+func (s *Session) Query(ctx context.Context, expr string, t time.Time) (*promql.Result, error) {
+	q, err := s.engine.NewInstantQuery(ctx, repl.QueryableFor(s.storage), nil, expr, t)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	return queryResult(q.Exec(ctx))
+}
+
+// QueryRange evaluates expr as a range query from start to end every step.
+func (s *Session) QueryRange(ctx context.Context, expr string, start, end time.Time, step time.Duration) (*promql.Result, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("step must be a positive duration")
+	}
+	q, err := s.engine.NewRangeQuery(ctx, repl.QueryableFor(s.storage), nil, expr, start, end, step)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	return queryResult(q.Exec(ctx))
+}
+
+// queryResult returns res, or its error. Closing the query releases the samples of the
+// result, so they are copied out first.
+func queryResult(res *promql.Result) (*promql.Result, error) {
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	out := *res
+	switch v := res.Value.(type) {
+	case promql.Vector:
+		out.Value = append(promql.Vector(nil), v...)
+	case promql.Matrix:
+		m := make(promql.Matrix, len(v))
+		for i, series := range v {
+			m[i] = promql.Series{
+				Metric:     series.Metric,
+				Floats:     append([]promql.FPoint(nil), series.Floats...),
+				Histograms: append([]promql.HPoint(nil), series.Histograms...),
+			}
+		}
+		out.Value = m
+	}
+	return &out, nil
+}
+
+// Execute runs line as the REPL would, a PromQL query or an ad-hoc command, and writes
+// what it prints to w. Errors of the line are printed too, as in the REPL; the returned
+// error only reports a failure to capture or write the output.
+func (s *Session) Execute(w io.Writer, line string) error {
+	executeMu.Lock()
+	defer executeMu.Unlock()
+	return repl.ExecuteQueryLineTo(w, s.engine, s.storage, line)
+}
+
+// Command is an ad-hoc command added with RegisterCommand.
+type Command struct {
+	Name        string // with its leading dot, e.g. ".owners"
+	Description string
+	Usage       string
+	Examples    []string
+	// Run executes the command with the arguments that follow its name, writing its
+	// output to w. A returned error is printed as the REPL prints errors. Run must not
+	// call Execute.
+	Run func(s *Session, args string, w io.Writer) error
+}
+
+// RegisterCommand adds cmd to the ad-hoc commands of every session, of the REPL and of
+// their help and completion. It fails when the name is taken.
+func RegisterCommand(cmd Command) error {
+	if cmd.Run == nil {
+		return fmt.Errorf("command %s: missing Run", cmd.Name)
+	}
+	return repl.RegisterAdHocCommand(repl.AdHocCommand{
+		Command:     cmd.Name,
+		Description: cmd.Description,
+		Usage:       cmd.Usage,
+		Examples:    cmd.Examples,
+	}, func(query string, storage *sstorage.SimpleStorage) bool {
+		s := &Session{storage: storage, engine: repl.CurrentEngine()}
+		args := strings.TrimSpace(strings.TrimPrefix(query, cmd.Name))
+		if err := cmd.Run(s, args, os.Stdout); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+		return true
+	})
+}
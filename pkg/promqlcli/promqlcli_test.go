@@ -0,0 +1,114 @@
+package promqlcli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/jjo/promql-cli/pkg/repl"
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestSession(t *testing.T) {
+	s := New(Options{})
+	if err := s.LoadReader(strings.NewReader(sstorage.SampleMetrics)); err != nil {
+		t.Fatalf("LoadReader: %v", err)
+	}
+	ctx := context.Background()
+
+	res, err := s.Query(ctx, `sum(http_requests_total)`, time.Now())
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if v, ok := res.Value.(promql.Vector); !ok || len(v) != 1 || v[0].F != 1030 {
+		t.Fatalf("unexpected result: %v", res.Value)
+	}
+	now := time.Now()
+	res, err = s.QueryRange(ctx, `temperature`, now.Add(-2*time.Minute), now, time.Minute)
+	if err != nil {
+		t.Fatalf("QueryRange: %v", err)
+	}
+	if m, ok := res.Value.(promql.Matrix); !ok || len(m) != 1 || len(m[0].Floats) == 0 {
+		t.Fatalf("unexpected range result: %v", res.Value)
+	}
+	if _, err := s.Query(ctx, `sum(`, now); err == nil {
+		t.Fatalf("expected a parse error")
+	}
+
+	var out bytes.Buffer
+	if err := s.Execute(&out, ".labels http_requests_total"); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !strings.Contains(out.String(), "code") {
+		t.Fatalf("expected the labels of the metric, got: %s", out.String())
+	}
+	out.Reset()
+	_ = s.Execute(&out, "temperature")
+	if !strings.Contains(out.String(), "27.3") {
+		t.Fatalf("expected the query printed, got: %s", out.String())
+	}
+}
+
+func TestSessionKeepsREPLEngine(t *testing.T) {
+	replEngine := repl.CurrentEngine()
+	s := New(Options{})
+	if repl.CurrentEngine() != replEngine {
+		t.Fatalf("New should not replace the REPL engine")
+	}
+	if err := s.Execute(io.Discard, ".metrics"); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if repl.CurrentEngine() != replEngine {
+		t.Fatalf("Execute should restore the REPL engine")
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	cmd := Command{
+		Name:        ".series_count",
+		Description: "Count the series of a query",
+		Usage:       ".series_count <query>",
+		Run: func(s *Session, args string, w io.Writer) error {
+			if args == "" {
+				return fmt.Errorf("missing query")
+			}
+			res, err := s.Query(context.Background(), args, time.Now())
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "%d series\n", len(res.Value.(promql.Vector)))
+			return err
+		},
+	}
+	if err := RegisterCommand(cmd); err != nil {
+		t.Fatalf("RegisterCommand: %v", err)
+	}
+	if err := RegisterCommand(cmd); err == nil {
+		t.Fatalf("expected an error registering a command twice")
+	}
+	for _, name := range []string{".labels", "noprefix"} {
+		if err := RegisterCommand(Command{Name: name, Run: cmd.Run}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	s := New(Options{})
+	if err := s.LoadReader(strings.NewReader(sstorage.SampleMetrics)); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	_ = s.Execute(&out, ".series_count http_requests_total")
+	_ = s.Execute(&out, ".series_count")
+	_ = s.Execute(&out, ".help .series_count")
+	for _, want := range []string{"2 series", "Error: missing query", "Count the series of a query"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q, got: %s", want, out.String())
+		}
+	}
+}
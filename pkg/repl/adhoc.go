@@ -3,6 +3,7 @@ package repl
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -26,8 +27,13 @@ var replEngine *promql.Engine
 func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 	trimmed := strings.TrimSpace(query)
 	// .help: show ad-hoc commands usage
-	if strings.HasPrefix(trimmed, ".help") {
-		handleHelpCommand()
+	if strings.HasPrefix(trimmed, ".help ") || trimmed == ".help" {
+		handleHelpCommand(strings.TrimSpace(strings.TrimPrefix(trimmed, ".help")))
+		return true
+	}
+
+	// Commands added with RegisterAdHocCommand, before the built-in prefix matches
+	if handleRegisteredCommand(trimmed, storage) {
 		return true
 	}
 
@@ -45,6 +51,53 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
+	// .store: named stores loaded side by side
+	if strings.HasPrefix(trimmed, ".store ") || trimmed == ".store" {
+		if handled := handleAdhocStore(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// .config: show or reload the config file
+	if strings.HasPrefix(trimmed, ".config ") || trimmed == ".config" {
+		if handled := handleAdhocConfig(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// .engine: show or change the engine limits
+	if strings.HasPrefix(trimmed, ".engine ") || trimmed == ".engine" {
+		if handled := handleAdhocEngine(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// .pprof: profile promql-cli itself
+	if strings.HasPrefix(trimmed, ".pprof ") || trimmed == ".pprof" {
+		if handled := handleAdhocPprof(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// .meminfo: report (and compact) the store memory
+	if strings.HasPrefix(trimmed, ".meminfo ") || trimmed == ".meminfo" {
+		if handled := handleAdhocMeminfo(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// .retention / .downsample: bound the store memory in long-running sessions
+	if strings.HasPrefix(trimmed, ".retention ") || trimmed == ".retention" {
+		if handled := handleAdhocRetention(trimmed, storage); handled {
+			return true
+		}
+	}
+	if strings.HasPrefix(trimmed, ".downsample ") || trimmed == ".downsample" {
+		if handled := handleAdhocDownsample(trimmed, storage); handled {
+			return true
+		}
+	}
+
 	// .metrics: list metric names
 	if trimmed == ".metrics" {
 		if handled := handleAdhocMetrics(trimmed, storage); handled {
@@ -52,8 +105,8 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
-	// .stats: show totals
-	if trimmed == ".stats" {
+	// .stats: show totals, or toggle per-query stats
+	if strings.HasPrefix(trimmed, ".stats ") || trimmed == ".stats" {
 		if handled := handleAdhocStats(trimmed, storage); handled {
 			return true
 		}
@@ -66,13 +119,27 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
-	// Handle .alerts
-	if trimmed == ".alerts" {
+	// Handle .alerts [filter_regex]
+	if strings.HasPrefix(trimmed, ".alerts ") || trimmed == ".alerts" {
 		if handled := handleAdhocAlerts(trimmed, storage); handled {
 			return true
 		}
 	}
 
+	// Handle .doctor [metric_regex]
+	if strings.HasPrefix(trimmed, ".doctor ") || trimmed == ".doctor" {
+		if handled := handleAdhocDoctor(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .cardinality [metric_regex] [topN]
+	if strings.HasPrefix(trimmed, ".cardinality ") || trimmed == ".cardinality" {
+		if handled := handleAdhocCardinality(trimmed, storage); handled {
+			return true
+		}
+	}
+
 	// Handle .labels <metric>
 	if strings.HasPrefix(trimmed, ".labels") {
 		if handled := handleAdhocLabels(trimmed, storage); handled {
@@ -80,6 +147,20 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
+	// Handle .values <label> [metric_regex]
+	if strings.HasPrefix(trimmed, ".values ") || trimmed == ".values" {
+		if handled := handleAdhocValues(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .meta <metric>
+	if strings.HasPrefix(trimmed, ".meta ") || trimmed == ".meta" {
+		if handled := handleAdhocMeta(trimmed, storage); handled {
+			return true
+		}
+	}
+
 	// Handle .timestamps <metric>
 	if strings.HasPrefix(trimmed, ".timestamps ") || trimmed == ".timestamps" {
 		if handled := handleAdhocTimestamps(trimmed, storage); handled {
@@ -87,6 +168,13 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
+	// Handle .exemplars <metric|selector>
+	if strings.HasPrefix(trimmed, ".exemplars ") || trimmed == ".exemplars" {
+		if handled := handleAdhocExemplars(trimmed, storage); handled {
+			return true
+		}
+	}
+
 	// Handle .drop <series regex>
 	if strings.HasPrefix(trimmed, ".drop ") || trimmed == ".drop" {
 		if handled := handleAdhocDrop(trimmed, storage); handled {
@@ -101,6 +189,27 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
+	// Handle .session save|load <name>, .session list
+	if strings.HasPrefix(trimmed, ".session ") || trimmed == ".session" {
+		if handled := handleAdhocSession(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .csv <file> <query> [range [step]]
+	if strings.HasPrefix(trimmed, ".csv ") || trimmed == ".csv" {
+		if handled := handleAdhocCSV(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .export <file> [format=...]
+	if strings.HasPrefix(trimmed, ".export ") || trimmed == ".export" {
+		if handled := handleAdhocExport(trimmed, storage); handled {
+			return true
+		}
+	}
+
 	// Handle .save <file.prom>
 	if strings.HasPrefix(trimmed, ".save ") || trimmed == ".save" {
 		if handled := handleAdhocSave(trimmed, storage); handled {
@@ -108,6 +217,27 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
+	// Handle .load_json <file.json>
+	if strings.HasPrefix(trimmed, ".load_json ") || trimmed == ".load_json" {
+		if handled := handleAdhocLoadJSON(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .load_influx <file> [measurement_regex] [precision=...]
+	if strings.HasPrefix(trimmed, ".load_influx ") || trimmed == ".load_influx" {
+		if handled := handleAdhocLoadInflux(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .load_tsdb <dir> [selector] [start [end]]
+	if strings.HasPrefix(trimmed, ".load_tsdb ") || trimmed == ".load_tsdb" {
+		if handled := handleAdhocLoadTSDB(trimmed, storage); handled {
+			return true
+		}
+	}
+
 	// Handle .load <file.prom>
 	if strings.HasPrefix(trimmed, ".load ") || trimmed == ".load" {
 		if handled := handleAdhocLoad(trimmed, storage); handled {
@@ -115,6 +245,13 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
+	// Handle .record <file> | stop
+	if strings.HasPrefix(trimmed, ".record ") || trimmed == ".record" {
+		if handled := handleAdhocRecord(trimmed, storage); handled {
+			return true
+		}
+	}
+
 	// Handle .source <file>
 	if strings.HasPrefix(trimmed, ".source ") || trimmed == ".source" {
 		if handled := handleAdhocSource(trimmed, storage); handled {
@@ -129,6 +266,137 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
+	// Handle .tz [<location>] and .time_format [rfc3339|unix|relative]
+	if strings.HasPrefix(trimmed, ".tz ") || trimmed == ".tz" {
+		if handled := handleAdhocTZ(trimmed, storage); handled {
+			return true
+		}
+	}
+	if strings.HasPrefix(trimmed, ".time_format ") || trimmed == ".time_format" {
+		if handled := handleAdhocTimeFormat(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .format [text|json|jsonl|table|csv|tsv|markdown]
+	if strings.HasPrefix(trimmed, ".format ") || trimmed == ".format" {
+		if handled := handleAdhocFormat(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .columns [keep|drop <labels>|reset]
+	if strings.HasPrefix(trimmed, ".columns ") || trimmed == ".columns" {
+		if handled := handleAdhocColumns(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .sort [value|metric [asc|desc] | none]
+	if strings.HasPrefix(trimmed, ".sort ") || trimmed == ".sort" {
+		if handled := handleAdhocSort(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .groupby [<label>|off]
+	if strings.HasPrefix(trimmed, ".groupby ") || trimmed == ".groupby" {
+		if handled := handleAdhocGroupBy(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .guard [N [warn|confirm]|off]
+	if strings.HasPrefix(trimmed, ".guard ") || trimmed == ".guard" {
+		if handled := handleAdhocGuard(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .limit [N|off]
+	if strings.HasPrefix(trimmed, ".limit ") || trimmed == ".limit" {
+		if handled := handleAdhocLimit(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .humanize [on|off]
+	if strings.HasPrefix(trimmed, ".humanize ") || trimmed == ".humanize" {
+		if handled := handleAdhocHumanize(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .highlight [<regex>|off]
+	if strings.HasPrefix(trimmed, ".highlight ") || trimmed == ".highlight" {
+		if handled := handleAdhocHighlight(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .persist
+	if strings.HasPrefix(trimmed, ".persist ") || trimmed == ".persist" {
+		if handled := handleAdhocPersist(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .connect [<prometheus-url>|off]
+	if strings.HasPrefix(trimmed, ".connect ") || trimmed == ".connect" {
+		if handled := handleAdhocConnect(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .range <start> <end> <step> <query>
+	if strings.HasPrefix(trimmed, ".range ") || trimmed == ".range" {
+		if handled := handleAdhocRange(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .graph <query> [range] [step]
+	if strings.HasPrefix(trimmed, ".graph ") || trimmed == ".graph" {
+		if handled := handleAdhocGraph(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .diff <queryA> ;; <queryB> | .diff @t1 @t2 <query>
+	if strings.HasPrefix(trimmed, ".diff ") || trimmed == ".diff" {
+		if handled := handleAdhocDiff(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .outliers <query> [z=3] [range=1h]
+	if strings.HasPrefix(trimmed, ".outliers ") || trimmed == ".outliers" {
+		if handled := handleAdhocOutliers(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .histogram <metric_base>
+	if strings.HasPrefix(trimmed, ".histogram ") || trimmed == ".histogram" {
+		if handled := handleAdhocHistogram(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .remote_read <url> <selector> [start] [end] [auth=...]
+	if strings.HasPrefix(trimmed, ".remote_read ") || trimmed == ".remote_read" {
+		if handled := handleAdhocRemoteRead(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .remote_write <url> [metric_regex] [auth=...]
+	if strings.HasPrefix(trimmed, ".remote_write ") || trimmed == ".remote_write" {
+		if handled := handleAdhocRemoteWrite(trimmed, storage); handled {
+			return true
+		}
+	}
+
 	// Handle .prom_scrape_range <PROM_API_URI> 'query' <start> <end> <step> [count] [delay]
 	if strings.HasPrefix(trimmed, ".prom_scrape_range") {
 		if handled := handleAdhocPromScrapeRangeCommand(trimmed, storage); handled {
@@ -143,6 +411,149 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
+	// Handle .explain <query>
+	if strings.HasPrefix(trimmed, ".explain ") || trimmed == ".explain" {
+		if handled := handleAdhocExplain(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .fmt <query>
+	if strings.HasPrefix(trimmed, ".fmt ") || trimmed == ".fmt" {
+		if handled := handleAdhocFmt(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .rewrite add-matcher|strip-aggregation ...
+	if strings.HasPrefix(trimmed, ".rewrite ") || trimmed == ".rewrite" {
+		if handled := handleAdhocRewrite(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .lint <query|file.promql>
+	if strings.HasPrefix(trimmed, ".lint ") || trimmed == ".lint" {
+		if handled := handleAdhocLint(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .set [name=value], .unset <name> and .vars
+	if strings.HasPrefix(trimmed, ".set ") || trimmed == ".set" {
+		if handled := handleAdhocSet(trimmed); handled {
+			return true
+		}
+	}
+	if strings.HasPrefix(trimmed, ".unset ") || trimmed == ".unset" {
+		if handled := handleAdhocUnset(trimmed); handled {
+			return true
+		}
+	}
+	if trimmed == ".vars" {
+		if handled := handleAdhocVars(trimmed); handled {
+			return true
+		}
+	}
+
+	// Handle .define [name(param, ...) = <query>]
+	if strings.HasPrefix(trimmed, ".define ") || trimmed == ".define" {
+		if handled := handleAdhocDefine(trimmed); handled {
+			return true
+		}
+	}
+
+	// Handle .undefine <name>
+	if strings.HasPrefix(trimmed, ".undefine ") || trimmed == ".undefine" {
+		if handled := handleAdhocUndefine(trimmed); handled {
+			return true
+		}
+	}
+
+	// Handle .run name(arg, ...)
+	if strings.HasPrefix(trimmed, ".run ") || trimmed == ".run" {
+		if handled := handleAdhocRun(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .grafana_import <dashboard.json> [var=value ...]
+	if strings.HasPrefix(trimmed, ".grafana_import ") || trimmed == ".grafana_import" {
+		if handled := handleAdhocGrafanaImport(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .grafana list|run|lint
+	if strings.HasPrefix(trimmed, ".grafana ") || trimmed == ".grafana" {
+		if handled := handleAdhocGrafana(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .bench <N> <query>
+	if strings.HasPrefix(trimmed, ".bench ") || trimmed == ".bench" {
+		if handled := handleAdhocBench(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .with [timeout=<duration>] [step=<duration>] <query>
+	if strings.HasPrefix(trimmed, ".with ") || trimmed == ".with" {
+		if handled := handleAdhocWith(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .timeout [<duration> <query>]
+	if strings.HasPrefix(trimmed, ".timeout ") || trimmed == ".timeout" {
+		if handled := handleAdhocTimeout(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .top [k] [interval] <query>
+	if strings.HasPrefix(trimmed, ".top ") || trimmed == ".top" {
+		if handled := handleAdhocTop(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .watch <interval> <query>
+	if strings.HasPrefix(trimmed, ".watch ") || trimmed == ".watch" {
+		if handled := handleAdhocWatch(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .scrape_diff <url1> <url2> [metric_regex] [threshold]
+	if strings.HasPrefix(trimmed, ".scrape_diff ") || trimmed == ".scrape_diff" {
+		if handled := handleAdhocScrapeDiff(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .k8s_scrape <namespace>/<pod|svc/name> [port] [/path]
+	if strings.HasPrefix(trimmed, ".k8s_scrape ") || trimmed == ".k8s_scrape" {
+		if handled := handleAdhocK8sScrape(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .scrape_config <file.yaml>
+	if strings.HasPrefix(trimmed, ".scrape_config ") || trimmed == ".scrape_config" {
+		if handled := handleAdhocScrapeConfig(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .scrape_bg <URI> [interval] [metrics_regex] | list | stop [id|all]
+	if strings.HasPrefix(trimmed, ".scrape_bg ") || trimmed == ".scrape_bg" {
+		if handled := handleAdhocScrapeBg(trimmed, storage); handled {
+			return true
+		}
+	}
+
 	// Handle .scrape <URI> [metrics_regex] [count] [delay]
 	if strings.HasPrefix(trimmed, ".scrape ") {
 		if handled := handleAdhocScrape(trimmed, storage); handled {
@@ -157,6 +568,39 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
+	// Handle .relabel <rules>
+	if strings.HasPrefix(trimmed, ".relabel ") || trimmed == ".relabel" {
+		if handled := handleAdhocRelabel(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .join <metric> on <label> from <file> [as <info_metric>]
+	if strings.HasPrefix(trimmed, ".join ") || trimmed == ".join" {
+		if handled := handleAdhocJoin(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .let <new_metric> = <query>
+	if strings.HasPrefix(trimmed, ".let ") || trimmed == ".let" {
+		if handled := handleAdhocLet(trimmed, storage); handled {
+			return true
+		}
+	}
+
+	// Handle .shift <metric_regex> <duration> and .rescale <metric_regex> <factor>
+	if strings.HasPrefix(trimmed, ".shift ") || trimmed == ".shift" {
+		if handled := handleAdhocShift(trimmed, storage); handled {
+			return true
+		}
+	}
+	if strings.HasPrefix(trimmed, ".rescale ") || trimmed == ".rescale" {
+		if handled := handleAdhocRescale(trimmed, storage); handled {
+			return true
+		}
+	}
+
 	// Handle .rename <old_metric> <new_metric>
 	if strings.HasPrefix(trimmed, ".rename ") || trimmed == ".rename" {
 		if handled := handleAdhocRename(trimmed, storage); handled {
@@ -172,22 +616,19 @@ func handleAdHocFunction(query string, storage *sstorage.SimpleStorage) bool {
 	return false
 }
 
-// handleHelpCommand handles the .help command
-func handleHelpCommand() {
+// handleHelpCommand handles the .help command: all ad-hoc commands, or the topic given
+// (an ad-hoc command, keys or env)
+func handleHelpCommand(topic string) {
+	if topic != "" {
+		if err := helpTopic(os.Stdout, topic); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+		return
+	}
 	fmt.Println("\nAd-hoc commands:")
 	for _, cmd := range AdHocCommands {
-		fmt.Printf("  %s\n", cmd.Usage)
-		fmt.Printf("    %s\n", cmd.Description)
-		if len(cmd.Examples) > 0 {
-			if len(cmd.Examples) == 1 {
-				fmt.Printf("    Example: %s\n", cmd.Examples[0])
-			} else {
-				fmt.Println("    Examples:")
-				for _, ex := range cmd.Examples {
-					fmt.Printf("      %s\n", ex)
-				}
-			}
-		}
+		WriteAdHocCommandHelp(os.Stdout, cmd)
 	}
+	fmt.Println("\nMore: .help <command>, .help keys, .help env")
 	fmt.Println()
 }
@@ -1,5 +1,12 @@
 package repl
 
+import (
+	"fmt"
+	"strings"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
 // AdHocCommand represents an ad-hoc command with its description
 type AdHocCommand struct {
 	Command     string
@@ -13,16 +20,26 @@ type AdHocCommand struct {
 var AdHocCommands = []AdHocCommand{
 	{
 		Command:     ".help",
-		Description: "Show usage for ad-hoc commands",
-		Usage:       ".help",
+		Description: "Show usage for ad-hoc commands, one of them, the keyboard shortcuts or the environment variables",
+		Usage:       ".help [<command>|keys|env]",
+		Examples: []string{
+			".help .scrape",
+			".help keys",
+		},
 	},
 	{
 		Command:     ".ai",
-		Description: "Use AI to propose PromQL queries for your loaded metrics",
-		Usage:       ".ai <intent> | .ai ask <intent> | .ai show | .ai run <N> | .ai edit <N>",
+		Description: "Use AI to propose PromQL queries for your loaded metrics, to explain one, or to correct one that fails",
+		Usage:       ".ai <intent> | .ai ask <intent> | .ai show | .ai run <N> | .ai edit <N> | .ai explain <query>|last | .ai fix [<query>] | .ai usage [reset] | .ai redact [list|add <pattern>|remove <pattern>|clear] | .ai context show [intent]",
 		Examples: []string{
 			".ai top 5 pods by http error rate over last hour",
 			".ai cpu usage by mode per instance in 30m",
+			".ai explain sum by (job) (rate(http_requests_total[5m]))",
+			".ai explain last",
+			".ai fix",
+			".ai usage",
+			".ai redact add label:customer_id",
+			".ai context show",
 		},
 	},
 	{
@@ -31,6 +48,24 @@ var AdHocCommands = []AdHocCommand{
 		Usage:       ".labels <metric>",
 		Examples:    []string{".labels http_requests_total"},
 	},
+	{
+		Command:     ".meta",
+		Description: "Show a metric's HELP and TYPE, sample and series counts, label names and first/last timestamps (a histogram or summary name covers its _bucket, _sum and _count series)",
+		Usage:       ".meta <metric>",
+		Examples: []string{
+			".meta http_requests_total",
+			".meta http_request_duration_seconds",
+		},
+	},
+	{
+		Command:     ".values",
+		Description: "List the distinct values of a label across the store, with their series and metric counts (optionally only in metrics matching a regex)",
+		Usage:       ".values <label> [metric_regex]",
+		Examples: []string{
+			".values job",
+			".values code http_.*",
+		},
+	},
 	{
 		Command:     ".metrics",
 		Description: "List metric names in the loaded dataset",
@@ -38,19 +73,27 @@ var AdHocCommands = []AdHocCommand{
 	},
 	{
 		Command:     ".rules",
-		Description: "Show or set active Prometheus rule files (dir, glob, or file)",
-		Usage:       ".rules [<dir|glob|file>]",
+		Description: "Show or set active Prometheus rule files (dir, glob, or file), or backfill recording rules over a range",
+		Usage:       ".rules [<dir|glob|file>] | .rules deps | .rules eval <start> <end> <step>",
 		Examples: []string{
 			".rules",
 			".rules ./example-rules.yaml",
 			".rules ./rules/",
 			".rules 'rules/*.yaml'",
+			".rules deps",
+			".rules eval now-6h now 1m",
 		},
 	},
 	{
 		Command:     ".alerts",
-		Description: "Show alerting rules from active rule files",
-		Usage:       ".alerts",
+		Description: "Show alerting rules with state (inactive/pending/firing), labels, annotations and value, backtest them over a range, or push firing alerts to Alertmanager",
+		Usage:       ".alerts [filter_regex] | .alerts range <start> <end> <step> [filter_regex] | .alerts push <alertmanager-url> [filter_regex] [--dry-run]",
+		Examples: []string{
+			".alerts",
+			".alerts 'High.*'",
+			".alerts range now-6h now 1m",
+			".alerts push http://localhost:9093 --dry-run",
+		},
 	},
 	{
 		Command:     ".timestamps",
@@ -58,21 +101,80 @@ var AdHocCommands = []AdHocCommand{
 		Usage:       ".timestamps <metric>",
 		Examples:    []string{".timestamps http_requests_total"},
 	},
+	{
+		Command:     ".exemplars",
+		Description: "List stored exemplars (e.g. trace IDs from OpenMetrics input) for a metric or selector",
+		Usage:       ".exemplars <metric|selector>",
+		Examples: []string{
+			".exemplars http_requests_total",
+			".exemplars 'http_request_duration_seconds_bucket{le=\"0.5\"}'",
+		},
+	},
 	{
 		Command:     ".stats",
-		Description: "Show current store totals (metrics and samples)",
-		Usage:       ".stats",
+		Description: "Show current store totals (metrics and samples), or print engine stats (exec time, samples loaded, peak samples, series) after each query result",
+		Usage:       ".stats [on|off]",
+		Examples: []string{
+			".stats",
+			".stats on",
+		},
+	},
+	{
+		Command:     ".cardinality",
+		Description: "Report series counts per metric and label name, label value counts and top label=value pairs (like Prometheus' TSDB status page)",
+		Usage:       ".cardinality [metric_regex] [topN]",
+		Examples: []string{
+			".cardinality",
+			".cardinality 'http_.*' 20",
+		},
+	},
+	{
+		Command:     ".doctor",
+		Description: "Scan stored series for anomalies: counter resets, out-of-order timestamps, duplicate samples, large gaps, NaN/Inf values and label cardinality hotspots",
+		Usage:       ".doctor [metric_regex]",
+		Examples: []string{
+			".doctor",
+			".doctor 'http_.*'",
+		},
 	},
 	{
 		Command:     ".load",
-		Description: "Load metrics from a Prometheus text-format file",
-		Usage:       ".load <file.prom> [timestamp={now|remove|<timespec>}] [regex='<series regex>']",
+		Description: "Load metrics from a Prometheus text-format, OpenMetrics or query API JSON file",
+		Usage:       ".load <file.prom> [timestamp={now|remove|<timespec>}] [regex='<series regex>'] [stream] [max_samples=<N>]",
 		Examples: []string{
 			".load metrics.prom",
 			".load metrics.prom timestamp=now",
 			".load metrics.prom timestamp=2025-09-28T12:00:00Z",
 			".load metrics.prom timestamp=remove",
 			".load metrics.prom regex='^up\\{.*\\}$'",
+			".load big.prom stream regex='^node_cpu' max_samples=1000000",
+		},
+	},
+	{
+		Command:     ".load_json",
+		Description: "Load the series of a saved Prometheus /api/v1/query or /api/v1/query_range JSON response, keeping their timestamps",
+		Usage:       ".load_json <file.json>",
+		Examples: []string{
+			".load_json range.json",
+		},
+	},
+	{
+		Command:     ".load_influx",
+		Description: "Load an InfluxDB line protocol file: each numeric field becomes <measurement>_<field>, tags become labels",
+		Usage:       ".load_influx <file> [measurement_regex] [precision=ns|us|ms|s]",
+		Examples: []string{
+			".load_influx telegraf.lp",
+			".load_influx export.lp '^(cpu|mem)$' precision=s",
+		},
+	},
+	{
+		Command:     ".load_tsdb",
+		Description: "Load series from a Prometheus TSDB on disk (data directory, snapshot or single block), opened read-only",
+		Usage:       ".load_tsdb <dir> [selector] [start [end]]",
+		Examples: []string{
+			".load_tsdb ./snapshots/20250928T120000Z-1a2b3c",
+			".load_tsdb /prometheus/data '{job=\"api\"}' now-6h now",
+			".load_tsdb ./data/01J8ZQ4S6W0Y4M2D9E4G3T5R7X node_cpu_seconds_total",
 		},
 	},
 	{
@@ -84,15 +186,66 @@ var AdHocCommands = []AdHocCommand{
 			".source /path/to/expressions.txt",
 		},
 	},
+	{
+		Command:     ".record",
+		Description: "Record the executed commands and their output to a file, to re-run (and check) them later with promql-cli replay",
+		Usage:       ".record <file.jsonl> | .record stop | .record",
+		Examples: []string{
+			".record incident-42.jsonl",
+			".record stop",
+		},
+	},
 	{
 		Command:     ".save",
-		Description: "Save current store to a Prometheus text-format file",
-		Usage:       ".save <file.prom> [timestamp={now|remove|<timespec>}] [regex='<series regex>']",
+		Description: "Save current store to a Prometheus text-format (or OpenMetrics) file",
+		Usage:       ".save <file.prom> [timestamp={now|remove|<timespec>}] [regex='<series regex>'] [format={prom|openmetrics}]",
 		Examples: []string{
 			".save snapshot.prom",
 			".save snapshot.prom timestamp=now",
 			".save snapshot.prom timestamp=remove",
 			".save snapshot.prom regex='http_requests_total\\{.*code=\"5..\".*\\}'",
+			".save snapshot.om format=openmetrics",
+		},
+	},
+	{
+		Command:     ".export",
+		Description: "Export the whole store (all timestamps, HELP/TYPE metadata) as OpenMetrics, Prometheus text or JSON",
+		Usage:       ".export <file> [format=openmetrics|prom|json]",
+		Examples: []string{
+			".export snapshot.om",
+			".export snapshot.prom format=prom",
+			".export snapshot.json format=json",
+		},
+	},
+	{
+		Command:     ".csv",
+		Description: "Write a query result as CSV: one row per sample, a column per label plus timestamp and value (instant, or over the last range)",
+		Usage:       ".csv <file> <query> [range [step]]",
+		Examples: []string{
+			".csv up.csv up",
+			".csv cpu.csv rate(node_cpu_seconds_total[5m]) 6h 1m",
+		},
+	},
+	{
+		Command:     ".session",
+		Description: "Save or restore the store, pinned time, active rules and AI settings (~/.promql-cli/sessions)",
+		Usage:       ".session save <name> | .session load <name> | .session list",
+		Examples: []string{
+			".session save incident-42",
+			".session load incident-42",
+			".session list",
+		},
+	},
+	{
+		Command:     ".store",
+		Description: "Keep named stores side by side, e.g. two captures: list them, create one, switch the store commands and queries use, or drop one. Selectors with a __store__ matcher read from the stores it matches, labeling their series, to compare them in one query. snapshot saves a copy of the active store, and diff compares two stores (the active one by default): new and removed metrics, series count changes and value drift",
+		Usage:       ".store [list] | .store new <name> | .store use <name> | .store drop <name> | .store snapshot <name> | .store diff <a> [<b>] [metric_regex] [threshold]",
+		Examples: []string{
+			".store new staging",
+			".store use default",
+			".store snapshot before",
+			".store diff before default http_.* 10%",
+			`sum by (__store__) (rate(http_requests_total{__store__=~".+"}[5m]))`,
 		},
 	},
 	{
@@ -107,55 +260,412 @@ var AdHocCommands = []AdHocCommand{
 	{
 		Command:     ".scrape",
 		Description: "Fetch metrics from HTTP(S) endpoint",
-		Usage:       ".scrape <URI> [metrics_regex] [count] [delay]",
+		Usage:       ".scrape <URI> [metrics_regex] [count] [delay] [header='Name: value'] [bearer_token=...|bearer_token_file=...|bearer_token_env=...] [user=... pass=...|password_file=...] [ca_file=...] [cert_file=... key_file=...] [server_name=...] [insecure_skip_verify=true] [profile=name]",
 		Examples: []string{
 			".scrape http://localhost:9100/metrics",
 			".scrape http://localhost:9100/metrics '^(up|process_.*)$'",
 			".scrape http://localhost:9100/metrics 3 5s",
 			".scrape http://localhost:9100/metrics 'http_.*' 5 2s",
+			".scrape https://node:9100/metrics bearer_token_env=NODE_TOKEN ca_file=/etc/ssl/ca.pem",
+			".scrape https://app:8443/metrics cert_file=client.crt key_file=client.key header='X-Scope-OrgID: team-a'",
+			".scrape https://prod:9100/metrics profile=prod",
+		},
+	},
+	{
+		Command:     ".scrape_bg",
+		Description: "Scrape an HTTP(S) endpoint in the background on an interval, updating the store while you keep querying in the REPL; failed scrapes back off",
+		Usage:       ".scrape_bg <URI> [interval] [metrics_regex] [<.scrape auth options>] | .scrape_bg [list] | .scrape_bg stop [id|all]",
+		Examples: []string{
+			".scrape_bg http://localhost:9100/metrics",
+			".scrape_bg http://localhost:9100/metrics 5s '^node_cpu.*'",
+			".scrape_bg list",
+			".scrape_bg stop 1",
+		},
+	},
+	{
+		Command:     ".k8s_scrape",
+		Description: "Scrape an in-cluster pod or service through the Kubernetes API server proxy (kubectl get --raw, no port-forward), adding namespace and pod/service labels",
+		Usage:       ".k8s_scrape <namespace>/<pod>|<namespace>/svc/<service> [port] [/path] [context=...] [kubeconfig=...]",
+		Examples: []string{
+			".k8s_scrape monitoring/node-exporter-7xk2p",
+			".k8s_scrape default/svc/my-app 8080 /metrics",
+			".k8s_scrape kube-system/coredns-5d78c9869d-abcde 9153 context=prod",
+		},
+	},
+	{
+		Command:     ".scrape_config",
+		Description: "Scrape the static targets of a Prometheus scrape config concurrently (relabel_configs, metric_relabel_configs, scrape_interval, count)",
+		Usage:       ".scrape_config <file.yaml>",
+		Examples: []string{
+			".scrape_config scrape.yml",
+			".scrape_config /etc/prometheus/prometheus.yml",
+		},
+	},
+	{
+		Command:     ".scrape_diff",
+		Description: "Scrape two endpoints and diff them: metrics and series on one side only, label name changes, value changes beyond a threshold (default 10%)",
+		Usage:       ".scrape_diff <url1> <url2> [metric_regex] [threshold]",
+		Examples: []string{
+			".scrape_diff http://old:9100/metrics http://new:9100/metrics",
+			".scrape_diff http://old:9100/metrics http://new:9100/metrics 'node_cpu.*' 5%",
 		},
 	},
 	{
 		Command:     ".prom_scrape",
 		Description: "Query a remote Prometheus API and import the results",
-		Usage:       ".prom_scrape <PROM_API_URI> 'query' [count] [delay]",
+		Usage:       ".prom_scrape <PROM_API_URI> 'query' [count] [delay] [<.scrape auth options>] | .prom_scrape <PROM_URL>/federate 'selector, ...' [honor_labels=true|false]",
 		Examples: []string{
 			".prom_scrape http://localhost:9090/api/v1 'up'",
+			".prom_scrape https://prom.example.com 'up' bearer_token_file=/var/run/secrets/token ca_file=ca.pem",
 			".prom_scrape http://localhost:9090 'rate(http_requests_total[5m])' 3 10s",
+			".prom_scrape http://localhost:9090/federate '{job=\"node\"}, {__name__=~\"job:.*\"}' honor_labels=true",
 		},
 	},
 	{
 		Command:     ".prom_scrape_range",
 		Description: "Query a remote Prometheus API over a time range and import the results",
-		Usage:       ".prom_scrape_range <PROM_API_URI> 'query' <start> <end> <step> [count] [delay]",
+		Usage:       ".prom_scrape_range <PROM_API_URI> 'query' <start> <end> <step> [count] [delay] [<.scrape auth options>]",
 		Examples: []string{
 			".prom_scrape_range http://localhost:9090 'up' now-15m now 30s",
 			".prom_scrape_range http://localhost:9090 'rate(http_requests_total[5m])' 2025-09-27T00:00:00Z 2025-09-27T00:30:00Z 15s",
 		},
 	},
+	{
+		Command:     ".remote_read",
+		Description: "Load raw series from a Prometheus/Thanos remote_read endpoint (default range: last hour)",
+		Usage:       ".remote_read <url> <selector> [start] [end] [auth={basic|mimir}] [user=... pass=...] [org_id=... api_key=...]",
+		Examples: []string{
+			".remote_read http://localhost:9090/api/v1/read up",
+			`.remote_read http://localhost:9090/api/v1/read {job="node", __name__=~"node_cpu.*"} now-6h now`,
+		},
+	},
+	{
+		Command:     ".remote_write",
+		Description: "Push stored series to a Prometheus remote-write receiver (Prometheus, Mimir, VictoriaMetrics, Thanos)",
+		Usage:       ".remote_write <url> [metric_regex] [auth={basic|mimir}] [user=... pass=...] [org_id=... api_key=...]",
+		Examples: []string{
+			".remote_write http://localhost:9090/api/v1/write",
+			".remote_write http://mimir:8080/api/v1/push '^synthetic_.*' org_id=test",
+		},
+	},
+	{
+		Command:     ".persist",
+		Description: "Write in-memory samples to the on-disk TSDB (--storage tsdb) and show its status",
+		Usage:       ".persist",
+	},
+	{
+		Command:     ".connect",
+		Description: "Query a live Prometheus alongside local metrics, or instead of them with 'proxy' (show status without args, 'off' to disconnect)",
+		Usage:       ".connect [<prometheus-url> [proxy]|off]",
+		Examples: []string{
+			".connect http://localhost:9090",
+			".connect http://localhost:9090 proxy",
+			".connect",
+			".connect off",
+		},
+	},
 	{
 		Command:     ".drop",
-		Description: "Drop all series matching a regex (by series signature name{labels})",
-		Usage:       ".drop <series regex>",
+		Description: "Drop all series matching label matchers, or a regex on their signature name{labels}",
+		Usage:       ".drop <series regex> | .drop {<matchers>}",
 		Examples: []string{
 			".drop '^up\\{.*instance=\"db-.*\".*\\}$'",
 			".drop 'http_requests_total\\{.*code=\"5..\".*\\}'",
+			".drop {job=\"old\",__name__=~\"tmp_.*\"}",
 		},
 	},
 	{
 		Command:     ".keep",
-		Description: "Keep only series matching a regex (drop the rest)",
-		Usage:       ".keep <series regex>",
+		Description: "Keep only series matching label matchers, or a regex on their signature (drop the rest)",
+		Usage:       ".keep <series regex> | .keep {<matchers>}",
 		Examples: []string{
+			".keep {job=\"node\",__name__=~\"node_cpu.*\"}",
 			".keep '^up\\{.*job=\"node-exporter\".*\\}$'",
 			".keep 'node_cpu_seconds_total\\{.*mode=\"idle\".*\\}'",
 		},
 	},
 	{
 		Command:     ".at",
-		Description: "Evaluate a query at a specific time",
-		Usage:       ".at <time> <query>",
-		Examples:    []string{".at now-10m sum by (path) (rate(http_requests_total[5m]))"},
+		Description: "Evaluate a query at a specific time, or over a time range (a range query printing a matrix; step defaults to 1m)",
+		Usage:       ".at <time> <query> | .at <start>..<end> [step=<step>] <query>",
+		Examples: []string{
+			".at now-10m sum by (path) (rate(http_requests_total[5m]))",
+			".at now-1h..now step=30s rate(http_requests_total[5m])",
+		},
+	},
+	{
+		Command:     ".range",
+		Description: "Evaluate a range query over [start, end] at the given step (like /query_range)",
+		Usage:       ".range <start> <end> <step> <query>",
+		Examples: []string{
+			".range now-1h now 1m rate(http_requests_total[5m])",
+			".range 2025-09-16T20:00:00Z 2025-09-16T21:00:00Z 30s sum by (code) (http_requests_total)",
+		},
+	},
+	{
+		Command:     ".watch",
+		Description: "Re-run a query every interval, highlighting value changes (Ctrl-C to stop)",
+		Usage:       ".watch <interval> <query>",
+		Examples: []string{
+			".watch 5s up",
+			".watch 30s sum by (job) (rate(http_requests_total[1m]))",
+		},
+	},
+	{
+		Command:     ".with",
+		Description: "Run one query or command with another query timeout (beyond the engine's too) or range query step than the session ones",
+		Usage:       ".with [timeout=<duration>] [step=<duration>] <query>",
+		Examples: []string{
+			".with timeout=2m step=15s .at now-6h..now sum by (pod) (rate(container_cpu_usage_seconds_total[5m]))",
+			".with timeout=5m count by (__name__) ({__name__=~\".+\"})",
+		},
+	},
+	{
+		Command:     ".timeout",
+		Description: "Show the query timeout, set it for the session (as .engine set timeout), or run one query with another one (as .with timeout=)",
+		Usage:       ".timeout [<duration> [<query>]]",
+		Examples: []string{
+			".timeout 5m count by (__name__) ({__name__=~\".+\"})",
+			".timeout 2m",
+		},
+	},
+	{
+		Command:     ".top",
+		Description: "Live top-k view of a query like top(1): series sorted by value with the change since the last refresh and a trend sparkline (any key or Ctrl-C to stop; k defaults to 10, interval to 2s)",
+		Usage:       ".top [k] [interval] <query>",
+		Examples: []string{
+			".top sum by (pod) (rate(container_cpu_usage_seconds_total[1m]))",
+			".top 5 10s topk(20, rate(http_requests_total[1m]))",
+		},
+	},
+	{
+		Command:     ".graph",
+		Description: "Draw a range query as a terminal line chart with a min/max/avg legend (range defaults to 1h)",
+		Usage:       ".graph <query> [range] [step] [--no-color]",
+		Examples: []string{
+			".graph rate(http_requests_total[5m])",
+			".graph sum by (code) (rate(http_requests_total[5m])) 6h 5m --no-color",
+		},
+	},
+	{
+		Command:     ".diff",
+		Description: "Compare two queries, or one query at two times, series by series (changed, added, removed)",
+		Usage:       ".diff <queryA> ;; <queryB> | .diff @<t1> @<t2> <query>",
+		Examples: []string{
+			".diff sum by (code) (rate(http_requests_total[5m] offset 1h)) ;; sum by (code) (rate(http_requests_total[5m]))",
+			".diff @now-1h @now rate(http_requests_total[5m])",
+		},
+	},
+	{
+		Command:     ".outliers",
+		Description: "Flag series whose recent values deviate from their own baseline (robust z-score), most severe first",
+		Usage:       ".outliers <query> [z=3] [range=1h] [recent=<range/10>] [step=<auto>] [method=mad|z]",
+		Examples: []string{
+			".outliers sum by (instance) (rate(http_requests_total[5m]))",
+			".outliers node_load1 z=4 range=6h recent=30m method=z",
+		},
+	},
+	{
+		Command:     ".histogram",
+		Description: "Inspect a classic histogram: bucket bar chart, le monotonicity and _count checks, histogram_quantile at p50/p90/p95/p99",
+		Usage:       ".histogram <metric_base>[{matchers}]",
+		Examples: []string{
+			".histogram http_request_duration_seconds",
+			".histogram http_request_duration_seconds{job=\"api\"}",
+		},
+	},
+	{
+		Command:     ".explain",
+		Description: "Show the query AST with result types, range sizes and per-selector series/sample counts from the store",
+		Usage:       ".explain <query>",
+		Examples: []string{
+			".explain sum by (job) (rate(http_requests_total[5m]))",
+		},
+	},
+	{
+		Command:     ".fmt",
+		Description: "Pretty-print a query with the upstream PromQL formatter, splitting long expressions over indented lines",
+		Usage:       ".fmt <query>",
+		Examples: []string{
+			".fmt sum by (job) (rate(http_requests_total{code=~\"5..\"}[5m])) / sum by (job) (rate(http_requests_total[5m])) > 0.05",
+		},
+	},
+	{
+		Command:     ".rewrite",
+		Description: "Transform a query and print the result: add label matchers to every selector (replacing matchers on the same labels), or strip its aggregations",
+		Usage:       ".rewrite add-matcher <label><op>\"<value>\"|{<matchers>} <query> | .rewrite strip-aggregation <query>",
+		Examples: []string{
+			".rewrite add-matcher job=\"api\" sum(rate(http_requests_total[5m])) / sum(rate(http_requests_total[1h]))",
+			".rewrite add-matcher {env=\"prod\",cluster=~\"eu-.*\"} up == 0",
+			".rewrite strip-aggregation sum by (job) (rate(http_requests_total[5m]))",
+		},
+	},
+	{
+		Command:     ".lint",
+		Description: "Check a query (or each expression of a file) for common mistakes: rate() on gauges, raw counters, subquery steps, dropped labels, comparisons without bool",
+		Usage:       ".lint <query|file.promql>",
+		Examples: []string{
+			".lint sum(http_requests_total)",
+			".lint histogram_quantile(0.9, sum by (job) (rate(http_request_duration_seconds_bucket[5m])))",
+			".lint queries.promql",
+		},
+	},
+	{
+		Command:     ".set",
+		Description: "Set a REPL variable, expanded as $name or ${name} in queries and commands before parsing",
+		Usage:       ".set name=value",
+		Examples: []string{
+			".set cluster=prod",
+			`rate(http_requests_total{cluster="$cluster"}[5m])`,
+		},
+	},
+	{
+		Command:     ".unset",
+		Description: "Remove REPL variables",
+		Usage:       ".unset <name> [name ...]",
+		Examples: []string{
+			".unset cluster",
+		},
+	},
+	{
+		Command:     ".vars",
+		Description: "List REPL variables (saved with .session save)",
+		Usage:       ".vars",
+		Examples: []string{
+			".vars",
+		},
+	},
+	{
+		Command:     ".define",
+		Description: "List named queries, or define a parameterized one saved to ~/.promql-cli/queries.yaml",
+		Usage:       ".define [name(param, ...) = <query>]",
+		Examples: []string{
+			`.define errrate(job) = sum(rate(http_requests_total{job="$job",code=~"5.."}[5m]))`,
+			".define up_ratio = avg(up)",
+			".define",
+		},
+	},
+	{
+		Command:     ".undefine",
+		Description: "Remove a named query",
+		Usage:       ".undefine <name>",
+		Examples: []string{
+			".undefine errrate",
+		},
+	},
+	{
+		Command:     ".run",
+		Description: "Run a named query, binding its parameters to the given arguments",
+		Usage:       ".run name(arg, ...)",
+		Examples: []string{
+			".run errrate(api)",
+			".run up_ratio",
+		},
+	},
+	{
+		Command:     ".grafana_import",
+		Description: "Extract the PromQL targets of a Grafana dashboard JSON, substituting $__rate_interval and template variables, and save them as named queries",
+		Usage:       ".grafana_import <dashboard.json> [var=value ...]",
+		Examples: []string{
+			".grafana_import node-exporter.json",
+			".grafana_import node-exporter.json job=node instance=host1:9100 __rate_interval=5m",
+		},
+	},
+	{
+		Command:     ".grafana",
+		Description: "List, run or lint the queries of the last imported Grafana dashboard one by one",
+		Usage:       ".grafana list | run <N|name|all> | lint [N|name|all]",
+		Examples: []string{
+			".grafana list",
+			".grafana run 3",
+			".grafana lint all",
+		},
+	},
+	{
+		Command:     ".bench",
+		Description: "Run a query N times and report min/median/p95 latency, samples touched and memory",
+		Usage:       ".bench <N> <query>",
+		Examples: []string{
+			".bench 20 sum by (job) (rate(http_requests_total[5m]))",
+		},
+	},
+	{
+		Command:     ".format",
+		Description: "Show or set the output format for query results",
+		Usage:       ".format [text|json|jsonl|table|csv|tsv|markdown]",
+		Examples: []string{
+			".format",
+			".format table",
+			".format csv",
+			".format markdown",
+			".format jsonl",
+		},
+	},
+	{
+		Command:     ".columns",
+		Description: "Show or limit the label columns displayed by the table format",
+		Usage:       ".columns [keep <labels> | drop <labels> | reset]",
+		Examples: []string{
+			".columns keep job,instance",
+			".columns drop pod",
+			".columns reset",
+		},
+	},
+	{
+		Command:     ".sort",
+		Description: "Show or set the order of printed vector samples and matrix series (by value: last point for matrices)",
+		Usage:       ".sort [value|metric [asc|desc] | none]",
+		Examples: []string{
+			".sort value desc",
+			".sort metric",
+			".sort none",
+		},
+	},
+	{
+		Command:     ".groupby",
+		Description: "Show or set a label to group printed vector samples by, one section per value with its sample count (text and table formats)",
+		Usage:       ".groupby [<label>|off]",
+		Examples: []string{
+			".groupby team",
+			".groupby off",
+		},
+	},
+	{
+		Command:     ".limit",
+		Description: "Show or set the maximum number of series printed per result",
+		Usage:       ".limit [N|off]",
+		Examples: []string{
+			".limit 20",
+			".limit off",
+		},
+	},
+	{
+		Command:     ".guard",
+		Description: "Show or set the series guard: queries whose selectors touch more than N series, as estimated from the label index before they run, ask for confirmation (or only warn with warn, or when there is no terminal)",
+		Usage:       ".guard [N [warn|confirm]|off]",
+		Examples: []string{
+			".guard 100000",
+			".guard 1000000 warn",
+			".guard off",
+		},
+	},
+	{
+		Command:     ".humanize",
+		Description: "Show or set humanized values in the table format: unit inferred from the metric name (_bytes as 3.4GiB, _seconds as 1h 2m, _timestamp_seconds as times, others as 1.2M); see .time_format relative for \"2m ago\" timestamps",
+		Usage:       ".humanize [on|off]",
+		Examples: []string{
+			".humanize on",
+		},
+	},
+	{
+		Command:     ".highlight",
+		Description: "Color matches of a regex in the label values and metric names of subsequent results (text and table formats)",
+		Usage:       ".highlight [<regex>|off]",
+		Examples: []string{
+			".highlight api-7f9c.*",
+			".highlight (?i)error|timeout",
+			".highlight off",
+		},
 	},
 	{
 		Command:     ".pinat",
@@ -168,6 +678,24 @@ var AdHocCommands = []AdHocCommand{
 			".pinat remove",
 		},
 	},
+	{
+		Command:     ".tz",
+		Description: "Show or set the time zone of displayed timestamps (text and table output, .timestamps; csv, tsv and markdown stay in UTC)",
+		Usage:       ".tz [UTC|local|<location>|<offset>]",
+		Examples: []string{
+			".tz Europe/Madrid",
+			".tz local",
+			".tz +05:30",
+		},
+	},
+	{
+		Command:     ".time_format",
+		Description: "Show or set how displayed timestamps are written: RFC3339 in the .tz time zone, unix seconds, or relative to now (e.g. 5m ago)",
+		Usage:       ".time_format [rfc3339|unix|relative]",
+		Examples: []string{
+			".time_format relative",
+		},
+	},
 	{
 		Command:     ".quit",
 		Description: "Exit the REPL",
@@ -175,11 +703,99 @@ var AdHocCommands = []AdHocCommand{
 	},
 	{
 		Command:     ".history",
-		Description: "Show REPL history (all or last N entries)",
-		Usage:       ".history [N]",
+		Description: "Show REPL history (all or last N entries), search it, or clear, dedupe or export it",
+		Usage:       ".history [N] | search <regex> | clear | dedupe | export <file>",
 		Examples: []string{
 			".history",
 			".history 20",
+			".history search rate\\(.*http",
+			".history dedupe",
+			".history export queries.txt",
+		},
+	},
+	{
+		Command:     ".config",
+		Description: "Show the config file (~/.promql-cli.yaml or --config) and the settings in effect, or reload it",
+		Usage:       ".config [show|reload]",
+		Examples: []string{
+			".config",
+			".config reload",
+		},
+	},
+	{
+		Command:     ".engine",
+		Description: "Show the PromQL engine limits, or change one and rebuild the engine (e.g. for huge captures or slow machines)",
+		Usage:       ".engine [show] | .engine set <timeout|max-samples|lookback-delta> <value>",
+		Examples: []string{
+			".engine",
+			".engine set timeout 5m",
+			".engine set max-samples 200000000",
+			".engine set lookback-delta 15m",
+		},
+	},
+	{
+		Command:     ".pprof",
+		Description: "Profile promql-cli itself: record a CPU profile or trace, write heap/goroutine/... profiles, or serve net/http/pprof",
+		Usage:       ".pprof | .pprof start [cpu|trace] <file> | .pprof stop | .pprof <heap|allocs|goroutine|block|mutex|threadcreate> <file> | .pprof http <addr>|off",
+		Examples: []string{
+			".pprof start /tmp/cpu.out",
+			".pprof stop",
+			".pprof heap /tmp/heap.out",
+			".pprof http localhost:6060",
+		},
+	},
+	{
+		Command:     ".meminfo",
+		Description: "Report the memory used by the loaded samples, or compact it: samples of a series share their labels and label strings are interned",
+		Usage:       ".meminfo [compact]",
+		Examples: []string{
+			".meminfo",
+			".meminfo compact",
+		},
+	},
+	{
+		Command:     ".retention",
+		Description: "Keep only the samples within a duration of the newest one, pruning older samples now and after every scrape or import",
+		Usage:       ".retention [<duration>|off]",
+		Examples: []string{
+			".retention 2h",
+			".retention off",
+		},
+	},
+	{
+		Command:     ".downsample",
+		Description: "Reduce samples to one per series and resolution interval (the newest), optionally only those older than a duration, now and after every scrape or import",
+		Usage:       ".downsample [<resolution> [older=<duration>]|off]",
+		Examples: []string{
+			".downsample 1m",
+			".downsample 5m older=1h",
+			".downsample off",
+		},
+	},
+	{
+		Command:     ".let",
+		Description: "Store the result of a query as a new metric (replacing any metric of that name): instant queries at the pinned time or now, or every point of a .at range",
+		Usage:       ".let <new_metric> = [.at <time> | .at <start>..<end> [step=<step>]] <query>",
+		Examples: []string{
+			".let job:errors:ratio = sum by (job) (rate(errors_total[5m])) / sum by (job) (rate(requests_total[5m]))",
+			".let cpu:rate1m = .at now-1h..now step=1m rate(node_cpu_seconds_total[1m])",
+		},
+	},
+	{
+		Command:     ".shift",
+		Description: "Move the samples of the metrics matching a regex in time by a signed duration, or so that their latest sample is now",
+		Usage:       ".shift <metric_regex> <[+|-]duration|now>",
+		Examples: []string{
+			".shift node_.* +1d",
+			".shift . now",
+		},
+	},
+	{
+		Command:     ".rescale",
+		Description: "Multiply the values of the metrics matching a regex by a factor, e.g. to fix units (native histograms are refused)",
+		Usage:       ".rescale <metric_regex> <factor>",
+		Examples: []string{
+			".rescale _milliseconds$ 0.001",
 		},
 	},
 	{
@@ -191,6 +807,26 @@ var AdHocCommands = []AdHocCommand{
 			".rename old_metric_name new_metric_name",
 		},
 	},
+	{
+		Command:     ".relabel",
+		Description: "Apply Prometheus relabel_configs (replace, keep, drop, labelmap, labeldrop, hashmod, ...) to stored series",
+		Usage:       ".relabel <rules.yml|inline YAML>",
+		Examples: []string{
+			".relabel metric-relabel.yml",
+			".relabel [{action: labeldrop, regex: 'pod_template_hash'}]",
+			".relabel [{source_labels: [__name__], regex: 'go_.*', action: drop}]",
+			".relabel {source_labels: [instance], regex: '([^:]+):.*', target_label: host}",
+		},
+	},
+	{
+		Command:     ".join",
+		Description: "Add the labels of a CSV or JSON mapping file (e.g. instance to team) to the series of a metric by the value of a label, or store them as an info metric to join in queries with group_left",
+		Usage:       ".join <metric> on <label> from <file.csv|json> [as <info_metric>]",
+		Examples: []string{
+			".join node_cpu_seconds_total on instance from owners.csv",
+			".join up on instance from owners.json as instance_owner_info",
+		},
+	},
 }
 
 // GetAdHocCommandNames returns just the command names for autocompletion
@@ -211,3 +847,39 @@ func GetAdHocCommandByName(name string) *AdHocCommand {
 	}
 	return nil
 }
+
+// AdHocHandler runs an ad-hoc command line (the command and its arguments) over storage,
+// printing its output; it returns false when the line is not for it.
+type AdHocHandler func(query string, storage *sstorage.SimpleStorage) bool
+
+// registeredCommands are the handlers of the commands added with RegisterAdHocCommand.
+var registeredCommands = map[string]AdHocHandler{}
+
+// RegisterAdHocCommand adds an ad-hoc command to the REPL, its help and its completion.
+// The name must start with a dot and not be taken by another command.
+func RegisterAdHocCommand(cmd AdHocCommand, handler AdHocHandler) error {
+	if !strings.HasPrefix(cmd.Command, ".") || len(cmd.Command) < 2 || strings.ContainsAny(cmd.Command, " \t") {
+		return fmt.Errorf("invalid command name %q (expected .name)", cmd.Command)
+	}
+	if handler == nil {
+		return fmt.Errorf("command %s: nil handler", cmd.Command)
+	}
+	if GetAdHocCommandByName(cmd.Command) != nil {
+		return fmt.Errorf("command %s already exists", cmd.Command)
+	}
+	if cmd.Usage == "" {
+		cmd.Usage = cmd.Command
+	}
+	AdHocCommands = append(AdHocCommands, cmd)
+	registeredCommands[cmd.Command] = handler
+	return nil
+}
+
+// handleRegisteredCommand runs query with the registered command it names, if any.
+func handleRegisteredCommand(query string, storage *sstorage.SimpleStorage) bool {
+	name, _, _ := strings.Cut(query, " ")
+	if handler, ok := registeredCommands[name]; ok {
+		return handler(query, storage)
+	}
+	return false
+}
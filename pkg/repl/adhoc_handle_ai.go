@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 
 	ai "github.com/jjo/promql-cli/pkg/ai"
 	sstorage "github.com/jjo/promql-cli/pkg/storage"
@@ -32,11 +34,16 @@ var (
 func handleAdhocAI(query string, storage *sstorage.SimpleStorage) bool {
 	args := strings.TrimSpace(strings.TrimPrefix(query, ".ai"))
 	if args == "" || args == "help" { // help
-		fmt.Println("Usage: .ai <intent> | .ai ask <intent> | .ai show | .ai <N> | .ai run <N> | .ai edit <N>")
+		fmt.Println("Usage: .ai <intent> | .ai ask <intent> | .ai show | .ai <N> | .ai run <N> | .ai edit <N> | .ai explain <query>|last | .ai fix [<query>] | .ai usage [reset] | .ai redact [list|add <pattern>|remove <pattern>|clear] | .ai context show [intent]")
 		fmt.Println("Examples:")
 		fmt.Println("  .ai top 5 pods by http error rate over last hour")
 		fmt.Println("  .ai 1        # run suggestion [1] if available")
 		fmt.Println("  .ai show     # reprint last suggestions")
+		fmt.Println("  .ai explain last   # explain the last query and its result")
+		fmt.Println("  .ai fix      # suggest corrections of the last failed query")
+		fmt.Println("  .ai usage    # tokens and estimated cost of this session's AI requests")
+		fmt.Println("  .ai redact add 'cust-[0-9]+'   # mask matching text in prompts")
+		fmt.Println("  .ai context show   # preview the prompt sent to the AI provider")
 		return true
 	}
 	// Selection: .ai show
@@ -114,6 +121,38 @@ func handleAdhocAI(query string, storage *sstorage.SimpleStorage) bool {
 		fmt.Println("Usage: .ai edit <N>  (N is 1-based)")
 		return true
 	}
+	// Explanation: .ai explain <query> | .ai explain last
+	if args == "explain" || strings.HasPrefix(args, "explain ") {
+		return handleAdhocAIExplain(strings.TrimPrefix(args, "explain"), storage)
+	}
+	// Token usage: .ai usage [reset]
+	if args == "usage" || strings.HasPrefix(args, "usage ") {
+		return handleAdhocAIUsage(strings.TrimPrefix(args, "usage"))
+	}
+	// Redaction: .ai redact [list|add <pattern>|remove <pattern>|clear]
+	if args == "redact" || strings.HasPrefix(args, "redact ") {
+		return handleAdhocAIRedact(strings.TrimPrefix(args, "redact"))
+	}
+	// Correction: .ai fix [<query>]
+	if args == "fix" || strings.HasPrefix(args, "fix ") {
+		return handleAdhocAIFix(strings.TrimPrefix(args, "fix"), storage)
+	}
+	// Preview: .ai context show [intent]
+	if args == "context" || strings.HasPrefix(args, "context ") {
+		rest := strings.TrimSpace(strings.TrimPrefix(args, "context"))
+		if rest != "show" && !strings.HasPrefix(rest, "show ") {
+			fmt.Println("Usage: .ai context show [intent]")
+			return true
+		}
+		intent := strings.TrimSpace(strings.TrimPrefix(rest, "show"))
+		if intent == "" {
+			intent = "<intent>"
+		}
+		prompt := ai.AIPromptPreview(storage, intent)
+		fmt.Print(prompt)
+		fmt.Printf("--- %d bytes (set context_max, label_values and redact with --ai, or .ai redact) ---\n", len(prompt))
+		return true
+	}
 	// Support alias: .ai ask <intent>
 	if strings.HasPrefix(args, "ask ") {
 		args = strings.TrimSpace(strings.TrimPrefix(args, "ask "))
@@ -166,39 +205,167 @@ func handleAdhocAI(query string, storage *sstorage.SimpleStorage) bool {
 			fmt.Printf("AI error: %v\n", err)
 			return
 		}
-		var validQ []string
-		var validE []string
-		for _, sug := range suggestions {
-			q := strings.TrimSpace(sug.Query)
-			if q == "" {
-				continue
-			}
-			q = ai.CleanCandidate(q)
-			if q == "" {
-				continue
-			}
-			if _, err := promParser.ParseExpr(q); err == nil {
-				validQ = append(validQ, q)
-				validE = append(validE, strings.TrimSpace(sug.Explain))
-			}
-		}
-		if len(validQ) == 0 {
+		if !presentAISuggestions(suggestions, "") {
 			fmt.Println("AI returned no valid PromQL suggestions.")
-			return
 		}
-		lastAISuggestions = validQ
-		lastAIExplanations = validE
-		aiSelectionActive = true
-		fmt.Println("AI suggestions (valid PromQL):")
-		for i := range validQ {
-			fmt.Printf("  [%d] %s\n", i+1, validQ[i])
-			if ex := strings.TrimSpace(validE[i]); ex != "" {
-				fmt.Printf("      - %s\n", ex)
-			}
-		}
-		fmt.Println("Choose with: .ai edit <N>  or  .ai run <N>  (1-based)")
-		fmt.Println("Tips: Alt-1..Alt-9 to paste a suggestion; Ctrl-Y to paste the first suggestion.")
+		printAIUsage()
 	}(args)
 	// Return immediately to keep the prompt interactive
 	return true
 }
+
+// presentAISuggestions keeps the suggestions that are valid PromQL, other than exclude, as
+// the ones .ai run/edit and the selection menu pick from, and prints them. It reports
+// whether any was left.
+func presentAISuggestions(suggestions []ai.AISuggestion, exclude string) bool {
+	var validQ []string
+	var validE []string
+	for _, sug := range suggestions {
+		q := strings.TrimSpace(sug.Query)
+		if q == "" {
+			continue
+		}
+		q = ai.CleanCandidate(q)
+		if q == "" || q == exclude {
+			continue
+		}
+		if _, err := promParser.ParseExpr(q); err == nil {
+			validQ = append(validQ, q)
+			validE = append(validE, strings.TrimSpace(sug.Explain))
+		}
+	}
+	if len(validQ) == 0 {
+		return false
+	}
+	lastAISuggestions = validQ
+	lastAIExplanations = validE
+	aiSelectionActive = true
+	fmt.Println("AI suggestions (valid PromQL):")
+	for i := range validQ {
+		fmt.Printf("  [%d] %s\n", i+1, validQ[i])
+		if ex := strings.TrimSpace(validE[i]); ex != "" {
+			fmt.Printf("      - %s\n", ex)
+		}
+	}
+	fmt.Println("Choose with: .ai edit <N>  or  .ai run <N>  (1-based)")
+	fmt.Println("Tips: Alt-1..Alt-9 to paste a suggestion; Ctrl-Y to paste the first suggestion.")
+	return true
+}
+
+// printAIUsage prints the tokens of the last AI request and the session totals on one line.
+func printAIUsage() {
+	last, ok := ai.LastAIUsage()
+	if !ok {
+		return
+	}
+	total := sumAIUsage(ai.SessionAIUsage())
+	line := fmt.Sprintf("AI usage: %s; session: %d requests, %d tokens", ai.FormatAIUsage(last), total.Calls, total.PromptTokens+total.CompletionTokens)
+	if total.Priced {
+		line += fmt.Sprintf(", ~$%.4f", total.Cost)
+	}
+	fmt.Println(line)
+}
+
+// handleAdhocAIUsage shows the AI token usage of the session by provider and model, with
+// the cost estimated from the config file ai.pricing, or forgets it: .ai usage [reset]
+func handleAdhocAIUsage(args string) bool {
+	switch strings.TrimSpace(args) {
+	case "":
+	case "reset":
+		ai.ResetAIUsage()
+		fmt.Println("AI usage reset")
+		return true
+	default:
+		fmt.Println("Usage: .ai usage [reset]")
+		return true
+	}
+	usage := ai.SessionAIUsage()
+	if len(usage) == 0 {
+		fmt.Println("No AI requests yet")
+		return true
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	mustFprintf(tw, "PROVIDER\tMODEL\tREQUESTS\tCACHED\tPROMPT\tCOMPLETION\tCOST\n")
+	for _, u := range usage {
+		mustFprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%s\n", u.Provider, u.Model, u.Calls, u.Cached, u.PromptTokens, u.CompletionTokens, usageCost(u))
+	}
+	total := sumAIUsage(usage)
+	if len(usage) > 1 {
+		mustFprintf(tw, "total\t\t%d\t%d\t%d\t%d\t%s\n", total.Calls, total.Cached, total.PromptTokens, total.CompletionTokens, usageCost(total))
+	}
+	_ = tw.Flush()
+	if !total.Priced {
+		fmt.Println("Set model prices (USD per million tokens) in the config file ai.pricing for cost estimates")
+	}
+	return true
+}
+
+// sumAIUsage adds up usage across providers and models.
+func sumAIUsage(usage []ai.AIUsage) ai.AIUsage {
+	var total ai.AIUsage
+	for _, u := range usage {
+		total.Calls += u.Calls
+		total.Cached += u.Cached
+		total.PromptTokens += u.PromptTokens
+		total.CompletionTokens += u.CompletionTokens
+		total.Cost += u.Cost
+		total.Priced = total.Priced || u.Priced
+	}
+	return total
+}
+
+// usageCost renders the estimated cost of u, or "-" when its model has no price.
+func usageCost(u ai.AIUsage) string {
+	if !u.Priced {
+		return "-"
+	}
+	return fmt.Sprintf("$%.4f", u.Cost)
+}
+
+// handleAdhocAIRedact manages the patterns masked in AI prompts, text regexes or
+// label:<regex> for label values: .ai redact [list|add <pattern>|remove <pattern>|clear]
+func handleAdhocAIRedact(args string) bool {
+	action, pattern, _ := strings.Cut(strings.TrimSpace(args), " ")
+	pattern = strings.TrimSpace(pattern)
+	if len(pattern) >= 2 && (pattern[0] == '\'' || pattern[0] == '"') && pattern[len(pattern)-1] == pattern[0] {
+		pattern = pattern[1 : len(pattern)-1]
+	}
+	switch {
+	case (action == "" || action == "list") && pattern == "":
+		patterns := ai.RedactPatterns()
+		if len(patterns) == 0 {
+			fmt.Println("No AI redaction patterns; add one with .ai redact add <pattern>")
+			return true
+		}
+		fmt.Printf("AI redaction patterns (%d):\n", len(patterns))
+		for _, p := range patterns {
+			fmt.Printf("  %s\n", p)
+		}
+	case action == "add" && pattern != "":
+		if err := ai.AddRedactPattern(pattern); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return true
+		}
+		fmt.Printf("AI prompts will mask %s (preview with .ai context show)\n", redactDescription(pattern))
+	case action == "remove" && pattern != "":
+		if !ai.RemoveRedactPattern(pattern) {
+			fmt.Printf("Error: no AI redaction pattern %s\n", pattern)
+			return true
+		}
+		fmt.Printf("Removed AI redaction pattern %s\n", pattern)
+	case action == "clear" && pattern == "":
+		_ = ai.SetRedactPatterns(nil)
+		fmt.Println("AI redaction patterns cleared")
+	default:
+		fmt.Println("Usage: .ai redact [list|add <pattern>|remove <pattern>|clear]  (pattern: text regex, or label:<label name regex>)")
+	}
+	return true
+}
+
+// redactDescription tells what a redaction pattern masks.
+func redactDescription(pattern string) string {
+	if label, ok := strings.CutPrefix(pattern, "label:"); ok {
+		return fmt.Sprintf("the values of labels matching %s", label)
+	}
+	return fmt.Sprintf("text matching %s", pattern)
+}
@@ -0,0 +1,128 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	ai "github.com/jjo/promql-cli/pkg/ai"
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// aiExplainMaxResultLines caps the lines of the last result sent along with .ai explain last.
+const aiExplainMaxResultLines = 20
+
+// Last successfully evaluated instant query, for .ai explain last.
+var (
+	lastQueryExpr   string
+	lastQueryTime   time.Time
+	lastQueryResult *promql.Result
+)
+
+// recordLastQuery remembers a successfully evaluated query and its result.
+func recordLastQuery(expr string, t time.Time, result *promql.Result) {
+	lastQueryExpr, lastQueryTime, lastQueryResult = expr, t, result
+}
+
+// summarizeQueryResult renders result as text, keeping at most maxLines lines.
+func summarizeQueryResult(result *promql.Result, t time.Time, maxLines int) string {
+	var buf bytes.Buffer
+	PrintUpstreamQueryResultToWriter(result, &buf)
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, "Evaluated at %s:\n", t.UTC().Format(time.RFC3339))
+	for i, l := range lines {
+		if i == maxLines {
+			fmt.Fprintf(&b, "... (%d more lines)\n", len(lines)-maxLines)
+			break
+		}
+		b.WriteString(l)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// printAIExplanation prints the sections of ex, skipping empty ones.
+func printAIExplanation(expr string, ex ai.AIExplanation) {
+	fmt.Printf("Explanation of: %s\n", expr)
+	if ex.Purpose != "" {
+		fmt.Printf("\nPurpose:\n  %s\n", ex.Purpose)
+	}
+	list := func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Printf("\n%s:\n", title)
+		for _, it := range items {
+			fmt.Printf("  - %s\n", it)
+		}
+	}
+	list("Aggregated away", ex.AggregatesAway)
+	list("Pitfalls", ex.Pitfalls)
+	if ex.Result != "" {
+		fmt.Printf("\nResult:\n  %s\n", ex.Result)
+	}
+}
+
+// handleAdhocAIExplain asks the AI provider to explain a query, or the last query and its
+// result: .ai explain <query> | .ai explain last
+func handleAdhocAIExplain(args string, storage *sstorage.SimpleStorage) bool {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		fmt.Println("Usage: .ai explain <query> | .ai explain last")
+		fmt.Println("Example: .ai explain sum by (job) (rate(http_requests_total[5m]))")
+		return true
+	}
+	expr, summary := args, ""
+	if args == "last" {
+		if lastQueryExpr == "" || lastQueryResult == nil {
+			fmt.Println("No query evaluated yet. Run a query first, or use: .ai explain <query>")
+			return true
+		}
+		expr = lastQueryExpr
+		summary = summarizeQueryResult(lastQueryResult, lastQueryTime, aiExplainMaxResultLines)
+	} else {
+		if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+			expr = alertExpr
+		}
+		if _, err := promParser.ParseExpr(expr); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return true
+		}
+	}
+	if aiInProgress || aiCancelRequest != nil {
+		fmt.Println("AI request already in progress. Press Ctrl-C to cancel it.")
+		return true
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	aiCancelRequest = cancel
+	aiInProgress = true
+	fmt.Println("Asking AI to explain... (press Ctrl-C to cancel)")
+	go func() {
+		defer func() {
+			aiInProgress = false
+			aiCancelRequest = nil
+			cancel()
+		}()
+		ex, err := ai.AIExplainQueryCtx(ctx, storage, expr, summary)
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+			return
+		}
+		if err != nil {
+			fmt.Printf("AI error: %v\n", err)
+			return
+		}
+		if ex.Purpose == "" {
+			fmt.Println("AI returned no explanation.")
+			return
+		}
+		printAIExplanation(expr, ex)
+		printAIUsage()
+	}()
+	return true
+}
@@ -0,0 +1,42 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_AIExplain_Last(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	for i, code := range []string{"200", "404", "500"} {
+		store.AddSample(map[string]string{"__name__": "http_requests_total", "code": code}, float64(i+1), 1700000000000)
+	}
+	prev := replEngine
+	replEngine = newTestEngine()
+	defer func() { replEngine = prev }()
+	defer recordLastQuery("", time.Time{}, nil)
+
+	recordLastQuery("", time.Time{}, nil)
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".ai explain last", store) })
+	if !strings.Contains(out, "No query evaluated yet") {
+		t.Fatalf("expected no-last-query message, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".ai explain", store) })
+	if !strings.Contains(out, "Usage: .ai explain <query> | .ai explain last") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+
+	_ = captureStdout(t, func() { executeOne(replEngine, store, ".at 1700000000 http_requests_total") })
+	if lastQueryExpr != "http_requests_total" || lastQueryResult == nil {
+		t.Fatalf("expected last query to be recorded, got %q", lastQueryExpr)
+	}
+	summary := summarizeQueryResult(lastQueryResult, lastQueryTime, 2)
+	if !strings.HasPrefix(summary, "Evaluated at 2023-11-14T22:13:20Z:\nVector (3 samples):\n") {
+		t.Fatalf("unexpected summary header: %s", summary)
+	}
+	if !strings.HasSuffix(summary, "... (2 more lines)\n") {
+		t.Fatalf("expected truncated summary, got: %s", summary)
+	}
+}
@@ -0,0 +1,98 @@
+package repl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	ai "github.com/jjo/promql-cli/pkg/ai"
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// Last query that failed to parse or evaluate, for .ai fix.
+var (
+	lastFailedExpr  string
+	lastFailedError string
+)
+
+// recordFailedQuery remembers a query that failed with err.
+func recordFailedQuery(expr string, err error) {
+	lastFailedExpr, lastFailedError = expr, err.Error()
+}
+
+// queryError returns the error of evaluating expr as an instant query at t, nil if it runs.
+func queryError(expr string, t time.Time, storage *sstorage.SimpleStorage) error {
+	if _, err := promParser.ParseExpr(expr); err != nil {
+		return err
+	}
+	if replEngine == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replTimeout)
+	defer cancel()
+	q, err := replEngine.NewInstantQuery(ctx, QueryableFor(storage), nil, expr, t)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	return q.Exec(ctx).Err
+}
+
+// handleAdhocAIFix asks the AI provider to correct the last failed query, or the given
+// one, offering the corrections as suggestions: .ai fix [<query>]
+func handleAdhocAIFix(args string, storage *sstorage.SimpleStorage) bool {
+	expr, errText := strings.TrimSpace(args), ""
+	if expr == "" {
+		if lastFailedExpr == "" {
+			fmt.Println("No failed query yet. Use: .ai fix <query>")
+			return true
+		}
+		expr, errText = lastFailedExpr, lastFailedError
+	} else {
+		if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+			expr = alertExpr
+		}
+		err := queryError(expr, time.Now(), storage)
+		if err == nil {
+			fmt.Println("The query runs without errors, nothing to fix (see .ai explain to review it)")
+			return true
+		}
+		errText = err.Error()
+	}
+	if aiInProgress || aiCancelRequest != nil {
+		fmt.Println("AI request already in progress. Press Ctrl-C to cancel it.")
+		return true
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	aiCancelRequest = cancel
+	aiInProgress = true
+	fmt.Printf("Asking AI to fix: %s\nError: %s\n(press Ctrl-C to cancel)\n", expr, errText)
+	go func() {
+		defer func() {
+			aiInProgress = false
+			aiCancelRequest = nil
+			cancel()
+		}()
+		suggestAIFix(ctx, storage, expr, errText)
+	}()
+	return true
+}
+
+// suggestAIFix asks the AI provider to correct expr, which failed with errText, and
+// offers the corrections as suggestions. It prints nothing when ctx is canceled.
+func suggestAIFix(ctx context.Context, storage *sstorage.SimpleStorage, expr, errText string) {
+	suggestions, err := ai.AIFixQueryCtx(ctx, storage, expr, errText)
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("AI error: %v\n", err)
+		return
+	}
+	if !presentAISuggestions(suggestions, expr) {
+		fmt.Println("AI returned no valid corrections.")
+	}
+	printAIUsage()
+}
@@ -0,0 +1,71 @@
+package repl
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ai "github.com/jjo/promql-cli/pkg/ai"
+)
+
+func TestAdhoc_AIFix(t *testing.T) {
+	var prompt string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		prompt = string(body)
+		_, _ = w.Write([]byte(`{"message":{"content":"{\"answers\":[{\"query\":\"sum(rate(http_requests_total[5m]))\",\"explain\":\"closed the parenthesis\"},{\"query\":\"sum(rate(http_requests_total[5m])\",\"explain\":\"unchanged\"}]}"},"prompt_eval_count":900,"eval_count":40}`))
+	}))
+	defer srv.Close()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("PROMQL_CLI_AI", "")
+	ai.ConfigureAIComposite(map[string]string{"provider": "ollama", "host": srv.URL})
+	defer ai.ConfigureAIComposite(map[string]string{"provider": "ollama"})
+	defer func() { lastFailedExpr, lastFailedError, lastAISuggestions, aiSelectionActive = "", "", nil, false }()
+	defer ai.ResetAIUsage()
+	ai.ResetAIUsage()
+	store := newTestStore(t)
+	prev := replEngine
+	replEngine = newTestEngine()
+	defer func() { replEngine = prev }()
+
+	lastFailedExpr = ""
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".ai fix", store) })
+	if !strings.Contains(out, "No failed query yet") {
+		t.Fatalf("expected no failed query, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".ai fix sum(temperature)", store) })
+	if !strings.Contains(out, "nothing to fix") {
+		t.Fatalf("expected a working query left alone, got: %s", out)
+	}
+
+	_ = captureStdout(t, func() { executeOne(replEngine, store, "sum(rate(http_requests_total[5m])") })
+	if lastFailedExpr != "sum(rate(http_requests_total[5m])" || !strings.Contains(lastFailedError, "parse error") {
+		t.Fatalf("expected the failed query recorded, got %q: %q", lastFailedExpr, lastFailedError)
+	}
+	// .ai fix runs suggestAIFix in the background
+	out = captureStdout(t, func() { suggestAIFix(context.Background(), store, lastFailedExpr, lastFailedError) })
+	if !strings.Contains(prompt, "parse error") || !strings.Contains(prompt, "http_requests_total") {
+		t.Fatalf("expected the query, its error and metrics in the prompt, got: %s", prompt)
+	}
+	if len(lastAISuggestions) != 1 || lastAISuggestions[0] != "sum(rate(http_requests_total[5m]))" || !strings.Contains(out, "[1] sum(rate(http_requests_total[5m]))") {
+		t.Fatalf("expected the correction offered alone, got %q: %s", lastAISuggestions, out)
+	}
+	if !strings.Contains(out, "AI usage: 900 prompt + 40 completion tokens (ollama llama3.1); session: 1 requests, 940 tokens") {
+		t.Fatalf("expected the usage line, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".ai usage", store) })
+	if !strings.Contains(out, "PROVIDER") || !strings.Contains(out, "ollama") || !strings.Contains(out, "900") || !strings.Contains(out, "ai.pricing") {
+		t.Fatalf("unexpected usage table: %s", out)
+	}
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".ai usage reset", store)
+		_ = handleAdHocFunction(".ai usage", store)
+	})
+	if !strings.Contains(out, "No AI requests yet") {
+		t.Fatalf("expected the usage reset, got: %s", out)
+	}
+}
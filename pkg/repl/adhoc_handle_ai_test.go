@@ -0,0 +1,49 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+
+	ai "github.com/jjo/promql-cli/pkg/ai"
+)
+
+func TestAdhoc_AIRedact(t *testing.T) {
+	defer func() { _ = ai.SetRedactPatterns(nil) }()
+	store := newTestStore(t)
+
+	out := captureStdout(t, func() {
+		_ = handleAdHocFunction(".ai redact", store)
+		_ = handleAdHocFunction(".ai redact add label:code", store)
+		_ = handleAdHocFunction(".ai redact add 'temp[a-z]+'", store)
+		_ = handleAdHocFunction(".ai redact add (", store)
+		_ = handleAdHocFunction(".ai redact list", store)
+	})
+	for _, want := range []string{
+		"No AI redaction patterns",
+		"AI prompts will mask the values of labels matching code",
+		"AI prompts will mask text matching temp[a-z]+",
+		"Error: invalid pattern",
+		"AI redaction patterns (2):\n  label:code\n  temp[a-z]+\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q, got: %s", want, out)
+		}
+	}
+
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".ai context show sum by (code) (http_requests_total{code=\"404\"})", store)
+	})
+	if strings.Contains(out, "temperature") || strings.Contains(out, "404") || !strings.Contains(out, `code="<redacted>"`) {
+		t.Fatalf("expected the preview redacted, got: %s", out)
+	}
+
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".ai redact remove label:code", store)
+		_ = handleAdHocFunction(".ai redact remove label:code", store)
+		_ = handleAdHocFunction(".ai redact clear", store)
+		_ = handleAdHocFunction(".ai redact list", store)
+	})
+	if !strings.Contains(out, "Removed AI redaction pattern label:code") || !strings.Contains(out, "Error: no AI redaction pattern label:code") || !strings.Contains(out, "No AI redaction patterns") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
@@ -0,0 +1,142 @@
+package repl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// BenchResult summarizes repeated evaluations of one query.
+type BenchResult struct {
+	Query  string        `json:"query"`
+	Runs   int           `json:"runs"`
+	Min    time.Duration `json:"min_ns"`
+	Median time.Duration `json:"median_ns"`
+	P95    time.Duration `json:"p95_ns"`
+	Max    time.Duration `json:"max_ns"`
+	// TotalSamples and PeakSamples come from the engine's query stats for a single run.
+	TotalSamples int64 `json:"total_samples"`
+	PeakSamples  int   `json:"peak_samples"`
+	// AllocBytes is the average heap allocated per run; MaxHeapBytes the largest live heap seen after a run.
+	AllocBytes   uint64 `json:"alloc_bytes_per_run"`
+	MaxHeapBytes uint64 `json:"max_heap_bytes"`
+}
+
+// RunBench evaluates expr n times as an instant query at t and collects latency, sample and memory statistics.
+func RunBench(engine *promql.Engine, storage *sstorage.SimpleStorage, expr string, n int, t time.Time) (BenchResult, error) {
+	res := BenchResult{Query: expr, Runs: n}
+	if n <= 0 {
+		return res, fmt.Errorf("number of runs must be positive")
+	}
+	durations := make([]time.Duration, 0, n)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for range n {
+		ctx, cancel := context.WithTimeout(context.Background(), replTimeout)
+		start := time.Now()
+		q, err := engine.NewInstantQuery(ctx, QueryableFor(storage), nil, expr, t)
+		if err != nil {
+			cancel()
+			return res, err
+		}
+		r := q.Exec(ctx)
+		elapsed := time.Since(start)
+		cancel()
+		if r.Err != nil {
+			q.Close()
+			return res, r.Err
+		}
+		if st := q.Stats(); st != nil && st.Samples != nil {
+			res.TotalSamples = st.Samples.TotalSamples
+			res.PeakSamples = st.Samples.PeakSamples
+		}
+		q.Close()
+		durations = append(durations, elapsed)
+
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		res.MaxHeapBytes = max(res.MaxHeapBytes, ms.HeapAlloc)
+	}
+	runtime.ReadMemStats(&after)
+	res.AllocBytes = (after.TotalAlloc - before.TotalAlloc) / uint64(n)
+
+	slices.Sort(durations)
+	res.Min, res.Max = durations[0], durations[n-1]
+	res.Median = durations[n/2]
+	res.P95 = durations[min(n-1, (n*95+99)/100-1)]
+	return res, nil
+}
+
+// WriteBenchResults renders bench results as text or, when asJSON is set, a JSON array.
+func WriteBenchResults(w io.Writer, results []BenchResult, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	for _, r := range results {
+		if _, err := fmt.Fprintf(w, "%s\n  runs=%d min=%s median=%s p95=%s max=%s\n  samples: total=%d peak=%d  memory: alloc/run=%s max_heap=%s\n",
+			r.Query, r.Runs, r.Min, r.Median, r.P95, r.Max, r.TotalSamples, r.PeakSamples, formatBytes(r.AllocBytes), formatBytes(r.MaxHeapBytes)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatBytes renders a byte count with a binary unit suffix.
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return strconv.FormatUint(b, 10) + "B"
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// handleAdhocBench benchmarks a query: .bench <N> <query>
+func handleAdhocBench(query string, storage *sstorage.SimpleStorage) bool {
+	rest := strings.TrimSpace(strings.TrimPrefix(query, ".bench"))
+	nStr, expr, _ := strings.Cut(rest, " ")
+	expr = strings.TrimSpace(expr)
+	n, err := strconv.Atoi(nStr)
+	if err != nil || n <= 0 || expr == "" {
+		fmt.Println("Usage: " + GetAdHocCommandByName(".bench").Usage)
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+		expr = alertExpr
+	}
+	expr = normalizeAtModifierTimestamps(expr)
+
+	t := time.Now()
+	if pinnedEvalTime != nil {
+		t = *pinnedEvalTime
+	}
+	res, err := RunBench(replEngine, storage, expr, n, t)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	_ = WriteBenchResults(os.Stdout, []BenchResult{res}, false)
+	return true
+}
@@ -0,0 +1,48 @@
+package repl
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunBench_Stats(t *testing.T) {
+	store := newTestStore(t)
+	var ts int64
+	for _, ss := range store.Metrics {
+		ts = ss[0].Timestamp
+		break
+	}
+	res, err := RunBench(newTestEngine(), store, "http_requests_total", 5, time.UnixMilli(ts))
+	if err != nil {
+		t.Fatalf("RunBench: %v", err)
+	}
+	if res.Runs != 5 || res.Min > res.Median || res.Median > res.P95 || res.P95 > res.Max {
+		t.Fatalf("inconsistent latencies: %+v", res)
+	}
+	if res.TotalSamples == 0 {
+		t.Fatalf("expected samples to be counted: %+v", res)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBenchResults(&buf, []BenchResult{res}, true); err != nil {
+		t.Fatalf("WriteBenchResults: %v", err)
+	}
+	var decoded []BenchResult
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded) != 1 || decoded[0].Query != "http_requests_total" {
+		t.Fatalf("unexpected json %q: %v", buf.String(), err)
+	}
+
+	if _, err := RunBench(newTestEngine(), store, "sum(", 1, time.Now()); err == nil {
+		t.Fatalf("expected parse error")
+	}
+}
+
+func TestAdhoc_Bench_Usage(t *testing.T) {
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".bench x up", newTestStore(t)) })
+	if !strings.Contains(out, "Usage: .bench <N> <query>") {
+		t.Fatalf("expected usage, got %q", out)
+	}
+}
@@ -0,0 +1,158 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// defaultCardinalityTopN is the number of entries shown per .cardinality table.
+const defaultCardinalityTopN = 10
+
+// CardinalityEntry is a name (metric, label or label=value pair) and its count.
+type CardinalityEntry struct {
+	Name  string
+	Count int
+}
+
+// Cardinality mirrors the tables of Prometheus' /api/v1/status/tsdb for the in-memory store.
+type Cardinality struct {
+	Series                      int
+	SeriesCountByMetricName     []CardinalityEntry
+	LabelValueCountByLabelName  []CardinalityEntry
+	SeriesCountByLabelName      []CardinalityEntry
+	SeriesCountByLabelValuePair []CardinalityEntry
+}
+
+// CardinalityStats counts the distinct series of the metrics matching filter (all when nil),
+// returning the topN entries of each table sorted by count.
+func CardinalityStats(storage *sstorage.SimpleStorage, filter *regexp.Regexp, topN int) Cardinality {
+	byMetric := map[string]int{}
+	labelValues := map[string]map[string]bool{}
+	byLabel := map[string]int{}
+	byPair := map[string]int{}
+	var total int
+	for name, samples := range storage.Metrics {
+		if filter != nil && !filter.MatchString(name) {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, s := range samples {
+			key := formatSeries(s.Labels)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			total++
+			byMetric[name]++
+			for k, v := range s.Labels {
+				if labelValues[k] == nil {
+					labelValues[k] = map[string]bool{}
+				}
+				labelValues[k][v] = true
+				byLabel[k]++
+				byPair[k+"="+v]++
+			}
+		}
+	}
+	valueCounts := make(map[string]int, len(labelValues))
+	for k, vals := range labelValues {
+		valueCounts[k] = len(vals)
+	}
+	delete(byLabel, labels.MetricName)
+	delete(valueCounts, labels.MetricName)
+	for k := range byPair {
+		if strings.HasPrefix(k, labels.MetricName+"=") {
+			delete(byPair, k)
+		}
+	}
+	return Cardinality{
+		Series:                      total,
+		SeriesCountByMetricName:     topCardinalityEntries(byMetric, topN),
+		LabelValueCountByLabelName:  topCardinalityEntries(valueCounts, topN),
+		SeriesCountByLabelName:      topCardinalityEntries(byLabel, topN),
+		SeriesCountByLabelValuePair: topCardinalityEntries(byPair, topN),
+	}
+}
+
+// topCardinalityEntries returns the n largest counts, ties broken by name.
+func topCardinalityEntries(counts map[string]int, n int) []CardinalityEntry {
+	out := make([]CardinalityEntry, 0, len(counts))
+	for name, c := range counts {
+		out = append(out, CardinalityEntry{Name: name, Count: c})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// Print writes one table per dimension, with each entry's share of all series.
+func (c Cardinality) Print(w io.Writer) {
+	mustFprintf(w, "Total series: %d\n", c.Series)
+	table := func(title, col, countCol string, entries []CardinalityEntry, share bool) {
+		mustFprintf(w, "\n%s:\n", title)
+		if len(entries) == 0 {
+			mustFprintln(w, "  (none)")
+			return
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		if share {
+			mustFprintf(tw, "  %s\t%s\t%%\n", col, countCol)
+		} else {
+			mustFprintf(tw, "  %s\t%s\n", col, countCol)
+		}
+		for _, e := range entries {
+			if share && c.Series > 0 {
+				mustFprintf(tw, "  %s\t%d\t%.1f\n", e.Name, e.Count, 100*float64(e.Count)/float64(c.Series))
+			} else {
+				mustFprintf(tw, "  %s\t%d\n", e.Name, e.Count)
+			}
+		}
+		_ = tw.Flush()
+	}
+	table("Series count by metric name", "METRIC", "SERIES", c.SeriesCountByMetricName, true)
+	table("Label value count by label name", "LABEL", "VALUES", c.LabelValueCountByLabelName, false)
+	table("Series count by label name", "LABEL", "SERIES", c.SeriesCountByLabelName, true)
+	table("Series count by label value pair", "LABEL=VALUE", "SERIES", c.SeriesCountByLabelValuePair, true)
+}
+
+// handleAdhocCardinality reports series cardinality: .cardinality [metric_regex] [topN]
+func handleAdhocCardinality(query string, storage *sstorage.SimpleStorage) bool {
+	args := strings.Fields(strings.TrimSpace(strings.TrimPrefix(query, ".cardinality")))
+	if len(args) > 2 {
+		fmt.Println("Usage: " + GetAdHocCommandByName(".cardinality").Usage)
+		return true
+	}
+	topN := defaultCardinalityTopN
+	var filter *regexp.Regexp
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+			topN = n
+			continue
+		}
+		re, err := regexp.Compile(strings.Trim(arg, "\"'"))
+		if err != nil {
+			fmt.Printf("Invalid metric_regex %q: %v\n", arg, err)
+			return true
+		}
+		filter = re
+	}
+	CardinalityStats(storage, filter, topN).Print(os.Stdout)
+	return true
+}
@@ -0,0 +1,39 @@
+package repl
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Cardinality(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	for i := 0; i < 6; i++ {
+		for _, ts := range []int64{0, 15000} {
+			store.AddSample(map[string]string{"__name__": "reqs_total", "path": "/p" + strconv.Itoa(i), "job": "api"}, 1, ts)
+		}
+	}
+	store.AddSample(map[string]string{"__name__": "up", "job": "api"}, 1, 0)
+	store.AddSample(map[string]string{"__name__": "up", "job": "db"}, 1, 0)
+
+	c := CardinalityStats(store, nil, 2)
+	if c.Series != 8 {
+		t.Fatalf("expected 8 series, got %d", c.Series)
+	}
+	if got := c.SeriesCountByMetricName; len(got) != 2 || got[0] != (CardinalityEntry{"reqs_total", 6}) || got[1] != (CardinalityEntry{"up", 2}) {
+		t.Fatalf("unexpected series by metric: %v", got)
+	}
+	if got := c.LabelValueCountByLabelName; got[0] != (CardinalityEntry{"path", 6}) || got[1] != (CardinalityEntry{"job", 2}) {
+		t.Fatalf("unexpected label value counts: %v", got)
+	}
+	if got := c.SeriesCountByLabelValuePair; len(got) != 2 || got[0] != (CardinalityEntry{"job=api", 7}) {
+		t.Fatalf("unexpected label pairs: %v", got)
+	}
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".cardinality up 5", store) })
+	if !strings.Contains(out, "Total series: 2") || strings.Contains(out, "reqs_total") || !strings.Contains(out, "job=db") {
+		t.Fatalf("unexpected .cardinality output:\n%s", out)
+	}
+}
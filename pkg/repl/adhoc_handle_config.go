@@ -0,0 +1,323 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"go.yaml.in/yaml/v2"
+
+	ai "github.com/jjo/promql-cli/pkg/ai"
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// Config is the promql-cli config file (~/.promql-cli.yaml): defaults for the command-line
+// flags and REPL settings. Flags and environment variables take precedence over it.
+type Config struct {
+	Repl   string `yaml:"repl,omitempty"`   // REPL backend: prompt|readline
+	Output string `yaml:"output,omitempty"` // result output format, as -o
+	Color  string `yaml:"color,omitempty"`  // auto|always|never, as --color
+	// TZ and TimeFormat are the time zone and format of displayed timestamps, as --tz and --time-format.
+	TZ         string `yaml:"tz,omitempty"`
+	TimeFormat string `yaml:"time_format,omitempty"`
+	// ConfirmLines is the number of lines above which matrix results are printed only after
+	// confirmation: 0 keeps the default (5000), -1 never asks.
+	ConfirmLines int          `yaml:"confirm_lines,omitempty"`
+	AI           ConfigAI     `yaml:"ai,omitempty"`
+	Engine       ConfigEngine `yaml:"engine,omitempty"`
+	// ScrapeURLs are offered first when completing .scrape and .prom_scrape URLs.
+	ScrapeURLs []string `yaml:"scrape_urls,omitempty"`
+	// ScrapeProfiles hold the headers, credentials and TLS options of the scrape commands.
+	ScrapeProfiles map[string]ScrapeAuth `yaml:"scrape_profiles,omitempty"`
+	Keys           ConfigKeys            `yaml:"keys,omitempty"`
+	// Hooks are shell commands run on REPL events, see ConfigHooks.
+	Hooks ConfigHooks `yaml:"hooks,omitempty"`
+}
+
+// ConfigAI holds AI provider profiles, in the keys accepted by --ai (provider, model,
+// base, answers, ...), the profile used by default, the prices of models for the cost
+// estimates of .ai usage, and the patterns masked in prompts, as .ai redact add takes them.
+type ConfigAI struct {
+	Profile  string                       `yaml:"profile,omitempty"`
+	Profiles map[string]map[string]string `yaml:"profiles,omitempty"`
+	Pricing  map[string]ai.ModelPrice     `yaml:"pricing,omitempty"` // by model, USD per million tokens
+	Redact   []string                     `yaml:"redact,omitempty"`
+}
+
+// ConfigEngine holds PromQL engine limits; zero values keep the built-in defaults.
+type ConfigEngine struct {
+	Timeout       model.Duration `yaml:"timeout,omitempty"`
+	MaxSamples    int            `yaml:"max_samples,omitempty"`
+	LookbackDelta model.Duration `yaml:"lookback_delta,omitempty"`
+}
+
+// ConfigKeys holds keybinding and completion tweaks. Each one defaults the environment
+// variable named in its comment, which still overrides it.
+type ConfigKeys struct {
+	AltDotKey                string `yaml:"alt_dot_key,omitempty"`                 // PROMQL_CLI_ALT_DOT_KEY
+	EagerCompletion          *bool  `yaml:"eager_completion,omitempty"`            // PROMQL_CLI_EAGER_COMPLETION
+	CompletionAutoBrace      *bool  `yaml:"completion_auto_brace,omitempty"`       // PROMQL_CLI_COMPLETION_AUTO_BRACE
+	CompletionLabelEquals    *bool  `yaml:"completion_label_equals,omitempty"`     // PROMQL_CLI_COMPLETION_LABEL_EQUALS
+	CompletionAutoCloseQuote *bool  `yaml:"completion_auto_close_quote,omitempty"` // PROMQL_CLI_COMPLETION_AUTO_CLOSE_QUOTE
+	SyntaxHighlight          *bool  `yaml:"syntax_highlight,omitempty"`            // PROMQL_CLI_SYNTAX_HIGHLIGHT
+}
+
+var (
+	// activeConfig is the applied config file (empty when there is none), read from
+	// configPath; configRequired is set when the path was given explicitly.
+	activeConfig   = &Config{}
+	configPath     string
+	configRequired bool
+	// configEnvDefaults maps environment variables to the values the config keys give them.
+	configEnvDefaults = map[string]string{}
+	// favoriteScrapeURLs are the config scrape_urls, offered first by URL completion.
+	favoriteScrapeURLs []string
+)
+
+// DefaultConfigPath returns $PROMQL_CLI_CONFIG, or ~/.promql-cli.yaml.
+func DefaultConfigPath() string {
+	if p := strings.TrimSpace(os.Getenv("PROMQL_CLI_CONFIG")); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".promql-cli.yaml"
+	}
+	return filepath.Join(home, ".promql-cli.yaml")
+}
+
+// LoadConfig reads and validates the config file at path. A missing file yields an empty
+// Config unless required is set (the path was given with --config).
+func LoadConfig(path string, required bool) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && !required {
+			return &Config{}, nil
+		}
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	switch strings.ToLower(c.Repl) {
+	case "", "prompt", "readline":
+	default:
+		return fmt.Errorf("repl: unknown backend %q (expected prompt|readline)", c.Repl)
+	}
+	if c.Output != "" {
+		if _, ok := formatters[strings.ToLower(c.Output)]; !ok {
+			return fmt.Errorf("output: unknown format %q (available: %s)", c.Output, strings.Join(FormatNames(), ", "))
+		}
+	}
+	switch strings.ToLower(c.Color) {
+	case "", "auto", "always", "never":
+	default:
+		return fmt.Errorf("color: invalid mode %q (expected auto|always|never)", c.Color)
+	}
+	if c.TZ != "" {
+		if _, err := parseLocation(c.TZ); err != nil {
+			return fmt.Errorf("tz: %w", err)
+		}
+	}
+	switch strings.ToLower(c.TimeFormat) {
+	case "", "rfc3339", "unix", "relative":
+	default:
+		return fmt.Errorf("time_format: invalid format %q (expected rfc3339|unix|relative)", c.TimeFormat)
+	}
+	if c.ConfirmLines < -1 {
+		return errors.New("confirm_lines must be positive, 0 for the default or -1 to never ask")
+	}
+	for model, p := range c.AI.Pricing {
+		if p.Input < 0 || p.Output < 0 {
+			return fmt.Errorf("ai: pricing: %s: prices must not be negative", model)
+		}
+	}
+	for _, p := range c.AI.Redact {
+		if err := ai.CheckRedactPattern(p); err != nil {
+			return fmt.Errorf("ai: redact: %w", err)
+		}
+	}
+	if c.Engine.MaxSamples < 0 {
+		return errors.New("engine: max_samples must not be negative")
+	}
+	if err := c.Hooks.validate(); err != nil {
+		return fmt.Errorf("hooks: %w", err)
+	}
+	for name, p := range c.ScrapeProfiles {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("scrape_profiles: %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// EngineOpts returns opts with the config engine limits applied.
+func (c *Config) EngineOpts(opts promql.EngineOpts) promql.EngineOpts {
+	if c.Engine.Timeout > 0 {
+		opts.Timeout = time.Duration(c.Engine.Timeout)
+	}
+	if c.Engine.MaxSamples > 0 {
+		opts.MaxSamples = c.Engine.MaxSamples
+	}
+	if c.Engine.LookbackDelta > 0 {
+		opts.LookbackDelta = time.Duration(c.Engine.LookbackDelta)
+	}
+	return opts
+}
+
+// env returns the environment variables the keys default, by name.
+func (k ConfigKeys) env() map[string]string {
+	env := map[string]string{}
+	if k.AltDotKey != "" {
+		env["PROMQL_CLI_ALT_DOT_KEY"] = k.AltDotKey
+	}
+	for name, b := range map[string]*bool{
+		"PROMQL_CLI_EAGER_COMPLETION":            k.EagerCompletion,
+		"PROMQL_CLI_COMPLETION_AUTO_BRACE":       k.CompletionAutoBrace,
+		"PROMQL_CLI_COMPLETION_LABEL_EQUALS":     k.CompletionLabelEquals,
+		"PROMQL_CLI_COMPLETION_AUTO_CLOSE_QUOTE": k.CompletionAutoCloseQuote,
+		"PROMQL_CLI_SYNTAX_HIGHLIGHT":            k.SyntaxHighlight,
+	} {
+		if b != nil {
+			env[name] = strconv.FormatBool(*b)
+		}
+	}
+	return env
+}
+
+// settingEnv returns the environment variable name or, when unset, the value the config
+// file keys give it.
+func settingEnv(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return configEnvDefaults[name]
+}
+
+// InitConfig loads the config file at path (see LoadConfig), applies its REPL settings and
+// remembers it for .config reload. Command-line flags applied afterwards override it.
+func InitConfig(path string, required bool) (*Config, error) {
+	cfg, err := LoadConfig(path, required)
+	if err != nil {
+		return nil, err
+	}
+	configPath, configRequired = path, required
+	applyConfig(cfg)
+	return cfg, nil
+}
+
+// applyConfig makes cfg the active config. Settings it leaves empty keep their value.
+func applyConfig(cfg *Config) {
+	activeConfig = cfg
+	if cfg.Output != "" {
+		_ = SetOutputFormat(cfg.Output)
+	}
+	if cfg.Color != "" {
+		_ = SetColorMode(cfg.Color)
+	}
+	if cfg.TZ != "" {
+		_ = SetTimeZone(cfg.TZ)
+	}
+	if cfg.TimeFormat != "" {
+		_ = SetTimeFormat(cfg.TimeFormat)
+	}
+	switch {
+	case cfg.ConfirmLines > 0:
+		confirmLines = cfg.ConfirmLines
+	case cfg.ConfirmLines < 0:
+		confirmLines = 0
+	}
+	favoriteScrapeURLs = cfg.ScrapeURLs
+	configEnvDefaults = cfg.Keys.env()
+	ai.SetConfigProfiles(cfg.AI.Profiles, cfg.AI.Profile)
+	ai.SetModelPricing(cfg.AI.Pricing)
+	_ = ai.SetRedactPatterns(cfg.AI.Redact)
+}
+
+// handleAdhocConfig shows or reloads the config file: .config [show|reload]
+func handleAdhocConfig(query string, storage *sstorage.SimpleStorage) bool {
+	switch arg := strings.TrimSpace(strings.TrimPrefix(query, ".config")); arg {
+	case "", "show":
+		printConfig()
+	case "reload":
+		reloadConfig()
+	default:
+		cmd := GetAdHocCommandByName(".config")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+	}
+	return true
+}
+
+// printConfig prints the config file path and contents, and the settings in effect.
+func printConfig() {
+	path := configPath
+	if path == "" {
+		path = DefaultConfigPath()
+	}
+	if _, err := os.Stat(path); err != nil {
+		fmt.Printf("Config file: %s (not found, using defaults)\n", path)
+	} else {
+		fmt.Printf("Config file: %s\n", path)
+	}
+	if data, err := yaml.Marshal(activeConfig); err == nil && string(data) != "{}\n" {
+		fmt.Print(string(data))
+	}
+	provider := ai.CurrentAIConfig()["provider"]
+	if provider == "" {
+		provider = "none"
+	}
+	fmt.Printf("In effect: output=%s color=%s timeout=%s ai=%s\n", outputFormat, colorMode, replTimeout, provider)
+}
+
+// reloadConfig re-reads the config file and applies it, rebuilding the engine when its
+// limits changed. The REPL backend and keys only take effect on restart.
+func reloadConfig() {
+	path := configPath
+	if path == "" {
+		path = DefaultConfigPath()
+	}
+	cfg, err := LoadConfig(path, configRequired)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	prev := activeConfig
+	applyConfig(cfg)
+	if !reflect.DeepEqual(prev.AI, cfg.AI) {
+		ai.ReconfigureAI()
+	}
+	fmt.Printf("Reloaded %s\n", path)
+	if prev.Engine != cfg.Engine && replEngine != nil {
+		rebuildEngine(cfg.EngineOpts(engineOpts))
+		fmt.Printf("Engine rebuilt: %s\n", formatEngineOpts(engineOpts))
+	}
+	var restart []string
+	if prev.Repl != cfg.Repl {
+		restart = append(restart, "repl")
+	}
+	if !reflect.DeepEqual(prev.Keys, cfg.Keys) {
+		restart = append(restart, "keys")
+	}
+	if len(restart) > 0 {
+		fmt.Printf("Restart promql-cli to apply the %s settings\n", strings.Join(restart, ", "))
+	}
+}
@@ -0,0 +1,135 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	if cfg, err := LoadConfig(filepath.Join(dir, "missing.yaml"), false); err != nil || cfg.Repl != "" {
+		t.Fatalf("missing default config: cfg=%+v err=%v", cfg, err)
+	}
+	if _, err := LoadConfig(filepath.Join(dir, "missing.yaml"), true); err == nil {
+		t.Fatal("expected an error for a missing --config file")
+	}
+
+	write := func(content string) string {
+		path := filepath.Join(dir, "config.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	cfg, err := LoadConfig(write(`
+repl: prompt
+output: table
+ai:
+  profile: work
+  profiles:
+    work: {provider: claude, model: opus, answers: 3}
+  pricing:
+    opus: {input: 15, output: 75}
+  redact: ['cust-[0-9]+', 'label:tenant']
+engine:
+  timeout: 2m
+  max_samples: 1000
+scrape_urls: [http://node:9100/metrics]
+keys:
+  alt_dot_key: "174"
+  completion_auto_brace: false
+`), true)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Repl != "prompt" || cfg.Output != "table" || cfg.AI.Profiles["work"]["answers"] != "3" || cfg.AI.Pricing["opus"].Output != 75 || len(cfg.AI.Redact) != 2 || len(cfg.ScrapeURLs) != 1 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	opts := cfg.EngineOpts(promql.EngineOpts{Timeout: 30 * time.Second, MaxSamples: 50000000, LookbackDelta: 5 * time.Minute})
+	if opts.Timeout != 2*time.Minute || opts.MaxSamples != 1000 || opts.LookbackDelta != 5*time.Minute {
+		t.Fatalf("unexpected engine opts: %+v", opts)
+	}
+	env := cfg.Keys.env()
+	if env["PROMQL_CLI_ALT_DOT_KEY"] != "174" || env["PROMQL_CLI_COMPLETION_AUTO_BRACE"] != "false" || len(env) != 2 {
+		t.Fatalf("unexpected keys env: %v", env)
+	}
+
+	for content, want := range map[string]string{
+		"outptu: json\n":                     "field outptu not found",
+		"output: xml\n":                      "unknown format",
+		"repl: emacs\n":                      "unknown backend",
+		"engine: {max_samples: -1}":          "must not be negative",
+		"tz: Mars/Olympus\n":                 "unknown time zone",
+		"time_format: iso\n":                 "invalid format",
+		"confirm_lines: -5\n":                "confirm_lines must be positive",
+		"hooks: {after_query: ['']}":         "hooks: after_query: empty command",
+		"ai: {pricing: {opus: {input: -1}}}": "prices must not be negative",
+		"ai: {redact: ['label:(']}":          "ai: redact: invalid label name pattern",
+	} {
+		if _, err := LoadConfig(write(content), true); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", content, want, err)
+		}
+	}
+}
+
+func TestAdhocConfig_ShowReload(t *testing.T) {
+	oldFormat, oldTimeout, oldConfig, oldEnv := outputFormat, replTimeout, activeConfig, configEnvDefaults
+	oldEngine, oldOpts := replEngine, engineOpts
+	defer func() {
+		outputFormat, replTimeout = oldFormat, oldTimeout
+		replEngine, engineOpts = oldEngine, oldOpts
+		configPath, configRequired = "", false
+		applyConfig(oldConfig)
+		configEnvDefaults = oldEnv
+	}()
+	t.Setenv("PROMQL_CLI_COMPLETION_LABEL_EQUALS", "")
+	NewEngine(promql.EngineOpts{MaxSamples: 1000, Timeout: 30 * time.Second, LookbackDelta: 5 * time.Minute})
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("output: csv\nkeys: {completion_label_equals: false}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InitConfig(path, true); err != nil {
+		t.Fatalf("InitConfig: %v", err)
+	}
+	if outputFormat != "csv" || getEnvBool("PROMQL_CLI_COMPLETION_LABEL_EQUALS", true) {
+		t.Fatalf("config not applied: format=%s", outputFormat)
+	}
+	t.Setenv("PROMQL_CLI_COMPLETION_LABEL_EQUALS", "1")
+	if !getEnvBool("PROMQL_CLI_COMPLETION_LABEL_EQUALS", false) {
+		t.Fatal("environment should override the config keys")
+	}
+
+	store := sstorage.NewSimpleStorage()
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".config", store) })
+	if !strings.Contains(out, "Config file: "+path) || !strings.Contains(out, "output: csv") || !strings.Contains(out, "In effect: output=csv") {
+		t.Fatalf("unexpected .config output: %s", out)
+	}
+
+	if err := os.WriteFile(path, []byte("output: json\nengine: {timeout: 5s}\nscrape_urls: [http://app:8080/metrics]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".config reload", store) })
+	if !strings.Contains(out, "Reloaded "+path) || !strings.Contains(out, "Engine rebuilt: timeout=5s max-samples=1000") ||
+		!strings.Contains(out, "apply the keys settings") {
+		t.Fatalf("unexpected reload output: %s", out)
+	}
+	if outputFormat != "json" || replTimeout != 5*time.Second || len(favoriteScrapeURLs) != 1 {
+		t.Fatalf("reload not applied: format=%s timeout=%s urls=%v", outputFormat, replTimeout, favoriteScrapeURLs)
+	}
+
+	if err := os.WriteFile(path, []byte("output: [\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".config reload", store) })
+	if !strings.HasPrefix(out, "Error: config "+path) || outputFormat != "json" {
+		t.Fatalf("invalid config should be reported and not applied: %s", out)
+	}
+}
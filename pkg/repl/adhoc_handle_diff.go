@@ -0,0 +1,215 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// diffQuerySeparator separates the two expressions of .diff <queryA> ;; <queryB>.
+const diffQuerySeparator = ";;"
+
+// QueryDiff is the per-series difference between two instant query results, A and B.
+type QueryDiff struct {
+	Changed        []ScrapeDiffValue // series whose value differs, largest relative change first
+	Removed, Added []string          // "series => value" present only in A / only in B
+	Unchanged      int
+}
+
+// DiffVectors matches the series of a and b by their labels and compares their values.
+// Series left unmatched are then paired by their labels without the metric name, when that
+// is unambiguous, since e.g. "x * 2" drops the name of "x". Native histogram samples are
+// compared by presence only.
+func DiffVectors(a, b promql.Vector) QueryDiff {
+	index := func(v promql.Vector) map[string]promql.Sample {
+		out := make(map[string]promql.Sample, len(v))
+		for _, s := range v {
+			out[formatSeries(s.Metric.Map())] = s
+		}
+		return out
+	}
+	sampleValue := func(s promql.Sample) string {
+		if s.H != nil {
+			return s.H.String()
+		}
+		return strconv.FormatFloat(s.F, 'g', -1, 64)
+	}
+	ia, ib := index(a), index(b)
+	var d QueryDiff
+	compare := func(key string, sa, sb promql.Sample) {
+		if sa.H == nil && sb.H == nil {
+			if rel := relativeDelta(sa.F, sb.F); rel > 0 {
+				d.Changed = append(d.Changed, ScrapeDiffValue{Series: key, A: sa.F, B: sb.F, Rel: rel})
+				return
+			}
+		}
+		d.Unchanged++
+	}
+	onlyA, onlyB := map[string]promql.Sample{}, map[string]promql.Sample{}
+	for key, sa := range ia {
+		if sb, ok := ib[key]; ok {
+			compare(key, sa, sb)
+		} else {
+			onlyA[key] = sa
+		}
+	}
+	for key, sb := range ib {
+		if _, ok := ia[key]; !ok {
+			onlyB[key] = sb
+		}
+	}
+	byLabels := func(m map[string]promql.Sample) map[string][]string {
+		out := map[string][]string{}
+		for key, s := range m {
+			k := s.Metric.DropMetricName().String()
+			out[k] = append(out[k], key)
+		}
+		return out
+	}
+	la, lb := byLabels(onlyA), byLabels(onlyB)
+	for k, keysA := range la {
+		if keysB := lb[k]; len(keysA) == 1 && len(keysB) == 1 {
+			compare(keysA[0], onlyA[keysA[0]], onlyB[keysB[0]])
+			delete(onlyA, keysA[0])
+			delete(onlyB, keysB[0])
+		}
+	}
+	for key, s := range onlyA {
+		d.Removed = append(d.Removed, key+" => "+sampleValue(s))
+	}
+	for key, s := range onlyB {
+		d.Added = append(d.Added, key+" => "+sampleValue(s))
+	}
+	sort.Strings(d.Removed)
+	sort.Strings(d.Added)
+	sort.Slice(d.Changed, func(i, j int) bool {
+		if d.Changed[i].Rel != d.Changed[j].Rel {
+			return d.Changed[i].Rel > d.Changed[j].Rel
+		}
+		return d.Changed[i].Series < d.Changed[j].Series
+	})
+	return d
+}
+
+// Print writes the changed series as a table, then the removed and added series.
+func (d QueryDiff) Print(w io.Writer) {
+	if len(d.Changed) > 0 {
+		mustFprintf(w, "\nChanged (%d):\n", len(d.Changed))
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		mustFprintln(tw, "  SERIES\tA\tB\tDELTA\tCHANGE")
+		for _, v := range d.Changed {
+			mustFprintf(tw, "  %s\t%s\t%s\t%s\t%+.1f%%\n", v.Series,
+				strconv.FormatFloat(v.A, 'g', -1, 64), strconv.FormatFloat(v.B, 'g', -1, 64),
+				strconv.FormatFloat(v.B-v.A, 'g', 6, 64), signedPercent(v))
+		}
+		_ = tw.Flush()
+	}
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		mustFprintf(w, "\n%s (%d):\n", title, len(lines))
+		for _, l := range lines {
+			mustFprintf(w, "  %s\n", l)
+		}
+	}
+	section("Removed (only in A)", d.Removed)
+	section("Added (only in B)", d.Added)
+	mustFprintf(w, "\n%d changed, %d unchanged, %d removed, %d added\n", len(d.Changed), d.Unchanged, len(d.Removed), len(d.Added))
+}
+
+// evalInstantVector evaluates expr at ts, returning scalars as a single label-less sample.
+func evalInstantVector(storage *sstorage.SimpleStorage, expr string, ts time.Time) (promql.Vector, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), replTimeout)
+	defer cancel()
+	q, err := replEngine.NewInstantQuery(ctx, QueryableFor(storage), nil, expr, ts)
+	if err != nil {
+		return nil, err
+	}
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	switch v := res.Value.(type) {
+	case promql.Vector:
+		return v, nil
+	case promql.Scalar:
+		return promql.Vector{{T: v.T, F: v.V}}, nil
+	default:
+		return nil, fmt.Errorf("%s returns a %s; .diff compares instant vectors and scalars", expr, res.Value.Type())
+	}
+}
+
+// parseDiffArgs parses "<queryA> ;; <queryB>" (both at evalTime) or "@t1 @t2 <query>".
+func parseDiffArgs(rest string, evalTime time.Time) (exprA, exprB string, tA, tB time.Time, err error) {
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, "@") {
+		fields := strings.Fields(rest)
+		if len(fields) < 3 || !strings.HasPrefix(fields[1], "@") {
+			return "", "", tA, tB, fmt.Errorf("expected @t1 @t2 <query>")
+		}
+		if tA, err = parseEvalTime(fields[0][1:]); err != nil {
+			return "", "", tA, tB, fmt.Errorf("invalid time %q: %w", fields[0], err)
+		}
+		if tB, err = parseEvalTime(fields[1][1:]); err != nil {
+			return "", "", tA, tB, fmt.Errorf("invalid time %q: %w", fields[1], err)
+		}
+		expr := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(rest, fields[0])), fields[1]))
+		return expr, expr, tA, tB, nil
+	}
+	a, b, ok := strings.Cut(rest, diffQuerySeparator)
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if !ok || a == "" || b == "" {
+		return "", "", tA, tB, fmt.Errorf("expected <queryA> %s <queryB>", diffQuerySeparator)
+	}
+	return a, b, evalTime, evalTime, nil
+}
+
+// handleAdhocDiff compares two queries, or one query at two times, series by series:
+// .diff <queryA> ;; <queryB> | .diff @t1 @t2 <query>
+func handleAdhocDiff(query string, storage *sstorage.SimpleStorage) bool {
+	evalTime := time.Now()
+	if pinnedEvalTime != nil {
+		evalTime = *pinnedEvalTime
+	}
+	exprA, exprB, tA, tB, err := parseDiffArgs(strings.TrimPrefix(query, ".diff"), evalTime)
+	if err != nil {
+		cmd := GetAdHocCommandByName(".diff")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	exprs := [2]string{exprA, exprB}
+	times := [2]time.Time{tA, tB}
+	var vectors [2]promql.Vector
+	for i := range exprs {
+		if alertExpr := GetAlertExpr(exprs[i]); alertExpr != "" {
+			exprs[i] = alertExpr
+		}
+		exprs[i] = normalizeAtModifierTimestamps(exprs[i])
+		if vectors[i], err = evalInstantVector(storage, exprs[i], times[i]); err != nil {
+			fmt.Printf("Error in %c: %v\n", 'A'+i, err)
+			return true
+		}
+	}
+	fmt.Printf("A: %s @ %s (%d series)\n", exprs[0], tA.UTC().Format(time.RFC3339), len(vectors[0]))
+	fmt.Printf("B: %s @ %s (%d series)\n", exprs[1], tB.UTC().Format(time.RFC3339), len(vectors[1]))
+	DiffVectors(vectors[0], vectors[1]).Print(os.Stdout)
+	return true
+}
@@ -0,0 +1,50 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Diff(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	for _, s := range []struct {
+		code string
+		v    float64
+		ts   int64
+	}{
+		{"200", 100, 1700000000000}, {"500", 10, 1700000000000}, {"404", 5, 1700000000000},
+		{"200", 150, 1700003600000}, {"500", 10, 1700003600000}, {"503", 7, 1700003600000},
+	} {
+		store.AddSample(map[string]string{"__name__": "errors", "code": s.code}, s.v, s.ts)
+	}
+	prev := replEngine
+	replEngine = newTestEngine()
+	defer func() { replEngine = prev }()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".diff @1700000000 @1700003600 errors", store) })
+	for _, want := range []string{
+		"A: errors @ 2023-11-14T22:13:20Z (3 series)",
+		`errors{code="200"}  100  150  50     +50.0%`,
+		"Removed (only in A) (1):\n  errors{code=\"404\"} => 5",
+		"Added (only in B) (1):\n  errors{code=\"503\"} => 7",
+		"1 changed, 1 unchanged, 1 removed, 1 added",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in:\n%s", want, out)
+		}
+	}
+
+	// Two queries, also through -c splitting which must keep ";;" together.
+	out = captureStdout(t, func() {
+		RunInitCommands(replEngine, store, `.pinat 1700003600; .diff errors{code="200"} ;; errors{code="200"} * 2; .pinat remove`, false)
+	})
+	if !strings.Contains(out, `errors{code="200"}  150  300  150    +100.0%`) || !strings.Contains(out, "1 changed, 0 unchanged, 0 removed, 0 added") {
+		t.Fatalf("unexpected .diff output:\n%s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".diff errors", store) })
+	if !strings.Contains(out, "Usage: .diff <queryA> ;; <queryB>") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+}
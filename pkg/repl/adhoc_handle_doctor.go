@@ -0,0 +1,225 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+const (
+	// doctorGapFactor flags gaps longer than this many times a series' median interval.
+	doctorGapFactor = 5
+	// doctorHotspotValues flags labels with at least this many distinct values in a metric.
+	doctorHotspotValues = 100
+	// doctorExamples caps the example series printed per metric and anomaly.
+	doctorExamples = 3
+)
+
+// DoctorMetric summarizes the anomalies found in one metric.
+type DoctorMetric struct {
+	Name, Type         string
+	Series, Samples    int
+	CounterResets      int
+	OutOfOrder         int // samples older than the previous one of the same series, in load order
+	Duplicates         int // samples sharing a timestamp with another sample of the same series
+	Gaps               int // intervals longer than doctorGapFactor times the series' median interval
+	MaxGap             time.Duration
+	NaN, Inf           int // stale markers are not counted as NaN
+	Examples           map[string][]string
+	CardinalityHotspot []string // "label (N values)"
+}
+
+// Problems reports whether any anomaly was found.
+func (m DoctorMetric) Problems() bool {
+	return m.CounterResets+m.OutOfOrder+m.Duplicates+m.Gaps+m.NaN+m.Inf+len(m.CardinalityHotspot) > 0
+}
+
+// isCounterFamily reports whether a metric's samples only ever increase (between resets).
+func isCounterFamily(storage *sstorage.SimpleStorage, name string) bool {
+	switch storage.MetricType(name) {
+	case "counter":
+		return true
+	case "histogram", "summary":
+		return strings.HasSuffix(name, "_count") || strings.HasSuffix(name, "_sum") || strings.HasSuffix(name, "_bucket")
+	case "":
+		return strings.HasSuffix(name, "_total")
+	}
+	return false
+}
+
+// Diagnose scans the stored series of the metrics matching filter (all when nil) and returns
+// a per-metric report, sorted by name.
+func Diagnose(storage *sstorage.SimpleStorage, filter *regexp.Regexp) []DoctorMetric {
+	names := make([]string, 0, len(storage.Metrics))
+	for name := range storage.Metrics {
+		if filter == nil || filter.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	report := make([]DoctorMetric, 0, len(names))
+	for _, name := range names {
+		samples := storage.Metrics[name]
+		m := DoctorMetric{Name: name, Type: storage.MetricType(name), Samples: len(samples), Examples: map[string][]string{}}
+		counter := isCounterFamily(storage, name)
+		example := func(kind, series string) {
+			if len(m.Examples[kind]) < doctorExamples {
+				m.Examples[kind] = append(m.Examples[kind], series)
+			}
+		}
+
+		series := map[string][]sstorage.MetricSample{}
+		var order []string
+		labelValues := map[string]map[string]bool{}
+		for _, s := range samples {
+			key := formatSeries(s.Labels)
+			prev := series[key]
+			if len(prev) == 0 {
+				order = append(order, key)
+				for k, v := range s.Labels {
+					if k == labels.MetricName {
+						continue
+					}
+					if labelValues[k] == nil {
+						labelValues[k] = map[string]bool{}
+					}
+					labelValues[k][v] = true
+				}
+			} else if s.Timestamp < prev[len(prev)-1].Timestamp {
+				m.OutOfOrder++
+				example("out-of-order", key)
+			}
+			series[key] = append(prev, s)
+			if s.Histogram == nil {
+				switch {
+				case math.IsInf(s.Value, 0):
+					m.Inf++
+					example("inf", key)
+				case math.IsNaN(s.Value) && !value.IsStaleNaN(s.Value):
+					m.NaN++
+					example("nan", key)
+				}
+			}
+		}
+		m.Series = len(order)
+
+		for _, key := range order {
+			ss := series[key]
+			sort.SliceStable(ss, func(i, j int) bool { return ss[i].Timestamp < ss[j].Timestamp })
+			var intervals []int64
+			for i := 1; i < len(ss); i++ {
+				if ss[i].Timestamp == ss[i-1].Timestamp {
+					m.Duplicates++
+					example("duplicate", key)
+					continue
+				}
+				intervals = append(intervals, ss[i].Timestamp-ss[i-1].Timestamp)
+				if counter && ss[i].Histogram == nil && ss[i].Value < ss[i-1].Value {
+					m.CounterResets++
+					example("counter reset", key)
+				}
+			}
+			if len(intervals) >= 2 {
+				sorted := append([]int64(nil), intervals...)
+				sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+				median := sorted[len(sorted)/2]
+				for _, iv := range intervals {
+					if iv > doctorGapFactor*median {
+						m.Gaps++
+						example("gap", key)
+						m.MaxGap = max(m.MaxGap, time.Duration(iv)*time.Millisecond)
+					}
+				}
+			}
+		}
+
+		for k, vals := range labelValues {
+			if len(vals) >= doctorHotspotValues {
+				m.CardinalityHotspot = append(m.CardinalityHotspot, fmt.Sprintf("%s (%d values)", k, len(vals)))
+			}
+		}
+		sort.Strings(m.CardinalityHotspot)
+		report = append(report, m)
+	}
+	return report
+}
+
+// printDoctorReport prints a summary table of the metrics with anomalies, followed by examples.
+func printDoctorReport(w io.Writer, report []DoctorMetric) {
+	var series, samples int
+	var problems []DoctorMetric
+	for _, m := range report {
+		series += m.Series
+		samples += m.Samples
+		if m.Problems() {
+			problems = append(problems, m)
+		}
+	}
+	mustFprintf(w, "Checked %d metrics, %d series, %d samples\n", len(report), series, samples)
+	if len(problems) == 0 {
+		mustFprintln(w, "No anomalies found")
+		return
+	}
+	mustFprintf(w, "%d metrics with anomalies:\n\n", len(problems))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	mustFprintln(tw, "METRIC\tTYPE\tSERIES\tSAMPLES\tRESETS\tOUT-OF-ORDER\tDUPLICATES\tGAPS\tMAX GAP\tNAN\tINF")
+	for _, m := range problems {
+		maxGap := "-"
+		if m.MaxGap > 0 {
+			maxGap = m.MaxGap.String()
+		}
+		typ := m.Type
+		if typ == "" {
+			typ = "-"
+		}
+		mustFprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%d\t%d\n", m.Name, typ, m.Series, m.Samples,
+			m.CounterResets, m.OutOfOrder, m.Duplicates, m.Gaps, maxGap, m.NaN, m.Inf)
+	}
+	_ = tw.Flush()
+
+	for _, m := range problems {
+		if len(m.Examples) == 0 && len(m.CardinalityHotspot) == 0 {
+			continue
+		}
+		mustFprintf(w, "\n%s:\n", m.Name)
+		if len(m.CardinalityHotspot) > 0 {
+			mustFprintf(w, "  cardinality hotspot: %s\n", strings.Join(m.CardinalityHotspot, ", "))
+		}
+		kinds := make([]string, 0, len(m.Examples))
+		for k := range m.Examples {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		for _, k := range kinds {
+			mustFprintf(w, "  %s: %s\n", k, strings.Join(m.Examples[k], ", "))
+		}
+	}
+}
+
+// handleAdhocDoctor reports store anomalies: .doctor [metric_regex]
+func handleAdhocDoctor(query string, storage *sstorage.SimpleStorage) bool {
+	arg := strings.Trim(strings.TrimSpace(strings.TrimPrefix(query, ".doctor")), "\"'")
+	var filter *regexp.Regexp
+	if arg != "" {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			fmt.Printf("Invalid metric_regex %q: %v\n", arg, err)
+			return true
+		}
+		filter = re
+	}
+	printDoctorReport(os.Stdout, Diagnose(storage, filter))
+	return true
+}
@@ -0,0 +1,57 @@
+package repl
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Doctor(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	for i, v := range []float64{1, 5, 2, 3} {
+		store.AddSample(map[string]string{"__name__": "reqs_total", "job": "a"}, v, int64(i)*15000)
+	}
+	// 15s steps, then a 10m gap, then a duplicate and an out-of-order sample.
+	gauge := map[string]string{"__name__": "temp", "room": "x"}
+	for _, ts := range []int64{0, 15000, 30000, 45000, 645000, 645000, 600000} {
+		store.AddSample(gauge, 20, ts)
+	}
+	store.AddSample(map[string]string{"__name__": "ratio"}, math.Inf(1), 0)
+	store.AddSample(map[string]string{"__name__": "ok_gauge"}, 1, 0)
+	for i := 0; i < 120; i++ {
+		store.AddSample(map[string]string{"__name__": "by_user", "user": strconv.Itoa(i)}, 1, 0)
+	}
+
+	report := Diagnose(store, nil)
+	byName := map[string]DoctorMetric{}
+	for _, m := range report {
+		byName[m.Name] = m
+	}
+	if m := byName["reqs_total"]; m.CounterResets != 1 || m.Series != 1 {
+		t.Fatalf("expected 1 counter reset, got %+v", m)
+	}
+	if m := byName["temp"]; m.Duplicates != 1 || m.OutOfOrder != 1 || m.Gaps != 1 || m.CounterResets != 0 {
+		t.Fatalf("unexpected temp report %+v", m)
+	}
+	if m := byName["ratio"]; m.Inf != 1 {
+		t.Fatalf("expected an Inf value, got %+v", m)
+	}
+	if m := byName["by_user"]; len(m.CardinalityHotspot) != 1 || m.CardinalityHotspot[0] != "user (120 values)" {
+		t.Fatalf("expected a user hotspot, got %+v", m)
+	}
+	if byName["ok_gauge"].Problems() {
+		t.Fatalf("ok_gauge should be clean: %+v", byName["ok_gauge"])
+	}
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".doctor", store) })
+	if !strings.Contains(out, "4 metrics with anomalies") || !strings.Contains(out, "counter reset: reqs_total{job=\"a\"}") {
+		t.Fatalf("unexpected .doctor output:\n%s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".doctor ok_.*", store) })
+	if !strings.Contains(out, "No anomalies found") {
+		t.Fatalf("expected a clean report, got:\n%s", out)
+	}
+}
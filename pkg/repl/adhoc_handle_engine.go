@@ -0,0 +1,127 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// engineOpts are the options of the engine built by NewEngine, changed by .engine set.
+var engineOpts promql.EngineOpts
+
+// DefaultEngineOpts returns the engine options of promql-cli before the config file and
+// flags change them.
+func DefaultEngineOpts() promql.EngineOpts {
+	return promql.EngineOpts{
+		Logger:                   nil,
+		Reg:                      nil,
+		MaxSamples:               50000000,
+		Timeout:                  30 * time.Second,
+		LookbackDelta:            5 * time.Minute,
+		EnableAtModifier:         true,
+		EnableNegativeOffset:     true,
+		NoStepSubqueryIntervalFn: func(_ int64) int64 { return 60 * 1000 },
+	}
+}
+
+// NewEngine builds the PromQL engine from opts and makes it the REPL engine, remembering
+// opts so that .engine set can rebuild it with other limits.
+func NewEngine(opts promql.EngineOpts) *promql.Engine {
+	engineOpts = opts
+	if opts.Timeout > 0 {
+		replTimeout = opts.Timeout
+	}
+	replEngine = promql.NewEngine(opts)
+	return replEngine
+}
+
+// CurrentEngine returns the REPL engine, which .engine set may have rebuilt since NewEngine.
+func CurrentEngine() *promql.Engine {
+	return replEngine
+}
+
+// rebuildEngine replaces the REPL (and rule evaluation) engine with one built from opts.
+func rebuildEngine(opts promql.EngineOpts) {
+	NewEngine(opts)
+	if evalEngine != nil {
+		SetEvalEngine(replEngine)
+	}
+}
+
+// setEngineOption returns opts with key (timeout, max-samples or lookback-delta) set to value.
+func setEngineOption(opts promql.EngineOpts, key, value string) (promql.EngineOpts, error) {
+	key = strings.ReplaceAll(strings.ToLower(key), "_", "-")
+	switch key {
+	case "timeout", "lookback-delta":
+		d, err := model.ParseDuration(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		if d <= 0 {
+			return opts, fmt.Errorf("invalid %s: must be positive", key)
+		}
+		if key == "timeout" {
+			opts.Timeout = time.Duration(d)
+		} else {
+			opts.LookbackDelta = time.Duration(d)
+		}
+	case "max-samples":
+		n, err := strconv.Atoi(strings.ReplaceAll(value, "_", ""))
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("invalid max-samples %q: must be a positive integer", value)
+		}
+		opts.MaxSamples = n
+	default:
+		return opts, fmt.Errorf("unknown engine setting %q (expected timeout|max-samples|lookback-delta)", key)
+	}
+	return opts, nil
+}
+
+// formatEngineOpts formats the tunable engine limits.
+func formatEngineOpts(opts promql.EngineOpts) string {
+	return fmt.Sprintf("timeout=%s max-samples=%d lookback-delta=%s",
+		model.Duration(opts.Timeout), opts.MaxSamples, model.Duration(opts.LookbackDelta))
+}
+
+// handleAdhocEngine shows the engine limits or changes one, rebuilding the engine:
+// .engine [show] | .engine set <timeout|max-samples|lookback-delta> <value>
+func handleAdhocEngine(query string, storage *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimPrefix(query, ".engine"))
+	var err error
+	switch {
+	case len(fields) == 0 || len(fields) == 1 && fields[0] == "show":
+		if replEngine == nil {
+			fmt.Println("Error: query engine not initialized")
+			return true
+		}
+		fmt.Printf("Engine: %s\n", formatEngineOpts(engineOpts))
+		return true
+	case len(fields) == 3 && fields[0] == "set":
+		if replEngine == nil {
+			fmt.Println("Error: query engine not initialized")
+			return true
+		}
+		var opts promql.EngineOpts
+		if opts, err = setEngineOption(engineOpts, fields[1], fields[2]); err == nil {
+			rebuildEngine(opts)
+			fmt.Printf("Engine rebuilt: %s\n", formatEngineOpts(engineOpts))
+			return true
+		}
+	default:
+		err = errors.New("expected .engine [show] or .engine set <key> <value>")
+	}
+	fmt.Printf("Error: %v\n", err)
+	cmd := GetAdHocCommandByName(".engine")
+	fmt.Println("Usage: " + cmd.Usage)
+	for _, ex := range cmd.Examples {
+		fmt.Println("Example: " + ex)
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestSetEngineOption(t *testing.T) {
+	base := promql.EngineOpts{Timeout: 30 * time.Second, MaxSamples: 1000, LookbackDelta: 5 * time.Minute}
+	for _, tc := range []struct {
+		key, value string
+		check      func(promql.EngineOpts) bool
+	}{
+		{"timeout", "2m", func(o promql.EngineOpts) bool { return o.Timeout == 2*time.Minute }},
+		{"max-samples", "200_000_000", func(o promql.EngineOpts) bool { return o.MaxSamples == 200000000 }},
+		{"lookback_delta", "1h", func(o promql.EngineOpts) bool { return o.LookbackDelta == time.Hour }},
+	} {
+		opts, err := setEngineOption(base, tc.key, tc.value)
+		if err != nil || !tc.check(opts) {
+			t.Errorf("%s=%s: opts=%+v err=%v", tc.key, tc.value, opts, err)
+		}
+	}
+	for _, kv := range [][2]string{{"timeout", "0s"}, {"timeout", "soon"}, {"max-samples", "-5"}, {"workers", "4"}} {
+		if _, err := setEngineOption(base, kv[0], kv[1]); err == nil {
+			t.Errorf("%s=%s: expected an error", kv[0], kv[1])
+		}
+	}
+}
+
+func TestAdhocEngine_SetRebuilds(t *testing.T) {
+	oldEngine, oldOpts, oldTimeout, oldPinned := replEngine, engineOpts, replTimeout, pinnedEvalTime
+	defer func() {
+		replEngine, engineOpts, replTimeout, pinnedEvalTime = oldEngine, oldOpts, oldTimeout, oldPinned
+	}()
+	NewEngine(promql.EngineOpts{MaxSamples: 50_000_000, Timeout: 30 * time.Second, LookbackDelta: 5 * time.Minute})
+	now := time.Unix(1700000600, 0)
+	pinnedEvalTime = &now
+
+	store := sstorage.NewSimpleStorage()
+	store.AddSample(map[string]string{"__name__": "up", "job": "a"}, 1, 1700000000*1000)
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".engine", store) })
+	if strings.TrimSpace(out) != "Engine: timeout=30s max-samples=50000000 lookback-delta=5m" {
+		t.Fatalf("unexpected .engine output: %q", out)
+	}
+	// The sample is 10m old: outside the default 5m lookback.
+	out = captureStdout(t, func() { executeOne(replEngine, store, "up") })
+	if strings.Contains(out, `job="a"`) {
+		t.Fatalf("expected no result with a 5m lookback, got %s", out)
+	}
+	before := replEngine
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".engine set lookback-delta 15m", store) })
+	if !strings.Contains(out, "Engine rebuilt: timeout=30s max-samples=50000000 lookback-delta=15m") || replEngine == before {
+		t.Fatalf("engine not rebuilt: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(replEngine, store, "up") })
+	if !strings.Contains(out, `job="a"`) {
+		t.Fatalf("expected a result with a 15m lookback, got %s", out)
+	}
+
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".engine set timeout 5s", store) })
+	if replTimeout != 5*time.Second {
+		t.Fatalf("expected the REPL timeout to follow the engine, got %s", replTimeout)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".engine set max-samples lots", store) })
+	if !strings.Contains(out, "Error: invalid max-samples") || !strings.Contains(out, "Usage: .engine") || engineOpts.MaxSamples != 50_000_000 {
+		t.Fatalf("unexpected output for an invalid value: %s", out)
+	}
+}
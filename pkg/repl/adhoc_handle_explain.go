@@ -0,0 +1,251 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// explainLookbackDelta returns the LookbackDelta of the engine (see .engine): instant
+// selectors only see samples at most this old.
+func explainLookbackDelta() time.Duration {
+	if engineOpts.LookbackDelta > 0 {
+		return engineOpts.LookbackDelta
+	}
+	return DefaultEngineOpts().LookbackDelta
+}
+
+// selectorStats describes the stored data matched by a selector.
+type selectorStats struct {
+	Series, Samples int
+	MinT, MaxT      int64
+	// InWindow counts samples within the evaluation window of the selector at t
+	// (the lookback delta for instant selectors, the range for range selectors).
+	InWindow int
+	// Fresh counts series with a sample within the window.
+	Fresh int
+}
+
+// statSelector counts the series and samples matched by vs, and those inside (t-window, t].
+func statSelector(storage *sstorage.SimpleStorage, vs *parser.VectorSelector, t time.Time, window time.Duration) (selectorStats, error) {
+	st := selectorStats{MinT: math.MaxInt64, MaxT: math.MinInt64}
+	q, err := QueryableFor(storage).Querier(math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return st, err
+	}
+	defer func() { _ = q.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), replTimeout)
+	defer cancel()
+
+	evalT := t.Add(-vs.OriginalOffset)
+	if vs.Timestamp != nil {
+		evalT = time.UnixMilli(*vs.Timestamp).Add(-vs.OriginalOffset)
+	}
+	end := evalT.UnixMilli()
+	start := evalT.Add(-window).UnixMilli()
+
+	ss := q.Select(ctx, false, nil, vs.LabelMatchers...)
+	for ss.Next() {
+		st.Series++
+		fresh := false
+		it := ss.At().Iterator(nil)
+		for it.Next() != chunkenc.ValNone {
+			ts := it.AtT()
+			st.Samples++
+			st.MinT, st.MaxT = min(st.MinT, ts), max(st.MaxT, ts)
+			if ts > start && ts <= end {
+				st.InWindow++
+				fresh = true
+			}
+		}
+		if fresh {
+			st.Fresh++
+		}
+	}
+	return st, ss.Err()
+}
+
+// explainType names the value type an expression evaluates to.
+func explainType(e parser.Expr) string {
+	return string(e.Type())
+}
+
+// ExplainQuery writes the AST of expr as a tree, annotated with evaluation hints:
+// result types, function signatures, range/subquery sizes and, for each selector,
+// the matching series and samples in the store relative to evaluation time t.
+func ExplainQuery(w io.Writer, storage *sstorage.SimpleStorage, expr string, t time.Time) error {
+	root, err := promParser.ParseExpr(expr)
+	if err != nil {
+		return err
+	}
+	mustFprintf(w, "Explain @ %s (lookback %s):\n", t.UTC().Format(time.RFC3339), model.Duration(explainLookbackDelta()))
+	explainNode(w, storage, root, t, "", "", 0)
+	return nil
+}
+
+// explainNode writes one node and recurses into its children. prefix indents the
+// node's line; childPrefix indents its hints and children. window is the range of an
+// enclosing range selector (0 for instant selectors).
+func explainNode(w io.Writer, storage *sstorage.SimpleStorage, node parser.Expr, t time.Time, prefix, childPrefix string, window time.Duration) {
+	var (
+		label    string
+		hints    []string
+		children []parser.Expr
+	)
+	switch n := node.(type) {
+	case *parser.AggregateExpr:
+		label = "aggregation " + n.Op.String()
+		if n.Without {
+			label += " without (" + strings.Join(n.Grouping, ", ") + ")"
+		} else if len(n.Grouping) > 0 {
+			label += " by (" + strings.Join(n.Grouping, ", ") + ")"
+		}
+		if n.Param != nil {
+			children = append(children, n.Param)
+		}
+		children = append(children, n.Expr)
+	case *parser.BinaryExpr:
+		label = "binary " + n.Op.String()
+		if n.ReturnBool {
+			label += " bool"
+		}
+		if vm := n.VectorMatching; vm != nil && n.LHS.Type() == parser.ValueTypeVector && n.RHS.Type() == parser.ValueTypeVector {
+			switch {
+			case vm.On:
+				label += " on (" + strings.Join(vm.MatchingLabels, ", ") + ")"
+			case len(vm.MatchingLabels) > 0:
+				label += " ignoring (" + strings.Join(vm.MatchingLabels, ", ") + ")"
+			}
+			switch vm.Card {
+			case parser.CardManyToOne:
+				label += " group_left"
+			case parser.CardOneToMany:
+				label += " group_right"
+			case parser.CardManyToMany:
+				hints = append(hints, "set operation: matches series by labels")
+			}
+			if vm.Card == parser.CardOneToOne && !vm.On && len(vm.MatchingLabels) == 0 {
+				hints = append(hints, "one-to-one match on all labels (except __name__): both sides need identical label sets")
+			}
+		}
+		children = append(children, n.LHS, n.RHS)
+	case *parser.Call:
+		args := make([]string, len(n.Func.ArgTypes))
+		for i, a := range n.Func.ArgTypes {
+			args[i] = string(a)
+		}
+		label = fmt.Sprintf("call %s(%s)", n.Func.Name, strings.Join(args, ", "))
+		if n.Func.Experimental {
+			hints = append(hints, "experimental function")
+		}
+		for _, a := range n.Args {
+			children = append(children, a)
+		}
+	case *parser.MatrixSelector:
+		label = fmt.Sprintf("range selector [%s]", model.Duration(n.Range))
+		explainLine(w, prefix, label, node)
+		explainNode(w, storage, n.VectorSelector, t, childPrefix+"└─ ", childPrefix+"   ", n.Range)
+		return
+	case *parser.SubqueryExpr:
+		label = fmt.Sprintf("subquery [%s:%s]", model.Duration(n.Range), model.Duration(n.Step))
+		if n.Step > 0 {
+			hints = append(hints, fmt.Sprintf("evaluates the inner expression %d times per step", int(n.Range/n.Step)))
+		} else {
+			hints = append(hints, "step defaults to the global evaluation interval")
+		}
+		if n.OriginalOffset != 0 {
+			hints = append(hints, fmt.Sprintf("offset %s", model.Duration(n.OriginalOffset)))
+		}
+		children = append(children, n.Expr)
+	case *parser.VectorSelector:
+		label = "selector " + n.String()
+		if window == 0 {
+			window = explainLookbackDelta()
+		}
+		st, err := statSelector(storage, n, t, window)
+		switch {
+		case err != nil:
+			hints = append(hints, fmt.Sprintf("error reading store: %v", err))
+		case st.Series == 0:
+			hints = append(hints, "no matching series in the store: the result will be empty")
+		default:
+			hints = append(hints, fmt.Sprintf("%d series, %d samples, %s → %s", st.Series, st.Samples,
+				time.UnixMilli(st.MinT).UTC().Format(time.RFC3339), time.UnixMilli(st.MaxT).UTC().Format(time.RFC3339)))
+			hints = append(hints, fmt.Sprintf("%d/%d series with samples in the %s window (%d samples)", st.Fresh, st.Series, model.Duration(window), st.InWindow))
+			switch {
+			case st.Fresh > 0:
+			case st.MinT > t.UnixMilli():
+				hints = append(hints, "all samples are after evaluation time: the result will be empty (try .pinat)")
+			default:
+				hints = append(hints, fmt.Sprintf("latest sample is %s before evaluation time: the result will be empty (try .pinat or a longer range)",
+					model.Duration(t.Sub(time.UnixMilli(st.MaxT)).Truncate(time.Second))))
+			}
+		}
+	case *parser.ParenExpr:
+		label = "parens"
+		children = append(children, n.Expr)
+	case *parser.UnaryExpr:
+		label = "unary " + n.Op.String()
+		children = append(children, n.Expr)
+	case *parser.StepInvariantExpr:
+		label = "step invariant"
+		children = append(children, n.Expr)
+	case *parser.NumberLiteral:
+		label = "number " + n.String()
+	case *parser.StringLiteral:
+		label = "string " + n.String()
+	default:
+		label = fmt.Sprintf("%T %s", node, node.String())
+	}
+
+	explainLine(w, prefix, label, node)
+	for _, h := range hints {
+		bar := "   "
+		if len(children) > 0 {
+			bar = "│  "
+		}
+		mustFprintf(w, "%s%s· %s\n", childPrefix, bar, h)
+	}
+	for i, c := range children {
+		if i == len(children)-1 {
+			explainNode(w, storage, c, t, childPrefix+"└─ ", childPrefix+"   ", 0)
+		} else {
+			explainNode(w, storage, c, t, childPrefix+"├─ ", childPrefix+"│  ", 0)
+		}
+	}
+}
+
+func explainLine(w io.Writer, prefix, label string, node parser.Expr) {
+	mustFprintf(w, "%s%s → %s\n", prefix, label, explainType(node))
+}
+
+// handleAdhocExplain prints the query plan: .explain <query>
+func handleAdhocExplain(query string, storage *sstorage.SimpleStorage) bool {
+	expr := strings.TrimSpace(strings.TrimPrefix(query, ".explain"))
+	if expr == "" {
+		fmt.Println("Usage: " + GetAdHocCommandByName(".explain").Usage)
+		return true
+	}
+	if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+		expr = alertExpr
+	}
+	expr = normalizeAtModifierTimestamps(expr)
+	t := time.Now()
+	if pinnedEvalTime != nil {
+		t = *pinnedEvalTime
+	}
+	if err := ExplainQuery(os.Stdout, storage, expr, t); err != nil {
+		fmt.Printf("Parse error: %v\n", err)
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Explain_TreeAndSelectorHints(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	store.AddSample(map[string]string{"__name__": "http_requests_total", "job": "api"}, 1, 1700000000000)
+	store.AddSample(map[string]string{"__name__": "http_requests_total", "job": "api"}, 5, 1700000060000)
+	at := time.UnixMilli(1700000090000)
+	pinnedEvalTime = &at
+	defer func() { pinnedEvalTime = nil }()
+
+	out := captureStdout(t, func() {
+		_ = handleAdHocFunction(`.explain sum by (job) (rate(http_requests_total[5m])) > 1 and absent_metric offset 1h`, store)
+	})
+	for _, want := range []string{
+		"binary and → vector",
+		"aggregation sum by (job) → vector",
+		"call rate(matrix) → vector",
+		"range selector [5m] → matrix",
+		"selector http_requests_total → vector",
+		"1 series, 2 samples",
+		"1/1 series with samples in the 5m window (2 samples)",
+		"selector absent_metric offset 1h → vector",
+		"no matching series in the store",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+
+	later := at.Add(time.Hour)
+	pinnedEvalTime = &later
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".explain http_requests_total", store) })
+	if !strings.Contains(out, "latest sample is 1h30s before evaluation time") {
+		t.Fatalf("expected staleness hint, got:\n%s", out)
+	}
+	// A lookback tuned with .engine set covers the sample again
+	prev := engineOpts
+	defer func() { engineOpts = prev }()
+	engineOpts.LookbackDelta = 2 * time.Hour
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".explain http_requests_total", store) })
+	if !strings.Contains(out, "(lookback 2h)") || !strings.Contains(out, "1/1 series with samples in the 2h window") {
+		t.Fatalf("expected the engine lookback delta, got:\n%s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".explain sum(", store) })
+	if !strings.Contains(out, "Parse error") {
+		t.Fatalf("expected parse error, got:\n%s", out)
+	}
+}
@@ -0,0 +1,146 @@
+package repl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// exportFormatForPath infers the .export format from the file extension.
+func exportFormatForPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return sstorage.ExportFormatJSON
+	case ".om", ".openmetrics":
+		return sstorage.SaveFormatOpenMetrics
+	default:
+		return sstorage.SaveFormatProm
+	}
+}
+
+// handleAdhocExport handles .export <file> [format=openmetrics|prom|json]
+func handleAdhocExport(query string, storage *sstorage.SimpleStorage) bool {
+	rest := strings.TrimSpace(strings.TrimPrefix(query, ".export"))
+	usage := GetAdHocCommandByName(".export").Usage
+	path, args := parsePathAndArgs(rest)
+	if path == "" {
+		fmt.Println(usage)
+		return true
+	}
+	format := exportFormatForPath(path)
+	for _, a := range args {
+		if v, ok := strings.CutPrefix(strings.ToLower(a), "format="); ok {
+			format = strings.Trim(v, "\"'")
+			if format == "om" {
+				format = sstorage.SaveFormatOpenMetrics
+			}
+		}
+	}
+	if !slices.Contains(sstorage.ExportFormats, format) {
+		fmt.Printf("Unknown export format %q. Use: format={%s}\n", format, strings.Join(sstorage.ExportFormats, "|"))
+		return true
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("Failed to open %s for writing: %v\n", path, err)
+		return true
+	}
+	defer func() { _ = f.Close() }()
+	if err := storage.Export(f, format); err != nil {
+		fmt.Printf("Failed to export metrics to %s: %v\n", path, err)
+		return true
+	}
+	series, samples := 0, 0
+	for name, ss := range storage.Metrics {
+		seen := make(map[string]bool)
+		for _, s := range ss {
+			seen[seriesSignature(name, s.Labels)] = true
+		}
+		series += len(seen)
+		samples += len(ss)
+	}
+	fmt.Printf("Exported %d metrics (%d series, %d samples) to %s as %s\n", len(storage.Metrics), series, samples, path, format)
+	return true
+}
+
+// handleAdhocCSV writes the result of a query as CSV, one row per sample with a column per
+// label, plus timestamp and value: .csv <file> <query> [range [step]]
+// Without a range the query is evaluated at the current (or pinned) time.
+func handleAdhocCSV(query string, storage *sstorage.SimpleStorage) bool {
+	path, args := parsePathAndArgs(strings.TrimPrefix(query, ".csv"))
+	expr, durs := splitQueryDurations(args)
+	if path == "" || expr == "" {
+		cmd := GetAdHocCommandByName(".csv")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+		expr = alertExpr
+	}
+	expr = normalizeAtModifierTimestamps(expr)
+
+	end := time.Now()
+	if pinnedEvalTime != nil {
+		end = *pinnedEvalTime
+	}
+	var result *promql.Result
+	var err error
+	if len(durs) == 0 {
+		result, err = RunInstantQuery(replEngine, storage, expr, end, replTimeout)
+	} else {
+		rng, step := durs[0], min(time.Minute, durs[0])
+		if len(durs) == 2 {
+			step = durs[1]
+		}
+		if step <= 0 || rng/step > maxRangePoints {
+			fmt.Printf("Error: exceeded maximum resolution of %d points per series, try a larger step\n", maxRangePoints)
+			return true
+		}
+		result, err = RunRangeQuery(replEngine, storage, expr, end.Add(-rng), end, step, replTimeout)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("Failed to open %s for writing: %v\n", path, err)
+		return true
+	}
+	defer func() { _ = f.Close() }()
+	if err := formatDelimited(f, result, ','); err != nil {
+		fmt.Printf("Failed to write %s: %v\n", path, err)
+		return true
+	}
+	_, rows, _ := resultRows(result, rfc3339Millis, false)
+	series := 1
+	switch v := result.Value.(type) {
+	case promql.Vector:
+		series = len(v)
+	case promql.Matrix:
+		series = len(v)
+	}
+	if len(durs) == 0 {
+		fmt.Printf("Wrote %d rows (%d series) to %s\n", len(rows), series, path)
+	} else {
+		fmt.Printf("Wrote %d rows (%d series over the last %s) to %s\n", len(rows), series, model.Duration(durs[0]), path)
+	}
+	return true
+}
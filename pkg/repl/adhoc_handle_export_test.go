@@ -0,0 +1,70 @@
+package repl
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhocCSV(t *testing.T) {
+	oldEngine, oldPinned := replEngine, pinnedEvalTime
+	defer func() { replEngine, pinnedEvalTime = oldEngine, oldPinned }()
+	replEngine = newTestEngine()
+	now := time.Unix(1700000600, 0)
+	pinnedEvalTime = &now
+
+	store := sstorage.NewSimpleStorage()
+	for i := int64(0); i <= 10; i++ {
+		ts := (1700000000 + i*60) * 1000
+		store.AddSample(map[string]string{"__name__": "temp", "room": "lab, east"}, float64(i), ts)
+		store.AddSample(map[string]string{"__name__": "temp", "room": "office", "floor": "2"}, 20, ts)
+	}
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "instant.csv")
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".csv "+path+" temp", store) })
+	if !strings.Contains(out, "Wrote 2 rows (2 series)") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	rows := readCSV(t, path)
+	if strings.Join(rows[0], ",") != "__name__,floor,room,timestamp,value" || len(rows) != 3 {
+		t.Fatalf("unexpected csv: %v", rows)
+	}
+	// Series come sorted by labels: floor="2" sorts before room="lab, east"
+	if rows[2][2] != "lab, east" || rows[2][3] != "2023-11-14T22:23:20Z" || rows[2][4] != "10" {
+		t.Fatalf("unexpected row: %v", rows[2])
+	}
+
+	path = filepath.Join(dir, "range.csv")
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".csv "+path+" temp offset 1m 5m 1m", store) })
+	if !strings.Contains(out, "Wrote 12 rows (2 series over the last 5m)") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	if rows := readCSV(t, path); len(rows) != 13 || rows[7][2] != "lab, east" || rows[7][4] != "4" {
+		t.Fatalf("unexpected csv: %v", rows)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".csv "+path, store) })
+	if !strings.Contains(out, "Usage: .csv") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+	return rows
+}
@@ -0,0 +1,202 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// isFederateURI reports whether uri points at a Prometheus /federate endpoint.
+func isFederateURI(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && strings.HasSuffix(strings.TrimRight(u.Path, "/"), "/federate")
+}
+
+// splitSelectors splits a list of series selectors on top-level ',' or ';' (outside braces
+// and quotes), e.g. `up{job="a",env="b"}, {__name__=~"job:.*"}`.
+func splitSelectors(s string) []string {
+	var out []string
+	depth := 0
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote && (i == 0 || s[i-1] != '\\') {
+				quote = 0
+			}
+		case r == '"' || r == '\'' || r == '`':
+			quote = r
+		case r == '{':
+			depth++
+		case r == '}':
+			depth--
+		case (r == ',' || r == ';') && depth == 0:
+			if sel := strings.TrimSpace(s[start:i]); sel != "" {
+				out = append(out, sel)
+			}
+			start = i + 1
+		}
+	}
+	if sel := strings.TrimSpace(s[start:]); sel != "" {
+		out = append(out, sel)
+	}
+	return out
+}
+
+// FederateOptions controls how federated series are labeled.
+type FederateOptions struct {
+	// HonorLabels keeps the job/instance (and any other) labels of federated series when they
+	// clash with the target labels, instead of renaming them to exported_<name>.
+	HonorLabels bool
+	// TargetLabels are attached to every series, e.g. job="federate" and instance=<host:port>.
+	TargetLabels map[string]string
+}
+
+// parseFederateOptions reads honor_labels, job and instance from the extra .prom_scrape options.
+func parseFederateOptions(u *url.URL, extra map[string]string) (FederateOptions, error) {
+	opts := FederateOptions{TargetLabels: map[string]string{
+		model.JobLabel:      "federate",
+		model.InstanceLabel: u.Host,
+	}}
+	for k, v := range extra {
+		switch k {
+		case "honor_labels":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return opts, fmt.Errorf("invalid honor_labels %q", v)
+			}
+			opts.HonorLabels = b
+		case model.JobLabel, model.InstanceLabel:
+			if v == "" {
+				delete(opts.TargetLabels, k)
+			} else {
+				opts.TargetLabels[k] = v
+			}
+		default:
+			return opts, fmt.Errorf("unknown option %q", k)
+		}
+	}
+	return opts, nil
+}
+
+// FederateOnce fetches the series matching selectors from a /federate endpoint into a fresh
+// store, keeping their timestamps and applying the target labels per opts.
+func FederateOnce(ctx context.Context, client *http.Client, uri string, selectors []string, opts FederateOptions, prepare func(*http.Request)) (*sstorage.SimpleStorage, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	for _, sel := range selectors {
+		q.Add("match[]", sel)
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", sstorage.ScrapeAcceptHeader)
+	if prepare != nil {
+		prepare(req)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	scraped := sstorage.NewSimpleStorage()
+	if err := scraped.LoadFromReaderWithContentType(resp.Body, resp.Header.Get("Content-Type"), nil); err != nil {
+		return nil, err
+	}
+	attachTargetLabels(scraped, opts.TargetLabels, opts.HonorLabels)
+	return scraped, nil
+}
+
+// handleFederateScrape implements .prom_scrape against a /federate endpoint, where the query
+// argument is a list of match[] selectors.
+func handleFederateScrape(storage *sstorage.SimpleStorage, client *http.Client, uri, query string, count int, delay time.Duration, extra map[string]string, prepare func(*http.Request)) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		fmt.Printf("Invalid federate URL %q: %v\n", uri, err)
+		return true
+	}
+	opts, err := parseFederateOptions(u, extra)
+	if err != nil {
+		fmt.Printf(".prom_scrape: %v\n", err)
+		fmt.Println(promScrapeUsage)
+		return true
+	}
+	selectors := splitSelectors(query)
+	for _, sel := range selectors {
+		if _, err := promParser.ParseMetricSelector(sel); err != nil {
+			fmt.Printf("Invalid selector %q: %v\n", sel, err)
+			return true
+		}
+	}
+	if len(selectors) == 0 {
+		fmt.Println(".prom_scrape: /federate needs at least one selector, e.g. '{job=\"node\"}'")
+		return true
+	}
+
+	// Create a context that can be canceled by Ctrl-C
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGINT)
+	defer signal.Stop(sigChan)
+	go func() {
+		select {
+		case <-sigChan:
+			fmt.Println("\nFederation interrupted")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		scraped, err := FederateOnce(ctx, client, uri, selectors, opts, prepare)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Printf("Failed to federate from %s: %v\n", u.Host, err)
+			}
+			break
+		}
+		series, samples := mergeScrapedStore(storage, scraped)
+		totalMetrics, totalSamples := storeTotals(storage)
+		fmt.Printf("Federated from %s (%d/%d): +%d series, +%d samples (total: %d metrics, %d samples)\n",
+			u.Host, i+1, count, series, samples, totalMetrics, totalSamples)
+		setScrapeCompletionSource(uri, client.Transport, prepare)
+		if rAdded, rAlerts, rErr := EvaluateActiveRules(storage); rErr != nil {
+			fmt.Printf("Rules evaluation failed: %v\n", rErr)
+		} else if rAdded > 0 || rAlerts > 0 {
+			fmt.Printf("Rules: added %d samples; %d alerts\n", rAdded, rAlerts)
+		}
+		enforceStoreLimits(storage)
+		if i < count-1 && delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+	return true
+}
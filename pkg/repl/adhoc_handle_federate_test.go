@@ -0,0 +1,62 @@
+package repl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestSplitSelectors(t *testing.T) {
+	got := splitSelectors(`up{job="a",env="b"}, {__name__=~"job:.*"}; node_load1{x="1;2"}`)
+	want := []string{`up{job="a",env="b"}`, `{__name__=~"job:.*"}`, `node_load1{x="1;2"}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("splitSelectors = %q, want %q", got, want)
+	}
+}
+
+func TestAdhoc_PromScrapeFederate(t *testing.T) {
+	var matches []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/federate" {
+			http.NotFound(w, r)
+			return
+		}
+		matches = r.URL.Query()["match[]"]
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(`# TYPE up untyped
+up{job="node",instance="web-1:9100",cluster="eu"} 1 1700000000000
+`))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		honor string
+		want  map[string]string
+	}{
+		{"true", map[string]string{"__name__": "up", "job": "node", "instance": "web-1:9100", "cluster": "eu"}},
+		{"false", map[string]string{"__name__": "up", "job": "federate", "instance": strings.TrimPrefix(srv.URL, "http://"),
+			"exported_job": "node", "exported_instance": "web-1:9100", "cluster": "eu"}},
+	} {
+		storage := sstorage.NewSimpleStorage()
+		out := captureStdout(t, func() {
+			handleAdhocPromScrapeCommand(`.prom_scrape `+srv.URL+`/federate '{job="node"}, {__name__=~"job:.*"}' honor_labels=`+tc.honor, storage)
+		})
+		if !strings.Contains(out, "Federated from") {
+			t.Fatalf("honor_labels=%s: unexpected output:\n%s", tc.honor, out)
+		}
+		if want := []string{`{job="node"}`, `{__name__=~"job:.*"}`}; !reflect.DeepEqual(matches, want) {
+			t.Fatalf("match[] = %q, want %q", matches, want)
+		}
+		samples := storage.Metrics["up"]
+		if len(samples) != 1 || samples[0].Timestamp != 1700000000000 {
+			t.Fatalf("honor_labels=%s: unexpected samples %+v", tc.honor, samples)
+		}
+		if !reflect.DeepEqual(samples[0].Labels, tc.want) {
+			t.Fatalf("honor_labels=%s: labels = %v, want %v", tc.honor, samples[0].Labels, tc.want)
+		}
+	}
+}
@@ -1,14 +1,20 @@
 package repl
 
 import (
+	"cmp"
+	"context"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	sstorage "github.com/jjo/promql-cli/pkg/storage"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/promql"
 )
 
@@ -36,6 +42,11 @@ func handleAdhocSave(query string, storage *sstorage.SimpleStorage) bool {
 		fmt.Println("Invalid regex specification. Use: regex='timeseries regex' (quote if it contains spaces)")
 		return true
 	}
+	format, ok := ParseSaveFormatArg(args)
+	if !ok {
+		fmt.Println("Invalid format specification. Use: format={prom|openmetrics}")
+		return true
+	}
 
 	f, err := os.Create(path)
 	if err != nil {
@@ -43,7 +54,7 @@ func handleAdhocSave(query string, storage *sstorage.SimpleStorage) bool {
 		return true
 	}
 	defer func() { _ = f.Close() }()
-	opts := sstorage.SaveOptions{TimestampMode: tsMode, FixedTimestamp: tsFixed}
+	opts := sstorage.SaveOptions{TimestampMode: tsMode, FixedTimestamp: tsFixed, Format: format}
 	if re != nil {
 		opts.SeriesRegex = re
 	}
@@ -142,6 +153,94 @@ func ParseRegexArg(args []string) (*regexp.Regexp, bool) {
 	return nil, true
 }
 
+// ParseStreamArgs parses the streaming-load options: "stream" (or stream=true|false) and
+// max_samples=N, which implies streaming. It returns (stream, maxSamples, ok).
+func ParseStreamArgs(args []string) (bool, int64, bool) {
+	stream := false
+	var maxSamples int64
+	for _, a := range args {
+		k, v, hasValue := strings.Cut(strings.ToLower(a), "=")
+		switch k {
+		case "stream":
+			if !hasValue {
+				stream = true
+				continue
+			}
+			b, err := strconv.ParseBool(strings.Trim(v, "\"'"))
+			if err != nil {
+				return false, 0, false
+			}
+			stream = b
+		case "max_samples":
+			n, err := strconv.ParseInt(strings.Trim(v, "\"'"), 10, 64)
+			if err != nil || n <= 0 {
+				return false, 0, false
+			}
+			stream, maxSamples = true, n
+		}
+	}
+	return stream, maxSamples, true
+}
+
+// loadWorkers is the number of goroutines parsing loaded files (0 means GOMAXPROCS).
+var loadWorkers int
+
+// SetLoadWorkers sets how many goroutines parse files loaded with .load and load: a
+// number, or 0 (default) to use GOMAXPROCS. 1 parses sequentially.
+func SetLoadWorkers(value string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid load workers %q (expected a number >= 0)", value)
+	}
+	loadWorkers = n
+	return nil
+}
+
+// LoadWorkers returns the number of goroutines set with SetLoadWorkers.
+func LoadWorkers() int {
+	return loadWorkers
+}
+
+// StreamLoad loads r with the streaming loader, keeping only series whose signature matches
+// re (nil keeps all) and stopping after maxSamples (0 means no limit). Progress is written
+// to progress as a single updating line when it is not nil.
+func StreamLoad(storage *sstorage.SimpleStorage, r io.Reader, re *regexp.Regexp, maxSamples int64, progress io.Writer) (sstorage.StreamStats, error) {
+	opts := sstorage.StreamOptions{MaxSamples: maxSamples}
+	if re != nil {
+		opts.Filter = func(name string, lbls map[string]string) bool { return re.MatchString(seriesSignature(name, lbls)) }
+	}
+	if progress != nil {
+		opts.Progress = func(st sstorage.StreamStats) {
+			mustFprintf(progress, "\rLoading: %s, %d lines, %d samples (%.0f samples/s)   ",
+				formatBytes(uint64(st.Bytes)), st.Lines, st.Samples, st.SamplesPerSecond())
+		}
+	}
+	st, err := storage.LoadStream(r, opts)
+	if progress != nil {
+		mustFprintln(progress)
+	}
+	return st, err
+}
+
+// ParseSaveFormatArg parses an optional format={prom|openmetrics} token ("om" is accepted
+// as a shorthand). Without it, the Prometheus text format is used.
+func ParseSaveFormatArg(args []string) (string, bool) {
+	for _, a := range args {
+		if !strings.HasPrefix(strings.ToLower(a), "format=") {
+			continue
+		}
+		switch strings.ToLower(strings.Trim(a[len("format="):], " \"'")) {
+		case sstorage.SaveFormatProm, "text":
+			return sstorage.SaveFormatProm, true
+		case sstorage.SaveFormatOpenMetrics, "om":
+			return sstorage.SaveFormatOpenMetrics, true
+		default:
+			return "", false
+		}
+	}
+	return sstorage.SaveFormatProm, true
+}
+
 // calculateTimestampOffset calculates the offset needed to align the latest timestamp to the target.
 // It examines samples in the given range and returns (offset, hasData).
 // For "set" mode: offset = target - latest_timestamp
@@ -196,6 +295,7 @@ func ApplyTimestampOverride(storage *sstorage.SimpleStorage, beforeCounts map[st
 			}
 		}
 	}
+	storage.InvalidateIndex()
 }
 
 // ApplyFilteredLoad loads samples from tmp storage into target storage, applying regex filter and timestamp overrides.
@@ -239,7 +339,11 @@ func ApplyFilteredLoad(storage *sstorage.SimpleStorage, tmp *sstorage.SimpleStor
 					// offset all timestamps so that the latest one aligns with the target
 					ts += offset
 				}
-				storage.AddSample(s.Labels, s.Value, ts)
+				if s.Histogram != nil {
+					storage.AddHistogramSample(s.Labels, s.Histogram, ts)
+				} else {
+					storage.AddSample(s.Labels, s.Value, ts)
+				}
 			}
 		}
 	}
@@ -276,6 +380,21 @@ func seriesSignature(name string, lbls map[string]string) string {
 // Queries are separated by blank lines, EOF is treated as query terminator
 // Supports backslash continuation within queries
 func ExecuteQueriesFromFile(engine *promql.Engine, storage *sstorage.SimpleStorage, path string) error {
+	return ExecuteQueriesFromFileReport(engine, storage, path, "")
+}
+
+// ExecuteQueriesFromFileReport runs a query file as ExecuteQueriesFromFile does and, when
+// report is set, writes a JUnit XML or TAP report of its expressions to it (see
+// ParseReportSpec). Expressions then also fail on errors and empty results, unless an
+// "# expect:" line says otherwise, and any failure is returned as an error.
+func ExecuteQueriesFromFileReport(engine *promql.Engine, storage *sstorage.SimpleStorage, path, report string) error {
+	var format, reportPath string
+	if report != "" {
+		var err error
+		if format, reportPath, err = ParseReportSpec(report); err != nil {
+			return err
+		}
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("failed to read %s: %w", path, err)
@@ -285,33 +404,127 @@ func ExecuteQueriesFromFile(engine *promql.Engine, storage *sstorage.SimpleStora
 
 	if len(queries) == 0 {
 		fmt.Printf("No expressions found in %s\n", path)
-		return nil
+		if report == "" {
+			return nil
+		}
 	}
 
-	// Execute each query
+	// Execute each query, checking the "# expect:" assertions of the query on its result
+	checked, failed := 0, 0
+	var results []queryFileResult
 	for _, q := range queries {
 		fmt.Printf("> %s\n", q.query)
-		ExecuteQueryLine(engine, storage, q.query)
+		reported := report != "" && (isReportedQuery(q.query) || len(q.expect) > 0)
+		if len(q.expect) == 0 && !reported {
+			ExecuteQueryLine(engine, storage, q.query)
+			continue
+		}
+		lastQueryResult = nil
+		res := queryFileResult{query: q.query, line: q.startLine}
+		start := time.Now()
+		if reported {
+			res.output = teeOutput(func() { ExecuteQueryLine(engine, storage, q.query) })
+		} else {
+			ExecuteQueryLine(engine, storage, q.query)
+		}
+		res.duration = time.Since(start)
+		if lastQueryResult == nil && isReportedQuery(q.query) {
+			res.failures = append(res.failures, cmp.Or(strings.TrimSpace(ansiEscape.ReplaceAllString(res.output, "")), "query failed"))
+		}
+		for _, e := range q.expect {
+			checked++
+			a, err := ParseAssertion(e.spec)
+			if err == nil {
+				err = a.Check(lastQueryResult)
+			}
+			if err != nil {
+				failed++
+				fmt.Printf("FAIL %s:%d: expect %s: %v\n", path, e.line, e.spec, err)
+				res.failures = append(res.failures, fmt.Sprintf("expect %s: %v", e.spec, err))
+			}
+		}
+		if len(q.expect) == 0 && lastQueryResult != nil && resultSeriesCount(lastQueryResult.Value) == 0 {
+			res.failures = append(res.failures, "empty result")
+		}
+		if reported {
+			results = append(results, res)
+		}
 	}
 
+	if checked > 0 {
+		fmt.Printf("Expectations: %d passed, %d failed\n", checked-failed, failed)
+	}
+	if report != "" {
+		if err := writeQueryReport(format, reportPath, path, results); err != nil {
+			return fmt.Errorf("report: %w", err)
+		}
+		broken := 0
+		for _, r := range results {
+			if len(r.failures) > 0 {
+				broken++
+			}
+		}
+		if broken > 0 {
+			return fmt.Errorf("%d of %d queries failed (report: %s)", broken, len(results), reportPath)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d expectations failed", failed, checked)
+	}
 	return nil
 }
 
+// ReadQueriesFromFile returns the expressions and ad-hoc commands of a query file, using the
+// same multi-line rules as ExecuteQueriesFromFile.
+func ReadQueriesFromFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var queries []string
+	for _, q := range parseQueriesFromContent(string(data)) {
+		queries = append(queries, q.query)
+	}
+	return queries, nil
+}
+
 // queryWithLineNum tracks a query and its starting line number for error reporting
 type queryWithLineNum struct {
 	query     string
 	startLine int
+	expect    []queryExpectation // "# expect:" lines before the query
+}
+
+// queryExpectation is the assertion of a "# expect: <assertion>" query file line.
+type queryExpectation struct {
+	spec string
+	line int
 }
 
 // parseQueriesFromContent parses multi-line queries from file content
 // Adhoc commands (starting with .) are processed on first newline
 // PromQL queries are separated by blank lines, backslash continues lines
+// "# expect:" comments apply to the query that follows them
 func parseQueriesFromContent(content string) []queryWithLineNum {
 	var queries []queryWithLineNum
 	var currentLines []string
 	var startLine int
 	lineNum := 0
 	inContinuation := false
+	var pending []queryExpectation
+	add := func(query string, start int) {
+		q := queryWithLineNum{query: query, startLine: start}
+		kept := pending[:0]
+		for _, e := range pending {
+			if e.line < start {
+				q.expect = append(q.expect, e)
+			} else {
+				kept = append(kept, e)
+			}
+		}
+		pending = kept
+		queries = append(queries, q)
+	}
 
 	for rawLine := range strings.SplitSeq(content, "\n") {
 		lineNum++
@@ -323,7 +536,10 @@ func parseQueriesFromContent(content string) []queryWithLineNum {
 		}
 
 		// Handle comments - skip but don't break query accumulation
-		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		if comment, ok := strings.CutPrefix(strings.TrimSpace(line), "#"); ok {
+			if spec, ok := strings.CutPrefix(strings.TrimSpace(comment), "expect:"); ok {
+				pending = append(pending, queryExpectation{spec: strings.TrimSpace(spec), line: lineNum})
+			}
 			continue
 		}
 
@@ -347,8 +563,7 @@ func parseQueriesFromContent(content string) []queryWithLineNum {
 		if trimmed == "" {
 			// Blank line - end current query if any
 			if len(currentLines) > 0 {
-				query := strings.Join(currentLines, " ")
-				queries = append(queries, queryWithLineNum{query: query, startLine: startLine})
+				add(strings.Join(currentLines, " "), startLine)
 				currentLines = nil
 				inContinuation = false
 			}
@@ -360,12 +575,11 @@ func parseQueriesFromContent(content string) []queryWithLineNum {
 		if strings.HasPrefix(trimmed, ".") {
 			// First, flush any accumulated query
 			if len(currentLines) > 0 {
-				query := strings.Join(currentLines, " ")
-				queries = append(queries, queryWithLineNum{query: query, startLine: startLine})
+				add(strings.Join(currentLines, " "), startLine)
 				currentLines = nil
 			}
 			// Then add the adhoc command immediately without requiring blank line
-			queries = append(queries, queryWithLineNum{query: trimmed, startLine: lineNum})
+			add(trimmed, lineNum)
 			inContinuation = false
 			continue
 		}
@@ -377,8 +591,7 @@ func parseQueriesFromContent(content string) []queryWithLineNum {
 
 	// Handle EOF - treat as query terminator if we have accumulated lines
 	if len(currentLines) > 0 {
-		query := strings.Join(currentLines, " ")
-		queries = append(queries, queryWithLineNum{query: query, startLine: startLine})
+		add(strings.Join(currentLines, " "), startLine)
 	}
 
 	return queries
@@ -451,18 +664,39 @@ func handleAdhocLoad(query string, storage *sstorage.SimpleStorage) bool {
 		fmt.Println("Invalid regex specification. Use: regex='timeseries regex' (quote if it contains spaces)")
 		return true
 	}
-	if re == nil {
-		if err := storage.LoadFromReader(f); err != nil {
+	stream, maxSamples, ok := ParseStreamArgs(args)
+	if !ok {
+		fmt.Println("Invalid streaming option. Use: stream[=true|false] max_samples=<N>")
+		return true
+	}
+	switch {
+	case stream:
+		st, err := StreamLoad(storage, f, re, maxSamples, os.Stdout)
+		if err != nil {
 			fmt.Printf("Failed to load metrics from %s: %v\n", path, err)
 			return true
 		}
+		if st.Truncated {
+			fmt.Printf("Stopped after max_samples=%d\n", maxSamples)
+		}
+		if st.Skipped > 0 {
+			fmt.Printf("Skipped %d samples not matching regex\n", st.Skipped)
+		}
 		if tsMode != "keep" {
 			ApplyTimestampOverride(storage, beforeCounts, tsMode, tsFixed)
 		}
-	} else {
+	case re == nil:
+		if err := storage.LoadParallel(f, loadWorkers); err != nil {
+			fmt.Printf("Failed to load metrics from %s: %v\n", path, err)
+			return true
+		}
+		if tsMode != "keep" {
+			ApplyTimestampOverride(storage, beforeCounts, tsMode, tsFixed)
+		}
+	default:
 		// Load into temp storage and merge matching series only
 		tmp := sstorage.NewSimpleStorage()
-		if err := tmp.LoadFromReader(f); err != nil {
+		if err := tmp.LoadParallel(f, loadWorkers); err != nil {
 			fmt.Printf("Failed to load metrics from %s: %v\n", path, err)
 			return true
 		}
@@ -501,14 +735,26 @@ func handleAdhocLoad(query string, storage *sstorage.SimpleStorage) bool {
 						// offset all timestamps so that the latest one aligns with the target
 						ts += offset
 					}
-					storage.AddSample(s.Labels, s.Value, ts)
+					if s.Histogram != nil {
+						storage.AddHistogramSample(s.Labels, s.Histogram, ts)
+					} else {
+						storage.AddSample(s.Labels, s.Value, ts)
+					}
 				}
 			}
 		}
 	}
 
+	reportLoad(storage, path, beforeMetrics, beforeSamples)
+	return true
+}
+
+// reportLoad prints what a load added to the store, runs the after_load hooks, then
+// evaluates the active rules and refreshes the completion cache.
+func reportLoad(storage *sstorage.SimpleStorage, path string, beforeMetrics, beforeSamples int) {
 	afterMetrics, afterSamples := storeTotals(storage)
 	fmt.Printf("Loaded %s: +%d metrics, +%d samples (total: %d metrics, %d samples)\n", path, afterMetrics-beforeMetrics, afterSamples-beforeSamples, afterMetrics, afterSamples)
+	RunLoadHooks(storage, path)
 
 	// Evaluate active rules after TSDB update
 	if added, alerts, err := EvaluateActiveRules(storage); err != nil {
@@ -521,6 +767,164 @@ func handleAdhocLoad(query string, storage *sstorage.SimpleStorage) bool {
 	if refreshMetricsCache != nil {
 		refreshMetricsCache(storage)
 	}
+}
+
+// handleAdhocLoadJSON loads a saved Prometheus query API response: .load_json <file.json>
+func handleAdhocLoadJSON(query string, storage *sstorage.SimpleStorage) bool {
+	path, _ := parsePathAndArgs(strings.TrimSpace(strings.TrimPrefix(query, ".load_json")))
+	if path == "" {
+		fmt.Println("Usage: " + GetAdHocCommandByName(".load_json").Usage)
+		return true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("Failed to open %s: %v\n", path, err)
+		return true
+	}
+	defer func() { _ = f.Close() }()
+	beforeMetrics, beforeSamples := storeTotals(storage)
+	skipped, err := storage.LoadPromAPIJSON(f)
+	if err != nil {
+		fmt.Printf("Failed to load %s: %v\n", path, err)
+		return true
+	}
+	if skipped > 0 {
+		fmt.Printf("Skipped %d native histogram samples (not supported in JSON input)\n", skipped)
+	}
+	reportLoad(storage, path, beforeMetrics, beforeSamples)
+	return true
+}
+
+// influxPrecisions maps the precision= values of .load_influx to timestamp units.
+var influxPrecisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// handleAdhocLoadInflux loads an InfluxDB line protocol file:
+// .load_influx <file> [measurement_regex] [precision=ns|us|ms|s]
+func handleAdhocLoadInflux(query string, storage *sstorage.SimpleStorage) bool {
+	cmd := GetAdHocCommandByName(".load_influx")
+	path, args := parsePathAndArgs(strings.TrimSpace(strings.TrimPrefix(query, ".load_influx")))
+	var opts sstorage.InfluxOptions
+	var pattern string
+	for _, a := range args {
+		if v, ok := strings.CutPrefix(a, "precision="); ok {
+			if opts.Precision, ok = influxPrecisions[v]; !ok {
+				fmt.Printf("Invalid precision %q (expected ns, us, ms or s)\n", v)
+				return true
+			}
+			continue
+		}
+		if pattern != "" {
+			path = ""
+			break
+		}
+		pattern = strings.Trim(a, `'"`)
+	}
+	if path == "" {
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			fmt.Printf("Invalid measurement regex: %v\n", err)
+			return true
+		}
+		opts.Filter = re.MatchString
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("Failed to open %s: %v\n", path, err)
+		return true
+	}
+	defer func() { _ = f.Close() }()
+	beforeMetrics, beforeSamples := storeTotals(storage)
+	st, err := storage.LoadInfluxLineProtocol(f, opts)
+	if err != nil {
+		fmt.Printf("Failed to load %s: %v (%d samples loaded before the error)\n", path, err, st.Samples)
+		return true
+	}
+	if st.Filtered > 0 {
+		fmt.Printf("Skipped %d lines not matching measurement regex\n", st.Filtered)
+	}
+	if st.Skipped > 0 {
+		fmt.Printf("Skipped %d string fields\n", st.Skipped)
+	}
+	reportLoad(storage, path, beforeMetrics, beforeSamples)
+	return true
+}
+
+// LoadTSDB loads the series matching selector (all series when empty) with samples between
+// start and end (unbounded when empty; now-<dur>, RFC3339 or unix) from a Prometheus TSDB
+// directory, snapshot or block. It returns the number of series and samples added.
+func LoadTSDB(ctx context.Context, dir, selector, start, end string, storage *sstorage.SimpleStorage) (int, int, error) {
+	var matchers []*labels.Matcher
+	if strings.TrimSpace(selector) != "" {
+		var err error
+		if matchers, err = promParser.ParseMetricSelector(selector); err != nil {
+			return 0, 0, fmt.Errorf("invalid selector %q: %w", selector, err)
+		}
+	}
+	mint, maxt := int64(math.MinInt64), int64(math.MaxInt64)
+	for _, bound := range []struct {
+		spec string
+		ms   *int64
+	}{{start, &mint}, {end, &maxt}} {
+		if bound.spec == "" {
+			continue
+		}
+		t, err := parseEvalTime(bound.spec)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid time %q: %w", bound.spec, err)
+		}
+		*bound.ms = t.UnixMilli()
+	}
+	if mint > maxt {
+		return 0, 0, fmt.Errorf("start %s is after end %s", start, end)
+	}
+	return storage.LoadTSDB(ctx, dir, mint, maxt, matchers...)
+}
 
+// handleAdhocLoadTSDB loads series from a Prometheus TSDB on disk:
+// .load_tsdb <dir> [selector] [start [end]]
+func handleAdhocLoadTSDB(query string, storage *sstorage.SimpleStorage) bool {
+	cmd := GetAdHocCommandByName(".load_tsdb")
+	dir, args := parsePathAndArgs(strings.TrimSpace(strings.TrimPrefix(query, ".load_tsdb")))
+	var selector string
+	var times []string
+	for _, a := range args {
+		if _, err := parseEvalTime(a); err == nil && len(times) < 2 {
+			times = append(times, a)
+			continue
+		}
+		if selector != "" || len(times) > 0 {
+			dir = ""
+			break
+		}
+		selector = strings.Trim(a, "'")
+	}
+	if dir == "" {
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	times = append(times, "", "")
+	beforeMetrics, beforeSamples := storeTotals(storage)
+	series, _, err := LoadTSDB(context.Background(), dir, selector, times[0], times[1], storage)
+	if err != nil {
+		fmt.Printf("Failed to load %s: %v\n", dir, err)
+		return true
+	}
+	fmt.Printf("Read %d series from TSDB %s\n", series, dir)
+	reportLoad(storage, dir, beforeMetrics, beforeSamples)
 	return true
 }
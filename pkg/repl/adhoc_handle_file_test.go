@@ -1,8 +1,17 @@
 package repl
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+
 	sstorage "github.com/jjo/promql-cli/pkg/storage"
 )
 
@@ -828,3 +837,187 @@ func TestParseTimestampArg(t *testing.T) {
 		})
 	}
 }
+
+func TestAdhocLoadJSON_RoundTrip(t *testing.T) {
+	m := promql.Matrix{{
+		Metric: labels.FromStrings("__name__", "http_requests_total", "code", "200"),
+		Floats: []promql.FPoint{{T: 1700000000000, F: 10}, {T: 1700000060000, F: 12}},
+	}}
+	var buf bytes.Buffer
+	if err := PrintResultJSONToWriter(&promql.Result{Value: m}, &buf); err != nil {
+		t.Fatalf("PrintResultJSONToWriter: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "range.json")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := sstorage.NewSimpleStorage()
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".load_json "+path, store) })
+	if !strings.Contains(out, "+1 metrics, +2 samples") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	got := store.Metrics["http_requests_total"]
+	if len(got) != 2 || got[1].Timestamp != 1700000060000 || got[1].Value != 12 || got[1].Labels["code"] != "200" {
+		t.Fatalf("unexpected samples: %+v", got)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".load_json", store) })
+	if !strings.Contains(out, "Usage: .load_json") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+}
+
+func TestAdhocLoadInflux(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpu.lp")
+	data := "cpu,host=a usage=1 1700000000\ncpu,host=b usage=2 1700000000\nmem,host=a used=5 1700000000\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := sstorage.NewSimpleStorage()
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".load_influx "+path+" '^cpu$' precision=s", store) })
+	if !strings.Contains(out, "Skipped 1 lines not matching measurement regex") || !strings.Contains(out, "+1 metrics, +2 samples") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	if got := store.Metrics["cpu_usage"]; len(got) != 2 || got[0].Timestamp != 1700000000000 {
+		t.Fatalf("unexpected samples: %+v", got)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".load_influx "+path+" precision=h", store) })
+	if !strings.Contains(out, "Invalid precision") {
+		t.Fatalf("expected precision error, got: %s", out)
+	}
+}
+
+func TestAdhocLoadTSDB(t *testing.T) {
+	dir := t.TempDir()
+	src := sstorage.NewSimpleStorage()
+	for i := int64(0); i < 4; i++ {
+		src.AddSample(map[string]string{"__name__": "jobs_total", "job": "api"}, float64(i), 1700000000000+i*60000)
+		src.AddSample(map[string]string{"__name__": "jobs_total", "job": "web"}, float64(i), 1700000000000+i*60000)
+	}
+	b, err := sstorage.OpenTSDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Persist(src); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store := sstorage.NewSimpleStorage()
+	out := captureStdout(t, func() {
+		_ = handleAdHocFunction(`.load_tsdb `+dir+` '{job="api"}' 1700000060 1700000120`, store)
+	})
+	if !strings.Contains(out, "Read 1 series from TSDB") || !strings.Contains(out, "+1 metrics, +2 samples") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".load_tsdb "+dir+" 'up{' ", store) }); !strings.Contains(out, "invalid selector") {
+		t.Fatalf("expected a selector error: %s", out)
+	}
+	if _, _, err := LoadTSDB(context.Background(), dir, "", "1700000120", "1700000060", store); err == nil {
+		t.Fatal("expected an error for start after end")
+	}
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".load_tsdb", store) }); !strings.Contains(out, "Usage: .load_tsdb") {
+		t.Fatalf("expected usage: %s", out)
+	}
+}
+
+func TestParseQueriesFromContent_Expect(t *testing.T) {
+	content := `# expect: non-empty
+up
+
+# a comment
+# expect: value > 0
+# expect: value < 10
+sum(up)
+.metrics
+# expect: empty
+up == 2`
+	queries := parseQueriesFromContent(content)
+	if len(queries) != 4 {
+		t.Fatalf("expected 4 queries, got %d", len(queries))
+	}
+	var got [][]string
+	for _, q := range queries {
+		var specs []string
+		for _, e := range q.expect {
+			specs = append(specs, e.spec)
+		}
+		got = append(got, specs)
+	}
+	want := "[[non-empty] [value > 0 value < 10] [] [empty]]"
+	if s := fmt.Sprint(got); s != want {
+		t.Fatalf("expectations = %s, want %s", s, want)
+	}
+	if queries[1].expect[1].line != 6 {
+		t.Errorf("expected the second expectation of sum(up) at line 6, got %d", queries[1].expect[1].line)
+	}
+}
+
+func TestExecuteQueriesFromFile_Expect(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	if err := store.LoadFromReader(strings.NewReader("up{job=\"a\"} 1\nup{job=\"b\"} 0\n")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "q.promql")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# expect: non-empty\nup\n\n# expect: value == 0\nup == 0\n")
+	var err error
+	out := captureStdout(t, func() { err = ExecuteQueriesFromFile(newTestEngine(), store, path) })
+	if err != nil || !strings.Contains(out, "Expectations: 2 passed, 0 failed") {
+		t.Fatalf("expected passing expectations, got %v:\n%s", err, out)
+	}
+
+	write("# expect: empty\nup\n\n# expect: value > 0\nup\n\n# expect: non-empty\nnot valid(\n")
+	out = captureStdout(t, func() { err = ExecuteQueriesFromFile(newTestEngine(), store, path) })
+	if err == nil || err.Error() != "3 of 3 expectations failed" {
+		t.Fatalf("expected 3 failed expectations, got %v:\n%s", err, out)
+	}
+	for _, want := range []string{
+		"FAIL " + path + ":1: expect empty: expected an empty result, got 2 series",
+		"FAIL " + path + ":4: expect value > 0: 1 of 2 values fail",
+		"FAIL " + path + ":7: expect non-empty: no query result",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestAdhoc_Load_StreamRegexMaxSamples(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "big.prom")
+	content := "# TYPE node_cpu_seconds_total counter\n" +
+		"node_cpu_seconds_total{cpu=\"0\",mode=\"idle\"} 1 1700000000000\n" +
+		"node_load1 0.5 1700000000000\n" +
+		"node_cpu_seconds_total{cpu=\"0\",mode=\"idle\"} 2 1700000010000\n" +
+		"node_cpu_seconds_total{cpu=\"0\",mode=\"idle\"} 3 1700000020000\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	store := sstorage.NewSimpleStorage()
+	out := captureStdout(t, func() {
+		_ = handleAdHocFunction(".load "+path+" stream regex='^node_cpu' max_samples=2", store)
+	})
+	for _, want := range []string{"Loading: ", "Stopped after max_samples=2", "Skipped 1 samples not matching regex", "+1 metrics, +2 samples"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output, got: %s", want, out)
+		}
+	}
+	if _, ok := store.Metrics["node_load1"]; ok {
+		t.Fatalf("node_load1 should have been filtered out")
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".load "+path+" max_samples=zero", store) })
+	if !strings.Contains(out, "Invalid streaming option") {
+		t.Fatalf("expected invalid option message, got: %s", out)
+	}
+}
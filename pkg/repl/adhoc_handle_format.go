@@ -0,0 +1,186 @@
+package repl
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// handleAdhocFormat handles .format [name]: show or set the result output format.
+func handleAdhocFormat(query string, _ *sstorage.SimpleStorage) bool {
+	arg := strings.Trim(strings.TrimSpace(strings.TrimPrefix(query, ".format")), "\"'")
+	if arg == "" {
+		fmt.Printf("Output format: %s (available: %s)\n", outputFormat, strings.Join(FormatNames(), ", "))
+		return true
+	}
+	if err := SetOutputFormat(arg); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	fmt.Printf("Output format: %s\n", outputFormat)
+	return true
+}
+
+// handleAdhocColumns handles .columns [keep|drop <labels>|reset]: limit the label columns
+// shown by the table format.
+func handleAdhocColumns(query string, _ *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimPrefix(query, ".columns"))
+	switch {
+	case len(fields) == 0:
+		fmt.Printf("Table columns: %s\n", tableColumns)
+		return true
+	case len(fields) == 1 && fields[0] == "reset":
+		tableColumns = ColumnFilter{}
+		fmt.Println("Table columns: all labels")
+		return true
+	case len(fields) >= 2 && (fields[0] == "keep" || fields[0] == "drop"):
+		var names []string
+		for _, f := range fields[1:] {
+			for n := range strings.SplitSeq(f, ",") {
+				if n = strings.Trim(strings.TrimSpace(n), "\"'"); n != "" {
+					names = append(names, n)
+				}
+			}
+		}
+		if len(names) > 0 {
+			tableColumns = ColumnFilter{Mode: fields[0], Labels: names}
+			fmt.Printf("Table columns: %s\n", tableColumns)
+			if outputFormat != "table" {
+				fmt.Println("Note: applies to the table format; see .format table")
+			}
+			return true
+		}
+	}
+	cmd := GetAdHocCommandByName(".columns")
+	fmt.Println("Usage: " + cmd.Usage)
+	for _, ex := range cmd.Examples {
+		fmt.Println("Example: " + ex)
+	}
+	return true
+}
+
+// handleAdhocSort handles .sort [value|metric [asc|desc] | none]: show or set the order of
+// printed vector samples and matrix series.
+func handleAdhocSort(query string, _ *sstorage.SimpleStorage) bool {
+	arg := strings.TrimSpace(strings.TrimPrefix(query, ".sort"))
+	if arg != "" {
+		if err := SetResultSort(arg); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			fmt.Println("Usage: " + GetAdHocCommandByName(".sort").Usage)
+			return true
+		}
+	}
+	fmt.Printf("Result sort: %s\n", resultSort)
+	return true
+}
+
+// handleAdhocGroupBy handles .groupby [<label>|off]: show or set the label vector results
+// are grouped by.
+func handleAdhocGroupBy(query string, _ *sstorage.SimpleStorage) bool {
+	switch arg := strings.Trim(strings.TrimSpace(strings.TrimPrefix(query, ".groupby")), "\"'"); {
+	case arg == "":
+	case arg == "off" || arg == "none":
+		resultGroupBy = ""
+	case model.LegacyValidation.IsValidLabelName(arg):
+		resultGroupBy = arg
+	default:
+		fmt.Printf("Error: invalid label name %q\n", arg)
+		fmt.Println("Usage: " + GetAdHocCommandByName(".groupby").Usage)
+		return true
+	}
+	if resultGroupBy == "" {
+		fmt.Println("Group by: off")
+		return true
+	}
+	fmt.Printf("Group by: %s\n", resultGroupBy)
+	if outputFormat != "text" && outputFormat != "table" {
+		fmt.Println("Note: applies to the text and table formats; see .format")
+	}
+	return true
+}
+
+// handleAdhocLimit handles .limit [N|off]: show or set the number of series printed per result.
+func handleAdhocLimit(query string, _ *sstorage.SimpleStorage) bool {
+	arg := strings.TrimSpace(strings.TrimPrefix(query, ".limit"))
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if arg == "off" || arg == "none" {
+			n, err = 0, nil
+		}
+		if err == nil {
+			err = SetResultLimit(n)
+		}
+		if err != nil {
+			fmt.Printf("Error: invalid limit %q\n", arg)
+			fmt.Println("Usage: " + GetAdHocCommandByName(".limit").Usage)
+			return true
+		}
+	}
+	if resultLimit == 0 {
+		fmt.Println("Result limit: none")
+	} else {
+		fmt.Printf("Result limit: %d series\n", resultLimit)
+	}
+	return true
+}
+
+// handleAdhocHumanize handles .humanize [on|off]: show or set humanized values in the
+// table format.
+func handleAdhocHumanize(query string, _ *sstorage.SimpleStorage) bool {
+	switch arg := strings.TrimSpace(strings.TrimPrefix(query, ".humanize")); arg {
+	case "":
+	case "on", "true":
+		SetHumanize(true)
+	case "off", "false":
+		SetHumanize(false)
+	default:
+		fmt.Printf("Error: invalid value %q\n", arg)
+		fmt.Println("Usage: " + GetAdHocCommandByName(".humanize").Usage)
+		return true
+	}
+	if !humanizeValues {
+		fmt.Println("Humanize: off")
+		return true
+	}
+	fmt.Println("Humanize: on (_bytes as KiB/MiB/GiB, _seconds as durations, _timestamp_seconds as times, others as k/M/G)")
+	if outputFormat != "table" {
+		fmt.Println("Note: applies to the table format; see .format table")
+	}
+	return true
+}
+
+// handleAdhocHighlight handles .highlight [<regex>|off]: color the matches of regex in the
+// label values and metric names of subsequent results.
+func handleAdhocHighlight(query string, _ *sstorage.SimpleStorage) bool {
+	arg := strings.TrimSpace(strings.TrimPrefix(query, ".highlight"))
+	switch arg {
+	case "":
+		if highlightPattern == nil {
+			fmt.Println("Highlight: off")
+			return true
+		}
+		fmt.Printf("Highlight: %s\n", highlightPattern)
+	case "off", "reset":
+		highlightPattern = nil
+		fmt.Println("Highlight: off")
+		return true
+	default:
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			fmt.Printf("Error: invalid regex: %v\n", err)
+			fmt.Println("Usage: " + GetAdHocCommandByName(".highlight").Usage)
+			return true
+		}
+		highlightPattern = re
+		fmt.Printf("Highlight: %s\n", highlightString(resultHighlight(), arg))
+	}
+	if !colorEnabled() {
+		fmt.Println("(colors are off: output is not a terminal, NO_COLOR is set, or --color=never)")
+	}
+	return true
+}
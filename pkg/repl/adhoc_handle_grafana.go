@@ -0,0 +1,351 @@
+package repl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// grafanaBuiltinVars are the values used for Grafana's interval/range macros unless
+// overridden on the .grafana_import command line.
+var grafanaBuiltinVars = map[string]string{
+	"__rate_interval": "1m",
+	"__interval":      "1m",
+	"__interval_ms":   "60000",
+	"__range":         "1h",
+	"__range_s":       "3600",
+	"__range_ms":      "3600000",
+}
+
+// grafanaDashboard is the subset of a Grafana dashboard JSON model needed to extract queries.
+type grafanaDashboard struct {
+	Title      string         `json:"title"`
+	Panels     []grafanaPanel `json:"panels"`
+	Rows       []grafanaRow   `json:"rows"` // pre-5.0 dashboards
+	Templating struct {
+		List []grafanaVariable `json:"list"`
+	} `json:"templating"`
+}
+
+type grafanaRow struct {
+	Panels []grafanaPanel `json:"panels"`
+}
+
+type grafanaPanel struct {
+	Title      string          `json:"title"`
+	Type       string          `json:"type"`
+	Datasource json.RawMessage `json:"datasource"`
+	Targets    []struct {
+		Expr       string          `json:"expr"`
+		RefID      string          `json:"refId"`
+		Hide       bool            `json:"hide"`
+		Datasource json.RawMessage `json:"datasource"`
+	} `json:"targets"`
+	Panels []grafanaPanel `json:"panels"` // collapsed rows
+}
+
+type grafanaVariable struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	AllValue string `json:"allValue"`
+	Current  struct {
+		Value json.RawMessage `json:"value"`
+	} `json:"current"`
+}
+
+// GrafanaQuery is a PromQL target extracted from a dashboard panel.
+type GrafanaQuery struct {
+	Name       string
+	Panel      string
+	RefID      string
+	Expr       string   // with variables substituted
+	Unresolved []string // variables left in Expr without a value
+}
+
+// grafanaVarRe matches $var, ${var}, ${var:format} and [[var]] references. A bare $ must
+// be followed by a letter or _, so that label_replace references such as "$1" are left alone.
+var grafanaVarRe = regexp.MustCompile(`\$\{(\w+)(?::[^}]*)?\}|\[\[(\w+)(?::[^\]]*)?\]\]|\$([A-Za-z_]\w*)`)
+
+// ParseGrafanaDashboard extracts the Prometheus targets of a dashboard (raw model or an
+// API export wrapped in {"dashboard": ...}), substituting Grafana's interval macros and
+// template variables. overrides take precedence over the dashboard's current values.
+func ParseGrafanaDashboard(data []byte, overrides map[string]string) (string, []GrafanaQuery, error) {
+	var wrapped struct {
+		Dashboard *grafanaDashboard `json:"dashboard"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return "", nil, err
+	}
+	d := wrapped.Dashboard
+	if d == nil {
+		d = &grafanaDashboard{}
+		if err := json.Unmarshal(data, d); err != nil {
+			return "", nil, err
+		}
+	}
+
+	vars := map[string]string{}
+	for k, v := range grafanaBuiltinVars {
+		vars[k] = v
+	}
+	for _, v := range d.Templating.List {
+		if val, ok := grafanaVariableValue(v); ok {
+			vars[v.Name] = val
+		}
+	}
+	for k, v := range overrides {
+		vars[k] = v
+	}
+
+	var panels []grafanaPanel
+	var walk func(ps []grafanaPanel)
+	walk = func(ps []grafanaPanel) {
+		for _, p := range ps {
+			panels = append(panels, p)
+			walk(p.Panels)
+		}
+	}
+	walk(d.Panels)
+	for _, r := range d.Rows {
+		walk(r.Panels)
+	}
+
+	var out []GrafanaQuery
+	used := map[string]int{}
+	for _, p := range panels {
+		for _, t := range p.Targets {
+			if strings.TrimSpace(t.Expr) == "" || t.Hide {
+				continue
+			}
+			ds := t.Datasource
+			if len(ds) == 0 || string(ds) == "null" {
+				ds = p.Datasource
+			}
+			if !isPrometheusDatasource(ds) {
+				continue
+			}
+			q := GrafanaQuery{Panel: p.Title, RefID: t.RefID}
+			unresolved := map[string]bool{}
+			q.Expr = grafanaVarRe.ReplaceAllStringFunc(t.Expr, func(m string) string {
+				sub := grafanaVarRe.FindStringSubmatch(m)
+				name := sub[1] + sub[2] + sub[3]
+				if v, ok := vars[name]; ok {
+					return v
+				}
+				unresolved[name] = true
+				return m
+			})
+			for name := range unresolved {
+				q.Unresolved = append(q.Unresolved, name)
+			}
+			sort.Strings(q.Unresolved)
+			base := grafanaQueryName(p.Title, t.RefID)
+			used[base]++
+			q.Name = base
+			if used[base] > 1 {
+				q.Name += "_" + strconv.Itoa(used[base])
+			}
+			out = append(out, q)
+		}
+	}
+	return d.Title, out, nil
+}
+
+// grafanaVariableValue returns the current value of a template variable, as it would be
+// interpolated into a PromQL regex matcher (multiple values joined with '|').
+func grafanaVariableValue(v grafanaVariable) (string, bool) {
+	var values []string
+	var one string
+	switch {
+	case json.Unmarshal(v.Current.Value, &one) == nil:
+		values = []string{one}
+	case json.Unmarshal(v.Current.Value, &values) == nil:
+	default:
+		return "", false
+	}
+	for i, val := range values {
+		if val == "$__all" {
+			if v.AllValue != "" {
+				return v.AllValue, true
+			}
+			return ".*", true
+		}
+		if len(values) > 1 {
+			values[i] = regexp.QuoteMeta(val)
+		}
+	}
+	if len(values) == 0 {
+		return "", false
+	}
+	return strings.Join(values, "|"), true
+}
+
+// isPrometheusDatasource reports whether a panel/target datasource may be Prometheus: unset,
+// a reference by name or variable, or an object whose type is prometheus.
+func isPrometheusDatasource(raw json.RawMessage) bool {
+	if len(raw) == 0 || string(raw) == "null" {
+		return true
+	}
+	var ref struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(raw, &ref) != nil {
+		return true // a plain datasource name
+	}
+	return ref.Type == "" || ref.Type == "prometheus" || strings.HasPrefix(ref.Type, "$")
+}
+
+var nonIdentRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// grafanaQueryName derives an identifier from a panel title and target refId.
+func grafanaQueryName(title, refID string) string {
+	name := strings.Trim(nonIdentRe.ReplaceAllString(strings.ToLower(title), "_"), "_")
+	if name == "" {
+		name = "panel"
+	}
+	if refID != "" {
+		name += "_" + strings.ToLower(refID)
+	}
+	return name
+}
+
+// lastGrafanaImport keeps the queries of the last .grafana_import, in dashboard order.
+var lastGrafanaImport []GrafanaQuery
+
+// handleAdhocGrafanaImport imports panel queries from a dashboard:
+// .grafana_import <dashboard.json> [var=value ...]
+func handleAdhocGrafanaImport(query string, storage *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimSpace(strings.TrimPrefix(query, ".grafana_import")))
+	if len(fields) == 0 {
+		cmd := GetAdHocCommandByName(".grafana_import")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	overrides := map[string]string{}
+	for _, f := range fields[1:] {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			fmt.Printf("Invalid variable %q (expected var=value)\n", f)
+			return true
+		}
+		overrides[strings.TrimPrefix(k, "$")] = strings.Trim(v, "\"'")
+	}
+	data, err := os.ReadFile(strings.Trim(fields[0], "\"'"))
+	if err != nil {
+		fmt.Printf("Failed to read dashboard: %v\n", err)
+		return true
+	}
+	title, queries, err := ParseGrafanaDashboard(data, overrides)
+	if err != nil {
+		fmt.Printf("Invalid dashboard JSON: %v\n", err)
+		return true
+	}
+	if len(queries) == 0 {
+		fmt.Printf("No PromQL targets found in %q\n", title)
+		return true
+	}
+	lastGrafanaImport = queries
+	for _, q := range queries {
+		SetNamedQuery(NamedQuery{Name: q.Name, Expr: q.Expr, Source: "grafana: " + title + " / " + q.Panel})
+	}
+	fmt.Printf("Imported %d queries from %q:\n", len(queries), title)
+	printGrafanaQueries()
+	fmt.Println("Run with: .grafana run <N|name|all>  lint with: .grafana lint [N|name]")
+	return true
+}
+
+func printGrafanaQueries() {
+	for i, q := range lastGrafanaImport {
+		fmt.Printf("  [%d] %s (%s)\n      %s\n", i+1, q.Name, q.Panel, q.Expr)
+		if len(q.Unresolved) > 0 {
+			fmt.Printf("      unresolved variables: %s (pass them as var=value)\n", strings.Join(q.Unresolved, ", "))
+		}
+	}
+}
+
+// selectGrafanaQueries resolves a 1-based index, a query name or "all".
+func selectGrafanaQueries(sel string) ([]GrafanaQuery, error) {
+	if sel == "" || sel == "all" {
+		return lastGrafanaImport, nil
+	}
+	if n, err := strconv.Atoi(sel); err == nil {
+		if n < 1 || n > len(lastGrafanaImport) {
+			return nil, fmt.Errorf("no query [%d] (have %d)", n, len(lastGrafanaImport))
+		}
+		return lastGrafanaImport[n-1 : n], nil
+	}
+	for _, q := range lastGrafanaImport {
+		if q.Name == sel {
+			return []GrafanaQuery{q}, nil
+		}
+	}
+	return nil, fmt.Errorf("no imported query named %q", sel)
+}
+
+// handleAdhocGrafana lists, runs or lints imported dashboard queries:
+// .grafana list | .grafana run <N|name|all> | .grafana lint [N|name|all]
+func handleAdhocGrafana(query string, storage *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimSpace(strings.TrimPrefix(query, ".grafana")))
+	usage := GetAdHocCommandByName(".grafana").Usage
+	if len(fields) == 0 || len(fields) > 2 {
+		fmt.Println("Usage: " + usage)
+		return true
+	}
+	if len(lastGrafanaImport) == 0 {
+		fmt.Println("No dashboard imported yet. Try: .grafana_import <dashboard.json>")
+		return true
+	}
+	sel := ""
+	if len(fields) == 2 {
+		sel = fields[1]
+	}
+	switch fields[0] {
+	case "list":
+		printGrafanaQueries()
+	case "run":
+		if sel == "" {
+			fmt.Println("Usage: " + usage)
+			return true
+		}
+		queries, err := selectGrafanaQueries(sel)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return true
+		}
+		if replEngine == nil {
+			fmt.Println("Error: PromQL engine not available")
+			return true
+		}
+		for _, q := range queries {
+			fmt.Printf("> %s: %s\n", q.Name, q.Expr)
+			ExecuteQueryLine(replEngine, storage, q.Expr)
+		}
+	case "lint":
+		queries, err := selectGrafanaQueries(sel)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return true
+		}
+		exprs := make([]string, len(queries))
+		for i, q := range queries {
+			exprs[i] = q.Expr
+		}
+		if n := LintQueries(os.Stdout, storage, exprs); n == 0 {
+			fmt.Printf("No issues found (%d expressions)\n", len(exprs))
+		} else {
+			fmt.Printf("%d issues found\n", n)
+		}
+	default:
+		fmt.Println("Usage: " + usage)
+	}
+	return true
+}
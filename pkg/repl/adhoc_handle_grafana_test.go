@@ -0,0 +1,103 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+const testGrafanaDashboard = `{
+  "dashboard": {
+    "title": "HTTP Overview",
+    "templating": {"list": [
+      {"name": "job", "type": "query", "current": {"value": "api"}},
+      {"name": "code", "type": "custom", "current": {"value": ["500", "503"]}},
+      {"name": "instance", "type": "query", "allValue": ".+", "current": {"value": ["$__all"]}}
+    ]},
+    "panels": [
+      {"title": "Request rate", "targets": [
+        {"refId": "A", "expr": "sum(rate(http_requests_total{job=\"$job\"}[$__rate_interval]))"},
+        {"refId": "B", "expr": "up", "hide": true}
+      ]},
+      {"title": "Logs", "datasource": {"type": "loki"}, "targets": [{"refId": "A", "expr": "{job=\"api\"}"}]},
+      {"type": "row", "title": "Errors", "panels": [
+        {"title": "Error ratio", "targets": [
+          {"refId": "A", "expr": "sum(rate(http_requests_total{code=~\"${code}\",instance=~\"[[instance]]\"}[5m])) / on(region) sum(rate(http_requests_total{cluster=\"$cluster\"}[5m]))"}
+        ]}
+      ]}
+    ]
+  }
+}`
+
+func TestParseGrafanaDashboard(t *testing.T) {
+	title, queries, err := ParseGrafanaDashboard([]byte(testGrafanaDashboard), map[string]string{"__rate_interval": "2m"})
+	if err != nil {
+		t.Fatalf("ParseGrafanaDashboard: %v", err)
+	}
+	if title != "HTTP Overview" || len(queries) != 2 {
+		t.Fatalf("expected 2 queries from %q, got %d: %+v", title, len(queries), queries)
+	}
+	if q := queries[0]; q.Name != "request_rate_a" || q.Expr != `sum(rate(http_requests_total{job="api"}[2m]))` || len(q.Unresolved) != 0 {
+		t.Fatalf("unexpected first query: %+v", q)
+	}
+	q := queries[1]
+	if q.Name != "error_ratio_a" || !strings.Contains(q.Expr, `code=~"500|503"`) || !strings.Contains(q.Expr, `instance=~".+"`) {
+		t.Fatalf("unexpected second query: %+v", q)
+	}
+	if len(q.Unresolved) != 1 || q.Unresolved[0] != "cluster" || !strings.Contains(q.Expr, `cluster="$cluster"`) {
+		t.Fatalf("expected $cluster to be left unresolved, got %+v", q)
+	}
+}
+
+func TestParseGrafanaDashboard_KeepsRegexReferences(t *testing.T) {
+	dashboard := `{"title": "Pods", "panels": [{"title": "By pod", "targets": [
+	  {"refId": "A", "expr": "label_replace(up{job=\"$job\"}, \"pod\", \"$1\", \"instance\", \"(.*):.*\")"}
+	]}]}`
+	_, queries, err := ParseGrafanaDashboard([]byte(dashboard), map[string]string{"job": "api"})
+	if err != nil {
+		t.Fatalf("ParseGrafanaDashboard: %v", err)
+	}
+	if len(queries) != 1 || queries[0].Expr != `label_replace(up{job="api"}, "pod", "$1", "instance", "(.*):.*")` || len(queries[0].Unresolved) != 0 {
+		t.Fatalf("expected $1 left alone, got %+v", queries)
+	}
+}
+
+func TestAdhoc_GrafanaImportRunLint(t *testing.T) {
+	prev := replEngine
+	replEngine = newTestEngine()
+	defer func() { replEngine = prev }()
+	defer func() { lastGrafanaImport = nil }()
+
+	path := filepath.Join(t.TempDir(), "dashboard.json")
+	if err := os.WriteFile(path, []byte(testGrafanaDashboard), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	store := sstorage.NewSimpleStorage()
+	if err := store.LoadFromReader(strings.NewReader("http_requests_total{job=\"api\",code=\"500\"} 3\n")); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".grafana_import "+path+" cluster=prod", store) })
+	if !strings.Contains(out, `Imported 2 queries from "HTTP Overview"`) || strings.Contains(out, "unresolved") {
+		t.Fatalf("unexpected import output: %s", out)
+	}
+	if nq, ok := GetNamedQuery("error_ratio_a"); !ok || !strings.Contains(nq.Expr, `cluster="prod"`) || nq.Source != "grafana: HTTP Overview / Error ratio" {
+		t.Fatalf("expected error_ratio_a named query, got %+v", nq)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".grafana run request_rate_a", store) })
+	if !strings.Contains(out, "> request_rate_a:") {
+		t.Fatalf("unexpected run output: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".grafana lint 2", store) })
+	if !strings.Contains(out, "[aggregation-drops-label]") {
+		t.Fatalf("expected on(region) finding, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".grafana run 9", store) })
+	if !strings.Contains(out, "no query [9]") {
+		t.Fatalf("expected out-of-range error, got: %s", out)
+	}
+}
@@ -0,0 +1,371 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// graphColors are the ANSI colors assigned to series in order; they wrap around.
+var graphColors = []string{"\033[32m", "\033[33m", "\033[34m", "\033[35m", "\033[36m", "\033[31m", "\033[92m", "\033[93m", "\033[94m", "\033[95m"}
+
+const graphColorReset = "\033[0m"
+
+// graphMaxSeries caps the number of series drawn in one chart.
+const graphMaxSeries = 20
+
+// GraphOptions controls RenderGraph.
+type GraphOptions struct {
+	Width  int  // total width in columns, including the axis
+	Height int  // plot height in rows (each row is 4 braille dots high)
+	Color  bool // color series with ANSI escapes
+}
+
+// RenderGraph draws the float series of m over [start, end] as a braille line chart
+// followed by a legend with min/max/avg per series.
+func RenderGraph(w io.Writer, m promql.Matrix, start, end time.Time, opts GraphOptions) {
+	if opts.Height <= 0 {
+		opts.Height = 12
+	}
+	var series []promql.Series
+	for _, s := range m {
+		if len(s.Floats) > 0 {
+			series = append(series, s)
+		}
+	}
+	if len(series) == 0 {
+		mustFprintln(w, "No float samples to graph")
+		return
+	}
+	truncated := len(series) - graphMaxSeries
+	if truncated > 0 {
+		series = series[:graphMaxSeries]
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, p := range s.Floats {
+			if !math.IsNaN(p.F) && !math.IsInf(p.F, 0) {
+				lo, hi = math.Min(lo, p.F), math.Max(hi, p.F)
+			}
+		}
+	}
+	if math.IsInf(lo, 0) {
+		mustFprintln(w, "No finite samples to graph")
+		return
+	}
+	if lo == hi {
+		pad := math.Max(math.Abs(lo)*0.1, 1)
+		lo, hi = lo-pad, hi+pad
+	}
+
+	topLabel, midLabel, botLabel := formatGraphValue(hi), formatGraphValue((hi+lo)/2), formatGraphValue(lo)
+	labelW := max(len(topLabel), len(midLabel), len(botLabel))
+	cols := max(opts.Width-labelW-2, 10)
+	rows := opts.Height
+	pw, ph := cols*2, rows*4
+
+	cells := make([][]rune, rows)
+	owner := make([][]int, rows)
+	for r := range cells {
+		cells[r] = make([]rune, cols)
+		owner[r] = make([]int, cols)
+		for c := range owner[r] {
+			owner[r][c] = -1
+		}
+	}
+	set := func(x, y, idx int) {
+		if x < 0 || x >= pw || y < 0 || y >= ph {
+			return
+		}
+		r, c := y/4, x/2
+		cells[r][c] |= brailleDot(x%2, y%4)
+		owner[r][c] = idx
+	}
+	span := end.Sub(start).Seconds()
+	toX := func(ts int64) int {
+		if span <= 0 {
+			return 0
+		}
+		return int(math.Round(float64(ts-start.UnixMilli()) / 1000 / span * float64(pw-1)))
+	}
+	toY := func(v float64) int {
+		return ph - 1 - int(math.Round((v-lo)/(hi-lo)*float64(ph-1)))
+	}
+	for idx, s := range series {
+		gap := seriesGapMillis(s.Floats)
+		px, py, have := 0, 0, false
+		var prevT int64
+		for _, p := range s.Floats {
+			if math.IsNaN(p.F) || math.IsInf(p.F, 0) {
+				have = false
+				continue
+			}
+			x, y := toX(p.T), toY(p.F)
+			if have && p.T-prevT <= gap {
+				drawLine(px, py, x, y, func(x, y int) { set(x, y, idx) })
+			} else {
+				set(x, y, idx)
+			}
+			px, py, prevT, have = x, y, p.T, true
+		}
+	}
+
+	for r := range rows {
+		label := ""
+		switch r {
+		case 0:
+			label = topLabel
+		case (rows - 1) / 2:
+			label = midLabel
+		case rows - 1:
+			label = botLabel
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "%*s ┤", labelW, label)
+		last := -1
+		for c := range cols {
+			ch := cells[r][c]
+			if ch == 0 {
+				ch = ' '
+			} else {
+				ch += 0x2800
+			}
+			if opts.Color && owner[r][c] != last {
+				if last >= 0 {
+					b.WriteString(graphColorReset)
+				}
+				if owner[r][c] >= 0 {
+					b.WriteString(graphColors[owner[r][c]%len(graphColors)])
+				}
+				last = owner[r][c]
+			}
+			b.WriteRune(ch)
+		}
+		if opts.Color && last >= 0 {
+			b.WriteString(graphColorReset)
+		}
+		mustFprintln(w, strings.TrimRight(b.String(), " "))
+	}
+	mustFprintf(w, "%*s └%s\n", labelW, "", strings.Repeat("─", cols))
+	from, to := formatGraphTime(start, end), formatGraphTime(end, start)
+	mustFprintf(w, "%*s  %s%*s\n", labelW, "", from, max(cols-len(from), len(to)+1), to)
+
+	mustFprintln(w)
+	for idx, s := range series {
+		mn, mx, sum, n := math.Inf(1), math.Inf(-1), 0.0, 0
+		for _, p := range s.Floats {
+			if math.IsNaN(p.F) || math.IsInf(p.F, 0) {
+				continue
+			}
+			mn, mx, sum, n = math.Min(mn, p.F), math.Max(mx, p.F), sum+p.F, n+1
+		}
+		marker := "■"
+		if opts.Color {
+			marker = graphColors[idx%len(graphColors)] + marker + graphColorReset
+		}
+		name := s.Metric.String()
+		if name == "{}" {
+			name = "(value)"
+		}
+		if n == 0 {
+			mustFprintf(w, "%s %s  (no finite samples)\n", marker, name)
+			continue
+		}
+		mustFprintf(w, "%s %s  min=%s max=%s avg=%s\n", marker, name, formatGraphValue(mn), formatGraphValue(mx), formatGraphValue(sum/float64(n)))
+	}
+	if truncated > 0 {
+		mustFprintf(w, "(%d more series not shown; narrow the query)\n", truncated)
+	}
+}
+
+// brailleDot returns the bit of the dot at (x, y) within a 2x4 braille cell.
+func brailleDot(x, y int) rune {
+	if y == 3 {
+		return rune(0x40 << x)
+	}
+	return rune(1 << (y + 3*x))
+}
+
+// drawLine plots the points between (x0, y0) and (x1, y1) (Bresenham).
+func drawLine(x0, y0, x1, y1 int, plot func(x, y int)) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		plot(x0, y0)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * e; e2 >= dy {
+			e += dy
+			x0 += sx
+		} else {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// seriesGapMillis returns the largest step between points that is still drawn as a line:
+// 1.5x the smallest interval of the series. Longer intervals are left as gaps.
+func seriesGapMillis(points []promql.FPoint) int64 {
+	var minStep int64
+	for i := 1; i < len(points); i++ {
+		if d := points[i].T - points[i-1].T; d > 0 && (minStep == 0 || d < minStep) {
+			minStep = d
+		}
+	}
+	return minStep * 3 / 2
+}
+
+// formatGraphValue formats an axis or legend value with up to 4 significant digits.
+func formatGraphValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+// formatGraphTime formats an axis time, including the date when the range spans days.
+func formatGraphTime(t, other time.Time) string {
+	t, other = t.UTC(), other.UTC()
+	if t.YearDay() == other.YearDay() && t.Year() == other.Year() {
+		return t.Format("15:04:05")
+	}
+	return t.Format("01-02 15:04")
+}
+
+// terminalWidth returns the width of the terminal on stdout, then $COLUMNS, then 80.
+func terminalWidth() int {
+	if cols, _, ok := terminalSize(); ok {
+		return cols
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// parseGraphArgs splits ".graph" arguments into the query, its range and step. A zero step
+// means automatic.
+func parseGraphArgs(args string) (expr string, rng, step time.Duration, color bool, err error) {
+	color = colorEnabled()
+	var fields []string
+	for _, f := range strings.Fields(args) {
+		if f == "--no-color" {
+			color = false
+			continue
+		}
+		fields = append(fields, f)
+	}
+	rng = time.Hour
+	expr, durs := splitQueryDurations(fields)
+	if len(durs) >= 1 {
+		rng = durs[0]
+	}
+	if len(durs) == 2 {
+		step = durs[1]
+	}
+	if strings.TrimSpace(expr) == "" {
+		return "", 0, 0, color, fmt.Errorf("missing query")
+	}
+	if rng <= 0 {
+		return "", 0, 0, color, fmt.Errorf("range must be a positive duration")
+	}
+	return expr, rng, step, color, nil
+}
+
+// splitQueryDurations splits up to two trailing [range] [step] duration arguments off a
+// query. They are only taken as durations when the rest still parses as PromQL, so that
+// e.g. "up offset 5m" keeps its offset, and the step is not larger than the range.
+func splitQueryDurations(fields []string) (string, []time.Duration) {
+	for n := min(2, len(fields)-1); n > 0; n-- {
+		candidate := strings.Join(fields[:len(fields)-n], " ")
+		if _, perr := promParser.ParseExpr(candidate); perr != nil {
+			continue
+		}
+		var durs []time.Duration
+		for _, f := range fields[len(fields)-n:] {
+			d, derr := model.ParseDuration(f)
+			if derr != nil {
+				break
+			}
+			durs = append(durs, time.Duration(d))
+		}
+		if len(durs) != n || (n == 2 && durs[1] > durs[0]) {
+			continue
+		}
+		return candidate, durs
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// handleAdhocGraph renders a range query as a terminal chart:
+// .graph <query> [range] [step] [--no-color]
+func handleAdhocGraph(query string, storage *sstorage.SimpleStorage) bool {
+	expr, rng, step, color, err := parseGraphArgs(strings.TrimPrefix(query, ".graph"))
+	if err != nil {
+		cmd := GetAdHocCommandByName(".graph")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+		expr = alertExpr
+	}
+	expr = normalizeAtModifierTimestamps(expr)
+
+	width := terminalWidth()
+	end := time.Now()
+	if pinnedEvalTime != nil {
+		end = *pinnedEvalTime
+	}
+	start := end.Add(-rng)
+	if step <= 0 {
+		// One point per horizontal braille dot.
+		step = max((rng / time.Duration(2*max(width-12, 10))).Truncate(time.Second), time.Second)
+	}
+	if rng/step > maxRangePoints {
+		fmt.Printf("Error: exceeded maximum resolution of %d points per series, try a larger step\n", maxRangePoints)
+		return true
+	}
+	result, err := RunRangeQuery(replEngine, storage, expr, start, end, step, replTimeout)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	m, ok := result.Value.(promql.Matrix)
+	if !ok || len(m) == 0 {
+		fmt.Printf("No data for %s in the last %s (see .pinat to graph older data)\n", expr, model.Duration(rng))
+		return true
+	}
+	fmt.Printf("%s  [%s, step %s]\n", expr, model.Duration(rng), model.Duration(step))
+	RenderGraph(os.Stdout, m, start, end, GraphOptions{Width: width, Color: color})
+	return true
+}
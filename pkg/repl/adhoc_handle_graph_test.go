@@ -0,0 +1,107 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestParseGraphArgs(t *testing.T) {
+	defer func() { colorMode = "auto" }()
+	colorMode = "always"
+	for _, tc := range []struct {
+		in    string
+		expr  string
+		rng   time.Duration
+		step  time.Duration
+		color bool
+	}{
+		{"up", "up", time.Hour, 0, true},
+		{"rate(x[5m]) 6h", "rate(x[5m])", 6 * time.Hour, 0, true},
+		{"sum by (a) (x) 6h 5m --no-color", "sum by (a) (x)", 6 * time.Hour, 5 * time.Minute, false},
+		{"up offset 5m", "up offset 5m", time.Hour, 0, true},
+		{"up offset 5m 2h", "up offset 5m", 2 * time.Hour, 0, true},
+	} {
+		expr, rng, step, color, err := parseGraphArgs(tc.in)
+		if err != nil || expr != tc.expr || rng != tc.rng || step != tc.step || color != tc.color {
+			t.Errorf("parseGraphArgs(%q) = %q, %v, %v, %v, %v", tc.in, expr, rng, step, color, err)
+		}
+	}
+	colorMode = "auto"
+	t.Setenv("NO_COLOR", "")
+	_ = captureStdout(t, func() {
+		if _, _, _, color, _ := parseGraphArgs("up"); color {
+			t.Errorf("expected no colors with --color=auto when stdout isn't a terminal")
+		}
+	})
+	if _, _, _, _, err := parseGraphArgs(" --no-color"); err == nil {
+		t.Errorf("expected error for missing query")
+	}
+}
+
+func TestRenderGraph(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	var rising, flat promql.Series
+	rising.Metric = labels.FromStrings("job", "a")
+	flat.Metric = labels.FromStrings("job", "b")
+	for i := range 11 {
+		ts := start.Add(time.Duration(i) * time.Minute).UnixMilli()
+		rising.Floats = append(rising.Floats, promql.FPoint{T: ts, F: float64(i * 10)})
+		flat.Floats = append(flat.Floats, promql.FPoint{T: ts, F: 50})
+	}
+	var buf bytes.Buffer
+	RenderGraph(&buf, promql.Matrix{rising, flat}, start, start.Add(10*time.Minute), GraphOptions{Width: 40, Height: 5})
+	out := buf.String()
+	lines := strings.Split(out, "\n")
+	if !strings.HasPrefix(lines[0], "100 ┤") || !strings.HasPrefix(lines[4], "  0 ┤") {
+		t.Fatalf("unexpected axis labels:\n%s", out)
+	}
+	for _, l := range lines[:5] {
+		if n := len([]rune(l)); n > 40 {
+			t.Fatalf("line wider than 40 columns (%d):\n%s", n, out)
+		}
+	}
+	if !strings.ContainsAny(out, "⠁⠂⠄⡀⠈⠐⠠⢀⣀⠉") || strings.Contains(out, "\033[") {
+		t.Fatalf("expected uncolored braille plot:\n%s", out)
+	}
+	for _, want := range []string{"22:13:20", "22:23:20", `■ {job="a"}  min=0 max=100 avg=50`, `■ {job="b"}  min=50 max=50 avg=50`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	RenderGraph(&buf, promql.Matrix{rising}, start, start.Add(10*time.Minute), GraphOptions{Width: 40, Height: 5, Color: true})
+	if !strings.Contains(buf.String(), graphColors[0]) {
+		t.Fatalf("expected colored output:\n%s", buf.String())
+	}
+}
+
+func TestAdhoc_Graph(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	for i, v := range []float64{10, 12, 15, 11, 9, 14, 18, 20, 17, 16} {
+		store.AddSample(map[string]string{"__name__": "temp_celsius"}, v, 1700000000000+int64(i)*60000)
+	}
+	prevEngine, prevPinned := replEngine, pinnedEvalTime
+	replEngine = newTestEngine()
+	end := time.UnixMilli(1700000540000)
+	pinnedEvalTime = &end
+	defer func() { replEngine, pinnedEvalTime = prevEngine, prevPinned }()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".graph temp_celsius 10m 1m --no-color", store) })
+	for _, want := range []string{"temp_celsius  [10m, step 1m]", "20 ┤", "min=9 max=20"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in:\n%s", want, out)
+		}
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".graph", store) })
+	if !strings.Contains(out, "Usage: .graph <query>") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+}
@@ -0,0 +1,126 @@
+package repl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	promparser "github.com/prometheus/prometheus/promql/parser"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+var (
+	// seriesGuard is the number of series above which a query asks before it runs, or only
+	// warns when guardWarnOnly is set or there is no terminal to ask on; 0 never checks.
+	seriesGuard   int
+	guardWarnOnly bool
+)
+
+// estimateQuerySeries estimates the series the selectors of query touch, from the label
+// indexes of storage (and of the stores selected with a __store__ matcher).
+func estimateQuerySeries(storage *sstorage.SimpleStorage, query string) (int, error) {
+	expr, err := promParser.ParseExpr(query)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	promparser.Inspect(expr, func(node promparser.Node, _ []promparser.Node) error {
+		vs, ok := node.(*promparser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		var storeMatchers, rest []*labels.Matcher
+		for _, m := range vs.LabelMatchers {
+			if m.Name == storeLabel {
+				storeMatchers = append(storeMatchers, m)
+			} else {
+				rest = append(rest, m)
+			}
+		}
+		if storeMatchers == nil {
+			total += storage.EstimateSeries(rest)
+			return nil
+		}
+		for _, name := range storeNames() {
+			if matchesAll(storeMatchers, name) {
+				total += storeByName(storage, name).EstimateSeries(rest)
+			}
+		}
+		return nil
+	})
+	return total, nil
+}
+
+// guardQuery tells whether query may run: when its selectors touch more than seriesGuard
+// series, it warns, or asks when it can. Queries that don't parse are left to the engine
+// to report.
+func guardQuery(storage *sstorage.SimpleStorage, query string) bool {
+	if seriesGuard <= 0 {
+		return true
+	}
+	n, err := estimateQuerySeries(storage, query)
+	if err != nil || n <= seriesGuard {
+		return true
+	}
+	if guardWarnOnly || askConfirm == nil {
+		fmt.Printf("Warning: the query touches up to %d series, above the .guard limit of %d\n", n, seriesGuard)
+		return true
+	}
+	if askConfirm(fmt.Sprintf("The query touches up to %d series, above the .guard limit of %d. Run it? [y/N] ", n, seriesGuard)) {
+		return true
+	}
+	fmt.Println("Not run; narrow down its selectors, or raise the limit with .guard")
+	return false
+}
+
+// handleAdhocGuard shows or sets the series guard: .guard [N [warn|confirm]|off]
+func handleAdhocGuard(query string, storage *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimPrefix(query, ".guard"))
+	usage := func() {
+		cmd := GetAdHocCommandByName(".guard")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+	}
+	switch {
+	case len(fields) == 0:
+	case len(fields) == 1 && (fields[0] == "off" || fields[0] == "none"):
+		seriesGuard, guardWarnOnly = 0, false
+	case len(fields) <= 2:
+		n, err := strconv.Atoi(fields[0])
+		if err != nil || n <= 0 {
+			fmt.Printf("Error: invalid series limit %q\n", fields[0])
+			usage()
+			return true
+		}
+		warnOnly := false
+		if len(fields) == 2 {
+			switch fields[1] {
+			case "warn":
+				warnOnly = true
+			case "confirm":
+			default:
+				fmt.Printf("Error: invalid mode %q (expected warn or confirm)\n", fields[1])
+				usage()
+				return true
+			}
+		}
+		seriesGuard, guardWarnOnly = n, warnOnly
+	default:
+		usage()
+		return true
+	}
+	if seriesGuard == 0 {
+		fmt.Println("Series guard: off")
+		return true
+	}
+	action := "ask before running"
+	if guardWarnOnly {
+		action = "warn about"
+	}
+	fmt.Printf("Series guard: %s queries touching more than %d series (store: %d series)\n", action, seriesGuard, storage.EstimateSeries(nil))
+	return true
+}
@@ -0,0 +1,49 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAdhoc_Guard(t *testing.T) {
+	defer func() { seriesGuard, guardWarnOnly, askConfirm = 0, false, nil }()
+	store := newTestStore(t)
+	engine := newTestEngine()
+
+	if n, err := estimateQuerySeries(store, `sum(rate(http_requests_total{code="404"}[5m])) / count({__name__=~".+"})`); err != nil || n != 4 {
+		t.Fatalf("expected 1+3 series, got %d (%v)", n, err)
+	}
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".guard 2", store) })
+	if !strings.Contains(out, "ask before running queries touching more than 2 series (store: 3 series)") {
+		t.Fatalf("unexpected status: %s", out)
+	}
+
+	var asked string
+	askConfirm = func(q string) bool { asked = q; return false }
+	out = captureStdout(t, func() { executeOne(engine, store, `{__name__=~".+"}`) })
+	if !strings.Contains(asked, "up to 3 series") || !strings.Contains(out, "Not run") || strings.Contains(out, "27.3") {
+		t.Fatalf("expected the query not run, asked %q, got: %s", asked, out)
+	}
+	out = captureStdout(t, func() { executeOne(engine, store, "temperature") })
+	if !strings.Contains(out, "27.3") {
+		t.Fatalf("expected a query under the guard run, got: %s", out)
+	}
+
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".guard 2 warn", store)
+		executeOne(engine, store, `{__name__=~".+"}`)
+	})
+	if !strings.Contains(out, "Warning: the query touches up to 3 series, above the .guard limit of 2") || !strings.Contains(out, "27.3") {
+		t.Fatalf("expected a warning and the result, got: %s", out)
+	}
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".guard -1", store)
+		_ = handleAdHocFunction(".guard 5 maybe", store)
+		_ = handleAdHocFunction(".guard off", store)
+	})
+	for _, want := range []string{`invalid series limit "-1"`, `invalid mode "maybe"`, "Series guard: off"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q, got: %s", want, out)
+		}
+	}
+}
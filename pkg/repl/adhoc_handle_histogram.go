@@ -0,0 +1,291 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// histogramQuantiles are the quantiles .histogram computes with histogram_quantile.
+var histogramQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+const (
+	// histogramBarWidth is the width of the largest bucket bar.
+	histogramBarWidth = 40
+	// histogramMaxSeries caps the number of histograms printed by .histogram.
+	histogramMaxSeries = 20
+)
+
+// HistogramBucket is one cumulative "le" bucket of a classic histogram.
+type HistogramBucket struct {
+	LE         float64
+	Bound      string // the le label as stored
+	Cumulative float64
+}
+
+// HistogramInspection describes one classic histogram series: its buckets, _count and
+// _sum, the quantiles histogram_quantile computes from it, and any problems found.
+type HistogramInspection struct {
+	Series     string // labels other than __name__ and le
+	Buckets    []HistogramBucket
+	Count, Sum float64
+	HasCount   bool
+	HasSum     bool
+	Quantiles  map[float64]float64
+	Problems   []string
+}
+
+// histogramKey identifies the histogram a sample belongs to: its labels without name and le.
+func histogramKey(m labels.Labels) string {
+	return labels.NewBuilder(m).Del(labels.MetricName, labels.BucketLabel).Labels().String()
+}
+
+// InspectHistograms evaluates the _bucket, _count and _sum series of the classic histogram
+// base (optionally restricted by matchers, e.g. `{job="api"}`) at t and checks them.
+func InspectHistograms(storage *sstorage.SimpleStorage, base, matchers string, t time.Time) ([]HistogramInspection, error) {
+	buckets, err := evalInstantVector(storage, base+"_bucket"+matchers, t)
+	if err != nil {
+		return nil, err
+	}
+	byKey := map[string]*HistogramInspection{}
+	var keys []string
+	get := func(key string) *HistogramInspection {
+		h, ok := byKey[key]
+		if !ok {
+			h = &HistogramInspection{Series: key, Quantiles: map[float64]float64{}}
+			byKey[key] = h
+			keys = append(keys, key)
+		}
+		return h
+	}
+	for _, s := range buckets {
+		if s.H != nil {
+			continue
+		}
+		h := get(histogramKey(s.Metric))
+		bound := s.Metric.Get(labels.BucketLabel)
+		le, err := strconv.ParseFloat(bound, 64)
+		if err != nil || math.IsNaN(le) {
+			h.Problems = append(h.Problems, fmt.Sprintf("invalid le=%q bucket", bound))
+			continue
+		}
+		h.Buckets = append(h.Buckets, HistogramBucket{LE: le, Bound: bound, Cumulative: s.F})
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	for _, part := range []struct {
+		suffix string
+		set    func(h *HistogramInspection, v float64)
+	}{
+		{"_count", func(h *HistogramInspection, v float64) { h.Count, h.HasCount = v, true }},
+		{"_sum", func(h *HistogramInspection, v float64) { h.Sum, h.HasSum = v, true }},
+	} {
+		v, err := evalInstantVector(storage, base+part.suffix+matchers, t)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range v {
+			if h, ok := byKey[histogramKey(s.Metric)]; ok && s.H == nil {
+				part.set(h, s.F)
+			}
+		}
+	}
+	for _, q := range histogramQuantiles {
+		v, err := evalInstantVector(storage, fmt.Sprintf("histogram_quantile(%g, %s_bucket%s)", q, base, matchers), t)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range v {
+			if h, ok := byKey[histogramKey(s.Metric)]; ok && s.H == nil {
+				h.Quantiles[q] = s.F
+			}
+		}
+	}
+	sort.Strings(keys)
+	out := make([]HistogramInspection, 0, len(keys))
+	for _, k := range keys {
+		h := byKey[k]
+		sort.SliceStable(h.Buckets, func(i, j int) bool { return h.Buckets[i].LE < h.Buckets[j].LE })
+		h.check()
+		out = append(out, *h)
+	}
+	return out, nil
+}
+
+// check validates the buckets and flags boundaries that make quantiles unreliable.
+func (h *HistogramInspection) check() {
+	problem := func(format string, args ...any) { h.Problems = append(h.Problems, fmt.Sprintf(format, args...)) }
+	for i := 1; i < len(h.Buckets); i++ {
+		prev, cur := h.Buckets[i-1], h.Buckets[i]
+		if prev.LE == cur.LE {
+			problem("duplicate bucket le=%s (as %q and %q)", cur.Bound, prev.Bound, cur.Bound)
+		} else if cur.Cumulative < prev.Cumulative {
+			problem("non-monotonic buckets: le=%s has %s but le=%s has %s", prev.Bound, formatHistogramValue(prev.Cumulative),
+				cur.Bound, formatHistogramValue(cur.Cumulative))
+		}
+	}
+	if len(h.Buckets) == 0 {
+		return
+	}
+	last := h.Buckets[len(h.Buckets)-1]
+	if !math.IsInf(last.LE, 1) {
+		problem("no le=\"+Inf\" bucket: histogram_quantile returns NaN")
+		return
+	}
+	total := last.Cumulative
+	if h.HasCount && h.Count != total {
+		problem("_count is %s but the +Inf bucket is %s", formatHistogramValue(h.Count), formatHistogramValue(total))
+	}
+	if total <= 0 {
+		problem("no observations")
+		return
+	}
+	if len(h.Buckets) < 2 {
+		problem("only the +Inf bucket: quantiles can't be estimated")
+		return
+	}
+	highest := h.Buckets[len(h.Buckets)-2]
+	if above := (total - highest.Cumulative) / total; above > 0 {
+		for _, q := range histogramQuantiles {
+			if q*total > highest.Cumulative {
+				problem("%.0f%% of observations are above the highest finite bucket le=%s: p%s and higher are capped at %s, add larger buckets",
+					above*100, highest.Bound, formatQuantile(q), highest.Bound)
+				break
+			}
+		}
+	}
+	if lowest := h.Buckets[0]; len(h.Buckets) > 2 && lowest.Cumulative/total >= 0.9 {
+		problem("%.0f%% of observations are in the lowest bucket le=%s: quantiles are interpolated between 0 and %s, add smaller buckets",
+			lowest.Cumulative/total*100, lowest.Bound, lowest.Bound)
+	}
+}
+
+func formatHistogramValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+// formatQuantile formats 0.95 as "95".
+func formatQuantile(q float64) string {
+	return strconv.FormatFloat(q*100, 'g', -1, 64)
+}
+
+// printHistogramInspection prints the bucket distribution as a bar chart, the quantiles and
+// the problems found.
+func printHistogramInspection(w io.Writer, base string, h HistogramInspection) {
+	name := base
+	if h.Series != "{}" {
+		name += h.Series
+	}
+	header := name + ":"
+	if h.HasCount {
+		header += " count=" + formatHistogramValue(h.Count)
+	}
+	if h.HasSum {
+		header += " sum=" + formatHistogramValue(h.Sum)
+		if h.HasCount && h.Count > 0 {
+			header += " avg=" + formatHistogramValue(h.Sum/h.Count)
+		}
+	}
+	mustFprintln(w, header)
+	maxDelta, prev := 0.0, 0.0
+	for _, b := range h.Buckets {
+		maxDelta = math.Max(maxDelta, b.Cumulative-prev)
+		prev = b.Cumulative
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	mustFprintln(tw, "  LE\tCUMULATIVE\tBUCKET\t")
+	prev = 0
+	for _, b := range h.Buckets {
+		delta := b.Cumulative - prev
+		prev = b.Cumulative
+		bar := ""
+		if delta < 0 {
+			bar = "! decreases"
+		} else if maxDelta > 0 {
+			bar = strings.Repeat("█", int(math.Round(delta/maxDelta*histogramBarWidth)))
+		}
+		mustFprintf(tw, "  %s\t%s\t%s\t%s\n", b.Bound, formatHistogramValue(b.Cumulative), formatHistogramValue(delta), bar)
+	}
+	_ = tw.Flush()
+	if len(h.Quantiles) > 0 {
+		var qs []string
+		for _, q := range histogramQuantiles {
+			if v, ok := h.Quantiles[q]; ok {
+				qs = append(qs, "p"+formatQuantile(q)+"="+formatHistogramValue(v))
+			}
+		}
+		mustFprintf(w, "  histogram_quantile: %s\n", strings.Join(qs, " "))
+	}
+	if len(h.Problems) == 0 {
+		mustFprintln(w, "  OK: buckets are monotonic and consistent with _count")
+	}
+	for _, p := range h.Problems {
+		mustFprintf(w, "  WARNING: %s\n", p)
+	}
+}
+
+// parseHistogramArgs splits "<metric_base>[{matchers}]", accepting a _bucket, _count or
+// _sum metric name for the base.
+func parseHistogramArgs(args string) (base, matchers string) {
+	args = strings.TrimSpace(args)
+	base = args
+	if i := strings.IndexByte(args, '{'); i >= 0 {
+		base, matchers = strings.TrimSpace(args[:i]), strings.TrimSpace(args[i:])
+	}
+	for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+		base = strings.TrimSuffix(base, suffix)
+	}
+	return base, matchers
+}
+
+// handleAdhocHistogram inspects a classic histogram: .histogram <metric_base>[{matchers}]
+func handleAdhocHistogram(query string, storage *sstorage.SimpleStorage) bool {
+	base, matchers := parseHistogramArgs(strings.TrimPrefix(query, ".histogram"))
+	if base == "" || strings.ContainsAny(base, " ()[]") {
+		cmd := GetAdHocCommandByName(".histogram")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	t := time.Now()
+	if pinnedEvalTime != nil {
+		t = *pinnedEvalTime
+	}
+	hs, err := InspectHistograms(storage, base, matchers, t)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	if len(hs) == 0 {
+		fmt.Printf("No %s_bucket series @ %s (classic histograms only; see .pinat for older data)\n", base, t.UTC().Format(time.RFC3339))
+		return true
+	}
+	for i, h := range hs {
+		if i == histogramMaxSeries {
+			fmt.Printf("(%d more histograms not shown; add label matchers, e.g. %s{job=\"...\"})\n", len(hs)-i, base)
+			break
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printHistogramInspection(os.Stdout, base, h)
+	}
+	return true
+}
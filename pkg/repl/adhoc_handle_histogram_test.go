@@ -0,0 +1,66 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Histogram(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	content := `# TYPE req_seconds histogram
+req_seconds_bucket{job="api",le="0.1"} 50 1700000000000
+req_seconds_bucket{job="api",le="0.5"} 90 1700000000000
+req_seconds_bucket{job="api",le="1"} 100 1700000000000
+req_seconds_bucket{job="api",le="+Inf"} 100 1700000000000
+req_seconds_count{job="api"} 100 1700000000000
+req_seconds_sum{job="api"} 15 1700000000000
+req_seconds_bucket{job="web",le="0.1"} 10 1700000000000
+req_seconds_bucket{job="web",le="0.5"} 8 1700000000000
+req_seconds_bucket{job="web",le="1"} 20 1700000000000
+req_seconds_bucket{job="web",le="+Inf"} 100 1700000000000
+req_seconds_count{job="web"} 101 1700000000000
+`
+	if err := store.LoadFromReader(strings.NewReader(content)); err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	prevEngine, prevPinned := replEngine, pinnedEvalTime
+	replEngine = newTestEngine()
+	at := time.UnixMilli(1700000000000)
+	pinnedEvalTime = &at
+	defer func() { replEngine, pinnedEvalTime = prevEngine, prevPinned }()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".histogram req_seconds_bucket", store) })
+	api, web := strings.Index(out, `req_seconds{job="api"}: count=100 sum=15 avg=0.15`), strings.Index(out, `req_seconds{job="web"}: count=101`)
+	if api < 0 || web < api {
+		t.Fatalf("expected both histograms, api first: %s", out)
+	}
+	apiOut, webOut := out[api:web], out[web:]
+	if !strings.Contains(apiOut, "OK: buckets are monotonic") || !strings.Contains(apiOut, "histogram_quantile: p50=0.1 p90=0.5 p95=0.75 p99=0.95") {
+		t.Fatalf("unexpected api histogram: %s", apiOut)
+	}
+	if !strings.Contains(apiOut, "0.1   50          50      "+strings.Repeat("█", 40)+"\n") {
+		t.Fatalf("expected full bar for the largest bucket: %s", apiOut)
+	}
+	for _, want := range []string{
+		"WARNING: non-monotonic buckets: le=0.1 has 10 but le=0.5 has 8",
+		"WARNING: _count is 101 but the +Inf bucket is 100",
+		"WARNING: 80% of observations are above the highest finite bucket le=1: p50 and higher are capped at 1",
+		"! decreases",
+	} {
+		if !strings.Contains(webOut, want) {
+			t.Fatalf("missing %q in web histogram: %s", want, webOut)
+		}
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(`.histogram req_seconds{job="api"}`, store) })
+	if strings.Contains(out, `job="web"`) {
+		t.Fatalf("expected matchers to select api only: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".histogram nope", store) })
+	if !strings.Contains(out, "No nope_bucket series") {
+		t.Fatalf("expected no-data message: %s", out)
+	}
+}
@@ -0,0 +1,132 @@
+package repl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// historyReplaced, when set by the readline backend, reloads its history after .history
+// clear or dedupe rewrote the history file.
+var historyReplaced func(entries []string)
+
+// currentHistory returns the REPL history, oldest first: the prompt backend's in-memory
+// history, or the history file.
+func currentHistory() []string {
+	if entries := getInMemoryHistory(); len(entries) > 0 {
+		return entries
+	}
+	return loadHistoryFromFile(getHistoryFilePath())
+}
+
+// replaceHistory rewrites the history file with entries and updates the backends' copies.
+func replaceHistory(entries []string) error {
+	if err := writeHistoryFile(getHistoryFilePath(), entries); err != nil {
+		return err
+	}
+	setInMemoryHistory(entries)
+	if historyReplaced != nil {
+		historyReplaced(entries)
+	}
+	return nil
+}
+
+// writeHistoryFile writes entries to path, one per line.
+func writeHistoryFile(path string, entries []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e)
+		b.WriteByte('\n')
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// handleAdhocHistory handles .history [N] | search <regex> | clear | dedupe | export <file>
+func handleAdhocHistory(query string, _ *sstorage.SimpleStorage) bool {
+	cmd := GetAdHocCommandByName(".history")
+	usage := func() {
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+	}
+	rest := strings.TrimSpace(strings.TrimPrefix(query, ".history"))
+	sub, arg, _ := strings.Cut(rest, " ")
+	arg = strings.TrimSpace(arg)
+	entries := currentHistory()
+	switch sub {
+	case "search":
+		re, err := regexp.Compile(arg)
+		if arg == "" || err != nil {
+			if err != nil {
+				fmt.Printf("Invalid regex: %v\n", err)
+			}
+			usage()
+			return true
+		}
+		n := 0
+		for _, e := range entries {
+			if re.MatchString(e) {
+				fmt.Println(e)
+				n++
+			}
+		}
+		if n == 0 {
+			fmt.Printf("No history entries match %s\n", arg)
+		}
+	case "clear":
+		if err := replaceHistory(nil); err != nil {
+			fmt.Printf("Failed to clear history: %v\n", err)
+			return true
+		}
+		fmt.Printf("Cleared %d history entries\n", len(entries))
+	case "dedupe":
+		deduped := DedupeHistory(entries)
+		if err := replaceHistory(deduped); err != nil {
+			fmt.Printf("Failed to rewrite history: %v\n", err)
+			return true
+		}
+		fmt.Printf("Removed %d duplicate history entries (%d left)\n", len(entries)-len(deduped), len(deduped))
+	case "export":
+		path, _ := parsePathAndArgs(arg)
+		if path == "" {
+			usage()
+			return true
+		}
+		if err := writeHistoryFile(path, entries); err != nil {
+			fmt.Printf("Failed to export history: %v\n", err)
+			return true
+		}
+		fmt.Printf("Exported %d history entries to %s\n", len(entries), path)
+	default:
+		n := -1
+		if rest != "" {
+			v, err := strconv.Atoi(rest)
+			if err != nil || v <= 0 {
+				usage()
+				return true
+			}
+			n = v
+		}
+		if len(entries) == 0 {
+			fmt.Println("No history available")
+			return true
+		}
+		start := 0
+		if n > 0 && n < len(entries) {
+			start = len(entries) - n
+		}
+		for i := start; i < len(entries); i++ {
+			fmt.Println(entries[i])
+		}
+	}
+	return true
+}
@@ -36,19 +36,29 @@ type promAPISeries struct {
 }
 
 func handleAdhocScrape(query string, storage *sstorage.SimpleStorage) bool {
-	args := strings.Fields(query)
+	args := splitArgs(query)
 	if len(args) < 2 {
-		fmt.Println("Usage: .scrape <URI> [metrics_regex] [count] [delay]")
+		fmt.Println("Usage: .scrape <URI> [metrics_regex] [count] [delay] [header='Name: value'] [bearer_token_file=...] [user=... pass=...] [ca_file=...] [cert_file=... key_file=...] [insecure_skip_verify=true] [profile=name]")
 		fmt.Println("Examples: .scrape http://localhost:9100/metrics | .scrape http://localhost:9100/metrics '^(up|process_.*)$' 3 5s")
 		return true
 	}
 	uri := args[1]
+	auth, opts, err := parseScrapeAuth(uri, args[2:])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	client, err := auth.client(60 * time.Second)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
 	var regexStr string
 	count := 1
 	delay := 10 * time.Second
 	countSet := false
 	delaySet := false
-	for _, tok := range args[2:] {
+	for _, tok := range opts {
 		if !countSet {
 			if n, err := strconv.Atoi(tok); err == nil {
 				count = n
@@ -102,7 +112,6 @@ func handleAdhocScrape(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}()
 
-	client := &http.Client{Timeout: 60 * time.Second}
 	for i := 0; i < count; i++ {
 		// Check if context was canceled
 		if ctx.Err() != nil {
@@ -120,6 +129,7 @@ func handleAdhocScrape(query string, storage *sstorage.SimpleStorage) bool {
 			fmt.Printf("Failed to create request for %s: %v\n", uri, err)
 			return true
 		}
+		req.Header.Set("Accept", sstorage.ScrapeAcceptHeader)
 
 		resp, err := client.Do(req)
 		if err != nil {
@@ -136,22 +146,20 @@ func handleAdhocScrape(query string, storage *sstorage.SimpleStorage) bool {
 				fmt.Printf("Failed to scrape %s: HTTP %d\n", uri, resp.StatusCode)
 				return
 			}
+			var filter func(name string) bool
 			if re != nil {
-				if err := storage.LoadFromReaderWithFilter(resp.Body, func(name string) bool { return re.MatchString(name) }); err != nil {
-					fmt.Printf("Failed to parse metrics from %s: %v\n", uri, err)
-					return
-				}
-			} else {
-				if err := storage.LoadFromReader(resp.Body); err != nil {
-					fmt.Printf("Failed to parse metrics from %s: %v\n", uri, err)
-					return
-				}
+				filter = func(name string) bool { return re.MatchString(name) }
+			}
+			if err := storage.LoadFromReaderWithContentType(resp.Body, resp.Header.Get("Content-Type"), filter); err != nil {
+				fmt.Printf("Failed to parse metrics from %s: %v\n", uri, err)
+				return
 			}
 		}()
 
 		afterMetrics, afterSamples := storeTotals(storage)
 		fmt.Printf("Scraped %s (%d/%d): +%d metrics, +%d samples (total: %d metrics, %d samples)\n",
 			uri, i+1, count, afterMetrics-beforeMetrics, afterSamples-beforeSamples, afterMetrics, afterSamples)
+		RunLoadHooks(storage, uri)
 
 		// Evaluate active rules after each scrape update
 		if added, alerts, err := EvaluateActiveRules(storage); err != nil {
@@ -159,6 +167,7 @@ func handleAdhocScrape(query string, storage *sstorage.SimpleStorage) bool {
 		} else if added > 0 || alerts > 0 {
 			fmt.Printf("Rules: added %d samples; %d alerts\n", added, alerts)
 		}
+		enforceStoreLimits(storage)
 
 		if i < count-1 && delay > 0 {
 			// Sleep with context awareness
@@ -178,6 +187,10 @@ func handleAdhocScrape(query string, storage *sstorage.SimpleStorage) bool {
 	return true
 }
 
+// promScrapeUsage is printed on .prom_scrape argument errors.
+const promScrapeUsage = "Usage: .prom_scrape <PROM_API_URI> 'query' [count] [delay] [auth=basic|mimir] [user=...] [pass=...] [org_id=...] [api_key=...] [header='Name: value'] [bearer_token_file=...] [ca_file=...] [cert_file=... key_file=...] [insecure_skip_verify=true] [profile=name]\n" +
+	"       .prom_scrape <PROM_URL>/federate 'selector[, selector...]' [count] [delay] [honor_labels=true|false] [job=federate] [instance=<host:port>] [auth=...]"
+
 // handleAdhocPromScrapeCommand parses and executes .prom_scrape, importing results from a remote Prometheus API.
 // Syntax: .prom_scrape <PROM_API_URI> 'query' [count] [delay]
 func handleAdhocPromScrapeCommand(input string, storage *sstorage.SimpleStorage) bool {
@@ -188,14 +201,18 @@ func handleAdhocPromScrapeCommand(input string, storage *sstorage.SimpleStorage)
 	// Remove command token
 	rest := strings.TrimSpace(strings.TrimPrefix(trim, ".prom_scrape"))
 	if rest == "" {
-		fmt.Println("Usage: .prom_scrape <PROM_API_URI> 'query' [count] [delay] [auth=basic|mimir] [user=...] [pass=...] [org_id=...] [api_key=...]")
+		fmt.Println(promScrapeUsage)
 		return true
 	}
 	// Parse: URI, quoted or unquoted query, optional N and DELAY + auth KVs
-	uri, q, count, delay, authMode, user, pass, orgID, apiKey, err := parsePromScrapeArgs(rest)
+	uri, q, count, delay, authMode, user, pass, orgID, apiKey, extra, authArgs, err := parsePromScrapeArgs(rest)
+	var client *http.Client
+	if err == nil {
+		client, err = promScrapeClient(uri, authArgs, 60*time.Second)
+	}
 	if err != nil {
 		fmt.Printf(".prom_scrape: %v\n", err)
-		fmt.Println("Usage: .prom_scrape <PROM_API_URI> 'query' [count] [delay] [auth=basic|mimir] [user=...] [pass=...] [org_id=...] [api_key=...]")
+		fmt.Println(promScrapeUsage)
 		return true
 	}
 	if count <= 0 {
@@ -204,6 +221,11 @@ func handleAdhocPromScrapeCommand(input string, storage *sstorage.SimpleStorage)
 	if delay < 0 {
 		delay = 0
 	}
+	if isFederateURI(uri) {
+		return handleFederateScrape(storage, client, uri, q, count, delay, extra, func(req *http.Request) {
+			applyPromAuth(req, authMode, user, pass, orgID, apiKey)
+		})
+	}
 
 	// Create a context that can be canceled by Ctrl-C
 	ctx, cancel := context.WithCancel(context.Background())
@@ -223,7 +245,6 @@ func handleAdhocPromScrapeCommand(input string, storage *sstorage.SimpleStorage)
 		}
 	}()
 
-	client := &http.Client{Timeout: 60 * time.Second}
 	endpoint := buildPromQueryEndpoint(uri)
 	for i := 0; i < count; i++ {
 		// Check if context was canceled
@@ -286,12 +307,17 @@ func handleAdhocPromScrapeCommand(input string, storage *sstorage.SimpleStorage)
 			afterMetrics, afterSamples := storeTotals(storage)
 			fmt.Printf("Imported from %s (%d/%d): +%d samples (total: %d metrics, %d samples)\n",
 				u.Scheme+"://"+u.Host, i+1, count, added, afterMetrics, afterSamples)
+			// Complete label values of metrics not imported yet from this Prometheus
+			setScrapeCompletionSource(uri, client.Transport, func(req *http.Request) {
+				applyPromAuth(req, authMode, user, pass, orgID, apiKey)
+			})
 			// Evaluate active rules after import
 			if rAdded, rAlerts, rErr := EvaluateActiveRules(storage); rErr != nil {
 				fmt.Printf("Rules evaluation failed: %v\n", rErr)
 			} else if rAdded > 0 || rAlerts > 0 {
 				fmt.Printf("Rules: added %d samples; %d alerts\n", rAdded, rAlerts)
 			}
+			enforceStoreLimits(storage)
 		}()
 
 		if i < count-1 && delay > 0 {
@@ -312,6 +338,16 @@ func handleAdhocPromScrapeCommand(input string, storage *sstorage.SimpleStorage)
 	return true
 }
 
+// promScrapeClient returns the HTTP client of a .prom_scrape command, with the scrape
+// profile of uri and the header, bearer token and TLS options of authArgs.
+func promScrapeClient(uri string, authArgs []string, timeout time.Duration) (*http.Client, error) {
+	auth, _, err := parseScrapeAuth(uri, authArgs)
+	if err != nil {
+		return nil, err
+	}
+	return auth.client(timeout)
+}
+
 // applyPromAuth sets HTTP headers based on auth mode and provided values.
 func applyPromAuth(req *http.Request, authMode, user, pass, orgID, apiKey string) {
 	mode := strings.ToLower(strings.TrimSpace(authMode))
@@ -474,23 +510,15 @@ func buildPromQueryRangeEndpoint(base string) string {
 }
 
 // parsePromScrapeArgs parses rest of the command after .prom_scrape
-func parsePromScrapeArgs(rest string) (uri string, query string, count int, delay time.Duration, authMode, user, pass, orgID, apiKey string, err error) {
+// The scrape options of ScrapeAuth (header=, bearer_token_file=, ca_file=...) are returned
+// in authArgs, the other unknown key=value args in extra.
+func parsePromScrapeArgs(rest string) (uri string, query string, count int, delay time.Duration, authMode, user, pass, orgID, apiKey string, extra map[string]string, authArgs []string, err error) {
 	i := 0
 	skipSpaces := func() {
 		for i < len(rest) && (rest[i] == ' ' || rest[i] == '\t') {
 			i++
 		}
 	}
-	nextToken := func() (string, bool) {
-		if i >= len(rest) {
-			return "", false
-		}
-		start := i
-		for i < len(rest) && rest[i] != ' ' && rest[i] != '\t' {
-			i++
-		}
-		return rest[start:i], true
-	}
 	skipSpaces()
 	// URI: read until space
 	start := i
@@ -499,13 +527,13 @@ func parsePromScrapeArgs(rest string) (uri string, query string, count int, dela
 	}
 	if i == start {
 		err = fmt.Errorf("missing PROM_API_URI")
-		return uri, query, count, delay, authMode, user, pass, orgID, apiKey, err
+		return uri, query, count, delay, authMode, user, pass, orgID, apiKey, extra, authArgs, err
 	}
 	uri = rest[start:i]
 	skipSpaces()
 	if i >= len(rest) {
 		err = fmt.Errorf("missing query expression")
-		return uri, query, count, delay, authMode, user, pass, orgID, apiKey, err
+		return uri, query, count, delay, authMode, user, pass, orgID, apiKey, extra, authArgs, err
 	}
 	// Query: quoted or unquoted token
 	if rest[i] == '\'' || rest[i] == '"' {
@@ -520,7 +548,7 @@ func parsePromScrapeArgs(rest string) (uri string, query string, count int, dela
 		}
 		if i >= len(rest) {
 			err = fmt.Errorf("unterminated quoted query")
-			return uri, query, count, delay, authMode, user, pass, orgID, apiKey, err
+			return uri, query, count, delay, authMode, user, pass, orgID, apiKey, extra, authArgs, err
 		}
 		query = rest[qStart:i]
 		i++ // skip closing quote
@@ -559,13 +587,8 @@ func parsePromScrapeArgs(rest string) (uri string, query string, count int, dela
 			skipSpaces()
 		}
 	}
-	// Optional auth key=value tokens
-	for {
-		skipSpaces()
-		tok, ok := nextToken()
-		if !ok || tok == "" {
-			break
-		}
+	// Optional auth key=value tokens, quoted values may hold spaces
+	for _, tok := range splitArgs(rest[i:]) {
 		if eq := strings.IndexByte(tok, '='); eq > 0 {
 			k := strings.ToLower(strings.TrimSpace(tok[:eq]))
 			v := strings.TrimSpace(tok[eq+1:])
@@ -580,10 +603,19 @@ func parsePromScrapeArgs(rest string) (uri string, query string, count int, dela
 				orgID = v
 			case "api_key", "apikey":
 				apiKey = v
+			default:
+				if isScrapeAuthKey(k) {
+					authArgs = append(authArgs, tok)
+					continue
+				}
+				if extra == nil {
+					extra = map[string]string{}
+				}
+				extra[k] = v
 			}
 		}
 	}
-	return uri, query, count, delay, authMode, user, pass, orgID, apiKey, err
+	return uri, query, count, delay, authMode, user, pass, orgID, apiKey, extra, authArgs, err
 }
 
 // handleAdhocPromScrapeRangeCommand parses and executes .prom_scrape_range, importing results via query_range.
@@ -595,13 +627,17 @@ func handleAdhocPromScrapeRangeCommand(input string, storage *sstorage.SimpleSto
 	}
 	rest := strings.TrimSpace(strings.TrimPrefix(trim, ".prom_scrape_range"))
 	if rest == "" {
-		fmt.Println("Usage: .prom_scrape_range <PROM_API_URI> 'query' <start> <end> <step> [count] [delay] [auth=basic|mimir] [user=...] [pass=...] [org_id=...] [api_key=...]")
+		fmt.Println("Usage: .prom_scrape_range <PROM_API_URI> 'query' <start> <end> <step> [count] [delay] [auth=basic|mimir] [user=...] [pass=...] [org_id=...] [api_key=...] [header='Name: value'] [bearer_token_file=...] [ca_file=...] [profile=name]")
 		return true
 	}
-	uri, q, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, err := parsePromScrapeRangeArgs(rest)
+	uri, q, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, authArgs, err := parsePromScrapeRangeArgs(rest)
+	var client *http.Client
+	if err == nil {
+		client, err = promScrapeClient(uri, authArgs, 120*time.Second)
+	}
 	if err != nil {
 		fmt.Printf(".prom_scrape_range: %v\n", err)
-		fmt.Println("Usage: .prom_scrape_range <PROM_API_URI> 'query' <start> <end> <step> [count] [delay] [auth=basic|mimir] [user=...] [pass=...] [org_id=...] [api_key=...]")
+		fmt.Println("Usage: .prom_scrape_range <PROM_API_URI> 'query' <start> <end> <step> [count] [delay] [auth=basic|mimir] [user=...] [pass=...] [org_id=...] [api_key=...] [header='Name: value'] [bearer_token_file=...] [ca_file=...] [profile=name]")
 		return true
 	}
 	if count <= 0 {
@@ -629,7 +665,6 @@ func handleAdhocPromScrapeRangeCommand(input string, storage *sstorage.SimpleSto
 		}
 	}()
 
-	client := &http.Client{Timeout: 120 * time.Second}
 	endpoint := buildPromQueryRangeEndpoint(uri)
 	for i := 0; i < count; i++ {
 		// Check if context was canceled
@@ -692,12 +727,17 @@ func handleAdhocPromScrapeRangeCommand(input string, storage *sstorage.SimpleSto
 			afterMetrics, afterSamples := storeTotals(storage)
 			fmt.Printf("Imported range from %s (%d/%d): +%d samples (total: %d metrics, %d samples)\n",
 				u.Scheme+"://"+u.Host, i+1, count, added, afterMetrics, afterSamples)
+			// Complete label values of metrics not imported yet from this Prometheus
+			setScrapeCompletionSource(uri, client.Transport, func(req *http.Request) {
+				applyPromAuth(req, authMode, user, pass, orgID, apiKey)
+			})
 			// Evaluate active rules after each range import
 			if rAdded, rAlerts, rErr := EvaluateActiveRules(storage); rErr != nil {
 				fmt.Printf("Rules evaluation failed: %v\n", rErr)
 			} else if rAdded > 0 || rAlerts > 0 {
 				fmt.Printf("Rules: added %d samples; %d alerts\n", rAdded, rAlerts)
 			}
+			enforceStoreLimits(storage)
 		}()
 
 		if i < count-1 && delay > 0 {
@@ -717,7 +757,7 @@ func handleAdhocPromScrapeRangeCommand(input string, storage *sstorage.SimpleSto
 }
 
 // parsePromScrapeRangeArgs parses the args after .prom_scrape_range
-func parsePromScrapeRangeArgs(rest string) (uri string, query string, start time.Time, end time.Time, step time.Duration, count int, delay time.Duration, authMode, user, pass, orgID, apiKey string, err error) {
+func parsePromScrapeRangeArgs(rest string) (uri string, query string, start time.Time, end time.Time, step time.Duration, count int, delay time.Duration, authMode, user, pass, orgID, apiKey string, authArgs []string, err error) {
 	i := 0
 	skipSpaces := func() {
 		for i < len(rest) && (rest[i] == ' ' || rest[i] == '\t') {
@@ -732,14 +772,14 @@ func parsePromScrapeRangeArgs(rest string) (uri string, query string, start time
 	}
 	if i == startIdx {
 		err = fmt.Errorf("missing PROM_API_URI")
-		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, err
+		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, authArgs, err
 	}
 	uri = rest[startIdx:i]
 	skipSpaces()
 	// Query token (quoted or unquoted)
 	if i >= len(rest) {
 		err = fmt.Errorf("missing query expression")
-		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, err
+		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, authArgs, err
 	}
 	if rest[i] == '\'' || rest[i] == '"' {
 		quote := rest[i]
@@ -753,7 +793,7 @@ func parsePromScrapeRangeArgs(rest string) (uri string, query string, start time
 		}
 		if i >= len(rest) {
 			err = fmt.Errorf("unterminated quoted query")
-			return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, err
+			return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, authArgs, err
 		}
 		query = rest[qStart:i]
 		i++
@@ -768,7 +808,7 @@ func parsePromScrapeRangeArgs(rest string) (uri string, query string, start time
 	// start time
 	if i >= len(rest) {
 		err = fmt.Errorf("missing start time")
-		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, err
+		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, authArgs, err
 	}
 	sStart := i
 	for i < len(rest) && rest[i] != ' ' && rest[i] != '\t' {
@@ -778,13 +818,13 @@ func parsePromScrapeRangeArgs(rest string) (uri string, query string, start time
 	start, err = parseEvalTime(startStr)
 	if err != nil {
 		err = fmt.Errorf("invalid start time %q: %w", startStr, err)
-		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, err
+		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, authArgs, err
 	}
 	skipSpaces()
 	// end time
 	if i >= len(rest) {
 		err = fmt.Errorf("missing end time")
-		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, err
+		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, authArgs, err
 	}
 	eStart := i
 	for i < len(rest) && rest[i] != ' ' && rest[i] != '\t' {
@@ -794,13 +834,13 @@ func parsePromScrapeRangeArgs(rest string) (uri string, query string, start time
 	end, err = parseEvalTime(endStr)
 	if err != nil {
 		err = fmt.Errorf("invalid end time %q: %w", endStr, err)
-		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, err
+		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, authArgs, err
 	}
 	skipSpaces()
 	// step duration
 	if i >= len(rest) {
 		err = fmt.Errorf("missing step duration")
-		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, err
+		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, authArgs, err
 	}
 	stStart := i
 	for i < len(rest) && rest[i] != ' ' && rest[i] != '\t' {
@@ -810,7 +850,7 @@ func parsePromScrapeRangeArgs(rest string) (uri string, query string, start time
 	step, err = time.ParseDuration(stepStr)
 	if err != nil {
 		err = fmt.Errorf("invalid step duration %q: %w", stepStr, err)
-		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, err
+		return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, authArgs, err
 	}
 	skipSpaces()
 	// optional count
@@ -839,18 +879,8 @@ func parsePromScrapeRangeArgs(rest string) (uri string, query string, start time
 			skipSpaces()
 		}
 	}
-	// optional auth KV tokens
-	for i < len(rest) {
-		// read token
-		startTok := i
-		for i < len(rest) && rest[i] != ' ' && rest[i] != '\t' {
-			i++
-		}
-		tok := rest[startTok:i]
-		skipSpaces()
-		if tok == "" {
-			break
-		}
+	// optional auth KV tokens, quoted values may hold spaces
+	for _, tok := range splitArgs(rest[i:]) {
 		if eq := strings.IndexByte(tok, '='); eq > 0 {
 			k := strings.ToLower(strings.TrimSpace(tok[:eq]))
 			v := strings.TrimSpace(tok[eq+1:])
@@ -865,8 +895,12 @@ func parsePromScrapeRangeArgs(rest string) (uri string, query string, start time
 				orgID = v
 			case "api_key", "apikey":
 				apiKey = v
+			default:
+				if isScrapeAuthKey(k) {
+					authArgs = append(authArgs, tok)
+				}
 			}
 		}
 	}
-	return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, err
+	return uri, query, start, end, step, count, delay, authMode, user, pass, orgID, apiKey, authArgs, err
 }
@@ -0,0 +1,281 @@
+package repl
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// joinUnmatchedLimit caps the unmatched label values listed by .join.
+const joinUnmatchedLimit = 5
+
+// JoinMapping maps the values of a key label to the labels they add, e.g. instance
+// values to their team and owner.
+type JoinMapping struct {
+	Key    string
+	Labels []string // the labels added, sorted
+	Values map[string]map[string]string
+}
+
+// LoadJoinMapping reads a mapping keyed by the key label from a CSV file, whose header
+// names the key column and the labels of the others, or a JSON file: a list of objects
+// with the key and the labels, or an object of label sets by key value.
+func LoadJoinMapping(path, key string) (*JoinMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	m := &JoinMapping{Key: key, Values: map[string]map[string]string{}}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = m.readJSON(f)
+	} else {
+		err = m.readCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	names := map[string]bool{}
+	for _, lbls := range m.Values {
+		for name := range lbls {
+			if name == "" || name == labels.MetricName || name == key {
+				return nil, fmt.Errorf("%s: invalid label name %q", path, name)
+			}
+			names[name] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: no labels to join besides %s", path, key)
+	}
+	m.Labels = slices.Sorted(maps.Keys(names))
+	return m, nil
+}
+
+func (m *JoinMapping) readCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return errors.New("empty file")
+	}
+	header := rows[0]
+	keyCol := slices.Index(header, m.Key)
+	if keyCol < 0 {
+		return fmt.Errorf("no %s column in the header %q", m.Key, strings.Join(header, ","))
+	}
+	for _, row := range rows[1:] {
+		lbls := map[string]string{}
+		for i, v := range row {
+			if i != keyCol && v != "" {
+				lbls[strings.TrimSpace(header[i])] = v
+			}
+		}
+		m.add(row[keyCol], lbls)
+	}
+	return nil
+}
+
+func (m *JoinMapping) readJSON(r io.Reader) error {
+	var doc any
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return err
+	}
+	switch doc := doc.(type) {
+	case []any:
+		for i, item := range doc {
+			obj, ok := item.(map[string]any)
+			if !ok {
+				return fmt.Errorf("item %d is not an object", i+1)
+			}
+			key, ok := obj[m.Key]
+			if !ok {
+				return fmt.Errorf("item %d has no %s", i+1, m.Key)
+			}
+			lbls := map[string]string{}
+			for k, v := range obj {
+				if k != m.Key {
+					lbls[k] = jsonLabelValue(v)
+				}
+			}
+			m.add(jsonLabelValue(key), lbls)
+		}
+	case map[string]any:
+		for key, item := range doc {
+			obj, ok := item.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s: not an object of labels", key)
+			}
+			lbls := map[string]string{}
+			for k, v := range obj {
+				lbls[k] = jsonLabelValue(v)
+			}
+			m.add(key, lbls)
+		}
+	default:
+		return errors.New("expected a list of objects or an object of label sets by key value")
+	}
+	return nil
+}
+
+// add maps a key value to lbls; later entries for a value add to, and override, earlier ones.
+func (m *JoinMapping) add(value string, lbls map[string]string) {
+	if m.Values[value] == nil {
+		m.Values[value] = map[string]string{}
+	}
+	maps.Copy(m.Values[value], lbls)
+}
+
+// jsonLabelValue renders a JSON value as a label value.
+func jsonLabelValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// JoinResult summarizes a join, counting series (not samples).
+type JoinResult struct {
+	Series, Joined int
+	Unmatched      []string // key values without a mapping, sorted
+}
+
+// JoinLabels adds the labels mapped to the key label value of each series of metric to
+// it, overriding labels of the same name.
+func JoinLabels(storage *sstorage.SimpleStorage, metric string, m *JoinMapping) JoinResult {
+	return joinSeries(storage, metric, m, func(s *sstorage.MetricSample, lbls map[string]string) {
+		merged := maps.Clone(s.Labels)
+		maps.Copy(merged, lbls)
+		s.Labels = merged
+	})
+}
+
+// JoinInfoMetric stores, as the info metric info, a series of value 1 for each key label
+// value of metric found in the mapping, with the mapped labels, at the timestamps of the
+// series of metric. It replaces any metric called info, and returns the series created.
+func JoinInfoMetric(storage *sstorage.SimpleStorage, metric string, m *JoinMapping, info string) (JoinResult, int) {
+	timestamps := map[string]map[int64]bool{}
+	res := joinSeries(storage, metric, m, func(s *sstorage.MetricSample, _ map[string]string) {
+		value := s.Labels[m.Key]
+		if timestamps[value] == nil {
+			timestamps[value] = map[int64]bool{}
+		}
+		timestamps[value][s.Timestamp] = true
+	})
+	var samples []sstorage.MetricSample
+	for value, ts := range timestamps {
+		lbls := maps.Clone(m.Values[value])
+		lbls[labels.MetricName] = info
+		lbls[m.Key] = value
+		for t := range ts {
+			samples = append(samples, sstorage.MetricSample{Labels: lbls, Value: 1, Timestamp: t})
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+	storage.Metrics[info] = samples
+	storage.MetricsType[info] = "gauge"
+	storage.MetricsHelp[info] = fmt.Sprintf("Labels of %s by %s, joined by .join", metric, m.Key)
+	storage.InvalidateIndex()
+	return res, len(timestamps)
+}
+
+// joinSeries calls join with the mapped labels of each sample of metric whose key label
+// value has a mapping.
+func joinSeries(storage *sstorage.SimpleStorage, metric string, m *JoinMapping, join func(*sstorage.MetricSample, map[string]string)) JoinResult {
+	var res JoinResult
+	seen := map[string]bool{}
+	unmatched := map[string]bool{}
+	samples := storage.Metrics[metric]
+	for i := range samples {
+		s := &samples[i]
+		value := s.Labels[m.Key]
+		lbls, ok := m.Values[value]
+		if sig := seriesSignature(metric, s.Labels); !seen[sig] {
+			seen[sig] = true
+			res.Series++
+			if ok {
+				res.Joined++
+			} else {
+				unmatched[value] = true
+			}
+		}
+		if ok {
+			join(s, lbls)
+		}
+	}
+	res.Unmatched = slices.Sorted(maps.Keys(unmatched))
+	storage.InvalidateIndex()
+	return res
+}
+
+// handleAdhocJoin joins labels from a mapping file to the series of a metric:
+// .join <metric> on <label> from <file.csv|json> [as <info_metric>]
+func handleAdhocJoin(query string, storage *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimPrefix(query, ".join"))
+	valid := (len(fields) == 5 || len(fields) == 7 && fields[5] == "as") && fields[1] == "on" && fields[3] == "from"
+	if !valid {
+		cmd := GetAdHocCommandByName(".join")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	metric, key, path := fields[0], fields[2], strings.Trim(fields[4], "\"'")
+	if _, ok := storage.Metrics[metric]; !ok {
+		fmt.Printf("Error: no metric %s\n", metric)
+		return true
+	}
+	m, err := LoadJoinMapping(path, key)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	var res JoinResult
+	if len(fields) == 7 {
+		info := fields[6]
+		if info == metric {
+			fmt.Printf("Error: the info metric must not be %s itself\n", metric)
+			return true
+		}
+		var created int
+		res, created = JoinInfoMetric(storage, metric, m, info)
+		fmt.Printf("Created %s with %d series of %s (%s)\n", info, created, key, strings.Join(m.Labels, ", "))
+		fmt.Printf("Join it with: %s * on (%s) group_left (%s) %s\n", metric, key, strings.Join(m.Labels, ", "), info)
+	} else {
+		res = JoinLabels(storage, metric, m)
+		fmt.Printf("Joined %s onto %d of %d series of %s by %s\n", strings.Join(m.Labels, ", "), res.Joined, res.Series, metric, key)
+	}
+	if n := len(res.Unmatched); n > 0 {
+		shown := slices.Clone(res.Unmatched[:min(n, joinUnmatchedLimit)])
+		for i, v := range shown {
+			if v == "" {
+				shown[i] = "(none)"
+			}
+		}
+		more := ""
+		if n > len(shown) {
+			more = fmt.Sprintf(", ... (%d more)", n-len(shown))
+		}
+		fmt.Printf("No mapping for %d %s values: %s%s\n", n, key, strings.Join(shown, ", "), more)
+	}
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+)
+
+func writeJoinFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadJoinMapping(t *testing.T) {
+	csvPath := writeJoinFile(t, "owners.csv", "instance,team,owner\nweb-1:8080,checkout,alice\nweb-2:8080,search,\n")
+	jsonList := writeJoinFile(t, "list.json", `[{"instance":"web-1:8080","team":"checkout","owner":"alice"},{"instance":"web-2:8080","team":"search"}]`)
+	jsonObj := writeJoinFile(t, "obj.json", `{"web-1:8080":{"team":"checkout","owner":"alice"},"web-2:8080":{"team":"search"}}`)
+	for _, path := range []string{csvPath, jsonList, jsonObj} {
+		m, err := LoadJoinMapping(path, "instance")
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if strings.Join(m.Labels, ",") != "owner,team" || m.Values["web-1:8080"]["owner"] != "alice" || len(m.Values["web-2:8080"]) != 1 {
+			t.Fatalf("%s: unexpected mapping %+v", path, m)
+		}
+	}
+	for content, want := range map[string]string{
+		"host,team\na,b\n":             "no instance column",
+		"instance\na\n":                "no labels to join",
+		"instance,__name__\na,b\n":     "invalid label name",
+		`[{"team":"checkout"}]` + "\n": "item 1 has no instance",
+	} {
+		name := "m.csv"
+		if strings.HasPrefix(content, "[") {
+			name = "m.json"
+		}
+		if _, err := LoadJoinMapping(writeJoinFile(t, name, content), "instance"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", content, want, err)
+		}
+	}
+}
+
+func TestAdhoc_Join(t *testing.T) {
+	path := writeJoinFile(t, "owners.csv", "instance,team\nweb-1:8080,checkout\n")
+
+	store := newRelabelStore(t)
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".join http_requests_total on instance from "+path, store) })
+	if !strings.Contains(out, "Joined team onto 1 of 2 series of http_requests_total by instance") || !strings.Contains(out, "No mapping for 1 instance values: web-2:8080") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	for _, s := range store.Metrics["http_requests_total"] {
+		if want := map[string]string{"web-1:8080": "checkout", "web-2:8080": ""}[s.Labels["instance"]]; s.Labels["team"] != want {
+			t.Fatalf("unexpected labels %v", s.Labels)
+		}
+	}
+	if _, ok := store.Metrics["go_goroutines"][0].Labels["team"]; ok {
+		t.Fatalf("expected other metrics left alone")
+	}
+
+	store = newRelabelStore(t)
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".join http_requests_total on instance from "+path+" as instance_team_info", store)
+	})
+	if !strings.Contains(out, "Created instance_team_info with 1 series of instance (team)") || !strings.Contains(out, "group_left (team) instance_team_info") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	if len(store.Metrics["instance_team_info"]) != 2 || store.MetricsType["instance_team_info"] != "gauge" {
+		t.Fatalf("expected an info sample per timestamp, got %v", store.Metrics["instance_team_info"])
+	}
+	eng := newTestEngine()
+	q, err := eng.NewInstantQuery(t.Context(), QueryableFor(store), nil, `sum by (team) (http_requests_total * on (instance) group_left (team) instance_team_info)`, time.UnixMilli(1700000060000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := q.Exec(t.Context())
+	if v, ok := res.Value.(promql.Vector); res.Err != nil || !ok || len(v) != 1 || v[0].Metric.Get("team") != "checkout" || v[0].F != 2 {
+		t.Fatalf("unexpected joined query result: %v %v", res.Value, res.Err)
+	}
+
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".join http_requests_total on instance", store)
+		_ = handleAdHocFunction(".join missing on instance from "+path, store)
+	})
+	if !strings.Contains(out, "Usage: .join") || !strings.Contains(out, "Error: no metric missing") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
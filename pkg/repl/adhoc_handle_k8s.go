@@ -0,0 +1,265 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// k8sScrapeTimeout bounds each kubectl call of .k8s_scrape.
+const k8sScrapeTimeout = 60 * time.Second
+
+// k8sTarget is a pod or service scraped by .k8s_scrape through the API server proxy.
+type k8sTarget struct {
+	Namespace string
+	Kind      string // pods or services
+	Name      string
+	Port      string // number or name, resolved from the object when empty
+	Path      string // defaults to the prometheus.io/path annotation or /metrics
+	Scheme    string // https from the prometheus.io/scheme annotation, http otherwise
+}
+
+// kubectlCommand returns the kubectl binary: $PROMQL_CLI_KUBECTL, or kubectl in $PATH.
+func kubectlCommand() string {
+	if k := strings.TrimSpace(os.Getenv("PROMQL_CLI_KUBECTL")); k != "" {
+		return k
+	}
+	return "kubectl"
+}
+
+// parseK8sTarget parses <namespace>/<pod>, <namespace>/pod/<pod> or <namespace>/svc/<service>
+// followed by an optional port and /path. context= and kubeconfig= are returned as kubectl flags.
+func parseK8sTarget(args []string) (k8sTarget, []string, error) {
+	var t k8sTarget
+	if len(args) == 0 {
+		return t, nil, errors.New("missing <namespace>/<pod|svc/name>")
+	}
+	parts := strings.Split(args[0], "/")
+	switch {
+	case len(parts) == 2:
+		t.Namespace, t.Kind, t.Name = parts[0], "pods", parts[1]
+	case len(parts) == 3:
+		t.Namespace, t.Name = parts[0], parts[2]
+		switch strings.ToLower(parts[1]) {
+		case "pod", "pods", "po":
+			t.Kind = "pods"
+		case "svc", "service", "services":
+			t.Kind = "services"
+		default:
+			return t, nil, fmt.Errorf("unknown kind %q (expected pod or svc)", parts[1])
+		}
+	default:
+		return t, nil, fmt.Errorf("invalid target %q (expected <namespace>/<pod> or <namespace>/svc/<service>)", args[0])
+	}
+	if t.Namespace == "" || t.Name == "" {
+		return t, nil, fmt.Errorf("invalid target %q: empty namespace or name", args[0])
+	}
+
+	var flags []string
+	for _, arg := range args[1:] {
+		key, value, isKV := strings.Cut(arg, "=")
+		switch {
+		case isKV && (key == "context" || key == "kubeconfig"):
+			flags = append(flags, "--"+key+"="+value)
+		case isKV:
+			return t, nil, fmt.Errorf("unknown option %q (expected context= or kubeconfig=)", key)
+		case strings.HasPrefix(arg, "/"):
+			if t.Path != "" {
+				return t, nil, fmt.Errorf("path given twice: %q", arg)
+			}
+			t.Path = arg
+		case t.Port == "":
+			t.Port = arg
+		default:
+			return t, nil, fmt.Errorf("unexpected argument %q", arg)
+		}
+	}
+	return t, flags, nil
+}
+
+// k8sObject holds the fields of a pod or service used to find its metrics port.
+type k8sObject struct {
+	Metadata struct {
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Containers []struct {
+			Ports []struct {
+				Name          string `json:"name"`
+				ContainerPort int    `json:"containerPort"`
+			} `json:"ports"`
+		} `json:"containers"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+// resolve fills in the port, path and scheme of t from the prometheus.io/port, path and
+// scheme annotations of its object, the first port named "metrics", or its first port.
+func (t *k8sTarget) resolve(obj k8sObject) error {
+	ann := obj.Metadata.Annotations
+	if t.Path == "" {
+		t.Path = ann["prometheus.io/path"]
+	}
+	if t.Path == "" {
+		t.Path = "/metrics"
+	}
+	if !strings.HasPrefix(t.Path, "/") {
+		t.Path = "/" + t.Path
+	}
+	t.Scheme = strings.ToLower(ann["prometheus.io/scheme"])
+	if t.Port != "" {
+		return nil
+	}
+	if t.Port = ann["prometheus.io/port"]; t.Port != "" {
+		return nil
+	}
+	var ports, names []string
+	for _, c := range obj.Spec.Containers {
+		for _, p := range c.Ports {
+			ports, names = append(ports, strconv.Itoa(p.ContainerPort)), append(names, p.Name)
+		}
+	}
+	for _, p := range obj.Spec.Ports {
+		ports, names = append(ports, strconv.Itoa(p.Port)), append(names, p.Name)
+	}
+	if len(ports) == 0 {
+		return fmt.Errorf("%s %s/%s exposes no port, pass one", strings.TrimSuffix(t.Kind, "s"), t.Namespace, t.Name)
+	}
+	t.Port = ports[0]
+	for i, name := range names {
+		if name == "metrics" || name == "http-metrics" {
+			t.Port = ports[i]
+			break
+		}
+	}
+	return nil
+}
+
+// proxyPath returns the API server proxy path of the target metrics.
+func (t k8sTarget) proxyPath() string {
+	name := t.Name + ":" + t.Port
+	if t.Scheme == "https" {
+		name = "https:" + name
+	}
+	return fmt.Sprintf("/api/v1/namespaces/%s/%s/%s/proxy%s", t.Namespace, t.Kind, name, t.Path)
+}
+
+// labels returns the target labels attached to the scraped series.
+func (t k8sTarget) labels() map[string]string {
+	if t.Kind == "services" {
+		return map[string]string{"namespace": t.Namespace, "service": t.Name}
+	}
+	return map[string]string{"namespace": t.Namespace, "pod": t.Name}
+}
+
+// kubectl runs kubectl with args, returning its stdout, or its stderr as the error.
+func kubectl(ctx context.Context, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, k8sScrapeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, kubectlCommand(), args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("kubectl %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("kubectl %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// k8sScrape fetches the metrics of t through the API server proxy (kubectl get --raw),
+// so neither a port-forward nor direct network access to the pod is needed.
+func k8sScrape(ctx context.Context, t *k8sTarget, flags []string) (*sstorage.SimpleStorage, error) {
+	if t.Port == "" || t.Path == "" {
+		kind := strings.TrimSuffix(t.Kind, "s")
+		data, err := kubectl(ctx, append([]string{"get", kind, t.Name, "-n", t.Namespace, "-o", "json"}, flags...)...)
+		if err != nil {
+			return nil, err
+		}
+		var obj k8sObject
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, fmt.Errorf("kubectl get %s: %w", kind, err)
+		}
+		if err := t.resolve(obj); err != nil {
+			return nil, err
+		}
+	}
+	data, err := kubectl(ctx, append([]string{"get", "--raw", t.proxyPath()}, flags...)...)
+	if err != nil {
+		return nil, err
+	}
+	scraped := sstorage.NewSimpleStorage()
+	if err := scraped.LoadFromReader(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+	attachTargetLabels(scraped, t.labels(), false)
+	return scraped, nil
+}
+
+// handleAdhocK8sScrape scrapes an in-cluster pod or service through the Kubernetes API:
+// .k8s_scrape <namespace>/<pod>|<namespace>/svc/<service> [port] [/path] [context=...] [kubeconfig=...]
+func handleAdhocK8sScrape(query string, storage *sstorage.SimpleStorage) bool {
+	t, flags, err := parseK8sTarget(strings.Fields(strings.TrimPrefix(query, ".k8s_scrape")))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		cmd := GetAdHocCommandByName(".k8s_scrape")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+
+	// Create a context that can be canceled by Ctrl-C
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGINT)
+	defer signal.Stop(sigChan)
+	go func() {
+		select {
+		case <-sigChan:
+			fmt.Println("\nKubernetes scrape interrupted")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	scraped, err := k8sScrape(ctx, &t, flags)
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Printf("Failed to scrape %s/%s/%s: %v\n", t.Namespace, t.Kind, t.Name, err)
+		}
+		return true
+	}
+	series, samples := mergeScrapedStore(storage, scraped)
+	totalMetrics, totalSamples := storeTotals(storage)
+	fmt.Printf("Scraped %s/%s/%s:%s%s: +%d series, +%d samples (total: %d metrics, %d samples)\n",
+		t.Namespace, t.Kind, t.Name, t.Port, t.Path, series, samples, totalMetrics, totalSamples)
+	if rAdded, rAlerts, rErr := EvaluateActiveRules(storage); rErr != nil {
+		fmt.Printf("Rules evaluation failed: %v\n", rErr)
+	} else if rAdded > 0 || rAlerts > 0 {
+		fmt.Printf("Rules: added %d samples; %d alerts\n", rAdded, rAlerts)
+	}
+	enforceStoreLimits(storage)
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestParseK8sTarget(t *testing.T) {
+	tgt, flags, err := parseK8sTarget([]string{"default/svc/app", "/stats", "http", "context=prod"})
+	if err != nil || tgt.Kind != "services" || tgt.Port != "http" || tgt.Path != "/stats" || len(flags) != 1 || flags[0] != "--context=prod" {
+		t.Fatalf("unexpected target %+v, flags %q, err %v", tgt, flags, err)
+	}
+	for _, args := range [][]string{{}, {"pod"}, {"ns/deploy/x"}, {"ns/p", "1", "2"}, {"ns/p", "namespace=x"}} {
+		if _, _, err := parseK8sTarget(args); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+
+	tgt = k8sTarget{Namespace: "ns", Kind: "pods", Name: "p"}
+	var obj k8sObject
+	obj.Metadata.Annotations = map[string]string{"prometheus.io/scheme": "https", "prometheus.io/path": "m"}
+	if err := tgt.resolve(obj); err == nil {
+		t.Fatal("expected an error without ports")
+	}
+	obj.Metadata.Annotations["prometheus.io/port"] = "8443"
+	if err := tgt.resolve(obj); err != nil || tgt.proxyPath() != "/api/v1/namespaces/ns/pods/https:p:8443/proxy/m" {
+		t.Fatalf("unexpected proxy path %q: %v", tgt.proxyPath(), err)
+	}
+}
+
+func TestAdhocK8sScrape(t *testing.T) {
+	dir := t.TempDir()
+	// A fake kubectl: the pod object, then its metrics through the proxy
+	script := `#!/bin/sh
+echo "$@" >> "` + dir + `/calls"
+case "$2" in
+pod) echo '{"spec":{"containers":[{"ports":[{"name":"web","containerPort":8080},{"name":"metrics","containerPort":9100}]}]}}' ;;
+--raw) printf 'up{pod="inner"} 1\n' ;;
+*) echo "Error from server (NotFound): services \"$3\" not found" >&2; exit 1 ;;
+esac
+`
+	kubectlPath := filepath.Join(dir, "kubectl")
+	if err := os.WriteFile(kubectlPath, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PROMQL_CLI_KUBECTL", kubectlPath)
+	store := sstorage.NewSimpleStorage()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".k8s_scrape monitoring/node-exporter-x context=prod", store) })
+	if !strings.Contains(out, "Scraped monitoring/pods/node-exporter-x:9100/metrics: +1 series, +1 samples") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	calls, _ := os.ReadFile(filepath.Join(dir, "calls"))
+	if !strings.Contains(string(calls), "get --raw /api/v1/namespaces/monitoring/pods/node-exporter-x:9100/proxy/metrics --context=prod") {
+		t.Fatalf("unexpected kubectl calls: %s", calls)
+	}
+	up := store.Metrics["up"]
+	if len(up) != 1 || up[0].Labels["namespace"] != "monitoring" || up[0].Labels["pod"] != "node-exporter-x" || up[0].Labels["exported_pod"] != "inner" {
+		t.Fatalf("unexpected series: %+v", up)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".k8s_scrape default/svc/missing", store) })
+	if !strings.Contains(out, `services "missing" not found`) {
+		t.Fatalf("expected the kubectl error: %s", out)
+	}
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".k8s_scrape", store) }); !strings.Contains(out, "Usage: .k8s_scrape") {
+		t.Fatalf("expected usage: %s", out)
+	}
+}
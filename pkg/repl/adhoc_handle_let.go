@@ -0,0 +1,160 @@
+package repl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// handleAdhocLet materializes a query result as a new metric, replacing any metric of that
+// name: .let <new_metric> = [.at <time>|<start>..<end> [step=<step>]] <query>
+// Instant queries run at the pinned evaluation time (or now), and store one sample per
+// series at that time; range queries store every point of the resulting matrix.
+func handleAdhocLet(query string, storage *sstorage.SimpleStorage) bool {
+	usage := func() {
+		cmd := GetAdHocCommandByName(".let")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+	}
+	name, expr, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(query, ".let")), "=")
+	name, expr = strings.TrimSpace(name), strings.TrimSpace(expr)
+	if !ok || name == "" || expr == "" {
+		usage()
+		return true
+	}
+	if !model.LegacyValidation.IsValidMetricName(name) {
+		fmt.Printf("Error: invalid metric name %q\n", name)
+		usage()
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+
+	evalTime := time.Now()
+	if pinnedEvalTime != nil {
+		evalTime = *pinnedEvalTime
+	}
+	var atRange *atRangeSpec
+	if strings.HasPrefix(expr, ".at ") {
+		parts := strings.Fields(expr)
+		if len(parts) < 3 {
+			usage()
+			return true
+		}
+		if strings.Contains(parts[1], "..") {
+			spec, err := parseAtRange(expr)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return true
+			}
+			atRange, expr = spec, spec.expr
+		} else {
+			ts, err := parseEvalTime(parts[1])
+			if err != nil {
+				fmt.Printf("Error: invalid time %q: %v\n", parts[1], err)
+				return true
+			}
+			evalTime, expr = ts, strings.TrimSpace(strings.TrimPrefix(expr, ".at "+parts[1]))
+		}
+	}
+	if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+		expr = alertExpr
+	}
+	expr = normalizeAtModifierTimestamps(expr)
+
+	var result *promql.Result
+	var err error
+	if atRange != nil {
+		result, err = RunRangeQuery(replEngine, storage, expr, atRange.start, atRange.end, atRange.step, replTimeout)
+	} else {
+		result, err = RunInstantQuery(replEngine, storage, expr, evalTime, replTimeout)
+	}
+	if err == nil {
+		err = checkLetResult(result)
+	}
+	if err != nil {
+		// The metric is left as it was
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+
+	replaced := len(storage.Metrics[name])
+	delete(storage.Metrics, name)
+	delete(storage.MetricsType, name)
+	series, samples, err := storeLetResult(storage, name, result)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	storage.MetricsHelp[name] = "Derived with .let from: " + expr
+	storage.InvalidateIndex()
+	fmt.Printf("Stored %d samples in %d series as %s", samples, series, name)
+	if replaced > 0 {
+		fmt.Printf(" (replacing %d samples)", replaced)
+	}
+	fmt.Println()
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+	return true
+}
+
+// checkLetResult tells whether result can be stored by storeLetResult.
+func checkLetResult(result *promql.Result) error {
+	switch result.Value.(type) {
+	case promql.Vector, promql.Matrix, promql.Scalar:
+		return nil
+	}
+	return fmt.Errorf("cannot store a %s result", result.Value.Type())
+}
+
+// storeLetResult adds the samples of result to storage under the metric name, keeping the
+// other labels of each series. It returns the series and samples stored.
+func storeLetResult(storage *sstorage.SimpleStorage, name string, result *promql.Result) (int, int, error) {
+	seriesLabels := func(m labels.Labels) map[string]string {
+		lbls := m.Map()
+		lbls[labels.MetricName] = name
+		return lbls
+	}
+	series, samples := 0, 0
+	switch v := result.Value.(type) {
+	case promql.Vector:
+		for _, s := range v {
+			if s.H != nil {
+				storage.AddHistogramSample(seriesLabels(s.Metric), s.H, s.T)
+			} else {
+				storage.AddSample(seriesLabels(s.Metric), s.F, s.T)
+			}
+			series++
+			samples++
+		}
+	case promql.Matrix:
+		for _, s := range v {
+			lbls := seriesLabels(s.Metric)
+			for _, p := range s.Floats {
+				storage.AddSample(lbls, p.F, p.T)
+			}
+			for _, p := range s.Histograms {
+				storage.AddHistogramSample(lbls, p.H, p.T)
+			}
+			series++
+			samples += len(s.Floats) + len(s.Histograms)
+		}
+	case promql.Scalar:
+		storage.AddSample(map[string]string{labels.MetricName: name}, v.V, v.T)
+		series, samples = 1, 1
+	default:
+		return 0, 0, checkLetResult(result)
+	}
+	return series, samples, nil
+}
@@ -0,0 +1,56 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Let(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	content := "http_requests_total{code=\"200\",job=\"api\"} 100 1700000000000\n" +
+		"http_requests_total{code=\"200\",job=\"api\"} 160 1700000060000\n" +
+		"http_requests_total{code=\"500\",job=\"api\"} 10 1700000060000\n"
+	if err := store.LoadFromReader(strings.NewReader(content)); err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	prevEngine, prevPinned := replEngine, pinnedEvalTime
+	replEngine = newTestEngine()
+	pinned := time.UnixMilli(1700000060000)
+	pinnedEvalTime = &pinned
+	defer func() { replEngine, pinnedEvalTime = prevEngine, prevPinned }()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".let job:requests:sum = sum by (job) (http_requests_total)", store) })
+	if !strings.Contains(out, "Stored 1 samples in 1 series as job:requests:sum") {
+		t.Fatalf("unexpected .let output: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(replEngine, store, "job:requests:sum * 2") })
+	if !strings.Contains(out, `{job="api"} => 340 @ 2023-11-14T22:14:20Z`) {
+		t.Fatalf("expected a query on the derived metric, got: %s", out)
+	}
+
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".let job:requests:sum = .at 1700000000..1700000060 step=30s http_requests_total", store)
+	})
+	if !strings.Contains(out, "Stored 4 samples in 2 series as job:requests:sum (replacing 1 samples)") {
+		t.Fatalf("unexpected range .let output: %s", out)
+	}
+	if got := store.MetricHelp("job:requests:sum"); got != "Derived with .let from: http_requests_total" {
+		t.Fatalf("unexpected help: %q", got)
+	}
+
+	// A result that can't be stored leaves the metric as it was
+	out = captureStdout(t, func() { _ = handleAdHocFunction(`.let job:requests:sum = "text"`, store) })
+	if !strings.Contains(out, "Error: cannot store a string result") || len(store.Metrics["job:requests:sum"]) != 4 {
+		t.Fatalf("expected the metric kept, got %d samples: %s", len(store.Metrics["job:requests:sum"]), out)
+	}
+
+	for _, bad := range []string{".let", ".let x =", ".let 1x = up"} {
+		out = captureStdout(t, func() { _ = handleAdHocFunction(bad, store) })
+		if !strings.Contains(out, "Usage: .let") {
+			t.Fatalf("%q: expected usage, got: %s", bad, out)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jjo/promql-cli/pkg/lint"
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// LintQueries lints each expression, using the store's TYPE metadata to tell counters from
+// gauges, and writes the findings to w. Ad-hoc commands are skipped. It returns the number
+// of issues found (parse errors included).
+func LintQueries(w io.Writer, storage *sstorage.SimpleStorage, queries []string) int {
+	opts := lint.Options{Parser: promParser}
+	if storage != nil {
+		opts.MetricType = storage.MetricType
+	}
+	total := 0
+	for _, q := range queries {
+		if strings.HasPrefix(q, ".") {
+			continue
+		}
+		issues, err := lint.Lint(q, opts)
+		if err != nil {
+			mustFprintf(w, "%s\n  parse error: %v\n", q, err)
+			total++
+			continue
+		}
+		if len(issues) == 0 {
+			continue
+		}
+		mustFprintf(w, "%s\n", q)
+		for _, is := range issues {
+			mustFprintf(w, "  %s\n", is)
+		}
+		total += len(issues)
+	}
+	return total
+}
+
+// handleAdhocLint lints a query or the expressions of a .promql file: .lint <query|file.promql>
+func handleAdhocLint(query string, storage *sstorage.SimpleStorage) bool {
+	arg := strings.TrimSpace(strings.TrimPrefix(query, ".lint"))
+	if arg == "" {
+		cmd := GetAdHocCommandByName(".lint")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	queries := []string{arg}
+	if path := strings.Trim(arg, "\"'"); !strings.ContainsAny(path, "({[ ") {
+		if fileQueries, err := ReadQueriesFromFile(path); err == nil {
+			queries = fileQueries
+		}
+	}
+	if n := LintQueries(os.Stdout, storage, queries); n == 0 {
+		fmt.Printf("No issues found (%d expressions)\n", len(queries))
+	} else {
+		fmt.Printf("%d issues found\n", n)
+	}
+	return true
+}
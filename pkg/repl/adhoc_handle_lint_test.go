@@ -0,0 +1,30 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Lint(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	if err := store.LoadFromReader(strings.NewReader("# TYPE temperature gauge\ntemperature{room=\"a\"} 20\n")); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".lint rate(temperature[5m])", store) })
+	if !strings.Contains(out, "[rate-on-gauge]") || !strings.Contains(out, "1 issues found") {
+		t.Fatalf("expected a rate-on-gauge finding, got: %s", out)
+	}
+
+	path := filepath.Join(t.TempDir(), "queries.promql")
+	if err := os.WriteFile(path, []byte("# comment\nsum(rate(http_requests_total[5m]))\n\ndelta(temperature[5m])\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".lint "+path, store) })
+	if !strings.Contains(out, "No issues found (2 expressions)") {
+		t.Fatalf("expected a clean file, got: %s", out)
+	}
+}
@@ -0,0 +1,54 @@
+package repl
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// handleAdhocMeminfo reports the store memory, compacting it first with "compact":
+// .meminfo [compact]
+func handleAdhocMeminfo(query string, storage *sstorage.SimpleStorage) bool {
+	switch arg := strings.TrimSpace(strings.TrimPrefix(query, ".meminfo")); arg {
+	case "":
+		printMemStats(storage.MemStats(), heapInUse())
+	case "compact":
+		before, beforeHeap := storage.MemStats(), heapInUse()
+		storage.Compact()
+		after, afterHeap := storage.MemStats(), heapInUse()
+		fmt.Println("Before compaction:")
+		printMemStats(before, beforeHeap)
+		fmt.Println("After compaction:")
+		printMemStats(after, afterHeap)
+		fmt.Printf("Saved: %s estimated, %s of Go heap\n", formatBytes(uint64(max(before.Bytes-after.Bytes, 0))), formatBytes(beforeHeap-min(afterHeap, beforeHeap)))
+	default:
+		fmt.Printf("Error: invalid argument %q\n", arg)
+		cmd := GetAdHocCommandByName(".meminfo")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+	}
+	return true
+}
+
+// heapInUse returns the bytes of the Go heap in use after a garbage collection.
+func heapInUse() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapInuse
+}
+
+func printMemStats(st sstorage.MemStats, heap uint64) {
+	perSeries := 0.0
+	if st.Series > 0 {
+		perSeries = float64(st.LabelSets) / float64(st.Series)
+	}
+	fmt.Printf("  Metrics: %d  Series: %d  Samples: %d\n", st.Metrics, st.Series, st.Samples)
+	fmt.Printf("  Label sets: %d (%.1f per series)  Label strings: %d (%s of distinct string data)\n",
+		st.LabelSets, perSeries, st.LabelStrings, formatBytes(uint64(st.StringBytes)))
+	fmt.Printf("  Store size: %s (estimated)  Go heap in use: %s\n", formatBytes(uint64(st.Bytes)), formatBytes(heap))
+}
@@ -0,0 +1,26 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhocMeminfo(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	for ts := int64(1); ts <= 5; ts++ {
+		store.AddSample(map[string]string{"__name__": "up", "job": "api"}, 1, ts*1000)
+	}
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".meminfo", store) })
+	if !strings.Contains(out, "Series: 1  Samples: 5") || !strings.Contains(out, "Label sets: 5 (5.0 per series)") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".meminfo compact", store) })
+	if !strings.Contains(out, "After compaction:\n  Metrics: 1  Series: 1  Samples: 5\n  Label sets: 1 (1.0 per series)") || !strings.Contains(out, "Saved: ") {
+		t.Fatalf("unexpected compact output: %s", out)
+	}
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".meminfo now", store) }); !strings.Contains(out, "Usage: .meminfo") {
+		t.Fatalf("expected usage: %s", out)
+	}
+}
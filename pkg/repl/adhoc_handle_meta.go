@@ -0,0 +1,89 @@
+package repl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// handleAdhocMeta shows the metadata and shape of a metric in one view: HELP, TYPE, sample
+// and series counts, label names and the first and last timestamps. For a histogram or
+// summary family (foo for foo_bucket, foo_sum and foo_count) it covers all its series.
+// Usage: .meta <metric>
+func handleAdhocMeta(query string, storage *sstorage.SimpleStorage) bool {
+	name := strings.Trim(strings.TrimSpace(strings.TrimPrefix(query, ".meta")), " \"'")
+	if name == "" {
+		cmd := GetAdHocCommandByName(".meta")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	members := []string{name}
+	if _, ok := storage.Metrics[name]; !ok {
+		members = nil
+		for m := range storage.Metrics {
+			if m != name && storage.MetricFamily(m) == name {
+				members = append(members, m)
+			}
+		}
+		sort.Strings(members)
+	}
+	if len(members) == 0 {
+		fmt.Printf("Metric '%s' not found\n", name)
+		return true
+	}
+
+	series := map[string]bool{}
+	labelNames := map[string]bool{}
+	var samples int
+	var minTs, maxTs int64
+	for _, m := range members {
+		for _, s := range storage.Metrics[m] {
+			if samples == 0 || s.Timestamp < minTs {
+				minTs = s.Timestamp
+			}
+			if samples == 0 || s.Timestamp > maxTs {
+				maxTs = s.Timestamp
+			}
+			samples++
+			series[formatSeries(s.Labels)] = true
+			for k := range s.Labels {
+				if k != "__name__" {
+					labelNames[k] = true
+				}
+			}
+		}
+	}
+	names := make([]string, 0, len(labelNames))
+	for k := range labelNames {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	orNone := func(s string) string {
+		if s == "" {
+			return "(none)"
+		}
+		return s
+	}
+	fmt.Printf("Metric: %s\n", name)
+	fmt.Printf("  HELP: %s\n", orNone(storage.MetricHelp(name)))
+	fmt.Printf("  TYPE: %s\n", orNone(storage.MetricType(name)))
+	if family := storage.MetricFamily(name); family != name {
+		fmt.Printf("  Family: %s\n", family)
+	}
+	if len(members) > 1 || members[0] != name {
+		fmt.Printf("  Series names: %s\n", strings.Join(members, ", "))
+	}
+	fmt.Printf("  Samples: %d in %d series\n", samples, len(series))
+	fmt.Printf("  Labels: %s\n", orNone(strings.Join(names, ", ")))
+	if samples > 0 {
+		fmt.Printf("  First: %s\n", formatDisplayMillis(minTs))
+		fmt.Printf("  Last:  %s\n", formatDisplayMillis(maxTs))
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Meta(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	content := "# HELP rd Request duration.\n# TYPE rd histogram\n" +
+		"rd_bucket{le=\"1\",job=\"api\"} 1 1700000000000\n" +
+		"rd_bucket{le=\"+Inf\",job=\"api\"} 2 1700000060000\n" +
+		"rd_sum{job=\"api\"} 3 1700000060000\n" +
+		"rd_count{job=\"api\"} 2 1700000060000\n"
+	if err := store.LoadFromReader(strings.NewReader(content)); err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".meta rd", store) })
+	for _, want := range []string{
+		"HELP: Request duration.", "TYPE: histogram", "Series names: rd_bucket, rd_count, rd_sum",
+		"Samples: 4 in 4 series", "Labels: job, le", "First: 2023-11-14T22:13:20Z", "Last:  2023-11-14T22:14:20Z",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in .meta output:\n%s", want, out)
+		}
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".meta rd_sum", store) })
+	if !strings.Contains(out, "TYPE: histogram") || !strings.Contains(out, "Family: rd") || !strings.Contains(out, "Samples: 1 in 1 series") {
+		t.Fatalf("unexpected .meta output for a family series:\n%s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".meta nope", store) })
+	if !strings.Contains(out, "Metric 'nope' not found") {
+		t.Fatalf("expected not found, got:\n%s", out)
+	}
+}
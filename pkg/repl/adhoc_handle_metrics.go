@@ -3,11 +3,15 @@ package repl
 import (
 	"fmt"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+
 	sstorage "github.com/jjo/promql-cli/pkg/storage"
 )
 
@@ -21,9 +25,23 @@ func storeTotals(storage *sstorage.SimpleStorage) (int, int) {
 	return totalMetrics, totalSamples
 }
 
-func handleAdhocStats(_ string, storage *sstorage.SimpleStorage) bool {
-	tm, ts := storeTotals(storage)
-	fmt.Printf("Total: %d metrics, %d samples\n", tm, ts)
+// handleAdhocStats shows the store totals, or turns per-query stats on or off: .stats [on|off]
+func handleAdhocStats(query string, storage *sstorage.SimpleStorage) bool {
+	switch arg := strings.TrimSpace(strings.TrimPrefix(query, ".stats")); arg {
+	case "":
+		tm, ts := storeTotals(storage)
+		fmt.Printf("Total: %d metrics, %d samples\n", tm, ts)
+		state := "off"
+		if showQueryStats {
+			state = "on"
+		}
+		fmt.Printf("Query stats: %s\n", state)
+	case "on", "off":
+		SetQueryStats(arg == "on")
+		fmt.Printf("Query stats: %s\n", arg)
+	default:
+		fmt.Println("Usage: " + GetAdHocCommandByName(".stats").Usage)
+	}
 	return true
 }
 
@@ -37,6 +55,19 @@ func handleAdhocMetrics(_ string, storage *sstorage.SimpleStorage) bool {
 		names = append(names, name)
 	}
 	sort.Strings(names)
+	if jsonlOutput() {
+		type metricJSON struct {
+			Name    string `json:"name"`
+			Type    string `json:"type,omitempty"`
+			Samples int    `json:"samples"`
+		}
+		items := make([]metricJSON, 0, len(names))
+		for _, n := range names {
+			items = append(items, metricJSON{Name: n, Type: storage.MetricsType[n], Samples: len(storage.Metrics[n])})
+		}
+		printJSONL(items)
+		return true
+	}
 	fmt.Printf("Metrics (%d):\n", len(names))
 	for _, n := range names {
 		fmt.Printf("  - %s\n", n)
@@ -91,6 +122,25 @@ func handleAdhocLabels(query string, storage *sstorage.SimpleStorage) bool {
 		}
 	}
 
+	if jsonlOutput() {
+		type labelJSON struct {
+			Label  string   `json:"label"`
+			Values []string `json:"values"`
+		}
+		var items []labelJSON
+		for labelName := range labelNames {
+			values := make([]string, 0, len(labelValues[labelName]))
+			for v := range labelValues[labelName] {
+				values = append(values, v)
+			}
+			sort.Strings(values)
+			items = append(items, labelJSON{Label: labelName, Values: values})
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+		printJSONL(items)
+		return true
+	}
+
 	// Display results
 	fmt.Printf("Labels for metric '%s' (%d samples):\n", metricName, len(samples))
 	if len(labelNames) == 0 {
@@ -217,8 +267,8 @@ func handleAdhocTimestamps(query string, storage *sstorage.SimpleStorage) bool {
 	fmt.Printf("  Series: %d\n", seriesCount)
 	fmt.Printf("  Samples: %d\n", len(samples))
 	fmt.Printf("  Unique timestamps: %d\n", uniqueCount)
-	fmt.Printf("  Earliest: %s (unix_ms=%d)\n", time.UnixMilli(minTs).UTC().Format(time.RFC3339), minTs)
-	fmt.Printf("  Latest:   %s (unix_ms=%d)\n", time.UnixMilli(maxTs).UTC().Format(time.RFC3339), maxTs)
+	fmt.Printf("  Earliest: %s (unix_ms=%d)\n", formatDisplayMillis(minTs), minTs)
+	fmt.Printf("  Latest:   %s (unix_ms=%d)\n", formatDisplayMillis(maxTs), maxTs)
 	fmt.Printf("  Span:     %s\n", span)
 	if exN > 0 {
 		fmt.Printf("  Examples: ")
@@ -226,7 +276,7 @@ func handleAdhocTimestamps(query string, storage *sstorage.SimpleStorage) bool {
 			if i > 0 {
 				fmt.Printf(", ")
 			}
-			fmt.Printf("%s", time.UnixMilli(ts[i]).UTC().Format(time.RFC3339))
+			fmt.Printf("%s", formatDisplayMillis(ts[i]))
 		}
 		fmt.Println()
 	}
@@ -237,34 +287,20 @@ func handleAdhocDrop(query string, storage *sstorage.SimpleStorage) bool {
 	arg := strings.TrimSpace(strings.TrimPrefix(query, ".drop"))
 	arg = strings.Trim(arg, " \"'")
 	if arg == "" {
-		fmt.Println("Usage: .drop <series regex>")
+		fmt.Println("Usage: " + GetAdHocCommandByName(".drop").Usage)
 		fmt.Println("Example: .drop '^up\\{.*instance=\"db-.*\".*\\}$'")
+		fmt.Println("Example: .drop {job=\"old\",__name__=~\"tmp_.*\"}")
 		return true
 	}
-	re, err := regexp.Compile(arg)
+	match, err := parseSeriesFilter(arg)
 	if err != nil {
-		fmt.Printf("Invalid regex: %v\n", err)
+		fmt.Printf("Error: %v\n", err)
 		return true
 	}
-	removed := 0
-	for name, samples := range storage.Metrics {
-		kept := samples[:0]
-		for _, s := range samples {
-			if re.MatchString(seriesSignature(name, s.Labels)) {
-				removed++
-				continue
-			}
-			kept = append(kept, s)
-		}
-		if len(kept) == 0 {
-			delete(storage.Metrics, name)
-		} else {
-			storage.Metrics[name] = kept
-		}
-	}
+	series, removed := pruneSeries(storage, match, false)
 	// Report new totals
 	totalMetrics, totalSamples := storeTotals(storage)
-	fmt.Printf("Dropped %d samples (now: %d metrics, %d samples)\n", removed, totalMetrics, totalSamples)
+	fmt.Printf("Dropped %d samples in %d series (now: %d metrics, %d samples)\n", removed, series, totalMetrics, totalSamples)
 	if refreshMetricsCache != nil {
 		refreshMetricsCache(storage)
 	}
@@ -275,24 +311,66 @@ func handleAdhocKeep(query string, storage *sstorage.SimpleStorage) bool {
 	arg := strings.TrimSpace(strings.TrimPrefix(query, ".keep"))
 	arg = strings.Trim(arg, " \"'")
 	if arg == "" {
-		fmt.Println("Usage: .keep <series regex>")
+		fmt.Println("Usage: " + GetAdHocCommandByName(".keep").Usage)
 		fmt.Println("Example: .keep 'node_cpu_seconds_total\\{.*mode=\"idle\".*\\}'")
+		fmt.Println("Example: .keep {job=\"node\"}")
 		return true
 	}
-	re, err := regexp.Compile(arg)
+	match, err := parseSeriesFilter(arg)
 	if err != nil {
-		fmt.Printf("Invalid regex: %v\n", err)
+		fmt.Printf("Error: %v\n", err)
 		return true
 	}
+	series, removed := pruneSeries(storage, match, true)
+	totalMetrics, totalSamples := storeTotals(storage)
+	fmt.Printf("Kept %s (removed %d samples in %d series; now: %d metrics, %d samples)\n", arg, removed, series, totalMetrics, totalSamples)
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+	return true
+}
+
+// parseSeriesFilter parses the series argument of .drop and .keep: a selector with label
+// matchers, such as {job="old",__name__=~"tmp_.*"} or up{instance=~"db-.*"}, or else a
+// regex matched against series signatures, name{labels}.
+func parseSeriesFilter(arg string) (func(name string, lbls map[string]string) bool, error) {
+	if strings.Contains(arg, "{") {
+		if matchers, err := promParser.ParseMetricSelector(arg); err == nil {
+			return func(_ string, lbls map[string]string) bool {
+				for _, m := range matchers {
+					if !m.Matches(lbls[m.Name]) {
+						return false
+					}
+				}
+				return true
+			}, nil
+		} else if strings.HasPrefix(arg, "{") {
+			return nil, fmt.Errorf("invalid selector: %v", err)
+		}
+	}
+	re, err := regexp.Compile(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %v", err)
+	}
+	return func(name string, lbls map[string]string) bool {
+		return re.MatchString(seriesSignature(name, lbls))
+	}, nil
+}
+
+// pruneSeries removes the series that match (or, with keep, that don't), returning the
+// number of series and samples removed.
+func pruneSeries(storage *sstorage.SimpleStorage, match func(name string, lbls map[string]string) bool, keep bool) (int, int) {
+	series := map[string]bool{}
 	removed := 0
 	for name, samples := range storage.Metrics {
 		kept := samples[:0]
 		for _, s := range samples {
-			if re.MatchString(seriesSignature(name, s.Labels)) {
+			if match(name, s.Labels) == keep {
 				kept = append(kept, s)
-			} else {
-				removed++
+				continue
 			}
+			series[seriesSignature(name, s.Labels)] = true
+			removed++
 		}
 		if len(kept) == 0 {
 			delete(storage.Metrics, name)
@@ -300,12 +378,8 @@ func handleAdhocKeep(query string, storage *sstorage.SimpleStorage) bool {
 			storage.Metrics[name] = kept
 		}
 	}
-	totalMetrics, totalSamples := storeTotals(storage)
-	fmt.Printf("Kept regex %q (removed %d samples; now: %d metrics, %d samples)\n", arg, removed, totalMetrics, totalSamples)
-	if refreshMetricsCache != nil {
-		refreshMetricsCache(storage)
-	}
-	return true
+	storage.InvalidateIndex()
+	return len(series), removed
 }
 
 // handleAdhocRename handles the .rename command
@@ -355,6 +429,12 @@ func handleAdhocRules(query string, storage *sstorage.SimpleStorage) bool {
 		}
 		return true
 	}
+	if args[1] == "eval" {
+		return handleAdhocRulesEval(args[2:], storage)
+	}
+	if args[1] == "deps" && len(args) == 2 {
+		return handleAdhocRulesDeps()
+	}
 	// set
 	spec := strings.TrimSpace(strings.Trim(args[1], "\"'"))
 	files, err := ResolveRuleSpec(spec)
@@ -374,16 +454,98 @@ func handleAdhocRules(query string, storage *sstorage.SimpleStorage) bool {
 	return true
 }
 
-// .alerts command: shows alerting rules from active rule files
-func handleAdhocAlerts(_ string, storage *sstorage.SimpleStorage) bool {
-	alerts := GetAlertingRules()
-	if len(alerts) == 0 {
-		fmt.Println("Alerts: none")
+// handleAdhocRulesDeps prints the active recording rules in evaluation order with the
+// recording rules each one reads: .rules deps
+func handleAdhocRulesDeps() bool {
+	_, files := GetActiveRules()
+	if len(files) == 0 {
+		fmt.Println("No active rules. Set them first with: .rules <dir|glob|file>")
 		return true
 	}
-	fmt.Printf("Alerting rules (%d):\n", len(alerts))
-	for _, a := range alerts {
-		fmt.Printf("  %s: %s\n", a.Name, a.Expr)
+	groups, err := loadRuleGroups(files)
+	if err != nil {
+		fmt.Printf(".rules: %v\n", err)
+		return true
+	}
+	rules := recordingRules(groups)
+	deps, err := recordingRuleDeps(rules)
+	if err != nil {
+		fmt.Printf(".rules: %v\n", err)
+		return true
+	}
+	reads := map[string][]string{}
+	for i, r := range rules {
+		for _, j := range deps[i] {
+			if name := rules[j].Record; !slices.Contains(reads[r.Record], name) {
+				reads[r.Record] = append(reads[r.Record], name)
+			}
+		}
+	}
+	ordered, cyclic, _ := OrderRecordingRules(rules)
+	fmt.Printf("Recording rules in evaluation order (%d):\n", len(ordered))
+	for i, r := range ordered {
+		line := fmt.Sprintf("  %d. %s", i+1, r.Record)
+		if len(reads[r.Record]) > 0 {
+			line += "  <- " + strings.Join(reads[r.Record], ", ")
+		}
+		fmt.Println(line)
+	}
+	if len(cyclic) > 0 {
+		fmt.Printf("Cycle (evaluated in file order): %s\n", strings.Join(cyclic, ", "))
+	}
+	return true
+}
+
+// handleAdhocRulesEval backfills the active recording rules: .rules eval <start> <end> <step>
+func handleAdhocRulesEval(args []string, storage *sstorage.SimpleStorage) bool {
+	if len(args) != 3 {
+		fmt.Println("Usage: .rules eval <start> <end> <step>")
+		fmt.Println("Example: .rules eval now-6h now 1m")
+		return true
+	}
+	_, files := GetActiveRules()
+	if len(files) == 0 {
+		fmt.Println("No active rules. Set them first with: .rules <dir|glob|file>")
+		return true
+	}
+	engine := evalEngine
+	if engine == nil {
+		engine = replEngine
+	}
+	if engine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	start, end, step, err := ParseRangeSpec(args[0], args[1], args[2])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	res, err := BackfillRecordingRules(engine, storage, files, start, end, step)
+	if err != nil {
+		fmt.Printf("Backfill failed: %v\n", err)
+	}
+	names := make([]string, 0, len(res.Samples))
+	total := 0
+	for name, n := range res.Samples {
+		names = append(names, name)
+		total += n
+	}
+	sort.Strings(names)
+	fmt.Printf("Backfilled %d samples over %d steps (%s to %s, step %s)", total, res.Steps,
+		start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339), model.Duration(step))
+	if res.Replaced > 0 {
+		fmt.Printf(", replacing %d previously recorded samples", res.Replaced)
+	}
+	fmt.Println()
+	if len(res.Cyclic) > 0 {
+		fmt.Printf("Warning: recording rules depend on each other in a cycle, evaluated in file order: %s\n", strings.Join(res.Cyclic, ", "))
+	}
+	for _, name := range names {
+		fmt.Printf("  %s: %d samples\n", name, res.Samples[name])
+	}
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
 	}
 	return true
 }
@@ -438,3 +600,87 @@ func handleAdhocSeed(query string, storage *sstorage.SimpleStorage) bool {
 	}
 	return true
 }
+
+// handleAdhocExemplars lists the exemplars stored for the series matching a metric name
+// or selector: .exemplars <metric|selector>
+func handleAdhocExemplars(query string, storage *sstorage.SimpleStorage) bool {
+	arg := strings.Trim(strings.TrimSpace(strings.TrimPrefix(query, ".exemplars")), "\"'")
+	if arg == "" {
+		fmt.Println("Usage: " + GetAdHocCommandByName(".exemplars").Usage)
+		return true
+	}
+	matchers, err := promParser.ParseMetricSelector(arg)
+	if err != nil {
+		fmt.Printf("Invalid selector %q: %v\n", arg, err)
+		return true
+	}
+
+	type seriesExemplars struct {
+		sig     string
+		created int64
+		samples []sstorage.MetricSample
+	}
+	bySig := map[string]*seriesExemplars{}
+	for name, samples := range storage.Metrics {
+		for _, s := range samples {
+			if s.Exemplar == nil || !matchesLabels(matchers, name, s.Labels) {
+				continue
+			}
+			sig := seriesSignature(name, s.Labels)
+			se := bySig[sig]
+			if se == nil {
+				se = &seriesExemplars{sig: sig}
+				bySig[sig] = se
+			}
+			se.created = max(se.created, s.Created)
+			se.samples = append(se.samples, s)
+		}
+	}
+	if len(bySig) == 0 {
+		fmt.Printf("No exemplars found for %s (exemplars are loaded from OpenMetrics input, i.e. ending with '# EOF')\n", arg)
+		return true
+	}
+
+	sigs := make([]string, 0, len(bySig))
+	for sig := range bySig {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+	total := 0
+	for _, sig := range sigs {
+		se := bySig[sig]
+		if se.created != 0 {
+			fmt.Printf("%s (created %s)\n", sig, time.UnixMilli(se.created).UTC().Format(time.RFC3339Nano))
+		} else {
+			fmt.Println(sig)
+		}
+		sort.SliceStable(se.samples, func(i, j int) bool { return se.samples[i].Timestamp < se.samples[j].Timestamp })
+		for _, s := range se.samples {
+			ex := s.Exemplar
+			line := fmt.Sprintf("  %s value=%s  exemplar %s %s",
+				time.UnixMilli(s.Timestamp).UTC().Format(time.RFC3339Nano), strconv.FormatFloat(s.Value, 'g', -1, 64),
+				seriesSignature("", ex.Labels), strconv.FormatFloat(ex.Value, 'g', -1, 64))
+			if ex.HasTimestamp {
+				line += " @ " + time.UnixMilli(ex.Timestamp).UTC().Format(time.RFC3339Nano)
+			}
+			fmt.Println(line)
+			total++
+		}
+	}
+	fmt.Printf("%d exemplars in %d series\n", total, len(sigs))
+	return true
+}
+
+// matchesLabels reports whether the series name{lbls} satisfies all matchers.
+func matchesLabels(matchers []*labels.Matcher, name string, lbls map[string]string) bool {
+	for _, m := range matchers {
+		v := lbls[m.Name]
+		if m.Name == labels.MetricName {
+			v = name
+		}
+		if !m.Matches(v) {
+			return false
+		}
+	}
+	return true
+}
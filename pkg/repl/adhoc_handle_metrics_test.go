@@ -0,0 +1,51 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Exemplars_OpenMetrics(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "om.prom")
+	content := `# TYPE http_requests counter
+http_requests_total{code="200"} 10 1700000000 # {trace_id="abc123"} 1 1699999999.5
+http_requests_total{code="200"} 12 1700000060
+http_requests_total{code="500"} 1 1700000060 # {trace_id="def456"} 1
+http_requests_created{code="200"} 1699990000
+# EOF
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	store := sstorage.NewSimpleStorage()
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".load "+path, store) })
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".exemplars http_requests_total", store) })
+	for _, want := range []string{
+		`http_requests_total{code="200"} (created 2023-11-14T19:26:40Z)`,
+		`  2023-11-14T22:13:20Z value=10  exemplar {trace_id="abc123"} 1 @ 2023-11-14T22:13:19.5Z`,
+		`http_requests_total{code="500"}`,
+		"2 exemplars in 2 series",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output, got: %s", want, out)
+		}
+	}
+	if strings.Contains(out, "value=12") {
+		t.Fatalf("samples without exemplars should not be listed: %s", out)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(`.exemplars http_requests_total{code="500"}`, store) })
+	if !strings.Contains(out, "def456") || strings.Contains(out, "abc123") {
+		t.Fatalf("expected only the code=500 exemplar, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".exemplars up", store) })
+	if !strings.Contains(out, "No exemplars found for up") {
+		t.Fatalf("expected no exemplars message, got: %s", out)
+	}
+}
@@ -0,0 +1,254 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// madScale makes the median absolute deviation comparable to a standard deviation for
+// normally distributed data.
+const madScale = 1.4826
+
+// OutlierOptions controls FindOutliers and .outliers.
+type OutlierOptions struct {
+	Threshold float64       // flag series whose |score| exceeds this
+	Method    string        // "mad" (median/MAD, robust) or "z" (mean/stddev)
+	Range     time.Duration // total window evaluated, baseline plus recent
+	Recent    time.Duration // trailing part of Range compared against the baseline
+	Step      time.Duration // range query resolution, 0 for automatic
+}
+
+// Outlier is a series whose recent values deviate from its own baseline.
+type Outlier struct {
+	Series   string
+	Score    float64 // deviation of Recent from Baseline, in (robust) standard deviations
+	Baseline float64 // median or mean of the baseline points
+	Recent   float64 // median or mean of the recent points
+}
+
+// FindOutliers compares, for each float series of m, its points at or after recentFrom
+// (Unix millis) with the earlier ones, and returns the series scoring beyond the threshold,
+// most severe first. Series with fewer than 3 baseline points or no recent point are not
+// scored; checked counts the scored ones.
+func FindOutliers(m promql.Matrix, recentFrom int64, opts OutlierOptions) (outliers []Outlier, checked int) {
+	for _, s := range m {
+		var base, recent []float64
+		for _, p := range s.Floats {
+			if math.IsNaN(p.F) || math.IsInf(p.F, 0) {
+				continue
+			}
+			if p.T >= recentFrom {
+				recent = append(recent, p.F)
+			} else {
+				base = append(base, p.F)
+			}
+		}
+		if len(base) < 3 || len(recent) == 0 {
+			continue
+		}
+		checked++
+		var center, spread, value float64
+		if opts.Method == "z" {
+			center, value = mean(base), mean(recent)
+			for _, v := range base {
+				spread += (v - center) * (v - center)
+			}
+			spread = math.Sqrt(spread / float64(len(base)))
+		} else {
+			center, value = median(base), median(recent)
+			dev := make([]float64, len(base))
+			for i, v := range base {
+				dev[i] = math.Abs(v - center)
+			}
+			spread = madScale * median(dev)
+		}
+		var score float64
+		switch {
+		case spread > 0:
+			score = (value - center) / spread
+		case value != center:
+			// A flat baseline makes any change infinitely unusual.
+			score = math.Inf(1)
+			if value < center {
+				score = math.Inf(-1)
+			}
+		}
+		if math.Abs(score) > opts.Threshold {
+			outliers = append(outliers, Outlier{Series: s.Metric.String(), Score: score, Baseline: center, Recent: value})
+		}
+	}
+	sort.Slice(outliers, func(i, j int) bool {
+		if a, b := math.Abs(outliers[i].Score), math.Abs(outliers[j].Score); a != b {
+			return a > b
+		}
+		return outliers[i].Series < outliers[j].Series
+	})
+	return outliers, checked
+}
+
+func mean(vs []float64) float64 {
+	sum := 0.0
+	for _, v := range vs {
+		sum += v
+	}
+	return sum / float64(len(vs))
+}
+
+// median returns the median of vs, sorting a copy.
+func median(vs []float64) float64 {
+	s := append([]float64(nil), vs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// printOutliers writes outliers as a table, most severe first.
+func printOutliers(w io.Writer, outliers []Outlier) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	mustFprintln(tw, "  SCORE\tBASELINE\tRECENT\tSERIES")
+	for _, o := range outliers {
+		mustFprintf(tw, "  %s\t%s\t%s\t%s\n", strconv.FormatFloat(o.Score, 'f', 1, 64),
+			strconv.FormatFloat(o.Baseline, 'g', 6, 64), strconv.FormatFloat(o.Recent, 'g', 6, 64), o.Series)
+	}
+	_ = tw.Flush()
+}
+
+// parseOutliersArgs splits ".outliers" arguments into the query and its trailing
+// z=, range=, recent=, step= and method= options.
+func parseOutliersArgs(args string) (string, OutlierOptions, error) {
+	opts := OutlierOptions{Threshold: 3, Method: "mad", Range: time.Hour}
+	fields := strings.Fields(args)
+	for len(fields) > 0 {
+		k, v, ok := strings.Cut(fields[len(fields)-1], "=")
+		if !ok {
+			break
+		}
+		var err error
+		switch strings.ToLower(k) {
+		case "z":
+			opts.Threshold, err = strconv.ParseFloat(v, 64)
+			if err == nil && (opts.Threshold <= 0 || math.IsNaN(opts.Threshold)) {
+				err = fmt.Errorf("must be positive")
+			}
+		case "range":
+			opts.Range, err = parseOutliersDuration(v)
+		case "recent":
+			opts.Recent, err = parseOutliersDuration(v)
+		case "step":
+			opts.Step, err = parseOutliersDuration(v)
+		case "method":
+			opts.Method = strings.ToLower(v)
+			if opts.Method != "mad" && opts.Method != "z" {
+				err = fmt.Errorf("expected mad or z")
+			}
+		default:
+			k = "" // part of the query, e.g. a label matcher
+		}
+		if k == "" {
+			break
+		}
+		if err != nil {
+			return "", opts, fmt.Errorf("invalid %s: %w", k, err)
+		}
+		fields = fields[:len(fields)-1]
+	}
+	if opts.Recent == 0 {
+		opts.Recent = opts.Range / 10
+	}
+	if opts.Recent >= opts.Range {
+		return "", opts, fmt.Errorf("recent window must be shorter than the range")
+	}
+	expr := strings.Join(fields, " ")
+	if expr == "" {
+		return "", opts, fmt.Errorf("missing query")
+	}
+	return expr, opts, nil
+}
+
+func parseOutliersDuration(s string) (time.Duration, error) {
+	d, err := model.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return time.Duration(d), nil
+}
+
+// handleAdhocOutliers flags series whose recent values deviate from their own baseline:
+// .outliers <query> [z=3] [range=1h] [recent=range/10] [step=auto] [method=mad|z]
+func handleAdhocOutliers(query string, storage *sstorage.SimpleStorage) bool {
+	expr, opts, err := parseOutliersArgs(strings.TrimPrefix(query, ".outliers"))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		cmd := GetAdHocCommandByName(".outliers")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+		expr = alertExpr
+	}
+	expr = normalizeAtModifierTimestamps(expr)
+
+	end := time.Now()
+	if pinnedEvalTime != nil {
+		end = *pinnedEvalTime
+	}
+	start := end.Add(-opts.Range)
+	step := opts.Step
+	if step <= 0 {
+		// Enough points for a stable baseline, and at least a few in the recent window.
+		step = max(min(opts.Range/120, opts.Recent/3).Truncate(time.Second), time.Second)
+	}
+	if opts.Range/step > maxRangePoints {
+		fmt.Printf("Error: exceeded maximum resolution of %d points per series, try a larger step\n", maxRangePoints)
+		return true
+	}
+	result, err := RunRangeQuery(replEngine, storage, expr, start, end, step, replTimeout)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	m, ok := result.Value.(promql.Matrix)
+	if !ok || len(m) == 0 {
+		fmt.Printf("No data for %s in the last %s (see .pinat to check older data)\n", expr, model.Duration(opts.Range))
+		return true
+	}
+	recentFrom := end.Add(-opts.Recent).UnixMilli()
+	outliers, checked := FindOutliers(m, recentFrom, opts)
+	fmt.Printf("%s: last %s vs the %s before it (step %s, %s, |score| > %g)\n", expr,
+		model.Duration(opts.Recent), model.Duration(opts.Range-opts.Recent), model.Duration(step), opts.Method, opts.Threshold)
+	if skipped := len(m) - checked; skipped > 0 {
+		fmt.Printf("(%d series skipped: fewer than 3 baseline points or no recent points)\n", skipped)
+	}
+	if len(outliers) == 0 {
+		fmt.Printf("No outliers among %d series\n", checked)
+		return true
+	}
+	fmt.Printf("%d of %d series are outliers:\n", len(outliers), checked)
+	printOutliers(os.Stdout, outliers)
+	return true
+}
@@ -0,0 +1,68 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestParseOutliersArgs(t *testing.T) {
+	expr, opts, err := parseOutliersArgs(` rate(http_requests_total{code="500"}[5m]) z=4 range=6h method=z`)
+	if err != nil {
+		t.Fatalf("parseOutliersArgs: %v", err)
+	}
+	if expr != `rate(http_requests_total{code="500"}[5m])` || opts.Threshold != 4 || opts.Range != 6*time.Hour ||
+		opts.Recent != 36*time.Minute || opts.Method != "z" {
+		t.Fatalf("unexpected parse: %q %+v", expr, opts)
+	}
+	for _, bad := range []string{"", "up z=0", "up method=iqr", "up range=10m recent=10m"} {
+		if _, _, err := parseOutliersArgs(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestAdhoc_Outliers(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	end := time.Unix(1700003600, 0)
+	for i := 0; i <= 60; i++ {
+		ts := end.Add(time.Duration(i-60) * time.Minute).UnixMilli()
+		jitter := float64(i%3) - 1
+		for host, v := range map[string]float64{"a": 10 + jitter, "b": 20 + jitter, "c": 30 + jitter} {
+			if host == "b" && i > 54 {
+				v = 80
+			}
+			if host == "c" && i > 54 {
+				v = 10
+			}
+			store.AddSample(map[string]string{"__name__": "load", "host": host}, v, ts)
+		}
+	}
+	prevEngine, prevPinned := replEngine, pinnedEvalTime
+	replEngine = newTestEngine()
+	pinnedEvalTime = &end
+	defer func() { replEngine, pinnedEvalTime = prevEngine, prevPinned }()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".outliers load z=5", store) })
+	if !strings.Contains(out, "2 of 3 series are outliers:") {
+		t.Fatalf("expected 2 outliers, got: %s", out)
+	}
+	b, c := strings.Index(out, `host="b"`), strings.Index(out, `host="c"`)
+	if b < 0 || c < 0 || b > c || strings.Contains(out, `host="a"`) {
+		t.Fatalf("expected b then c flagged, a not flagged: %s", out)
+	}
+	if !strings.Contains(out, "-13.5") {
+		t.Fatalf("expected negative score for c: %s", out)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".outliers load z=100", store) })
+	if !strings.Contains(out, "No outliers among 3 series") {
+		t.Fatalf("expected no outliers, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".outliers", store) })
+	if !strings.Contains(out, "Usage: .outliers <query>") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+}
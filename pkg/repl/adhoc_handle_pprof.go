@@ -0,0 +1,294 @@
+package repl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	httppprof "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strings"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// snapshotProfiles are the runtime/pprof profiles written on demand (or when profiling
+// stops), as opposed to cpu and trace, which record between a start and a stop.
+var snapshotProfiles = []string{"heap", "allocs", "goroutine", "block", "mutex", "threadcreate"}
+
+var (
+	// cpuProfileFile and traceFile are the files of the running CPU profile and
+	// execution trace, if any.
+	cpuProfileFile *os.File
+	traceFile      *os.File
+	// profilesAtStop maps snapshot profiles (--profile heap=...) to the file they are
+	// written to when profiling stops.
+	profilesAtStop = map[string]string{}
+	// pprofServer serves net/http/pprof, when started with --profile http= or .pprof http.
+	pprofServer *http.Server
+)
+
+// StartProfiling starts the profiles of spec, comma or space separated kind=value pairs:
+// cpu=<file> and trace=<file> record until StopProfiling, heap, allocs, goroutine, block,
+// mutex and threadcreate are written to their file by StopProfiling, and http=<addr>
+// serves net/http/pprof on addr. Already started profiles are stopped on error.
+func StartProfiling(spec string) error {
+	fields := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(fields) == 0 {
+		return errors.New("profile: empty spec")
+	}
+	for _, f := range fields {
+		kind, value, ok := strings.Cut(f, "=")
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if !ok || strings.TrimSpace(value) == "" {
+			_ = StopProfiling()
+			return fmt.Errorf("profile: expected kind=value, got %q", f)
+		}
+		var err error
+		switch {
+		case kind == "cpu" || kind == "trace":
+			err = startRecording(kind, value)
+		case kind == "http":
+			_, err = startPprofServer(value)
+		case isSnapshotProfile(kind):
+			enableProfileRate(kind)
+			profilesAtStop[kind] = value
+		default:
+			err = fmt.Errorf("unknown profile %q (expected cpu|trace|%s|http)", kind, strings.Join(snapshotProfiles, "|"))
+		}
+		if err != nil {
+			_ = StopProfiling()
+			return fmt.Errorf("profile: %w", err)
+		}
+	}
+	return nil
+}
+
+// StopProfiling stops the CPU profile and trace, writes the profiles requested for the stop
+// and shuts the pprof listener down. It returns the first error.
+func StopProfiling() error {
+	_, err := stopRecording()
+	names := make([]string, 0, len(profilesAtStop))
+	for name := range profilesAtStop {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if werr := writeProfile(name, profilesAtStop[name]); err == nil {
+			err = werr
+		}
+	}
+	profilesAtStop = map[string]string{}
+	stopPprofServer()
+	return err
+}
+
+func isSnapshotProfile(name string) bool {
+	for _, p := range snapshotProfiles {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// enableProfileRate turns on the sampling of the block and mutex profiles, off by default.
+func enableProfileRate(name string) {
+	switch name {
+	case "block":
+		runtime.SetBlockProfileRate(1)
+	case "mutex":
+		runtime.SetMutexProfileFraction(1)
+	}
+}
+
+// startRecording starts the CPU profile (kind cpu) or execution trace (kind trace) into path.
+func startRecording(kind, path string) error {
+	if kind == "cpu" && cpuProfileFile != nil || kind == "trace" && traceFile != nil {
+		return fmt.Errorf("%s profiling already running", kind)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if kind == "cpu" {
+		err = pprof.StartCPUProfile(f)
+	} else {
+		err = trace.Start(f)
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if kind == "cpu" {
+		cpuProfileFile = f
+	} else {
+		traceFile = f
+	}
+	return nil
+}
+
+// stopRecording stops the running CPU profile and trace, returning the files written.
+func stopRecording() ([]string, error) {
+	var written []string
+	var err error
+	if cpuProfileFile != nil {
+		pprof.StopCPUProfile()
+		if cerr := cpuProfileFile.Close(); cerr != nil {
+			err = cerr
+		}
+		written = append(written, "CPU profile "+cpuProfileFile.Name())
+		cpuProfileFile = nil
+	}
+	if traceFile != nil {
+		trace.Stop()
+		if cerr := traceFile.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		written = append(written, "trace "+traceFile.Name())
+		traceFile = nil
+	}
+	return written, err
+}
+
+// writeProfile writes the named runtime/pprof profile to path.
+func writeProfile(name, path string) error {
+	p := pprof.Lookup(name)
+	if p == nil {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	if name == "heap" {
+		// Get up-to-date statistics, as go test -memprofile does
+		runtime.GC()
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := p.WriteTo(f, 0); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// startPprofServer serves net/http/pprof on addr, returning the address it listens on.
+func startPprofServer(addr string) (string, error) {
+	if pprofServer != nil {
+		return "", fmt.Errorf("pprof listener already running on %s", pprofServer.Addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	pprofServer = &http.Server{Addr: ln.Addr().String(), Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func(srv *http.Server) { _ = srv.Serve(ln) }(pprofServer)
+	return pprofServer.Addr, nil
+}
+
+func stopPprofServer() {
+	if pprofServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_ = pprofServer.Shutdown(ctx)
+	pprofServer = nil
+}
+
+// handleAdhocPprof captures profiles of promql-cli itself:
+// .pprof | .pprof start [cpu|trace] <file> | .pprof stop | .pprof <heap|allocs|...> <file> | .pprof http <addr>|off
+func handleAdhocPprof(query string, _ *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimPrefix(query, ".pprof"))
+	var err error
+	switch {
+	case len(fields) == 0:
+		printPprofStatus()
+		return true
+	case fields[0] == "start" && (len(fields) == 2 || len(fields) == 3 && (fields[1] == "cpu" || fields[1] == "trace")):
+		kind, path := "cpu", fields[len(fields)-1]
+		if len(fields) == 3 {
+			kind = fields[1]
+		}
+		if err = startRecording(kind, path); err == nil {
+			fmt.Printf("Started %s profiling to %s (stop with .pprof stop)\n", kind, path)
+			return true
+		}
+	case fields[0] == "stop" && len(fields) == 1:
+		written, serr := stopRecording()
+		if serr != nil {
+			err = serr
+			break
+		}
+		if len(written) == 0 {
+			fmt.Println("No CPU profile or trace running")
+		}
+		for _, w := range written {
+			fmt.Printf("Wrote %s\n", w)
+		}
+		return true
+	case fields[0] == "http" && len(fields) == 2:
+		if fields[1] == "off" {
+			stopPprofServer()
+			fmt.Println("pprof listener: off")
+			return true
+		}
+		var addr string
+		if addr, err = startPprofServer(fields[1]); err == nil {
+			fmt.Printf("pprof listener on http://%s/debug/pprof/\n", addr)
+			return true
+		}
+	case isSnapshotProfile(fields[0]) && len(fields) == 2:
+		enableProfileRate(fields[0])
+		if err = writeProfile(fields[0], fields[1]); err == nil {
+			fmt.Printf("Wrote %s profile to %s\n", fields[0], fields[1])
+			if fields[0] == "block" || fields[0] == "mutex" {
+				fmt.Printf("Note: %s profiling was off until now; write it again later for meaningful data\n", fields[0])
+			}
+			return true
+		}
+	default:
+		err = errors.New("invalid arguments")
+	}
+	fmt.Printf("Error: %v\n", err)
+	cmd := GetAdHocCommandByName(".pprof")
+	fmt.Println("Usage: " + cmd.Usage)
+	for _, ex := range cmd.Examples {
+		fmt.Println("Example: " + ex)
+	}
+	return true
+}
+
+// printPprofStatus prints the running profiles and pprof listener.
+func printPprofStatus() {
+	if cpuProfileFile == nil && traceFile == nil && pprofServer == nil && len(profilesAtStop) == 0 {
+		fmt.Println("Profiling: off")
+		return
+	}
+	if cpuProfileFile != nil {
+		fmt.Printf("CPU profile: writing to %s\n", cpuProfileFile.Name())
+	}
+	if traceFile != nil {
+		fmt.Printf("Trace: writing to %s\n", traceFile.Name())
+	}
+	for _, name := range snapshotProfiles {
+		if path, ok := profilesAtStop[name]; ok {
+			fmt.Printf("%s profile: written to %s on exit\n", name, path)
+		}
+	}
+	if pprofServer != nil {
+		fmt.Printf("pprof listener: http://%s/debug/pprof/\n", pprofServer.Addr)
+	}
+}
@@ -0,0 +1,87 @@
+package repl
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestStartStopProfiling(t *testing.T) {
+	defer func() { _ = StopProfiling() }()
+	dir := t.TempDir()
+	cpu, heap := filepath.Join(dir, "cpu.out"), filepath.Join(dir, "heap.out")
+	if err := StartProfiling("cpu=" + cpu + ",heap=" + heap); err != nil {
+		t.Fatalf("StartProfiling: %v", err)
+	}
+	if err := StopProfiling(); err != nil {
+		t.Fatalf("StopProfiling: %v", err)
+	}
+	for _, path := range []string{cpu, heap} {
+		if st, err := os.Stat(path); err != nil || st.Size() == 0 {
+			t.Errorf("expected a profile in %s: %v", path, err)
+		}
+	}
+
+	for spec, want := range map[string]string{
+		"cpu":                        "expected kind=value",
+		"disk=/tmp/x":                "unknown profile",
+		"cpu=" + dir + "/no/cpu.out": "no such file",
+	} {
+		if err := StartProfiling(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", spec, want, err)
+		}
+	}
+	if cpuProfileFile != nil || len(profilesAtStop) != 0 {
+		t.Fatal("a failed StartProfiling should stop what it started")
+	}
+}
+
+func TestAdhocPprof(t *testing.T) {
+	defer func() { _ = StopProfiling() }()
+	store := sstorage.NewSimpleStorage()
+	dir := t.TempDir()
+	cpu, goroutine := filepath.Join(dir, "cpu.out"), filepath.Join(dir, "goroutine.out")
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".pprof start "+cpu, store) })
+	if !strings.Contains(out, "Started cpu profiling to "+cpu) {
+		t.Fatalf("unexpected start output: %s", out)
+	}
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".pprof", store) }); !strings.Contains(out, "CPU profile: writing to "+cpu) {
+		t.Fatalf("unexpected status: %s", out)
+	}
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".pprof start cpu "+cpu+".2", store) }); !strings.Contains(out, "already running") {
+		t.Fatalf("expected an error starting a second CPU profile: %s", out)
+	}
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".pprof stop", store) }); !strings.Contains(out, "Wrote CPU profile "+cpu) {
+		t.Fatalf("unexpected stop output: %s", out)
+	}
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".pprof goroutine "+goroutine, store) }); !strings.Contains(out, "Wrote goroutine profile") {
+		t.Fatalf("unexpected snapshot output: %s", out)
+	}
+	if st, err := os.Stat(goroutine); err != nil || st.Size() == 0 {
+		t.Fatalf("expected a goroutine profile: %v", err)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".pprof http 127.0.0.1:0", store) })
+	m := regexp.MustCompile(`http://\S+/debug/pprof/`).FindString(out)
+	if m == "" {
+		t.Fatalf("unexpected http output: %s", out)
+	}
+	resp, err := http.Get(m + "cmdline")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("pprof listener: %v %v", resp, err)
+	}
+	_ = resp.Body.Close()
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".pprof http off", store) }); !strings.Contains(out, "pprof listener: off") || pprofServer != nil {
+		t.Fatalf("unexpected http off output: %s", out)
+	}
+
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".pprof frobnicate", store) }); !strings.Contains(out, "Usage: .pprof") {
+		t.Fatalf("expected usage: %s", out)
+	}
+}
@@ -0,0 +1,185 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// maxRangePoints mirrors the Prometheus /query_range limit on points per series.
+const maxRangePoints = 11000
+
+// handleAdhocRange runs a range query: .range <start> <end> <step> <query>
+func handleAdhocRange(query string, storage *sstorage.SimpleStorage) bool {
+	rest := strings.TrimSpace(strings.TrimPrefix(query, ".range"))
+	parts := strings.Fields(rest)
+	if len(parts) < 4 {
+		fmt.Println("Usage: " + GetAdHocCommandByName(".range").Usage)
+		fmt.Println("Example: .range now-1h now 1m rate(http_requests_total[5m])")
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	start, end, step, err := ParseRangeSpec(parts[0], parts[1], parts[2])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	// Everything after the third argument is the PromQL expression (may contain spaces)
+	expr := rest
+	for range 3 {
+		expr = strings.TrimSpace(expr)
+		if i := strings.IndexAny(expr, " \t"); i >= 0 {
+			expr = expr[i:]
+		}
+	}
+	expr = strings.TrimSpace(expr)
+	if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+		expr = alertExpr
+	}
+	expr = normalizeAtModifierTimestamps(expr)
+
+	result, err := RunRangeQuery(replEngine, storage, expr, start, end, step, replTimeout)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	printResult(result)
+	printQueryStats(os.Stdout)
+	return true
+}
+
+// atRangeSpec is the range of ".at <start>..<end> [step=<step>] <query>", and its query.
+type atRangeSpec struct {
+	start, end time.Time
+	step       time.Duration
+	expr       string
+}
+
+// parseAtRange parses ".at <start>..<end> [step=<step>] <query>". Times accept the formats
+// of .pinat; an empty start or end, and the step, default as in ParseRangeSpec.
+func parseAtRange(query string) (*atRangeSpec, error) {
+	rest := strings.TrimSpace(strings.TrimPrefix(query, ".at"))
+	timeRange, rest, _ := strings.Cut(rest, " ")
+	startStr, endStr, _ := strings.Cut(timeRange, "..")
+	rest = strings.TrimSpace(rest)
+	var stepStr string
+	if strings.HasPrefix(rest, "step=") {
+		stepStr, rest, _ = strings.Cut(strings.TrimPrefix(rest, "step="), " ")
+		rest = strings.TrimSpace(rest)
+	}
+	if rest == "" {
+		return nil, fmt.Errorf("missing query after %q", timeRange)
+	}
+	start, end, step, err := ParseRangeSpec(startStr, endStr, stepStr)
+	if err != nil {
+		return nil, err
+	}
+	return &atRangeSpec{start: start, end: end, step: step, expr: rest}, nil
+}
+
+// RunRangeQuery evaluates expr over [start, end] at the given step, like the Prometheus
+// /query_range API. A step set by .with replaces the given one.
+func RunRangeQuery(engine *promql.Engine, storage *sstorage.SimpleStorage, expr string, start, end time.Time, step, timeout time.Duration) (*promql.Result, error) {
+	if rangeStepOverride > 0 {
+		step, rangeStepUsed = rangeStepOverride, true
+		if end.Sub(start)/step > maxRangePoints {
+			return nil, fmt.Errorf("exceeded maximum resolution of %d points per series, try a larger step", maxRangePoints)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	q, err := engine.NewRangeQuery(ctx, QueryableFor(storage), nil, expr, start, end, step)
+	if err != nil {
+		return nil, fmt.Errorf("creating query: %w", err)
+	}
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	collectQueryStats(q, res)
+	return res, nil
+}
+
+// RunInstantQuery evaluates expr at t, like the Prometheus /query API.
+func RunInstantQuery(engine *promql.Engine, storage *sstorage.SimpleStorage, expr string, t time.Time, timeout time.Duration) (*promql.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	q, err := engine.NewInstantQuery(ctx, QueryableFor(storage), nil, expr, t)
+	if err != nil {
+		return nil, fmt.Errorf("creating query: %w", err)
+	}
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	collectQueryStats(q, res)
+	return res, nil
+}
+
+// ParseRangeSpec parses start/end/step strings for a range query.
+// Times accept now[+-]duration, RFC3339 or unix seconds/millis; step accepts a
+// Prometheus duration (e.g. 30s, 1m) or a number of seconds.
+// Empty values default to end=now, start=end-1h and step=1m.
+func ParseRangeSpec(startStr, endStr, stepStr string) (time.Time, time.Time, time.Duration, error) {
+	end := time.Now()
+	if endStr != "" {
+		t, err := parseEvalTime(endStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid end time %q: %w", endStr, err)
+		}
+		end = t
+	}
+	start := end.Add(-time.Hour)
+	if startStr != "" {
+		t, err := parseEvalTime(startStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid start time %q: %w", startStr, err)
+		}
+		start = t
+	}
+	step := time.Minute
+	if stepStr != "" {
+		d, err := parseRangeStep(stepStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid step %q: %w", stepStr, err)
+		}
+		step = d
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("end time must not be before start time")
+	}
+	if step <= 0 {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("step must be a positive duration")
+	}
+	if end.Sub(start)/step > maxRangePoints {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("exceeded maximum resolution of %d points per series, try a larger step", maxRangePoints)
+	}
+	return start, end, step, nil
+}
+
+// parseRangeStep accepts a Prometheus duration or a (possibly fractional) number of seconds.
+func parseRangeStep(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, fmt.Errorf("not a finite number")
+		}
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	d, err := model.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(d), nil
+}
@@ -0,0 +1,93 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Range_RendersMatrix(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	content := "http_requests_total{code=\"200\"} 100 1700000000000\n" +
+		"http_requests_total{code=\"200\"} 160 1700000060000\n" +
+		"http_requests_total{code=\"200\"} 220 1700000120000\n"
+	if err := store.LoadFromReader(strings.NewReader(content)); err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	prev := replEngine
+	replEngine = newTestEngine()
+	defer func() { replEngine = prev }()
+
+	out := captureStdout(t, func() {
+		executeOne(replEngine, store, ".range 1700000060 1700000120 60 rate(http_requests_total[2m])")
+	})
+	if !strings.Contains(out, "Matrix (1 series):") {
+		t.Fatalf("expected matrix output, got: %s", out)
+	}
+	if strings.Count(out, " @ ") != 2 {
+		t.Fatalf("expected 2 points, got: %s", out)
+	}
+}
+
+func TestAt_Range(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	content := "http_requests_total{code=\"200\"} 100 1700000000000\n" +
+		"http_requests_total{code=\"200\"} 160 1700000060000\n" +
+		"http_requests_total{code=\"200\"} 220 1700000120000\n"
+	if err := store.LoadFromReader(strings.NewReader(content)); err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	engine := newTestEngine()
+
+	out := captureStdout(t, func() {
+		executeOne(engine, store, ".at 1700000000..1700000120 step=30s http_requests_total")
+	})
+	if !strings.Contains(out, "Matrix (1 series):") || strings.Count(out, " @ ") != 5 {
+		t.Fatalf("expected a 5 point matrix, got: %s", out)
+	}
+	out = captureStdout(t, func() {
+		executeOne(engine, store, ".at 1700000000..1700000120 http_requests_total")
+	})
+	if strings.Count(out, " @ ") != 3 {
+		t.Fatalf("expected the default 1m step, got: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(engine, store, ".at now..now-1h up") })
+	if !strings.Contains(out, "Error: end time must not be before start time") || !strings.Contains(out, "Usage: .at") {
+		t.Fatalf("expected an error and usage, got: %s", out)
+	}
+	if _, err := parseAtRange(".at now-1h..now step=1m"); err == nil {
+		t.Fatal("expected an error for a missing query")
+	}
+}
+
+func TestAdhoc_Range_Usage(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".range now-1h now", store) })
+	if !strings.Contains(out, "Usage: .range <start> <end> <step> <query>") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+}
+
+func TestParseRangeSpec(t *testing.T) {
+	start, end, step, err := ParseRangeSpec("1700000000", "1700003600", "30s")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if start.Unix() != 1700000000 || end.Unix() != 1700003600 || step != 30*time.Second {
+		t.Fatalf("unexpected range: %v %v %v", start, end, step)
+	}
+	if _, _, step, err = ParseRangeSpec("", "", "15"); err != nil || step != 15*time.Second {
+		t.Fatalf("expected numeric step in seconds, got %v (%v)", step, err)
+	}
+	if _, _, _, err := ParseRangeSpec("1700003600", "1700000000", "1m"); err == nil {
+		t.Fatalf("expected error for end before start")
+	}
+	if _, _, _, err := ParseRangeSpec("now-30d", "now", "1s"); err == nil {
+		t.Fatalf("expected error for too many points")
+	}
+	if _, _, _, err := ParseRangeSpec("", "", "0s"); err == nil {
+		t.Fatalf("expected error for zero step")
+	}
+}
@@ -0,0 +1,236 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// recordEntry is one executed command of a .record file, written as a JSON line.
+type recordEntry struct {
+	Time    time.Time `json:"time"` // evaluation time: the pinned time, or when it ran
+	Pinned  bool      `json:"pinned,omitempty"`
+	Command string    `json:"command"`
+	Output  string    `json:"output"`
+}
+
+var (
+	// recordFile is the .record file being written, if any.
+	recordFile *os.File
+	// recordedCommands counts the commands written to recordFile.
+	recordedCommands int
+)
+
+// ansiEscape matches the ANSI color sequences removed from recorded output.
+var ansiEscape = regexp.MustCompile("\033\\[[0-9;]*[A-Za-z]")
+
+// rfc3339Time matches the timestamps masked when checking commands recorded without a
+// pinned evaluation time, as they depend on when the command ran.
+var rfc3339Time = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+
+// executeRecorded runs line as executeOne does, also writing it and its output to the
+// .record file when recording. .record commands themselves are not recorded.
+func executeRecorded(engine *promql.Engine, storage *sstorage.SimpleStorage, line string) {
+	cmd := strings.TrimSpace(line)
+	if recordFile == nil || cmd == "" || cmd == ".record" || strings.HasPrefix(cmd, ".record ") {
+		executeOne(engine, storage, line)
+		return
+	}
+	at, pinned := time.Now(), pinnedEvalTime != nil
+	if pinned {
+		at = *pinnedEvalTime
+	}
+	out := teeOutput(func() { executeOne(engine, storage, line) })
+	// recordFile is closed when a recorded command (e.g. .source) stops the recording
+	if recordFile == nil {
+		return
+	}
+	entry := recordEntry{Time: at.UTC(), Pinned: pinned, Command: cmd, Output: ansiEscape.ReplaceAllString(out, "")}
+	if err := json.NewEncoder(recordFile).Encode(entry); err != nil {
+		fmt.Printf("Recording to %s failed, stopped: %v\n", recordFile.Name(), err)
+		_ = recordFile.Close()
+		recordFile = nil
+		return
+	}
+	recordedCommands++
+}
+
+// teeOutput runs fn, returning what it printed to stdout while still printing it.
+func teeOutput(fn func()) string {
+	orig := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		fn()
+		return ""
+	}
+	os.Stdout = w
+	outCh := make(chan []byte)
+	go func() {
+		var buf bytes.Buffer
+		_, _ = io.Copy(io.MultiWriter(orig, &buf), r)
+		outCh <- buf.Bytes()
+	}()
+	fn()
+	_ = w.Close()
+	os.Stdout = orig
+	b := <-outCh
+	_ = r.Close()
+	return string(b)
+}
+
+// handleAdhocRecord records the executed commands and their output to a file, for
+// promql-cli replay: .record <file> | .record stop | .record
+func handleAdhocRecord(query string, _ *sstorage.SimpleStorage) bool {
+	arg := strings.TrimSpace(strings.TrimPrefix(query, ".record"))
+	switch {
+	case arg == "":
+		if recordFile == nil {
+			fmt.Println("Recording: off")
+		} else {
+			fmt.Printf("Recording to %s: %d commands so far\n", recordFile.Name(), recordedCommands)
+		}
+	case arg == "stop":
+		if recordFile == nil {
+			fmt.Println("Not recording")
+			return true
+		}
+		name := recordFile.Name()
+		if err := recordFile.Close(); err != nil {
+			fmt.Printf("Error: closing %s: %v\n", name, err)
+		}
+		recordFile = nil
+		fmt.Printf("Recorded %d commands to %s (replay with: promql-cli replay [--check] %s)\n", recordedCommands, name, name)
+	case recordFile != nil:
+		fmt.Printf("Error: already recording to %s (stop with .record stop)\n", recordFile.Name())
+	default:
+		f, err := os.Create(arg)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return true
+		}
+		recordFile, recordedCommands = f, 0
+		fmt.Printf("Recording commands and output to %s (stop with .record stop)\n", arg)
+	}
+	return true
+}
+
+// ReplayResult summarizes a Replay.
+type ReplayResult struct {
+	Commands   int
+	Mismatches int
+}
+
+// Replay re-executes the commands of a .record file against storage. With check, the
+// outputs are not shown but compared with the recorded ones, and a diff of each mismatch
+// is written to w. Timestamps are ignored in the outputs of commands recorded without a
+// pinned evaluation time (.pinat), as they depend on when the command ran; the others are
+// evaluated at their recorded time. The pinned evaluation time is restored afterwards.
+func Replay(engine *promql.Engine, storage *sstorage.SimpleStorage, path string, check bool, w io.Writer) (ReplayResult, error) {
+	var res ReplayResult
+	f, err := os.Open(path)
+	if err != nil {
+		return res, err
+	}
+	defer func() { _ = f.Close() }()
+	replEngine = engine
+	prevPinned := pinnedEvalTime
+	defer func() { pinnedEvalTime = prevPinned }()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 1024*1024), 256*1024*1024)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var e recordEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return res, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		res.Commands++
+		pinnedEvalTime = prevPinned
+		if e.Pinned {
+			at := e.Time
+			pinnedEvalTime = &at
+		}
+		if !check {
+			mustFprintf(w, "> %s\n", e.Command)
+			executeOne(engine, storage, e.Command)
+			continue
+		}
+		out, _ := captureOutput(func() { executeOne(engine, storage, e.Command) })
+		recorded, replayed := e.Output, ansiEscape.ReplaceAllString(out, "")
+		if !e.Pinned {
+			recorded = rfc3339Time.ReplaceAllString(recorded, "<time>")
+			replayed = rfc3339Time.ReplaceAllString(replayed, "<time>")
+		}
+		if d := diffOutput(recorded, replayed); d != "" {
+			res.Mismatches++
+			mustFprintf(w, "Mismatch at command %d: %s\n%s\n", res.Commands, e.Command, d)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return res, err
+	}
+	if res.Commands == 0 {
+		return res, errors.New("no commands recorded in " + path)
+	}
+	return res, nil
+}
+
+// diffOutput returns a "- recorded / + replayed" line diff of two outputs, ignoring trailing
+// whitespace, or "" when they match.
+func diffOutput(recorded, replayed string) string {
+	split := func(s string) []string {
+		lines := strings.Split(strings.TrimRight(s, " \t\n"), "\n")
+		for i := range lines {
+			lines[i] = strings.TrimRight(lines[i], " \t\r")
+		}
+		return lines
+	}
+	a, b := split(recorded), split(replayed)
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var d strings.Builder
+	changed := false
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			d.WriteString("  " + a[i] + "\n")
+			i, j = i+1, j+1
+		case j >= len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			d.WriteString("- " + a[i] + "\n")
+			i, changed = i+1, true
+		default:
+			d.WriteString("+ " + b[j] + "\n")
+			j, changed = j+1, true
+		}
+	}
+	if !changed {
+		return ""
+	}
+	return strings.TrimSuffix(d.String(), "\n")
+}
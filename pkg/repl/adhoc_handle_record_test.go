@@ -0,0 +1,110 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	engine := newTestEngine()
+	prevEngine, prevPinned := replEngine, pinnedEvalTime
+	replEngine = engine
+	defer func() { replEngine, pinnedEvalTime = prevEngine, prevPinned }()
+	defer func() {
+		if recordFile != nil {
+			_ = recordFile.Close()
+			recordFile = nil
+		}
+	}()
+
+	store := sstorage.NewSimpleStorage()
+	if err := store.LoadFromReader(strings.NewReader("up{job=\"a\"} 1 1700000000000\nup{job=\"b\"} 0 1700000000000\n")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "rec.jsonl")
+	run := func(line string) string { return captureStdout(t, func() { executeRecorded(engine, store, line) }) }
+
+	if out := run(".record"); !strings.Contains(out, "Recording: off") {
+		t.Fatalf("unexpected status: %s", out)
+	}
+	if out := run(".record " + path); !strings.Contains(out, "Recording commands and output to "+path) {
+		t.Fatalf("unexpected start output: %s", out)
+	}
+	run(".pinat 2023-11-14T22:15:00Z")
+	if out := run("sum(up)"); !strings.Contains(out, "=> 1 @") {
+		t.Fatalf("recorded commands should still print their output: %s", out)
+	}
+	run("up == 0")
+	if out := run(".record"); !strings.Contains(out, "3 commands so far") {
+		t.Fatalf("unexpected status: %s", out)
+	}
+	if out := run(".record stop"); !strings.Contains(out, "Recorded 3 commands to "+path) {
+		t.Fatalf("unexpected stop output: %s", out)
+	}
+	run("count(up)")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 3 || strings.Contains(string(data), "count(up)") || strings.Contains(string(data), ".record") {
+		t.Fatalf("expected the 3 commands run while recording, got:\n%s", data)
+	}
+
+	pinnedEvalTime = nil
+	var w bytes.Buffer
+	res, err := Replay(engine, store, path, true, &w)
+	if err != nil || res.Commands != 3 || res.Mismatches != 0 {
+		t.Fatalf("expected a matching replay, got %+v %v:\n%s", res, err, w.String())
+	}
+	if pinnedEvalTime != nil {
+		t.Fatalf("replay should restore the pinned evaluation time, got %v", pinnedEvalTime)
+	}
+
+	// Pinned commands are evaluated at their recorded time, even without the .pinat
+	withoutPin := filepath.Join(t.TempDir(), "nopin.jsonl")
+	if err := os.WriteFile(withoutPin, data[bytes.IndexByte(data, '\n')+1:], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.Reset()
+	res, err = Replay(engine, store, withoutPin, true, &w)
+	if err != nil || res.Commands != 2 || res.Mismatches != 0 {
+		t.Fatalf("expected a matching replay at the recorded time, got %+v %v:\n%s", res, err, w.String())
+	}
+
+	pinnedEvalTime = nil
+	changed := sstorage.NewSimpleStorage()
+	if err := changed.LoadFromReader(strings.NewReader("up{job=\"a\"} 1 1700000000000\nup{job=\"b\"} 1 1700000000000\n")); err != nil {
+		t.Fatal(err)
+	}
+	w.Reset()
+	res, err = Replay(engine, changed, path, true, &w)
+	if err != nil || res.Mismatches != 2 {
+		t.Fatalf("expected 2 mismatches, got %+v %v:\n%s", res, err, w.String())
+	}
+	if out := w.String(); !strings.Contains(out, "Mismatch at command 2: sum(up)") || !strings.Contains(out, "+   [1] {} => 2 @") {
+		t.Fatalf("unexpected mismatch report:\n%s", out)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.jsonl")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Replay(engine, store, empty, true, &w); err == nil || !strings.Contains(err.Error(), "no commands recorded") {
+		t.Fatalf("expected an error replaying an empty recording, got %v", err)
+	}
+}
+
+func TestDiffOutput(t *testing.T) {
+	if d := diffOutput("a\nb\n", "a  \nb"); d != "" {
+		t.Fatalf("trailing whitespace should be ignored, got %q", d)
+	}
+	want := "  a\n- b\n+ x\n  c\n+ d"
+	if d := diffOutput("a\nb\nc\n", "a\nx\nc\nd\n"); d != want {
+		t.Fatalf("diffOutput = %q, want %q", d, want)
+	}
+}
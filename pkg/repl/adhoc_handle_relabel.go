@@ -0,0 +1,187 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"go.yaml.in/yaml/v2"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// relabelExampleLimit caps the before/after examples printed by .relabel.
+const relabelExampleLimit = 5
+
+// ParseRelabelConfigs parses Prometheus relabel_configs from YAML. It accepts a list of
+// rules, a single rule, or a mapping with relabel_configs and/or metric_relabel_configs
+// (as copied from a scrape config, applied in that order).
+func ParseRelabelConfigs(data []byte) ([]*relabel.Config, error) {
+	var cfgs []*relabel.Config
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "" {
+		return nil, errors.New("no relabel rules given")
+	}
+	var keys map[string]any
+	switch {
+	case strings.HasPrefix(trimmed, "-") || strings.HasPrefix(trimmed, "["):
+		if err := yaml.UnmarshalStrict(data, &cfgs); err != nil {
+			return nil, err
+		}
+	case yaml.Unmarshal(data, &keys) == nil && (keys["relabel_configs"] != nil || keys["metric_relabel_configs"] != nil):
+		var scrape struct {
+			RelabelConfigs       []*relabel.Config `yaml:"relabel_configs"`
+			MetricRelabelConfigs []*relabel.Config `yaml:"metric_relabel_configs"`
+		}
+		if err := yaml.Unmarshal(data, &scrape); err != nil {
+			return nil, err
+		}
+		cfgs = append(scrape.RelabelConfigs, scrape.MetricRelabelConfigs...)
+	default:
+		cfg := &relabel.Config{}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, err
+		}
+		cfgs = []*relabel.Config{cfg}
+	}
+	if len(cfgs) == 0 {
+		return nil, errors.New("no relabel rules given")
+	}
+	for i, cfg := range cfgs {
+		if cfg == nil {
+			return nil, fmt.Errorf("rule %d is empty", i+1)
+		}
+		if err := cfg.Validate(model.UTF8Validation); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return cfgs, nil
+}
+
+// RelabelResult summarizes ApplyRelabel, counting series (not samples).
+type RelabelResult struct {
+	Kept, Changed, Dropped int
+	// Examples holds up to relabelExampleLimit "before → after" lines for changed or dropped series.
+	Examples []string
+}
+
+// ApplyRelabel rewrites the label sets of all stored series with cfgs, the way Prometheus
+// applies metric_relabel_configs at scrape time. Dropped series and series left without a
+// metric name are removed; series that end up with the same labels are merged. HELP/TYPE
+// metadata follows renamed metrics.
+func ApplyRelabel(storage *sstorage.SimpleStorage, cfgs []*relabel.Config) RelabelResult {
+	var res RelabelResult
+	names := make([]string, 0, len(storage.Metrics))
+	for name := range storage.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make(map[string][]sstorage.MetricSample, len(storage.Metrics))
+	renamed := map[string]bool{}
+	lb := labels.NewBuilder(labels.EmptyLabels())
+	for _, name := range names {
+		// Relabel each series once; samples of a series share the resulting label map.
+		bySig := map[string]map[string]string{}
+		for _, s := range storage.Metrics[name] {
+			sig := seriesSignature(name, s.Labels)
+			newLabels, seen := bySig[sig]
+			if !seen {
+				lb.Reset(labels.FromMap(s.Labels))
+				lb.Set(labels.MetricName, name)
+				keep := relabel.ProcessBuilder(lb, cfgs...)
+				newName := lb.Get(labels.MetricName)
+				switch {
+				case !keep || newName == "":
+					res.Dropped++
+					res.addExample(sig + " → dropped")
+				default:
+					newLabels = lb.Labels().Map()
+					if newSig := seriesSignature(newName, newLabels); newSig != sig {
+						res.Changed++
+						res.addExample(sig + " → " + newSig)
+					}
+					res.Kept++
+				}
+				bySig[sig] = newLabels
+			}
+			if newLabels == nil {
+				continue
+			}
+			newName := newLabels[labels.MetricName]
+			s.Labels = newLabels
+			out[newName] = append(out[newName], s)
+			if newName != name && !renamed[name] {
+				renamed[name] = true
+				if help, ok := storage.MetricsHelp[name]; ok && storage.MetricsHelp[newName] == "" {
+					storage.MetricsHelp[newName] = help
+				}
+				if typ, ok := storage.MetricsType[name]; ok && storage.MetricsType[newName] == "" {
+					storage.MetricsType[newName] = typ
+				}
+			}
+		}
+	}
+	for _, samples := range out {
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+	}
+	// Like .rename, metadata moves with the metric once no series keeps the old name.
+	for name := range renamed {
+		if _, ok := out[name]; !ok {
+			delete(storage.MetricsHelp, name)
+			delete(storage.MetricsType, name)
+		}
+	}
+	storage.Metrics = out
+	return res
+}
+
+func (r *RelabelResult) addExample(line string) {
+	if len(r.Examples) < relabelExampleLimit {
+		r.Examples = append(r.Examples, line)
+	}
+}
+
+// handleAdhocRelabel applies relabel_configs to the store: .relabel <rules.yml|inline YAML>
+func handleAdhocRelabel(query string, storage *sstorage.SimpleStorage) bool {
+	arg := strings.TrimSpace(strings.TrimPrefix(query, ".relabel"))
+	if arg == "" {
+		cmd := GetAdHocCommandByName(".relabel")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	data := []byte(strings.Trim(arg, "'"))
+	if path := strings.Trim(arg, "\"'"); !strings.ContainsAny(path, "{[:\n") {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("Failed to read relabel rules: %v\n", err)
+			return true
+		}
+		data = b
+	}
+	cfgs, err := ParseRelabelConfigs(data)
+	if err != nil {
+		fmt.Printf("Invalid relabel rules: %v\n", err)
+		return true
+	}
+
+	res := ApplyRelabel(storage, cfgs)
+	for _, ex := range res.Examples {
+		fmt.Println("  " + ex)
+	}
+	totalMetrics, totalSamples := storeTotals(storage)
+	fmt.Printf("Relabeled with %d rules: %d series kept (%d changed), %d dropped (now: %d metrics, %d samples)\n",
+		len(cfgs), res.Kept, res.Changed, res.Dropped, totalMetrics, totalSamples)
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func newRelabelStore(t *testing.T) *sstorage.SimpleStorage {
+	t.Helper()
+	store := sstorage.NewSimpleStorage()
+	input := `# HELP http_requests_total Total requests.
+# TYPE http_requests_total counter
+http_requests_total{instance="web-1:8080",pod_template_hash="abc",code="200"} 1 1700000000000
+http_requests_total{instance="web-1:8080",pod_template_hash="abc",code="200"} 2 1700000060000
+http_requests_total{instance="web-2:8080",pod_template_hash="def",code="200"} 5 1700000000000
+go_goroutines{instance="web-1:8080"} 10 1700000000000
+`
+	if err := store.LoadFromReader(strings.NewReader(input)); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	return store
+}
+
+func TestParseRelabelConfigs_Forms(t *testing.T) {
+	for name, in := range map[string]string{
+		"list":   "[{action: labeldrop, regex: pod_template_hash}, {source_labels: [__name__], regex: 'go_.*', action: drop}]",
+		"block":  "- action: labeldrop\n  regex: pod_template_hash\n- source_labels: [__name__]\n  regex: go_.*\n  action: drop\n",
+		"scrape": "relabel_configs:\n- action: labeldrop\n  regex: pod_template_hash\nmetric_relabel_configs:\n- source_labels: [__name__]\n  regex: go_.*\n  action: drop\n",
+	} {
+		cfgs, err := ParseRelabelConfigs([]byte(in))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(cfgs) != 2 || cfgs[0].Action != "labeldrop" || cfgs[1].Action != "drop" {
+			t.Fatalf("%s: unexpected configs %+v", name, cfgs)
+		}
+	}
+
+	cfgs, err := ParseRelabelConfigs([]byte("{source_labels: [instance], target_label: host}"))
+	if err != nil || len(cfgs) != 1 || cfgs[0].Action != "replace" || cfgs[0].Replacement != "$1" {
+		t.Fatalf("expected a single replace rule with defaults, got %+v (%v)", cfgs, err)
+	}
+
+	for _, bad := range []string{"", "[{action: hashmod, target_label: shard}]", "[{action: replace}]", "[{actoin: drop}]"} {
+		if _, err := ParseRelabelConfigs([]byte(bad)); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestAdhoc_Relabel_ReplaceDropAndMerge(t *testing.T) {
+	store := newRelabelStore(t)
+	out := captureStdout(t, func() {
+		_ = handleAdHocFunction(`.relabel [{source_labels: [instance], regex: '([^:]+):.*', target_label: instance}, {action: labeldrop, regex: pod_template_hash}, {source_labels: [__name__], regex: 'go_.*', action: drop}]`, store)
+	})
+	for _, want := range []string{
+		`http_requests_total{code="200",instance="web-1:8080",pod_template_hash="abc"} → http_requests_total{code="200",instance="web-1"}`,
+		`go_goroutines{instance="web-1:8080"} → dropped`,
+		"Relabeled with 3 rules: 2 series kept (2 changed), 1 dropped (now: 1 metrics, 3 samples)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output, got: %s", want, out)
+		}
+	}
+	samples := store.Metrics["http_requests_total"]
+	if len(samples) != 3 {
+		t.Fatalf("unexpected samples after relabel: %+v", samples)
+	}
+	for _, s := range samples {
+		if inst := s.Labels["instance"]; inst != "web-1" && inst != "web-2" {
+			t.Fatalf("instance should have the port stripped: %v", s.Labels)
+		}
+		if _, ok := s.Labels["pod_template_hash"]; ok {
+			t.Fatalf("pod_template_hash should be dropped: %v", s.Labels)
+		}
+	}
+}
+
+func TestAdhoc_Relabel_RenameFromFileKeepsMetadata(t *testing.T) {
+	store := newRelabelStore(t)
+	path := filepath.Join(t.TempDir(), "relabel.yml")
+	rules := `metric_relabel_configs:
+- source_labels: [__name__]
+  regex: http_(.*)
+  target_label: __name__
+  replacement: web_$1
+- source_labels: [instance]
+  modulus: 4
+  target_label: shard
+  action: hashmod
+`
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".relabel "+path, store) })
+	if !strings.Contains(out, "Relabeled with 2 rules: 3 series kept (3 changed), 0 dropped") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	if _, ok := store.Metrics["http_requests_total"]; ok || store.MetricsHelp["http_requests_total"] != "" {
+		t.Fatalf("old metric name and its metadata should be gone")
+	}
+	renamed := store.Metrics["web_requests_total"]
+	if len(renamed) != 3 || renamed[0].Labels["shard"] == "" {
+		t.Fatalf("expected renamed series with a shard label, got %+v", renamed)
+	}
+	if store.MetricsHelp["web_requests_total"] != "Total requests." || store.MetricsType["web_requests_total"] != "counter" {
+		t.Fatalf("metadata should follow the rename: help=%q type=%q", store.MetricsHelp["web_requests_total"], store.MetricsType["web_requests_total"])
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".relabel missing.yml", store) })
+	if !strings.Contains(out, "Failed to read relabel rules") {
+		t.Fatalf("expected read error, got: %s", out)
+	}
+}
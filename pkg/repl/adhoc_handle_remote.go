@@ -0,0 +1,139 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	promstorage "github.com/prometheus/prometheus/storage"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// remoteQueryable, when set via .connect or --remote, is merged with the local
+// SimpleStorage so queries see both loaded metrics and live remote data.
+var remoteQueryable *sstorage.RemoteQueryable
+
+// remoteProxy, set via --remote-url or ".connect <url> proxy", makes the remote Prometheus
+// the only query source: local storage is bypassed and completions come from its v1 API.
+var remoteProxy bool
+
+// client is the v1 API of the connected remote Prometheus, used for completions.
+var client v1.API
+
+// ConnectRemote wires a live Prometheus server (HTTP API) as an additional query source.
+// An empty url disconnects.
+func ConnectRemote(url string) error {
+	if strings.TrimSpace(url) == "" {
+		remoteQueryable, remoteProxy, client = nil, false, nil
+		return nil
+	}
+	rq, err := sstorage.NewRemoteQueryable(url)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, _, err := rq.API.Query(ctx, "vector(1)", time.Now()); err != nil {
+		return fmt.Errorf("cannot reach %s: %w", rq.URL, err)
+	}
+	remoteQueryable, remoteProxy, client = rq, false, rq.API
+	return nil
+}
+
+// ConnectRemoteProxy connects to a live Prometheus server in proxy mode, where every
+// query is evaluated against the remote data only. An empty url disconnects.
+func ConnectRemoteProxy(url string) error {
+	if err := ConnectRemote(url); err != nil {
+		return err
+	}
+	remoteProxy = remoteQueryable != nil
+	return nil
+}
+
+// QueryableFor returns the queryable used for PromQL evaluation: the local storage,
+// merged with the on-disk TSDB (--storage tsdb) and the connected remote Prometheus,
+// when configured. Remote failures are reported as warnings so local data remains queryable.
+// In proxy mode only the remote Prometheus is queried. Selectors matching on __store__
+// read from the named stores of .store instead.
+func QueryableFor(storage *sstorage.SimpleStorage) promstorage.Queryable {
+	return workspaceQueryable{base: activeQueryableFor(storage), active: storage}
+}
+
+// activeQueryableFor is QueryableFor without the other stores.
+func activeQueryableFor(storage *sstorage.SimpleStorage) promstorage.Queryable {
+	rq, ps := remoteQueryable, persistentStore
+	if rq != nil && remoteProxy {
+		return rq
+	}
+	if rq == nil && ps == nil {
+		return storage
+	}
+	return promstorage.QueryableFunc(func(mint, maxt int64) (promstorage.Querier, error) {
+		local, err := storage.Querier(mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+		primaries := []promstorage.Querier{local}
+		if ps != nil {
+			disk, err := ps.Querier(mint, maxt)
+			if err != nil {
+				return nil, err
+			}
+			primaries = append(primaries, disk)
+		}
+		var secondaries []promstorage.Querier
+		if rq != nil {
+			remote, err := rq.Querier(mint, maxt)
+			if err != nil {
+				return nil, err
+			}
+			secondaries = append(secondaries, remote)
+		}
+		return promstorage.NewMergeQuerier(primaries, secondaries, promstorage.ChainedSeriesMerge), nil
+	})
+}
+
+// remoteModeDescription describes how the connected remote is combined with local storage.
+func remoteModeDescription() string {
+	if remoteProxy {
+		return "proxy mode, local storage bypassed"
+	}
+	return "merged with local storage"
+}
+
+// handleAdhocConnect handles .connect [<prometheus-url> [proxy]|off]
+func handleAdhocConnect(query string, storage *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimSpace(strings.TrimPrefix(query, ".connect")))
+	if len(fields) == 0 {
+		if remoteQueryable == nil {
+			fmt.Println("Remote Prometheus: none (queries use local storage only)")
+		} else {
+			fmt.Printf("Remote Prometheus: %s (%s)\n", remoteQueryable.URL, remoteModeDescription())
+		}
+		return true
+	}
+	arg := strings.Trim(fields[0], "\"'")
+	proxy := len(fields) > 1 && strings.EqualFold(fields[1], "proxy")
+	if strings.EqualFold(arg, "off") || strings.EqualFold(arg, "remove") {
+		_ = ConnectRemote("")
+		fmt.Println("Remote Prometheus: disconnected")
+		return true
+	}
+	connect := ConnectRemote
+	if proxy {
+		connect = ConnectRemoteProxy
+	}
+	if err := connect(arg); err != nil {
+		fmt.Printf("Error connecting to remote Prometheus: %v\n", err)
+		return true
+	}
+	// Refresh completion candidates from the new query source
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+	fmt.Printf("Connected to remote Prometheus: %s (%s)\n", remoteQueryable.URL, remoteModeDescription())
+	return true
+}
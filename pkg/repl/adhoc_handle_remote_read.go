@@ -0,0 +1,209 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// RemoteAuthOptions holds the optional auth settings for RemoteRead and RemoteWrite (see applyPromAuth).
+type RemoteAuthOptions struct {
+	AuthMode, User, Pass, OrgID, APIKey string
+}
+
+// RemoteRead pulls the raw samples of the series matching selector in [start, end] from a
+// Prometheus remote_read endpoint (e.g. http://prom:9090/api/v1/read) into storage.
+// It returns the number of series and samples added.
+func RemoteRead(ctx context.Context, url, selector string, start, end time.Time, opts RemoteAuthOptions, storage *sstorage.SimpleStorage) (int, int, error) {
+	matchers, err := promParser.ParseMetricSelector(selector)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid selector %q: %w", selector, err)
+	}
+	query := &prompb.Query{StartTimestampMs: start.UnixMilli(), EndTimestampMs: end.UnixMilli()}
+	for _, m := range matchers {
+		var typ prompb.LabelMatcher_Type
+		switch m.Type {
+		case labels.MatchEqual:
+			typ = prompb.LabelMatcher_EQ
+		case labels.MatchNotEqual:
+			typ = prompb.LabelMatcher_NEQ
+		case labels.MatchRegexp:
+			typ = prompb.LabelMatcher_RE
+		case labels.MatchNotRegexp:
+			typ = prompb.LabelMatcher_NRE
+		}
+		query.Matchers = append(query.Matchers, &prompb.LabelMatcher{Type: typ, Name: m.Name, Value: m.Value})
+	}
+	reqPB := &prompb.ReadRequest{
+		Queries:               []*prompb.Query{query},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_SAMPLES},
+	}
+	data, err := reqPB.Marshal()
+	if err != nil {
+		return 0, 0, fmt.Errorf("encoding read request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Accept-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Read-Version", "0.1.0")
+	applyPromAuth(req, opts.AuthMode, opts.User, opts.Pass, opts.OrgID, opts.APIKey)
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, 0, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		return 0, 0, fmt.Errorf("decoding snappy response: %w", err)
+	}
+	var respPB prompb.ReadResponse
+	if err := respPB.Unmarshal(raw); err != nil {
+		return 0, 0, fmt.Errorf("decoding read response: %w", err)
+	}
+
+	series, samples := 0, 0
+	for _, res := range respPB.Results {
+		for _, ts := range res.Timeseries {
+			lbls := make(map[string]string, len(ts.Labels))
+			for _, l := range ts.Labels {
+				lbls[l.Name] = l.Value
+			}
+			for _, s := range ts.Samples {
+				storage.AddSample(lbls, s.Value, s.Timestamp)
+			}
+			for _, h := range ts.Histograms {
+				storage.AddHistogramSample(lbls, h.ToFloatHistogram(), h.Timestamp)
+			}
+			series++
+			samples += len(ts.Samples) + len(ts.Histograms)
+		}
+	}
+	return series, samples, nil
+}
+
+// parseRemoteAuthOption applies an auth=/user=/pass=/org_id=/api_key= token to opts.
+// It reports false for other tokens, such as label matchers or regexes containing "=".
+func parseRemoteAuthOption(tok string, opts *RemoteAuthOptions) bool {
+	k, v, ok := strings.Cut(tok, "=")
+	if !ok || strings.ContainsAny(tok, `"}`) {
+		return false
+	}
+	switch strings.ToLower(k) {
+	case "auth", "auth_mode":
+		opts.AuthMode = strings.ToLower(v)
+	case "user", "username":
+		opts.User = v
+	case "pass", "password":
+		opts.Pass = v
+	case "org_id", "orgid", "tenant", "tenant_id":
+		opts.OrgID = v
+	case "api_key", "apikey":
+		opts.APIKey = v
+	default:
+		return false
+	}
+	return true
+}
+
+// parseRemoteReadArgs parses <url> <selector> [start] [end] [auth=...] [user=...] [pass=...] [org_id=...] [api_key=...].
+// The selector may contain spaces; start/end default to now-1h and now.
+func parseRemoteReadArgs(rest string) (url, selector string, start, end time.Time, opts RemoteAuthOptions, err error) {
+	fields := strings.Fields(rest)
+	if len(fields) < 2 {
+		return url, selector, start, end, opts, fmt.Errorf("missing url or selector")
+	}
+	url, fields = fields[0], fields[1:]
+
+	// Trailing auth key=value tokens (values cannot contain quotes or braces, unlike matchers).
+	for len(fields) > 1 && parseRemoteAuthOption(fields[len(fields)-1], &opts) {
+		fields = fields[:len(fields)-1]
+	}
+
+	// Up to two trailing tokens are start/end times, as long as what precedes them is a valid selector.
+	for n := min(2, len(fields)-1); n >= 0; n-- {
+		sel := strings.Trim(strings.Join(fields[:len(fields)-n], " "), "'")
+		if _, perr := promParser.ParseMetricSelector(sel); perr != nil {
+			continue
+		}
+		var startStr, endStr string
+		if n >= 1 {
+			startStr = fields[len(fields)-n]
+		}
+		if n == 2 {
+			endStr = fields[len(fields)-1]
+		}
+		if start, end, _, err = ParseRangeSpec(startStr, endStr, ""); err != nil {
+			continue
+		}
+		return url, sel, start, end, opts, nil
+	}
+	return url, selector, start, end, opts, fmt.Errorf("invalid selector or time range in %q", strings.Join(fields, " "))
+}
+
+// RemoteReadSpec runs RemoteRead with arguments in .remote_read syntax: <url> <selector> [start] [end] [auth=...].
+func RemoteReadSpec(ctx context.Context, spec string, storage *sstorage.SimpleStorage) (int, int, error) {
+	url, selector, start, end, opts, err := parseRemoteReadArgs(spec)
+	if err != nil {
+		return 0, 0, err
+	}
+	return RemoteRead(ctx, url, selector, start, end, opts, storage)
+}
+
+// handleAdhocRemoteRead loads raw series from a remote_read endpoint:
+// .remote_read <url> <selector> [start] [end] [auth=...]
+func handleAdhocRemoteRead(query string, storage *sstorage.SimpleStorage) bool {
+	rest := strings.TrimSpace(strings.TrimPrefix(query, ".remote_read"))
+	usage := GetAdHocCommandByName(".remote_read").Usage
+	if rest == "" {
+		fmt.Println("Usage: " + usage)
+		return true
+	}
+	url, selector, start, end, opts, err := parseRemoteReadArgs(rest)
+	if err != nil {
+		fmt.Printf(".remote_read: %v\n", err)
+		fmt.Println("Usage: " + usage)
+		return true
+	}
+	series, samples, err := RemoteRead(context.Background(), url, selector, start, end, opts, storage)
+	if err != nil {
+		fmt.Printf("Failed to remote_read from %s: %v\n", url, err)
+		return true
+	}
+	totalMetrics, totalSamples := storeTotals(storage)
+	fmt.Printf("Remote read %s %s [%s, %s]: +%d series, +%d samples (total: %d metrics, %d samples)\n",
+		url, selector, start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339), series, samples, totalMetrics, totalSamples)
+
+	if added, alerts, err := EvaluateActiveRules(storage); err != nil {
+		fmt.Printf("Rules evaluation failed: %v\n", err)
+	} else if added > 0 || alerts > 0 {
+		fmt.Printf("Rules: added %d samples; %d alerts\n", added, alerts)
+	}
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+	return true
+}
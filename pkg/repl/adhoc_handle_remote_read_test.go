@@ -0,0 +1,65 @@
+package repl
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_RemoteRead_LoadsSeries(t *testing.T) {
+	var got prompb.ReadRequest
+	var orgID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		raw, err := snappy.Decode(nil, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := got.Unmarshal(raw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		orgID = r.Header.Get("X-Scope-OrgID")
+		resp := prompb.ReadResponse{Results: []*prompb.QueryResult{{Timeseries: []*prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1700000000000}, {Value: 0, Timestamp: 1700000060000}},
+		}}}}}
+		data, _ := resp.Marshal()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Header().Set("Content-Encoding", "snappy")
+		_, _ = w.Write(snappy.Encode(nil, data))
+	}))
+	defer srv.Close()
+
+	store := sstorage.NewSimpleStorage()
+	cmd := `.remote_read ` + srv.URL + `/api/v1/read {__name__="up", job=~"no.*"} 2023-11-14T22:00:00Z 2023-11-14T23:00:00Z org_id=acme`
+	out := captureStdout(t, func() { _ = handleAdHocFunction(cmd, store) })
+	if !strings.Contains(out, "+1 series, +2 samples") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	if len(store.Metrics["up"]) != 2 || store.Metrics["up"][1].Labels["job"] != "node" {
+		t.Fatalf("unexpected stored samples: %+v", store.Metrics["up"])
+	}
+	if len(got.Queries) != 1 || got.Queries[0].StartTimestampMs != 1699999200000 || got.Queries[0].EndTimestampMs != 1700002800000 {
+		t.Fatalf("unexpected query range: %+v", got.Queries)
+	}
+	if ms := got.Queries[0].Matchers; len(ms) != 2 || ms[1].Type != prompb.LabelMatcher_RE || ms[1].Value != "no.*" {
+		t.Fatalf("unexpected matchers: %+v", ms)
+	}
+	if orgID != "acme" {
+		t.Fatalf("expected X-Scope-OrgID acme, got %q", orgID)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".remote_read "+srv.URL+" up{", store) })
+	if !strings.Contains(out, "invalid selector") {
+		t.Fatalf("expected selector error, got %q", out)
+	}
+}
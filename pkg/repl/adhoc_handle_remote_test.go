@@ -0,0 +1,147 @@
+package repl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// newFakePrometheus serves /api/v1/query, returning one raw sample for remote_up
+// one second before the requested evaluation time, plus the label and metadata APIs.
+func newFakePrometheus(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/query":
+		case "/api/v1/labels":
+			_, _ = w.Write([]byte(`{"status":"success","data":["__name__","job"]}`))
+			return
+		case "/api/v1/label/__name__/values":
+			_, _ = w.Write([]byte(`{"status":"success","data":["remote_up"]}`))
+			return
+		case "/api/v1/label/job/values":
+			_, _ = w.Write([]byte(`{"status":"success","data":["remote"]}`))
+			return
+		case "/api/v1/metadata":
+			_, _ = w.Write([]byte(`{"status":"success","data":{"remote_up":[{"type":"gauge","help":"Remote target is up.","unit":""}]}}`))
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		_ = r.ParseForm()
+		q := r.Form.Get("query")
+		ts, _ := strconv.ParseFloat(r.Form.Get("time"), 64)
+		if q == "vector(1)" {
+			_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[%f,"1"]}]}}`, ts)
+			return
+		}
+		if !strings.Contains(q, `__name__="remote_up"`) {
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"remote_up","job":"remote"},"values":[[%f,"7"]]}]}}`, ts-1)
+	}))
+}
+
+func TestAdhoc_Connect_MergesRemoteAndLocal(t *testing.T) {
+	srv := newFakePrometheus(t)
+	defer srv.Close()
+	defer func() { remoteQueryable = nil }()
+
+	store := sstorage.NewSimpleStorage()
+	store.AddSample(map[string]string{"__name__": "local_up", "job": "local"}, 3, time.Now().Add(-time.Second).UnixMilli())
+	engine := newTestEngine()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".connect "+srv.URL, store) })
+	if !strings.Contains(out, "Connected to remote Prometheus: "+srv.URL) {
+		t.Fatalf("expected connect confirmation, got: %s", out)
+	}
+
+	out = captureStdout(t, func() { executeOne(engine, store, "remote_up + on() group_left local_up") })
+	if !strings.Contains(out, "=> 10 @") {
+		t.Fatalf("expected merged local+remote result 10, got: %s", out)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".connect off", store) })
+	if !strings.Contains(out, "disconnected") || remoteQueryable != nil {
+		t.Fatalf("expected disconnect, got: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(engine, store, "remote_up") })
+	if !strings.Contains(out, "No results found") {
+		t.Fatalf("expected no remote data after disconnect, got: %s", out)
+	}
+}
+
+func TestAdhoc_Connect_ProxyMode(t *testing.T) {
+	srv := newFakePrometheus(t)
+	defer srv.Close()
+	defer func() { _ = ConnectRemote("") }()
+
+	store := sstorage.NewSimpleStorage()
+	store.AddSample(map[string]string{"__name__": "local_up", "job": "local"}, 3, time.Now().Add(-time.Second).UnixMilli())
+	engine := newTestEngine()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".connect "+srv.URL+" proxy", store) })
+	if !strings.Contains(out, "proxy mode") || !remoteProxy {
+		t.Fatalf("expected proxy mode confirmation, got: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(engine, store, "remote_up") })
+	if !strings.Contains(out, "=> 7 @") {
+		t.Fatalf("expected remote result, got: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(engine, store, "local_up") })
+	if !strings.Contains(out, "No results found") {
+		t.Fatalf("expected local storage to be bypassed, got: %s", out)
+	}
+
+	// Completions come from the remote v1 API, refreshed by .connect in the go-prompt REPL
+	prevRefresh := refreshMetricsCache
+	defer func() { refreshMetricsCache = prevRefresh }()
+	refreshMetricsCache = func(*sstorage.SimpleStorage) { fetchMetrics() }
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".connect "+srv.URL+" proxy", store) })
+	if len(metrics) != 1 || metrics[0] != "remote_up" || metricsHelp["remote_up"] != "Remote target is up." {
+		t.Fatalf("expected remote metric completions, got %v %v", metrics, metricsHelp)
+	}
+	if got := getLabelNameSuggests("", "remote_up"); len(got) != 1 || got[0].Text != "job" {
+		t.Fatalf("unexpected label name suggestions: %v", got)
+	}
+	if got := getLabelValueSuggests("", "remote_up", "job"); len(got) != 1 || got[0].Text != `"remote"` {
+		t.Fatalf("unexpected label value suggestions: %v", got)
+	}
+
+	// and the readline completer, which leaves out the bypassed local storage
+	ac := NewPrometheusAutoCompleter(store)
+	if got := ac.getMetricNameCompletions(""); len(got) != 1 || got[0] != "remote_up" {
+		t.Fatalf("unexpected readline metric completions: %v", got)
+	}
+	if got := ac.getLabelNameCompletions("remote_up", ""); len(got) != 1 || got[0] != "job" {
+		t.Fatalf("unexpected readline label name completions: %v", got)
+	}
+	if got := ac.getLabelValueCompletions("", "job", ""); len(got) != 1 || got[0] != "remote" {
+		t.Fatalf("unexpected readline label value completions: %v", got)
+	}
+
+	_ = ConnectRemote("")
+	if remoteProxy || client != nil {
+		t.Fatalf("expected proxy mode and API client to be reset on disconnect")
+	}
+}
+
+func TestAdhoc_Connect_Unreachable(t *testing.T) {
+	defer func() { remoteQueryable = nil }()
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".connect "+srv.URL, sstorage.NewSimpleStorage()) })
+	if !strings.Contains(out, "Error connecting to remote Prometheus") || remoteQueryable != nil {
+		t.Fatalf("expected connection error, got: %s", out)
+	}
+}
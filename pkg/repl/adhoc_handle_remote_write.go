@@ -0,0 +1,148 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// remoteWriteBatchSize is the maximum number of series sent in one remote-write request.
+const remoteWriteBatchSize = 1000
+
+// buildWriteRequests groups the stored samples of the metrics matching re (nil matches all)
+// into remote-write series, with sorted labels and time-ordered samples, batched by series.
+func buildWriteRequests(storage *sstorage.SimpleStorage, re *regexp.Regexp) []*prompb.WriteRequest {
+	names := make([]string, 0, len(storage.Metrics))
+	for name := range storage.Metrics {
+		if re == nil || re.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var series []prompb.TimeSeries
+	for _, name := range names {
+		byKey := map[string]int{}
+		first := len(series)
+		for _, s := range storage.Metrics[name] {
+			lset := labels.FromMap(s.Labels)
+			key := lset.String()
+			i, ok := byKey[key]
+			if !ok {
+				i = len(series)
+				byKey[key] = i
+				series = append(series, prompb.TimeSeries{Labels: prompb.FromLabels(lset, nil)})
+			}
+			if s.Histogram != nil {
+				series[i].Histograms = append(series[i].Histograms, prompb.FromFloatHistogram(s.Timestamp, s.Histogram))
+				continue
+			}
+			series[i].Samples = append(series[i].Samples, prompb.Sample{Value: s.Value, Timestamp: s.Timestamp})
+		}
+		for i := first; i < len(series); i++ {
+			sort.SliceStable(series[i].Samples, func(a, b int) bool { return series[i].Samples[a].Timestamp < series[i].Samples[b].Timestamp })
+			sort.SliceStable(series[i].Histograms, func(a, b int) bool { return series[i].Histograms[a].Timestamp < series[i].Histograms[b].Timestamp })
+		}
+	}
+
+	var reqs []*prompb.WriteRequest
+	for start := 0; start < len(series); start += remoteWriteBatchSize {
+		end := min(start+remoteWriteBatchSize, len(series))
+		reqs = append(reqs, &prompb.WriteRequest{Timeseries: series[start:end]})
+	}
+	return reqs
+}
+
+// RemoteWrite pushes the stored series of the metrics matching re (nil pushes all) to a
+// Prometheus remote-write receiver (Prometheus, Mimir, VictoriaMetrics, Thanos Receive).
+// It returns the number of series and samples sent.
+func RemoteWrite(ctx context.Context, url string, re *regexp.Regexp, opts RemoteAuthOptions, storage *sstorage.SimpleStorage) (int, int, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	series, samples := 0, 0
+	for _, wr := range buildWriteRequests(storage, re) {
+		data, err := wr.Marshal()
+		if err != nil {
+			return series, samples, fmt.Errorf("encoding write request: %w", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(snappy.Encode(nil, data)))
+		if err != nil {
+			return series, samples, err
+		}
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		applyPromAuth(req, opts.AuthMode, opts.User, opts.Pass, opts.OrgID, opts.APIKey)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return series, samples, err
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return series, samples, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		for _, ts := range wr.Timeseries {
+			series++
+			samples += len(ts.Samples) + len(ts.Histograms)
+		}
+	}
+	return series, samples, nil
+}
+
+// handleAdhocRemoteWrite pushes the store to a remote-write endpoint:
+// .remote_write <url> [metric_regex] [auth=...]
+func handleAdhocRemoteWrite(query string, storage *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimSpace(strings.TrimPrefix(query, ".remote_write")))
+	usage := GetAdHocCommandByName(".remote_write").Usage
+	if len(fields) == 0 {
+		fmt.Println("Usage: " + usage)
+		return true
+	}
+	url := fields[0]
+	var (
+		opts    RemoteAuthOptions
+		pattern string
+	)
+	for _, tok := range fields[1:] {
+		if parseRemoteAuthOption(tok, &opts) {
+			continue
+		}
+		if pattern != "" {
+			fmt.Println("Usage: " + usage)
+			return true
+		}
+		pattern = strings.Trim(tok, "\"'")
+	}
+	var re *regexp.Regexp
+	if pattern != "" {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			fmt.Printf("Invalid regex %q: %v\n", pattern, err)
+			return true
+		}
+	}
+	series, samples, err := RemoteWrite(context.Background(), url, re, opts, storage)
+	if err != nil {
+		fmt.Printf("Failed to remote_write to %s after %d series: %v\n", url, series, err)
+		return true
+	}
+	if series == 0 {
+		fmt.Println("No series matched; nothing was sent")
+		return true
+	}
+	fmt.Printf("Remote write %s: sent %d series, %d samples\n", url, series, samples)
+	return true
+}
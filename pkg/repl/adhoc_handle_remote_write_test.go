@@ -0,0 +1,72 @@
+package repl
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_RemoteWrite_PushesSeries(t *testing.T) {
+	var got prompb.WriteRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("X-Prometheus-Remote-Write-Version") == "" {
+			http.Error(w, "bad headers", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		raw, err := snappy.Decode(nil, body)
+		if err == nil {
+			err = got.Unmarshal(raw)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	store := sstorage.NewSimpleStorage()
+	store.AddSample(map[string]string{"__name__": "synthetic_load", "job": "b"}, 2, 2000)
+	store.AddSample(map[string]string{"__name__": "synthetic_load", "job": "a"}, 1, 1000)
+	store.AddSample(map[string]string{"__name__": "synthetic_load", "job": "b"}, 1, 1000)
+	store.AddSample(map[string]string{"__name__": "other", "job": "a"}, 5, 1000)
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".remote_write "+srv.URL+" '^synthetic_'", store) })
+	if !strings.Contains(out, "sent 2 series, 3 samples") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	if len(got.Timeseries) != 2 {
+		t.Fatalf("expected 2 series, got %+v", got.Timeseries)
+	}
+	for _, ts := range got.Timeseries {
+		if ts.Labels[0].Name != "__name__" || ts.Labels[0].Value != "synthetic_load" {
+			t.Fatalf("labels not sorted or wrong metric: %+v", ts.Labels)
+		}
+		for i := 1; i < len(ts.Samples); i++ {
+			if ts.Samples[i].Timestamp < ts.Samples[i-1].Timestamp {
+				t.Fatalf("samples not time-ordered: %+v", ts.Samples)
+			}
+		}
+	}
+
+	// Regexes containing "=" are not taken for auth options
+	for _, re := range []string{`other{job="a"}`, "x=~y"} {
+		out = captureStdout(t, func() { _ = handleAdHocFunction(".remote_write "+srv.URL+" "+re, store) })
+		if !strings.Contains(out, "No series matched") {
+			t.Fatalf("expected %s used as the metric regex, got %q", re, out)
+		}
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".remote_write "+srv.URL+" '^nomatch$'", store) })
+	if !strings.Contains(out, "No series matched") {
+		t.Fatalf("expected no-match message, got %q", out)
+	}
+}
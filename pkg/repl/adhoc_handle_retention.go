@@ -0,0 +1,138 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+	"github.com/prometheus/common/model"
+)
+
+var (
+	// retention, when set, is how far back from the newest sample the store keeps samples.
+	retention        time.Duration
+	retentionDropped int
+	// downsampleResolution, when set, is the interval samples older than downsampleAge
+	// are reduced to, keeping the newest sample of each series in every interval.
+	downsampleResolution, downsampleAge time.Duration
+	downsampleDropped                   int
+)
+
+// applyStoreLimits applies the retention and downsampling to the store, returning the
+// samples each dropped.
+func applyStoreLimits(storage *sstorage.SimpleStorage) (retained, downsampled int) {
+	if retention <= 0 && downsampleResolution <= 0 {
+		return 0, 0
+	}
+	// Relative to the newest sample, so loaded captures are aged like live scrapes
+	newest := storage.MaxTimestamp()
+	if retention > 0 {
+		retained = storage.DropBefore(newest - retention.Milliseconds())
+		retentionDropped += retained
+	}
+	if downsampleResolution > 0 {
+		downsampled = storage.Downsample(downsampleResolution.Milliseconds(), newest-downsampleAge.Milliseconds())
+		downsampleDropped += downsampled
+	}
+	return retained, downsampled
+}
+
+// enforceStoreLimits applies the retention and downsampling after a scrape or import,
+// printing what was dropped.
+func enforceStoreLimits(storage *sstorage.SimpleStorage) {
+	retained, downsampled := applyStoreLimits(storage)
+	if retained > 0 {
+		fmt.Printf("Retention: dropped %d samples older than %s\n", retained, model.Duration(retention))
+	}
+	if downsampled > 0 {
+		fmt.Printf("Downsampling: dropped %d samples to a %s resolution\n", downsampled, model.Duration(downsampleResolution))
+	}
+}
+
+// handleAdhocRetention shows or sets the store retention: .retention [<duration>|off]
+func handleAdhocRetention(query string, storage *sstorage.SimpleStorage) bool {
+	arg := strings.TrimSpace(strings.TrimPrefix(query, ".retention"))
+	switch arg {
+	case "":
+		if retention <= 0 {
+			fmt.Printf("Retention: off (dropped %d samples so far)\n", retentionDropped)
+		} else {
+			fmt.Printf("Retention: %s (dropped %d samples so far)\n", model.Duration(retention), retentionDropped)
+		}
+		return true
+	case "off":
+		retention = 0
+		fmt.Println("Retention: off")
+		return true
+	}
+	d, err := model.ParseDuration(arg)
+	if err != nil || d <= 0 {
+		if err == nil {
+			err = errors.New("retention must be positive")
+		}
+		printStoreLimitsUsage(".retention", err)
+		return true
+	}
+	retention = time.Duration(d)
+	_, before := storeTotals(storage)
+	dropped, _ := applyStoreLimits(storage)
+	fmt.Printf("Retention: %s, dropped %d of %d samples\n", model.Duration(retention), dropped, before)
+	return true
+}
+
+// handleAdhocDownsample shows or sets the store downsampling:
+// .downsample [<resolution> [older=<duration>]|off]
+func handleAdhocDownsample(query string, storage *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimPrefix(query, ".downsample"))
+	switch {
+	case len(fields) == 0:
+		if downsampleResolution <= 0 {
+			fmt.Printf("Downsampling: off (dropped %d samples so far)\n", downsampleDropped)
+		} else {
+			fmt.Printf("Downsampling: %s resolution for samples older than %s (dropped %d samples so far)\n",
+				model.Duration(downsampleResolution), model.Duration(downsampleAge), downsampleDropped)
+		}
+		return true
+	case len(fields) == 1 && fields[0] == "off":
+		downsampleResolution = 0
+		fmt.Println("Downsampling: off")
+		return true
+	case len(fields) > 2:
+		printStoreLimitsUsage(".downsample", errors.New("too many arguments"))
+		return true
+	}
+	res, err := model.ParseDuration(fields[0])
+	if err == nil && res <= 0 {
+		err = errors.New("resolution must be positive")
+	}
+	var age model.Duration
+	if err == nil && len(fields) == 2 {
+		value, ok := strings.CutPrefix(fields[1], "older=")
+		if !ok {
+			err = fmt.Errorf("unknown argument %q", fields[1])
+		} else {
+			age, err = model.ParseDuration(value)
+		}
+	}
+	if err != nil {
+		printStoreLimitsUsage(".downsample", err)
+		return true
+	}
+	downsampleResolution, downsampleAge = time.Duration(res), time.Duration(age)
+	_, before := storeTotals(storage)
+	_, dropped := applyStoreLimits(storage)
+	fmt.Printf("Downsampling: %s resolution for samples older than %s, dropped %d of %d samples\n",
+		model.Duration(downsampleResolution), model.Duration(downsampleAge), dropped, before)
+	return true
+}
+
+func printStoreLimitsUsage(command string, err error) {
+	fmt.Printf("Error: %v\n", err)
+	cmd := GetAdHocCommandByName(command)
+	fmt.Println("Usage: " + cmd.Usage)
+	for _, ex := range cmd.Examples {
+		fmt.Println("Example: " + ex)
+	}
+}
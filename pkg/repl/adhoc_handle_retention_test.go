@@ -0,0 +1,47 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhocRetentionAndDownsample(t *testing.T) {
+	defer func() { retention, downsampleResolution, retentionDropped, downsampleDropped = 0, 0, 0, 0 }()
+	store := sstorage.NewSimpleStorage()
+	for ts := int64(0); ts < 120; ts++ { // 2h of samples every minute
+		store.AddSample(map[string]string{"__name__": "up"}, 1, ts*60000)
+	}
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".retention 1h", store) })
+	if !strings.Contains(out, "Retention: 1h, dropped 59 of 120 samples") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".downsample 5m older=30m", store) })
+	if !strings.Contains(out, "dropped 23 of 61 samples") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+
+	// New samples are aged after scrapes and imports
+	store.AddSample(map[string]string{"__name__": "up"}, 1, 150*60000)
+	out = captureStdout(t, func() { enforceStoreLimits(store) })
+	if !strings.Contains(out, "Retention: dropped") || !strings.Contains(out, "Downsampling: dropped") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".retention", store) }); !strings.Contains(out, "Retention: 1h (dropped") {
+		t.Fatalf("unexpected status: %s", out)
+	}
+
+	for cmd, want := range map[string]string{
+		".retention off":          "Retention: off",
+		".downsample off":         "Downsampling: off",
+		".retention soon":         "Usage: .retention",
+		".downsample 1m after=1h": "Usage: .downsample",
+		".downsample 0s":          "resolution must be positive",
+	} {
+		if out := captureStdout(t, func() { _ = handleAdHocFunction(cmd, store) }); !strings.Contains(out, want) {
+			t.Errorf("%s: expected %q in %q", cmd, want, out)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package repl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// cutMatchers splits the leading label matchers of s, either a {...} selector or a single
+// name<op>"value" matcher, from the rest of it. Quoted values may contain spaces and braces.
+func cutMatchers(s string) (matchers, rest string) {
+	s = strings.TrimSpace(s)
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '}' && s[0] == '{':
+			return s[:i+1], strings.TrimSpace(s[i+1:])
+		case (c == ' ' || c == '\t') && s[0] != '{':
+			return s[:i], strings.TrimSpace(s[i+1:])
+		}
+	}
+	return s, ""
+}
+
+// addMatchers adds the matchers to every vector selector of expr, replacing any matchers
+// on the same labels. It returns the number of selectors changed.
+func addMatchers(expr parser.Expr, ms []*labels.Matcher) int {
+	n := 0
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		kept := vs.LabelMatchers[:0]
+		for _, m := range vs.LabelMatchers {
+			replaced := false
+			for _, add := range ms {
+				replaced = replaced || m.Name == add.Name
+			}
+			if !replaced {
+				kept = append(kept, m)
+			}
+		}
+		vs.LabelMatchers = append(kept, ms...)
+		n++
+		return nil
+	})
+	return n
+}
+
+// stripAggregations replaces every aggregation of expr by the expression it aggregates,
+// e.g. to see the series behind a sum. It returns the new expression and the number of
+// aggregations removed.
+func stripAggregations(expr parser.Expr) (parser.Expr, int) {
+	n := 0
+	var strip func(e parser.Expr) parser.Expr
+	strip = func(e parser.Expr) parser.Expr {
+		switch e := e.(type) {
+		case *parser.AggregateExpr:
+			n++
+			return strip(e.Expr)
+		case *parser.BinaryExpr:
+			e.LHS, e.RHS = strip(e.LHS), strip(e.RHS)
+		case *parser.Call:
+			for i, a := range e.Args {
+				e.Args[i] = strip(a)
+			}
+		case *parser.ParenExpr:
+			e.Expr = strip(e.Expr)
+		case *parser.UnaryExpr:
+			e.Expr = strip(e.Expr)
+		case *parser.SubqueryExpr:
+			e.Expr = strip(e.Expr)
+		case *parser.StepInvariantExpr:
+			e.Expr = strip(e.Expr)
+		}
+		return e
+	}
+	return strip(expr), n
+}
+
+// handleAdhocRewrite mechanically transforms a query and prints the result:
+// .rewrite add-matcher <matchers> <query> | .rewrite strip-aggregation <query>
+func handleAdhocRewrite(query string, _ *sstorage.SimpleStorage) bool {
+	usage := func() {
+		cmd := GetAdHocCommandByName(".rewrite")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+	}
+	action, rest, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(query, ".rewrite")), " ")
+	parse := func(q string) parser.Expr {
+		q = strings.TrimSpace(q)
+		if alertExpr := GetAlertExpr(q); alertExpr != "" {
+			q = alertExpr
+		}
+		if q == "" {
+			usage()
+			return nil
+		}
+		expr, err := promParser.ParseExpr(q)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return nil
+		}
+		return expr
+	}
+
+	switch action {
+	case "add-matcher":
+		arg, q := cutMatchers(rest)
+		if arg == "" || q == "" {
+			usage()
+			return true
+		}
+		sel := arg
+		if !strings.HasPrefix(sel, "{") {
+			sel = "{" + sel + "}"
+		}
+		ms, err := promParser.ParseMetricSelector(sel)
+		if err != nil {
+			fmt.Printf("Error: invalid matchers %q: %v\n", arg, err)
+			return true
+		}
+		expr := parse(q)
+		if expr == nil {
+			return true
+		}
+		if addMatchers(expr, ms) == 0 {
+			fmt.Println("Note: the query has no selectors to add matchers to")
+		}
+		fmt.Println(expr.String())
+	case "strip-aggregation":
+		expr := parse(rest)
+		if expr == nil {
+			return true
+		}
+		expr, n := stripAggregations(expr)
+		if n == 0 {
+			fmt.Println("Note: the query has no aggregations to strip")
+		}
+		fmt.Println(expr.String())
+	default:
+		usage()
+	}
+	return true
+}
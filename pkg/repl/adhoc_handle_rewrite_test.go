@@ -0,0 +1,30 @@
+package repl
+
+import (
+	"testing"
+)
+
+func TestAdhoc_Rewrite(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{`.rewrite add-matcher job="api" sum(rate(http_requests_total{job="web",code="500"}[5m])) / sum(rate(http_requests_total[5m]))`,
+			`sum(rate(http_requests_total{code="500",job="api"}[5m])) / sum(rate(http_requests_total{job="api"}[5m]))` + "\n"},
+		{`.rewrite add-matcher {env="prod",cluster=~"eu .*"} up == 0`,
+			`up{cluster=~"eu .*",env="prod"} == 0` + "\n"},
+		{`.rewrite add-matcher job="a b" vector(1)`,
+			"Note: the query has no selectors to add matchers to\nvector(1)\n"},
+		{`.rewrite strip-aggregation topk(3, sum by (job) (rate(x[5m]))) > 1`,
+			"rate(x[5m]) > 1\n"},
+		{`.rewrite strip-aggregation rate(x[5m])`,
+			"Note: the query has no aggregations to strip\nrate(x[5m])\n"},
+		{`.rewrite add-matcher job~"x" up`,
+			"Error: invalid matchers \"job~\\\"x\\\"\": 1:5: parse error: unexpected character inside braces: '~'\n"},
+	}
+	for _, c := range cases {
+		out := captureStdout(t, func() { _ = handleAdHocFunction(c.in, nil) })
+		if out != c.want {
+			t.Errorf("%s:\ngot:  %q\nwant: %q", c.in, out, c.want)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// storeMu serializes access to the store between the REPL (commands, queries and
+// completion) and the background scrapes of .scrape_bg.
+var storeMu sync.Mutex
+
+// storeHeld is set while the REPL runs a line holding storeMu (see lockStore), so that
+// commands refreshing until stopped can release it while they wait.
+var storeHeld atomic.Bool
+
+// interactive is set when the store is only accessed by the interactive REPL, which
+// holds storeMu while it runs a line. Other callers (serve, -q, piped queries) read the
+// store without it, so .scrape_bg is refused for them.
+var interactive bool
+
+// SetInteractive tells whether the store is only accessed by the interactive REPL, as
+// before the REPL starts, allowing .scrape_bg from the init commands.
+func SetInteractive(on bool) {
+	interactive = on
+}
+
+// lockStore takes storeMu for the REPL to run a line.
+func lockStore() {
+	storeMu.Lock()
+	storeHeld.Store(true)
+}
+
+// unlockStore releases storeMu taken by lockStore.
+func unlockStore() {
+	storeHeld.Store(false)
+	storeMu.Unlock()
+}
+
+// waitReleasingStore waits for d, releasing storeMu meanwhile when the REPL holds it so
+// that background scrapes can merge. It reports false when ctx is canceled first.
+func waitReleasingStore(ctx context.Context, d time.Duration) bool {
+	if storeHeld.Load() {
+		unlockStore()
+		defer lockStore()
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+const (
+	// defaultScrapeBgInterval is the .scrape_bg interval when none is given.
+	defaultScrapeBgInterval = 15 * time.Second
+	// maxScrapeBgBackoff bounds the delay after consecutive failed scrapes.
+	maxScrapeBgBackoff = 5 * time.Minute
+)
+
+// bgScrape is a background scrape started with .scrape_bg.
+type bgScrape struct {
+	id       int
+	uri      string
+	interval time.Duration
+	re       *regexp.Regexp
+	rt       http.RoundTripper // applies the scrape headers, credentials and TLS options
+	cancel   context.CancelFunc
+
+	// Guarded by mu, as read by .scrape_bg list while scraping
+	mu                           sync.Mutex
+	scrapes, failures, errStreak int
+	samples                      int // added by the last successful scrape
+	last, next                   time.Time
+	lastErr                      error
+}
+
+var (
+	bgScrapesMu sync.Mutex
+	bgScrapes   = map[int]*bgScrape{}
+	bgScrapeSeq int
+)
+
+// backoff returns the delay before the next scrape: the interval, doubled for each
+// consecutive failure up to maxScrapeBgBackoff.
+func (b *bgScrape) backoff() time.Duration {
+	d := b.interval
+	for i := 0; i < b.errStreak && d < maxScrapeBgBackoff; i++ {
+		d *= 2
+	}
+	return min(d, max(maxScrapeBgBackoff, b.interval))
+}
+
+// run scrapes until canceled.
+func (b *bgScrape) run(ctx context.Context, storage *sstorage.SimpleStorage) {
+	client := &http.Client{Timeout: min(b.interval, 60*time.Second), Transport: b.rt}
+	for {
+		added, err := scrapeInto(ctx, client, b.uri, b.re, storage)
+		if ctx.Err() != nil {
+			return
+		}
+		b.mu.Lock()
+		b.scrapes++
+		b.last = time.Now()
+		if err != nil {
+			b.failures++
+			b.errStreak++
+			b.lastErr = err
+		} else {
+			b.errStreak, b.samples = 0, added
+		}
+		delay := b.backoff()
+		b.next = b.last.Add(delay)
+		b.mu.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scrapeInto scrapes uri into a new store, then merges it into storage holding storeMu,
+// applying .retention/.downsample and the active rules. It returns the samples added.
+func scrapeInto(ctx context.Context, client *http.Client, uri string, re *regexp.Regexp, storage *sstorage.SimpleStorage) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", sstorage.ScrapeAcceptHeader)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var filter func(name string) bool
+	if re != nil {
+		filter = func(name string) bool { return re.MatchString(name) }
+	}
+	scraped := sstorage.NewSimpleStorage()
+	if err := scraped.LoadFromReaderWithContentType(resp.Body, resp.Header.Get("Content-Type"), filter); err != nil {
+		return 0, err
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if ctx.Err() != nil {
+		// Stopped while waiting for the REPL
+		return 0, ctx.Err()
+	}
+	_, added := mergeScrapedStore(storage, scraped)
+	applyStoreLimits(storage)
+	_, _, _ = EvaluateActiveRules(storage)
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+	return added, nil
+}
+
+// handleAdhocScrapeBg scrapes in the background while the REPL stays usable:
+// .scrape_bg <URI> [interval] [metrics_regex] | .scrape_bg [list] | .scrape_bg stop [id|all]
+func handleAdhocScrapeBg(query string, storage *sstorage.SimpleStorage) bool {
+	args := splitArgs(strings.TrimPrefix(query, ".scrape_bg"))
+	var auth ScrapeAuth
+	if len(args) > 0 && args[0] != "stop" && args[0] != "list" {
+		var err error
+		if auth, args, err = parseScrapeAuth(args[0], args); err != nil {
+			printScrapeBgUsage(err)
+			return true
+		}
+	}
+	switch {
+	case len(args) == 0 || len(args) == 1 && args[0] == "list":
+		printBgScrapes()
+		return true
+	case args[0] == "stop" && len(args) <= 2:
+		target := "all"
+		if len(args) == 2 {
+			target = args[1]
+		}
+		if err := stopBgScrapes(target); err != nil {
+			printScrapeBgUsage(err)
+		}
+		return true
+	case len(args) > 3:
+		printScrapeBgUsage(fmt.Errorf("too many arguments"))
+		return true
+	}
+
+	if !interactive {
+		fmt.Println("Error: .scrape_bg only runs in the interactive REPL; use .scrape for a one-off scrape")
+		return true
+	}
+	rt, err := auth.RoundTripper()
+	if err != nil {
+		printScrapeBgUsage(err)
+		return true
+	}
+	b := &bgScrape{uri: args[0], interval: defaultScrapeBgInterval, rt: rt}
+	intervalSet := false
+	for _, tok := range args[1:] {
+		if d, err := time.ParseDuration(tok); err == nil && d > 0 && !intervalSet {
+			b.interval, intervalSet = d, true
+			continue
+		}
+		re, err := regexp.Compile(strings.Trim(tok, "\"'"))
+		if err != nil || b.re != nil {
+			printScrapeBgUsage(fmt.Errorf("invalid metrics_regex %q", tok))
+			return true
+		}
+		b.re = re
+	}
+	if !strings.HasPrefix(b.uri, "http://") && !strings.HasPrefix(b.uri, "https://") {
+		printScrapeBgUsage(fmt.Errorf("invalid URI %q (expected http:// or https://)", b.uri))
+		return true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	bgScrapesMu.Lock()
+	bgScrapeSeq++
+	b.id = bgScrapeSeq
+	bgScrapes[b.id] = b
+	bgScrapesMu.Unlock()
+	go b.run(ctx, storage)
+	fmt.Printf("Started background scrape #%d of %s every %s (list with .scrape_bg list, stop with .scrape_bg stop %d)\n", b.id, b.uri, b.interval, b.id)
+	return true
+}
+
+// stopBgScrapes stops the background scrape with the given id, or all of them. A scrape
+// in progress is not merged into the store.
+func stopBgScrapes(target string) error {
+	bgScrapesMu.Lock()
+	var stopping []*bgScrape
+	if target == "all" {
+		for _, b := range bgScrapes {
+			stopping = append(stopping, b)
+		}
+	} else {
+		id, err := strconv.Atoi(strings.TrimPrefix(target, "#"))
+		if err != nil || bgScrapes[id] == nil {
+			bgScrapesMu.Unlock()
+			return fmt.Errorf("no background scrape %q", target)
+		}
+		stopping = append(stopping, bgScrapes[id])
+	}
+	for _, b := range stopping {
+		delete(bgScrapes, b.id)
+	}
+	bgScrapesMu.Unlock()
+
+	sort.Slice(stopping, func(i, j int) bool { return stopping[i].id < stopping[j].id })
+	for _, b := range stopping {
+		b.cancel()
+		fmt.Printf("Stopped background scrape #%d of %s\n", b.id, b.uri)
+	}
+	if len(stopping) == 0 {
+		fmt.Println("No background scrapes running")
+	}
+	return nil
+}
+
+func printBgScrapes() {
+	bgScrapesMu.Lock()
+	scrapes := make([]*bgScrape, 0, len(bgScrapes))
+	for _, b := range bgScrapes {
+		scrapes = append(scrapes, b)
+	}
+	bgScrapesMu.Unlock()
+	if len(scrapes) == 0 {
+		fmt.Println("No background scrapes running")
+		return
+	}
+	sort.Slice(scrapes, func(i, j int) bool { return scrapes[i].id < scrapes[j].id })
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tURI\tINTERVAL\tSCRAPES\tERRORS\tLAST SAMPLES\tLAST\tNEXT\tLAST ERROR")
+	for _, b := range scrapes {
+		b.mu.Lock()
+		last, next, lastErr := "-", "-", "-"
+		if !b.last.IsZero() {
+			last = time.Since(b.last).Round(time.Second).String() + " ago"
+			next = "in " + time.Until(b.next).Round(time.Second).String()
+		}
+		if b.lastErr != nil {
+			lastErr = b.lastErr.Error()
+			if b.errStreak > 0 {
+				lastErr += fmt.Sprintf(" (%d in a row, backing off)", b.errStreak)
+			}
+		}
+		_, _ = fmt.Fprintf(tw, "#%d\t%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\n", b.id, b.uri, b.interval, b.scrapes, b.failures, b.samples, last, next, lastErr)
+		b.mu.Unlock()
+	}
+	_ = tw.Flush()
+}
+
+func printScrapeBgUsage(err error) {
+	fmt.Printf("Error: %v\n", err)
+	cmd := GetAdHocCommandByName(".scrape_bg")
+	fmt.Println("Usage: " + cmd.Usage)
+	for _, ex := range cmd.Examples {
+		fmt.Println("Example: " + ex)
+	}
+}
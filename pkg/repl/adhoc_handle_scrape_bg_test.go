@@ -0,0 +1,87 @@
+package repl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhocScrapeBg(t *testing.T) {
+	var fail atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("up 1\nskipped 2\n"))
+	}))
+	defer srv.Close()
+	defer func() { _ = stopBgScrapes("all") }()
+	store := sstorage.NewSimpleStorage()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".scrape_bg "+srv.URL+" 20ms", store) })
+	if !strings.Contains(out, "only runs in the interactive REPL") {
+		t.Fatalf("expected .scrape_bg refused outside the REPL, got: %s", out)
+	}
+	SetInteractive(true)
+	defer SetInteractive(false)
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".scrape_bg "+srv.URL+" 20ms '^up$'", store) })
+	if !strings.Contains(out, "Started background scrape #") || !strings.Contains(out, "every 20ms") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	samples := func() int {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		if len(store.Metrics["skipped"]) > 0 {
+			t.Fatal("metrics_regex not applied")
+		}
+		return len(store.Metrics["up"])
+	}
+	for deadline := time.Now().Add(5 * time.Second); samples() < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the background scrape didn't update the store")
+		}
+	}
+
+	fail.Store(true)
+	var list string
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(list, "backing off"); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected failures in the list: %s", list)
+		}
+		list = captureStdout(t, func() { _ = handleAdHocFunction(".scrape_bg list", store) })
+	}
+	if !strings.Contains(list, "HTTP 500") || !strings.Contains(list, srv.URL) {
+		t.Fatalf("unexpected list: %s", list)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".scrape_bg stop all", store) })
+	if !strings.Contains(out, "Stopped background scrape #") {
+		t.Fatalf("unexpected stop output: %s", out)
+	}
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".scrape_bg", store) }); !strings.Contains(out, "No background scrapes running") {
+		t.Fatalf("unexpected list after stop: %s", out)
+	}
+	if out = captureStdout(t, func() { _ = handleAdHocFunction(".scrape_bg stop 99", store) }); !strings.Contains(out, "Usage: .scrape_bg") {
+		t.Fatalf("expected usage: %s", out)
+	}
+}
+
+func TestBgScrapeBackoff(t *testing.T) {
+	b := &bgScrape{interval: 10 * time.Second}
+	for streak, want := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second} {
+		b.errStreak = streak
+		if got := b.backoff(); got != want {
+			t.Errorf("%d failures: got %s, want %s", streak, got, want)
+		}
+	}
+	b.errStreak = 10
+	if got := b.backoff(); got != maxScrapeBgBackoff {
+		t.Errorf("expected the backoff capped at %s, got %s", maxScrapeBgBackoff, got)
+	}
+}
@@ -0,0 +1,420 @@
+package repl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"go.yaml.in/yaml/v2"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// Defaults for .scrape_config, matching Prometheus where it has one.
+const (
+	defaultScrapeConfigInterval = model.Duration(time.Minute)
+	defaultScrapeConfigTimeout  = model.Duration(10 * time.Second)
+)
+
+// ScrapeConfigFile is the subset of a Prometheus configuration understood by .scrape_config.
+// Unknown keys (rule_files, service discovery, ...) are ignored so a prometheus.yml can be
+// used as-is; only static_configs targets are scraped.
+type ScrapeConfigFile struct {
+	Global struct {
+		ScrapeInterval model.Duration `yaml:"scrape_interval,omitempty"`
+		ScrapeTimeout  model.Duration `yaml:"scrape_timeout,omitempty"`
+		Count          int            `yaml:"count,omitempty"`
+	} `yaml:"global"`
+	ScrapeConfigs []*ScrapeJobConfig `yaml:"scrape_configs"`
+}
+
+// ScrapeJobConfig is one scrape_configs entry. Count is a promql-cli extension: the number of
+// scrapes per target, scrape_interval apart.
+type ScrapeJobConfig struct {
+	JobName              string               `yaml:"job_name"`
+	ScrapeInterval       model.Duration       `yaml:"scrape_interval,omitempty"`
+	ScrapeTimeout        model.Duration       `yaml:"scrape_timeout,omitempty"`
+	Count                int                  `yaml:"count,omitempty"`
+	MetricsPath          string               `yaml:"metrics_path,omitempty"`
+	Scheme               string               `yaml:"scheme,omitempty"`
+	Params               url.Values           `yaml:"params,omitempty"`
+	StaticConfigs        []ScrapeStaticConfig `yaml:"static_configs,omitempty"`
+	RelabelConfigs       []*relabel.Config    `yaml:"relabel_configs,omitempty"`
+	MetricRelabelConfigs []*relabel.Config    `yaml:"metric_relabel_configs,omitempty"`
+}
+
+// ScrapeStaticConfig is a static_configs entry: targets sharing a set of extra labels.
+type ScrapeStaticConfig struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+}
+
+// ScrapeTarget is a resolved target after relabel_configs, ready to be scraped.
+type ScrapeTarget struct {
+	Job      string
+	URL      string
+	Labels   map[string]string // target labels (job, instance, static labels) attached to every series
+	Interval time.Duration
+	Timeout  time.Duration
+	Count    int
+	Metric   []*relabel.Config // metric_relabel_configs
+}
+
+// ParseScrapeConfig parses a Prometheus-style configuration with scrape_configs, filling in
+// Prometheus defaults (scheme http, metrics_path /metrics, 1m interval, 10s timeout).
+func ParseScrapeConfig(data []byte) (*ScrapeConfigFile, error) {
+	cfg := &ScrapeConfigFile{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.ScrapeConfigs) == 0 {
+		return nil, errors.New("no scrape_configs found")
+	}
+	if cfg.Global.ScrapeInterval == 0 {
+		cfg.Global.ScrapeInterval = defaultScrapeConfigInterval
+	}
+	if cfg.Global.ScrapeTimeout == 0 {
+		cfg.Global.ScrapeTimeout = defaultScrapeConfigTimeout
+	}
+	if cfg.Global.Count < 1 {
+		cfg.Global.Count = 1
+	}
+	seen := map[string]bool{}
+	for i, job := range cfg.ScrapeConfigs {
+		if job == nil || job.JobName == "" {
+			return nil, fmt.Errorf("scrape_configs[%d]: job_name is required", i)
+		}
+		if seen[job.JobName] {
+			return nil, fmt.Errorf("duplicate job_name %q", job.JobName)
+		}
+		seen[job.JobName] = true
+		if job.ScrapeInterval == 0 {
+			job.ScrapeInterval = cfg.Global.ScrapeInterval
+		}
+		if job.ScrapeTimeout == 0 {
+			job.ScrapeTimeout = min(cfg.Global.ScrapeTimeout, job.ScrapeInterval)
+		}
+		if job.Count < 1 {
+			job.Count = cfg.Global.Count
+		}
+		if job.MetricsPath == "" {
+			job.MetricsPath = "/metrics"
+		}
+		if job.Scheme == "" {
+			job.Scheme = "http"
+		}
+		if job.Scheme != "http" && job.Scheme != "https" {
+			return nil, fmt.Errorf("job %q: unsupported scheme %q", job.JobName, job.Scheme)
+		}
+		for _, rcs := range [][]*relabel.Config{job.RelabelConfigs, job.MetricRelabelConfigs} {
+			for j, rc := range rcs {
+				if rc == nil {
+					return nil, fmt.Errorf("job %q: relabel rule %d is empty", job.JobName, j+1)
+				}
+				if err := rc.Validate(model.UTF8Validation); err != nil {
+					return nil, fmt.Errorf("job %q: relabel rule %d: %w", job.JobName, j+1, err)
+				}
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// Targets resolves the static targets of all jobs, applying relabel_configs the way Prometheus
+// does for discovered targets. It also returns the number of targets dropped by relabeling.
+func (c *ScrapeConfigFile) Targets() ([]ScrapeTarget, int) {
+	var targets []ScrapeTarget
+	dropped := 0
+	lb := labels.NewBuilder(labels.EmptyLabels())
+	for _, job := range c.ScrapeConfigs {
+		for _, sc := range job.StaticConfigs {
+			for _, addr := range sc.Targets {
+				lb.Reset(labels.EmptyLabels())
+				for k, v := range sc.Labels {
+					lb.Set(k, v)
+				}
+				lb.Set(model.AddressLabel, addr)
+				lb.Set(model.SchemeLabel, job.Scheme)
+				lb.Set(model.MetricsPathLabel, job.MetricsPath)
+				lb.Set(model.JobLabel, job.JobName)
+				lb.Set(model.ScrapeIntervalLabel, job.ScrapeInterval.String())
+				lb.Set(model.ScrapeTimeoutLabel, job.ScrapeTimeout.String())
+				for k, v := range job.Params {
+					if len(v) > 0 {
+						lb.Set(model.ParamLabelPrefix+k, v[0])
+					}
+				}
+				if !relabel.ProcessBuilder(lb, job.RelabelConfigs...) || lb.Get(model.AddressLabel) == "" {
+					dropped++
+					continue
+				}
+				if lb.Get(model.InstanceLabel) == "" {
+					lb.Set(model.InstanceLabel, lb.Get(model.AddressLabel))
+				}
+				params := url.Values{}
+				for k, v := range job.Params {
+					params[k] = append([]string(nil), v...)
+				}
+				tl := map[string]string{}
+				lb.Labels().Range(func(l labels.Label) {
+					if name, ok := strings.CutPrefix(l.Name, model.ParamLabelPrefix); ok {
+						params.Set(name, l.Value)
+					}
+					if !strings.HasPrefix(l.Name, model.ReservedLabelPrefix) {
+						tl[l.Name] = l.Value
+					}
+				})
+				u := url.URL{
+					Scheme:   lb.Get(model.SchemeLabel),
+					Host:     lb.Get(model.AddressLabel),
+					Path:     lb.Get(model.MetricsPathLabel),
+					RawQuery: params.Encode(),
+				}
+				targets = append(targets, ScrapeTarget{
+					Job:      job.JobName,
+					URL:      u.String(),
+					Labels:   tl,
+					Interval: time.Duration(job.ScrapeInterval),
+					Timeout:  time.Duration(job.ScrapeTimeout),
+					Count:    job.Count,
+					Metric:   job.MetricRelabelConfigs,
+				})
+			}
+		}
+	}
+	return targets, dropped
+}
+
+// ScrapeTargetResult summarizes the scrapes of one target.
+type ScrapeTargetResult struct {
+	Target   ScrapeTarget
+	Scrapes  int // successful scrapes
+	Series   int // series in the last successful scrape
+	Samples  int // samples added to the store over all scrapes (excluding up)
+	Duration time.Duration
+	Err      error // error from the last failed scrape, if the last scrape failed
+}
+
+// ScrapeTargets scrapes all targets concurrently, Count times each and Interval apart, merging
+// the samples into storage with target labels attached and metric_relabel_configs applied.
+// Every scrape also records an up{job,instance} sample, like Prometheus.
+func ScrapeTargets(ctx context.Context, storage *sstorage.SimpleStorage, targets []ScrapeTarget) []ScrapeTargetResult {
+	results := make([]ScrapeTargetResult, len(targets))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := range targets {
+		results[i].Target = targets[i]
+		wg.Add(1)
+		go func(res *ScrapeTargetResult) {
+			defer wg.Done()
+			client := &http.Client{Timeout: res.Target.Timeout}
+			for n := 0; n < res.Target.Count && ctx.Err() == nil; n++ {
+				if n > 0 {
+					select {
+					case <-time.After(res.Target.Interval):
+					case <-ctx.Done():
+						return
+					}
+				}
+				start := time.Now()
+				scraped, err := scrapeTargetOnce(ctx, client, res.Target)
+				res.Duration = time.Since(start)
+				res.Err = err
+				up := 0.0
+				if err == nil {
+					up = 1
+					res.Scrapes++
+				}
+				mu.Lock()
+				if err == nil {
+					series, samples := mergeScrapedStore(storage, scraped)
+					res.Series = series
+					res.Samples += samples
+				}
+				upLabels := map[string]string{
+					labels.MetricName:   "up",
+					model.JobLabel:      res.Target.Labels[model.JobLabel],
+					model.InstanceLabel: res.Target.Labels[model.InstanceLabel],
+				}
+				storage.AddSample(upLabels, up, start.UnixMilli())
+				mu.Unlock()
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// scrapeTargetOnce fetches one target into a fresh store, attaching the target labels (existing
+// conflicting labels are kept as exported_<name>, as with honor_labels: false) and applying
+// metric_relabel_configs.
+func scrapeTargetOnce(ctx context.Context, client *http.Client, t ScrapeTarget) (*sstorage.SimpleStorage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", sstorage.ScrapeAcceptHeader)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	scraped := sstorage.NewSimpleStorage()
+	if err := scraped.LoadFromReaderWithContentType(resp.Body, resp.Header.Get("Content-Type"), nil); err != nil {
+		return nil, err
+	}
+	attachTargetLabels(scraped, t.Labels, false)
+	if len(t.Metric) > 0 {
+		ApplyRelabel(scraped, t.Metric)
+	}
+	return scraped, nil
+}
+
+// attachTargetLabels adds target labels to every scraped series. With honorLabels, labels
+// already present on a series win; otherwise the target label wins and a conflicting scraped
+// value is kept as exported_<name>, like Prometheus' honor_labels setting.
+func attachTargetLabels(scraped *sstorage.SimpleStorage, target map[string]string, honorLabels bool) {
+	for _, samples := range scraped.Metrics {
+		for i := range samples {
+			lbls := samples[i].Labels
+			for k, v := range target {
+				old, ok := lbls[k]
+				switch {
+				case ok && honorLabels:
+					continue
+				case ok && old != v:
+					lbls[model.ExportedLabelPrefix+k] = old
+				}
+				lbls[k] = v
+			}
+		}
+	}
+}
+
+// mergeScrapedStore appends all samples of src into dst (keeping HELP/TYPE it doesn't have yet)
+// and returns the number of series and samples merged.
+func mergeScrapedStore(dst, src *sstorage.SimpleStorage) (int, int) {
+	series := map[string]bool{}
+	samples := 0
+	for name, ss := range src.Metrics {
+		dst.Metrics[name] = append(dst.Metrics[name], ss...)
+		for _, s := range ss {
+			series[seriesSignature(name, s.Labels)] = true
+		}
+		samples += len(ss)
+	}
+	for name, help := range src.MetricsHelp {
+		if _, ok := dst.MetricsHelp[name]; !ok {
+			dst.MetricsHelp[name] = help
+		}
+	}
+	for name, typ := range src.MetricsType {
+		if _, ok := dst.MetricsType[name]; !ok {
+			dst.MetricsType[name] = typ
+		}
+	}
+	return len(series), samples
+}
+
+// printScrapeTargetResults prints the per-target summary table of .scrape_config.
+func printScrapeTargetResults(results []ScrapeTargetResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Target.Job != results[j].Target.Job {
+			return results[i].Target.Job < results[j].Target.Job
+		}
+		return results[i].Target.Labels[model.InstanceLabel] < results[j].Target.Labels[model.InstanceLabel]
+	})
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "JOB\tINSTANCE\tSTATE\tSCRAPES\tSERIES\tSAMPLES\tLAST DURATION\tERROR")
+	for _, r := range results {
+		state := "up"
+		errText := ""
+		if r.Err != nil {
+			state = "down"
+			errText = r.Err.Error()
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%d/%d\t%d\t%d\t%s\t%s\n",
+			r.Target.Job, r.Target.Labels[model.InstanceLabel], state, r.Scrapes, r.Target.Count,
+			r.Series, r.Samples, r.Duration.Round(time.Millisecond), errText)
+	}
+	_ = tw.Flush()
+}
+
+// handleAdhocScrapeConfig scrapes the static targets of a Prometheus scrape config file:
+// .scrape_config <file.yaml>
+func handleAdhocScrapeConfig(query string, storage *sstorage.SimpleStorage) bool {
+	path := strings.Trim(strings.TrimSpace(strings.TrimPrefix(query, ".scrape_config")), "\"'")
+	if path == "" {
+		cmd := GetAdHocCommandByName(".scrape_config")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Failed to read scrape config: %v\n", err)
+		return true
+	}
+	cfg, err := ParseScrapeConfig(data)
+	if err != nil {
+		fmt.Printf("Invalid scrape config %s: %v\n", path, err)
+		return true
+	}
+	targets, dropped := cfg.Targets()
+	if dropped > 0 {
+		fmt.Printf("%d targets dropped by relabel_configs\n", dropped)
+	}
+	if len(targets) == 0 {
+		fmt.Println("No targets to scrape (only static_configs are supported)")
+		return true
+	}
+
+	// Create a context that can be canceled by Ctrl-C
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGINT)
+	defer signal.Stop(sigChan)
+	go func() {
+		select {
+		case <-sigChan:
+			fmt.Println("\nScraping interrupted")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	fmt.Printf("Scraping %d targets from %d jobs...\n", len(targets), len(cfg.ScrapeConfigs))
+	results := ScrapeTargets(ctx, storage, targets)
+	printScrapeTargetResults(results)
+	totalMetrics, totalSamples := storeTotals(storage)
+	fmt.Printf("Total: %d metrics, %d samples\n", totalMetrics, totalSamples)
+
+	if added, alerts, err := EvaluateActiveRules(storage); err != nil {
+		fmt.Printf("Rules evaluation failed: %v\n", err)
+	} else if added > 0 || alerts > 0 {
+		fmt.Printf("Rules: added %d samples; %d alerts\n", added, alerts)
+	}
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package repl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestParseScrapeConfig_DefaultsAndTargets(t *testing.T) {
+	cfg, err := ParseScrapeConfig([]byte(`
+global:
+  scrape_interval: 15s
+rule_files: [rules.yml]
+scrape_configs:
+- job_name: node
+  count: 2
+  params: {module: [http_2xx]}
+  static_configs:
+  - targets: ['web-1:9100', 'db-1:9100']
+    labels: {env: dev}
+  relabel_configs:
+  - source_labels: [__address__]
+    regex: 'db-.*'
+    action: drop
+  - source_labels: [__address__]
+    regex: '([^:]+):.*'
+    target_label: instance
+`))
+	if err != nil {
+		t.Fatalf("ParseScrapeConfig: %v", err)
+	}
+	job := cfg.ScrapeConfigs[0]
+	if job.Scheme != "http" || job.MetricsPath != "/metrics" || job.ScrapeInterval.String() != "15s" || job.ScrapeTimeout.String() != "10s" || job.Count != 2 {
+		t.Fatalf("unexpected defaults: %+v", job)
+	}
+	targets, dropped := cfg.Targets()
+	if dropped != 1 || len(targets) != 1 {
+		t.Fatalf("expected 1 target and 1 dropped, got %d/%d", len(targets), dropped)
+	}
+	tg := targets[0]
+	if tg.URL != "http://web-1:9100/metrics?module=http_2xx" {
+		t.Fatalf("unexpected URL %q", tg.URL)
+	}
+	if tg.Labels["instance"] != "web-1" || tg.Labels["job"] != "node" || tg.Labels["env"] != "dev" || len(tg.Labels) != 3 {
+		t.Fatalf("unexpected target labels %v", tg.Labels)
+	}
+
+	for _, bad := range []string{
+		"scrape_configs: []",
+		"scrape_configs: [{static_configs: [{targets: [a:1]}]}]",
+		"scrape_configs: [{job_name: a}, {job_name: a}]",
+		"scrape_configs: [{job_name: a, scheme: ftp}]",
+		"scrape_configs: [{job_name: a, relabel_configs: [{action: replace}]}]",
+	} {
+		if _, err := ParseScrapeConfig([]byte(bad)); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestAdhoc_ScrapeConfig_ScrapesTargetsConcurrently(t *testing.T) {
+	okSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE http_requests_total counter\nhttp_requests_total{code=\"200\",job=\"app\"} 7\ngo_goroutines 12\n"))
+	}))
+	defer okSrv.Close()
+	badSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer badSrv.Close()
+
+	path := filepath.Join(t.TempDir(), "scrape.yml")
+	cfg := `scrape_configs:
+- job_name: web
+  scrape_interval: 10ms
+  count: 2
+  static_configs:
+  - targets: ['` + strings.TrimPrefix(okSrv.URL, "http://") + `', '` + strings.TrimPrefix(badSrv.URL, "http://") + `']
+  metric_relabel_configs:
+  - source_labels: [__name__]
+    regex: 'go_.*'
+    action: drop
+`
+	if err := os.WriteFile(path, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := sstorage.NewSimpleStorage()
+	out := captureStdout(t, func() {
+		_ = handleAdHocFunction(".scrape_config "+path, store)
+	})
+	for _, want := range []string{"Scraping 2 targets from 1 jobs", "JOB", "up", "2/2", "down", "HTTP 500"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+	reqs := store.Metrics["http_requests_total"]
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 http_requests_total samples, got %d", len(reqs))
+	}
+	if l := reqs[0].Labels; l["job"] != "web" || l["exported_job"] != "app" || l["instance"] != strings.TrimPrefix(okSrv.URL, "http://") {
+		t.Fatalf("unexpected labels %v", l)
+	}
+	if _, ok := store.Metrics["go_goroutines"]; ok {
+		t.Fatalf("expected go_goroutines to be dropped by metric_relabel_configs")
+	}
+	up := map[float64]int{}
+	for _, s := range store.Metrics["up"] {
+		up[s.Value]++
+	}
+	if up[1] != 2 || up[0] != 2 {
+		t.Fatalf("expected 2 up=1 and 2 up=0 samples, got %v", up)
+	}
+}
@@ -0,0 +1,329 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// defaultScrapeDiffThreshold is the relative value change reported by .scrape_diff.
+const defaultScrapeDiffThreshold = 0.1
+
+// ScrapeDiff is the structured difference between two scrapes, A and B.
+type ScrapeDiff struct {
+	OnlyA, OnlyB             []string           // metric names present on one side only
+	LabelNames               []ScrapeDiffLabels // metrics whose label names differ
+	SeriesCounts             []ScrapeDiffCount  // metrics on both sides with another number of series
+	SeriesOnlyA, SeriesOnlyB []string           // series of common metrics present on one side only
+	ValueChanges             []ScrapeDiffValue  // series whose value changed beyond the threshold
+	CommonSeries             int                // series present on both sides
+	MetricsA, MetricsB       int                // compared metrics on each side
+	Threshold                float64            // relative value change reported
+}
+
+// ScrapeDiffCount is the number of series of a metric in A and B.
+type ScrapeDiffCount struct {
+	Metric string
+	A, B   int
+}
+
+// ScrapeDiffLabels lists the label names of a metric that only one side uses.
+type ScrapeDiffLabels struct {
+	Metric       string
+	OnlyA, OnlyB []string
+}
+
+// ScrapeDiffValue is a series whose value differs between A and B.
+type ScrapeDiffValue struct {
+	Series string
+	A, B   float64
+	Rel    float64 // |B-A| / max(|A|, |B|)
+}
+
+// DiffScrapes compares the latest sample of each series in a and b. Only metrics matching
+// filter (all when nil) are compared; value changes with a relative delta above threshold
+// are reported. Native histogram samples are compared by presence only.
+func DiffScrapes(a, b *sstorage.SimpleStorage, filter *regexp.Regexp, threshold float64) ScrapeDiff {
+	d := ScrapeDiff{Threshold: threshold}
+	names := map[string]bool{}
+	for name := range a.Metrics {
+		names[name] = true
+	}
+	for name := range b.Metrics {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		if filter == nil || filter.MatchString(name) {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		sa, inA := a.Metrics[name]
+		sb, inB := b.Metrics[name]
+		if inA {
+			d.MetricsA++
+		}
+		if inB {
+			d.MetricsB++
+		}
+		switch {
+		case !inB:
+			d.OnlyA = append(d.OnlyA, name)
+			continue
+		case !inA:
+			d.OnlyB = append(d.OnlyB, name)
+			continue
+		}
+		seriesA, namesA := latestSeries(sa)
+		seriesB, namesB := latestSeries(sb)
+		if len(seriesA) != len(seriesB) {
+			d.SeriesCounts = append(d.SeriesCounts, ScrapeDiffCount{Metric: name, A: len(seriesA), B: len(seriesB)})
+		}
+		if la, lb := setDifference(namesA, namesB), setDifference(namesB, namesA); len(la) > 0 || len(lb) > 0 {
+			d.LabelNames = append(d.LabelNames, ScrapeDiffLabels{Metric: name, OnlyA: la, OnlyB: lb})
+		}
+		for key, s := range seriesA {
+			other, ok := seriesB[key]
+			if !ok {
+				d.SeriesOnlyA = append(d.SeriesOnlyA, key)
+				continue
+			}
+			d.CommonSeries++
+			if s.Histogram != nil || other.Histogram != nil {
+				continue
+			}
+			if rel := relativeDelta(s.Value, other.Value); rel > threshold {
+				d.ValueChanges = append(d.ValueChanges, ScrapeDiffValue{Series: key, A: s.Value, B: other.Value, Rel: rel})
+			}
+		}
+		for key := range seriesB {
+			if _, ok := seriesA[key]; !ok {
+				d.SeriesOnlyB = append(d.SeriesOnlyB, key)
+			}
+		}
+	}
+	sort.Strings(d.SeriesOnlyA)
+	sort.Strings(d.SeriesOnlyB)
+	sort.Slice(d.ValueChanges, func(i, j int) bool {
+		if d.ValueChanges[i].Rel != d.ValueChanges[j].Rel {
+			return d.ValueChanges[i].Rel > d.ValueChanges[j].Rel
+		}
+		return d.ValueChanges[i].Series < d.ValueChanges[j].Series
+	})
+	return d
+}
+
+// latestSeries indexes samples by series, keeping the latest one, and collects the label names used.
+func latestSeries(samples []sstorage.MetricSample) (map[string]sstorage.MetricSample, map[string]bool) {
+	series := make(map[string]sstorage.MetricSample, len(samples))
+	names := map[string]bool{}
+	for _, s := range samples {
+		key := formatSeries(s.Labels)
+		if prev, ok := series[key]; !ok || s.Timestamp >= prev.Timestamp {
+			series[key] = s
+		}
+		for k := range s.Labels {
+			if k != labels.MetricName {
+				names[k] = true
+			}
+		}
+	}
+	return series, names
+}
+
+// formatSeries formats a label set in PromQL notation, name{label="value", ...}.
+func formatSeries(m map[string]string) string {
+	b := labels.NewBuilder(labels.FromMap(m))
+	b.Del(labels.MetricName)
+	lbls := b.Labels()
+	if lbls.IsEmpty() {
+		return m[labels.MetricName]
+	}
+	return m[labels.MetricName] + lbls.String()
+}
+
+func setDifference(a, b map[string]bool) []string {
+	var out []string
+	for k := range a {
+		if !b[k] {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// relativeDelta returns |b-a| / max(|a|, |b|): 0 when equal, 1 when one side is 0. NaN on
+// exactly one side counts as a full change.
+func relativeDelta(a, b float64) float64 {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b), a == b:
+		return 0
+	case math.IsNaN(a) || math.IsNaN(b) || math.IsInf(a, 0) || math.IsInf(b, 0):
+		return 1
+	}
+	return math.Abs(b-a) / math.Max(math.Abs(a), math.Abs(b))
+}
+
+// Print writes the diff as sections, one line per difference.
+func (d ScrapeDiff) Print(w io.Writer, nameA, nameB string) {
+	mustFprintf(w, "A: %s (%d metrics)\nB: %s (%d metrics)\n", nameA, d.MetricsA, nameB, d.MetricsB)
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		mustFprintf(w, "\n%s (%d):\n", title, len(lines))
+		for _, l := range lines {
+			mustFprintf(w, "  %s\n", l)
+		}
+	}
+	section("Metrics only in A", d.OnlyA)
+	section("Metrics only in B", d.OnlyB)
+	var lbls []string
+	for _, l := range d.LabelNames {
+		var parts []string
+		if len(l.OnlyA) > 0 {
+			parts = append(parts, "-"+strings.Join(l.OnlyA, " -"))
+		}
+		if len(l.OnlyB) > 0 {
+			parts = append(parts, "+"+strings.Join(l.OnlyB, " +"))
+		}
+		lbls = append(lbls, l.Metric+": "+strings.Join(parts, " "))
+	}
+	section("Label name changes", lbls)
+	var counts []string
+	for _, c := range d.SeriesCounts {
+		counts = append(counts, fmt.Sprintf("%s: %d -> %d (%+d)", c.Metric, c.A, c.B, c.B-c.A))
+	}
+	section("Series count changes", counts)
+	section("Series only in A", d.SeriesOnlyA)
+	section("Series only in B", d.SeriesOnlyB)
+	var values []string
+	for _, v := range d.ValueChanges {
+		values = append(values, fmt.Sprintf("%s %s -> %s (%+.1f%%)", v.Series,
+			strconv.FormatFloat(v.A, 'g', -1, 64), strconv.FormatFloat(v.B, 'g', -1, 64), signedPercent(v)))
+	}
+	section("Value changes", values)
+	if len(d.ValueChanges) > 0 {
+		top := d.ValueChanges[0]
+		mustFprintf(w, "\nValue drift: %d of %d common series changed by more than %s%%, up to %+.1f%% (%s)\n",
+			len(d.ValueChanges), d.CommonSeries, strconv.FormatFloat(d.Threshold*100, 'g', -1, 64), signedPercent(top), top.Series)
+	}
+	if len(d.OnlyA)+len(d.OnlyB)+len(d.LabelNames)+len(d.SeriesOnlyA)+len(d.SeriesOnlyB)+len(d.ValueChanges) == 0 {
+		mustFprintf(w, "\nNo differences (%d common series)\n", d.CommonSeries)
+	}
+}
+
+// signedPercent returns the change from A to B relative to A (or ±100% when A is 0 or NaN).
+func signedPercent(v ScrapeDiffValue) float64 {
+	if v.A == 0 || math.IsNaN(v.A) || math.IsNaN(v.B) || math.IsInf(v.A, 0) || math.IsInf(v.B, 0) {
+		if v.B < v.A {
+			return -100
+		}
+		return 100
+	}
+	return (v.B - v.A) / math.Abs(v.A) * 100
+}
+
+// parseThreshold accepts "5%" or a fraction like "0.05".
+func parseThreshold(tok string) (float64, bool) {
+	if p, ok := strings.CutSuffix(tok, "%"); ok {
+		f, err := strconv.ParseFloat(p, 64)
+		return f / 100, err == nil && f >= 0
+	}
+	f, err := strconv.ParseFloat(tok, 64)
+	return f, err == nil && f >= 0
+}
+
+// parseDiffOptions parses the optional [metric_regex] [threshold] of the diff commands.
+func parseDiffOptions(args []string) (*regexp.Regexp, float64, error) {
+	var filter *regexp.Regexp
+	threshold := defaultScrapeDiffThreshold
+	for _, tok := range args {
+		if t, ok := parseThreshold(tok); ok {
+			threshold = t
+			continue
+		}
+		re, err := regexp.Compile(strings.Trim(tok, "\"'"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid metric_regex %q: %w", tok, err)
+		}
+		filter = re
+	}
+	return filter, threshold, nil
+}
+
+// handleAdhocScrapeDiff scrapes two endpoints and prints their differences:
+// .scrape_diff <url1> <url2> [metric_regex] [threshold]
+func handleAdhocScrapeDiff(query string, storage *sstorage.SimpleStorage) bool {
+	args := strings.Fields(strings.TrimSpace(strings.TrimPrefix(query, ".scrape_diff")))
+	if len(args) < 2 || len(args) > 4 {
+		cmd := GetAdHocCommandByName(".scrape_diff")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	filter, threshold, err := parseDiffOptions(args[2:])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGINT)
+	defer signal.Stop(sigChan)
+	go func() {
+		select {
+		case <-sigChan:
+			fmt.Println("\nScrape diff interrupted")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stores := make([]*sstorage.SimpleStorage, 2)
+	errs := make([]error, 2)
+	done := make(chan struct{}, 2)
+	for i, u := range args[:2] {
+		// Each endpoint gets the config scrape profile of its URL
+		client, err := scrapeProfileFor(u).client(60 * time.Second)
+		if err != nil {
+			fmt.Printf("Failed to scrape %s: %v\n", u, err)
+			return true
+		}
+		go func() {
+			stores[i], errs[i] = scrapeTargetOnce(ctx, client, ScrapeTarget{URL: u})
+			done <- struct{}{}
+		}()
+	}
+	<-done
+	<-done
+	for i, err := range errs {
+		if err != nil {
+			fmt.Printf("Failed to scrape %s: %v\n", args[i], err)
+			return true
+		}
+	}
+	DiffScrapes(stores[0], stores[1], filter, threshold).Print(os.Stdout, args[0], args[1])
+	return true
+}
@@ -0,0 +1,62 @@
+package repl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_ScrapeDiff(t *testing.T) {
+	serve := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(body))
+		}))
+	}
+	oldSrv := serve(`# TYPE app_requests_total counter
+app_requests_total{code="200"} 100
+app_requests_total{code="500"} 4
+app_temperature 20
+app_legacy_info 1
+`)
+	defer oldSrv.Close()
+	newSrv := serve(`# TYPE app_requests_total counter
+app_requests_total{code="200",method="GET"} 100
+app_temperature 30
+app_build_info{version="2.0"} 1
+`)
+	defer newSrv.Close()
+
+	out := captureStdout(t, func() {
+		_ = handleAdHocFunction(".scrape_diff "+oldSrv.URL+" "+newSrv.URL+" 'app_.*' 20%", sstorage.NewSimpleStorage())
+	})
+	for _, want := range []string{
+		"Metrics only in A (1):\n  app_legacy_info",
+		"Metrics only in B (1):\n  app_build_info",
+		"app_requests_total: +method",
+		`Series only in A (2):`,
+		`app_requests_total{code="500"}`,
+		`Series only in B (1):`,
+		"Value changes (1):\n  app_temperature 20 -> 30 (+50.0%)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".scrape_diff "+oldSrv.URL+" "+oldSrv.URL, sstorage.NewSimpleStorage())
+	})
+	if !strings.Contains(out, "No differences (4 common series)") {
+		t.Fatalf("expected no differences, got:\n%s", out)
+	}
+
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".scrape_diff "+oldSrv.URL+" "+newSrv.URL+" 'app_(.*'", sstorage.NewSimpleStorage())
+	})
+	if !strings.HasPrefix(out, `Error: invalid metric_regex "'app_(.*'": error parsing regexp`) {
+		t.Fatalf("expected an invalid regex error, got:\n%s", out)
+	}
+}
@@ -0,0 +1,225 @@
+package repl
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	ai "github.com/jjo/promql-cli/pkg/ai"
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// sessionVersion is bumped on incompatible changes to the session file layout.
+const sessionVersion = 1
+
+var sessionNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Session is the on-disk snapshot written by .session save: the in-memory store plus
+// REPL settings. It is gob-encoded so NaN values and native histograms round-trip.
+type Session struct {
+	Version        int
+	SavedAt        time.Time
+	PinnedEvalTime *time.Time
+	RuleSpec       string
+	AIConfig       map[string]string
+	Vars           map[string]string
+	Metrics        map[string][]sstorage.MetricSample
+	MetricsHelp    map[string]string
+	MetricsType    map[string]string
+}
+
+// sessionsDir returns the directory holding saved sessions (~/.promql-cli/sessions).
+func sessionsDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return "", fmt.Errorf("cannot determine home directory: %v", err)
+	}
+	return filepath.Join(home, ".promql-cli", "sessions"), nil
+}
+
+// sessionPath validates name and returns its session file path.
+func sessionPath(name string) (string, error) {
+	if !sessionNameRe.MatchString(name) {
+		return "", fmt.Errorf("invalid session name %q (use letters, digits, '.', '_' or '-')", name)
+	}
+	dir, err := sessionsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".session"), nil
+}
+
+// SaveSession writes the store, pinned evaluation time, active rules, AI settings and REPL
+// variables to the named session.
+func SaveSession(name string, storage *sstorage.SimpleStorage) (string, error) {
+	path, err := sessionPath(name)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	ruleSpec, _ := GetActiveRules()
+	sess := Session{
+		Version:        sessionVersion,
+		SavedAt:        time.Now().UTC(),
+		PinnedEvalTime: pinnedEvalTime,
+		RuleSpec:       ruleSpec,
+		AIConfig:       ai.CurrentAIConfig(),
+		Vars:           replVars,
+		Metrics:        storage.Metrics,
+		MetricsHelp:    storage.MetricsHelp,
+		MetricsType:    storage.MetricsType,
+	}
+	// Write to a temp file first so a failed save never clobbers an existing session.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+name+".*")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if err := gob.NewEncoder(tmp).Encode(&sess); err != nil {
+		_ = tmp.Close()
+		return "", fmt.Errorf("encode session: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// LoadSession replaces the store content and REPL settings with the named session.
+func LoadSession(name string, storage *sstorage.SimpleStorage) (*Session, error) {
+	path, err := sessionPath(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var sess Session
+	if err := gob.NewDecoder(f).Decode(&sess); err != nil {
+		return nil, fmt.Errorf("decode session %s: %w", path, err)
+	}
+	if sess.Version != sessionVersion {
+		return nil, fmt.Errorf("unsupported session version %d", sess.Version)
+	}
+
+	// Resolve rules before touching any state so a broken spec leaves the REPL unchanged.
+	var ruleFiles []string
+	if sess.RuleSpec != "" {
+		if ruleFiles, err = ResolveRuleSpec(sess.RuleSpec); err != nil {
+			return nil, fmt.Errorf("rules %q: %w", sess.RuleSpec, err)
+		}
+	}
+	storage.Metrics = sess.Metrics
+	if storage.Metrics == nil {
+		storage.Metrics = make(map[string][]sstorage.MetricSample)
+	}
+	storage.MetricsHelp = sess.MetricsHelp
+	if storage.MetricsHelp == nil {
+		storage.MetricsHelp = make(map[string]string)
+	}
+	storage.MetricsType = sess.MetricsType
+	if storage.MetricsType == nil {
+		storage.MetricsType = make(map[string]string)
+	}
+	pinnedEvalTime = sess.PinnedEvalTime
+	SetActiveRules(ruleFiles, sess.RuleSpec)
+	setReplVars(sess.Vars)
+	if len(sess.AIConfig) > 0 {
+		ai.ConfigureAIComposite(sess.AIConfig)
+	}
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+	return &sess, nil
+}
+
+// listSessions returns the saved session names, sorted.
+func listSessions() ([]string, error) {
+	dir, err := sessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*.session"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(m), ".session"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// handleAdhocSession handles .session save <name> | .session load <name> | .session list
+func handleAdhocSession(query string, storage *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimSpace(strings.TrimPrefix(query, ".session")))
+	usage := GetAdHocCommandByName(".session").Usage
+	if len(fields) == 0 {
+		fmt.Println(usage)
+		return true
+	}
+	switch fields[0] {
+	case "list":
+		names, err := listSessions()
+		if err != nil {
+			fmt.Printf("Failed to list sessions: %v\n", err)
+			return true
+		}
+		if len(names) == 0 {
+			fmt.Println("No saved sessions")
+			return true
+		}
+		fmt.Printf("Saved sessions (%d):\n", len(names))
+		for _, n := range names {
+			fmt.Printf("  %s\n", n)
+		}
+	case "save", "load":
+		if len(fields) != 2 {
+			fmt.Println(usage)
+			return true
+		}
+		name := strings.Trim(fields[1], "\"'")
+		if fields[0] == "save" {
+			path, err := SaveSession(name, storage)
+			if err != nil {
+				fmt.Printf("Failed to save session %q: %v\n", name, err)
+				return true
+			}
+			metrics, samples := storeTotals(storage)
+			fmt.Printf("Saved session %q to %s (%d metrics, %d samples)\n", name, path, metrics, samples)
+			return true
+		}
+		sess, err := LoadSession(name, storage)
+		if err != nil {
+			fmt.Printf("Failed to load session %q: %v\n", name, err)
+			return true
+		}
+		metrics, samples := storeTotals(storage)
+		fmt.Printf("Loaded session %q saved at %s (%d metrics, %d samples)\n", name, sess.SavedAt.Format(time.RFC3339), metrics, samples)
+		if sess.PinnedEvalTime != nil {
+			fmt.Printf("  pinned evaluation time: %s\n", sess.PinnedEvalTime.UTC().Format(time.RFC3339))
+		}
+		if sess.RuleSpec != "" {
+			fmt.Printf("  active rules: %s\n", sess.RuleSpec)
+		}
+		if p := sess.AIConfig["provider"]; p != "" {
+			fmt.Printf("  AI provider: %s\n", p)
+		}
+		if len(sess.Vars) > 0 {
+			fmt.Printf("  variables: %d (see .vars)\n", len(sess.Vars))
+		}
+	default:
+		fmt.Println(usage)
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Session_SaveLoadList(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	rulesPath := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(rulesPath, []byte("groups:\n- name: g\n  rules:\n  - record: up:sum\n    expr: sum(up)\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	pinned := time.Unix(1700000000, 0).UTC()
+	oldPinned := pinnedEvalTime
+	pinnedEvalTime = &pinned
+	SetActiveRules([]string{rulesPath}, rulesPath)
+	setReplVars(map[string]string{"cluster": "prod"})
+	defer func() {
+		SetActiveRules(nil, "")
+		pinnedEvalTime = oldPinned
+		setReplVars(nil)
+	}()
+
+	store := newTestStore(t)
+	wantMetrics, wantSamples := storeTotals(store)
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".session save incident-1", store) })
+	if !strings.Contains(out, `Saved session "incident-1"`) {
+		t.Fatalf("unexpected save output: %q", out)
+	}
+
+	// Reset state, then restore it from the session.
+	pinnedEvalTime = nil
+	SetActiveRules(nil, "")
+	setReplVars(nil)
+	restored := sstorage.NewSimpleStorage()
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".session load incident-1", restored) })
+	if !strings.Contains(out, `Loaded session "incident-1"`) {
+		t.Fatalf("unexpected load output: %q", out)
+	}
+	if gotMetrics, gotSamples := storeTotals(restored); gotMetrics != wantMetrics || gotSamples != wantSamples {
+		t.Fatalf("restored %d metrics/%d samples, want %d/%d", gotMetrics, gotSamples, wantMetrics, wantSamples)
+	}
+	if pinnedEvalTime == nil || !pinnedEvalTime.Equal(pinned) {
+		t.Fatalf("pinned time not restored: %v", pinnedEvalTime)
+	}
+	if spec, files := GetActiveRules(); spec != rulesPath || len(files) != 1 {
+		t.Fatalf("active rules not restored: %q %v", spec, files)
+	}
+
+	if replVars["cluster"] != "prod" {
+		t.Fatalf("variables not restored: %v", replVars)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".session list", restored) })
+	if !strings.Contains(out, "Saved sessions (1)") || !strings.Contains(out, "incident-1") {
+		t.Fatalf("unexpected list output: %q", out)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".session save ../escape", restored) })
+	if !strings.Contains(out, "invalid session name") {
+		t.Fatalf("expected invalid name error, got %q", out)
+	}
+}
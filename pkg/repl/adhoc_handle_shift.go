@@ -0,0 +1,156 @@
+package repl
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// matchingMetrics returns the metric names matching the regex arg, or prints why not.
+func matchingMetrics(storage *sstorage.SimpleStorage, arg string) ([]string, bool) {
+	re, err := regexp.Compile(strings.Trim(arg, "\"'"))
+	if err != nil {
+		fmt.Printf("Invalid metric_regex %q: %v\n", arg, err)
+		return nil, false
+	}
+	var names []string
+	for name := range storage.Metrics {
+		if re.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		fmt.Printf("No metrics match %q\n", re.String())
+		return nil, false
+	}
+	return names, true
+}
+
+// parseShift parses the offset of .shift: a signed Prometheus duration (e.g. -1h, +1d), or
+// "now" for the offset moving the latest of samples to the current time.
+func parseShift(arg string, latest int64) (time.Duration, error) {
+	if strings.EqualFold(arg, "now") {
+		return time.Since(time.UnixMilli(latest)).Truncate(time.Millisecond), nil
+	}
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(arg, "-"):
+		sign, arg = -1, arg[1:]
+	case strings.HasPrefix(arg, "+"):
+		arg = arg[1:]
+	}
+	d, err := model.ParseDuration(arg)
+	if err != nil {
+		return 0, err
+	}
+	return sign * time.Duration(d), nil
+}
+
+// handleAdhocShift moves the samples of the metrics matching a regex in time:
+// .shift <metric_regex> <[+|-]duration|now>
+func handleAdhocShift(query string, storage *sstorage.SimpleStorage) bool {
+	args := strings.Fields(strings.TrimPrefix(query, ".shift"))
+	if len(args) != 2 {
+		cmd := GetAdHocCommandByName(".shift")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	names, ok := matchingMetrics(storage, args[0])
+	if !ok {
+		return true
+	}
+	latest := int64(math.MinInt64)
+	for _, name := range names {
+		for _, s := range storage.Metrics[name] {
+			latest = max(latest, s.Timestamp)
+		}
+	}
+	d, err := parseShift(args[1], latest)
+	if err != nil {
+		fmt.Printf("Error: invalid shift %q: %v\n", args[1], err)
+		fmt.Println("Usage: " + GetAdHocCommandByName(".shift").Usage)
+		return true
+	}
+	offset := d.Milliseconds()
+	samples := 0
+	for _, name := range names {
+		for i := range storage.Metrics[name] {
+			s := &storage.Metrics[name][i]
+			s.Timestamp += offset
+			if s.Created != 0 {
+				s.Created += offset
+			}
+			if s.Exemplar != nil && s.Exemplar.HasTimestamp {
+				ex := *s.Exemplar
+				ex.Timestamp += offset
+				s.Exemplar = &ex
+			}
+			samples++
+		}
+	}
+	storage.InvalidateIndex()
+	fmt.Printf("Shifted %d samples of %d metrics by %s (latest now %s)\n",
+		samples, len(names), formatShift(d), formatDisplayMillis(latest+offset))
+	return true
+}
+
+// formatShift renders a signed offset as a Prometheus duration, e.g. -1d or +30m.
+func formatShift(d time.Duration) string {
+	if d < 0 {
+		return "-" + model.Duration(-d).String()
+	}
+	return "+" + model.Duration(d).String()
+}
+
+// handleAdhocRescale multiplies the values of the metrics matching a regex by a factor,
+// e.g. to fix units: .rescale <metric_regex> <factor>
+func handleAdhocRescale(query string, storage *sstorage.SimpleStorage) bool {
+	args := strings.Fields(strings.TrimPrefix(query, ".rescale"))
+	if len(args) != 2 {
+		cmd := GetAdHocCommandByName(".rescale")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	factor, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || math.IsNaN(factor) || math.IsInf(factor, 0) {
+		fmt.Printf("Error: invalid factor %q (expected a finite number, e.g. 0.001 or 1e-3)\n", args[1])
+		return true
+	}
+	names, ok := matchingMetrics(storage, args[0])
+	if !ok {
+		return true
+	}
+	// Rescaling the observations of a native histogram would move them across its bucket
+	// boundaries, which are fixed by its schema
+	for _, name := range names {
+		for _, s := range storage.Metrics[name] {
+			if s.Histogram != nil {
+				fmt.Printf("Error: %s holds native histograms, which can't be rescaled; nothing changed\n", name)
+				return true
+			}
+		}
+	}
+	samples := 0
+	for _, name := range names {
+		for i := range storage.Metrics[name] {
+			storage.Metrics[name][i].Value *= factor
+			samples++
+		}
+	}
+	storage.InvalidateIndex()
+	fmt.Printf("Rescaled %d samples of %d metrics by %s\n", samples, len(names), strconv.FormatFloat(factor, 'g', -1, 64))
+	return true
+}
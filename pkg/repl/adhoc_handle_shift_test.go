@@ -0,0 +1,70 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/histogram"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Shift(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	store.AddSample(map[string]string{"__name__": "reqs_total"}, 1, 1700000000000)
+	store.AddSample(map[string]string{"__name__": "reqs_total"}, 2, 1700000060000)
+	store.AddSample(map[string]string{"__name__": "up"}, 1, 1700000000000)
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".shift ^reqs -1h", store) })
+	if !strings.Contains(out, "Shifted 2 samples of 1 metrics by -1h (latest now 2023-11-14T21:14:20Z)") {
+		t.Fatalf("unexpected .shift output: %s", out)
+	}
+	if got := store.Metrics["reqs_total"][0].Timestamp; got != 1700000000000-3600000 {
+		t.Fatalf("unexpected shifted timestamp %d", got)
+	}
+	if got := store.Metrics["up"][0].Timestamp; got != 1700000000000 {
+		t.Fatalf("expected up untouched, got %d", got)
+	}
+
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".shift . now", store) })
+	if d := time.Since(time.UnixMilli(store.Metrics["up"][0].Timestamp)); d < 0 || d > time.Minute {
+		t.Fatalf("expected the latest sample at now, got %v ago", d)
+	}
+
+	for _, bad := range []string{".shift up", ".shift up 1x"} {
+		out = captureStdout(t, func() { _ = handleAdHocFunction(bad, store) })
+		if !strings.Contains(out, "Usage: .shift") {
+			t.Fatalf("%q: expected usage, got: %s", bad, out)
+		}
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".shift nope 1h", store) })
+	if !strings.Contains(out, `No metrics match "nope"`) {
+		t.Fatalf("expected no match, got: %s", out)
+	}
+}
+
+func TestAdhoc_Rescale(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	store.AddSample(map[string]string{"__name__": "latency_milliseconds"}, 250, 1700000000000)
+	store.AddSample(map[string]string{"__name__": "up"}, 1, 1700000000000)
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".rescale _milliseconds$ 0.001", store) })
+	if !strings.Contains(out, "Rescaled 1 samples of 1 metrics by 0.001") {
+		t.Fatalf("unexpected .rescale output: %s", out)
+	}
+	if got := store.Metrics["latency_milliseconds"][0].Value; got != 0.25 {
+		t.Fatalf("expected 0.25, got %v", got)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".rescale up NaN", store) })
+	if !strings.Contains(out, "Error: invalid factor") {
+		t.Fatalf("expected an invalid factor error, got: %s", out)
+	}
+
+	h := &histogram.FloatHistogram{Count: 2, Sum: 300, ZeroThreshold: 0.001, PositiveSpans: []histogram.Span{{Offset: 0, Length: 1}}, PositiveBuckets: []float64{2}}
+	store.AddHistogramSample(map[string]string{"__name__": "rpc_milliseconds"}, h, 1700000000000)
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".rescale _milliseconds$ 0.001", store) })
+	if !strings.Contains(out, "Error: rpc_milliseconds holds native histograms") || store.Metrics["latency_milliseconds"][0].Value != 0.25 {
+		t.Fatalf("expected native histograms rejected and nothing changed, got: %s", out)
+	}
+}
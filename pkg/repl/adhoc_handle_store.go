@@ -0,0 +1,305 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	promstorage "github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// storeLabel is the pseudo-label naming the store of a series. Selectors with a matcher
+// on it read from the stores it matches, and their series carry it.
+const storeLabel = "__store__"
+
+// defaultStoreName is the name of the store the REPL starts with.
+const defaultStoreName = "default"
+
+var (
+	// activeStoreName is the store whose contents the REPL storage holds; parkedStores are
+	// the other ones, set aside by .store new and .store use.
+	activeStoreName = defaultStoreName
+	parkedStores    = map[string]*sstorage.SimpleStorage{}
+)
+
+// storeNameRe are the valid store names.
+var storeNameRe = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// storeNames returns the names of all stores, sorted.
+func storeNames() []string {
+	names := []string{activeStoreName}
+	for name := range parkedStores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// storeByName returns the store called name, given the active one.
+func storeByName(active *sstorage.SimpleStorage, name string) *sstorage.SimpleStorage {
+	if name == activeStoreName {
+		return active
+	}
+	return parkedStores[name]
+}
+
+// swapStoreContents exchanges the metrics of a and b, so that the REPL storage, which
+// commands hold on to, can hold any store.
+func swapStoreContents(a, b *sstorage.SimpleStorage) {
+	a.Metrics, b.Metrics = b.Metrics, a.Metrics
+	a.MetricsHelp, b.MetricsHelp = b.MetricsHelp, a.MetricsHelp
+	a.MetricsType, b.MetricsType = b.MetricsType, a.MetricsType
+	a.InvalidateIndex()
+	b.InvalidateIndex()
+}
+
+// useStore makes name the active store, parking the current one.
+func useStore(storage *sstorage.SimpleStorage, name string) {
+	next := parkedStores[name]
+	delete(parkedStores, name)
+	swapStoreContents(storage, next)
+	parkedStores[activeStoreName] = next
+	activeStoreName = name
+	if refreshMetricsCache != nil {
+		refreshMetricsCache(storage)
+	}
+}
+
+// snapshotStore returns a copy of storage that later changes to it don't affect.
+func snapshotStore(storage *sstorage.SimpleStorage) *sstorage.SimpleStorage {
+	snap := sstorage.NewSimpleStorage()
+	for name, samples := range storage.Metrics {
+		copied := make([]sstorage.MetricSample, len(samples))
+		for i, s := range samples {
+			s.Labels = maps.Clone(s.Labels)
+			if s.Histogram != nil {
+				s.Histogram = s.Histogram.Copy()
+			}
+			copied[i] = s
+		}
+		snap.Metrics[name] = copied
+	}
+	maps.Copy(snap.MetricsHelp, storage.MetricsHelp)
+	maps.Copy(snap.MetricsType, storage.MetricsType)
+	return snap
+}
+
+// workspaceQueryable adds the stores to base, the queryable of the active store: selectors
+// matching on storeLabel read from the stores it matches instead.
+type workspaceQueryable struct {
+	base   promstorage.Queryable
+	active *sstorage.SimpleStorage
+}
+
+func (w workspaceQueryable) Querier(mint, maxt int64) (promstorage.Querier, error) {
+	q, err := w.base.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return &workspaceQuerier{Querier: q, active: w.active, mint: mint, maxt: maxt}, nil
+}
+
+// workspaceQuerier is the querier of workspaceQueryable.
+type workspaceQuerier struct {
+	promstorage.Querier
+	active     *sstorage.SimpleStorage
+	mint, maxt int64
+	opened     []promstorage.Querier // store queriers, closed with it
+}
+
+func (q *workspaceQuerier) Select(ctx context.Context, sortSeries bool, hints *promstorage.SelectHints, matchers ...*labels.Matcher) promstorage.SeriesSet {
+	var storeMatchers, rest []*labels.Matcher
+	for _, m := range matchers {
+		if m.Name == storeLabel {
+			storeMatchers = append(storeMatchers, m)
+		} else {
+			rest = append(rest, m)
+		}
+	}
+	if storeMatchers == nil {
+		return q.Querier.Select(ctx, sortSeries, hints, matchers...)
+	}
+	if len(rest) == 0 {
+		rest = []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")}
+	}
+	var sets []promstorage.SeriesSet
+	for _, name := range storeNames() {
+		if !matchesAll(storeMatchers, name) {
+			continue
+		}
+		sq, err := storeByName(q.active, name).Querier(q.mint, q.maxt)
+		if err != nil {
+			return promstorage.ErrSeriesSet(err)
+		}
+		q.opened = append(q.opened, sq)
+		sets = append(sets, &storeSeriesSet{SeriesSet: sq.Select(ctx, true, hints, rest...), store: name})
+	}
+	return promstorage.NewMergeSeriesSet(sets, 0, promstorage.ChainedSeriesMerge)
+}
+
+func (q *workspaceQuerier) LabelValues(ctx context.Context, name string, hints *promstorage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	if name == storeLabel {
+		return storeNames(), nil, nil
+	}
+	return q.Querier.LabelValues(ctx, name, hints, matchers...)
+}
+
+func (q *workspaceQuerier) Close() error {
+	for _, sq := range q.opened {
+		_ = sq.Close()
+	}
+	return q.Querier.Close()
+}
+
+// matchesAll tells whether value matches all the matchers.
+func matchesAll(matchers []*labels.Matcher, value string) bool {
+	for _, m := range matchers {
+		if !m.Matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// storeSeriesSet adds the storeLabel of its store to the series of a SeriesSet.
+type storeSeriesSet struct {
+	promstorage.SeriesSet
+	store string
+}
+
+func (s *storeSeriesSet) At() promstorage.Series {
+	series := s.SeriesSet.At()
+	b := labels.NewBuilder(series.Labels())
+	b.Set(storeLabel, s.store)
+	return storeSeries{Series: series, lbls: b.Labels()}
+}
+
+// storeSeries is a series with the storeLabel of its store.
+type storeSeries struct {
+	promstorage.Series
+	lbls labels.Labels
+}
+
+func (s storeSeries) Labels() labels.Labels { return s.lbls }
+
+// handleAdhocStore manages named stores:
+// .store [list|new <name>|use <name>|drop <name>|snapshot <name>|diff <a> [<b>] [metric_regex] [threshold]]
+func handleAdhocStore(query string, storage *sstorage.SimpleStorage) bool {
+	fields := strings.Fields(strings.TrimPrefix(query, ".store"))
+	usage := func() {
+		cmd := GetAdHocCommandByName(".store")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+	}
+	if len(fields) >= 2 && fields[0] == "diff" {
+		diffStores(storage, fields[1:])
+		return true
+	}
+	if len(fields) == 0 || (len(fields) == 1 && fields[0] == "list") {
+		names := storeNames()
+		fmt.Printf("Stores (%d):\n", len(names))
+		for _, name := range names {
+			mark := " "
+			if name == activeStoreName {
+				mark = "*"
+			}
+			metrics, samples := storeTotals(storeByName(storage, name))
+			fmt.Printf("%s %s: %d metrics, %d samples\n", mark, name, metrics, samples)
+		}
+		if len(names) > 1 {
+			fmt.Printf("Query across stores with the %s label, e.g. sum by (%s) (up{%s=~\".+\"})\n", storeLabel, storeLabel, storeLabel)
+		}
+		return true
+	}
+	if len(fields) != 2 {
+		usage()
+		return true
+	}
+	name := fields[1]
+	exists := storeByName(storage, name) != nil
+	switch fields[0] {
+	case "new":
+		if !storeNameRe.MatchString(name) {
+			fmt.Printf("Error: invalid store name %q (letters, digits, '_', '.' and '-')\n", name)
+			return true
+		}
+		if exists {
+			fmt.Printf("Error: store %s already exists; switch to it with .store use %s\n", name, name)
+			return true
+		}
+		prev := activeStoreName
+		parkedStores[name] = sstorage.NewSimpleStorage()
+		useStore(storage, name)
+		fmt.Printf("Created store %s and switched to it (back with .store use %s)\n", name, prev)
+	case "use":
+		if !exists {
+			fmt.Printf("Error: no store %s (create it with .store new %s)\n", name, name)
+			return true
+		}
+		if name != activeStoreName {
+			useStore(storage, name)
+		}
+		metrics, samples := storeTotals(storage)
+		fmt.Printf("Using store %s: %d metrics, %d samples\n", name, metrics, samples)
+	case "drop":
+		if !exists {
+			fmt.Printf("Error: no store %s\n", name)
+			return true
+		}
+		if name == activeStoreName {
+			fmt.Printf("Error: store %s is in use; switch to another one first\n", name)
+			return true
+		}
+		delete(parkedStores, name)
+		fmt.Printf("Dropped store %s\n", name)
+	case "snapshot":
+		if !storeNameRe.MatchString(name) {
+			fmt.Printf("Error: invalid store name %q (letters, digits, '_', '.' and '-')\n", name)
+			return true
+		}
+		if exists {
+			fmt.Printf("Error: store %s already exists; drop it first to take a new snapshot\n", name)
+			return true
+		}
+		parkedStores[name] = snapshotStore(storage)
+		metrics, samples := storeTotals(parkedStores[name])
+		fmt.Printf("Saved store %s as snapshot %s: %d metrics, %d samples (compare with .store diff %s %s)\n", activeStoreName, name, metrics, samples, name, activeStoreName)
+	default:
+		usage()
+	}
+	return true
+}
+
+// diffStores prints what changed from store a to store b, the active one when args don't
+// name it, as .scrape_diff does for two scrapes.
+func diffStores(storage *sstorage.SimpleStorage, args []string) {
+	nameA, nameB := args[0], activeStoreName
+	args = args[1:]
+	if len(args) > 0 && storeByName(storage, args[0]) != nil {
+		nameB, args = args[0], args[1:]
+	}
+	a, b := storeByName(storage, nameA), storeByName(storage, nameB)
+	if a == nil {
+		fmt.Printf("Error: no store %s\n", nameA)
+		return
+	}
+	filter, threshold, err := parseDiffOptions(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	DiffScrapes(a, b, filter, threshold).Print(os.Stdout, nameA, nameB)
+	_, samplesA := storeTotals(a)
+	_, samplesB := storeTotals(b)
+	fmt.Printf("\nSamples: %d -> %d (%+d)\n", samplesA, samplesB, samplesB-samplesA)
+}
@@ -0,0 +1,98 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Store(t *testing.T) {
+	defer func() { activeStoreName, parkedStores = defaultStoreName, map[string]*sstorage.SimpleStorage{} }()
+	store := newTestStore(t)
+	engine := newTestEngine()
+	now := time.Now().UnixMilli()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".store new staging", store) })
+	if !strings.Contains(out, "Created store staging") || len(store.Metrics) != 0 {
+		t.Fatalf("expected an empty store in use, got %d metrics: %s", len(store.Metrics), out)
+	}
+	store.AddSample(map[string]string{"__name__": "http_requests_total", "method": "get", "code": "200"}, 2000, now)
+
+	out = captureStdout(t, func() { executeOne(engine, store, "http_requests_total") })
+	if !strings.Contains(out, "2000") || strings.Contains(out, "1027") || strings.Contains(out, storeLabel) {
+		t.Fatalf("expected only the active store queried, got: %s", out)
+	}
+	out = captureStdout(t, func() {
+		executeOne(engine, store, `http_requests_total{code="200",__store__="staging"} - ignoring(__store__) http_requests_total{code="200",__store__="default"}`)
+	})
+	if !strings.Contains(out, "973") {
+		t.Fatalf("expected the difference across stores, got: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(engine, store, `count by (__store__) ({__store__=~".+"})`) })
+	if !strings.Contains(out, `{__store__="default"} => 3`) || !strings.Contains(out, `{__store__="staging"} => 1`) {
+		t.Fatalf("expected the series of both stores, got: %s", out)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".store", store) })
+	if !strings.Contains(out, "Stores (2):") || !strings.Contains(out, "  default: 2 metrics, 3 samples") || !strings.Contains(out, "* staging: 1 metrics, 1 samples") {
+		t.Fatalf("unexpected list: %s", out)
+	}
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".store drop staging", store)
+		_ = handleAdHocFunction(".store use default", store)
+		_ = handleAdHocFunction(".store new default", store)
+		_ = handleAdHocFunction(".store drop staging", store)
+	})
+	for _, want := range []string{"store staging is in use", "Using store default: 2 metrics, 3 samples", "store default already exists", "Dropped store staging"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q, got: %s", want, out)
+		}
+	}
+	if _, ok := store.Metrics["temperature"]; !ok || len(storeNames()) != 1 {
+		t.Fatalf("expected the default store back alone")
+	}
+}
+
+func TestAdhoc_StoreSnapshotDiff(t *testing.T) {
+	defer func() { activeStoreName, parkedStores = defaultStoreName, map[string]*sstorage.SimpleStorage{} }()
+	store := newTestStore(t)
+	now := time.Now().UnixMilli()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".store snapshot before", store) })
+	if !strings.Contains(out, "Saved store default as snapshot before: 2 metrics, 3 samples") || activeStoreName != defaultStoreName {
+		t.Fatalf("unexpected snapshot output: %s", out)
+	}
+	for i := range store.Metrics["temperature"] {
+		store.Metrics["temperature"][i].Value = 40
+	}
+	store.AddSample(map[string]string{"__name__": "http_requests_total", "method": "post", "code": "200"}, 5, now)
+	store.AddSample(map[string]string{"__name__": "up", "job": "api"}, 1, now)
+	if v := parkedStores["before"].Metrics["temperature"][0].Value; v != 27.3 {
+		t.Fatalf("expected the snapshot unchanged, got %v", v)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".store diff before", store) })
+	for _, want := range []string{
+		"A: before (2 metrics)", "B: default (3 metrics)",
+		"Metrics only in B (1):\n  up",
+		"http_requests_total: 2 -> 3 (+1)",
+		"Value drift: 1 of 3 common series changed by more than 10%",
+		"Samples: 3 -> 5 (+2)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q, got: %s", want, out)
+		}
+	}
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".store diff before before", store)
+		_ = handleAdHocFunction(".store diff nope", store)
+		_ = handleAdHocFunction(".store snapshot before", store)
+	})
+	for _, want := range []string{"No differences (3 common series)", "Error: no store nope", "store before already exists"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q, got: %s", want, out)
+		}
+	}
+}
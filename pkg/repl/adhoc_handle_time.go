@@ -2,9 +2,12 @@ package repl
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/prometheus/common/model"
+
 	sstorage "github.com/jjo/promql-cli/pkg/storage"
 )
 
@@ -15,7 +18,7 @@ func handleAdhocPinAt(query string, _ *sstorage.SimpleStorage) bool {
 		if pinnedEvalTime == nil {
 			fmt.Println("Pinned evaluation time: none")
 		} else {
-			fmt.Printf("Pinned evaluation time: %s\n", pinnedEvalTime.UTC().Format(time.RFC3339))
+			fmt.Printf("Pinned evaluation time: %s\n", pinnedEvalTime.In(displayIn(time.UTC)).Format(time.RFC3339))
 		}
 		return true
 	}
@@ -36,6 +39,147 @@ func handleAdhocPinAt(query string, _ *sstorage.SimpleStorage) bool {
 		}
 	}
 	pinnedEvalTime = &t
-	fmt.Printf("Pinned evaluation time: %s\n", t.UTC().Format(time.RFC3339))
+	fmt.Printf("Pinned evaluation time: %s\n", t.In(displayIn(time.UTC)).Format(time.RFC3339))
+	return true
+}
+
+var (
+	// displayLocation is the time zone of displayed timestamps (.tz, --tz). Until set,
+	// results in the text format are in local time and other timestamps in UTC.
+	displayLocation *time.Location
+	// displayTimeFormat is how timestamps are displayed: rfc3339, unix (seconds) or
+	// relative to now (.time_format, --time-format).
+	displayTimeFormat = "rfc3339"
+)
+
+// SetTimeZone sets the time zone of displayed timestamps: UTC, local, an IANA location
+// such as Europe/Madrid, or a fixed offset such as +05:30.
+func SetTimeZone(name string) error {
+	loc, err := parseLocation(name)
+	if err != nil {
+		return err
+	}
+	displayLocation = loc
+	return nil
+}
+
+// SetTimeFormat sets how timestamps are displayed: rfc3339, unix or relative.
+func SetTimeFormat(format string) error {
+	switch f := strings.ToLower(strings.TrimSpace(format)); f {
+	case "rfc3339", "unix", "relative":
+		displayTimeFormat = f
+		return nil
+	default:
+		return fmt.Errorf("invalid time format %q (expected rfc3339|unix|relative)", format)
+	}
+}
+
+// parseLocation parses UTC, local, an offset such as +05:30 or an IANA location name.
+func parseLocation(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	switch strings.ToLower(name) {
+	case "utc", "z":
+		return time.UTC, nil
+	case "local":
+		return time.Local, nil
+	}
+	if strings.HasPrefix(name, "+") || strings.HasPrefix(name, "-") {
+		t, err := time.Parse("-07:00", name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone offset %q (expected e.g. +05:30)", name)
+		}
+		_, offset := t.Zone()
+		return time.FixedZone(name, offset), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q (expected UTC, local, a location such as Europe/Madrid or an offset such as +05:30)", name)
+	}
+	return loc, nil
+}
+
+// displayIn returns the display time zone, or def when none is set.
+func displayIn(def *time.Location) *time.Location {
+	if displayLocation != nil {
+		return displayLocation
+	}
+	return def
+}
+
+// formatDisplayTime renders t in the display time zone and format.
+func formatDisplayTime(t time.Time) string {
+	return formatTimeIn(t, displayIn(time.UTC))
+}
+
+// formatDisplayMillis is formatDisplayTime for a Unix timestamp in milliseconds.
+func formatDisplayMillis(ms int64) string {
+	return formatDisplayTime(time.UnixMilli(ms))
+}
+
+// formatResultMillis renders the timestamp of a result in the text format, in local time
+// unless a display time zone is set.
+func formatResultMillis(ms int64) string {
+	return formatTimeIn(time.UnixMilli(ms), displayIn(time.Local))
+}
+
+// rfc3339Millis renders a Unix timestamp in milliseconds as RFC3339 in UTC, for output
+// read by other tools.
+func rfc3339Millis(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
+
+// formatTimeIn renders t in loc and the display format.
+func formatTimeIn(t time.Time, loc *time.Location) string {
+	switch displayTimeFormat {
+	case "unix":
+		return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', -1, 64)
+	case "relative":
+		d := time.Since(t).Round(time.Second)
+		switch {
+		case d == 0:
+			return "now"
+		case d > 0:
+			return model.Duration(d).String() + " ago"
+		default:
+			return "in " + model.Duration(-d).String()
+		}
+	}
+	return t.In(loc).Format(time.RFC3339)
+}
+
+// handleAdhocTZ shows or sets the time zone of displayed timestamps: .tz [<location>|local|UTC]
+func handleAdhocTZ(query string, _ *sstorage.SimpleStorage) bool {
+	arg := strings.Trim(strings.TrimSpace(strings.TrimPrefix(query, ".tz")), "\"'")
+	if arg != "" {
+		if err := SetTimeZone(arg); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			cmd := GetAdHocCommandByName(".tz")
+			fmt.Println("Usage: " + cmd.Usage)
+			for _, ex := range cmd.Examples {
+				fmt.Println("Example: " + ex)
+			}
+			return true
+		}
+	}
+	if displayLocation == nil {
+		fmt.Printf("Time zone: default (text results in local time, now %s; other timestamps in UTC)\n", time.Now().Format(time.RFC3339))
+		return true
+	}
+	now := time.Now().In(displayLocation)
+	fmt.Printf("Time zone: %s (now %s)\n", displayLocation, now.Format(time.RFC3339))
+	return true
+}
+
+// handleAdhocTimeFormat shows or sets how timestamps are displayed: .time_format [rfc3339|unix|relative]
+func handleAdhocTimeFormat(query string, _ *sstorage.SimpleStorage) bool {
+	arg := strings.TrimSpace(strings.TrimPrefix(query, ".time_format"))
+	if arg != "" {
+		if err := SetTimeFormat(arg); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			fmt.Println("Usage: " + GetAdHocCommandByName(".time_format").Usage)
+			return true
+		}
+	}
+	fmt.Printf("Time format: %s (e.g. %s)\n", displayTimeFormat, formatDisplayTime(time.Now()))
 	return true
 }
@@ -0,0 +1,94 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+)
+
+func TestDisplayTimeZoneAndFormat(t *testing.T) {
+	defer func() { displayLocation, displayTimeFormat = nil, "rfc3339" }()
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	if got := formatDisplayTime(ts); got != "2024-01-02T03:04:05Z" {
+		t.Errorf("default: got %q", got)
+	}
+	if err := SetTimeZone("+05:30"); err != nil {
+		t.Fatal(err)
+	}
+	if got := formatDisplayTime(ts); got != "2024-01-02T08:34:05+05:30" {
+		t.Errorf("+05:30: got %q", got)
+	}
+	if err := SetTimeZone("Mars/Olympus"); err == nil {
+		t.Error("expected an error for an unknown time zone")
+	}
+	if err := SetTimeFormat("unix"); err != nil {
+		t.Fatal(err)
+	}
+	if got := formatDisplayMillis(ts.UnixMilli() + 500); got != "1704164645.5" {
+		t.Errorf("unix: got %q", got)
+	}
+	if err := SetTimeFormat("relative"); err != nil {
+		t.Fatal(err)
+	}
+	if got := formatDisplayTime(time.Now().Add(-5 * time.Minute)); got != "5m ago" {
+		t.Errorf("relative: got %q", got)
+	}
+	if err := SetTimeFormat("iso"); err == nil {
+		t.Error("expected an error for an unknown time format")
+	}
+}
+
+func TestAdhoc_TZ(t *testing.T) {
+	defer func() { displayLocation, displayTimeFormat = nil, "rfc3339" }()
+	out := captureStdout(t, func() { handleAdHocFunction(".tz +02:00", nil) })
+	if !strings.Contains(out, "Time zone: +02:00") {
+		t.Errorf("unexpected .tz output: %q", out)
+	}
+	out = captureStdout(t, func() { handleAdHocFunction(".tz nowhere", nil) })
+	if !strings.Contains(out, "Error: unknown time zone") || !strings.Contains(out, "Usage: .tz") {
+		t.Errorf("unexpected .tz error output: %q", out)
+	}
+	out = captureStdout(t, func() { handleAdHocFunction(".time_format unix", nil) })
+	if !strings.Contains(out, "Time format: unix") {
+		t.Errorf("unexpected .time_format output: %q", out)
+	}
+}
+
+func TestResultTimestamps_TextLocalAndDelimitedUTC(t *testing.T) {
+	prevLocal := time.Local
+	defer func() { time.Local, displayLocation, displayTimeFormat = prevLocal, nil, "rfc3339" }()
+	time.Local = time.FixedZone("TEST", 2*3600)
+	result := &promql.Result{Value: promql.Scalar{T: 1704164645000, V: 1}}
+
+	render := func(format string) string {
+		var b strings.Builder
+		if err := SetOutputFormat(format); err != nil {
+			t.Fatal(err)
+		}
+		if err := formatters[format].Format(&b, result); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+	defer func() { _ = SetOutputFormat("text") }()
+	if got := render("text"); !strings.Contains(got, "2024-01-02T05:04:05+02:00") {
+		t.Errorf("text should default to local time, got %q", got)
+	}
+	if err := SetTimeZone("+05:30"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTimeFormat("unix"); err != nil {
+		t.Fatal(err)
+	}
+	if got := render("table"); !strings.Contains(got, "1704164645") {
+		t.Errorf("table should follow .time_format, got %q", got)
+	}
+	for _, format := range []string{"csv", "tsv", "markdown"} {
+		if got := render(format); !strings.Contains(got, "2024-01-02T03:04:05Z") {
+			t.Errorf("%s should be RFC3339 UTC, got %q", format, got)
+		}
+	}
+}
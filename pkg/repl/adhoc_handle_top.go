@@ -0,0 +1,320 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"golang.org/x/term"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// topTrendPoints is the number of refreshes shown by the .top trend column.
+const topTrendPoints = 20
+
+// sparkBlocks draw sparklines, lowest to highest.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// TopOptions controls RunTop.
+type TopOptions struct {
+	K        int // number of series shown
+	Interval time.Duration
+	// Count stops after that many refreshes (0 runs until ctx is canceled).
+	Count int
+}
+
+// topRow is a series of a .top refresh.
+type topRow struct {
+	Series string
+	Value  float64
+	Delta  float64
+	HasOld bool // Delta is set: the series was in the previous refresh
+}
+
+// sparkline draws vs as a line of blocks scaled between their finite minimum and maximum;
+// other values are drawn as spaces.
+func sparkline(vs []float64) string {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range vs {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	var b strings.Builder
+	for _, v := range vs {
+		switch {
+		case math.IsNaN(v) || math.IsInf(v, 0):
+			b.WriteRune(' ')
+		case hi > lo:
+			b.WriteRune(sparkBlocks[int((v-lo)/(hi-lo)*float64(len(sparkBlocks)-1))])
+		default:
+			b.WriteRune(sparkBlocks[0])
+		}
+	}
+	return b.String()
+}
+
+// topRows returns the k highest values of cur, with their change since prev.
+func topRows(cur, prev map[string]float64, k int) []topRow {
+	rows := make([]topRow, 0, len(cur))
+	for series, v := range cur {
+		old, ok := prev[series]
+		rows = append(rows, topRow{Series: series, Value: v, Delta: v - old, HasOld: ok})
+	}
+	// Highest first, NaNs last
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i].Value, rows[j].Value
+		if math.IsNaN(a) != math.IsNaN(b) {
+			return !math.IsNaN(a)
+		}
+		if a != b && !math.IsNaN(a) {
+			return a > b
+		}
+		return rows[i].Series < rows[j].Series
+	})
+	if len(rows) > k {
+		rows = rows[:k]
+	}
+	return rows
+}
+
+// writeTopTable prints rows like top(1): rank, value, change since the previous refresh
+// (colored when color is set), trend over the last refreshes and series.
+func writeTopTable(w io.Writer, rows []topRow, history map[string][]float64, color bool) {
+	values, deltas := make([]string, len(rows)), make([]string, len(rows))
+	valueWidth, deltaWidth := len("VALUE"), len("DELTA")
+	for i, r := range rows {
+		values[i] = strconv.FormatFloat(r.Value, 'g', 6, 64)
+		deltas[i] = "-"
+		if r.HasOld {
+			deltas[i] = strconv.FormatFloat(r.Delta, 'g', 4, 64)
+			if r.Delta > 0 {
+				deltas[i] = "+" + deltas[i]
+			}
+		}
+		valueWidth, deltaWidth = max(valueWidth, len(values[i])), max(deltaWidth, len(deltas[i]))
+	}
+	rankWidth := len(strconv.Itoa(len(rows)))
+	mustFprintf(w, "%*s  %*s  %-*s  %-*s  %s\n", rankWidth, "#", valueWidth, "VALUE", deltaWidth, "DELTA", topTrendPoints, "TREND", "SERIES")
+	for i, r := range rows {
+		delta := fmt.Sprintf("%-*s", deltaWidth, deltas[i])
+		if color && r.HasOld && r.Delta > 0 {
+			delta = watchColorUp + delta + watchColorReset
+		} else if color && r.HasOld && r.Delta < 0 {
+			delta = watchColorDown + delta + watchColorReset
+		}
+		trend := sparkline(history[r.Series])
+		mustFprintf(w, "%*d  %*s  %s  %s%s  %s\n", rankWidth, i+1, valueWidth, values[i], delta,
+			trend, strings.Repeat(" ", topTrendPoints-len(history[r.Series])), r.Series)
+	}
+}
+
+// RunTop evaluates expr as an instant query at the current time every opts.Interval and
+// writes its opts.K highest series to w as a table sorted by value, with the change since
+// the previous refresh and the trend of the last refreshes. It returns when ctx is canceled
+// or after opts.Count refreshes. The REPL store is released between refreshes, so that
+// .scrape_bg merges show up.
+func RunTop(ctx context.Context, engine *promql.Engine, storage *sstorage.SimpleStorage, expr string, opts TopOptions, w io.Writer) error {
+	if opts.Interval <= 0 {
+		return fmt.Errorf("top interval must be a positive duration")
+	}
+	if opts.K <= 0 {
+		return fmt.Errorf("top k must be positive")
+	}
+	var prev map[string]float64
+	history := map[string][]float64{}
+	for i := 0; opts.Count <= 0 || i < opts.Count; i++ {
+		if i > 0 && !waitReleasingStore(ctx, opts.Interval) {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		now := time.Now()
+		var frame bytes.Buffer
+		mustFprintf(&frame, "%sTop %d every %s: %s    %s\n\n", watchClearScreen, opts.K, opts.Interval, expr, now.UTC().Format(time.RFC3339))
+
+		qctx, cancel := context.WithTimeout(ctx, replTimeout)
+		q, err := engine.NewInstantQuery(qctx, QueryableFor(storage), nil, expr, now)
+		if err != nil {
+			cancel()
+			return fmt.Errorf("creating query: %w", err)
+		}
+		res := q.Exec(qctx)
+		cancel()
+		if res.Err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			mustFprintf(&frame, "Error: %v\n", res.Err)
+			_, _ = w.Write(frame.Bytes())
+			continue
+		}
+		cur, ok := watchValues(res)
+		if !ok {
+			return fmt.Errorf("expected a vector or scalar result, got %s", res.Value.Type())
+		}
+		// Keep the trend of the series still there
+		for series := range history {
+			if _, ok := cur[series]; !ok {
+				delete(history, series)
+			}
+		}
+		for series, v := range cur {
+			h := append(history[series], v)
+			history[series] = h[max(0, len(h)-topTrendPoints):]
+		}
+		rows := topRows(cur, prev, opts.K)
+		if len(rows) == 0 {
+			mustFprintln(&frame, "No results found")
+		} else {
+			writeTopTable(&frame, rows, history, colorEnabled())
+			mustFprintf(&frame, "\n%d of %d series\n", len(rows), len(cur))
+		}
+		_, _ = w.Write(frame.Bytes())
+		prev = cur
+	}
+	return nil
+}
+
+// watchKeyPress returns a channel closed at the first key pressed, and a function to stop
+// watching. Keys are taken from readline's input gate when it is running, or polled from
+// the terminal on stdin otherwise.
+func watchKeyPress() (<-chan struct{}, func()) {
+	pressed := make(chan struct{})
+	var once sync.Once
+	press := func() { once.Do(func() { close(pressed) }) }
+	if gate := rlInputGate; gate != nil {
+		gate.Intercept(press)
+		return pressed, func() { gate.Intercept(nil) }
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				if pendingInput(int(os.Stdin.Fd())) {
+					press()
+					return
+				}
+			}
+		}
+	})
+	return pressed, func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// crlfWriter turns line feeds into CRLF, for output to a terminal in raw mode.
+type crlfWriter struct{ w io.Writer }
+
+func (c crlfWriter) Write(p []byte) (int, error) {
+	if _, err := c.w.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// parseTopArgs splits ".top" arguments into the optional k and interval, and the query.
+func parseTopArgs(args string) (expr string, k int, interval time.Duration, err error) {
+	k, interval = 10, 2*time.Second
+	expr = strings.TrimSpace(args)
+	if first, rest, _ := strings.Cut(expr, " "); first != "" {
+		if n, convErr := strconv.Atoi(first); convErr == nil {
+			if n <= 0 {
+				return "", 0, 0, fmt.Errorf("k must be positive, got %d", n)
+			}
+			k, expr = n, strings.TrimSpace(rest)
+		}
+	}
+	if first, rest, _ := strings.Cut(expr, " "); first != "" {
+		if d, durErr := model.ParseDuration(first); durErr == nil {
+			if d <= 0 {
+				return "", 0, 0, fmt.Errorf("interval must be positive")
+			}
+			interval, expr = time.Duration(d), strings.TrimSpace(rest)
+		}
+	}
+	return expr, k, interval, nil
+}
+
+// handleAdhocTop shows a live top-k view of a query: .top [k] [interval] <query>
+func handleAdhocTop(query string, storage *sstorage.SimpleStorage) bool {
+	expr, k, interval, err := parseTopArgs(strings.TrimPrefix(query, ".top"))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+	if err != nil || expr == "" {
+		cmd := GetAdHocCommandByName(".top")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+		expr = alertExpr
+	}
+	expr = normalizeAtModifierTimestamps(expr)
+
+	// Stop on Ctrl-C, as .watch does, or on any key when stdin is a terminal
+	ctx, cancel := context.WithCancel(context.Background())
+	watchCancel = cancel
+	defer func() {
+		watchCancel = nil
+		cancel()
+	}()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGINT)
+	defer signal.Stop(sigChan)
+	var w io.Writer = os.Stdout
+	if st, err := term.MakeRaw(int(os.Stdin.Fd())); err == nil {
+		defer restoreTerminalState(st)
+		w = crlfWriter{os.Stdout}
+		pressed, stop := watchKeyPress()
+		defer stop()
+		go func() {
+			select {
+			case <-pressed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err = RunTop(ctx, replEngine, storage, expr, TopOptions{K: k, Interval: interval}, w)
+	if err != nil {
+		mustFprintf(w, "Error: %v\n", err)
+	}
+	return true
+}
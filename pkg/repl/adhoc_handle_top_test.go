@@ -0,0 +1,87 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestRunTop_SortsAndTrends(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	now := time.Now().UnixMilli()
+	for i, v := range []float64{3, 9, 1} {
+		store.AddSample(map[string]string{"__name__": "cpu", "pod": string(rune('a' + i))}, v, now)
+	}
+
+	var buf bytes.Buffer
+	opts := TopOptions{K: 2, Interval: time.Millisecond, Count: 2}
+	if err := RunTop(context.Background(), newTestEngine(), store, "cpu", opts, &buf); err != nil {
+		t.Fatalf("RunTop: %v", err)
+	}
+	frames := strings.Split(buf.String(), watchClearScreen)
+	if len(frames) != 3 {
+		t.Fatalf("expected 2 refreshes, got %q", buf.String())
+	}
+	first, second := frames[1], frames[2]
+	b, a := strings.Index(first, `pod="b"`), strings.Index(first, `pod="a"`)
+	if !strings.HasPrefix(first, "Top 2 every 1ms: cpu") || b < 0 || a < b || strings.Contains(first, `pod="c"`) ||
+		!strings.Contains(first, "2 of 3 series") {
+		t.Fatalf("expected the 2 highest series, highest first: %q", first)
+	}
+	if !strings.Contains(second, "1      9  0      ▁▁") {
+		t.Fatalf("expected delta and trend columns on the second refresh: %q", second)
+	}
+
+	if got := sparkline([]float64{0, 7, 3.5, 14}); got != "▁▄▂█" {
+		t.Fatalf("unexpected sparkline %q", got)
+	}
+	rows := topRows(map[string]float64{"x": 2, "y": 5}, map[string]float64{"x": 4}, 10)
+	if rows[0].Series != "y" || rows[0].HasOld || rows[1].Delta != -2 {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+
+	for args, want := range map[string]string{
+		"5 10s up":      "5 10s up",
+		"up":            "10 2s up",
+		"3 rate(x[5m])": "3 2s rate(x[5m])",
+	} {
+		expr, k, interval, err := parseTopArgs(args)
+		if got := strings.Join([]string{strconv.Itoa(k), interval.String(), expr}, " "); err != nil || got != want {
+			t.Errorf("%q: expected %q, got %q (%v)", args, want, got, err)
+		}
+	}
+	if _, _, _, err := parseTopArgs("0 up"); err == nil {
+		t.Fatalf("expected an error for k=0")
+	}
+}
+
+func TestRunTop_ReleasesStoreBetweenRefreshes(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	now := time.Now().UnixMilli()
+	store.AddSample(map[string]string{"__name__": "cpu", "pod": "a"}, 1, now)
+
+	// As the REPL runs .top, holding the store; a background scrape merges meanwhile.
+	var buf bytes.Buffer
+	done := make(chan error)
+	lockStore()
+	go func() {
+		defer unlockStore()
+		done <- RunTop(context.Background(), newTestEngine(), store, "cpu", TopOptions{K: 5, Interval: 200 * time.Millisecond, Count: 2}, &buf)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	storeMu.Lock()
+	store.AddSample(map[string]string{"__name__": "cpu", "pod": "b"}, 2, now)
+	storeMu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("RunTop: %v", err)
+	}
+	frames := strings.Split(buf.String(), watchClearScreen)
+	if len(frames) != 3 || !strings.Contains(frames[2], `pod="b"`) {
+		t.Fatalf("expected the scraped series on the second refresh: %q", buf.String())
+	}
+}
@@ -0,0 +1,52 @@
+package repl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// persistentStore, when set via --storage tsdb, is an on-disk TSDB that is queried
+// alongside the in-memory store and receives its samples on .persist and exit.
+var persistentStore *sstorage.TSDBBackend
+
+// SetPersistentStore sets (or clears, with nil) the on-disk TSDB backend.
+func SetPersistentStore(b *sstorage.TSDBBackend) {
+	persistentStore = b
+}
+
+// PersistStore writes the in-memory samples to the on-disk TSDB, if configured.
+func PersistStore(storage *sstorage.SimpleStorage) (int, error) {
+	if persistentStore == nil {
+		return 0, nil
+	}
+	return persistentStore.Persist(storage)
+}
+
+// handleAdhocPersist handles .persist: flush in-memory samples to the on-disk TSDB and show its status.
+func handleAdhocPersist(query string, storage *sstorage.SimpleStorage) bool {
+	if strings.TrimSpace(strings.TrimPrefix(query, ".persist")) != "" {
+		fmt.Println("Usage: " + GetAdHocCommandByName(".persist").Usage)
+		return true
+	}
+	if persistentStore == nil {
+		fmt.Println("No persistent storage configured (start with --storage tsdb --data-dir <dir>)")
+		return true
+	}
+	n, err := PersistStore(storage)
+	if err != nil {
+		fmt.Printf("Error persisting samples: %v\n", err)
+		return true
+	}
+	series, blocks, mint, maxt := persistentStore.Stats()
+	fmt.Printf("Persisted %d new samples to %s\n", n, persistentStore.Dir)
+	if mint > maxt {
+		fmt.Println("TSDB: empty")
+		return true
+	}
+	fmt.Printf("TSDB: %d head series, %d blocks, %s .. %s\n", series, blocks,
+		time.UnixMilli(mint).UTC().Format(time.RFC3339), time.UnixMilli(maxt).UTC().Format(time.RFC3339))
+	return true
+}
@@ -0,0 +1,98 @@
+package repl
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// labelValueCounts counts, for each value of label across the metrics matching filter (all
+// when nil), the distinct series and metrics carrying it.
+func labelValueCounts(storage *sstorage.SimpleStorage, label string, filter *regexp.Regexp) (series, metrics map[string]int) {
+	series, metrics = map[string]int{}, map[string]int{}
+	for name, samples := range storage.Metrics {
+		if filter != nil && !filter.MatchString(name) {
+			continue
+		}
+		seen := map[string]bool{}
+		inMetric := map[string]bool{}
+		for _, s := range samples {
+			v, ok := s.Labels[label]
+			if !ok || v == "" {
+				continue
+			}
+			key := formatSeries(s.Labels)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			series[v]++
+			if !inMetric[v] {
+				inMetric[v] = true
+				metrics[v]++
+			}
+		}
+	}
+	return series, metrics
+}
+
+// handleAdhocValues lists the distinct values of a label with their series and metric
+// counts, most used first: .values <label> [metric_regex]
+func handleAdhocValues(query string, storage *sstorage.SimpleStorage) bool {
+	args := strings.Fields(strings.TrimSpace(strings.TrimPrefix(query, ".values")))
+	if len(args) == 0 || len(args) > 2 {
+		cmd := GetAdHocCommandByName(".values")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	label := strings.Trim(args[0], "\"'")
+	var filter *regexp.Regexp
+	scope := ""
+	if len(args) == 2 {
+		re, err := regexp.Compile(strings.Trim(args[1], "\"'"))
+		if err != nil {
+			fmt.Printf("Invalid metric_regex %q: %v\n", args[1], err)
+			return true
+		}
+		filter = re
+		scope = fmt.Sprintf(" in metrics matching %q", re.String())
+	}
+
+	series, metrics := labelValueCounts(storage, label, filter)
+	if len(series) == 0 {
+		fmt.Printf("No values for label '%s'%s\n", label, scope)
+		return true
+	}
+	if jsonlOutput() {
+		type valueJSON struct {
+			Value   string `json:"value"`
+			Series  int    `json:"series"`
+			Metrics int    `json:"metrics"`
+		}
+		var items []valueJSON
+		for _, e := range topCardinalityEntries(series, 0) {
+			items = append(items, valueJSON{Value: e.Name, Series: e.Count, Metrics: metrics[e.Name]})
+		}
+		printJSONL(items)
+		return true
+	}
+	total := 0
+	for _, n := range series {
+		total += n
+	}
+	fmt.Printf("Values of label '%s'%s: %d values, %d series\n", label, scope, len(series), total)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	mustFprintln(tw, "  VALUE\tSERIES\tMETRICS")
+	for _, e := range topCardinalityEntries(series, 0) {
+		mustFprintf(tw, "  %q\t%d\t%d\n", e.Name, e.Count, metrics[e.Name])
+	}
+	_ = tw.Flush()
+	return true
+}
@@ -0,0 +1,39 @@
+package repl
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Values(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	for i := 0; i < 3; i++ {
+		for _, ts := range []int64{0, 15000} {
+			store.AddSample(map[string]string{"__name__": "reqs_total", "path": "/p" + strconv.Itoa(i), "job": "api"}, 1, ts)
+		}
+	}
+	store.AddSample(map[string]string{"__name__": "up", "job": "api"}, 1, 0)
+	store.AddSample(map[string]string{"__name__": "up", "job": "db"}, 1, 0)
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".values job", store) })
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if lines[0] != "Values of label 'job': 2 values, 5 series" || len(lines) != 4 ||
+		strings.Join(strings.Fields(lines[2]), " ") != `"api" 4 2` || strings.Join(strings.Fields(lines[3]), " ") != `"db" 1 1` {
+		t.Fatalf("unexpected .values output:\n%s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".values job ^up$", store) })
+	if !strings.Contains(out, "in metrics matching \"^up$\": 2 values, 2 series") {
+		t.Fatalf("unexpected filtered .values output:\n%s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".values path up", store) })
+	if !strings.Contains(out, "No values for label 'path'") {
+		t.Fatalf("expected no values, got:\n%s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".values", store) })
+	if !strings.Contains(out, "Usage: .values") {
+		t.Fatalf("expected usage, got:\n%s", out)
+	}
+}
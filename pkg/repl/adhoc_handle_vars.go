@@ -0,0 +1,107 @@
+package repl
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// replVars holds the variables set with .set, expanded as $name or ${name} in queries.
+var replVars = map[string]string{}
+
+// varExpansionExempt are commands whose arguments keep $name literally: they define
+// variables or query parameters themselves.
+var varExpansionExempt = []string{".set", ".unset", ".vars", ".define", ".undefine"}
+
+var setVarRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+
+// ExpandVars replaces $name and ${name} references to REPL variables in query. References to
+// unknown variables are left as-is.
+func ExpandVars(query string) string {
+	if len(replVars) == 0 || !strings.Contains(query, "$") {
+		return query
+	}
+	trimmed := strings.TrimSpace(query)
+	for _, cmd := range varExpansionExempt {
+		if trimmed == cmd || strings.HasPrefix(trimmed, cmd+" ") {
+			return query
+		}
+	}
+	return queryParamRe.ReplaceAllStringFunc(query, func(m string) string {
+		sub := queryParamRe.FindStringSubmatch(m)
+		if v, ok := replVars[sub[1]+sub[2]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// handleAdhocSet sets a REPL variable: .set name=value
+func handleAdhocSet(query string) bool {
+	arg := strings.TrimSpace(strings.TrimPrefix(query, ".set"))
+	if arg == "" {
+		return handleAdhocVars(".vars")
+	}
+	m := setVarRe.FindStringSubmatch(arg)
+	if m == nil {
+		cmd := GetAdHocCommandByName(".set")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	value := strings.TrimSpace(m[2])
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	replVars[m[1]] = value
+	fmt.Printf("$%s = %s\n", m[1], value)
+	return true
+}
+
+// handleAdhocUnset removes REPL variables: .unset <name> [name ...]
+func handleAdhocUnset(query string) bool {
+	names := strings.Fields(strings.TrimPrefix(query, ".unset"))
+	if len(names) == 0 {
+		fmt.Println("Usage: " + GetAdHocCommandByName(".unset").Usage)
+		return true
+	}
+	for _, name := range names {
+		name = strings.TrimPrefix(name, "$")
+		if _, ok := replVars[name]; !ok {
+			fmt.Printf("No variable $%s\n", name)
+			continue
+		}
+		delete(replVars, name)
+		fmt.Printf("Unset $%s\n", name)
+	}
+	return true
+}
+
+// handleAdhocVars lists the REPL variables: .vars
+func handleAdhocVars(query string) bool {
+	if len(replVars) == 0 {
+		fmt.Println("No variables set. Try: .set cluster=prod")
+		return true
+	}
+	names := make([]string, 0, len(replVars))
+	for name := range replVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("$%s = %s\n", name, replVars[name])
+	}
+	return true
+}
+
+// setReplVars replaces all REPL variables, e.g. when loading a session.
+func setReplVars(vars map[string]string) {
+	replVars = maps.Clone(vars)
+	if replVars == nil {
+		replVars = map[string]string{}
+	}
+}
@@ -0,0 +1,42 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_SetVarsExpansion(t *testing.T) {
+	defer setReplVars(nil)
+	store := sstorage.NewSimpleStorage()
+	if err := store.LoadFromReader(strings.NewReader("up{cluster=\"prod\",job=\"api\"} 1\nup{cluster=\"dev\",job=\"api\"} 0\n")); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	out := captureStdout(t, func() { _ = handleAdHocFunction(`.set cluster = "prod"`, store) })
+	if !strings.Contains(out, "$cluster = prod") {
+		t.Fatalf("unexpected .set output: %s", out)
+	}
+	if got := ExpandVars(`up{cluster="$cluster",job="${job}"}`); got != `up{cluster="prod",job="${job}"}` {
+		t.Fatalf("unexpected expansion %q", got)
+	}
+	if got := ExpandVars(`.define f(cluster) = up{cluster="$cluster"}`); !strings.Contains(got, "$cluster") {
+		t.Fatalf(".define should not be expanded: %q", got)
+	}
+	if got := ExpandVars(`label_replace(up, "x", "$1", "job", "(.*)")`); !strings.Contains(got, `"$1"`) {
+		t.Fatalf("regex group references must be kept: %q", got)
+	}
+
+	out = captureStdout(t, func() { ExecuteQueryLine(newTestEngine(), store, `up{cluster="$cluster"}`) })
+	if !strings.Contains(out, `cluster="prod"`) || strings.Contains(out, `cluster="dev"`) {
+		t.Fatalf("expected only the prod series, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".vars", store) })
+	if !strings.Contains(out, "$cluster = prod") {
+		t.Fatalf("unexpected .vars output: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".unset cluster", store) })
+	if len(replVars) != 0 || !strings.Contains(out, "Unset $cluster") {
+		t.Fatalf("expected cluster to be unset: %s", out)
+	}
+}
@@ -0,0 +1,195 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// ANSI sequences used by watch mode.
+const (
+	watchClearScreen = "\033[2J\033[H"
+	watchColorUp     = "\033[1;32m"
+	watchColorDown   = "\033[1;31m"
+	watchColorNew    = "\033[1;33m"
+	watchColorReset  = "\033[0m"
+)
+
+// watchCancel stops the running .watch; the prompt backend's interrupt handler
+// calls it instead of exiting the REPL.
+var watchCancel context.CancelFunc
+
+// WatchOptions controls RunWatch.
+type WatchOptions struct {
+	Interval time.Duration
+	// Count stops after that many iterations (0 runs until ctx is canceled).
+	Count int
+	// Clear clears the screen before each iteration, as watch(1) does.
+	Clear bool
+}
+
+// RunWatch evaluates expr as an instant query at the current time every opts.Interval,
+// writing the result to w and highlighting values that changed since the previous
+// iteration. It returns when ctx is canceled or after opts.Count iterations. The REPL store
+// is released between iterations, so that .scrape_bg merges show up.
+func RunWatch(ctx context.Context, engine *promql.Engine, storage *sstorage.SimpleStorage, expr string, opts WatchOptions, w io.Writer) error {
+	if opts.Interval <= 0 {
+		return fmt.Errorf("watch interval must be a positive duration")
+	}
+	var prev map[string]float64
+	for i := 0; opts.Count <= 0 || i < opts.Count; i++ {
+		if i > 0 && !waitReleasingStore(ctx, opts.Interval) {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		now := time.Now()
+		if opts.Clear {
+			_, _ = io.WriteString(w, watchClearScreen)
+		}
+		_, _ = fmt.Fprintf(w, "Every %s: %s    %s\n\n", opts.Interval, expr, now.UTC().Format(time.RFC3339))
+
+		qctx, cancel := context.WithTimeout(ctx, replTimeout)
+		q, err := engine.NewInstantQuery(qctx, QueryableFor(storage), nil, expr, now)
+		if err != nil {
+			cancel()
+			return fmt.Errorf("creating query: %w", err)
+		}
+		res := q.Exec(qctx)
+		cancel()
+		if res.Err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			_, _ = fmt.Fprintf(w, "Error: %v\n", res.Err)
+			continue
+		}
+		cur, ok := watchValues(res)
+		if !ok {
+			// Matrices and strings have no per-series value to compare; render them as-is.
+			if err := formatters[outputFormat].Format(w, res); err != nil {
+				return err
+			}
+			continue
+		}
+		writeWatchDeltas(w, cur, prev)
+		prev = cur
+	}
+	return nil
+}
+
+// watchValues returns the float values of a vector or scalar result, keyed by series.
+func watchValues(res *promql.Result) (map[string]float64, bool) {
+	switch v := res.Value.(type) {
+	case promql.Vector:
+		out := make(map[string]float64, len(v))
+		for _, s := range v {
+			if s.H != nil {
+				continue
+			}
+			out[s.Metric.String()] = s.F
+		}
+		return out, true
+	case promql.Scalar:
+		return map[string]float64{"scalar": v.V}, true
+	default:
+		return nil, false
+	}
+}
+
+// writeWatchDeltas prints one line per series, colored by the change since prev.
+func writeWatchDeltas(w io.Writer, cur, prev map[string]float64) {
+	if len(cur) == 0 {
+		_, _ = fmt.Fprintln(w, "No results found")
+	}
+	keys := make([]string, 0, len(cur))
+	for k := range cur {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := cur[k]
+		line := fmt.Sprintf("%s => %s", k, strconv.FormatFloat(v, 'g', -1, 64))
+		old, seen := prev[k]
+		switch {
+		case prev == nil:
+		case !seen:
+			line = watchColorNew + line + " (new)" + watchColorReset
+		case v > old:
+			line = watchColorUp + line + " (+" + strconv.FormatFloat(v-old, 'g', -1, 64) + ")" + watchColorReset
+		case v < old:
+			line = watchColorDown + line + " (" + strconv.FormatFloat(v-old, 'g', -1, 64) + ")" + watchColorReset
+		}
+		_, _ = fmt.Fprintln(w, line)
+	}
+	var gone []string
+	for k := range prev {
+		if _, ok := cur[k]; !ok {
+			gone = append(gone, k)
+		}
+	}
+	sort.Strings(gone)
+	for _, k := range gone {
+		_, _ = fmt.Fprintf(w, "%s%s (gone)%s\n", watchColorDown, k, watchColorReset)
+	}
+}
+
+// handleAdhocWatch re-runs a query periodically: .watch <interval> <query>
+func handleAdhocWatch(query string, storage *sstorage.SimpleStorage) bool {
+	rest := strings.TrimSpace(strings.TrimPrefix(query, ".watch"))
+	intervalStr, expr, _ := strings.Cut(rest, " ")
+	expr = strings.TrimSpace(expr)
+	if intervalStr == "" || expr == "" {
+		fmt.Println("Usage: " + GetAdHocCommandByName(".watch").Usage)
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	interval, err := parseRangeStep(intervalStr)
+	if err != nil {
+		fmt.Printf("Invalid interval %q: %v\n", intervalStr, err)
+		return true
+	}
+	if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+		expr = alertExpr
+	}
+	expr = normalizeAtModifierTimestamps(expr)
+
+	// Create a context that can be canceled by Ctrl-C
+	ctx, cancel := context.WithCancel(context.Background())
+	watchCancel = cancel
+	defer func() {
+		watchCancel = nil
+		cancel()
+	}()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGINT)
+	defer signal.Stop(sigChan)
+	go func() {
+		select {
+		case <-sigChan:
+			fmt.Println("\nWatch stopped")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := RunWatch(ctx, replEngine, storage, expr, WatchOptions{Interval: interval, Clear: true}, os.Stdout); err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+	return true
+}
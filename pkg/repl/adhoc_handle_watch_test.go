@@ -0,0 +1,50 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestRunWatch_HighlightsChanges(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	now := time.Now().UnixMilli()
+	store.AddSample(map[string]string{"__name__": "jobs", "queue": "a"}, 1, now)
+	store.AddSample(map[string]string{"__name__": "jobs", "queue": "b"}, 5, now)
+
+	var buf bytes.Buffer
+	opts := WatchOptions{Interval: time.Millisecond, Count: 1}
+	if err := RunWatch(context.Background(), newTestEngine(), store, "jobs", opts, &buf); err != nil {
+		t.Fatalf("RunWatch: %v", err)
+	}
+	first := buf.String()
+	if !strings.Contains(first, "Every 1ms: jobs") || !strings.Contains(first, `{__name__="jobs", queue="a"} => 1`) || strings.Contains(first, "\033[") {
+		t.Fatalf("unexpected first iteration: %q", first)
+	}
+
+	prev := map[string]float64{`jobs{queue="a"}`: 1, `jobs{queue="b"}`: 5}
+	cur := map[string]float64{`jobs{queue="a"}`: 3, `jobs{queue="c"}`: 7}
+	buf.Reset()
+	writeWatchDeltas(&buf, cur, prev)
+	out := buf.String()
+	for _, want := range []string{
+		watchColorUp + `jobs{queue="a"} => 3 (+2)` + watchColorReset,
+		watchColorNew + `jobs{queue="c"} => 7 (new)` + watchColorReset,
+		watchColorDown + `jobs{queue="b"} (gone)` + watchColorReset,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buf.Reset()
+	if err := RunWatch(ctx, newTestEngine(), store, "jobs", WatchOptions{Interval: time.Second}, &buf); err != nil || buf.Len() != 0 {
+		t.Fatalf("expected canceled watch to return immediately, got err=%v out=%q", err, buf.String())
+	}
+}
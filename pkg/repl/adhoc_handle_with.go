@@ -0,0 +1,143 @@
+package repl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+var (
+	// rangeStepOverride, set by .with step=, is the step of the range queries run by
+	// RunRangeQuery in place of their own; rangeStepUsed records that one used it.
+	rangeStepOverride time.Duration
+	rangeStepUsed     bool
+)
+
+// withOptions are the per-command overrides of .with.
+type withOptions struct {
+	Timeout time.Duration
+	Step    time.Duration
+}
+
+// parseWithArgs splits ".with" arguments into their leading key=value overrides (timeout=
+// and step=) and the command they apply to.
+func parseWithArgs(args string) (withOptions, string, error) {
+	var opts withOptions
+	rest := strings.TrimSpace(args)
+	for rest != "" {
+		field, after, _ := strings.Cut(rest, " ")
+		k, v, ok := strings.Cut(field, "=")
+		if !ok || strings.ContainsAny(k, "{(\"") {
+			break
+		}
+		d, err := model.ParseDuration(v)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("must be positive")
+		}
+		switch strings.ToLower(k) {
+		case "timeout":
+			opts.Timeout = time.Duration(d)
+		case "step":
+			opts.Step = time.Duration(d)
+		default:
+			return opts, "", fmt.Errorf("unknown option %q (expected timeout= or step=)", k)
+		}
+		if err != nil {
+			return opts, "", fmt.Errorf("invalid %s %q: %w", k, v, err)
+		}
+		rest = strings.TrimSpace(after)
+	}
+	return opts, rest, nil
+}
+
+// runWith runs line as the REPL would with the query timeout and range step of opts in
+// place of the session ones. A timeout beyond the engine's runs on an engine built for
+// this command only, so the session engine is left as it is.
+func runWith(storage *sstorage.SimpleStorage, opts withOptions, line string) {
+	engine := replEngine
+	if opts.Timeout > 0 && engineOpts.Timeout > 0 && opts.Timeout > engineOpts.Timeout {
+		eo := engineOpts
+		eo.Timeout = opts.Timeout
+		engine = promql.NewEngine(eo)
+	}
+	prevEngine, prevTimeout := replEngine, replTimeout
+	prevStep, prevUsed := rangeStepOverride, rangeStepUsed
+	defer func() {
+		replEngine, replTimeout = prevEngine, prevTimeout
+		rangeStepOverride, rangeStepUsed = prevStep, prevUsed
+	}()
+	replEngine = engine
+	if opts.Timeout > 0 {
+		replTimeout = opts.Timeout
+	}
+	if opts.Step > 0 {
+		rangeStepOverride, rangeStepUsed = opts.Step, false
+	}
+	executeOne(engine, storage, line)
+	if opts.Step > 0 && !rangeStepUsed {
+		fmt.Println("Note: step= only applies to range queries, e.g. .at <start>..<end>, .range or .graph")
+	}
+}
+
+// handleAdhocWith runs a command with other limits than the session ones:
+// .with [timeout=<duration>] [step=<duration>] <query or command>
+func handleAdhocWith(query string, storage *sstorage.SimpleStorage) bool {
+	opts, rest, err := parseWithArgs(strings.TrimPrefix(query, ".with"))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+	if err != nil || rest == "" || opts == (withOptions{}) {
+		cmd := GetAdHocCommandByName(".with")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	runWith(storage, opts, rest)
+	return true
+}
+
+// handleAdhocTimeout shows the query timeout, sets it for the session, or runs a query
+// with another one: .timeout [<duration> [<query>]]
+func handleAdhocTimeout(query string, storage *sstorage.SimpleStorage) bool {
+	arg := strings.TrimSpace(strings.TrimPrefix(query, ".timeout"))
+	if arg == "" {
+		fmt.Printf("Query timeout: %s (set it for the session with .timeout <duration>)\n", model.Duration(replTimeout))
+		return true
+	}
+	durStr, rest, _ := strings.Cut(arg, " ")
+	d, err := model.ParseDuration(durStr)
+	if err != nil || d <= 0 {
+		fmt.Printf("Error: invalid timeout %q\n", durStr)
+		cmd := GetAdHocCommandByName(".timeout")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	if rest = strings.TrimSpace(rest); rest == "" {
+		// As .engine set timeout <duration>
+		opts := engineOpts
+		opts.Timeout = time.Duration(d)
+		rebuildEngine(opts)
+		fmt.Printf("Query timeout: %s\n", model.Duration(replTimeout))
+		return true
+	}
+	runWith(storage, withOptions{Timeout: time.Duration(d)}, rest)
+	return true
+}
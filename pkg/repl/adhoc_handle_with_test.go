@@ -0,0 +1,83 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestParseWithArgs(t *testing.T) {
+	opts, rest, err := parseWithArgs(" timeout=2m step=15s sum(rate(x[5m]))")
+	if err != nil || opts.Timeout != 2*time.Minute || opts.Step != 15*time.Second || rest != "sum(rate(x[5m]))" {
+		t.Fatalf("unexpected parse: %+v %q %v", opts, rest, err)
+	}
+	if _, rest, _ := parseWithArgs(`timeout=1s up{job="a"}`); rest != `up{job="a"}` {
+		t.Fatalf("expected the selector kept as the query, got %q", rest)
+	}
+	for _, args := range []string{"samples=10 up", "timeout=0s up", "step=abc up"} {
+		if _, _, err := parseWithArgs(args); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
+
+func TestAdhoc_WithOverrides(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 61 {
+		store.AddSample(map[string]string{"__name__": "jobs"}, float64(i), t0.Add(time.Duration(i)*time.Minute).UnixMilli())
+	}
+	prevEngine, prevOpts, prevTimeout := replEngine, engineOpts, replTimeout
+	defer func() { replEngine, engineOpts, replTimeout = prevEngine, prevOpts, prevTimeout }()
+	engineOpts = promql.EngineOpts{MaxSamples: 50_000_000, Timeout: time.Second, LookbackDelta: 5 * time.Minute}
+	replEngine = promql.NewEngine(engineOpts)
+	session := replEngine
+
+	out := captureStdout(t, func() {
+		_ = handleAdHocFunction(".with step=30m .at 2023-01-01T00:00:00Z..2023-01-01T01:00:00Z jobs", store)
+	})
+	if strings.Count(out, " @ ") != 3 || strings.Contains(out, "Note:") {
+		t.Fatalf("expected 3 points at a 30m step, got: %s", out)
+	}
+	if replEngine != session || replTimeout != prevTimeout || rangeStepOverride != 0 {
+		t.Fatalf("expected the session settings restored")
+	}
+
+	var sawTimeout time.Duration
+	out = captureStdout(t, func() {
+		_ = handleAdHocFunction(".with timeout=5m step=1m .timeout", store)
+		sawTimeout = replTimeout
+	})
+	if !strings.Contains(out, "Query timeout: 5m") || !strings.Contains(out, "Note: step= only applies to range queries") || sawTimeout != prevTimeout {
+		t.Fatalf("expected the overridden timeout shown, got: %s", out)
+	}
+
+	// A timeout beyond the engine's one runs on an engine of its own
+	engineOpts.Timeout = time.Nanosecond
+	replEngine = promql.NewEngine(engineOpts)
+	out = captureStdout(t, func() { executeOne(replEngine, store, ".at 2023-01-01T00:30:00Z jobs") })
+	if !strings.Contains(out, "Error") {
+		t.Fatalf("expected the session engine to time out, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".timeout 1m .at 2023-01-01T00:30:00Z jobs", store) })
+	if !strings.Contains(out, "Vector (1 samples)") {
+		t.Fatalf("expected the query to run with the longer timeout, got: %s", out)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".timeout 10m", store) })
+	if !strings.Contains(out, "Query timeout: 10m") || replTimeout != 10*time.Minute || engineOpts.Timeout != 10*time.Minute {
+		t.Fatalf("expected the session timeout set, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".timeout soon", store) })
+	if !strings.Contains(out, "Error: invalid timeout") || !strings.Contains(out, "Usage: .timeout") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".with up", store) })
+	if !strings.Contains(out, "Usage: .with") {
+		t.Fatalf("expected usage without overrides, got: %s", out)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -177,6 +178,32 @@ func TestAdhoc_Drop_RemovesMetricAndReports(t *testing.T) {
 	}
 }
 
+func TestAdhoc_DropKeep_Matchers(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	for _, ts := range []int64{0, 15000} {
+		store.AddSample(map[string]string{"__name__": "tmp_a", "job": "old"}, 1, ts)
+		store.AddSample(map[string]string{"__name__": "tmp_b", "job": "new"}, 1, ts)
+		store.AddSample(map[string]string{"__name__": "up", "job": "old"}, 1, ts)
+		store.AddSample(map[string]string{"__name__": "up", "job": "new"}, 1, ts)
+	}
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(`.drop {job="old",__name__=~"tmp_.*"}`, store) })
+	if !strings.Contains(out, "Dropped 2 samples in 1 series") {
+		t.Fatalf("unexpected .drop output: %s", out)
+	}
+	if _, ok := store.Metrics["tmp_a"]; ok {
+		t.Fatal("expected tmp_a removed")
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(`.keep up{job="new"}`, store) })
+	if !strings.Contains(out, "removed 4 samples in 2 series; now: 1 metrics, 2 samples") {
+		t.Fatalf("unexpected .keep output: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(`.drop {job=}`, store) })
+	if !strings.Contains(out, "Error: invalid selector") {
+		t.Fatalf("expected a selector error, got: %s", out)
+	}
+}
+
 func TestAdhoc_Scrape_FetchesAndLoads(t *testing.T) {
 	// Prepare a small exposition endpoint
 	payload := `# HELP up 1 if up
@@ -328,6 +355,50 @@ func TestAdhoc_Help_PrintsAndReturnsTrue(t *testing.T) {
 	}
 }
 
+func TestAdhoc_Help_Topics(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	for topic, want := range map[string]string{
+		".help .record": "Record the executed commands",
+		".help record":  ".record <file.jsonl>",
+		".help keys":    "Ctrl-X Ctrl-E",
+		".help env":     "PROMQL_CLI_HISTORY",
+		".help nosuch":  `Error: unknown help topic "nosuch"`,
+	} {
+		out := captureStdout(t, func() { _ = handleAdHocFunction(topic, store) })
+		if !strings.Contains(out, want) || strings.Contains(out, "Ad-hoc commands:") {
+			t.Errorf("%s: expected %q only, got: %s", topic, want, out)
+		}
+	}
+}
+
+// TestEnvVarsDocumented checks that the environment variables read by the REPL are listed
+// in EnvVars, so that .help env and the man page don't miss new ones.
+func TestEnvVarsDocumented(t *testing.T) {
+	var documented strings.Builder
+	for _, e := range EnvVars {
+		documented.WriteString(e.Name + ",")
+	}
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`"(PROMQL_CLI_[A-Z_]+)"`)
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range re.FindAllStringSubmatch(string(src), -1) {
+			if !strings.Contains(documented.String(), m[1]+",") {
+				t.Errorf("%s reads %s, missing from EnvVars", f, m[1])
+			}
+		}
+	}
+}
+
 func TestAdhoc_Metrics_EmptyAndNonEmpty(t *testing.T) {
 	// Empty store
 	empty := sstorage.NewSimpleStorage()
@@ -391,6 +462,33 @@ func TestAdhoc_Save_WithTimestamp_ParsesPathAndArgs(t *testing.T) {
 	}
 }
 
+func TestAdhoc_Export_FormatFromExtensionAndArg(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t)
+
+	jsonPath := filepath.Join(dir, "store.json")
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".export "+jsonPath, store) })
+	if !strings.Contains(out, "Exported 2 metrics (3 series, 3 samples) to "+jsonPath+" as json") {
+		t.Fatalf("unexpected .export output: %s", out)
+	}
+	data, err := os.ReadFile(jsonPath)
+	if err != nil || !strings.Contains(string(data), `"type": "counter"`) {
+		t.Fatalf("expected JSON export with types, got %q (%v)", data, err)
+	}
+
+	omPath := filepath.Join(dir, "store.txt")
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".export "+omPath+" format=openmetrics", store) })
+	data, err = os.ReadFile(omPath)
+	if err != nil || !strings.HasSuffix(string(data), "# EOF\n") || !strings.Contains(string(data), "# HELP temperature Temperature in Celsius") {
+		t.Fatalf("expected OpenMetrics export, got %q (%v)", data, err)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".export "+omPath+" format=yaml", store) })
+	if !strings.Contains(out, "Unknown export format") {
+		t.Fatalf("expected unknown format error, got: %s", out)
+	}
+}
+
 func TestAdhoc_Seed_KVAndPositional(t *testing.T) {
 	store := sstorage.NewSimpleStorage()
 	if err := store.LoadFromReader(strings.NewReader(sstorage.SampleMetrics)); err != nil {
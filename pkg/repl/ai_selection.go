@@ -0,0 +1,94 @@
+package repl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// aiSuggestionItem is an entry of the AI selection menu, shared by the go-prompt dropdown,
+// its picker and the readline Tab completion.
+type aiSuggestionItem struct {
+	Text        string // command to run, e.g. ".ai edit 1"
+	Description string // preview of the query and its explanation
+}
+
+// aiSuggestionPreview shortens a suggested query and its explanation for menus.
+func aiSuggestionPreview(query, explanation string) string {
+	preview := strings.TrimSpace(query)
+	if len(preview) > 120 {
+		preview = preview[:120] + "..."
+	}
+	if ex := strings.TrimSpace(explanation); ex != "" {
+		if len(ex) > 80 {
+			ex = ex[:80] + "..."
+		}
+		preview = preview + " — " + ex
+	}
+	return preview
+}
+
+// aiSuggestionItems lists "<prefix>edit N" for every suggestion, then "<prefix>run N".
+func aiSuggestionItems(prefix string, queries, explanations []string) []aiSuggestionItem {
+	var items []aiSuggestionItem
+	for _, action := range []string{"edit", "run"} {
+		for i, q := range queries {
+			ex := ""
+			if i < len(explanations) {
+				ex = explanations[i]
+			}
+			items = append(items, aiSuggestionItem{
+				Text:        fmt.Sprintf("%s%s %d", prefix, action, i+1),
+				Description: aiSuggestionPreview(q, ex),
+			})
+		}
+	}
+	return items
+}
+
+// aiPasteText returns the line to paste for the Nth (1-based) AI suggestion, or with n=0 for
+// the one prepared by .ai edit (defaulting to the first). The explanation, when any, is
+// appended as a PromQL comment. It returns "" when there is nothing to paste.
+func aiPasteText(n int) string {
+	idx := n - 1
+	if n == 0 {
+		if strings.TrimSpace(aiClipboard) == "" {
+			if len(lastAISuggestions) == 0 {
+				return ""
+			}
+			aiClipboard = lastAISuggestions[0]
+		}
+		idx = -1
+		for i, q := range lastAISuggestions {
+			if strings.TrimSpace(q) == strings.TrimSpace(aiClipboard) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return aiClipboard
+		}
+	}
+	if idx < 0 || idx >= len(lastAISuggestions) || lastAISuggestions[idx] == "" {
+		return ""
+	}
+	text := lastAISuggestions[idx]
+	if idx < len(lastAIExplanations) {
+		if ex := strings.TrimSpace(lastAIExplanations[idx]); ex != "" {
+			text = text + " # " + ex
+		}
+	}
+	return text
+}
+
+// aiSelectionCompletions returns the AI selection menu commands for an empty line while a
+// selection is active, for backends without a dropdown.
+func aiSelectionCompletions(line string) []string {
+	if !aiSelectionActive || strings.TrimSpace(line) != "" {
+		return nil
+	}
+	var out []string
+	for _, it := range aiSuggestionItems(".ai ", lastAISuggestions, lastAIExplanations) {
+		out = append(out, it.Text)
+	}
+	return out
+}
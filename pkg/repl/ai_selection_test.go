@@ -0,0 +1,57 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAISelection_PasteAndMenu(t *testing.T) {
+	prevQ, prevE, prevClip, prevActive := lastAISuggestions, lastAIExplanations, aiClipboard, aiSelectionActive
+	defer func() {
+		lastAISuggestions, lastAIExplanations, aiClipboard, aiSelectionActive = prevQ, prevE, prevClip, prevActive
+	}()
+
+	lastAISuggestions, lastAIExplanations, aiClipboard, aiSelectionActive = nil, nil, "", false
+	if got := aiPasteText(0); got != "" {
+		t.Fatalf("expected nothing to paste, got %q", got)
+	}
+
+	lastAISuggestions = []string{"up", "sum(rate(http_requests_total[5m]))"}
+	lastAIExplanations = []string{"", "overall request rate"}
+	if got := aiPasteText(0); got != "up" || aiClipboard != "up" {
+		t.Fatalf("Ctrl-Y should default to the first suggestion, got %q (clipboard %q)", got, aiClipboard)
+	}
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".ai edit 2", nil) })
+	if got, want := aiPasteText(0), "sum(rate(http_requests_total[5m])) # overall request rate"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if got := aiPasteText(1); got != "up" {
+		t.Fatalf("Alt-1 should paste the first suggestion, got %q", got)
+	}
+	if got := aiPasteText(3); got != "" {
+		t.Fatalf("expected nothing for a missing suggestion, got %q", got)
+	}
+
+	items := aiSuggestionItems(".ai ", lastAISuggestions, lastAIExplanations)
+	var texts []string
+	for _, it := range items {
+		texts = append(texts, it.Text)
+	}
+	if got := strings.Join(texts, ","); got != ".ai edit 1,.ai edit 2,.ai run 1,.ai run 2" {
+		t.Fatalf("unexpected menu: %s", got)
+	}
+	if items[1].Description != "sum(rate(http_requests_total[5m])) — overall request rate" {
+		t.Fatalf("unexpected preview: %q", items[1].Description)
+	}
+
+	if got := aiSelectionCompletions(""); got != nil {
+		t.Fatalf("expected no completions without an active selection, got %v", got)
+	}
+	aiSelectionActive = true
+	if got := aiSelectionCompletions("  "); len(got) != 4 {
+		t.Fatalf("expected 4 completions on an empty line, got %v", got)
+	}
+	if got := aiSelectionCompletions("up"); got != nil {
+		t.Fatalf("expected no AI completions while typing, got %v", got)
+	}
+}
@@ -0,0 +1,147 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Assertion is a check of a query result, given with query --assert or a "# expect:"
+// line of a query file: non-empty, empty, or "value <op> N", which every value of the
+// result (and at least one) must satisfy.
+type Assertion struct {
+	Spec      string  // as given, for messages
+	Kind      string  // non-empty, empty or value
+	Op        string  // >, >=, <, <=, == or != for value
+	Threshold float64 // N for value
+}
+
+// valueAssertion matches "value <op> N" assertions.
+var valueAssertion = regexp.MustCompile(`^value\s*(>=|<=|==|!=|>|<)\s*(\S+)$`)
+
+// ParseAssertion parses non-empty, empty or "value <op> N".
+func ParseAssertion(spec string) (Assertion, error) {
+	spec = strings.TrimSpace(spec)
+	a := Assertion{Spec: spec}
+	switch strings.ToLower(spec) {
+	case "non-empty", "nonempty":
+		a.Kind = "non-empty"
+		return a, nil
+	case "empty":
+		a.Kind = "empty"
+		return a, nil
+	}
+	m := valueAssertion.FindStringSubmatch(spec)
+	if m == nil {
+		return a, fmt.Errorf("invalid assertion %q (expected non-empty, empty or 'value <op> N' with op one of > >= < <= == !=)", spec)
+	}
+	n, err := strconv.ParseFloat(m[2], 64)
+	if err != nil {
+		return a, fmt.Errorf("invalid assertion %q: %q is not a number", spec, m[2])
+	}
+	a.Kind, a.Op, a.Threshold = "value", m[1], n
+	return a, nil
+}
+
+// Check returns nil when result satisfies the assertion, or an error describing why not.
+func (a Assertion) Check(result *promql.Result) error {
+	if result == nil {
+		return errors.New("no query result")
+	}
+	if result.Err != nil {
+		return result.Err
+	}
+	series := resultSeriesCount(result.Value)
+	switch a.Kind {
+	case "non-empty":
+		if series == 0 {
+			return errors.New("expected a non-empty result, got no series")
+		}
+		return nil
+	case "empty":
+		if series != 0 {
+			return fmt.Errorf("expected an empty result, got %d series", series)
+		}
+		return nil
+	}
+
+	checked, failed := 0, 0
+	var example string
+	check := func(lbls labels.Labels, v float64) {
+		checked++
+		if !a.compare(v) {
+			if failed == 0 {
+				example = fmt.Sprintf("%s => %s", lbls.String(), strconv.FormatFloat(v, 'g', -1, 64))
+			}
+			failed++
+		}
+	}
+	switch v := result.Value.(type) {
+	case promql.Vector:
+		for _, s := range v {
+			if s.H != nil {
+				return fmt.Errorf("cannot compare the native histogram of %s", s.Metric.String())
+			}
+			check(s.Metric, s.F)
+		}
+	case promql.Matrix:
+		for _, s := range v {
+			if len(s.Histograms) > 0 {
+				return fmt.Errorf("cannot compare the native histograms of %s", s.Metric.String())
+			}
+			for _, p := range s.Floats {
+				check(s.Metric, p.F)
+			}
+		}
+	case promql.Scalar:
+		check(labels.EmptyLabels(), v.V)
+	default:
+		return fmt.Errorf("cannot compare a %s result", result.Value.Type())
+	}
+	if checked == 0 {
+		return errors.New("expected values to compare, got no series")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d values fail %s, e.g. %s", failed, checked, a.Spec, example)
+	}
+	return nil
+}
+
+// compare reports whether v satisfies a value assertion.
+func (a Assertion) compare(v float64) bool {
+	switch a.Op {
+	case ">":
+		return v > a.Threshold
+	case ">=":
+		return v >= a.Threshold
+	case "<":
+		return v < a.Threshold
+	case "<=":
+		return v <= a.Threshold
+	case "==":
+		return v == a.Threshold
+	case "!=":
+		return v != a.Threshold
+	}
+	return false
+}
+
+// resultSeriesCount returns the series of a result; a scalar or string counts as one.
+func resultSeriesCount(v parser.Value) int {
+	switch v := v.(type) {
+	case promql.Vector:
+		return len(v)
+	case promql.Matrix:
+		return len(v)
+	case nil:
+		return 0
+	default:
+		return 1
+	}
+}
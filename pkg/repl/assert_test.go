@@ -0,0 +1,72 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+func TestParseAssertion(t *testing.T) {
+	for spec, want := range map[string]Assertion{
+		"non-empty":     {Kind: "non-empty"},
+		"EMPTY":         {Kind: "empty"},
+		"value > 5":     {Kind: "value", Op: ">", Threshold: 5},
+		"value>=0.99":   {Kind: "value", Op: ">=", Threshold: 0.99},
+		"value != -1e3": {Kind: "value", Op: "!=", Threshold: -1000},
+	} {
+		got, err := ParseAssertion(spec)
+		want.Spec = spec
+		if err != nil || got != want {
+			t.Errorf("ParseAssertion(%q) = %+v, %v; want %+v", spec, got, err, want)
+		}
+	}
+	for _, spec := range []string{"", "present", "value", "value ~ 1", "value > x", "count > 1"} {
+		if _, err := ParseAssertion(spec); err == nil {
+			t.Errorf("ParseAssertion(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestAssertionCheck(t *testing.T) {
+	vector := &promql.Result{Value: promql.Vector{
+		{Metric: labels.FromStrings("job", "a"), F: 1},
+		{Metric: labels.FromStrings("job", "b"), F: 0},
+	}}
+	matrix := &promql.Result{Value: promql.Matrix{
+		{Metric: labels.FromStrings("job", "a"), Floats: []promql.FPoint{{T: 0, F: 2}, {T: 60000, F: 3}}},
+	}}
+	empty := &promql.Result{Value: promql.Vector{}}
+	scalar := &promql.Result{Value: promql.Scalar{V: 7}}
+
+	for _, tc := range []struct {
+		spec    string
+		result  *promql.Result
+		wantErr string
+	}{
+		{"non-empty", vector, ""},
+		{"non-empty", empty, "got no series"},
+		{"empty", empty, ""},
+		{"empty", vector, "got 2 series"},
+		{"value >= 0", vector, ""},
+		{"value > 0", vector, `1 of 2 values fail value > 0, e.g. {job="b"} => 0`},
+		{"value > 1", matrix, ""},
+		{"value < 3", matrix, "1 of 2 values fail"},
+		{"value == 7", scalar, ""},
+		{"value > 0", empty, "got no series"},
+		{"non-empty", nil, "no query result"},
+	} {
+		a, err := ParseAssertion(tc.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = a.Check(tc.result)
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tc.spec, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: expected error containing %q, got %v", tc.spec, tc.wantErr, err)
+		}
+	}
+}
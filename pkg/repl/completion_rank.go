@@ -0,0 +1,103 @@
+package repl
+
+import (
+	"sort"
+	"strings"
+)
+
+// functionMetricTypes maps PromQL functions to the metric TYPE their argument usually has,
+// so completions inside e.g. rate( list counters first.
+var functionMetricTypes = map[string]string{
+	"rate":               "counter",
+	"irate":              "counter",
+	"increase":           "counter",
+	"resets":             "counter",
+	"histogram_quantile": "histogram",
+	"avg_over_time":      "gauge",
+	"min_over_time":      "gauge",
+	"max_over_time":      "gauge",
+	"quantile_over_time": "gauge",
+	"stddev_over_time":   "gauge",
+	"stdvar_over_time":   "gauge",
+	"delta":              "gauge",
+	"idelta":             "gauge",
+	"deriv":              "gauge",
+	"predict_linear":     "gauge",
+	"changes":            "gauge",
+}
+
+// metricTypeOf returns the TYPE metadata of a metric name, resolving the foo_bucket,
+// foo_sum and foo_count series of a histogram or summary and the foo_total series of a
+// counter to their family foo. It returns "" when unknown.
+func metricTypeOf(types map[string]string, name string) string {
+	if typ, ok := types[name]; ok {
+		return typ
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count", "_total"} {
+		base, found := strings.CutSuffix(name, suffix)
+		if !found {
+			continue
+		}
+		typ := types[base]
+		switch {
+		case suffix == "_total" && typ == "counter":
+			return typ
+		case suffix != "_total" && (typ == "histogram" || typ == "summary"):
+			return typ
+		}
+	}
+	return ""
+}
+
+// metricSuitsFunction tells whether a metric is the kind fn usually takes: counters for
+// rate(), _bucket series for histogram_quantile(), gauges for avg_over_time() and the like.
+func metricSuitsFunction(types map[string]string, name, fn string) bool {
+	want := functionMetricTypes[fn]
+	if want == "" {
+		return false
+	}
+	typ := metricTypeOf(types, name)
+	if want == "histogram" {
+		return strings.HasSuffix(name, "_bucket") && (typ == "" || typ == "histogram")
+	}
+	return typ == want
+}
+
+// rankMetricsForFunction stably moves the metrics suiting fn (see metricSuitsFunction)
+// ahead of the others, keeping the order of each group.
+func rankMetricsForFunction(names []string, types map[string]string, fn string) []string {
+	if functionMetricTypes[fn] == "" {
+		return names
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return metricSuitsFunction(types, names[i], fn) && !metricSuitsFunction(types, names[j], fn)
+	})
+	return names
+}
+
+// enclosingFunction returns the name of the function call the end of text is an argument
+// of, e.g. "rate" for "sum(rate(http_", or "" outside of any call.
+func enclosingFunction(text string) string {
+	depth := 0
+	for i := len(text) - 1; i >= 0; i-- {
+		switch text[i] {
+		case ')':
+			depth++
+		case '(':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			start := i
+			for start > 0 && (isIdentByte(text[start-1])) {
+				start--
+			}
+			return text[start:i]
+		}
+	}
+	return ""
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
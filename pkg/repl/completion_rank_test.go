@@ -0,0 +1,58 @@
+package repl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+const rankTestMetrics = `# TYPE api_requests_total counter
+api_requests_total{code="200"} 10
+# TYPE api_latency_seconds histogram
+api_latency_seconds_bucket{le="0.1"} 3
+api_latency_seconds_bucket{le="+Inf"} 5
+api_latency_seconds_sum 1.2
+api_latency_seconds_count 5
+# TYPE api_temperature gauge
+api_temperature 21.5
+`
+
+func TestRankMetricsForFunction(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	if err := store.LoadFromReader(strings.NewReader(rankTestMetrics)); err != nil {
+		t.Fatalf("LoadFromReader failed: %v", err)
+	}
+	if got := metricTypeOf(store.MetricsType, "api_latency_seconds_count"); got != "histogram" {
+		t.Fatalf("expected histogram for a _count series, got %q", got)
+	}
+
+	all := []string{"api_latency_seconds_bucket", "api_latency_seconds_count", "api_latency_seconds_sum", "api_requests_total", "api_temperature"}
+	for fn, first := range map[string]string{
+		"rate":               "api_requests_total",
+		"histogram_quantile": "api_latency_seconds_bucket",
+		"avg_over_time":      "api_temperature",
+		"abs":                "api_latency_seconds_bucket",
+	} {
+		got := rankMetricsForFunction(append([]string(nil), all...), store.MetricsType, fn)
+		if got[0] != first {
+			t.Errorf("%s(: expected %s first, got %v", fn, first, got)
+		}
+	}
+
+	ac := NewPrometheusAutoCompleter(store)
+	for line, want := range map[string][]string{
+		"sum(rate(api_": {"api_requests_total"},
+		"rate(":         {"api_requests_total"},
+	} {
+		got := ac.getCompletions(line, len(line), strings.TrimPrefix(line[strings.LastIndex(line, "(")+1:], " "))
+		if len(got) == 0 || !reflect.DeepEqual(got[:len(want)], want) {
+			t.Errorf("%q: expected %v first, got %v", line, want, got)
+		}
+	}
+
+	if got := enclosingFunction("histogram_quantile(0.9, sum by (le) (rate(x[5m])), "); got != "histogram_quantile" {
+		t.Errorf("expected histogram_quantile, got %q", got)
+	}
+}
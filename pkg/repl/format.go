@@ -0,0 +1,561 @@
+package repl
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// Formatter renders a query result to a writer.
+type Formatter interface {
+	Format(w io.Writer, result *promql.Result) error
+}
+
+// FormatterFunc adapts a function to the Formatter interface.
+type FormatterFunc func(w io.Writer, result *promql.Result) error
+
+func (f FormatterFunc) Format(w io.Writer, result *promql.Result) error { return f(w, result) }
+
+// formatters holds the registered output formats, keyed by name.
+var formatters = map[string]Formatter{
+	"text": FormatterFunc(func(w io.Writer, result *promql.Result) error {
+		writeResultText(w, result, resultHighlight())
+		return nil
+	}),
+	"json": FormatterFunc(func(w io.Writer, result *promql.Result) error {
+		return PrintResultJSONToWriter(result, w)
+	}),
+	"table":    FormatterFunc(formatTable),
+	"csv":      FormatterFunc(func(w io.Writer, result *promql.Result) error { return formatDelimited(w, result, ',') }),
+	"tsv":      FormatterFunc(func(w io.Writer, result *promql.Result) error { return formatDelimited(w, result, '\t') }),
+	"markdown": FormatterFunc(formatMarkdown),
+	"jsonl":    FormatterFunc(formatJSONL),
+}
+
+// outputFormat is the active output format for query results (set via .format or -o).
+var outputFormat = "text"
+
+// RegisterFormatter adds (or replaces) a named output format.
+func RegisterFormatter(name string, f Formatter) {
+	formatters[strings.ToLower(name)] = f
+}
+
+// FormatNames returns the registered output format names, sorted.
+func FormatNames() []string {
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetOutputFormat selects the output format used by PrintResult.
+func SetOutputFormat(name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = "text"
+	}
+	if _, ok := formatters[name]; !ok {
+		return fmt.Errorf("unknown output format %q (available: %s)", name, strings.Join(FormatNames(), ", "))
+	}
+	outputFormat = name
+	return nil
+}
+
+// PrintResult renders result to stdout using the active output format, sort order and limit.
+func PrintResult(result *promql.Result) error {
+	return renderResult(os.Stdout, result)
+}
+
+// printResult is PrintResult for REPL use, reporting render errors inline. Output taller
+// than the terminal goes through the pager; matrices that tall are summarized and streamed
+// to it (see printLargeMatrix).
+func printResult(result *promql.Result) {
+	if m, ok := result.Value.(promql.Matrix); ok {
+		if rows, tty := terminalHeight(); tty && matrixLines(m) >= rows-1 {
+			printLargeMatrix(result, m)
+			return
+		}
+	}
+	var buf bytes.Buffer
+	err := renderResult(&buf, result)
+	pageOutput(buf.Bytes())
+	if err != nil {
+		fmt.Printf("Error rendering %s output: %v\n", outputFormat, err)
+	}
+}
+
+// renderResult sorts and limits result, then formats it to w. The note about series left
+// out by the limit goes to stderr for machine-readable formats, so it can't corrupt them.
+func renderResult(w io.Writer, result *promql.Result) error {
+	result, omitted := arrangeResult(result, resultSort, resultLimit)
+	f := formatters[outputFormat]
+	if resultGroupBy != "" && (outputFormat == "text" || outputFormat == "table") {
+		f = FormatterFunc(func(w io.Writer, result *promql.Result) error {
+			return formatGrouped(w, result, resultGroupBy, formatters[outputFormat])
+		})
+	}
+	if err := f.Format(w, result); err != nil {
+		return err
+	}
+	if omitted > 0 {
+		note := w
+		if outputFormat != "text" && outputFormat != "table" {
+			note = os.Stderr
+		}
+		mustFprintf(note, "... %d more series not shown (limit %d, see .limit)\n", omitted, resultLimit)
+	}
+	return nil
+}
+
+// ResultSort orders vector and matrix results before printing.
+type ResultSort struct {
+	By   string // "value", "metric", or empty to keep the engine's order
+	Desc bool
+}
+
+// String describes the sort order, e.g. "value desc".
+func (o ResultSort) String() string {
+	if o.By == "" {
+		return "none"
+	}
+	if o.Desc {
+		return o.By + " desc"
+	}
+	return o.By + " asc"
+}
+
+var (
+	// resultSort and resultLimit are applied by PrintResult (set via .sort/.limit or --sort/--limit).
+	resultSort  ResultSort
+	resultLimit int
+)
+
+// SetResultSort parses "value|metric [asc|desc]", or "none" to keep the engine's order.
+// Sorting by value defaults to descending, by metric to ascending.
+func SetResultSort(spec string) error {
+	o, err := parseResultSort(spec)
+	if err != nil {
+		return err
+	}
+	resultSort = o
+	return nil
+}
+
+// parseResultSort parses a sort order as SetResultSort does.
+func parseResultSort(spec string) (ResultSort, error) {
+	fields := strings.Fields(strings.ToLower(spec))
+	if len(fields) == 0 || len(fields) > 2 {
+		return ResultSort{}, fmt.Errorf("expected value|metric [asc|desc] or none")
+	}
+	var o ResultSort
+	switch fields[0] {
+	case "none", "off":
+		if len(fields) > 1 {
+			return o, fmt.Errorf("unexpected %q after %s", fields[1], fields[0])
+		}
+		return o, nil
+	case "value", "metric":
+		o = ResultSort{By: fields[0], Desc: fields[0] == "value"}
+	default:
+		return o, fmt.Errorf("unknown sort key %q (expected value or metric)", fields[0])
+	}
+	if len(fields) == 2 {
+		switch fields[1] {
+		case "asc":
+			o.Desc = false
+		case "desc":
+			o.Desc = true
+		default:
+			return o, fmt.Errorf("unknown sort direction %q (expected asc or desc)", fields[1])
+		}
+	}
+	return o, nil
+}
+
+// SetResultLimit caps the number of series printed per result; 0 disables the limit.
+func SetResultLimit(n int) error {
+	if n < 0 {
+		return fmt.Errorf("limit must be 0 (no limit) or positive, got %d", n)
+	}
+	resultLimit = n
+	return nil
+}
+
+// arrangeResult returns result with its vector samples or matrix series sorted by o and
+// cut to limit (0: no limit), and the number of series cut. Matrices are sorted by the last
+// value of each series. The input result is not modified.
+func arrangeResult(result *promql.Result, o ResultSort, limit int) (*promql.Result, int) {
+	// less compares by labels or by value; ok is false for samples without a float value,
+	// which sort after all others, as do NaNs.
+	less := func(la, lb labels.Labels, fa, fb float64, oka, okb bool) bool {
+		if o.By == "metric" {
+			if o.Desc {
+				return labels.Compare(la, lb) > 0
+			}
+			return labels.Compare(la, lb) < 0
+		}
+		oka, okb = oka && !math.IsNaN(fa), okb && !math.IsNaN(fb)
+		if oka != okb {
+			return oka
+		}
+		if !oka || fa == fb {
+			return false
+		}
+		return (fa > fb) == o.Desc
+	}
+	switch v := result.Value.(type) {
+	case promql.Vector:
+		if o.By == "" && (limit <= 0 || len(v) <= limit) {
+			return result, 0
+		}
+		v = append(promql.Vector(nil), v...)
+		if o.By != "" {
+			sort.SliceStable(v, func(i, j int) bool {
+				return less(v[i].Metric, v[j].Metric, v[i].F, v[j].F, v[i].H == nil, v[j].H == nil)
+			})
+		}
+		omitted := 0
+		if limit > 0 && len(v) > limit {
+			omitted, v = len(v)-limit, v[:limit]
+		}
+		return &promql.Result{Value: v, Warnings: result.Warnings}, omitted
+	case promql.Matrix:
+		if o.By == "" && (limit <= 0 || len(v) <= limit) {
+			return result, 0
+		}
+		m := append(promql.Matrix(nil), v...)
+		last := func(s promql.Series) (float64, bool) {
+			if len(s.Floats) == 0 {
+				return 0, false
+			}
+			return s.Floats[len(s.Floats)-1].F, true
+		}
+		if o.By != "" {
+			sort.SliceStable(m, func(i, j int) bool {
+				fi, oki := last(m[i])
+				fj, okj := last(m[j])
+				return less(m[i].Metric, m[j].Metric, fi, fj, oki, okj)
+			})
+		}
+		omitted := 0
+		if limit > 0 && len(m) > limit {
+			omitted, m = len(m)-limit, m[:limit]
+		}
+		return &promql.Result{Value: m, Warnings: result.Warnings}, omitted
+	}
+	return result, 0
+}
+
+// resultGroupBy is the label vector results are grouped by in the text and table formats
+// (set via .groupby); empty prints them as one list.
+var resultGroupBy string
+
+// resultGroup holds the samples of a vector sharing the value of the grouping label.
+type resultGroup struct {
+	Value   string
+	Samples promql.Vector
+}
+
+// groupVector splits v by the value of label, keeping the order of the samples within each
+// group. Groups are ordered by value, like sort_by_label(), with the samples lacking the
+// label last.
+func groupVector(v promql.Vector, label string) []resultGroup {
+	index := map[string]int{}
+	var groups []resultGroup
+	for _, s := range v {
+		value := s.Metric.Get(label)
+		i, ok := index[value]
+		if !ok {
+			i = len(groups)
+			index[value] = i
+			groups = append(groups, resultGroup{Value: value})
+		}
+		groups[i].Samples = append(groups[i].Samples, s)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if (groups[i].Value == "") != (groups[j].Value == "") {
+			return groups[j].Value == ""
+		}
+		return groups[i].Value < groups[j].Value
+	})
+	return groups
+}
+
+// formatGrouped renders a vector result as one section per value of label, each headed by
+// the value and its sample count and rendered by f (text samples are listed directly).
+// Other results are rendered by f as they are.
+func formatGrouped(w io.Writer, result *promql.Result, label string, f Formatter) error {
+	v, ok := result.Value.(promql.Vector)
+	if !ok || len(v) == 0 {
+		return f.Format(w, result)
+	}
+	groups := groupVector(v, label)
+	mustFprintf(w, "Vector (%d samples) grouped by %s (%d groups):\n", len(v), label, len(groups))
+	for _, g := range groups {
+		header := fmt.Sprintf("%s=%q", label, g.Value)
+		if g.Value == "" {
+			header = "(no " + label + ")"
+		}
+		mustFprintf(w, "%s: %d samples\n", header, len(g.Samples))
+		if outputFormat == "text" {
+			writeVectorText(w, g.Samples, resultHighlight())
+			continue
+		}
+		if err := f.Format(w, &promql.Result{Value: g.Samples, Warnings: result.Warnings}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ColumnFilter limits the label columns shown by the table format.
+type ColumnFilter struct {
+	Mode   string // "keep" or "drop"; empty shows all labels
+	Labels []string
+}
+
+// tableColumns is the active ColumnFilter (set via .columns).
+var tableColumns ColumnFilter
+
+// String describes the filter, e.g. "keep job, instance".
+func (f ColumnFilter) String() string {
+	if f.Mode == "" {
+		return "all labels"
+	}
+	return f.Mode + " " + strings.Join(f.Labels, ", ")
+}
+
+// apply removes the filtered label columns from header and rows, leaving the trailing
+// timestamp and value columns, and returns the names of the hidden columns.
+func (f ColumnFilter) apply(header []string, rows [][]string) ([]string, [][]string, []string) {
+	if f.Mode == "" || len(header) <= 2 {
+		return header, rows, nil
+	}
+	listed := map[string]bool{}
+	for _, l := range f.Labels {
+		listed[l] = true
+	}
+	var keep []int
+	var hidden []string
+	for i, name := range header {
+		if i >= len(header)-2 || listed[name] == (f.Mode == "keep") {
+			keep = append(keep, i)
+		} else {
+			hidden = append(hidden, name)
+		}
+	}
+	if len(hidden) == 0 {
+		return header, rows, nil
+	}
+	pick := func(r []string) []string {
+		out := make([]string, len(keep))
+		for j, i := range keep {
+			out[j] = r[i]
+		}
+		return out
+	}
+	filtered := make([][]string, len(rows))
+	for i, r := range rows {
+		filtered[i] = pick(r)
+	}
+	return pick(header), filtered, hidden
+}
+
+// resultRows flattens a result into a header and rows: one column per label
+// (__name__ first), followed by timestamp and value. Matrices yield one row per point.
+// Timestamps are rendered by fmtTime and, with humanize, values by humanizeValue.
+func resultRows(result *promql.Result, fmtTime func(int64) string, humanize bool) ([]string, [][]string, error) {
+	fmtValue := func(_ labels.Labels, v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	if humanize {
+		fmtValue = func(ls labels.Labels, v float64) string { return humanizeValue(ls.Get(labels.MetricName), v) }
+	}
+
+	switch v := result.Value.(type) {
+	case promql.Vector:
+		lbls := make([]labels.Labels, len(v))
+		for i, s := range v {
+			lbls[i] = s.Metric
+		}
+		names := labelColumns(lbls)
+		var rows [][]string
+		for _, s := range v {
+			value := fmtValue(s.Metric, s.F)
+			if s.H != nil {
+				value = s.H.String()
+			}
+			rows = append(rows, append(labelCells(s.Metric, names), fmtTime(s.T), value))
+		}
+		return append(names, "timestamp", "value"), rows, nil
+	case promql.Matrix:
+		lbls := make([]labels.Labels, len(v))
+		for i, s := range v {
+			lbls[i] = s.Metric
+		}
+		names := labelColumns(lbls)
+		var rows [][]string
+		for _, s := range v {
+			cells := labelCells(s.Metric, names)
+			for _, p := range s.Floats {
+				rows = append(rows, append(append([]string{}, cells...), fmtTime(p.T), fmtValue(s.Metric, p.F)))
+			}
+			for _, p := range s.Histograms {
+				rows = append(rows, append(append([]string{}, cells...), fmtTime(p.T), p.H.String()))
+			}
+		}
+		return append(names, "timestamp", "value"), rows, nil
+	case promql.Scalar:
+		return []string{"timestamp", "value"}, [][]string{{fmtTime(v.T), fmtValue(labels.EmptyLabels(), v.V)}}, nil
+	case promql.String:
+		return []string{"timestamp", "value"}, [][]string{{fmtTime(v.T), v.V}}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported result type: %T", result.Value)
+	}
+}
+
+// labelColumns returns the union of label names, __name__ first and the rest sorted.
+func labelColumns(sets []labels.Labels) []string {
+	seen := map[string]bool{}
+	hasName := false
+	var names []string
+	for _, ls := range sets {
+		ls.Range(func(l labels.Label) {
+			if l.Name == labels.MetricName {
+				hasName = true
+				return
+			}
+			if !seen[l.Name] {
+				seen[l.Name] = true
+				names = append(names, l.Name)
+			}
+		})
+	}
+	sort.Strings(names)
+	if hasName {
+		names = append([]string{labels.MetricName}, names...)
+	}
+	return names
+}
+
+func labelCells(ls labels.Labels, names []string) []string {
+	cells := make([]string, len(names))
+	for i, n := range names {
+		cells[i] = ls.Get(n)
+	}
+	return cells
+}
+
+// formatTable renders an aligned, human-readable table.
+func formatTable(w io.Writer, result *promql.Result) error {
+	header, rows, err := resultRows(result, formatDisplayMillis, humanizeValues)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		mustFprintln(w, "No results found")
+		return nil
+	}
+	header, rows, hidden := tableColumns.apply(header, rows)
+	// Columns are padded by hand rather than with a tabwriter, which would count the
+	// highlight escapes as part of the cell width.
+	upper := make([]string, len(header))
+	for i, h := range header {
+		upper[i] = strings.ToUpper(h)
+	}
+	widths := make([]int, len(header))
+	for _, r := range append([][]string{upper}, rows...) {
+		for i, c := range r {
+			widths[i] = max(widths[i], utf8.RuneCountInString(c))
+		}
+	}
+	hl := resultHighlight()
+	writeRow := func(r []string, labelCells int) {
+		var b strings.Builder
+		for i, c := range r {
+			if i < labelCells {
+				b.WriteString(highlightString(hl, c))
+			} else {
+				b.WriteString(c)
+			}
+			if i < len(r)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c)+2))
+			}
+		}
+		mustFprintln(w, b.String())
+	}
+	writeRow(upper, 0)
+	for _, r := range rows {
+		// The last two columns are the timestamp and the value.
+		writeRow(r, len(r)-2)
+	}
+	if len(hidden) > 0 {
+		mustFprintf(w, "... %d label columns hidden (%s); see .columns\n", len(hidden), strings.Join(hidden, ", "))
+	}
+	return nil
+}
+
+// formatDelimited renders CSV (sep=',') or TSV (sep='\t') with a header row. Timestamps
+// are RFC3339 in UTC whatever .tz and .time_format, as they are meant for other tools.
+func formatDelimited(w io.Writer, result *promql.Result, sep rune) error {
+	header, rows, err := resultRows(result, rfc3339Millis, false)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = sep
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// formatMarkdown renders a GitHub-flavored Markdown table with padded columns, with the
+// timestamps of formatDelimited.
+func formatMarkdown(w io.Writer, result *promql.Result) error {
+	header, rows, err := resultRows(result, rfc3339Millis, false)
+	if err != nil {
+		return err
+	}
+	escape := strings.NewReplacer("|", `\|`, "\n", " ")
+	cells := make([][]string, 0, len(rows)+1)
+	cells = append(cells, header)
+	cells = append(cells, rows...)
+	widths := make([]int, len(header))
+	for _, r := range cells {
+		for i := range r {
+			r[i] = escape.Replace(r[i])
+			widths[i] = max(widths[i], utf8.RuneCountInString(r[i]), 3)
+		}
+	}
+	writeRow := func(r []string) {
+		padded := make([]string, len(r))
+		for i, c := range r {
+			padded[i] = c + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c))
+		}
+		mustFprintln(w, "| "+strings.Join(padded, " | ")+" |")
+	}
+	writeRow(cells[0])
+	sep := make([]string, len(widths))
+	for i, n := range widths {
+		sep[i] = strings.Repeat("-", n)
+	}
+	writeRow(sep)
+	for _, r := range cells[1:] {
+		writeRow(r)
+	}
+	return nil
+}
@@ -0,0 +1,449 @@
+package repl
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func testVectorResult() *promql.Result {
+	return &promql.Result{Value: promql.Vector{
+		{Metric: labels.FromStrings("__name__", "up", "job", "node"), T: 1700000000000, F: 1},
+		{Metric: labels.FromStrings("__name__", "up", "instance", "a,b", "job", "db"), T: 1700000000000, F: 0.5},
+	}}
+}
+
+func TestFormat_CSV_Vector(t *testing.T) {
+	var buf bytes.Buffer
+	if err := formatters["csv"].Format(&buf, testVectorResult()); err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	want := "__name__,instance,job,timestamp,value\n" +
+		"up,,node,2023-11-14T22:13:20Z,1\n" +
+		"up,\"a,b\",db,2023-11-14T22:13:20Z,0.5\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected csv:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestFormat_TSV_Matrix(t *testing.T) {
+	res := &promql.Result{Value: promql.Matrix{
+		{Metric: labels.FromStrings("job", "node"), Floats: []promql.FPoint{{T: 0, F: 1}, {T: 60000, F: 2}}},
+	}}
+	var buf bytes.Buffer
+	if err := formatters["tsv"].Format(&buf, res); err != nil {
+		t.Fatalf("tsv: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[0] != "job\ttimestamp\tvalue" || lines[2] != "node\t1970-01-01T00:01:00Z\t2" {
+		t.Fatalf("unexpected tsv: %q", buf.String())
+	}
+}
+
+func TestFormat_Table_AlignsColumns(t *testing.T) {
+	var buf bytes.Buffer
+	if err := formatters["table"].Format(&buf, testVectorResult()); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "__NAME__") {
+		t.Fatalf("unexpected table: %q", buf.String())
+	}
+	col := strings.Index(lines[0], "JOB")
+	if strings.Index(lines[1], "node") != col || strings.Index(lines[2], "db") != col {
+		t.Fatalf("columns not aligned: %q", buf.String())
+	}
+}
+
+func TestFormat_Markdown_Vector(t *testing.T) {
+	res := testVectorResult()
+	res.Value = append(res.Value.(promql.Vector), promql.Sample{Metric: labels.FromStrings("__name__", "up", "job", "a|b"), T: 1700000000000, F: 2})
+	var buf bytes.Buffer
+	if err := formatters["markdown"].Format(&buf, res); err != nil {
+		t.Fatalf("markdown: %v", err)
+	}
+	want := "| __name__ | instance | job  | timestamp            | value |\n" +
+		"| -------- | -------- | ---- | -------------------- | ----- |\n" +
+		"| up       |          | node | 2023-11-14T22:13:20Z | 1     |\n" +
+		"| up       | a,b      | db   | 2023-11-14T22:13:20Z | 0.5   |\n" +
+		"| up       |          | a\\|b | 2023-11-14T22:13:20Z | 2     |\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected markdown:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestFormat_Markdown_PadsByRunes(t *testing.T) {
+	res := &promql.Result{Value: promql.Vector{
+		{Metric: labels.FromStrings("city", "Zürich"), T: 1700000000000, F: 1},
+		{Metric: labels.FromStrings("city", "Bern"), T: 1700000000000, F: 2},
+	}}
+	var buf bytes.Buffer
+	if err := formatters["markdown"].Format(&buf, res); err != nil {
+		t.Fatalf("markdown: %v", err)
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if lines[2] != "| Zürich | 2023-11-14T22:13:20Z | 1     |" || lines[3] != "| Bern   | 2023-11-14T22:13:20Z | 2     |" {
+		t.Fatalf("unexpected markdown padding:\n%s", buf.String())
+	}
+}
+
+func TestFormat_JSONL(t *testing.T) {
+	var buf bytes.Buffer
+	if err := formatters["jsonl"].Format(&buf, testVectorResult()); err != nil {
+		t.Fatalf("jsonl: %v", err)
+	}
+	want := `{"metric":{"__name__":"up","job":"node"},"value":[1700000000,1]}` + "\n" +
+		`{"metric":{"__name__":"up","instance":"a,b","job":"db"},"value":[1700000000,0.5]}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected jsonl:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	res := &promql.Result{Value: promql.Matrix{
+		{Metric: labels.FromStrings("job", "node"), Floats: []promql.FPoint{{T: 0, F: 1}, {T: 60000, F: 2}}},
+	}}
+	if err := formatters["jsonl"].Format(&buf, res); err != nil {
+		t.Fatalf("jsonl: %v", err)
+	}
+	if want := `{"metric":{"job":"node"},"values":[[0,1],[60,2]]}` + "\n"; buf.String() != want {
+		t.Fatalf("unexpected jsonl matrix: %s", buf.String())
+	}
+
+	defer func() { outputFormat = "text" }()
+	store := newTestStore(t)
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".format jsonl", store) })
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".values code", store) })
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if !strings.HasPrefix(line, `{"value":`) || !strings.Contains(line, `"series":`) {
+			t.Fatalf("expected a JSON line per label value, got: %s", out)
+		}
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".metrics", store) })
+	if !strings.HasPrefix(out, `{"name":"http_requests_total","type":"counter","samples":2}`+"\n") {
+		t.Fatalf("expected JSON lines from .metrics, got: %s", out)
+	}
+}
+
+func TestAdhoc_Format_SetAndApply(t *testing.T) {
+	defer func() { outputFormat = "text" }()
+	store := newTestStore(t)
+	engine := newTestEngine()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".format csv", store) })
+	if !strings.Contains(out, "Output format: csv") {
+		t.Fatalf("expected format confirmation, got: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(engine, store, "scalar(vector(3))") })
+	if !strings.HasPrefix(out, "timestamp,value\n") || !strings.Contains(out, ",3\n") {
+		t.Fatalf("expected csv scalar output, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".format yaml", sstorage.NewSimpleStorage()) })
+	if !strings.Contains(out, "unknown output format") || outputFormat != "csv" {
+		t.Fatalf("expected rejection of unknown format, got: %s", out)
+	}
+}
+
+func TestFormat_NativeHistogram(t *testing.T) {
+	defer func() { outputFormat = "text" }()
+	store := sstorage.NewSimpleStorage()
+	h := &histogram.FloatHistogram{
+		Count:           4,
+		Sum:             3,
+		Schema:          0,
+		PositiveSpans:   []histogram.Span{{Offset: 0, Length: 2}},
+		PositiveBuckets: []float64{1, 3},
+	}
+	store.AddHistogramSample(map[string]string{"__name__": "rpc_seconds", "job": "api"}, h, time.Now().Add(-time.Second).UnixMilli())
+	engine := newTestEngine()
+
+	out := captureStdout(t, func() { executeOne(engine, store, "rpc_seconds") })
+	if !strings.Contains(out, `job="api"} => {count:4, sum:3`) {
+		t.Fatalf("expected histogram in text output, got: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(engine, store, "histogram_count(rpc_seconds)") })
+	if !strings.Contains(out, "=> 4 @") {
+		t.Fatalf("expected histogram_count result, got: %s", out)
+	}
+
+	outputFormat = "json"
+	out = captureStdout(t, func() { executeOne(engine, store, "rpc_seconds") })
+	if !strings.Contains(out, `"histogram":[`) || !strings.Contains(out, `"count":"4"`) || !strings.Contains(out, `[0,"1","2","3"]`) {
+		t.Fatalf("expected histogram in JSON output, got: %s", out)
+	}
+
+	outputFormat = "csv"
+	out = captureStdout(t, func() { executeOne(engine, store, "rpc_seconds[1m]") })
+	if !strings.HasPrefix(out, "__name__,job,timestamp,value\n") || !strings.Contains(out, "rpc_seconds,api,") || !strings.Contains(out, "{count:4") {
+		t.Fatalf("expected histogram row in csv output, got: %s", out)
+	}
+}
+
+func TestAdhoc_Columns_Table(t *testing.T) {
+	defer func() { tableColumns = ColumnFilter{} }()
+	store := sstorage.NewSimpleStorage()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".columns keep job, __name__", store) })
+	if !strings.Contains(out, "Table columns: keep job, __name__") {
+		t.Fatalf("expected columns confirmation, got: %s", out)
+	}
+	var buf bytes.Buffer
+	if err := formatters["table"].Format(&buf, testVectorResult()); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 4 || strings.Join(strings.Fields(lines[0]), " ") != "__NAME__ JOB TIMESTAMP VALUE" ||
+		lines[3] != "... 1 label columns hidden (instance); see .columns" {
+		t.Fatalf("unexpected table: %q", buf.String())
+	}
+
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".columns drop instance,job", store) })
+	buf.Reset()
+	_ = formatters["table"].Format(&buf, testVectorResult())
+	if strings.Contains(buf.String(), "JOB") || !strings.Contains(buf.String(), "2 label columns hidden (instance, job)") {
+		t.Fatalf("unexpected table: %q", buf.String())
+	}
+	buf.Reset()
+	_ = formatters["csv"].Format(&buf, testVectorResult())
+	if !strings.HasPrefix(buf.String(), "__name__,instance,job,") {
+		t.Fatalf("expected csv to keep all columns: %q", buf.String())
+	}
+
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".columns reset", store) })
+	buf.Reset()
+	_ = formatters["table"].Format(&buf, testVectorResult())
+	if strings.Contains(buf.String(), "hidden") {
+		t.Fatalf("expected all columns after reset: %q", buf.String())
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".columns keep", store) })
+	if !strings.Contains(out, "Usage: .columns") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+}
+
+func TestArrangeResult_SortAndLimit(t *testing.T) {
+	v := promql.Vector{
+		{Metric: labels.FromStrings("job", "b"), F: 2},
+		{Metric: labels.FromStrings("job", "c"), F: math.NaN()},
+		{Metric: labels.FromStrings("job", "a"), F: 3},
+		{Metric: labels.FromStrings("job", "d"), F: 1},
+	}
+	jobs := func(r *promql.Result) string {
+		var out []string
+		for _, s := range r.Value.(promql.Vector) {
+			out = append(out, s.Metric.Get("job"))
+		}
+		return strings.Join(out, ",")
+	}
+	res := &promql.Result{Value: v}
+	if got, _ := arrangeResult(res, ResultSort{By: "value", Desc: true}, 0); jobs(got) != "a,b,d,c" {
+		t.Fatalf("value desc: got %s", jobs(got))
+	}
+	if got, _ := arrangeResult(res, ResultSort{By: "value"}, 0); jobs(got) != "d,b,a,c" {
+		t.Fatalf("value asc: got %s", jobs(got))
+	}
+	got, omitted := arrangeResult(res, ResultSort{By: "metric"}, 2)
+	if jobs(got) != "a,b" || omitted != 2 {
+		t.Fatalf("metric asc limit 2: got %s, %d omitted", jobs(got), omitted)
+	}
+	if jobs(res) != "b,c,a,d" {
+		t.Fatalf("input modified: %s", jobs(res))
+	}
+
+	m := promql.Matrix{
+		{Metric: labels.FromStrings("job", "x"), Floats: []promql.FPoint{{T: 1, F: 9}, {T: 2, F: 1}}},
+		{Metric: labels.FromStrings("job", "y"), Floats: []promql.FPoint{{T: 1, F: 0}, {T: 2, F: 5}}},
+	}
+	got, _ = arrangeResult(&promql.Result{Value: m}, ResultSort{By: "value", Desc: true}, 1)
+	if gm := got.Value.(promql.Matrix); len(gm) != 1 || gm[0].Metric.Get("job") != "y" {
+		t.Fatalf("expected matrix sorted by last value: %v", gm)
+	}
+}
+
+func TestAdhoc_SortLimit(t *testing.T) {
+	defer func() { resultSort, resultLimit = ResultSort{}, 0 }()
+	store := sstorage.NewSimpleStorage()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".sort value", store) })
+	if !strings.Contains(out, "Result sort: value desc") {
+		t.Fatalf("expected sort confirmation, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".sort labels", store) })
+	if !strings.Contains(out, "Usage: .sort") || resultSort.String() != "value desc" {
+		t.Fatalf("expected usage and unchanged sort, got: %s (%s)", out, resultSort)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".limit 1", store) })
+	if !strings.Contains(out, "Result limit: 1 series") {
+		t.Fatalf("expected limit confirmation, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = PrintResult(testVectorResult()) })
+	if !strings.Contains(out, `job="node"`) || strings.Contains(out, `job="db"`) ||
+		!strings.Contains(out, "... 1 more series not shown (limit 1, see .limit)") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".limit off", store) })
+	if !strings.Contains(out, "Result limit: none") || resultLimit != 0 {
+		t.Fatalf("expected no limit, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".limit -3", store) })
+	if !strings.Contains(out, "Usage: .limit") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+}
+
+func TestAdhoc_GroupBy(t *testing.T) {
+	defer func() { resultGroupBy, outputFormat = "", "text" }()
+	store := sstorage.NewSimpleStorage()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".groupby instance", store) })
+	if !strings.Contains(out, "Group by: instance") || resultGroupBy != "instance" {
+		t.Fatalf("expected group by confirmation, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = PrintResult(testVectorResult()) })
+	want := "Vector (2 samples) grouped by instance (2 groups):\n" +
+		"instance=\"a,b\": 1 samples\n" +
+		"  [1] {__name__=\"up\", instance=\"a,b\", job=\"db\"} => 0.5 @ "
+	if !strings.HasPrefix(out, want) || !strings.Contains(out, "(no instance): 1 samples\n  [1] {__name__=\"up\", job=\"node\"}") {
+		t.Fatalf("unexpected grouped output: %s", out)
+	}
+
+	outputFormat = "table"
+	out = captureStdout(t, func() { _ = PrintResult(testVectorResult()) })
+	if strings.Count(out, "TIMESTAMP") != 2 || !strings.Contains(out, "(no instance): 1 samples") {
+		t.Fatalf("expected a table per group, got: %s", out)
+	}
+	outputFormat = "csv"
+	out = captureStdout(t, func() { _ = PrintResult(testVectorResult()) })
+	if strings.Contains(out, "grouped by") {
+		t.Fatalf("expected machine-readable formats to be left as they are, got: %s", out)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".groupby 1x", store) })
+	if !strings.Contains(out, "Usage: .groupby") || resultGroupBy != "instance" {
+		t.Fatalf("expected usage and unchanged grouping, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".groupby off", store) })
+	if !strings.Contains(out, "Group by: off") || resultGroupBy != "" {
+		t.Fatalf("expected grouping off, got: %s", out)
+	}
+}
+
+func TestAdhoc_Highlight(t *testing.T) {
+	defer func() { highlightPattern, colorMode = nil, "auto" }()
+	store := sstorage.NewSimpleStorage()
+	if err := SetColorMode("always"); err != nil {
+		t.Fatal(err)
+	}
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".highlight no.e", store) })
+	if !strings.Contains(out, "Highlight: ") || highlightPattern == nil {
+		t.Fatalf("expected highlight confirmation, got: %s", out)
+	}
+
+	var buf bytes.Buffer
+	_ = formatters["text"].Format(&buf, testVectorResult())
+	if !strings.Contains(buf.String(), `job="`+highlightStart+"node"+highlightReset+`"`) ||
+		!strings.Contains(buf.String(), `job="db"`) {
+		t.Fatalf("expected node highlighted: %q", buf.String())
+	}
+	buf.Reset()
+	_ = formatters["table"].Format(&buf, testVectorResult())
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	plain := strings.ReplaceAll(strings.ReplaceAll(lines[1], highlightStart, ""), highlightReset, "")
+	if !strings.Contains(lines[1], highlightStart+"node"+highlightReset) || strings.Index(plain, "2023") != strings.Index(lines[2], "2023") {
+		t.Fatalf("expected aligned table with node highlighted: %q", buf.String())
+	}
+	buf.Reset()
+	PrintUpstreamQueryResultToWriter(testVectorResult(), &buf)
+	if strings.Contains(buf.String(), highlightStart) {
+		t.Fatalf("expected no highlight outside of result printing: %q", buf.String())
+	}
+
+	_ = SetColorMode("auto")
+	t.Setenv("NO_COLOR", "")
+	buf.Reset()
+	_ = captureStdout(t, func() { _ = formatters["text"].Format(&buf, testVectorResult()) })
+	if strings.Contains(buf.String(), highlightStart) {
+		t.Fatalf("expected no colors with --color=auto when stdout isn't a terminal: %q", buf.String())
+	}
+
+	_ = SetColorMode("never")
+	buf.Reset()
+	_ = formatters["text"].Format(&buf, testVectorResult())
+	if strings.Contains(buf.String(), highlightStart) {
+		t.Fatalf("expected no colors with --color=never: %q", buf.String())
+	}
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".highlight off", store) })
+	if highlightPattern != nil {
+		t.Fatalf("expected highlight off")
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".highlight (", store) })
+	if !strings.Contains(out, "invalid regex") {
+		t.Fatalf("expected regex error, got: %s", out)
+	}
+	if err := SetColorMode("sometimes"); err == nil {
+		t.Fatalf("expected invalid color mode error")
+	}
+}
+
+func TestHumanizeValue(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		v    float64
+		want string
+	}{
+		{"http_requests_total", 1234567, "1.23M"},
+		{"up", 1, "1"},
+		{"ratio", 0.0123, "0.0123"},
+		{"node_memory_MemTotal_bytes", 3.4 * 1024 * 1024 * 1024, "3.4GiB"},
+		{"container_fs_reads_bytes_total", 512, "512B"},
+		{"process_cpu_seconds_total", 3725.5, "1h 2m 6s"},
+		{"http_request_duration_seconds", 0.0042, "4.2ms"},
+		{"process_start_time_seconds", 1700000000, "2023-11-14T22:13:20Z"},
+		{"up", math.NaN(), "NaN"},
+	} {
+		if got := humanizeValue(tc.name, tc.v); got != tc.want {
+			t.Errorf("humanizeValue(%q, %v) = %q, want %q", tc.name, tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestAdhoc_Humanize_Table(t *testing.T) {
+	defer SetHumanize(false)
+	store := sstorage.NewSimpleStorage()
+	res := &promql.Result{Value: promql.Vector{
+		{Metric: labels.FromStrings("__name__", "node_memory_MemTotal_bytes"), T: 1700000000000, F: 2048},
+	}}
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".humanize on", store) })
+	if !strings.Contains(out, "Humanize: on") {
+		t.Fatalf("expected humanize confirmation, got: %s", out)
+	}
+	var buf bytes.Buffer
+	_ = formatters["table"].Format(&buf, res)
+	if !strings.Contains(buf.String(), "2KiB") {
+		t.Fatalf("expected a humanized table value: %q", buf.String())
+	}
+	buf.Reset()
+	_ = formatters["csv"].Format(&buf, res)
+	if !strings.HasSuffix(buf.String(), ",2048\n") {
+		t.Fatalf("expected csv to keep raw values: %q", buf.String())
+	}
+
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".humanize off", store) })
+	buf.Reset()
+	_ = formatters["table"].Format(&buf, res)
+	if !strings.Contains(buf.String(), "2048") {
+		t.Fatalf("expected raw table values after .humanize off: %q", buf.String())
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".humanize maybe", store) })
+	if !strings.Contains(out, "Usage: .humanize") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+}
@@ -0,0 +1,138 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// KeyBinding is a keyboard shortcut of the REPL.
+type KeyBinding struct {
+	Keys   string
+	Action string
+}
+
+// KeyBindings lists the REPL keyboard shortcuts. Like AdHocCommands, it is the source of
+// truth for .help keys, promql-cli help and the man page.
+var KeyBindings = []KeyBinding{
+	{"Ctrl-A / Ctrl-E", "Jump to the start/end of the line"},
+	{"Alt-B / Alt-F", "Move backward/forward one word"},
+	{"Up / Down", "Previous/next history entry starting with the typed prefix"},
+	{"Ctrl-R", "Search history for entries containing the typed text (repeat for older matches)"},
+	{"Alt-.", "Insert the last argument of the previous command (repeat to cycle)"},
+	{"Ctrl-K / Ctrl-U", "Delete to the end/start of the line"},
+	{"Ctrl-W / Ctrl-Backspace", "Delete the previous word, stopping at PromQL delimiters such as (){},."},
+	{"Alt-D / Alt-Backspace", "Delete the next/previous word"},
+	{"Ctrl-T", "Transpose the characters before the cursor"},
+	{"Alt-U / Alt-L / Alt-C", "Uppercase/lowercase/capitalize the word"},
+	{"Enter", "Run the query, or continue it on the next line while a (, [ or { is unclosed"},
+	{"\\ at end of line", "Continue the query on the next line"},
+	{"Alt-Enter / Alt-J", "Insert a literal newline"},
+	{"Tab", "Complete metric names, labels, values, functions and ad-hoc commands"},
+	{"Ctrl-Y", "Paste the AI suggestion prepared by .ai edit (or the first one)"},
+	{"Alt-1..Alt-9", "Paste the corresponding AI suggestion"},
+	{"Ctrl-X Ctrl-E", "Edit the line in $PROMQL_EDITOR, $VISUAL or $EDITOR"},
+	{"Ctrl-L", "Clear the screen"},
+	{"Ctrl-C", "Cancel the running AI request or command, or clear the line"},
+	{"Ctrl-D", "Delete the character under the cursor, or exit on an empty line"},
+}
+
+// EnvVar is an environment variable read by promql-cli.
+type EnvVar struct {
+	Name        string
+	Description string
+}
+
+// EnvVars lists the environment variables read by promql-cli, for .help env, promql-cli
+// help and the man page.
+var EnvVars = []EnvVar{
+	{"PROMQL_CLI_CONFIG", "Config file read instead of ~/.promql-cli.yaml (--config)"},
+	{"PROMQL_CLI_HISTORY", "REPL history file (default: ~/.promql-cli_history)"},
+	{"PROMQL_CLI_AI", "AI options as key=value pairs, as --ai"},
+	{"PROMQL_CLI_AI_PROVIDER", "AI provider: openai|openai-compat|azure|claude|grok|ollama"},
+	{"PROMQL_CLI_AI_PROFILE", "AI profile of the config file to use"},
+	{"PROMQL_CLI_AI_NUM", "Number of AI suggestions to ask for"},
+	{"PROMQL_CLI_AI_DEBUG", "Print AI requests and responses when set to true"},
+	{"OPENAI_API_KEY", "OpenAI API key"},
+	{"PROMQL_CLI_OPENAI_BASE, PROMQL_CLI_OPENAI_MODEL", "OpenAI API base URL and model"},
+	{"PROMQL_CLI_OPENAI_COMPAT_BASE, PROMQL_CLI_OPENAI_COMPAT_MODEL", "OpenAI-compatible server (llama.cpp, vLLM, LM Studio) base URL and model"},
+	{"PROMQL_CLI_OPENAI_COMPAT_API_KEY", "API key of the OpenAI-compatible server, if it needs one"},
+	{"AZURE_OPENAI_API_KEY", "Azure OpenAI API key"},
+	{"AZURE_OPENAI_ENDPOINT, AZURE_OPENAI_DEPLOYMENT, AZURE_OPENAI_API_VERSION", "Azure OpenAI resource endpoint, deployment and API version"},
+	{"ANTHROPIC_API_KEY", "Anthropic (Claude) API key"},
+	{"PROMQL_CLI_ANTHROPIC_BASE, PROMQL_CLI_ANTHROPIC_MODEL", "Anthropic API base URL and model"},
+	{"XAI_API_KEY", "xAI (Grok) API key"},
+	{"PROMQL_CLI_XAI_BASE, PROMQL_CLI_XAI_MODEL", "xAI API base URL and model"},
+	{"PROMQL_CLI_OLLAMA_HOST, PROMQL_CLI_OLLAMA_MODEL", "Ollama host and model"},
+	{"PROMQL_CLI_ALT_DOT_KEY", "Code point (or character) sent by Alt-. on terminals without meta-as-ESC (config keys.alt_dot_key)"},
+	{"PROMQL_CLI_EAGER_COMPLETION", "Show completions while typing, not only on Tab (config keys.eager_completion)"},
+	{"PROMQL_CLI_COMPLETION_AUTO_BRACE", "Append { to a uniquely completed metric name (config keys.completion_auto_brace)"},
+	{"PROMQL_CLI_COMPLETION_LABEL_EQUALS", "Insert = after a completed label name (config keys.completion_label_equals)"},
+	{"PROMQL_CLI_COMPLETION_AUTO_CLOSE_QUOTE", "Append the closing quote to a completed label value (config keys.completion_auto_close_quote)"},
+	{"PROMQL_CLI_SYNTAX_HIGHLIGHT", "Highlight PromQL syntax while typing (config keys.syntax_highlight)"},
+	{"PROMQL_CLI_DEBUG_KEYS", "Print the key codes received by the readline REPL when set to 1"},
+	{"PROMQL_CLI_KUBECTL", "kubectl binary used by .k8s_scrape"},
+	{"PROMQL_EDITOR, VISUAL, EDITOR", "Editor opened by Ctrl-X Ctrl-E, first one set"},
+	{"PAGER", "Pager for output longer than the terminal (default: less -R); empty or cat to disable"},
+	{"NO_COLOR", "Disable ANSI colors with --color=auto"},
+	{"COLUMNS", "Output width (e.g. of .graph) when the terminal size is unknown"},
+}
+
+// FindAdHocCommand returns the ad-hoc command named name, with or without its leading dot.
+func FindAdHocCommand(name string) *AdHocCommand {
+	if !strings.HasPrefix(name, ".") {
+		name = "." + name
+	}
+	return GetAdHocCommandByName(name)
+}
+
+// WriteAdHocCommandHelp writes the usage, description and examples of cmd.
+func WriteAdHocCommandHelp(w io.Writer, cmd AdHocCommand) {
+	mustFprintf(w, "  %s\n", cmd.Usage)
+	mustFprintf(w, "    %s\n", cmd.Description)
+	switch len(cmd.Examples) {
+	case 0:
+	case 1:
+		mustFprintf(w, "    Example: %s\n", cmd.Examples[0])
+	default:
+		mustFprintf(w, "    Examples:\n")
+		for _, ex := range cmd.Examples {
+			mustFprintf(w, "      %s\n", ex)
+		}
+	}
+}
+
+// WriteKeyBindings writes KeyBindings as an aligned list.
+func WriteKeyBindings(w io.Writer) {
+	width := 0
+	for _, k := range KeyBindings {
+		width = max(width, len(k.Keys))
+	}
+	for _, k := range KeyBindings {
+		mustFprintf(w, "  %-*s  %s\n", width, k.Keys, k.Action)
+	}
+}
+
+// WriteEnvVars writes EnvVars, each name followed by its indented description.
+func WriteEnvVars(w io.Writer) {
+	for _, e := range EnvVars {
+		mustFprintf(w, "  %s\n    %s\n", e.Name, e.Description)
+	}
+}
+
+// helpTopic writes the help of a .help topic: keys, env, or an ad-hoc command.
+func helpTopic(w io.Writer, topic string) error {
+	switch topic {
+	case "keys":
+		WriteKeyBindings(w)
+	case "env":
+		WriteEnvVars(w)
+	default:
+		cmd := FindAdHocCommand(topic)
+		if cmd == nil {
+			return fmt.Errorf("unknown help topic %q (expected an ad-hoc command, keys or env)", topic)
+		}
+		WriteAdHocCommandHelp(w, *cmd)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package repl
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// documentedKeys returns the keys of KeyBindings, one per alternative of an entry.
+func documentedKeys() map[string]bool {
+	keys := map[string]bool{}
+	for _, k := range KeyBindings {
+		for _, alt := range strings.Split(k.Keys, " / ") {
+			keys[alt] = true
+		}
+	}
+	return keys
+}
+
+// parseRepoFile parses a source file of the package.
+func parseRepoFile(t *testing.T, name string) *ast.File {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), name, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// chordKeys maps the first key of a chord to the documented chord.
+var chordKeys = map[string]string{"Ctrl-X": "Ctrl-X Ctrl-E"}
+
+// promptBindings returns the keys bound by the go-prompt REPL (KeyBind and ESC-prefixed
+// ASCIICodeBind), named as in KeyBindings.
+func promptBindings(t *testing.T) map[string]bool {
+	t.Helper()
+	bound := map[string]bool{}
+	ast.Inspect(parseRepoFile(t, "prompt_repl.go"), func(n ast.Node) bool {
+		kv, ok := n.(*ast.KeyValueExpr)
+		if !ok {
+			return true
+		}
+		field, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		switch field.Name {
+		case "Key":
+			sel, ok := kv.Value.(*ast.SelectorExpr)
+			if !ok {
+				t.Errorf("unexpected key binding %T", kv.Value)
+				return true
+			}
+			name := sel.Sel.Name
+			if rest, ok := strings.CutPrefix(name, "Control"); ok {
+				name = "Ctrl-" + rest
+			}
+			bound[name] = true
+		case "ASCIICode":
+			elts := kv.Value.(*ast.CompositeLit).Elts
+			if len(elts) != 2 {
+				t.Errorf("unexpected ASCII code binding of %d bytes", len(elts))
+				return true
+			}
+			lit, ok := elts[1].(*ast.BasicLit)
+			if !ok {
+				bound["Alt-1..Alt-9"] = true // bound in a loop over the digits
+				return true
+			}
+			code, err := strconv.ParseUint(lit.Value, 0, 8)
+			if err != nil {
+				t.Errorf("unexpected ASCII code %s: %v", lit.Value, err)
+				return true
+			}
+			switch code {
+			case 0x7f:
+				bound["Alt-Backspace"] = true
+			case 0x0d:
+				bound["Alt-Enter"] = true
+			default:
+				bound["Alt-"+strings.ToUpper(string(rune(code)))] = true
+			}
+		}
+		return true
+	})
+	return bound
+}
+
+// readlineBindings returns the keys handled by name in the readline REPL listener
+// (keyCtrlY, keyUp...), named as in KeyBindings.
+func readlineBindings(t *testing.T) map[string]bool {
+	t.Helper()
+	bound := map[string]bool{}
+	ast.Inspect(parseRepoFile(t, "repl.go"), func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for _, id := range spec.Names {
+			name, ok := strings.CutPrefix(id.Name, "key")
+			if !ok || name == "ESC" {
+				continue
+			}
+			if rest, ok := strings.CutPrefix(name, "Ctrl"); ok {
+				name = "Ctrl-" + rest
+			}
+			bound[name] = true
+		}
+		return true
+	})
+	return bound
+}
+
+func TestKeyBindingsDocumented(t *testing.T) {
+	documented := documentedKeys()
+	prompt := promptBindings(t)
+	if len(prompt) < 20 {
+		t.Fatalf("found only %d go-prompt key bindings, the parsing is likely broken: %v", len(prompt), prompt)
+	}
+	for _, bound := range []map[string]bool{prompt, readlineBindings(t)} {
+		for key := range bound {
+			if chord, ok := chordKeys[key]; ok {
+				key = chord
+			}
+			if !documented[key] {
+				t.Errorf("key binding %s is missing from KeyBindings", key)
+			}
+		}
+	}
+	// Enter and the trailing backslash are handled by the line reading, not bound, and
+	// Ctrl-Backspace only by the readline REPL
+	for first, chord := range chordKeys {
+		prompt[chord] = prompt[first]
+	}
+	for key := range documented {
+		if key == "Enter" || key == `\ at end of line` || key == "Ctrl-Backspace" {
+			continue
+		}
+		if !prompt[key] {
+			t.Errorf("KeyBindings documents %s, which the go-prompt REPL doesn't bind", key)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package repl
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"golang.org/x/term"
+)
+
+const (
+	highlightStart = "\033[1;31m"
+	highlightReset = "\033[0m"
+)
+
+var (
+	// colorMode is auto, always or never (set via --color).
+	colorMode = "auto"
+	// highlightPattern marks matching label values in printed results (set via .highlight).
+	highlightPattern *regexp.Regexp
+)
+
+// SetColorMode sets when ANSI colors are used: auto (stdout is a terminal and NO_COLOR is
+// unset), always, or never.
+func SetColorMode(mode string) error {
+	switch m := strings.ToLower(strings.TrimSpace(mode)); m {
+	case "auto", "always", "never":
+		colorMode = m
+		return nil
+	default:
+		return fmt.Errorf("invalid color mode %q (expected auto|always|never)", mode)
+	}
+}
+
+// colorEnabled reports whether ANSI colors may be written to stdout.
+func colorEnabled() bool {
+	switch colorMode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return term.IsTerminal(int(os.Stdout.Fd())) && os.Getenv("NO_COLOR") == ""
+}
+
+// resultHighlight returns the .highlight pattern, or nil when there is none or colors are off.
+func resultHighlight() *regexp.Regexp {
+	if highlightPattern == nil || !colorEnabled() {
+		return nil
+	}
+	return highlightPattern
+}
+
+// highlightString colors the matches of re in s; a nil re leaves s as is.
+func highlightString(re *regexp.Regexp, s string) string {
+	if re == nil {
+		return s
+	}
+	return re.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "" {
+			return m
+		}
+		return highlightStart + m + highlightReset
+	})
+}
+
+// highlightMetric renders m like labels.Labels.String, coloring the matches of re in label
+// values (including the metric name).
+func highlightMetric(re *regexp.Regexp, m labels.Labels) string {
+	if re == nil {
+		return m.String()
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	i := 0
+	m.Range(func(l labels.Label) {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		i++
+		if model.LegacyValidation.IsValidLabelName(l.Name) {
+			b.WriteString(l.Name)
+		} else {
+			b.WriteString(strconv.Quote(l.Name))
+		}
+		b.WriteByte('=')
+		q := strconv.Quote(l.Value)
+		b.WriteByte('"')
+		b.WriteString(highlightString(re, q[1:len(q)-1]))
+		b.WriteByte('"')
+	})
+	b.WriteByte('}')
+	return b.String()
+}
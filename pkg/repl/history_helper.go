@@ -1,6 +1,9 @@
 package repl
 
-import "strings"
+import (
+	"slices"
+	"strings"
+)
 
 // BuildFilteredHistory builds a newest-first filtered history slice based on the given prefix.
 // - Preserves duplicates for 1:1 navigation
@@ -15,3 +18,29 @@ func BuildFilteredHistory(prefix string, history []string) []string {
 	}
 	return out
 }
+
+// ReverseSearchHistory returns the index of the most recent entry before from that contains
+// query and differs from skip (the line currently shown), or -1. It backs Ctrl-R search.
+func ReverseSearchHistory(query, skip string, history []string, from int) int {
+	for i := min(from, len(history)) - 1; i >= 0; i-- {
+		if history[i] != skip && strings.Contains(history[i], query) {
+			return i
+		}
+	}
+	return -1
+}
+
+// DedupeHistory removes repeated entries, keeping the most recent occurrence of each so that
+// the order of recent commands is preserved.
+func DedupeHistory(history []string) []string {
+	seen := make(map[string]bool, len(history))
+	out := make([]string, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		if !seen[history[i]] {
+			seen[history[i]] = true
+			out = append(out, history[i])
+		}
+	}
+	slices.Reverse(out)
+	return out
+}
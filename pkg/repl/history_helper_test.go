@@ -1,6 +1,13 @@
 package repl
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
 
 func TestBuildFilteredHistory_PrefixAndDuplicates(t *testing.T) {
 	h := []string{
@@ -39,3 +46,62 @@ func TestBuildFilteredHistory_PrefixAndDuplicates(t *testing.T) {
 		t.Fatalf("expected 0 entries for unmatched prefix, got %d (%#v)", len(none), none)
 	}
 }
+
+func TestReverseSearchHistory(t *testing.T) {
+	h := []string{"sum(rate(a[5m]))", "up", "rate(b[1m])", "rate(b[1m])"}
+	if i := ReverseSearchHistory("rate", "", h, len(h)); i != 3 {
+		t.Fatalf("expected newest match 3, got %d", i)
+	}
+	// The entry shown is skipped, so repeating moves to a different entry.
+	if i := ReverseSearchHistory("rate", "rate(b[1m])", h, 3); i != 0 {
+		t.Fatalf("expected older distinct match 0, got %d", i)
+	}
+	if i := ReverseSearchHistory("rate", "", h, 0); i != -1 {
+		t.Fatalf("expected no match, got %d", i)
+	}
+}
+
+func TestDedupeHistory(t *testing.T) {
+	got := DedupeHistory([]string{"a", "b", "a", "c", "b"})
+	if strings.Join(got, ",") != "a,c,b" {
+		t.Fatalf("expected most recent occurrences kept in order, got %v", got)
+	}
+}
+
+func TestAdhocHistory_SearchDedupeClearExport(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history")
+	t.Setenv("PROMQL_CLI_HISTORY", path)
+	prev := getInMemoryHistory()
+	defer setInMemoryHistory(prev)
+	setInMemoryHistory(nil)
+	if err := os.WriteFile(path, []byte("up\nrate(x[5m])\nup\nsum(rate(y[1m]))\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := sstorage.NewSimpleStorage()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".history search ^(sum\\()?rate", store) })
+	if out != "rate(x[5m])\nsum(rate(y[1m]))\n" {
+		t.Fatalf("unexpected search output: %q", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".history dedupe", store) })
+	if !strings.Contains(out, "Removed 1 duplicate history entries (3 left)") {
+		t.Fatalf("unexpected dedupe output: %q", out)
+	}
+	if got := loadHistoryFromFile(path); strings.Join(got, ",") != "rate(x[5m]),up,sum(rate(y[1m]))" {
+		t.Fatalf("unexpected history file: %v", got)
+	}
+	export := filepath.Join(dir, "export.txt")
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".history export "+export, store) })
+	if got := loadHistoryFromFile(export); len(got) != 3 {
+		t.Fatalf("unexpected export: %v", got)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".history clear", store) })
+	if !strings.Contains(out, "Cleared 3 history entries") || len(loadHistoryFromFile(path)) != 0 {
+		t.Fatalf("unexpected clear output: %q", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".history search (", store) })
+	if !strings.Contains(out, "Invalid regex") {
+		t.Fatalf("expected regex error, got: %q", out)
+	}
+}
@@ -2,3 +2,6 @@ package repl
 
 // getInMemoryHistory returns the current in-memory history used by the prompt backend.
 func getInMemoryHistory() []string { return replHistory }
+
+// setInMemoryHistory replaces the in-memory history used by the prompt backend.
+func setInMemoryHistory(entries []string) { replHistory = entries }
@@ -0,0 +1,182 @@
+package repl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// Hook events, as named in the config file hooks section.
+const (
+	HookAfterLoad     = "after_load"
+	HookBeforeQuery   = "before_query"
+	HookAfterQuery    = "after_query"
+	HookOnAlertFiring = "on_alert_firing"
+)
+
+// hookTimeout is how long a hook command may run before it is killed.
+var hookTimeout = 10 * time.Second
+
+// ConfigHooks holds the shell commands run on each event. They get the event as a JSON
+// HookEvent on stdin and its name in $PROMQL_CLI_EVENT.
+type ConfigHooks struct {
+	AfterLoad     []string `yaml:"after_load,omitempty"`
+	BeforeQuery   []string `yaml:"before_query,omitempty"`
+	AfterQuery    []string `yaml:"after_query,omitempty"`
+	OnAlertFiring []string `yaml:"on_alert_firing,omitempty"`
+}
+
+func (h ConfigHooks) commands(event string) []string {
+	switch event {
+	case HookAfterLoad:
+		return h.AfterLoad
+	case HookBeforeQuery:
+		return h.BeforeQuery
+	case HookAfterQuery:
+		return h.AfterQuery
+	case HookOnAlertFiring:
+		return h.OnAlertFiring
+	}
+	return nil
+}
+
+func (h ConfigHooks) validate() error {
+	for _, event := range []string{HookAfterLoad, HookBeforeQuery, HookAfterQuery, HookOnAlertFiring} {
+		for _, cmd := range h.commands(event) {
+			if cmd == "" {
+				return fmt.Errorf("%s: empty command", event)
+			}
+		}
+	}
+	return nil
+}
+
+// HookEvent is the JSON document hooks read on stdin. Fields not set for the event are
+// omitted.
+type HookEvent struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// after_load: where the data came from and the store totals afterwards
+	Source  string `json:"source,omitempty"`
+	Metrics int    `json:"metrics,omitempty"`
+	Samples int    `json:"samples,omitempty"`
+	// before_query and after_query: the query and its evaluation time (the end of a range
+	// query); after_query adds its duration and the result as the Prometheus API returns it,
+	// or the error
+	Query           string          `json:"query,omitempty"`
+	EvalTime        *time.Time      `json:"eval_time,omitempty"`
+	DurationSeconds float64         `json:"duration_seconds,omitempty"`
+	Result          json.RawMessage `json:"result,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	// on_alert_firing: the firing alerts, as .alerts push sends them
+	Alerts []AlertmanagerAlert `json:"alerts,omitempty"`
+}
+
+// hooksFor returns the commands configured for event.
+func hooksFor(event string) []string {
+	return activeConfig.Hooks.commands(event)
+}
+
+// runHooks runs the commands configured for ev.Event one after the other, with ev as JSON
+// on stdin. Their output goes to stderr, so it can't mix with results; failures are
+// reported there too and don't stop the REPL.
+func runHooks(ev HookEvent) {
+	cmds := hooksFor(ev.Event)
+	if len(cmds) == 0 {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		mustFprintf(os.Stderr, "Hook %s: %v\n", ev.Event, err)
+		return
+	}
+	for _, cmdline := range cmds {
+		if err := runHook(cmdline, ev.Event, payload); err != nil {
+			mustFprintf(os.Stderr, "Hook %s failed: %s: %v\n", ev.Event, cmdline, err)
+		}
+	}
+}
+
+// runHook runs one hook command, killing it after hookTimeout.
+func runHook(cmdline, event string, payload []byte) error {
+	cmd := shellCommand(cmdline)
+	cmd.Env = append(os.Environ(), "PROMQL_CLI_EVENT="+event)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(hookTimeout, func() {
+		timedOut.Store(true)
+		_ = cmd.Process.Kill()
+	})
+	err := cmd.Wait()
+	timer.Stop()
+	if timedOut.Load() {
+		return errors.New("timed out after " + hookTimeout.String())
+	}
+	return err
+}
+
+// RunLoadHooks runs the after_load hooks for data loaded into storage from source.
+func RunLoadHooks(storage *sstorage.SimpleStorage, source string) {
+	if len(hooksFor(HookAfterLoad)) == 0 {
+		return
+	}
+	metrics, samples := storeTotals(storage)
+	runHooks(HookEvent{Event: HookAfterLoad, Source: source, Metrics: metrics, Samples: samples})
+}
+
+// runQueryHooks runs the before_query hooks of query, evaluated at t.
+func runQueryHooks(query string, t time.Time) {
+	if len(hooksFor(HookBeforeQuery)) == 0 {
+		return
+	}
+	t = t.UTC()
+	runHooks(HookEvent{Event: HookBeforeQuery, Query: query, EvalTime: &t})
+}
+
+// runResultHooks runs the after_query hooks of query, evaluated at t since start, with its
+// result or error.
+func runResultHooks(query string, t, start time.Time, result *promql.Result, err error) {
+	if len(hooksFor(HookAfterQuery)) == 0 {
+		return
+	}
+	t = t.UTC()
+	ev := HookEvent{Event: HookAfterQuery, Query: query, EvalTime: &t, DurationSeconds: time.Since(start).Seconds()}
+	if err != nil {
+		ev.Error = err.Error()
+	} else if result != nil {
+		var buf bytes.Buffer
+		if jerr := PrintResultJSONToWriter(result, &buf); jerr == nil {
+			ev.Result = bytes.TrimSpace(buf.Bytes())
+		}
+	}
+	runHooks(ev)
+}
+
+// runAlertHooks runs the on_alert_firing hooks when alerting rules evaluated at t have
+// firing alerts, honoring their for duration.
+func runAlertHooks(storage *sstorage.SimpleStorage, t time.Time) {
+	if len(hooksFor(HookOnAlertFiring)) == 0 || evalEngine == nil {
+		return
+	}
+	alerts := FiringAlerts(EvaluateAlertStates(evalEngine, storage, activeAlertingRules, t), time.Now())
+	if len(alerts) == 0 {
+		return
+	}
+	runHooks(HookEvent{Event: HookOnAlertFiring, Alerts: alerts})
+}
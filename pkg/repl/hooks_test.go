@@ -0,0 +1,79 @@
+package repl
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHooks_QueryAndAlertEvents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands use a POSIX shell")
+	}
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "events.jsonl")
+	prevConfig, prevEngine := activeConfig, evalEngine
+	defer func() { activeConfig, evalEngine = prevConfig, prevEngine }()
+	appendEvent := `(cat; echo " $PROMQL_CLI_EVENT") >> ` + logPath
+	activeConfig = &Config{Hooks: ConfigHooks{
+		AfterLoad:     []string{appendEvent},
+		BeforeQuery:   []string{appendEvent},
+		AfterQuery:    []string{appendEvent, "exit 3"},
+		OnAlertFiring: []string{appendEvent},
+	}}
+	store := newTestStore(t)
+
+	rulesPath := filepath.Join(dir, "rules.yaml")
+	if err := os.WriteFile(rulesPath, []byte("groups:\n- name: t\n  rules:\n  - alert: NotFound\n    expr: http_requests_total{code=\"404\"} > 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	SetActiveRules([]string{rulesPath}, rulesPath)
+	defer SetActiveRules(nil, "")
+	SetEvalEngine(newTestEngine())
+
+	out := captureStdout(t, func() {
+		RunLoadHooks(store, "sample.prom")
+		executeOne(newTestEngine(), store, "temperature")
+		_, _, _ = EvaluateActiveRules(store)
+	})
+	if !strings.Contains(out, "27.3") {
+		t.Fatalf("expected the query result printed, got: %s", out)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 events, got: %s", data)
+	}
+	var events []HookEvent
+	for _, line := range lines {
+		payload, name, _ := strings.Cut(line, " ")
+		var ev HookEvent
+		if err := json.Unmarshal([]byte(payload), &ev); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		if ev.Event != name || time.Since(ev.Time) > time.Minute {
+			t.Fatalf("unexpected event header: %q", line)
+		}
+		events = append(events, ev)
+	}
+	if events[0].Source != "sample.prom" || events[0].Metrics != 2 || events[0].Samples != 3 {
+		t.Errorf("unexpected after_load: %+v", events[0])
+	}
+	if events[1].Event != HookBeforeQuery || events[1].Query != "temperature" || events[1].EvalTime == nil {
+		t.Errorf("unexpected before_query: %+v", events[1])
+	}
+	if events[2].Event != HookAfterQuery || !strings.Contains(string(events[2].Result), `"resultType":"vector"`) || !strings.Contains(string(events[2].Result), "27.3") {
+		t.Errorf("unexpected after_query: %+v", events[2])
+	}
+	if events[3].Event != HookOnAlertFiring || len(events[3].Alerts) != 1 || events[3].Alerts[0].Labels["alertname"] != "NotFound" {
+		t.Errorf("unexpected on_alert_firing: %+v", events[3])
+	}
+}
@@ -0,0 +1,111 @@
+package repl
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// humanizeValues makes the table format render values with unit prefixes, e.g. 1.2M, 3.4GiB
+// or 1h 2m 3s (set via .humanize or query --humanize).
+var humanizeValues bool
+
+// SetHumanize turns humanized table values on or off.
+func SetHumanize(on bool) {
+	humanizeValues = on
+}
+
+// humanizeValue renders v for people, inferring its unit from the suffix of metric name:
+// _bytes in binary (IEC) units, _seconds as a duration, _timestamp_seconds as a time (see
+// .tz and .time_format), anything else with SI prefixes.
+func humanizeValue(name string, v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	base := strings.TrimSuffix(name, "_total")
+	switch {
+	case strings.HasSuffix(base, "_timestamp_seconds"), strings.HasSuffix(base, "_time_seconds"):
+		return formatDisplayTime(time.UnixMilli(int64(v * 1000)))
+	case strings.HasSuffix(base, "_seconds"):
+		return humanizeDuration(v)
+	case strings.HasSuffix(base, "_bytes"):
+		return humanizeBytes(v)
+	}
+	return humanizeSI(v)
+}
+
+// humanizeSI renders v with 3 significant digits and an SI prefix, e.g. 1.23k or 4.5M.
+// Values below 1000 keep no prefix.
+func humanizeSI(v float64) string {
+	prefixes := []string{"", "k", "M", "G", "T", "P", "E"}
+	i := 0
+	for math.Abs(v) >= 1000 && i < len(prefixes)-1 {
+		v /= 1000
+		i++
+	}
+	return threeDigits(v) + prefixes[i]
+}
+
+// humanizeBytes renders v with 3 significant digits and a binary prefix, e.g. 512B or 3.4GiB.
+func humanizeBytes(v float64) string {
+	prefixes := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	i := 0
+	for math.Abs(v) >= 1024 && i < len(prefixes)-1 {
+		v /= 1024
+		i++
+	}
+	return threeDigits(v) + prefixes[i]
+}
+
+// humanizeDuration renders seconds as days, hours, minutes and seconds (e.g. 1d 2h 3m 4s),
+// or in ms, µs and ns below a second.
+func humanizeDuration(v float64) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	switch {
+	case v == 0:
+		return "0s"
+	case v < 1e-6:
+		return sign + threeDigits(v*1e9) + "ns"
+	case v < 1e-3:
+		return sign + threeDigits(v*1e6) + "µs"
+	case v < 1:
+		return sign + threeDigits(v*1e3) + "ms"
+	case v < 60:
+		return sign + threeDigits(v) + "s"
+	}
+	secs := int64(math.Round(v))
+	var parts []string
+	for _, u := range []struct {
+		suffix string
+		secs   int64
+	}{{"d", 86400}, {"h", 3600}, {"m", 60}, {"s", 1}} {
+		if n := secs / u.secs; n > 0 {
+			parts = append(parts, strconv.FormatInt(n, 10)+u.suffix)
+			secs -= n * u.secs
+		}
+	}
+	return sign + strings.Join(parts, " ")
+}
+
+// threeDigits renders v with about 3 significant digits and no exponent above 1, e.g. 1.23,
+// 45.6 or 789 (999.96 renders as 1000).
+func threeDigits(v float64) string {
+	prec := 0
+	switch a := math.Abs(v); {
+	case a < 1:
+		return strconv.FormatFloat(v, 'g', 3, 64)
+	case a < 10:
+		prec = 2
+	case a < 100:
+		prec = 1
+	}
+	s := strconv.FormatFloat(v, 'f', prec, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
@@ -0,0 +1,53 @@
+package repl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/prometheus/prometheus/promql"
+)
+
+// formatJSONL renders result as JSON Lines: one object per vector sample or matrix series,
+// shaped like the data.result items of the json format, e.g. for jq or awk. A scalar or
+// string renders as a single {"value": [timestamp, value]} line; empty results print nothing.
+func formatJSONL(w io.Writer, result *promql.Result) error {
+	enc := json.NewEncoder(w)
+	switch v := result.Value.(type) {
+	case promql.Vector:
+		for _, s := range v {
+			if err := enc.Encode(toSampleJSON(s)); err != nil {
+				return err
+			}
+		}
+	case promql.Matrix:
+		for _, series := range v {
+			if err := enc.Encode(toSeriesJSON(series)); err != nil {
+				return err
+			}
+		}
+	case promql.Scalar:
+		return enc.Encode(map[string]any{"value": [2]any{float64(v.T) / 1000.0, v.V}})
+	case promql.String:
+		return enc.Encode(map[string]any{"value": [2]any{float64(v.T) / 1000.0, v.V}})
+	}
+	return nil
+}
+
+// jsonlOutput reports whether ad-hoc commands listing data should print JSON Lines
+// (.format jsonl or -o jsonl) instead of text.
+func jsonlOutput() bool {
+	return outputFormat == "jsonl"
+}
+
+// printJSONL writes each of items to stdout as a line of JSON.
+func printJSONL[T any](items []T) {
+	enc := json.NewEncoder(os.Stdout)
+	for _, it := range items {
+		if err := enc.Encode(it); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package repl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.yaml.in/yaml/v2"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// NamedQuery is a saved PromQL expression that can be listed, run and linted by name.
+// Params are referenced in Expr as $param or ${param} and bound by .run name(arg, ...).
+type NamedQuery struct {
+	Name   string   `yaml:"name"`
+	Params []string `yaml:"params,omitempty"`
+	Expr   string   `yaml:"expr"`
+	Source string   `yaml:"-"` // where it came from, e.g. "grafana: <dashboard> / <panel>"; "" for .define
+}
+
+// namedQueries holds the saved queries, keyed by name.
+var namedQueries = map[string]NamedQuery{}
+
+var namedQueriesLoad sync.Once
+
+var namedQueryNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SetNamedQuery saves (or replaces) a named query.
+func SetNamedQuery(q NamedQuery) { namedQueries[q.Name] = q }
+
+// GetNamedQuery returns the named query with the given name.
+func GetNamedQuery(name string) (NamedQuery, bool) {
+	q, ok := namedQueries[name]
+	return q, ok
+}
+
+// NamedQueries returns all saved queries sorted by name.
+func NamedQueries() []NamedQuery {
+	out := make([]NamedQuery, 0, len(namedQueries))
+	for _, q := range namedQueries {
+		out = append(out, q)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Signature formats the query as name(param, ...), or just name without params.
+func (q NamedQuery) Signature() string {
+	if len(q.Params) == 0 {
+		return q.Name
+	}
+	return q.Name + "(" + strings.Join(q.Params, ", ") + ")"
+}
+
+// namedQueriesPath returns the query library file (~/.promql-cli/queries.yaml).
+func namedQueriesPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return "", fmt.Errorf("cannot determine home directory: %v", err)
+	}
+	return filepath.Join(home, ".promql-cli", "queries.yaml"), nil
+}
+
+// loadNamedQueries merges the query library file into the registry, once per process.
+// A missing file is not an error.
+func loadNamedQueries() {
+	namedQueriesLoad.Do(func() {
+		path, err := namedQueriesPath()
+		if err != nil {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Printf("Warning: failed to read %s: %v\n", path, err)
+			}
+			return
+		}
+		var file struct {
+			Queries []NamedQuery `yaml:"queries"`
+		}
+		if err := yaml.UnmarshalStrict(data, &file); err != nil {
+			fmt.Printf("Warning: invalid query library %s: %v\n", path, err)
+			return
+		}
+		for _, q := range file.Queries {
+			if _, ok := namedQueries[q.Name]; !ok && namedQueryNameRe.MatchString(q.Name) {
+				namedQueries[q.Name] = q
+			}
+		}
+	})
+}
+
+// saveNamedQueries writes the queries defined with .define (not imported ones) to the library file.
+func saveNamedQueries() (string, error) {
+	path, err := namedQueriesPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	var file struct {
+		Queries []NamedQuery `yaml:"queries"`
+	}
+	for _, q := range NamedQueries() {
+		if q.Source == "" {
+			file.Queries = append(file.Queries, q)
+		}
+	}
+	data, err := yaml.Marshal(&file)
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, data, 0o600)
+}
+
+// defineRe matches "name = expr" and "name(p1, p2) = expr".
+var defineRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*(?:\(([^)]*)\))?\s*=\s*(.+)$`)
+
+// ParseDefine parses the argument of .define into a NamedQuery, checking that every
+// parameter is used and that the expression's variables are all parameters.
+func ParseDefine(def string) (NamedQuery, error) {
+	m := defineRe.FindStringSubmatch(strings.TrimSpace(def))
+	if m == nil {
+		return NamedQuery{}, fmt.Errorf("expected name(param, ...) = <query>")
+	}
+	q := NamedQuery{Name: m[1], Expr: strings.TrimSpace(m[3])}
+	declared := map[string]bool{}
+	if strings.TrimSpace(m[2]) != "" {
+		for _, p := range strings.Split(m[2], ",") {
+			p = strings.TrimPrefix(strings.TrimSpace(p), "$")
+			if !namedQueryNameRe.MatchString(p) {
+				return NamedQuery{}, fmt.Errorf("invalid parameter name %q", p)
+			}
+			if declared[p] {
+				return NamedQuery{}, fmt.Errorf("duplicate parameter %q", p)
+			}
+			declared[p] = true
+			q.Params = append(q.Params, p)
+		}
+	}
+	used := map[string]bool{}
+	for _, sub := range queryParamRe.FindAllStringSubmatch(q.Expr, -1) {
+		name := sub[1] + sub[2]
+		if !declared[name] {
+			return NamedQuery{}, fmt.Errorf("$%s is not a parameter of %s", name, q.Name)
+		}
+		used[name] = true
+	}
+	for _, p := range q.Params {
+		if !used[p] {
+			return NamedQuery{}, fmt.Errorf("parameter %q is not used in the query", p)
+		}
+	}
+	// Make sure the expression parses once parameters are bound to a placeholder usable as a
+	// label value, a duration or a number.
+	var err error
+	for _, placeholder := range []string{"x", "5m", "1"} {
+		args := make([]string, len(q.Params))
+		for i := range args {
+			args[i] = placeholder
+		}
+		probe, _ := q.Expand(args)
+		if _, err = promParser.ParseExpr(probe); err == nil {
+			return q, nil
+		}
+	}
+	return NamedQuery{}, err
+}
+
+// queryParamRe matches $param and ${param} references.
+var queryParamRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Expand substitutes args for the query's parameters, in declaration order.
+func (q NamedQuery) Expand(args []string) (string, error) {
+	if len(args) != len(q.Params) {
+		return "", fmt.Errorf("%s takes %d arguments, got %d", q.Signature(), len(q.Params), len(args))
+	}
+	values := make(map[string]string, len(args))
+	for i, p := range q.Params {
+		values[p] = args[i]
+	}
+	return queryParamRe.ReplaceAllStringFunc(q.Expr, func(m string) string {
+		sub := queryParamRe.FindStringSubmatch(m)
+		if v, ok := values[sub[1]+sub[2]]; ok {
+			return v
+		}
+		return m
+	}), nil
+}
+
+// ParseRunCall splits "name" or "name(arg1, \"arg 2\")" into the name and its arguments.
+// Quotes around an argument are removed.
+func ParseRunCall(call string) (string, []string, error) {
+	call = strings.TrimSpace(call)
+	open := strings.Index(call, "(")
+	if open < 0 {
+		return call, nil, nil
+	}
+	if !strings.HasSuffix(call, ")") {
+		return "", nil, fmt.Errorf("missing closing parenthesis in %q", call)
+	}
+	name := strings.TrimSpace(call[:open])
+	inner := call[open+1 : len(call)-1]
+	if strings.TrimSpace(inner) == "" {
+		return name, nil, nil
+	}
+	var args []string
+	var cur strings.Builder
+	var quote rune
+	for _, r := range inner {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			args = append(args, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return "", nil, fmt.Errorf("unterminated quote in %q", call)
+	}
+	args = append(args, strings.TrimSpace(cur.String()))
+	return name, args, nil
+}
+
+// handleAdhocDefine lists named queries, or defines one and saves the library:
+// .define | .define name(param, ...) = <query>
+func handleAdhocDefine(query string) bool {
+	loadNamedQueries()
+	arg := strings.TrimSpace(strings.TrimPrefix(query, ".define"))
+	if arg == "" {
+		queries := NamedQueries()
+		if len(queries) == 0 {
+			cmd := GetAdHocCommandByName(".define")
+			fmt.Println("No named queries defined. Usage: " + cmd.Usage)
+			for _, ex := range cmd.Examples {
+				fmt.Println("Example: " + ex)
+			}
+			return true
+		}
+		for _, q := range queries {
+			fmt.Printf("%s = %s\n", q.Signature(), q.Expr)
+			if q.Source != "" {
+				fmt.Printf("    (%s)\n", q.Source)
+			}
+		}
+		return true
+	}
+	q, err := ParseDefine(arg)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	SetNamedQuery(q)
+	path, err := saveNamedQueries()
+	if err != nil {
+		fmt.Printf("Defined %s (not saved: %v)\n", q.Signature(), err)
+		return true
+	}
+	fmt.Printf("Defined %s (saved to %s)\n", q.Signature(), path)
+	return true
+}
+
+// handleAdhocUndefine removes a named query: .undefine <name>
+func handleAdhocUndefine(query string) bool {
+	loadNamedQueries()
+	name := strings.TrimSpace(strings.TrimPrefix(query, ".undefine"))
+	if name == "" {
+		fmt.Println("Usage: " + GetAdHocCommandByName(".undefine").Usage)
+		return true
+	}
+	q, ok := GetNamedQuery(name)
+	if !ok {
+		fmt.Printf("No named query %q\n", name)
+		return true
+	}
+	delete(namedQueries, name)
+	if q.Source == "" {
+		if _, err := saveNamedQueries(); err != nil {
+			fmt.Printf("Warning: failed to save query library: %v\n", err)
+		}
+	}
+	fmt.Printf("Removed %s\n", q.Signature())
+	return true
+}
+
+// handleAdhocRun expands a named query with its arguments and executes it:
+// .run name(arg, ...)
+func handleAdhocRun(query string, storage *sstorage.SimpleStorage) bool {
+	loadNamedQueries()
+	call := strings.TrimSpace(strings.TrimPrefix(query, ".run"))
+	if call == "" {
+		fmt.Println("Usage: " + GetAdHocCommandByName(".run").Usage)
+		return true
+	}
+	name, args, err := ParseRunCall(call)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	q, ok := GetNamedQuery(name)
+	if !ok {
+		fmt.Printf("No named query %q (see .define)\n", name)
+		return true
+	}
+	expr, err := q.Expand(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	if replEngine == nil {
+		fmt.Println("Error: PromQL engine not available")
+		return true
+	}
+	fmt.Printf("> %s\n", expr)
+	ExecuteQueryLine(replEngine, storage, expr)
+	return true
+}
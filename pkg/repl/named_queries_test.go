@@ -0,0 +1,83 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestParseDefineAndExpand(t *testing.T) {
+	q, err := ParseDefine(`errrate(job, window) = sum(rate(http_requests_total{job="$job",code=~"5.."}[${window}]))`)
+	if err != nil {
+		t.Fatalf("ParseDefine: %v", err)
+	}
+	if q.Signature() != "errrate(job, window)" {
+		t.Fatalf("unexpected signature %q", q.Signature())
+	}
+	name, args, err := ParseRunCall(`errrate("api, v2", 10m)`)
+	if err != nil || name != "errrate" || len(args) != 2 || args[0] != "api, v2" {
+		t.Fatalf("unexpected ParseRunCall result: %q %q %v", name, args, err)
+	}
+	expr, err := q.Expand(args)
+	if err != nil || expr != `sum(rate(http_requests_total{job="api, v2",code=~"5.."}[10m]))` {
+		t.Fatalf("unexpected expansion %q (%v)", expr, err)
+	}
+	if _, err := q.Expand([]string{"api"}); err == nil {
+		t.Fatalf("expected an arity error")
+	}
+
+	for _, bad := range []string{
+		"errrate",
+		"f(job) = up",
+		"f = up{job=\"$job\"}",
+		"f(a, a) = up{a=\"$a\"}",
+		"f = sum(",
+	} {
+		if _, err := ParseDefine(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestAdhoc_DefineRunPersists(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	prev := replEngine
+	replEngine = newTestEngine()
+	defer func() { replEngine = prev }()
+	defer delete(namedQueries, "jobup")
+
+	store := sstorage.NewSimpleStorage()
+	if err := store.LoadFromReader(strings.NewReader("up{job=\"api\"} 1\nup{job=\"db\"} 0\n")); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	out := captureStdout(t, func() { _ = handleAdHocFunction(`.define jobup(job) = up{job="$job"}`, store) })
+	if !strings.Contains(out, "Defined jobup(job)") {
+		t.Fatalf("unexpected define output: %s", out)
+	}
+	data, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), ".promql-cli", "queries.yaml"))
+	if err != nil || !strings.Contains(string(data), "name: jobup") {
+		t.Fatalf("expected jobup in the query library, got %q (%v)", data, err)
+	}
+
+	ac := NewPrometheusAutoCompleter(store)
+	if got := ac.getCompletions(".run job", len(".run job"), "job"); len(got) != 1 || got[0] != "jobup" {
+		t.Fatalf("expected .run to complete jobup, got %v", got)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".run jobup(db)", store) })
+	if !strings.Contains(out, `> up{job="db"}`) || !strings.Contains(out, `job="db"`) || strings.Contains(out, `job="api"`) {
+		t.Fatalf("unexpected run output: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".run nosuch(x)", store) })
+	if !strings.Contains(out, `No named query "nosuch"`) {
+		t.Fatalf("unexpected output for unknown query: %s", out)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".undefine jobup", store) })
+	if _, ok := GetNamedQuery("jobup"); ok || !strings.Contains(out, "Removed jobup(job)") {
+		t.Fatalf("expected jobup to be removed: %s", out)
+	}
+}
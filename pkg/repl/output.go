@@ -5,9 +5,10 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"time"
+	"regexp"
+	"strconv"
 
-	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/promql"
 )
@@ -24,6 +25,12 @@ func PrintUpstreamQueryResult(result *promql.Result) {
 }
 
 func PrintUpstreamQueryResultToWriter(result *promql.Result, w io.Writer) {
+	writeResultText(w, result, nil)
+}
+
+// writeResultText is PrintUpstreamQueryResultToWriter, coloring the matches of hl in label
+// values when hl is not nil.
+func writeResultText(w io.Writer, result *promql.Result, hl *regexp.Regexp) {
 	switch v := result.Value.(type) {
 	case promql.Vector:
 		if len(v) == 0 {
@@ -31,15 +38,9 @@ func PrintUpstreamQueryResultToWriter(result *promql.Result, w io.Writer) {
 			return
 		}
 		mustFprintf(w, "Vector (%d samples):\n", len(v))
-		for i, sample := range v {
-			mustFprintf(w, "  [%d] %s => %g @ %s\n",
-				i+1,
-				sample.Metric,
-				sample.F,
-				model.Time(sample.T).Time().Format(time.RFC3339))
-		}
+		writeVectorText(w, v, hl)
 	case promql.Scalar:
-		mustFprintf(w, "Scalar: %g @ %s\n", v.V, model.Time(v.T).Time().Format(time.RFC3339))
+		mustFprintf(w, "Scalar: %g @ %s\n", v.V, formatResultMillis(v.T))
 	case promql.String:
 		mustFprintf(w, "String: %s\n", v.V)
 	case promql.Matrix:
@@ -49,9 +50,12 @@ func PrintUpstreamQueryResultToWriter(result *promql.Result, w io.Writer) {
 		}
 		mustFprintf(w, "Matrix (%d series):\n", len(v))
 		for i, series := range v {
-			mustFprintf(w, "  [%d] %s:\n", i+1, series.Metric)
+			mustFprintf(w, "  [%d] %s:\n", i+1, highlightMetric(hl, series.Metric))
 			for _, point := range series.Floats {
-				mustFprintf(w, "    %g @ %s\n", point.F, model.Time(point.T).Time().Format(time.RFC3339))
+				mustFprintf(w, "    %g @ %s\n", point.F, formatResultMillis(point.T))
+			}
+			for _, point := range series.Histograms {
+				mustFprintf(w, "    %s @ %s\n", point.H, formatResultMillis(point.T))
 			}
 		}
 	default:
@@ -59,16 +63,69 @@ func PrintUpstreamQueryResultToWriter(result *promql.Result, w io.Writer) {
 	}
 }
 
+// writeVectorText prints the numbered samples of a vector, one per line.
+func writeVectorText(w io.Writer, v promql.Vector, hl *regexp.Regexp) {
+	for i, sample := range v {
+		if sample.H != nil {
+			mustFprintf(w, "  [%d] %s => %s @ %s\n",
+				i+1,
+				highlightMetric(hl, sample.Metric),
+				sample.H,
+				formatResultMillis(sample.T))
+			continue
+		}
+		mustFprintf(w, "  [%d] %s => %g @ %s\n",
+			i+1,
+			highlightMetric(hl, sample.Metric),
+			sample.F,
+			formatResultMillis(sample.T))
+	}
+}
+
 // PrintResultJSON renders the result as JSON similar to Prometheus API shapes.
 func PrintResultJSON(result *promql.Result) error {
-	type sampleJSON struct {
-		Metric map[string]string `json:"metric"`
-		Value  [2]any            `json:"value"` // [timestamp(sec), value]
+	return PrintResultJSONToWriter(result, os.Stdout)
+}
+
+// sampleJSON is a vector sample in the JSON output formats.
+type sampleJSON struct {
+	Metric    map[string]string `json:"metric"`
+	Value     *[2]any           `json:"value,omitempty"`     // [timestamp(sec), value]
+	Histogram *[2]any           `json:"histogram,omitempty"` // [timestamp(sec), histogram]
+}
+
+// seriesJSON is a matrix series in the JSON output formats.
+type seriesJSON struct {
+	Metric     map[string]string `json:"metric"`
+	Values     [][2]any          `json:"values,omitempty"`
+	Histograms [][2]any          `json:"histograms,omitempty"`
+}
+
+// toSampleJSON converts a vector sample to its JSON shape.
+func toSampleJSON(s promql.Sample) sampleJSON {
+	sj := sampleJSON{Metric: labelsToMap(s.Metric)}
+	if s.H != nil {
+		sj.Histogram = &[2]any{float64(s.T) / 1000.0, histogramToJSON(s.H)}
+	} else {
+		sj.Value = &[2]any{float64(s.T) / 1000.0, s.F}
 	}
-	type seriesJSON struct {
-		Metric map[string]string `json:"metric"`
-		Values [][2]any          `json:"values"`
+	return sj
+}
+
+// toSeriesJSON converts a matrix series to its JSON shape.
+func toSeriesJSON(series promql.Series) seriesJSON {
+	var values, histograms [][2]any
+	for _, p := range series.Floats {
+		values = append(values, [2]any{float64(p.T) / 1000.0, p.F})
 	}
+	for _, p := range series.Histograms {
+		histograms = append(histograms, [2]any{float64(p.T) / 1000.0, histogramToJSON(p.H)})
+	}
+	return seriesJSON{Metric: labelsToMap(series.Metric), Values: values, Histograms: histograms}
+}
+
+// PrintResultJSONToWriter is PrintResultJSON writing to w.
+func PrintResultJSONToWriter(result *promql.Result, w io.Writer) error {
 	type dataJSON struct {
 		ResultType string `json:"resultType"`
 		Result     any    `json:"result"`
@@ -83,17 +140,14 @@ func PrintResultJSON(result *promql.Result) error {
 		out := respJSON{Status: "success", Data: dataJSON{ResultType: "vector"}}
 		var arr []sampleJSON
 		for _, s := range v {
-			arr = append(arr, sampleJSON{
-				Metric: labelsToMap(s.Metric),
-				Value:  [2]any{float64(s.T) / 1000.0, s.F},
-			})
+			arr = append(arr, toSampleJSON(s))
 		}
 		out.Data.Result = arr
 		b, err := json.Marshal(out)
 		if err != nil {
 			return err
 		}
-		if _, err := os.Stdout.Write(append(b, '\n')); err != nil {
+		if _, err := w.Write(append(b, '\n')); err != nil {
 			return err
 		}
 		return nil
@@ -104,7 +158,7 @@ func PrintResultJSON(result *promql.Result) error {
 		if err != nil {
 			return err
 		}
-		if _, err := os.Stdout.Write(append(b, '\n')); err != nil {
+		if _, err := w.Write(append(b, '\n')); err != nil {
 			return err
 		}
 		return nil
@@ -112,21 +166,14 @@ func PrintResultJSON(result *promql.Result) error {
 		out := respJSON{Status: "success", Data: dataJSON{ResultType: "matrix"}}
 		var arr []seriesJSON
 		for _, series := range v {
-			var values [][2]any
-			for _, p := range series.Floats {
-				values = append(values, [2]any{float64(p.T) / 1000.0, p.F})
-			}
-			arr = append(arr, seriesJSON{
-				Metric: labelsToMap(series.Metric),
-				Values: values,
-			})
+			arr = append(arr, toSeriesJSON(series))
 		}
 		out.Data.Result = arr
 		b, err := json.Marshal(out)
 		if err != nil {
 			return err
 		}
-		if _, err := os.Stdout.Write(append(b, '\n')); err != nil {
+		if _, err := w.Write(append(b, '\n')); err != nil {
 			return err
 		}
 		return nil
@@ -137,7 +184,7 @@ func PrintResultJSON(result *promql.Result) error {
 		if err != nil {
 			return err
 		}
-		if _, err := os.Stdout.Write(append(b, '\n')); err != nil {
+		if _, err := w.Write(append(b, '\n')); err != nil {
 			return err
 		}
 		return nil
@@ -147,3 +194,33 @@ func PrintResultJSON(result *promql.Result) error {
 func labelsToMap(l labels.Labels) map[string]string {
 	return l.Map()
 }
+
+// histogramToJSON renders a native histogram like the Prometheus HTTP API does:
+// count, sum and buckets as [boundary_rule, lower, upper, count], with values as strings.
+// Boundary rules: 0 open left, 1 open right, 2 open both, 3 closed both.
+func histogramToJSON(h *histogram.FloatHistogram) map[string]any {
+	fmtFloat := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	buckets := [][4]any{}
+	it := h.AllBucketIterator()
+	for it.Next() {
+		b := it.At()
+		if b.Count == 0 {
+			continue
+		}
+		rule := 2
+		switch {
+		case b.LowerInclusive && b.UpperInclusive:
+			rule = 3
+		case b.LowerInclusive:
+			rule = 1
+		case b.UpperInclusive:
+			rule = 0
+		}
+		buckets = append(buckets, [4]any{rule, fmtFloat(b.Lower), fmtFloat(b.Upper), fmtFloat(b.Count)})
+	}
+	return map[string]any{
+		"count":   fmtFloat(h.Count),
+		"sum":     fmtFloat(h.Sum),
+		"buckets": buckets,
+	}
+}
@@ -0,0 +1,78 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// terminalHeight returns the number of rows of the terminal on stdout, and false when
+// stdout is not a terminal.
+func terminalHeight() (int, bool) {
+	_, rows, ok := terminalSize()
+	return rows, ok
+}
+
+// pagerCommand returns $PAGER, or "less -R" when unset and less is installed. An empty
+// result or "cat" means no pager.
+func pagerCommand() string {
+	if p, ok := os.LookupEnv("PAGER"); ok {
+		return strings.TrimSpace(p)
+	}
+	if _, err := exec.LookPath("less"); err == nil {
+		return "less -R"
+	}
+	return ""
+}
+
+// pageOutput writes out to stdout, through the pager when stdout is a terminal and out
+// doesn't fit on it. Output is written directly if the pager can't be run.
+func pageOutput(out []byte) {
+	rows, tty := terminalHeight()
+	pager := pagerCommand()
+	if !tty || pager == "" || pager == "cat" || bytes.Count(out, []byte("\n")) < rows-1 {
+		_, _ = os.Stdout.Write(out)
+		return
+	}
+	cmd := shellCommand(pager)
+	cmd.Stdin = bytes.NewReader(out)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// The shell couldn't find the pager (shellNotFoundExit); other exit codes mean it ran (e.g. quit early).
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() == shellNotFoundExit {
+			_, _ = os.Stdout.Write(out)
+		}
+	}
+}
+
+// streamOutput calls render with a writer to the pager when stdout is a terminal, or to
+// stdout otherwise, so output shows up while it is rendered. Writes block while the pager
+// or terminal is behind, and are dropped once the pager has quit.
+func streamOutput(render func(w io.Writer) error) error {
+	_, tty := terminalHeight()
+	pager := pagerCommand()
+	if !tty || pager == "" || pager == "cat" {
+		return render(os.Stdout)
+	}
+	// Check the pager is there: once streamed, the output can't be written again
+	if _, err := exec.LookPath(strings.Fields(pager)[0]); err != nil {
+		return render(os.Stdout)
+	}
+	cmd := shellCommand(pager)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return render(os.Stdout)
+	}
+	if err := cmd.Start(); err != nil {
+		return render(os.Stdout)
+	}
+	err = render(in)
+	_ = in.Close()
+	_ = cmd.Wait()
+	return err
+}
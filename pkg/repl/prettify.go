@@ -0,0 +1,96 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	promparser "github.com/prometheus/prometheus/promql/parser"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// PrettifyQuery formats a PromQL expression with the upstream prettifier: expressions
+// longer than 100 characters are split over indented lines, shorter ones are normalized.
+func PrettifyQuery(expr string) (string, error) {
+	node, err := promParser.ParseExpr(expr)
+	if err != nil {
+		return "", err
+	}
+	return promparser.Prettify(node), nil
+}
+
+// PrettifyQueryFile formats the PromQL expressions of a query file (see
+// ExecuteQueriesFromFile), keeping its comments, blank lines and ad-hoc commands. Blocks
+// that don't parse, or that mix comments into an expression, are left as they are; the
+// parse errors are returned with their line numbers.
+func PrettifyQueryFile(content string) (string, error) {
+	lines := strings.Split(content, "\n")
+	var out []string
+	var errs []error
+	var block []string
+	blockStart := 0
+	flush := func() {
+		if len(block) == 0 {
+			return
+		}
+		defer func() { block = nil }()
+		var parts []string
+		for _, l := range block {
+			trimmed := strings.TrimSpace(l)
+			if strings.HasPrefix(trimmed, "#") {
+				out = append(out, block...)
+				return
+			}
+			trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, "\\"))
+			if trimmed != "" {
+				parts = append(parts, trimmed)
+			}
+		}
+		pretty, err := PrettifyQuery(strings.Join(parts, " "))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", blockStart, err))
+			out = append(out, block...)
+			return
+		}
+		out = append(out, strings.Split(pretty, "\n")...)
+	}
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		// Comments go with an expression they are in the middle of, as when running the file
+		if trimmed == "" || strings.HasPrefix(trimmed, ".") || len(block) == 0 && strings.HasPrefix(trimmed, "#") {
+			flush()
+			out = append(out, line)
+			continue
+		}
+		if len(block) == 0 {
+			blockStart = i + 1
+		}
+		block = append(block, line)
+	}
+	flush()
+	return strings.Join(out, "\n"), errors.Join(errs...)
+}
+
+// handleAdhocFmt pretty-prints a PromQL expression: .fmt <query>
+func handleAdhocFmt(query string, _ *sstorage.SimpleStorage) bool {
+	expr := strings.TrimSpace(strings.TrimPrefix(query, ".fmt"))
+	if alertExpr := GetAlertExpr(expr); alertExpr != "" {
+		expr = alertExpr
+	}
+	if expr == "" {
+		cmd := GetAdHocCommandByName(".fmt")
+		fmt.Println("Usage: " + cmd.Usage)
+		for _, ex := range cmd.Examples {
+			fmt.Println("Example: " + ex)
+		}
+		return true
+	}
+	pretty, err := PrettifyQuery(expr)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	fmt.Println(pretty)
+	return true
+}
@@ -0,0 +1,64 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrettifyQueryFile(t *testing.T) {
+	long := `sum by (job) (rate(http_requests_total{code=~"5.."}[5m])) / sum by (job) (rate(http_requests_total[5m]))`
+	in := strings.Join([]string{
+		"# Error ratio",
+		"# expect: non-empty",
+		long,
+		"",
+		".pinat now",
+		"up  ==   1",
+		"",
+		"up \\",
+		"  # not a comment of the file format",
+		"",
+		"sum(",
+		"  bad",
+		"",
+	}, "\n")
+	out, err := PrettifyQueryFile(in)
+	if err == nil || !strings.Contains(err.Error(), "line 11: ") {
+		t.Fatalf("expected a parse error at line 11, got %v", err)
+	}
+	want := strings.Join([]string{
+		"# Error ratio",
+		"# expect: non-empty",
+		"  sum by (job) (rate(http_requests_total{code=~\"5..\"}[5m]))",
+		"/",
+		"  sum by (job) (rate(http_requests_total[5m]))",
+		"",
+		".pinat now",
+		"up == 1",
+		"",
+		"up \\",
+		"  # not a comment of the file format",
+		"",
+		"sum(",
+		"  bad",
+		"",
+	}, "\n")
+	if out != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestAdhoc_Fmt(t *testing.T) {
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".fmt sum (rate( x[5m] ))", nil) })
+	if out != "sum(rate(x[5m]))\n" {
+		t.Fatalf("unexpected .fmt output: %q", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".fmt sum(", nil) })
+	if !strings.HasPrefix(out, "Error: ") {
+		t.Fatalf("expected a parse error, got: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".fmt", nil) })
+	if !strings.HasPrefix(out, "Usage: .fmt <query>") {
+		t.Fatalf("expected usage, got: %s", out)
+	}
+}
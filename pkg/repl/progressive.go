@@ -0,0 +1,112 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// defaultConfirmLines is the number of output lines above which a matrix result is only
+// printed after confirmation, unless the config file sets confirm_lines.
+const defaultConfirmLines = 5000
+
+// confirmLines is the number of output lines above which printLargeMatrix asks before
+// printing; 0 never asks.
+var confirmLines = defaultConfirmLines
+
+// askConfirm, when set by the REPL backend, asks question on the terminal and reports
+// whether the answer was yes. Without it large results are printed without asking.
+var askConfirm func(question string) bool
+
+// isYes tells whether a confirmation answer means yes.
+func isYes(answer string) bool {
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// askConfirmStdin is askConfirm for backends that leave the terminal in cooked mode while a
+// command runs: it reads the answer from stdin a byte at a time, so nothing past the line
+// is consumed.
+func askConfirmStdin(question string) bool {
+	fmt.Print(question)
+	var answer []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 0 || err != nil || b[0] == '\n' {
+			break
+		}
+		answer = append(answer, b[0])
+	}
+	return isYes(string(answer))
+}
+
+// matrixLines estimates the output lines of a matrix: a header, a line per series and
+// one per point.
+func matrixLines(m promql.Matrix) int {
+	lines := 1 + len(m)
+	for _, s := range m {
+		lines += len(s.Floats) + len(s.Histograms)
+	}
+	return lines
+}
+
+// matrixSummary describes a matrix by its series and point counts and its time span,
+// e.g. "Matrix: 1200 series, 72000 points from <start> to <end> (1h)".
+func matrixSummary(m promql.Matrix) string {
+	points := 0
+	var minT, maxT int64
+	for _, s := range m {
+		for _, ts := range seriesTimestamps(s) {
+			if points == 0 || ts < minT {
+				minT = ts
+			}
+			if points == 0 || ts > maxT {
+				maxT = ts
+			}
+			points++
+		}
+	}
+	if points == 0 {
+		return fmt.Sprintf("Matrix: %d series, no points", len(m))
+	}
+	return fmt.Sprintf("Matrix: %d series, %d points from %s to %s (%s)", len(m), points,
+		formatDisplayMillis(minT), formatDisplayMillis(maxT), model.Duration(time.Duration(maxT-minT)*time.Millisecond))
+}
+
+// seriesTimestamps returns the timestamps of the float and histogram points of s.
+func seriesTimestamps(s promql.Series) []int64 {
+	ts := make([]int64, 0, len(s.Floats)+len(s.Histograms))
+	for _, p := range s.Floats {
+		ts = append(ts, p.T)
+	}
+	for _, p := range s.Histograms {
+		ts = append(ts, p.T)
+	}
+	return ts
+}
+
+// printLargeMatrix prints a matrix result too tall for the terminal progressively: a
+// summary first, then, once confirmed when it exceeds confirmLines, the result streamed to
+// the pager or the terminal as it is rendered instead of buffered whole.
+func printLargeMatrix(result *promql.Result, m promql.Matrix) {
+	fmt.Println(matrixSummary(m))
+	arranged, _ := arrangeResult(result, resultSort, resultLimit)
+	if am, ok := arranged.Value.(promql.Matrix); ok && askConfirm != nil && confirmLines > 0 {
+		if lines := matrixLines(am); lines > confirmLines && !askConfirm(fmt.Sprintf("Print about %d lines? [y/N] ", lines)) {
+			fmt.Println("Not printed; narrow it down with .limit, a shorter range or a larger step")
+			return
+		}
+	}
+	if err := streamOutput(func(w io.Writer) error { return renderResult(w, result) }); err != nil {
+		fmt.Printf("Error rendering %s output: %v\n", outputFormat, err)
+	}
+}
@@ -0,0 +1,52 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+func TestPrintLargeMatrix_SummaryAndConfirm(t *testing.T) {
+	prevAsk, prevLines := askConfirm, confirmLines
+	defer func() { askConfirm, confirmLines = prevAsk, prevLines }()
+
+	var m promql.Matrix
+	for i := range 3 {
+		s := promql.Series{Metric: labels.FromStrings("__name__", "up", "i", string(rune('a'+i)))}
+		for j := range 4 {
+			s.Floats = append(s.Floats, promql.FPoint{T: 1700000000000 + int64(j)*60000, F: float64(j)})
+		}
+		m = append(m, s)
+	}
+	result := &promql.Result{Value: m}
+	if got := matrixLines(m); got != 16 {
+		t.Fatalf("expected 16 lines, got %d", got)
+	}
+
+	var asked string
+	askConfirm = func(q string) bool { asked = q; return false }
+	confirmLines = 10
+	out := captureStdout(t, func() { printLargeMatrix(result, m) })
+	if !strings.HasPrefix(out, "Matrix: 3 series, 12 points from ") || !strings.Contains(out, "(3m)") {
+		t.Fatalf("expected a summary first, got: %s", out)
+	}
+	if asked != "Print about 16 lines? [y/N] " || !strings.Contains(out, "Not printed") || strings.Contains(out, `i="a"`) {
+		t.Fatalf("expected the result held back after %q, got: %s", asked, out)
+	}
+
+	askConfirm = func(string) bool { return true }
+	out = captureStdout(t, func() { printLargeMatrix(result, m) })
+	if !strings.Contains(out, "Matrix (3 series):") || !strings.Contains(out, `i="c"`) {
+		t.Fatalf("expected the result once confirmed, got: %s", out)
+	}
+
+	asked = ""
+	askConfirm = func(q string) bool { asked = q; return false }
+	confirmLines = 0
+	out = captureStdout(t, func() { printLargeMatrix(result, m) })
+	if asked != "" || !strings.Contains(out, `i="c"`) {
+		t.Fatalf("expected no confirmation with confirm_lines off, got %q: %s", asked, out)
+	}
+}
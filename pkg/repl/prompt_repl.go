@@ -7,7 +7,6 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"regexp"
@@ -19,8 +18,7 @@ import (
 	"unicode"
 
 	"github.com/c-bata/go-prompt"
-	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
-	"golang.org/x/sys/unix"
+	"golang.org/x/term"
 
 	sstorage "github.com/jjo/promql-cli/pkg/storage"
 )
@@ -29,10 +27,10 @@ const suggestionLimit = 100
 
 // Global variables needed for prompt completions
 var (
-	client      v1.API
 	ctx         = context.Background()
 	metrics     []string
 	metricsHelp map[string]string // metric name -> help text
+	metricsType map[string]string // metric family -> TYPE, to rank function arguments
 	// recordingRuleSet marks names that come from recording rules so we can label them
 	recordingRuleSet map[string]bool
 	replHistory      []string
@@ -44,6 +42,8 @@ var (
 
 // promptCompleter provides completions for go-prompt
 func promptCompleter(d prompt.Document) []prompt.Suggest {
+	storeMu.Lock()
+	defer storeMu.Unlock()
 	text := d.TextBeforeCursor()
 	trimmedText := strings.TrimSpace(text)
 	emptySuggestions := []prompt.Suggest{}
@@ -62,7 +62,7 @@ func promptCompleter(d prompt.Document) []prompt.Suggest {
 	}
 
 	// Handle eager completion mode - show suggestions at start
-	eagerCompletion := os.Getenv("PROMQL_CLI_EAGER_COMPLETION") == "true"
+	eagerCompletion := settingEnv("PROMQL_CLI_EAGER_COMPLETION") == "true"
 	if eagerCompletion && text == "" {
 		return getMixedSuggests("")
 	}
@@ -102,7 +102,9 @@ func promptCompleter(d prompt.Document) []prompt.Suggest {
 			if after == "" {
 				return []prompt.Suggest{
 					{Text: "ask ", Description: "ask free text to ask the AI"},
+					{Text: "context show", Description: "preview the context sent to the AI"},
 					{Text: "edit ", Description: "prepare a suggestion for editing (Ctrl-Y to paste)"},
+					{Text: "explain ", Description: "explain a query, or 'last' for the last query and result"},
 					{Text: "run ", Description: "run a suggestion number"},
 					{Text: "show", Description: "show last AI suggestions"},
 				}
@@ -111,7 +113,9 @@ func promptCompleter(d prompt.Document) []prompt.Suggest {
 			low := strings.ToLower(after)
 			subs := []prompt.Suggest{
 				{Text: "ask ", Description: "ask free text to ask the AI"},
+				{Text: "context show", Description: "preview the context sent to the AI"},
 				{Text: "edit ", Description: "prepare a suggestion for editing (Ctrl-Y to paste)"},
+				{Text: "explain ", Description: "explain a query, or 'last' for the last query and result"},
 				{Text: "run ", Description: "run a suggestion number"},
 				{Text: "show", Description: "show last AI suggestions"},
 			}
@@ -160,7 +164,15 @@ func promptCompleter(d prompt.Document) []prompt.Suggest {
 		}
 
 		// Handle .scrape, .prom_scrape and .prom_scrape_range URL completions FIRST
-		if strings.HasPrefix(trimmedText, ".scrape") || strings.HasPrefix(trimmedText, ".prom_scrape") || strings.HasPrefix(trimmedText, ".prom_scrape_range") {
+		if strings.HasPrefix(trimmedText, ".scrape") && !strings.HasPrefix(trimmedText, ".scrape_config") || strings.HasPrefix(trimmedText, ".prom_scrape") || strings.HasPrefix(trimmedText, ".prom_scrape_range") {
+			// .scrape_diff takes two URLs
+			if strings.Contains(text, ".scrape_diff ") {
+				afterCmd := text[strings.Index(text, ".scrape_diff ")+len(".scrape_diff "):]
+				if fields := strings.Fields(afterCmd); len(fields) < 2 || len(fields) == 2 && !strings.HasSuffix(afterCmd, " ") {
+					return getScrapeURLCompletions(wordBefore)
+				}
+				return emptySuggestions
+			}
 			if strings.Contains(text, ".scrape ") {
 				cmdEnd := strings.Index(text, ".scrape ") + 8
 				afterCmd := strings.TrimSpace(text[cmdEnd:])
@@ -175,9 +187,9 @@ func promptCompleter(d prompt.Document) []prompt.Suggest {
 		}
 
 		// Check if we're in a special ad-hoc command context for metric completion
-		if strings.Contains(text, ".labels ") || strings.Contains(text, ".timestamps ") ||
-			strings.Contains(text, ".drop ") || strings.Contains(text, ".seed ") {
-			// We're after .labels/.timestamps/.drop/.seed, show metric completions
+		if strings.Contains(text, ".labels ") || strings.Contains(text, ".meta ") || strings.Contains(text, ".timestamps ") ||
+			strings.Contains(text, ".drop ") || strings.Contains(text, ".seed ") || strings.Contains(text, ".exemplars ") {
+			// We're after .labels/.meta/.timestamps/.drop/.seed/.exemplars, show metric completions
 			if lastSpace := strings.LastIndex(text, " "); lastSpace != -1 {
 				wordAfterSpace := text[lastSpace+1:]
 				return getMetricSuggests(wordAfterSpace)
@@ -185,8 +197,9 @@ func promptCompleter(d prompt.Document) []prompt.Suggest {
 			return emptySuggestions
 		}
 
-		// Check if we're after .load, .save, or .source for file completions
-		if strings.Contains(text, ".load ") || strings.Contains(text, ".save ") || strings.Contains(text, ".source ") {
+		// Check if we're after .load, .save, .export, .source, .scrape_config or .grafana_import for file completions
+		if strings.Contains(text, ".load ") || strings.Contains(text, ".save ") || strings.Contains(text, ".export ") || strings.Contains(text, ".source ") ||
+			strings.Contains(text, ".scrape_config ") || strings.Contains(text, ".grafana_import ") {
 			if lastSpace := strings.LastIndex(text, " "); lastSpace != -1 {
 				pathPrefix := text[lastSpace+1:]
 				return getFileCompletions(pathPrefix)
@@ -194,6 +207,53 @@ func promptCompleter(d prompt.Document) []prompt.Suggest {
 			return emptySuggestions
 		}
 
+		// Handle .run / .undefine named query completions
+		for _, cmd := range []string{".run ", ".undefine "} {
+			if strings.HasPrefix(strings.TrimLeft(text, " \t"), cmd) {
+				afterCmd := strings.TrimPrefix(strings.TrimLeft(text, " \t"), cmd)
+				if strings.ContainsAny(afterCmd, "( ") {
+					return emptySuggestions
+				}
+				loadNamedQueries()
+				suggestions := []prompt.Suggest{}
+				for _, q := range NamedQueries() {
+					if strings.HasPrefix(q.Name, afterCmd) {
+						suggestions = append(suggestions, prompt.Suggest{Text: q.Name, Description: q.Signature() + " = " + q.Expr})
+					}
+				}
+				return suggestions
+			}
+		}
+
+		// Handle .format output format completions
+		if strings.HasPrefix(trimmedText, ".format") && strings.Contains(text, ".format ") {
+			suggestions := []prompt.Suggest{}
+			for _, name := range FormatNames() {
+				if strings.HasPrefix(name, wordBefore) {
+					suggestions = append(suggestions, prompt.Suggest{Text: name, Description: "output format"})
+				}
+			}
+			return suggestions
+		}
+
+		// Handle .range <start> <end> <step> <query> completions
+		if strings.HasPrefix(trimmedText, ".range ") {
+			afterCmd := strings.TrimPrefix(strings.TrimLeft(text, " \t"), ".range ")
+			fields := strings.Fields(afterCmd)
+			typing := len(fields)
+			if typing > 0 && !strings.HasSuffix(afterCmd, " ") {
+				typing--
+			}
+			switch typing {
+			case 0, 1:
+				return getTimeCompletions(wordBefore)
+			case 2:
+				return getRangeStepSuggests(wordBefore)
+			default:
+				return getMixedSuggests(wordBefore)
+			}
+		}
+
 		// Handle .at and .pinat time completions
 		if strings.HasPrefix(trimmedText, ".at") || strings.HasPrefix(trimmedText, ".pinat") {
 			if strings.Contains(text, ".at ") || strings.Contains(text, ".pinat ") {
@@ -242,8 +302,9 @@ func promptCompleter(d prompt.Document) []prompt.Suggest {
 	case "label_value":
 		return getLabelValueSuggests(wordBefore, context.MetricName, context.LabelName)
 	case "function_arg":
-		// Inside function args, offer both functions and metrics (functions like rate() are common)
-		return getMixedSuggests(wordBefore)
+		// Inside function args, offer both functions and metrics (functions like rate() are common),
+		// with the metrics of the type the function takes first
+		return getMixedSuggestsFor(wordBefore, context.FunctionName)
 	case "after_operator":
 		// After operator, show metrics
 		return getMetricSuggests(wordBefore)
@@ -294,6 +355,11 @@ func getScrapeURLCompletions(prefix string) []prompt.Suggest {
 		{Text: "http://localhost:9115/metrics", Description: "Blackbox Exporter metrics"},
 		{Text: "http://localhost:2112/metrics", Description: "Common exporter port"},
 	}
+	favorites := make([]prompt.Suggest, 0, len(favoriteScrapeURLs))
+	for _, u := range favoriteScrapeURLs {
+		favorites = append(favorites, prompt.Suggest{Text: u, Description: "From config scrape_urls"})
+	}
+	urlOptions = append(favorites, urlOptions...)
 
 	// Filter based on prefix
 	if prefix == "" {
@@ -409,7 +475,7 @@ func getFileCompletions(prefix string) []prompt.Suggest {
 			path := filepath.Join(dir, name)
 			if f.IsDir() {
 				suggestions = append(suggestions, prompt.Suggest{
-					Text:        path + "/",
+					Text:        path + string(filepath.Separator),
 					Description: "directory",
 				})
 			} else {
@@ -516,6 +582,12 @@ func getFunctionSuggests(prefix string) []prompt.Suggest {
 
 // getMetricSuggests returns metric suggestions based on prefix
 func getMetricSuggests(prefix string) []prompt.Suggest {
+	return getMetricSuggestsFor(prefix, "")
+}
+
+// getMetricSuggestsFor returns metric suggestions based on prefix for an argument of the
+// function fn, listing first the metrics of the TYPE it takes (see rankMetricsForFunction).
+func getMetricSuggestsFor(prefix, fn string) []prompt.Suggest {
 	if len(metrics) == 0 {
 		// Try to fetch metrics if not already loaded
 		fetchMetrics()
@@ -527,7 +599,20 @@ func getMetricSuggests(prefix string) []prompt.Suggest {
 	// Sort metrics to ensure consistent ordering
 	sortedMetrics := make([]string, len(metrics))
 	copy(sortedMetrics, metrics)
+	// Add the remote metrics not loaded locally (in proxy mode metrics already are remote)
+	if !remoteProxy {
+		seen := make(map[string]bool, len(metrics))
+		for _, m := range metrics {
+			seen[m] = true
+		}
+		for _, m := range remoteMetricNames() {
+			if !seen[m] {
+				sortedMetrics = append(sortedMetrics, m)
+			}
+		}
+	}
 	sort.Strings(sortedMetrics)
+	sortedMetrics = rankMetricsForFunction(sortedMetrics, metricsType, fn)
 
 	for _, m := range sortedMetrics {
 		if count >= 100 { // Limit suggestions
@@ -553,6 +638,8 @@ func getMetricSuggests(prefix string) []prompt.Suggest {
 					// Default label depends on whether this name is a recording rule
 					if recordingRuleSet != nil && recordingRuleSet[m] {
 						description = "(rule)"
+					} else if typ := metricTypeOf(metricsType, m); typ != "" {
+						description = "(" + typ + ")"
 					} else {
 						description = "(metric)"
 					}
@@ -606,8 +693,28 @@ func getRangeDurationSuggests(prefix string, _ string) []prompt.Suggest {
 	return filtered
 }
 
+// getRangeStepSuggests returns step completions for the .range command
+func getRangeStepSuggests(prefix string) []prompt.Suggest {
+	steps := []prompt.Suggest{
+		{Text: "15s", Description: "15 seconds"},
+		{Text: "30s", Description: "30 seconds"},
+		{Text: "1m", Description: "1 minute"},
+		{Text: "5m", Description: "5 minutes"},
+		{Text: "15m", Description: "15 minutes"},
+		{Text: "1h", Description: "1 hour"},
+	}
+
+	filtered := []prompt.Suggest{}
+	for _, s := range steps {
+		if strings.HasPrefix(s.Text, prefix) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
 // Global variable to store the original terminal state for restoration
-var globalOriginalState string
+var globalOriginalState *term.State
 
 // Global variables for prefix-based history navigation
 var (
@@ -619,6 +726,11 @@ var (
 	historyActive   bool   // true while navigating with Up/Down
 	historySeed     string // full line captured at activation (to restore on Down at end)
 	historyLastLine string // last line inserted by history navigation
+
+	// State for Ctrl-R search of history entries containing the typed text
+	searchQuery string // text typed before the first Ctrl-R
+	searchIndex int    // index in replHistory of the entry shown
+	searchLine  string // entry shown, to detect edits since the last Ctrl-R
 )
 
 // Global variables for multi-line editing
@@ -640,10 +752,8 @@ func promptExecutor(s string) {
 
 	// Handle multi-line input (commands with embedded newlines)
 	if strings.Contains(s, "\n") {
-		// Process multi-line PromQL query
-		// Replace newlines with spaces for PromQL parsing
-		s = strings.ReplaceAll(s, "\n", " ")
-		s = strings.TrimSpace(s)
+		// Process multi-line PromQL query, keeping the newlines that end its # comments
+		s = joinContinuedLines(strings.Split(s, "\n"))
 		if s == "" {
 			return
 		}
@@ -663,13 +773,21 @@ func promptExecutor(s string) {
 			return
 		}
 
-		// If we're in multi-line mode from backslash continuation, combine all lines
+		// Continue on a new line while the expression has unclosed brackets
+		if needsContinuation(strings.Join(append(multiLineBuffer, s), "\n")) {
+			if s != "" {
+				multiLineBuffer = append(multiLineBuffer, s)
+			}
+			inMultiLine = true
+			return
+		}
+
+		// If we're in multi-line mode from continuation, combine all lines
 		if inMultiLine {
 			multiLineBuffer = append(multiLineBuffer, s)
-			s = strings.Join(multiLineBuffer, " ")
+			s = joinContinuedLines(multiLineBuffer)
 			multiLineBuffer = nil
 			inMultiLine = false
-			s = strings.TrimSpace(s)
 			if s == "" {
 				return
 			}
@@ -680,21 +798,20 @@ func promptExecutor(s string) {
 	if s == "quit" || s == ".quit" {
 		// Save history before exiting
 		saveHistory()
+		_ = StopProfiling()
 		fmt.Println("\nExiting...")
 		// Restore terminal state before exiting
-		if globalOriginalState != "" {
-			restoreTerminalState(globalOriginalState)
-		}
+		restoreTerminalState(globalOriginalState)
 		os.Exit(0)
 	}
 
 	// Add to history
 	if s != "" && !strings.HasPrefix(s, " ") { // Don't save empty or space-prefixed commands
 		// Avoid adding consecutive duplicates
-		if len(replHistory) == 0 || replHistory[len(replHistory)-1] != s {
-			replHistory = append(replHistory, s)
+		if entry := historyLine(s); len(replHistory) == 0 || replHistory[len(replHistory)-1] != entry {
+			replHistory = append(replHistory, entry)
 			// Save to file immediately for persistence
-			appendToHistoryFile(s)
+			appendToHistoryFile(entry)
 		}
 		// Track for Alt+. functionality
 		lastExecutedCommand = s
@@ -755,8 +872,7 @@ func launchExternalEditor(buf *prompt.Buffer) {
 	rawState := saveTerminalState()
 	restoreTerminalState(globalOriginalState)
 
-	cmdStr := fmt.Sprintf("%s %s", editor, shellQuote(path))
-	cmd := exec.Command("/bin/sh", "-c", cmdStr)
+	cmd := shellCommand(fmt.Sprintf("%s %s", editor, shellQuote(path)))
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -796,87 +912,35 @@ func drainStdin() {
 	drainFD(int(os.Stdin.Fd()))
 }
 
-// drainFD drains any immediately available bytes from the given file descriptor in non-blocking mode.
-func drainFD(fd int) {
-	// Set non-blocking; ignore errors where unsupported
-	_ = unix.SetNonblock(fd, true)
-	defer func() { _ = unix.SetNonblock(fd, false) }()
-	buf := make([]byte, 8192)
-	for {
-		n, err := unix.Read(fd, buf)
-		if n <= 0 {
-			if err == nil || err == unix.EAGAIN || err == unix.EWOULDBLOCK {
-				break
-			}
-			break
-		}
-		// Continue reading until no more data is immediately available
-	}
-}
-
 // getAISuggestionMenu builds the dropdown items for AI selection (edit first, then run)
 func getAISuggestionMenu() []prompt.Suggest {
-	var items []prompt.Suggest
-	if len(lastAISuggestions) == 0 {
-		return items
-	}
-	// First: edit
-	for i, q := range lastAISuggestions {
-		preview := strings.TrimSpace(q)
-		if len(preview) > 120 {
-			preview = preview[:120] + "..."
-		}
-		if i < len(lastAIExplanations) && strings.TrimSpace(lastAIExplanations[i]) != "" {
-			ex := strings.TrimSpace(lastAIExplanations[i])
-			if len(ex) > 80 {
-				ex = ex[:80] + "..."
-			}
-			preview = preview + " — " + ex
-		}
-		items = append(items, prompt.Suggest{Text: fmt.Sprintf(".ai edit %d", i+1), Description: preview})
-	}
-	// Then: run
-	for i, q := range lastAISuggestions {
-		preview := strings.TrimSpace(q)
-		if len(preview) > 120 {
-			preview = preview[:120] + "..."
-		}
-		if i < len(lastAIExplanations) && strings.TrimSpace(lastAIExplanations[i]) != "" {
-			ex := strings.TrimSpace(lastAIExplanations[i])
-			if len(ex) > 80 {
-				ex = ex[:80] + "..."
-			}
-			preview = preview + " — " + ex
-		}
-		items = append(items, prompt.Suggest{Text: fmt.Sprintf(".ai run %d", i+1), Description: preview})
+	return toPromptSuggests(aiSuggestionItems(".ai ", lastAISuggestions, lastAIExplanations))
+}
+
+// toPromptSuggests converts AI selection menu items to go-prompt suggestions.
+func toPromptSuggests(items []aiSuggestionItem) []prompt.Suggest {
+	out := make([]prompt.Suggest, 0, len(items))
+	for _, it := range items {
+		out = append(out, prompt.Suggest{Text: it.Text, Description: it.Description})
 	}
-	return items
+	return out
 }
 
 type promptREPL struct {
 	prompt *prompt.Prompt
 }
 
-//nolint:unused // used from interactive keybinds in future integrations
+// pasteAISuggestionN replaces the line with the Nth AI suggestion, or with n=0 the one
+// prepared by .ai edit (see aiPasteText).
 func pasteAISuggestionN(buf *prompt.Buffer, n int) {
-	if n <= 0 || n > len(lastAISuggestions) {
-		return
-	}
-	s := lastAISuggestions[n-1]
-	if s == "" {
+	text := aiPasteText(n)
+	if text == "" {
 		return
 	}
-	// Append helper text if available
-	if n-1 < len(lastAIExplanations) {
-		if ex := strings.TrimSpace(lastAIExplanations[n-1]); ex != "" {
-			s = s + " # " + ex
-		}
-	}
-	// Replace entire line with the chosen suggestion
 	doc := buf.Document()
 	buf.CursorLeft(len([]rune(doc.TextBeforeCursor())))
 	buf.Delete(len(doc.Text))
-	buf.InsertText(s, false, true)
+	buf.InsertText(text, false, true)
 }
 
 // runAIPicker opens a temporary go-prompt to pick an AI suggestion.
@@ -888,44 +952,8 @@ func runAIPicker(validQ []string, validE []string) bool {
 		return false
 	}
 
-	// Build suggestion list: "run N" and "edit N" with query preview
-	var items []prompt.Suggest
-	// First, all edit options
-	for i, q := range validQ {
-		preview := strings.TrimSpace(q)
-		if len(preview) > 120 {
-			preview = preview[:120] + "..."
-		}
-		if i < len(validE) && strings.TrimSpace(validE[i]) != "" {
-			ex := strings.TrimSpace(validE[i])
-			if len(ex) > 80 {
-				ex = ex[:80] + "..."
-			}
-			preview = preview + " — " + ex
-		}
-		items = append(
-			items,
-			prompt.Suggest{Text: fmt.Sprintf("edit %d", i+1), Description: preview},
-		)
-	}
-	// Then, all run options
-	for i, q := range validQ {
-		preview := strings.TrimSpace(q)
-		if len(preview) > 120 {
-			preview = preview[:120] + "..."
-		}
-		if i < len(validE) && strings.TrimSpace(validE[i]) != "" {
-			ex := strings.TrimSpace(validE[i])
-			if len(ex) > 80 {
-				ex = ex[:80] + "..."
-			}
-			preview = preview + " — " + ex
-		}
-		items = append(
-			items,
-			prompt.Suggest{Text: fmt.Sprintf("run %d", i+1), Description: preview},
-		)
-	}
+	// Build suggestion list: "edit N" and "run N" with query preview
+	items := toPromptSuggests(aiSuggestionItems("", validQ, validE))
 
 	selected := false
 	pickerExec := func(in string) {
@@ -1017,8 +1045,14 @@ func (r *promptREPL) Run() error {
 				fmt.Println("\nAI request canceled")
 				continue
 			}
+			// A running .watch handles its own interrupt; keep the REPL alive
+			if watchCancel != nil {
+				watchCancel()
+				continue
+			}
 			// Otherwise exit cleanly
 			saveHistory()
+			_ = StopProfiling()
 			fmt.Println("\nInterrupted. Exiting...")
 			restoreTerminalState(originalState)
 			if r.prompt != nil {
@@ -1028,7 +1062,7 @@ func (r *promptREPL) Run() error {
 	}()
 
 	// Check if eager completion is enabled
-	eagerCompletion := os.Getenv("PROMQL_CLI_EAGER_COMPLETION") == "true"
+	eagerCompletion := settingEnv("PROMQL_CLI_EAGER_COMPLETION") == "true"
 
 	// Create the prompt with proper options
 	opts := []prompt.Option{
@@ -1140,6 +1174,25 @@ func (r *promptREPL) Run() error {
 				}
 			},
 		}),
+		// Ctrl-R: replace the line with the most recent history entry containing the typed
+		// text; repeat for older matches
+		prompt.OptionAddKeyBind(prompt.KeyBind{
+			Key: prompt.ControlR,
+			Fn: func(buf *prompt.Buffer) {
+				if searchLine == "" || buf.Text() != searchLine {
+					searchQuery, searchIndex = buf.Text(), len(replHistory)
+				}
+				idx := ReverseSearchHistory(searchQuery, buf.Text(), replHistory, searchIndex)
+				if idx < 0 {
+					return
+				}
+				buf.DeleteBeforeCursor(len([]rune(buf.Document().TextBeforeCursor())))
+				buf.Delete(len([]rune(buf.Document().TextAfterCursor())))
+				buf.InsertText(replHistory[idx], false, true)
+				searchIndex, searchLine = idx, replHistory[idx]
+				resetHistoryState()
+			},
+		}),
 		// Ctrl-C: cancel in-flight AI or clear current line
 		prompt.OptionAddKeyBind(prompt.KeyBind{
 			Key: prompt.ControlC,
@@ -1148,6 +1201,8 @@ func (r *promptREPL) Run() error {
 					aiCancelRequest()
 					return
 				}
+				// Cancel a multi-line query being typed
+				multiLineBuffer, inMultiLine = nil, false
 				// Clear line (do not submit 0x03 as input)
 				doc := buf.Document()
 				buf.CursorLeft(len([]rune(doc.TextBeforeCursor())))
@@ -1308,6 +1363,7 @@ func (r *promptREPL) Run() error {
 				if buf.Text() == "" {
 					// Exit on empty line
 					saveHistory()
+					_ = StopProfiling()
 					fmt.Println("\nExiting...")
 					os.Exit(0)
 				}
@@ -1402,38 +1458,9 @@ func (r *promptREPL) Run() error {
 		prompt.OptionAddKeyBind(prompt.KeyBind{
 			Key: prompt.ControlY,
 			Fn: func(buf *prompt.Buffer) {
-				if aiClipboard == "" {
-					// If clipboard is empty but we have suggestions, default to first
-					if len(lastAISuggestions) > 0 {
-						aiClipboard = lastAISuggestions[0]
-					} else {
-						return
-					}
-				}
-				// Replace entire line with clipboard content, appending helper text when available
-				doc := buf.Document()
-				buf.CursorLeft(len([]rune(doc.TextBeforeCursor())))
-				buf.Delete(len(doc.Text))
-				text := aiClipboard
-				// Try to find matching explanation
-				idx := -1
-				for i, q := range lastAISuggestions {
-					if strings.TrimSpace(q) == strings.TrimSpace(aiClipboard) {
-						idx = i
-						break
-					}
-				}
-				if idx >= 0 && idx < len(lastAIExplanations) {
-					if ex := strings.TrimSpace(lastAIExplanations[idx]); ex != "" {
-						text = text + " # " + ex
-					}
-				}
-				buf.InsertText(text, false, true)
+				pasteAISuggestionN(buf, 0)
 			},
 		}),
-		// Alt-1..Alt-9: Paste corresponding AI suggestion directly
-		// Note: ESC+<digit> sequences
-
 		// Ctrl-L: Clear screen
 		prompt.OptionAddKeyBind(prompt.KeyBind{
 			Key: prompt.ControlL,
@@ -1558,10 +1585,22 @@ func (r *promptREPL) Run() error {
 		),
 	}
 
+	// Alt-1..Alt-9 (ESC+<digit>): Paste the corresponding AI suggestion directly
+	for d := byte('1'); d <= '9'; d++ {
+		n := int(d - '0')
+		opts = append(opts, prompt.OptionAddASCIICodeBind(prompt.ASCIICodeBind{
+			ASCIICode: []byte{0x1b, d},
+			Fn: func(buf *prompt.Buffer) {
+				pasteAISuggestionN(buf, n)
+			},
+		}))
+	}
+
 	// Add option to show completions at start only if eager completion is enabled
 	if eagerCompletion {
 		opts = append(opts, prompt.OptionShowCompletionAtStart())
 	}
+	opts = append(opts, syntaxHighlightOptions()...)
 
 	// Initialize metrics for completion
 	fetchMetrics()
@@ -1572,6 +1611,10 @@ func (r *promptREPL) Run() error {
 		opts...,
 	)
 
+	// go-prompt restores the cooked terminal while a command runs, so it can read answers
+	askConfirm = askConfirmStdin
+	defer func() { askConfirm = nil }()
+
 	// Run the prompt - this will handle terminal restoration on exit
 	r.prompt.Run()
 
@@ -1579,9 +1622,7 @@ func (r *promptREPL) Run() error {
 	saveHistory()
 
 	// Restore terminal state when exiting normally
-	if originalState != "" {
-		restoreTerminalState(originalState)
-	}
+	restoreTerminalState(originalState)
 
 	return nil
 }
@@ -1593,13 +1634,14 @@ func (r *promptREPL) Close() error {
 
 // fetchMetrics fetches available metrics for completion
 func fetchMetrics() {
-	// Try to get metrics from globalStorage if available
-	if globalStorage != nil {
+	// Try to get metrics from globalStorage if available (unless proxying to a remote Prometheus)
+	if globalStorage != nil && !remoteProxy {
 		if storage, ok := globalStorage.(*sstorage.SimpleStorage); ok && storage != nil {
 			// Build a de-duplicated metrics list and track recording rule names
 			seen := make(map[string]bool)
 			metrics = make([]string, 0, len(storage.Metrics))
 			metricsHelp = storage.MetricsHelp // Use the help text from storage
+			metricsType = storage.MetricsType
 			recordingRuleSet = make(map[string]bool)
 			for name := range storage.Metrics {
 				if !seen[name] {
@@ -1646,14 +1688,32 @@ func fetchMetrics() {
 	for _, lbl := range lbls {
 		metrics = append(metrics, string(lbl))
 	}
+	recordingRuleSet = make(map[string]bool)
+	metricsHelp = make(map[string]string)
+	metricsType = make(map[string]string)
+	if md, err := client.Metadata(ctx, "", ""); err == nil {
+		for name, entries := range md {
+			if len(entries) > 0 && entries[0].Help != "" {
+				metricsHelp[name] = entries[0].Help
+			}
+			if len(entries) > 0 && entries[0].Type != "" {
+				metricsType[name] = string(entries[0].Type)
+			}
+		}
+	}
 }
 
 // getMixedSuggests returns both metrics and functions (metrics prioritized)
 func getMixedSuggests(prefix string) []prompt.Suggest {
+	return getMixedSuggestsFor(prefix, "")
+}
+
+// getMixedSuggestsFor is getMixedSuggests for an argument of the function fn.
+func getMixedSuggestsFor(prefix, fn string) []prompt.Suggest {
 	var suggestions []prompt.Suggest
 
 	// Add metric suggestions FIRST (prioritized)
-	metricSuggests := getMetricSuggests(prefix)
+	metricSuggests := getMetricSuggestsFor(prefix, fn)
 	for i := range metricSuggests {
 		if len(suggestions) >= 50 {
 			break
@@ -1673,29 +1733,6 @@ func getMixedSuggests(prefix string) []prompt.Suggest {
 	return suggestions
 }
 
-// saveTerminalState saves the current terminal state using stty
-func saveTerminalState() string {
-	cmd := exec.Command("stty", "-g")
-	cmd.Stdin = os.Stdin
-	out, err := cmd.Output()
-	if err != nil {
-		return ""
-	}
-	return strings.TrimSpace(string(out))
-}
-
-// restoreTerminalState restores terminal state using stty
-func restoreTerminalState(state string) {
-	if state == "" {
-		return
-	}
-	cmd := exec.Command("stty", state)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	_ = cmd.Run()
-}
-
 // PromQLContext represents the current context in a PromQL expression
 type PromQLContext struct {
 	Type         string // "function_arg", "label_name", "label_value", "range_duration", "after_operator", etc.
@@ -1796,26 +1833,17 @@ func analyzePromQLContext(text string) PromQLContext {
 
 // getLabelNameSuggests returns label name suggestions for a metric
 func getLabelNameSuggests(prefix string, metricName string) []prompt.Suggest {
-	if globalStorage == nil {
-		return []prompt.Suggest{}
-	}
-
-	storage, ok := globalStorage.(*sstorage.SimpleStorage)
-	if !ok || storage == nil {
-		return []prompt.Suggest{}
-	}
-
 	// Collect unique label names from the metric
 	labelNames := make(map[string]bool)
-	if samples, exists := storage.Metrics[metricName]; exists {
-		for _, sample := range samples {
-			for labelName := range sample.Labels {
-				if labelName != "__name__" {
-					labelNames[labelName] = true
-				}
-			}
+	if storage, ok := globalStorage.(*sstorage.SimpleStorage); ok && storage != nil && !remoteProxy && metricName != "" {
+		for _, labelName := range storage.LabelNamesOf(metricName) {
+			labelNames[labelName] = true
 		}
 	}
+	// Add the remote label names, fetched in the background for metrics not pulled yet
+	for _, n := range remoteLabelNames(metricName) {
+		labelNames[n] = true
+	}
 
 	var suggestions []prompt.Suggest
 	for labelName := range labelNames {
@@ -1836,24 +1864,16 @@ func getLabelNameSuggests(prefix string, metricName string) []prompt.Suggest {
 
 // getLabelValueSuggests returns label value suggestions for a specific label
 func getLabelValueSuggests(prefix string, metricName string, labelName string) []prompt.Suggest {
-	if globalStorage == nil {
-		return []prompt.Suggest{}
-	}
-
-	storage, ok := globalStorage.(*sstorage.SimpleStorage)
-	if !ok || storage == nil {
-		return []prompt.Suggest{}
-	}
-
 	// Collect unique label values
 	labelValues := make(map[string]bool)
-	if samples, exists := storage.Metrics[metricName]; exists {
-		for _, sample := range samples {
-			if value, hasLabel := sample.Labels[labelName]; hasLabel {
-				labelValues[value] = true
-			}
+	if storage, ok := globalStorage.(*sstorage.SimpleStorage); ok && storage != nil && !remoteProxy && metricName != "" {
+		for _, value := range storage.LabelValuesOf(metricName, labelName) {
+			labelValues[value] = true
 		}
 	}
+	for _, v := range remoteLabelValues(metricName, labelName) {
+		labelValues[v] = true
+	}
 
 	// Check if prefix already has quotes
 	prefixHasOpenQuote := strings.HasPrefix(prefix, "\"")
@@ -2108,7 +2128,7 @@ func shouldSuppressCompletions(text, trimmedText string) bool {
 	}
 
 	// Check eager completion setting
-	eagerCompletion := os.Getenv("PROMQL_CLI_EAGER_COMPLETION") == "true"
+	eagerCompletion := settingEnv("PROMQL_CLI_EAGER_COMPLETION") == "true"
 
 	if !eagerCompletion {
 		// Don't show suggestions at the start of a new line - wait for Tab or typing
@@ -3,6 +3,9 @@
 package repl
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -39,3 +42,55 @@ func TestRecordingRuleKeepsHelpDescription(t *testing.T) {
 		t.Fatalf("expected description %q, got %q", want, got)
 	}
 }
+
+func TestMetricSuggestsRankedByFunction(t *testing.T) {
+	metrics = []string{"api_latency_seconds_bucket", "api_requests_total", "api_temperature"}
+	metricsHelp = map[string]string{}
+	metricsType = map[string]string{"api_latency_seconds": "histogram", "api_requests": "counter", "api_temperature": "gauge"}
+	recordingRuleSet = map[string]bool{}
+	defer func() { metricsType = nil }()
+
+	sugg := getMixedSuggestsFor("api_", "rate")
+	if len(sugg) < 3 || sugg[0].Text != "api_requests_total" {
+		t.Fatalf("expected counters first inside rate(, got %v", sugg)
+	}
+	if got, want := sugg[0].Description, "(counter)"; got != want {
+		t.Fatalf("expected description %q, got %q", want, got)
+	}
+	if sugg = getMetricSuggestsFor("api_", "avg_over_time"); sugg[0].Text != "api_temperature" {
+		t.Fatalf("expected gauges first inside avg_over_time(, got %v", sugg)
+	}
+}
+
+func TestPromptExecutor_CommentedMultiLineQuery(t *testing.T) {
+	histPath := filepath.Join(t.TempDir(), "history")
+	t.Setenv("PROMQL_CLI_HISTORY", histPath)
+	oldExec, oldHist := executeOneFunc, replHistory
+	defer func() { executeOneFunc, replHistory = oldExec, oldHist }()
+	replHistory = nil
+
+	store := newTestStore(t)
+	engine := newTestEngine()
+	var out string
+	executeOneFunc = func(s string) {
+		out = captureStdout(t, func() { executeOne(engine, store, s) })
+	}
+	for _, line := range []string{"sum(", "  http_requests_total # all codes", ")"} {
+		promptExecutor(line)
+	}
+	if strings.Contains(out, "rror") || !strings.Contains(out, "1030") {
+		t.Fatalf("commented multi-line query failed:\n%s", out)
+	}
+
+	want := "sum( http_requests_total )"
+	if len(replHistory) != 1 || replHistory[0] != want {
+		t.Fatalf("history = %q, want [%q]", replHistory, want)
+	}
+	data, err := os.ReadFile(histPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != want {
+		t.Fatalf("history file = %q, want %q", got, want)
+	}
+}
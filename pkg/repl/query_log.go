@@ -0,0 +1,269 @@
+package repl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// queryLogLine is one line of the Prometheus query log (--query.log-file, or
+// query_log_file in its config): a JSON document per query or rule evaluation.
+type queryLogLine struct {
+	Params struct {
+		Query string    `json:"query"`
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+		Step  float64   `json:"step"` // seconds, 0 for instant queries
+	} `json:"params"`
+	Stats struct {
+		Timings struct {
+			EvalTotalTime float64 `json:"evalTotalTime"`
+			ExecTotalTime float64 `json:"execTotalTime"`
+		} `json:"timings"`
+		Samples *struct {
+			TotalQueryableSamples int64 `json:"totalQueryableSamples"`
+			PeakSamples           int   `json:"peakSamples"`
+		} `json:"samples"`
+	} `json:"stats"`
+	RuleGroup *struct {
+		Name string `json:"name"`
+	} `json:"ruleGroup"`
+}
+
+// QueryLogStat aggregates the logged evaluations of an expression of one kind: instant,
+// range or rule (evaluated by a rule group).
+type QueryLogStat struct {
+	Query        string  `json:"query"`
+	Kind         string  `json:"kind"`
+	Count        int     `json:"count"`
+	TotalSeconds float64 `json:"total_seconds"`
+	AvgSeconds   float64 `json:"avg_seconds"`
+	MaxSeconds   float64 `json:"max_seconds"`
+	PeakSamples  int     `json:"peak_samples,omitempty"`
+	// Range and Step are those of the last logged range query, used to replay it
+	Range  time.Duration   `json:"-"`
+	Step   time.Duration   `json:"-"`
+	Replay *QueryLogReplay `json:"replay,omitempty"`
+}
+
+// QueryLogReplay is the evaluation of a logged expression against the local store.
+type QueryLogReplay struct {
+	Seconds float64 `json:"seconds"`
+	Series  int     `json:"series"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// QueryLog is the summary of a query log.
+type QueryLog struct {
+	Queries int            `json:"queries"`
+	Skipped int            `json:"skipped"` // lines that aren't logged queries
+	Stats   []QueryLogStat `json:"expressions"`
+}
+
+// ParseQueryLog reads a Prometheus query log and aggregates its queries by expression,
+// sorted by count.
+func ParseQueryLog(r io.Reader) (QueryLog, error) {
+	var ql QueryLog
+	byKey := map[string]*QueryLogStat{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var l queryLogLine
+		if err := json.Unmarshal([]byte(line), &l); err != nil || l.Params.Query == "" {
+			ql.Skipped++
+			continue
+		}
+		ql.Queries++
+		query := normalizeLoggedQuery(l.Params.Query)
+		kind := "instant"
+		switch {
+		case l.RuleGroup != nil:
+			kind = "rule"
+		case l.Params.Step > 0 && l.Params.End.After(l.Params.Start):
+			kind = "range"
+		}
+		st := byKey[kind+"\x00"+query]
+		if st == nil {
+			st = &QueryLogStat{Query: query, Kind: kind}
+			byKey[kind+"\x00"+query] = st
+		}
+		secs := l.Stats.Timings.EvalTotalTime
+		if secs == 0 {
+			secs = l.Stats.Timings.ExecTotalTime
+		}
+		st.Count++
+		st.TotalSeconds += secs
+		st.MaxSeconds = max(st.MaxSeconds, secs)
+		if l.Stats.Samples != nil {
+			st.PeakSamples = max(st.PeakSamples, l.Stats.Samples.PeakSamples)
+		}
+		if kind == "range" {
+			st.Range = l.Params.End.Sub(l.Params.Start)
+			st.Step = time.Duration(l.Params.Step * float64(time.Second))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ql, err
+	}
+	for _, st := range byKey {
+		st.AvgSeconds = st.TotalSeconds / float64(st.Count)
+		ql.Stats = append(ql.Stats, *st)
+	}
+	err := SortQueryLogStats(ql.Stats, "count")
+	return ql, err
+}
+
+// normalizeLoggedQuery formats query as PromQL does, so that spellings of an expression
+// differing in spacing or quoting aggregate together. Queries that don't parse are kept
+// as they are.
+func normalizeLoggedQuery(query string) string {
+	if expr, err := promParser.ParseExpr(query); err == nil {
+		return expr.String()
+	}
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// SortQueryLogStats sorts stats by count, total, max or avg seconds, highest first.
+func SortQueryLogStats(stats []QueryLogStat, by string) error {
+	var key func(QueryLogStat) float64
+	switch by {
+	case "count":
+		key = func(s QueryLogStat) float64 { return float64(s.Count) }
+	case "total":
+		key = func(s QueryLogStat) float64 { return s.TotalSeconds }
+	case "max":
+		key = func(s QueryLogStat) float64 { return s.MaxSeconds }
+	case "avg":
+		key = func(s QueryLogStat) float64 { return s.AvgSeconds }
+	default:
+		return fmt.Errorf("unknown sort %q (expected count|total|max|avg)", by)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if ki, kj := key(stats[i]), key(stats[j]); ki != kj {
+			return ki > kj
+		}
+		return stats[i].Query < stats[j].Query
+	})
+	return nil
+}
+
+// ReplayQueryLog evaluates the expressions of stats against storage, timing them: range
+// queries over the range and step last logged, ending at t, the others at t.
+func ReplayQueryLog(engine *promql.Engine, storage *sstorage.SimpleStorage, stats []QueryLogStat, t time.Time) {
+	for i := range stats {
+		st := &stats[i]
+		ctx, cancel := context.WithTimeout(context.Background(), replTimeout)
+		start := time.Now()
+		var q promql.Query
+		var err error
+		if st.Kind == "range" {
+			q, err = engine.NewRangeQuery(ctx, QueryableFor(storage), nil, st.Query, t.Add(-st.Range), t, st.Step)
+		} else {
+			q, err = engine.NewInstantQuery(ctx, QueryableFor(storage), nil, st.Query, t)
+		}
+		replay := &QueryLogReplay{}
+		if err == nil {
+			res := q.Exec(ctx)
+			err = res.Err
+			switch v := res.Value.(type) {
+			case promql.Vector:
+				replay.Series = len(v)
+			case promql.Matrix:
+				replay.Series = len(v)
+			}
+			q.Close()
+		}
+		replay.Seconds = time.Since(start).Seconds()
+		cancel()
+		if err != nil {
+			replay.Error = err.Error()
+		}
+		st.Replay = replay
+	}
+}
+
+// WriteQueryLog writes the top expressions of ql, all of them when top is 0, as a table
+// or as JSON.
+func WriteQueryLog(w io.Writer, ql QueryLog, top int, asJSON bool) error {
+	distinct := len(ql.Stats)
+	if top > 0 && len(ql.Stats) > top {
+		ql.Stats = ql.Stats[:top]
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(ql)
+	}
+	if _, err := fmt.Fprintf(w, "%d queries, %d distinct expressions", ql.Queries, distinct); err != nil {
+		return err
+	}
+	if ql.Skipped > 0 {
+		if _, err := fmt.Fprintf(w, " (%d unparsable lines skipped)", ql.Skipped); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintln(w); err != nil {
+		return err
+	}
+	if len(ql.Stats) == 0 {
+		return nil
+	}
+	replayed := ql.Stats[0].Replay != nil
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "COUNT\tAVG\tMAX\tTOTAL\tKIND"
+	if replayed {
+		header += "\tREPLAY"
+	}
+	mustFprintf(tw, "%s\tQUERY\n", header)
+	for _, st := range ql.Stats {
+		row := fmt.Sprintf("%d\t%s\t%s\t%s\t%s", st.Count, logSeconds(st.AvgSeconds), logSeconds(st.MaxSeconds), logSeconds(st.TotalSeconds), st.Kind)
+		if replayed {
+			switch r := st.Replay; {
+			case r == nil:
+				row += "\t-"
+			case r.Error != "":
+				row += "\terror"
+			default:
+				row += fmt.Sprintf("\t%s (%d series)", logSeconds(r.Seconds), r.Series)
+			}
+		}
+		mustFprintf(tw, "%s\t%s\n", row, st.Query)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, st := range ql.Stats {
+		if st.Replay != nil && st.Replay.Error != "" {
+			if _, err := fmt.Fprintf(w, "Replay error: %s: %s\n", st.Query, st.Replay.Error); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// logSeconds renders seconds as a duration rounded for a table.
+func logSeconds(s float64) string {
+	d := time.Duration(s * float64(time.Second))
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
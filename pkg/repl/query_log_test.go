@@ -0,0 +1,50 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testQueryLog = `{"httpRequest":{"clientIP":"127.0.0.1","method":"GET","path":"/api/v1/query_range"},"params":{"end":"2026-10-16T10:00:00.000Z","query":"sum(rate(http_requests_total[5m]))","start":"2026-10-16T09:00:00.000Z","step":60},"stats":{"timings":{"evalTotalTime":0.012,"execTotalTime":0.013}},"ts":"2026-10-16T10:00:00.010Z"}
+{"httpRequest":{"clientIP":"127.0.0.1","method":"GET","path":"/api/v1/query_range"},"params":{"end":"2026-10-16T10:01:00.000Z","query":"sum( rate(http_requests_total[5m]) )","start":"2026-10-16T09:01:00.000Z","step":60},"stats":{"timings":{"evalTotalTime":0.030}},"ts":"2026-10-16T10:01:00.010Z"}
+{"params":{"end":"2026-10-16T10:01:00.000Z","query":"temperature > 25","start":"2026-10-16T10:01:00.000Z","step":0},"ruleGroup":{"file":"rules.yml","name":"room"},"stats":{"timings":{"evalTotalTime":0.5}},"ts":"2026-10-16T10:01:00.010Z"}
+
+not a logged query
+`
+
+func TestParseQueryLog(t *testing.T) {
+	ql, err := ParseQueryLog(strings.NewReader(testQueryLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ql.Queries != 3 || ql.Skipped != 1 || len(ql.Stats) != 2 {
+		t.Fatalf("unexpected summary: %+v", ql)
+	}
+	st := ql.Stats[0]
+	if st.Query != "sum(rate(http_requests_total[5m]))" || st.Kind != "range" || st.Count != 2 || st.MaxSeconds != 0.030 || st.Range != time.Hour || st.Step != time.Minute {
+		t.Fatalf("expected the two spellings aggregated, got %+v", st)
+	}
+	if err := SortQueryLogStats(ql.Stats, "max"); err != nil || ql.Stats[0].Kind != "rule" {
+		t.Fatalf("expected the rule first by max, got %+v (%v)", ql.Stats, err)
+	}
+	if err := SortQueryLogStats(ql.Stats, "slowest"); err == nil {
+		t.Fatalf("expected an error for an unknown sort")
+	}
+
+	ReplayQueryLog(newTestEngine(), newTestStore(t), ql.Stats[:1], time.Now())
+	var buf bytes.Buffer
+	if err := WriteQueryLog(&buf, ql, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"3 queries, 2 distinct expressions (1 unparsable lines skipped)", "REPLAY", "500ms", "(1 series)", "temperature > 25"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "http_requests_total") {
+		t.Errorf("expected only the top expression, got:\n%s", out)
+	}
+}
@@ -0,0 +1,155 @@
+package repl
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// queryFileResult is the outcome of an expression of a query file, for --report.
+type queryFileResult struct {
+	query    string
+	line     int
+	duration time.Duration
+	output   string
+	failures []string // empty when the expression passed
+}
+
+// isReportedQuery reports whether a query file entry is a PromQL expression (possibly
+// .at <time> <expr>), as opposed to an ad-hoc command setting things up.
+func isReportedQuery(q string) bool {
+	return !strings.HasPrefix(q, ".") || strings.HasPrefix(q, ".at ")
+}
+
+// ParseReportSpec parses a --report value: <file>.xml for JUnit XML, <file>.tap for TAP,
+// or junit:<file> / tap:<file> for any file name.
+func ParseReportSpec(spec string) (format, path string, err error) {
+	if f, p, ok := strings.Cut(spec, ":"); ok && (f == "junit" || f == "tap") && p != "" {
+		return f, p, nil
+	}
+	switch {
+	case strings.HasSuffix(spec, ".xml"):
+		return "junit", spec, nil
+	case strings.HasSuffix(spec, ".tap"):
+		return "tap", spec, nil
+	}
+	return "", "", fmt.Errorf("invalid report %q (expected <file>.xml, <file>.tap, junit:<file> or tap:<file>)", spec)
+}
+
+// writeQueryReport writes the results of the query file source in format to path.
+func writeQueryReport(format, path, source string, results []queryFileResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	write := writeJUnitReport
+	if format == "tap" {
+		write = writeTAPReport
+	}
+	if err := write(f, source, results); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// JUnit XML elements, in the subset understood by CI systems (Jenkins, GitLab, GitHub actions).
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitReport writes results as a JUnit XML test suite named after source, with a
+// test case per expression.
+func writeJUnitReport(w io.Writer, source string, results []queryFileResult) error {
+	suite := junitTestSuite{Name: source, Tests: len(results), Timestamp: time.Now().UTC().Format(time.RFC3339)}
+	var total time.Duration
+	for _, r := range results {
+		total += r.duration
+		tc := junitTestCase{
+			Name:      r.query,
+			Classname: fmt.Sprintf("%s:%d", source, r.line),
+			Time:      junitSeconds(r.duration),
+			SystemOut: ansiEscape.ReplaceAllString(r.output, ""),
+		}
+		if len(r.failures) > 0 {
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: r.failures[0], Type: "failure", Text: strings.Join(r.failures, "\n")}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = junitSeconds(total)
+	doc := junitTestSuites{Name: "promql-cli", Tests: suite.Tests, Failures: suite.Failures, Time: suite.Time, Suites: []junitTestSuite{suite}}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// writeTAPReport writes results in the Test Anything Protocol (version 13), with the
+// failures of each expression in a YAML diagnostic block.
+func writeTAPReport(w io.Writer, source string, results []queryFileResult) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TAP version 13\n1..%d\n", len(results))
+	for i, r := range results {
+		status := "ok"
+		if len(r.failures) > 0 {
+			status = "not ok"
+		}
+		// # starts a TAP directive (SKIP, TODO) in the description
+		desc := strings.ReplaceAll(r.query, "#", `\#`)
+		fmt.Fprintf(&b, "%s %d - %s (%s:%d)\n", status, i+1, desc, source, r.line)
+		if len(r.failures) == 0 {
+			continue
+		}
+		// Quoted strings are valid YAML, whatever they contain
+		fmt.Fprintf(&b, "  ---\n  message: %s\n  failures:\n", strconv.Quote(r.failures[0]))
+		for _, f := range r.failures {
+			fmt.Fprintf(&b, "    - %s\n", strconv.Quote(f))
+		}
+		fmt.Fprintf(&b, "  duration_ms: %d\n  ...\n", r.duration.Milliseconds())
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,102 @@
+package repl
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestParseReportSpec(t *testing.T) {
+	for spec, want := range map[string][2]string{
+		"junit.xml":         {"junit", "junit.xml"},
+		"out/results.tap":   {"tap", "out/results.tap"},
+		"junit:report.txt":  {"junit", "report.txt"},
+		"tap:/tmp/r.report": {"tap", "/tmp/r.report"},
+	} {
+		format, path, err := ParseReportSpec(spec)
+		if err != nil || format != want[0] || path != want[1] {
+			t.Errorf("ParseReportSpec(%q) = %q, %q, %v; want %q, %q", spec, format, path, err, want[0], want[1])
+		}
+	}
+	for _, spec := range []string{"report.txt", "tap", "html:r.html", "junit:"} {
+		if _, _, err := ParseReportSpec(spec); err == nil {
+			t.Errorf("ParseReportSpec(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestExecuteQueriesFromFileReport(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	if err := store.LoadFromReader(strings.NewReader("up{job=\"a\"} 1\nup{job=\"b\"} 0\n")); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "q.promql")
+	content := ".metrics\n\nup\n\nnosuch\n\nsum(up\n\n# expect: empty\nnosuch\n\n# expect: value > 0\nup\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	junit := filepath.Join(dir, "report.xml")
+	var err error
+	captureStdout(t, func() { err = ExecuteQueriesFromFileReport(newTestEngine(), store, path, junit) })
+	if err == nil || !strings.Contains(err.Error(), "3 of 5 queries failed") {
+		t.Fatalf("expected 3 of 5 failed queries, got %v", err)
+	}
+	data, err := os.ReadFile(junit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatalf("invalid JUnit XML: %v\n%s", err, data)
+	}
+	if suites.Tests != 5 || suites.Failures != 3 || len(suites.Suites) != 1 || len(suites.Suites[0].Cases) != 5 {
+		t.Fatalf("unexpected JUnit report:\n%s", data)
+	}
+	want := map[string]string{
+		"up":     "",
+		"nosuch": "empty result",
+		"sum(up": "parse error",
+	}
+	for i, tc := range suites.Suites[0].Cases[:3] {
+		msg := ""
+		if tc.Failure != nil {
+			msg = tc.Failure.Message
+		}
+		if w := want[tc.Name]; w == "" && msg != "" || !strings.Contains(msg, w) {
+			t.Errorf("case %d %q: failure %q, want %q", i, tc.Name, msg, w)
+		}
+	}
+	if c := suites.Suites[0].Cases[3]; c.Failure != nil || c.Classname != path+":10" {
+		t.Errorf("an expected empty result should pass, got %+v", c)
+	}
+	if c := suites.Suites[0].Cases[4]; c.Failure == nil || !strings.Contains(c.Failure.Message, "expect value > 0") {
+		t.Errorf("expected a failed expectation, got %+v", c)
+	}
+
+	tap := filepath.Join(dir, "report.tap")
+	captureStdout(t, func() { err = ExecuteQueriesFromFileReport(newTestEngine(), store, path, tap) })
+	if err == nil {
+		t.Fatal("expected failed queries")
+	}
+	if data, err = os.ReadFile(tap); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{
+		"TAP version 13\n1..5\n",
+		"ok 1 - up (" + path + ":3)\n",
+		"not ok 2 - nosuch (" + path + ":5)\n  ---\n  message: \"empty result\"\n",
+		"not ok 3 - sum(up",
+		"ok 4 - nosuch",
+		"not ok 5 - up",
+	} {
+		if !strings.Contains(string(data), w) {
+			t.Errorf("missing %q in TAP report:\n%s", w, data)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/util/stats"
+)
+
+// QueryStats are the engine statistics of one query, as the Prometheus query API returns
+// with stats=all.
+type QueryStats struct {
+	ExecTime    time.Duration // total, including queueing
+	PrepareTime time.Duration // selecting series from the storage
+	EvalTime    time.Duration
+	// TotalSamples is the number of samples loaded; PeakSamples the most held in memory at once.
+	TotalSamples int64
+	PeakSamples  int
+	Series       int // series in the result
+}
+
+var (
+	// showQueryStats prints QueryStats after each result (set via .stats on or --stats).
+	showQueryStats bool
+	// lastQueryStats holds the stats of the last query run while showQueryStats is set,
+	// until printQueryStats consumes them.
+	lastQueryStats *QueryStats
+)
+
+// SetQueryStats turns printing query statistics after each result on or off.
+func SetQueryStats(on bool) {
+	showQueryStats = on
+	lastQueryStats = nil
+}
+
+// collectQueryStats records the stats of the executed q, when they are shown.
+func collectQueryStats(q promql.Query, res *promql.Result) {
+	if !showQueryStats {
+		return
+	}
+	b := stats.NewQueryStats(q.Stats()).Builtin()
+	seconds := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	qs := &QueryStats{
+		ExecTime:    seconds(b.Timings.ExecTotalTime),
+		PrepareTime: seconds(b.Timings.QueryPreparationTime),
+		EvalTime:    seconds(b.Timings.EvalTotalTime),
+	}
+	if b.Samples != nil {
+		qs.TotalSamples, qs.PeakSamples = b.Samples.TotalQueryableSamples, b.Samples.PeakSamples
+	}
+	switch v := res.Value.(type) {
+	case promql.Vector:
+		qs.Series = len(v)
+	case promql.Matrix:
+		qs.Series = len(v)
+	case promql.Scalar, promql.String:
+		qs.Series = 1
+	}
+	lastQueryStats = qs
+}
+
+func (s QueryStats) String() string {
+	round := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+	return fmt.Sprintf("exec %s (prepare %s, eval %s), %d samples loaded, peak %d samples, %d series",
+		round(s.ExecTime), round(s.PrepareTime), round(s.EvalTime), s.TotalSamples, s.PeakSamples, s.Series)
+}
+
+// printQueryStats writes the stats of the last query, if shown and not printed yet.
+func printQueryStats(w io.Writer) {
+	if !showQueryStats || lastQueryStats == nil {
+		return
+	}
+	mustFprintf(w, "Stats: %s\n", lastQueryStats)
+	lastQueryStats = nil
+}
+
+// PrintQueryStats is printQueryStats for one-off queries.
+func PrintQueryStats(w io.Writer) {
+	printQueryStats(w)
+}
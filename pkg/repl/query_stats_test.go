@@ -0,0 +1,56 @@
+package repl
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestQueryStats(t *testing.T) {
+	oldEngine, oldPinned := replEngine, pinnedEvalTime
+	defer func() {
+		replEngine, pinnedEvalTime = oldEngine, oldPinned
+		SetQueryStats(false)
+	}()
+	replEngine = newTestEngine()
+	now := time.Unix(1700000600, 0)
+	pinnedEvalTime = &now
+
+	store := sstorage.NewSimpleStorage()
+	for i := int64(0); i <= 10; i++ {
+		for _, job := range []string{"a", "b", "c"} {
+			store.AddSample(map[string]string{"__name__": "reqs", "job": job}, float64(i), (1700000000+i*60)*1000)
+		}
+	}
+
+	out := captureStdout(t, func() { executeOne(replEngine, store, "sum(reqs)") })
+	if strings.Contains(out, "Stats:") {
+		t.Fatalf("stats printed while off: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".stats on", store) })
+	if strings.TrimSpace(out) != "Query stats: on" {
+		t.Fatalf("unexpected .stats on output: %q", out)
+	}
+
+	out = captureStdout(t, func() { executeOne(replEngine, store, "count_over_time(reqs[10m])") })
+	re := regexp.MustCompile(`(?m)^Stats: exec \S+ \(prepare \S+, eval \S+\), 30 samples loaded, peak \d+ samples, 3 series$`)
+	if !re.MatchString(out) {
+		t.Fatalf("unexpected stats for an instant query: %s", out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".range 1700000000 1700000600 1m sum(reqs)", store) })
+	if !strings.Contains(out, "Matrix (1 series)") || !strings.Contains(out, "samples loaded") || !strings.HasSuffix(out, "1 series\n") {
+		t.Fatalf("unexpected stats for a range query: %s", out)
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".stats", store) })
+	if !strings.Contains(out, "Total: 1 metrics, 33 samples") || !strings.Contains(out, "Query stats: on") {
+		t.Fatalf("unexpected .stats output: %s", out)
+	}
+	_ = captureStdout(t, func() { _ = handleAdHocFunction(".stats off", store) })
+	if out = captureStdout(t, func() { executeOne(replEngine, store, "sum(reqs)") }); strings.Contains(out, "Stats:") {
+		t.Fatalf("stats printed after .stats off: %s", out)
+	}
+}
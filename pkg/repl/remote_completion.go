@@ -0,0 +1,196 @@
+package repl
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// remoteCompletionTTL is how long fetched remote metric and label names are completed
+// from the cache; older entries are still used while refreshed in the background.
+const remoteCompletionTTL = 2 * time.Minute
+
+// remoteCompletionWait bounds how long a completion waits for a first fetch; a slower
+// remote shows up at the next completion instead of blocking typing.
+var remoteCompletionWait = 300 * time.Millisecond
+
+// scrapeCompletionAPI is the v1 API of the Prometheus last imported from by .prom_scrape,
+// used for completions when no remote is connected.
+var scrapeCompletionAPI v1.API
+
+// remoteCompletionEntry holds the names fetched for one cache key. done is set while a
+// fetch is running and closed when it finishes.
+type remoteCompletionEntry struct {
+	values  []string
+	fetched time.Time
+	done    chan struct{}
+}
+
+// remoteCompletionCache caches remote completion metadata per source API.
+type remoteCompletionCache struct {
+	mu      sync.Mutex
+	api     v1.API
+	entries map[string]*remoteCompletionEntry
+}
+
+var remoteCompletions = &remoteCompletionCache{}
+
+// remoteCompletionSource returns the API completions are fetched from: the connected
+// remote (.connect, --remote-url), else the Prometheus of the last .prom_scrape.
+func remoteCompletionSource() v1.API {
+	if client != nil {
+		return client
+	}
+	return scrapeCompletionAPI
+}
+
+// setScrapeCompletionSource makes the Prometheus behind the .prom_scrape uri the
+// completion source, applying prepare (auth headers) to its requests and sending them
+// through next, the transport of the scrape (api.DefaultRoundTripper when nil).
+func setScrapeCompletionSource(uri string, next http.RoundTripper, prepare func(*http.Request)) {
+	if next == nil {
+		next = api.DefaultRoundTripper
+	}
+	c, err := api.NewClient(api.Config{
+		Address:      promAPIBase(uri),
+		RoundTripper: promAuthRoundTripper{prepare: prepare, next: next},
+	})
+	if err != nil {
+		return
+	}
+	scrapeCompletionAPI = v1.NewAPI(c)
+}
+
+// promAPIBase returns the server address of a Prometheus API or federate URI.
+func promAPIBase(uri string) string {
+	b := strings.TrimRight(strings.TrimSpace(uri), "/")
+	for _, suffix := range []string{"/query_range", "/query", "/federate"} {
+		b = strings.TrimSuffix(b, suffix)
+	}
+	return strings.TrimSuffix(b, "/api/v1")
+}
+
+// promAuthRoundTripper applies prepare to each request before sending it.
+type promAuthRoundTripper struct {
+	prepare func(*http.Request)
+	next    http.RoundTripper
+}
+
+func (rt promAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt.prepare != nil {
+		req = req.Clone(req.Context())
+		rt.prepare(req)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// remoteMetricNames returns the metric names of the completion source, if any.
+func remoteMetricNames() []string {
+	return remoteCompletionValues("__name__", "")
+}
+
+// remoteLabelNames returns the label names of metricName (all series when empty) from
+// the completion source.
+func remoteLabelNames(metricName string) []string {
+	return remoteCompletionValues("", metricName)
+}
+
+// remoteLabelValues returns the values of labelName for metricName (all series when
+// empty) from the completion source.
+func remoteLabelValues(metricName, labelName string) []string {
+	return remoteCompletionValues(labelName, metricName)
+}
+
+// remoteCompletionValues returns the cached label names (labelName == "") or values for
+// metricName, fetching them asynchronously over the last hour when missing or stale.
+func remoteCompletionValues(labelName, metricName string) []string {
+	source := remoteCompletionSource()
+	if source == nil {
+		return nil
+	}
+	return remoteCompletions.get(source, labelName+"\x00"+metricName, func(ctx context.Context, source v1.API) ([]string, error) {
+		end := time.Now()
+		if pinnedEvalTime != nil {
+			end = *pinnedEvalTime
+		}
+		var matches []string
+		if metricName != "" {
+			matches = []string{metricName}
+		}
+		var out []string
+		if labelName == "" {
+			names, _, err := source.LabelNames(ctx, matches, end.Add(-time.Hour), end)
+			if err != nil {
+				return nil, err
+			}
+			for _, n := range names {
+				if n != "__name__" {
+					out = append(out, n)
+				}
+			}
+			return out, nil
+		}
+		values, _, err := source.LabelValues(ctx, labelName, matches, end.Add(-time.Hour), end)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			out = append(out, string(v))
+		}
+		return out, nil
+	})
+}
+
+// get returns the cached values of key for source, starting a background fetch when
+// they are missing or older than remoteCompletionTTL. Only a first fetch is waited for,
+// up to remoteCompletionWait.
+func (c *remoteCompletionCache) get(source v1.API, key string, fetch func(context.Context, v1.API) ([]string, error)) []string {
+	c.mu.Lock()
+	if c.api != source || c.entries == nil {
+		c.api, c.entries = source, map[string]*remoteCompletionEntry{}
+	}
+	e := c.entries[key]
+	if e == nil {
+		e = &remoteCompletionEntry{}
+		c.entries[key] = e
+	}
+	if e.done == nil && time.Since(e.fetched) > remoteCompletionTTL {
+		e.done = make(chan struct{})
+		go c.refresh(source, e, fetch)
+	}
+	values, done, first := e.values, e.done, e.fetched.IsZero()
+	c.mu.Unlock()
+
+	if first && done != nil {
+		select {
+		case <-done:
+		case <-time.After(remoteCompletionWait):
+			return nil
+		}
+		c.mu.Lock()
+		values = e.values
+		c.mu.Unlock()
+	}
+	return values
+}
+
+// refresh fetches the values of e. Failures keep the previous values until the next TTL.
+func (c *remoteCompletionCache) refresh(source v1.API, e *remoteCompletionEntry, fetch func(context.Context, v1.API) ([]string, error)) {
+	fctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	values, err := fetch(fctx, source)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		e.values = values
+	}
+	e.fetched = time.Now()
+	close(e.done)
+	e.done = nil
+}
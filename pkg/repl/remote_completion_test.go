@@ -0,0 +1,102 @@
+package repl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestRemoteCompletion_CachedLabelValues(t *testing.T) {
+	var valueRequests atomic.Int32
+	var orgID atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		orgID.Store(r.Header.Get("X-Scope-OrgID"))
+		switch r.URL.Path {
+		case "/api/v1/labels":
+			_, _ = w.Write([]byte(`{"status":"success","data":["__name__","job","zone"]}`))
+		case "/api/v1/label/__name__/values":
+			_, _ = w.Write([]byte(`{"status":"success","data":["local_up","remote_up"]}`))
+		case "/api/v1/label/zone/values":
+			valueRequests.Add(1)
+			if r.URL.Query().Get("match[]") != "remote_up" {
+				t.Errorf("unexpected match[]: %q", r.URL.Query().Get("match[]"))
+			}
+			_, _ = w.Write([]byte(`{"status":"success","data":["eu-1","us-1"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	oldClient, oldCache, oldScrape, oldWait := client, remoteCompletions, scrapeCompletionAPI, remoteCompletionWait
+	defer func() {
+		client, remoteCompletions, scrapeCompletionAPI, remoteCompletionWait = oldClient, oldCache, oldScrape, oldWait
+	}()
+	client, scrapeCompletionAPI = nil, nil
+	remoteCompletions, remoteCompletionWait = &remoteCompletionCache{}, 5*time.Second
+
+	store := sstorage.NewSimpleStorage()
+	store.AddSample(map[string]string{"__name__": "local_up", "job": "local"}, 1, time.Now().UnixMilli())
+	ac := NewPrometheusAutoCompleter(store)
+	if got := ac.getLabelValueCompletions("remote_up", "zone", ""); len(got) != 0 {
+		t.Fatalf("no completion source should give no remote values, got %v", got)
+	}
+
+	// As after .prom_scrape <uri> ... org_id=tenant
+	setScrapeCompletionSource(srv.URL+"/api/v1/query", nil, func(req *http.Request) { req.Header.Set("X-Scope-OrgID", "tenant") })
+	if got := ac.getLabelValueCompletions("remote_up", "zone", "e"); !reflect.DeepEqual(got, []string{"eu-1"}) {
+		t.Fatalf("label values: got %v", got)
+	}
+	if got := ac.getLabelNameCompletions("remote_up", ""); !reflect.DeepEqual(got, []string{"job", "zone"}) {
+		t.Fatalf("label names: got %v", got)
+	}
+	if got := ac.getMetricNameCompletions("remote"); !reflect.DeepEqual(got, []string{"remote_up"}) {
+		t.Fatalf("metric names: got %v", got)
+	}
+	if got := ac.getMetricNameCompletions("local"); !reflect.DeepEqual(got, []string{"local_up"}) {
+		t.Fatalf("local metrics should not be duplicated, got %v", got)
+	}
+	_ = ac.getLabelValueCompletions("remote_up", "zone", "")
+	if n := valueRequests.Load(); n != 1 {
+		t.Fatalf("expected cached label values, got %d requests", n)
+	}
+	if id, _ := orgID.Load().(string); id != "tenant" {
+		t.Fatalf("expected .prom_scrape auth to be applied, got org id %q", id)
+	}
+
+	// Expired entries are returned while refreshed in the background
+	remoteCompletions.mu.Lock()
+	for _, e := range remoteCompletions.entries {
+		e.fetched = e.fetched.Add(-2 * remoteCompletionTTL)
+	}
+	remoteCompletions.mu.Unlock()
+	if got := ac.getLabelValueCompletions("remote_up", "zone", ""); len(got) != 2 {
+		t.Fatalf("stale values should be used while refreshing, got %v", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for valueRequests.Load() != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := valueRequests.Load(); n != 2 {
+		t.Fatalf("expected a background refresh, got %d requests", n)
+	}
+}
+
+func TestPromAPIBase(t *testing.T) {
+	for in, want := range map[string]string{
+		"http://prom:9090":                     "http://prom:9090",
+		"http://prom:9090/api/v1/":             "http://prom:9090",
+		"http://prom:9090/api/v1/query":        "http://prom:9090",
+		"http://mimir/prometheus/api/v1/query": "http://mimir/prometheus",
+		"http://prom:9090/federate":            "http://prom:9090",
+	} {
+		if got := promAPIBase(in); got != want {
+			t.Errorf("promAPIBase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
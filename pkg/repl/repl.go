@@ -7,9 +7,9 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,7 +20,7 @@ import (
 	"github.com/chzyer/readline"
 	"github.com/prometheus/prometheus/promql"
 	promparser "github.com/prometheus/prometheus/promql/parser"
-	"golang.org/x/sys/unix"
+	"golang.org/x/term"
 
 	sstorage "github.com/jjo/promql-cli/pkg/storage"
 )
@@ -47,6 +47,10 @@ const altDotMarker = rune(0xE000)
 // rlInputGate gates stdin to readline so we can pause input while running an external editor.
 var rlInputGate *inputGate
 
+// rlCookedState is the terminal state before readline switches to raw mode, restored while
+// the external editor runs.
+var rlCookedState *term.State
+
 // inputGate proxies bytes from a real source (os.Stdin) to a pipe that readline consumes.
 // When paused, it stops reading from the source so the editor can read directly from the TTY.
 type inputGate struct {
@@ -55,7 +59,8 @@ type inputGate struct {
 	w                  *io.PipeWriter
 	paused             uint32 // atomic 0/1
 	stop               chan struct{}
-	escSeqTransformers []func([]byte) []byte // transformers for ESC sequences
+	escSeqTransformers []func([]byte) []byte  // transformers for ESC sequences
+	onInput            atomic.Pointer[func()] // when set, called for input instead of forwarding it
 }
 
 func newInputGate(src *os.File) *inputGate {
@@ -69,6 +74,16 @@ func (g *inputGate) Reader() io.ReadCloser { return g.r }
 func (g *inputGate) Pause()                { atomic.StoreUint32(&g.paused, 1) }
 func (g *inputGate) Resume()               { atomic.StoreUint32(&g.paused, 0) }
 func (g *inputGate) Closed() bool          { return atomic.LoadUint32(&g.paused) == 2 }
+
+// Intercept makes the gate call fn for the input it reads, dropping it, instead of
+// forwarding it to readline, e.g. to stop .top on a key press; nil forwards again.
+func (g *inputGate) Intercept(fn func()) {
+	if fn == nil {
+		g.onInput.Store(nil)
+		return
+	}
+	g.onInput.Store(&fn)
+}
 func (g *inputGate) Close() {
 	select {
 	case <-g.stop:
@@ -98,7 +113,9 @@ func (g *inputGate) loop() {
 		default:
 		}
 		n, err := g.src.Read(buf)
-		if n > 0 {
+		if fn := g.onInput.Load(); n > 0 && fn != nil {
+			(*fn)()
+		} else if n > 0 {
 			data := buf[:n]
 			// Apply any ESC sequence transformers
 			for _, transform := range g.escSeqTransformers {
@@ -118,20 +135,7 @@ func (g *inputGate) Flush() {
 	if g == nil || g.src == nil {
 		return
 	}
-	fd := int(g.src.Fd())
-	_ = unix.SetNonblock(fd, true)
-	defer func() { _ = unix.SetNonblock(fd, false) }()
-	buf := make([]byte, 8192)
-	for {
-		n, err := unix.Read(fd, buf)
-		if n <= 0 {
-			if err == nil || err == unix.EAGAIN || err == unix.EWOULDBLOCK {
-				break
-			}
-			break
-		}
-		// Continue until empty
-	}
+	drainFD(int(g.src.Fd()))
 }
 
 // runInteractiveQueries starts an interactive query session using readline for enhanced UX.
@@ -175,7 +179,7 @@ func runInteractiveQueries(engine *promql.Engine, storage *sstorage.SimpleStorag
 
 	// Optional custom Alt+. rune codepoint (for terminals that send non-ESC meta)
 	var altDotRune rune
-	if v := strings.TrimSpace(os.Getenv("PROMQL_CLI_ALT_DOT_KEY")); v != "" {
+	if v := strings.TrimSpace(settingEnv("PROMQL_CLI_ALT_DOT_KEY")); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
 			altDotRune = rune(n)
 		} else if r := []rune(v); len(r) == 1 {
@@ -345,25 +349,12 @@ func runInteractiveQueries(engine *promql.Engine, storage *sstorage.SimpleStorag
 			return out
 		}
 
-		// Ctrl-Y: paste AI clipboard (from .ai edit N)
+		// Ctrl-Y: paste AI clipboard (from .ai edit N, defaulting to the first suggestion)
 		if key == keyCtrlY {
-			if strings.TrimSpace(aiClipboard) == "" {
+			text := aiPasteText(0)
+			if text == "" {
 				return copyRunes(line), pos, true
 			}
-			text := aiClipboard
-			// Try to find matching explanation
-			idx := -1
-			for i, q := range lastAISuggestions {
-				if strings.TrimSpace(q) == strings.TrimSpace(aiClipboard) {
-					idx = i
-					break
-				}
-			}
-			if idx >= 0 && idx < len(lastAIExplanations) {
-				if ex := strings.TrimSpace(lastAIExplanations[idx]); ex != "" {
-					text = text + " # " + ex
-				}
-			}
 			newLine := []rune(text)
 			return newLine, len(newLine), true
 		}
@@ -503,6 +494,14 @@ func runInteractiveQueries(engine *promql.Engine, storage *sstorage.SimpleStorag
 					prevPos = np
 					return nl, np, true
 				}
+				// Alt-1..Alt-9: paste the corresponding AI suggestion
+				if key >= '1' && key <= '9' {
+					if text := aiPasteText(int(key - '0')); text != "" {
+						newLine := []rune(text)
+						return newLine, len(newLine), true
+					}
+					return copyRunes(line), pos, true
+				}
 				if key == '.' || key == '>' || key == ',' || (altDotRune != 0 && key == altDotRune) {
 					// Alt+. (or Alt+> / Alt+, fallbacks): insert/cycle last argument from history
 					// removeTrigger=false because listener intercepts BEFORE readline inserts the key
@@ -635,6 +634,7 @@ func runInteractiveQueries(engine *promql.Engine, storage *sstorage.SimpleStorag
 
 	// Build a gated stdin so we can pause input while the external editor is active
 	rlInputGate = newInputGate(os.Stdin)
+	rlCookedState = saveTerminalState()
 
 	// Add transformer to convert Alt+. (ESC+.) to special marker before readline sees it
 	// This is necessary because readline intercepts ESC sequences before the listener sees them
@@ -662,13 +662,16 @@ func runInteractiveQueries(engine *promql.Engine, storage *sstorage.SimpleStorag
 		return result
 	})
 	rl, err := readline.NewEx(&readline.Config{
-		Prompt:          "> ",
-		HistoryFile:     historyPath,
-		AutoComplete:    createAutoCompleter(storage), // Dynamic tab completion
-		InterruptPrompt: "^C",
-		EOFPrompt:       "exit",
-		Listener:        readline.FuncListener(listener),
-		Stdin:           rlInputGate.Reader(),
+		Prompt:       "> ",
+		HistoryFile:  historyPath,
+		AutoComplete: createAutoCompleter(storage), // Dynamic tab completion
+		Painter:      syntaxPainter{},
+		// Multi-line queries are saved joined once complete, see below
+		DisableAutoSaveHistory: true,
+		InterruptPrompt:        "^C",
+		EOFPrompt:              "exit",
+		Listener:               readline.FuncListener(listener),
+		Stdin:                  rlInputGate.Reader(),
 	})
 	if err != nil {
 		fmt.Printf("Warning: Could not initialize readline, falling back to basic input: %v\n", err)
@@ -676,6 +679,19 @@ func runInteractiveQueries(engine *promql.Engine, storage *sstorage.SimpleStorag
 		return
 	}
 	defer func() { _ = rl.Close() }()
+	// Reload readline's history (used by its Ctrl-R search) and ours after .history clear/dedupe.
+	historyReplaced = func(entries []string) {
+		userHistory = entries
+		rl.SetHistoryPath(historyPath)
+	}
+	defer func() { historyReplaced = nil }()
+	// Ask confirmations with readline itself, which owns stdin; the loop resets the prompt
+	askConfirm = func(question string) bool {
+		rl.SetPrompt(question)
+		answer, err := rl.Readline()
+		return err == nil && isYes(answer)
+	}
+	defer func() { askConfirm = nil }()
 	// Ensure we stop proxying input when leaving the REPL
 	defer func() {
 		if rlInputGate != nil {
@@ -684,7 +700,7 @@ func runInteractiveQueries(engine *promql.Engine, storage *sstorage.SimpleStorag
 		}
 	}()
 
-	// Multi-line continuation state (using backslash at EOL)
+	// Multi-line continuation state (backslash at EOL or unclosed brackets)
 	var mlActive bool
 	var mlParts []string
 
@@ -746,21 +762,25 @@ func runInteractiveQueries(engine *promql.Engine, storage *sstorage.SimpleStorag
 			continue
 		}
 
-		// Keep our in-memory history in sync (readline persists to file separately)
-		if trimmed := strings.TrimSpace(line); trimmed != "" {
-			userHistory = append(userHistory, trimmed)
-		}
-
 		query := strings.TrimSpace(line)
 		if query == "" && !mlActive {
 			continue
 		}
 
+		// Continue on a new line while the expression has unclosed brackets
+		if needsContinuation(strings.Join(append(mlParts, query), "\n")) {
+			if query != "" {
+				mlParts = append(mlParts, query)
+			}
+			mlActive = true
+			continue
+		}
+
 		if mlActive {
-			if s := strings.TrimSpace(query); s != "" {
-				mlParts = append(mlParts, s)
+			if query != "" {
+				mlParts = append(mlParts, query)
 			}
-			query = strings.TrimSpace(strings.Join(mlParts, " "))
+			query = joinContinuedLines(mlParts)
 			mlActive = false
 			mlParts = nil
 			if query == "" {
@@ -768,15 +788,23 @@ func runInteractiveQueries(engine *promql.Engine, storage *sstorage.SimpleStorag
 			}
 		}
 
+		// Save the whole (joined) query to the history, in memory and in the file
+		userHistory = append(userHistory, historyLine(query))
+		_ = rl.SaveHistory(historyLine(query))
+
 		if query == "quit" || query == ".quit" {
 			break
 		}
 
 		// Track last executed command for Alt+.
 		lastExecutedCommand = query
+		// Any submitted line ends the AI selection started by .ai
+		aiSelectionActive = false
 
 		// Delegate full-line execution (ad-hoc, !cmd, query, pipes) to executeOne
-		executeOne(engine, storage, query)
+		lockStore()
+		executeRecorded(replEngine, storage, query)
+		unlockStore()
 	}
 }
 
@@ -840,7 +868,7 @@ func (pac *PrometheusAutoCompleter) getFilePathCompletions(pathSoFar, _ string)
 			continue
 		}
 		if e.IsDir() {
-			name += "/"
+			name += string(filepath.Separator)
 		}
 		out = append(out, name)
 	}
@@ -855,6 +883,8 @@ func NewPrometheusAutoCompleter(storage *sstorage.SimpleStorage) *PrometheusAuto
 
 // Do implements the readline.AutoCompleter interface to provide dynamic completions.
 func (pac *PrometheusAutoCompleter) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
 	lineStr := string(line)
 	cursorPos := pos
 
@@ -1020,6 +1050,11 @@ func (pac *PrometheusAutoCompleter) getCompletions(line string, pos int, current
 	beforeCursor := line[:pos]
 	trimmed := strings.TrimLeft(beforeCursor, " \t")
 
+	// While an AI selection is active, Tab on an empty line offers .ai edit/run N
+	if items := aiSelectionCompletions(beforeCursor); items != nil {
+		return items
+	}
+
 	// Range-vector scaffold suggestions when inside '[' ...
 	if strings.HasSuffix(strings.TrimRight(beforeCursor, " \t"), "[") || strings.HasPrefix(currentWord, "[") {
 		return getRangeDurationCompletions(currentWord)
@@ -1046,7 +1081,7 @@ func (pac *PrometheusAutoCompleter) getCompletions(line string, pos int, current
 			after := strings.TrimSpace(trimmed[4:])
 			low := strings.ToLower(after)
 			if after == "" {
-				return []string{"ask ", "run ", "edit ", "show"}
+				return []string{"ask ", "run ", "edit ", "explain ", "context show", "show"}
 			}
 			if strings.HasPrefix(low, "run ") || strings.HasPrefix(low, "edit ") {
 				// suggest indices
@@ -1074,17 +1109,18 @@ func (pac *PrometheusAutoCompleter) getCompletions(line string, pos int, current
 			// Otherwise, when typing "ask" or "show" we don't complete beyond token
 			return []string{}
 		}
-		// If after ".labels ", ".seed ", ".drop ", or ".timestamps ", complete metric names
-		if strings.HasPrefix(trimmed, ".labels ") || strings.HasPrefix(trimmed, ".seed ") ||
-			strings.HasPrefix(trimmed, ".drop ") || strings.HasPrefix(trimmed, ".timestamps ") {
+		// If after ".labels ", ".meta ", ".seed ", ".drop ", ".timestamps " or ".exemplars ", complete metric names
+		if strings.HasPrefix(trimmed, ".labels ") || strings.HasPrefix(trimmed, ".meta ") || strings.HasPrefix(trimmed, ".seed ") ||
+			strings.HasPrefix(trimmed, ".drop ") || strings.HasPrefix(trimmed, ".timestamps ") ||
+			strings.HasPrefix(trimmed, ".exemplars ") {
 			return pac.getMetricNameCompletions(currentWord)
 		}
 		// No further completions for .help and .metrics
 		if trimmed == ".help" || trimmed == ".metrics" || strings.HasPrefix(trimmed, ".help ") || strings.HasPrefix(trimmed, ".metrics ") {
 			return []string{}
 		}
-		// If after ".load ", ".save ", or ".source ", complete filesystem paths (current word = base name)
-		if strings.HasPrefix(trimmed, ".load ") || strings.HasPrefix(trimmed, ".save ") || strings.HasPrefix(trimmed, ".source ") {
+		// If after ".load ", ".save ", ".export " or ".source ", complete filesystem paths (current word = base name)
+		if strings.HasPrefix(trimmed, ".load ") || strings.HasPrefix(trimmed, ".save ") || strings.HasPrefix(trimmed, ".export ") || strings.HasPrefix(trimmed, ".source ") {
 			// Extract the path substring after the command token
 			var pathSoFar string
 			switch {
@@ -1092,6 +1128,8 @@ func (pac *PrometheusAutoCompleter) getCompletions(line string, pos int, current
 				pathSoFar = trimmed[len(".load "):]
 			case strings.HasPrefix(trimmed, ".save "):
 				pathSoFar = trimmed[len(".save "):]
+			case strings.HasPrefix(trimmed, ".export "):
+				pathSoFar = trimmed[len(".export "):]
 			default:
 				pathSoFar = trimmed[len(".source "):]
 			}
@@ -1113,7 +1151,7 @@ func (pac *PrometheusAutoCompleter) getCompletions(line string, pos int, current
 					"http://localhost:9115/metrics",
 				}
 				var out []string
-				for _, url := range urlExamples {
+				for _, url := range slices.Concat(favoriteScrapeURLs, urlExamples) {
 					// Show all URLs when currentWord is empty or filter if typing
 					if currentWord == "" || strings.HasPrefix(url, currentWord) {
 						out = append(out, url)
@@ -1148,6 +1186,79 @@ func (pac *PrometheusAutoCompleter) getCompletions(line string, pos int, current
 				}
 			}
 		}
+		// If after ".format ", offer output format names
+		if strings.HasPrefix(trimmed, ".format ") {
+			var out []string
+			for _, name := range FormatNames() {
+				if strings.HasPrefix(name, currentWord) {
+					out = append(out, name)
+				}
+			}
+			return out
+		}
+		// If after ".run " or ".undefine ", offer the saved query names
+		for _, cmd := range []string{".run ", ".undefine "} {
+			if after, ok := strings.CutPrefix(trimmed, cmd); ok {
+				if strings.ContainsAny(after, "( ") {
+					return []string{}
+				}
+				loadNamedQueries()
+				var out []string
+				for _, q := range NamedQueries() {
+					if strings.HasPrefix(q.Name, currentWord) {
+						out = append(out, q.Name)
+					}
+				}
+				return out
+			}
+		}
+		// If after ".range ", offer start/end time presets, then step presets, then query completions
+		if strings.HasPrefix(trimmed, ".range ") {
+			cmdIdx := strings.LastIndex(line[:pos], ".range ")
+			if cmdIdx >= 0 {
+				after := line[cmdIdx+7 : pos]
+				fields := strings.Fields(after)
+				typing := len(fields)
+				if typing > 0 && !strings.HasSuffix(after, " ") && !strings.HasSuffix(after, "\t") {
+					typing--
+				}
+				var presets []string
+				switch typing {
+				case 0:
+					presets = []string{"now-5m", "now-15m", "now-30m", "now-1h", "now-6h", "now-24h", "now-7d"}
+				case 1:
+					presets = []string{"now", "now-5m", "now-15m", "now-30m", "now-1h", time.Now().UTC().Format(time.RFC3339)}
+				case 2:
+					presets = []string{"15s", "30s", "1m", "5m", "15m", "1h"}
+				default:
+					// Past start/end/step; delegate to query completions for the remainder
+					queryStart := cmdIdx + 7
+					for range 3 {
+						for queryStart < len(line) && (line[queryStart] == ' ' || line[queryStart] == '\t') {
+							queryStart++
+						}
+						for queryStart < len(line) && line[queryStart] != ' ' && line[queryStart] != '\t' {
+							queryStart++
+						}
+					}
+					if queryStart < pos {
+						queryStart++
+						subline := line[queryStart:]
+						subpos := pos - queryStart
+						subWord, _ := pac.getCurrentWord(subline, subpos)
+						return pac.getCompletions(subline, subpos, subWord)
+					}
+					return []string{}
+				}
+				var out []string
+				for _, p := range presets {
+					if currentWord == "" || strings.HasPrefix(strings.ToLower(p), strings.ToLower(currentWord)) {
+						out = append(out, p)
+					}
+				}
+				return out
+			}
+		}
 		// If after ".at ", either offer time presets or transition into query completions
 		if strings.HasPrefix(trimmed, ".at ") {
 			cmdIdx := strings.LastIndex(line[:pos], ".at ")
@@ -1191,12 +1302,14 @@ func (pac *PrometheusAutoCompleter) getCompletions(line string, pos int, current
 
 	// Analyze the context to determine what type of completion to provide
 	context := pac.analyzeContext(line, pos)
+	// Function whose argument is being typed, to list the metrics of the type it takes first
+	fn := enclosingFunction(line[:min(pos, len(line))])
 
 	switch context.Type {
 	case "metric_name":
 		// Suggest metrics, range templates, aggregators, and functions when starting an expression
 		var out []string
-		out = append(out, pac.getMetricNameCompletions(currentWord)...)
+		out = append(out, pac.getMetricNameCompletionsFor(currentWord, fn)...)
 		// Include range-vector scaffolds as standalone tokens
 		out = append(out, getBracketedRangeTemplates()...)
 		// Aggregators like sum, avg, min, max, topk, bottomk, quantile, etc.
@@ -1209,7 +1322,14 @@ func (pac *PrometheusAutoCompleter) getCompletions(line string, pos int, current
 	case "label_value":
 		return pac.getLabelValueCompletions(context.MetricName, context.LabelName, currentWord)
 	case "function":
-		return pac.getFunctionCompletions(currentWord)
+		// Right after e.g. rate( offer the metrics it usually takes before nested functions
+		var out []string
+		for _, m := range pac.getMetricNameCompletions(currentWord) {
+			if metricSuitsFunction(pac.storage.MetricsType, m, fn) {
+				out = append(out, m)
+			}
+		}
+		return append(out, pac.getFunctionCompletions(currentWord)...)
 	case "operator":
 		return pac.getOperatorCompletions(currentWord)
 	default:
@@ -1295,12 +1415,24 @@ func (pac *PrometheusAutoCompleter) extractMetricName(text string) string {
 func (pac *PrometheusAutoCompleter) getMetricNameCompletions(prefix string) []string {
 	var completions []string
 
-	for metricName := range pac.storage.Metrics {
+	// In proxy mode the local storage is bypassed: only complete the remote metrics
+	local := pac.storage.Metrics
+	if remoteProxy {
+		local = nil
+	}
+	for metricName := range local {
 		if strings.HasPrefix(strings.ToLower(metricName), strings.ToLower(prefix)) {
 			completions = append(completions, metricName)
 		}
 	}
 
+	// Add remote metrics not loaded locally
+	for _, m := range remoteMetricNames() {
+		if local[m] == nil && strings.HasPrefix(strings.ToLower(m), strings.ToLower(prefix)) {
+			completions = append(completions, m)
+		}
+	}
+
 	// Add recording rule names
 	for _, rn := range GetRecordingRuleNames() {
 		if strings.HasPrefix(strings.ToLower(rn), strings.ToLower(prefix)) {
@@ -1319,25 +1451,33 @@ func (pac *PrometheusAutoCompleter) getMetricNameCompletions(prefix string) []st
 	return completions
 }
 
+// getMetricNameCompletionsFor returns the metric names matching prefix for an argument of
+// the function fn, the ones of the TYPE it takes first (see rankMetricsForFunction).
+func (pac *PrometheusAutoCompleter) getMetricNameCompletionsFor(prefix, fn string) []string {
+	return rankMetricsForFunction(pac.getMetricNameCompletions(prefix), pac.storage.MetricsType, fn)
+}
+
 // getLabelNameCompletions returns label names for a specific metric.
 func (pac *PrometheusAutoCompleter) getLabelNameCompletions(metricName, prefix string) []string {
 	labelNames := make(map[string]bool)
 
 	// If no specific metric, get labels from all metrics
-	metricsToCheck := make(map[string][]sstorage.MetricSample)
-	if metricName != "" && pac.storage.Metrics[metricName] != nil {
-		metricsToCheck[metricName] = pac.storage.Metrics[metricName]
-	} else {
-		metricsToCheck = pac.storage.Metrics
+	localMetric := metricName
+	if pac.storage.Metrics[localMetric] == nil {
+		localMetric = ""
 	}
-
-	for _, samples := range metricsToCheck {
-		for _, sample := range samples {
-			for labelName := range sample.Labels {
-				if labelName != "__name__" && strings.HasPrefix(strings.ToLower(labelName), strings.ToLower(prefix)) {
-					labelNames[labelName] = true
-				}
-			}
+	var local []string
+	if !remoteProxy {
+		local = pac.storage.LabelNamesOf(localMetric)
+	}
+	for _, labelName := range local {
+		if strings.HasPrefix(strings.ToLower(labelName), strings.ToLower(prefix)) {
+			labelNames[labelName] = true
+		}
+	}
+	for _, labelName := range remoteLabelNames(metricName) {
+		if strings.HasPrefix(strings.ToLower(labelName), strings.ToLower(prefix)) {
+			labelNames[labelName] = true
 		}
 	}
 
@@ -1355,20 +1495,22 @@ func (pac *PrometheusAutoCompleter) getLabelValueCompletions(metricName, labelNa
 	labelValues := make(map[string]bool)
 
 	// If no specific metric, get values from all metrics
-	metricsToCheck := make(map[string][]sstorage.MetricSample)
-	if metricName != "" && pac.storage.Metrics[metricName] != nil {
-		metricsToCheck[metricName] = pac.storage.Metrics[metricName]
-	} else {
-		metricsToCheck = pac.storage.Metrics
+	localMetric := metricName
+	if pac.storage.Metrics[localMetric] == nil {
+		localMetric = ""
 	}
-
-	for _, samples := range metricsToCheck {
-		for _, sample := range samples {
-			if value, exists := sample.Labels[labelName]; exists {
-				if strings.HasPrefix(strings.ToLower(value), strings.ToLower(prefix)) {
-					labelValues[value] = true // raw value, no quotes; quotes handled in Do
-				}
-			}
+	var local []string
+	if !remoteProxy {
+		local = pac.storage.LabelValuesOf(localMetric, labelName)
+	}
+	for _, value := range local {
+		if strings.HasPrefix(strings.ToLower(value), strings.ToLower(prefix)) {
+			labelValues[value] = true // raw value, no quotes; quotes handled in Do
+		}
+	}
+	for _, value := range remoteLabelValues(metricName, labelName) {
+		if strings.HasPrefix(strings.ToLower(value), strings.ToLower(prefix)) {
+			labelValues[value] = true
 		}
 	}
 
@@ -1492,12 +1634,18 @@ func getAggregatorCompletions(prefix string) []string {
 	return out
 }
 
-// splitQueryAndPipe splits a line into query and pipe command on a '|' that is outside double-quoted strings.
-// Returns (query, cmd, true) when a top-level pipe is found; otherwise (line, "", false).
+// splitQueryAndPipe splits a line into query and pipe command on a '|' that is outside double-quoted strings,
+// skipping the "|||" of result stages. Returns (query, cmd, true) when a top-level pipe is found; otherwise
+// (line, "", false).
 func splitQueryAndPipe(line string) (string, string, bool) {
 	inStr := false
 	esc := false
+	skip := 0
 	for i, r := range line {
+		if skip > 0 {
+			skip--
+			continue
+		}
 		if inStr {
 			if esc {
 				esc = false
@@ -1516,6 +1664,10 @@ func splitQueryAndPipe(line string) (string, string, bool) {
 			inStr = true
 			continue
 		}
+		if strings.HasPrefix(line[i:], resultPipeSep) {
+			skip = len(resultPipeSep) - 1
+			continue
+		}
 		if r == '|' {
 			left := strings.TrimSpace(line[:i])
 			right := strings.TrimSpace(line[i+1:])
@@ -1616,27 +1768,6 @@ func rlExtractLastArgument(cmd string) string {
 	return ""
 }
 
-// rlSaveTerminalState saves the current terminal state using stty
-func rlSaveTerminalState() string {
-	cmd := exec.Command("stty", "-g")
-	cmd.Stdin = os.Stdin
-	out, err := cmd.Output()
-	if err != nil {
-		return ""
-	}
-	return strings.TrimSpace(string(out))
-}
-
-// rlRestoreTerminalState restores a saved terminal state using stty
-func rlRestoreTerminalState(state string) {
-	if state == "" {
-		return
-	}
-	cmd := exec.Command("stty", state)
-	cmd.Stdin = os.Stdin
-	_ = cmd.Run()
-}
-
 // rlLaunchExternalEditorForReadline opens the current line in the user's editor and returns
 // the edited text (flattened to a single line). If the editor fails, returns empty string.
 func rlLaunchExternalEditorForReadline(current string) string {
@@ -1658,18 +1789,15 @@ func rlLaunchExternalEditorForReadline(current string) string {
 	// Pick editor: PROMQL_EDITOR > VISUAL > EDITOR > nano
 	editor := getEditorCommand()
 
-	// Safely quote path
-	shQuote := shellQuote
-
 	// Pause readline input so it won't intercept keys intended for the editor
 	if rlInputGate != nil {
 		rlInputGate.Pause()
 	}
-	// Save current (raw) tty state and switch to a sane cooked mode for the editor
-	raw := rlSaveTerminalState()
-	_ = exec.Command("stty", "sane").Run()
+	// Save current (raw) tty state and switch back to cooked mode for the editor
+	raw := saveTerminalState()
+	restoreTerminalState(rlCookedState)
 
-	cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf("%s %s", editor, shQuote(path)))
+	cmd := shellCommand(fmt.Sprintf("%s %s", editor, shellQuote(path)))
 	// Use the real TTY for the editor
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
@@ -1677,7 +1805,7 @@ func rlLaunchExternalEditorForReadline(current string) string {
 	_ = cmd.Run()
 
 	// Return terminal to prior raw state
-	rlRestoreTerminalState(raw)
+	restoreTerminalState(raw)
 	// Drain any pending bytes that may have been queued during editor exit
 	if rlInputGate != nil {
 		// Drain multiple times with small sleeps to capture late-arriving bytes
@@ -1817,10 +1945,10 @@ func qEqualLabels(a, b map[string]string) bool {
 	return true
 }
 
-// getEnvBool reads an environment variable and parses it as boolean.
+// getEnvBool reads an environment variable (or its config file default) and parses it as boolean.
 // Accepts 1/0, true/false (case-insensitive). Falls back to defVal when unset/invalid.
 func getEnvBool(name string, defVal bool) bool {
-	v := os.Getenv(name)
+	v := settingEnv(name)
 	if v == "" {
 		return defVal
 	}
@@ -1907,7 +2035,9 @@ func runBasicInteractiveQueries(engine *promql.Engine, storage *sstorage.SimpleS
 			break
 		}
 
-		executeOne(engine, storage, query)
+		lockStore()
+		executeRecorded(replEngine, storage, query)
+		unlockStore()
 	}
 }
 
@@ -1917,6 +2047,21 @@ func ExecuteQueryLine(engine *promql.Engine, storage *sstorage.SimpleStorage, li
 	executeOne(engine, storage, line)
 }
 
+// ExecuteQueryLineTo is ExecuteQueryLine writing the output to w instead of stdout, with
+// engine as the engine of the ad-hoc commands too while it runs. Stdout is redirected
+// while it runs, so calls must not overlap.
+func ExecuteQueryLineTo(w io.Writer, engine *promql.Engine, storage *sstorage.SimpleStorage, line string) error {
+	prevEngine := replEngine
+	replEngine = engine
+	defer func() { replEngine = prevEngine }()
+	out, err := captureOutput(func() { executeOne(engine, storage, line) })
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, out)
+	return err
+}
+
 // executeOne runs a single command line. Supports ad-hoc dot-commands and PromQL (including .at <time> <query>).
 func executeOne(engine *promql.Engine, storage *sstorage.SimpleStorage, line string) {
 	orig := strings.TrimSpace(line)
@@ -1936,7 +2081,7 @@ func executeOne(engine *promql.Engine, storage *sstorage.SimpleStorage, line str
 			fmt.Println("Usage: !<command>")
 			return
 		}
-		cmd := exec.Command("/bin/sh", "-c", cmdStr)
+		cmd := shellCommand(cmdStr)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		cmd.Stdin = os.Stdin
@@ -1948,7 +2093,21 @@ func executeOne(engine *promql.Engine, storage *sstorage.SimpleStorage, line str
 
 	// Split potential pipeline: <query> | <command> where '|' is outside double-quoted strings
 	queryPart, pipeCmd, hasPipe := splitQueryAndPipe(orig)
-	query := strings.TrimSpace(queryPart)
+	// and result stages: <query> ||| <stage> ||| ...
+	queryPart, stageSpecs := splitResultPipeline(queryPart)
+	query := strings.TrimSpace(ExpandVars(queryPart))
+	var pipeline *resultPipeline
+	if stageSpecs != nil {
+		var err error
+		if pipeline, err = parseResultPipeline(stageSpecs); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if strings.HasPrefix(query, ".") && !strings.HasPrefix(query, ".at ") {
+			fmt.Printf("Error: %s stages apply to query results, not to ad-hoc commands\n", resultPipeSep)
+			return
+		}
+	}
 
 	// Ad-hoc commands (support piping for their printed output)
 	if strings.HasPrefix(query, ".") {
@@ -1966,7 +2125,7 @@ func executeOne(engine *promql.Engine, storage *sstorage.SimpleStorage, line str
 				executeOne(engine, storage, next+" | "+pipeCmd)
 				return
 			}
-			cmd := exec.Command("/bin/sh", "-c", pipeCmd)
+			cmd := shellCommand(pipeCmd)
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
 			stdin, err := cmd.StdinPipe()
@@ -2001,10 +2160,20 @@ func executeOne(engine *promql.Engine, storage *sstorage.SimpleStorage, line str
 	if pinnedEvalTime != nil {
 		evalTime = *pinnedEvalTime
 	}
-	// Support ".at <time> <query>" (overrides pinned time)
+	// Support ".at <time> <query>" (overrides pinned time), and ".at <start>..<end> [step=<step>] <query>"
+	// for a range query
+	var atRange *atRangeSpec
 	if strings.HasPrefix(query, ".at ") {
 		parts := strings.Fields(query)
-		if len(parts) >= 3 {
+		if len(parts) >= 3 && strings.Contains(parts[1], "..") {
+			spec, err := parseAtRange(query)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				fmt.Println("Usage: " + GetAdHocCommandByName(".at").Usage)
+				return
+			}
+			atRange, query = spec, spec.expr
+		} else if len(parts) >= 3 {
 			if ts, err := parseEvalTime(parts[1]); err == nil {
 				evalTime = ts
 				query = strings.TrimPrefix(query, ".at "+parts[1]+" ")
@@ -2018,25 +2187,62 @@ func executeOne(engine *promql.Engine, storage *sstorage.SimpleStorage, line str
 	// Normalize @<unix_ms> to seconds with decimals for PromQL @ modifier
 	query = normalizeAtModifierTimestamps(query)
 
-	ctx, cancel := context.WithTimeout(context.Background(), replTimeout)
-	defer cancel()
-
-	q, err := engine.NewInstantQuery(ctx, storage, nil, query, evalTime)
-	if err != nil {
-		fmt.Printf("Error creating query: %v\n", err)
+	if atRange != nil {
+		evalTime = atRange.end
+	}
+	if !guardQuery(storage, query) {
 		return
 	}
+	runQueryHooks(query, evalTime)
+	start := time.Now()
+	var result *promql.Result
+	if atRange != nil {
+		var err error
+		result, err = RunRangeQuery(engine, storage, query, atRange.start, atRange.end, atRange.step, replTimeout)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			recordFailedQuery(query, err)
+			runResultHooks(query, evalTime, start, nil, err)
+			return
+		}
+		recordLastQuery(query, atRange.end, result)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), replTimeout)
+		defer cancel()
 
-	result := q.Exec(ctx)
-	if result.Err != nil {
-		fmt.Printf("Error: %v\n", result.Err)
-		return
+		q, err := engine.NewInstantQuery(ctx, QueryableFor(storage), nil, query, evalTime)
+		if err != nil {
+			fmt.Printf("Error creating query: %v\n", err)
+			recordFailedQuery(query, err)
+			runResultHooks(query, evalTime, start, nil, err)
+			return
+		}
+
+		result = q.Exec(ctx)
+		if result.Err != nil {
+			fmt.Printf("Error: %v\n", result.Err)
+			recordFailedQuery(query, result.Err)
+			runResultHooks(query, evalTime, start, nil, result.Err)
+			return
+		}
+		recordLastQuery(query, evalTime, result)
+		collectQueryStats(q, result)
+	}
+	runResultHooks(query, evalTime, start, result, nil)
+
+	if pipeline != nil {
+		var err error
+		if result, err = pipeline.apply(result); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		defer pipeline.override()()
 	}
 
 	if hasPipe {
 		// Capture the normal printed output and feed it to the pipe command
-		captured, _ := captureOutput(func() { PrintUpstreamQueryResult(result) })
-		cmd := exec.Command("/bin/sh", "-c", pipeCmd)
+		captured, _ := captureOutput(func() { printResult(result) })
+		cmd := shellCommand(pipeCmd)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		stdin, err := cmd.StdinPipe()
@@ -2054,10 +2260,12 @@ func executeOne(engine *promql.Engine, storage *sstorage.SimpleStorage, line str
 		if err := cmd.Wait(); err != nil {
 			fmt.Printf("Command failed: %v\n", err)
 		}
+		printQueryStats(os.Stdout)
 		return
 	}
 
-	PrintUpstreamQueryResult(result)
+	printResult(result)
+	printQueryStats(os.Stdout)
 }
 
 // captureOutput captures stdout produced by fn and returns it as a string.
@@ -2132,50 +2340,16 @@ func RunInitCommands(engine *promql.Engine, storage *sstorage.SimpleStorage, com
 		defer restore()
 	}
 
-	// Split by ';' and newlines to allow multi-line input
+	// Split by ';' and newlines to allow multi-line input; ";;" is kept as .diff's separator
+	const diffSep = "\x00"
 	seps := strings.NewReplacer("\n", ";", "\r", ";")
-	flat := seps.Replace(commands)
+	flat := seps.Replace(strings.ReplaceAll(commands, diffQuerySeparator, diffSep))
 	parts := strings.Split(flat, ";")
 	for _, p := range parts {
-		cmd := strings.TrimSpace(p)
+		cmd := strings.TrimSpace(strings.ReplaceAll(p, diffSep, diffQuerySeparator))
 		if cmd == "" {
 			continue
 		}
-		executeOne(engine, storage, cmd)
+		executeRecorded(replEngine, storage, cmd)
 	}
 }
-
-func handleAdhocHistory(query string, _ *sstorage.SimpleStorage) bool {
-	fields := strings.Fields(query)
-	n := -1
-	if len(fields) == 2 {
-		if v, err := strconv.Atoi(fields[1]); err == nil && v > 0 {
-			n = v
-		} else {
-			fmt.Println("Usage: .history [N]")
-			return true
-		}
-	} else if len(fields) > 2 {
-		fmt.Println("Usage: .history [N]")
-		return true
-	}
-	// Prefer in-memory history when available (prompt backend)
-	entries := getInMemoryHistory()
-	if len(entries) == 0 {
-		// Fallback to file
-		path := getHistoryFilePath()
-		entries = loadHistoryFromFile(path)
-	}
-	if len(entries) == 0 {
-		fmt.Println("No history available")
-		return true
-	}
-	start := 0
-	if n > 0 && n < len(entries) {
-		start = len(entries) - n
-	}
-	for i := start; i < len(entries); i++ {
-		fmt.Println(entries[i])
-	}
-	return true
-}
@@ -10,8 +10,10 @@ import (
 
 // RunInteractiveQueriesDispatch determines which REPL backend to use
 func RunInteractiveQueriesDispatch(engine *promql.Engine, storage *sstorage.SimpleStorage, silent bool, replBackend string) {
-	// Always set the eval engine for rule evaluations regardless of backend
+	// Always set the REPL and rule evaluation engines regardless of backend
+	replEngine = engine
 	SetEvalEngine(engine)
+	SetInteractive(true)
 
 	if replBackend == "prompt" {
 		if !silent {
@@ -36,48 +38,21 @@ func runPromptREPL(engine *promql.Engine, storage *sstorage.SimpleStorage, silen
 
 	// Set up the executeOne function pointer for prompt_repl.go
 	executeOneFunc = func(s string) {
-		executeOne(engine, storage, s)
+		lockStore()
+		defer unlockStore()
+		executeRecorded(replEngine, storage, s)
 	}
 
 	// Set global storage for metric help text access
 	globalStorage = storage
 
-	// Set up the refresh function for adhoc.go to call after loading metrics
+	// Set up the refresh function for adhoc.go to call after loading metrics or
+	// connecting a remote Prometheus
 	refreshMetricsCache = func(s *sstorage.SimpleStorage) {
 		if s != nil {
-			// Update the global storage reference
+			// Update the global storage reference and rebuild the metrics list
 			globalStorage = s
-
-			// Rebuild metrics list (de-duplicated) and track recording rule names
-			seen := make(map[string]bool)
-			var metricNames []string
-			for name := range s.Metrics {
-				if !seen[name] {
-					metricNames = append(metricNames, name)
-					seen[name] = true
-				}
-			}
-			metrics = metricNames
-			// Add recording rule names for completion even if not present yet (without duplicates)
-			recordingRuleSet = make(map[string]bool)
-			for _, rn := range GetRecordingRuleNames() {
-				recordingRuleSet[rn] = true
-				if !seen[rn] {
-					metrics = append(metrics, rn)
-					seen[rn] = true
-				}
-			}
-			// Add alert names for completion (without duplicates)
-			for _, ar := range GetAlertingRules() {
-				if !seen[ar.Name] {
-					metrics = append(metrics, ar.Name)
-					seen[ar.Name] = true
-				}
-			}
-			metricsHelp = s.MetricsHelp
-
-			// Clear the cached metrics in fetchMetrics to force re-fetch
-			// This ensures the next completion request gets fresh data
+			fetchMetrics()
 			if !silent {
 				fmt.Printf("[Autocompletion cache updated: %d metrics]\n", len(metrics))
 			}
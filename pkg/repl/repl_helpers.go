@@ -9,7 +9,7 @@ import (
 const PromQLSeparators = " (){},=!~\"\t\n+-*/^%"
 
 // getEditorCommand returns the user's preferred editor from environment variables,
-// falling back to nano as default.
+// falling back to nano as default (notepad on Windows).
 // Checks PROMQL_EDITOR, VISUAL, EDITOR in that order.
 func getEditorCommand() string {
 	for _, envVar := range []string{"PROMQL_EDITOR", "VISUAL", "EDITOR"} {
@@ -17,7 +17,7 @@ func getEditorCommand() string {
 			return editor
 		}
 	}
-	return "nano"
+	return defaultEditor
 }
 
 // isWordBoundaryRune checks if a rune is a word boundary using PromQL separators
@@ -25,7 +25,87 @@ func isWordBoundaryRune(r rune) bool {
 	return strings.ContainsRune(PromQLSeparators, r)
 }
 
-// shellQuote safely quotes a string for use in shell commands using POSIX single-quote escaping
-func shellQuote(s string) string {
-	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
+// needsContinuation reports whether query, the lines typed so far joined, is a PromQL
+// expression with unclosed parentheses, brackets or braces, so that Enter continues it on
+// a new line instead of submitting it. Brackets in strings and comments are ignored, and
+// ad-hoc (.) and shell (!) commands are always submitted.
+func needsContinuation(query string) bool {
+	query = strings.TrimSpace(query)
+	if query == "" || strings.HasPrefix(query, ".") || strings.HasPrefix(query, "!") {
+		return false
+	}
+	depth := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '#':
+			// A comment runs to the end of its line
+			if nl := strings.IndexByte(query[i:], '\n'); nl >= 0 {
+				i += nl
+			} else {
+				i = len(query)
+			}
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+// joinContinuedLines joins the lines of a query continued over several lines. PromQL
+// expressions keep their line breaks, which end the # comments they may hold; ad-hoc (.)
+// and shell (!) commands are joined with spaces, as typed on one line.
+func joinContinuedLines(lines []string) string {
+	query := strings.TrimSpace(strings.Join(lines, "\n"))
+	if strings.HasPrefix(query, ".") || strings.HasPrefix(query, "!") {
+		return strings.Join(strings.Fields(query), " ")
+	}
+	return query
+}
+
+// historyLine returns query on a single line for the history, which holds a line per
+// entry: the # comments of a multi-line PromQL expression are dropped so that joining its
+// lines doesn't comment out the rest of it.
+func historyLine(query string) string {
+	if !strings.Contains(query, "\n") {
+		return query
+	}
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(query) {
+				b.WriteByte(c)
+				i++
+				c = query[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '#':
+			nl := strings.IndexByte(query[i:], '\n')
+			if nl < 0 {
+				i = len(query)
+				continue
+			}
+			i += nl
+			c = '\n'
+		}
+		b.WriteByte(c)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
 }
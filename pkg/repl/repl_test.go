@@ -239,3 +239,46 @@ func TestDeletePrevWord(t *testing.T) {
 		})
 	}
 }
+
+func TestNeedsContinuation(t *testing.T) {
+	tests := map[string]bool{
+		"":                                    false,
+		"up":                                  false,
+		"sum(":                                true,
+		"sum(\nrate(foo[5m]":                  true,
+		"sum(\nrate(foo[5m])\n)":              false,
+		`foo{job="a(b"}`:                      false,
+		`foo{job="a\"(b"`:                     true,
+		"sum( # closing ) later\n":            true,
+		"foo)":                                false,
+		".ai ask why is (this":                false,
+		"!echo (":                             false,
+		"label_replace(up, 'x', '(', '', '')": false,
+	}
+	for query, want := range tests {
+		if got := needsContinuation(query); got != want {
+			t.Errorf("needsContinuation(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestJoinContinuedLines(t *testing.T) {
+	tests := []struct {
+		lines       []string
+		query, hist string
+	}{
+		{[]string{"sum(", "  up # all targets", ")"}, "sum(\n  up # all targets\n)", "sum( up )"},
+		{[]string{`up{job="a#b"} # job`, ""}, `up{job="a#b"} # job`, `up{job="a#b"} # job`},
+		{[]string{`count(up{job="a#b"} # job`, ")"}, "count(up{job=\"a#b\"} # job\n)", `count(up{job="a#b"} )`},
+		{[]string{".scrape", "  http://localhost:9100/metrics"}, ".scrape http://localhost:9100/metrics", ".scrape http://localhost:9100/metrics"},
+	}
+	for _, tt := range tests {
+		query := joinContinuedLines(tt.lines)
+		if query != tt.query {
+			t.Errorf("joinContinuedLines(%q) = %q, want %q", tt.lines, query, tt.query)
+		}
+		if got := historyLine(query); got != tt.hist {
+			t.Errorf("historyLine(%q) = %q, want %q", query, got, tt.hist)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package repl
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// resultPipeSep separates a query from the stages that post-process its result, e.g.
+// `up ||| filter label job=~"api.*" ||| head 20 ||| csv`.
+const resultPipeSep = "|||"
+
+// resultStage transforms the result of a query.
+type resultStage func(*promql.Result) (*promql.Result, error)
+
+// resultPipeline holds the parsed stages of a query line, and the output format they
+// select, if any.
+type resultPipeline struct {
+	stages []resultStage
+	format string
+}
+
+// splitResultPipeline splits line on the "|||" outside double-quoted strings into the query
+// and its result stages.
+func splitResultPipeline(line string) (string, []string) {
+	var parts []string
+	inStr, esc, start := false, false, 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if inStr {
+			switch {
+			case esc:
+				esc = false
+			case c == '\\':
+				esc = true
+			case c == '"':
+				inStr = false
+			}
+			continue
+		}
+		if c == '"' {
+			inStr = true
+			continue
+		}
+		if strings.HasPrefix(line[i:], resultPipeSep) {
+			parts = append(parts, strings.TrimSpace(line[start:i]))
+			i += len(resultPipeSep) - 1
+			start = i + 1
+		}
+	}
+	if parts == nil {
+		return line, nil
+	}
+	parts = append(parts, strings.TrimSpace(line[start:]))
+	return parts[0], parts[1:]
+}
+
+// parseResultPipeline parses result stages:
+//
+//	filter label <matchers>       keep the series matching e.g. job=~"api.*",code!="200"
+//	filter value <op> <number>    keep the series whose value compares, e.g. > 0.5
+//	head [N] / tail [N]           keep the first or last N series (default 10)
+//	sort value|metric [asc|desc]  order the series, like .sort
+//	<format>                      print the result in an output format, e.g. csv
+func parseResultPipeline(specs []string) (*resultPipeline, error) {
+	p := &resultPipeline{}
+	for _, spec := range specs {
+		name, args, _ := strings.Cut(spec, " ")
+		name, args = strings.ToLower(name), strings.TrimSpace(args)
+		var stage resultStage
+		var err error
+		switch name {
+		case "":
+			return nil, fmt.Errorf("empty stage after %s", resultPipeSep)
+		case "filter":
+			stage, err = parseFilterStage(args)
+		case "head", "tail":
+			stage, err = parseHeadTailStage(name, args)
+		case "sort":
+			var o ResultSort
+			if o, err = parseResultSort(args); err == nil {
+				stage = func(r *promql.Result) (*promql.Result, error) {
+					arranged, _ := arrangeResult(r, o, 0)
+					return arranged, nil
+				}
+			}
+		default:
+			if _, ok := formatters[name]; !ok || args != "" {
+				return nil, fmt.Errorf("unknown stage %q (expected filter, head, tail, sort or an output format: %s)", spec, strings.Join(FormatNames(), ", "))
+			}
+			p.format = name
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		p.stages = append(p.stages, stage)
+	}
+	return p, nil
+}
+
+// parseFilterStage parses the arguments of "filter label <matchers>" and
+// "filter value <op> <number>".
+func parseFilterStage(args string) (resultStage, error) {
+	kind, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+	switch kind {
+	case "label":
+		if rest == "" {
+			return nil, fmt.Errorf("missing label matcher, e.g. filter label job=~\"api.*\"")
+		}
+		matchers, err := promParser.ParseMetricSelector("{" + rest + "}")
+		if err != nil {
+			return nil, fmt.Errorf("invalid label matcher %q: %w", rest, err)
+		}
+		return seriesFilter(func(l labels.Labels, _ float64, _ bool) bool {
+			for _, m := range matchers {
+				if !m.Matches(l.Get(m.Name)) {
+					return false
+				}
+			}
+			return true
+		}), nil
+	case "value":
+		fields := strings.Fields(rest)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expected filter value <op> <number>, e.g. filter value > 0.5")
+		}
+		n, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", fields[1])
+		}
+		var cmp func(float64) bool
+		switch fields[0] {
+		case ">":
+			cmp = func(v float64) bool { return v > n }
+		case ">=":
+			cmp = func(v float64) bool { return v >= n }
+		case "<":
+			cmp = func(v float64) bool { return v < n }
+		case "<=":
+			cmp = func(v float64) bool { return v <= n }
+		case "==":
+			cmp = func(v float64) bool { return v == n }
+		case "!=":
+			cmp = func(v float64) bool { return v != n }
+		default:
+			return nil, fmt.Errorf("unknown operator %q (expected >, >=, <, <=, == or !=)", fields[0])
+		}
+		return seriesFilter(func(_ labels.Labels, v float64, ok bool) bool { return ok && cmp(v) }), nil
+	}
+	return nil, fmt.Errorf("expected filter label <matchers> or filter value <op> <number>")
+}
+
+// parseHeadTailStage parses "head [N]" and "tail [N]".
+func parseHeadTailStage(name, args string) (resultStage, error) {
+	n := 10
+	if args != "" {
+		var err error
+		if n, err = strconv.Atoi(args); err != nil || n < 0 {
+			return nil, fmt.Errorf("expected a number of series, got %q", args)
+		}
+	}
+	return func(r *promql.Result) (*promql.Result, error) {
+		return sliceSeries(r, func(size int) (int, int) {
+			if name == "head" {
+				return 0, min(n, size)
+			}
+			return max(0, size-n), size
+		})
+	}, nil
+}
+
+// seriesFilter returns a stage keeping the vector samples or matrix series for which keep
+// returns true. keep gets their float value, the last point of a matrix series; ok is false
+// for those without one.
+func seriesFilter(keep func(l labels.Labels, v float64, ok bool) bool) resultStage {
+	return func(r *promql.Result) (*promql.Result, error) {
+		switch v := r.Value.(type) {
+		case promql.Vector:
+			var out promql.Vector
+			for _, s := range v {
+				if keep(s.Metric, s.F, s.H == nil) {
+					out = append(out, s)
+				}
+			}
+			return &promql.Result{Value: out, Warnings: r.Warnings}, nil
+		case promql.Matrix:
+			var out promql.Matrix
+			for _, s := range v {
+				f, ok := math.NaN(), len(s.Floats) > 0
+				if ok {
+					f = s.Floats[len(s.Floats)-1].F
+				}
+				if keep(s.Metric, f, ok) {
+					out = append(out, s)
+				}
+			}
+			return &promql.Result{Value: out, Warnings: r.Warnings}, nil
+		}
+		return nil, fmt.Errorf("expected a vector or matrix result, got %s", r.Value.Type())
+	}
+}
+
+// sliceSeries keeps the vector samples or matrix series between the bounds returned by
+// bounds for their number.
+func sliceSeries(r *promql.Result, bounds func(size int) (int, int)) (*promql.Result, error) {
+	switch v := r.Value.(type) {
+	case promql.Vector:
+		lo, hi := bounds(len(v))
+		return &promql.Result{Value: v[lo:hi], Warnings: r.Warnings}, nil
+	case promql.Matrix:
+		lo, hi := bounds(len(v))
+		return &promql.Result{Value: v[lo:hi], Warnings: r.Warnings}, nil
+	}
+	return nil, fmt.Errorf("expected a vector or matrix result, got %s", r.Value.Type())
+}
+
+// apply runs the stages over result, starting from the order set by .sort.
+func (p *resultPipeline) apply(result *promql.Result) (*promql.Result, error) {
+	if len(p.stages) == 0 {
+		return result, nil
+	}
+	result, _ = arrangeResult(result, resultSort, 0)
+	for _, stage := range p.stages {
+		var err error
+		if result, err = stage(result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// override selects the output format of the pipeline and, when it has stages, leaves out
+// the .sort order apply already used; the returned function restores both.
+func (p *resultPipeline) override() func() {
+	prevFormat, prevSort := outputFormat, resultSort
+	if p.format != "" {
+		outputFormat = p.format
+	}
+	if len(p.stages) > 0 {
+		resultSort = ResultSort{}
+	}
+	return func() { outputFormat, resultSort = prevFormat, prevSort }
+}
@@ -0,0 +1,63 @@
+package repl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitResultPipeline(t *testing.T) {
+	query, stages := splitResultPipeline(`up{job=~"a|||b"} ||| filter label job=~"api.*" ||| head 20 ||| csv`)
+	if query != `up{job=~"a|||b"}` || !reflect.DeepEqual(stages, []string{`filter label job=~"api.*"`, "head 20", "csv"}) {
+		t.Fatalf("unexpected split: %q %q", query, stages)
+	}
+	if query, stages := splitResultPipeline("a or b"); query != "a or b" || stages != nil {
+		t.Fatalf("expected no stages, got %q %q", query, stages)
+	}
+	if q, cmd, ok := splitQueryAndPipe("up ||| head 2 | grep x"); !ok || q != "up ||| head 2" || cmd != "grep x" {
+		t.Fatalf("expected the shell pipe after the stages, got %q %q %v", q, cmd, ok)
+	}
+	for _, specs := range [][]string{{""}, {"uniq"}, {"head -1"}, {"filter label job"}, {"filter value ~ 1"}, {"sort labels"}, {"csv x"}} {
+		if _, err := parseResultPipeline(specs); err == nil {
+			t.Errorf("%q: expected an error", specs)
+		}
+	}
+}
+
+func TestExecuteOne_ResultPipeline(t *testing.T) {
+	store := newTestStore(t)
+	engine := newTestEngine()
+	prevFormat, prevSort := outputFormat, resultSort
+	defer func() { outputFormat, resultSort = prevFormat, prevSort }()
+	if err := SetResultSort("metric desc"); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() {
+		executeOne(engine, store, `{__name__=~"http.*|temperature"} ||| filter label code=~"2..|404" ||| sort value asc ||| head 1 ||| csv`)
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], ",value") || !strings.HasSuffix(lines[1], ",3") {
+		t.Fatalf("expected the lowest of the matching samples as CSV, got: %s", out)
+	}
+	if outputFormat != prevFormat || resultSort.By != "metric" {
+		t.Fatalf("expected the session format and sort restored, got %s %v", outputFormat, resultSort)
+	}
+
+	out = captureStdout(t, func() { executeOne(engine, store, "http_requests_total ||| filter value > 100") })
+	if !strings.Contains(out, `code="200"`) || strings.Contains(out, `code="404"`) {
+		t.Fatalf("expected only the series above 100, got: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(engine, store, "http_requests_total ||| tail 1") })
+	if !strings.Contains(out, `code="200"`) || strings.Contains(out, `code="404"`) {
+		t.Fatalf("expected the last series in .sort order, got: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(engine, store, "scalar(temperature) ||| head 1") })
+	if !strings.Contains(out, "Error: expected a vector or matrix result, got scalar") {
+		t.Fatalf("expected an error for a scalar, got: %s", out)
+	}
+	out = captureStdout(t, func() { executeOne(engine, store, ".metrics ||| head 1") })
+	if !strings.Contains(out, "not to ad-hoc commands") {
+		t.Fatalf("expected ad-hoc commands rejected, got: %s", out)
+	}
+}
@@ -0,0 +1,309 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/template"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// Alert states, as reported by Prometheus.
+const (
+	AlertStateInactive = "inactive"
+	AlertStatePending  = "pending"
+	AlertStateFiring   = "firing"
+)
+
+// defaultRuleInterval is the evaluation interval assumed for rule groups without one.
+const defaultRuleInterval = time.Minute
+
+// AlertInstance is a single alert produced by an alerting rule for one series.
+type AlertInstance struct {
+	Labels      map[string]string
+	Annotations map[string]string
+	Value       float64
+	State       string
+	ActiveFor   time.Duration
+}
+
+// AlertStatus is the evaluated state of an alerting rule at a point in time.
+type AlertStatus struct {
+	Rule      AlertRule
+	State     string
+	Instances []AlertInstance
+	Err       error
+}
+
+// EvaluateAlertStates evaluates the alerting rules at t and derives each alert's state.
+// Without rule manager history, a series is considered active since the earliest
+// consecutive evaluation (at the group interval, looking back up to `for`) where it
+// was present; it is firing once active for at least `for`, pending otherwise.
+func EvaluateAlertStates(engine *promql.Engine, storage *sstorage.SimpleStorage, rules []AlertRule, t time.Time) []AlertStatus {
+	out := make([]AlertStatus, 0, len(rules))
+	for _, r := range rules {
+		st := AlertStatus{Rule: r, State: AlertStateInactive}
+		instances, err := evalAlertInstances(engine, storage, r, t)
+		if err != nil {
+			st.Err = err
+		}
+		st.Instances = instances
+		for _, in := range instances {
+			if in.State == AlertStateFiring {
+				st.State = AlertStateFiring
+				break
+			}
+			st.State = AlertStatePending
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+func evalAlertInstances(engine *promql.Engine, storage *sstorage.SimpleStorage, r AlertRule, t time.Time) ([]AlertInstance, error) {
+	queryable := QueryableFor(storage)
+	ctx, cancel := context.WithTimeout(context.Background(), replTimeout)
+	defer cancel()
+
+	q, err := engine.NewInstantQuery(ctx, queryable, nil, r.Expr, t)
+	if err != nil {
+		return nil, err
+	}
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	var vec promql.Vector
+	switch v := res.Value.(type) {
+	case promql.Vector:
+		vec = v
+	case promql.Scalar:
+		vec = promql.Vector{{T: v.T, F: v.V, Metric: labels.EmptyLabels()}}
+	default:
+		return nil, fmt.Errorf("unsupported result type %T", res.Value)
+	}
+
+	// Find how long each series has been continuously active, looking back up to `for`.
+	step := r.Interval
+	if step <= 0 {
+		step = defaultRuleInterval
+	}
+	activeSteps := map[string]int{}
+	if r.For > 0 {
+		// Look back a whole number of steps so the last evaluation point lands exactly on t.
+		lookback := time.Duration(math.Ceil(float64(r.For)/float64(step))) * step
+		rq, err := engine.NewRangeQuery(ctx, queryable, nil, r.Expr, t.Add(-lookback), t, step)
+		if err != nil {
+			return nil, err
+		}
+		rres := rq.Exec(ctx)
+		if rres.Err != nil {
+			return nil, rres.Err
+		}
+		if m, ok := rres.Value.(promql.Matrix); ok {
+			for _, series := range m {
+				activeSteps[series.Metric.String()] = trailingSteps(series.Floats, t, step)
+			}
+		}
+	}
+
+	queryFn := alertQueryFunc(engine, queryable)
+
+	var instances []AlertInstance
+	for _, smpl := range vec {
+		// Prometheus semantics: NaN is treated as "no data".
+		if math.IsNaN(smpl.F) {
+			continue
+		}
+		lbls, annotations := expandAlert(ctx, queryFn, r, smpl, t)
+
+		in := AlertInstance{Labels: lbls, Annotations: annotations, Value: smpl.F, State: AlertStateFiring}
+		if r.For > 0 {
+			n := max(activeSteps[smpl.Metric.String()], 1)
+			in.ActiveFor = time.Duration(n-1) * step
+			if in.ActiveFor < r.For {
+				in.State = AlertStatePending
+			}
+		}
+		instances = append(instances, in)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return labels.Compare(labels.FromMap(instances[i].Labels), labels.FromMap(instances[j].Labels)) < 0
+	})
+	return instances, nil
+}
+
+// alertQueryFunc returns the query function used by alert templates (e.g. {{ query "..." }}).
+func alertQueryFunc(engine *promql.Engine, queryable storage.Queryable) template.QueryFunc {
+	return func(ctx context.Context, qs string, ts time.Time) (promql.Vector, error) {
+		q, err := engine.NewInstantQuery(ctx, queryable, nil, qs, ts)
+		if err != nil {
+			return nil, err
+		}
+		res := q.Exec(ctx)
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		switch v := res.Value.(type) {
+		case promql.Vector:
+			return v, nil
+		case promql.Scalar:
+			return promql.Vector{{T: v.T, F: v.V, Metric: labels.EmptyLabels()}}, nil
+		default:
+			return nil, fmt.Errorf("rule result is not a vector or scalar")
+		}
+	}
+}
+
+// expandAlert returns the labels (series labels, templated rule labels and alertname) and
+// templated annotations of the alert produced by r for smpl at t.
+func expandAlert(ctx context.Context, queryFn template.QueryFunc, r AlertRule, smpl promql.Sample, t time.Time) (map[string]string, map[string]string) {
+	seriesLabels := smpl.Metric.Map()
+	delete(seriesLabels, labels.MetricName)
+
+	expand := func(name, text string) string {
+		data := template.AlertTemplateData(seriesLabels, nil, "", smpl)
+		defs := "{{$labels := .Labels}}{{$externalLabels := .ExternalLabels}}{{$externalURL := .ExternalURL}}{{$value := .Value}}"
+		tmpl := template.NewTemplateExpander(ctx, defs+text, "__alert_"+r.Name+"_"+name, data, model.TimeFromUnixNano(t.UnixNano()), queryFn, nil, nil)
+		out, err := tmpl.Expand()
+		if err != nil {
+			return fmt.Sprintf("<error expanding template: %v>", err)
+		}
+		return out
+	}
+
+	lbls := maps.Clone(seriesLabels)
+	for k, v := range r.Labels {
+		lbls[k] = expand("label_"+k, v)
+	}
+	lbls[model.AlertNameLabel] = r.Name
+	annotations := make(map[string]string, len(r.Annotations))
+	for k, v := range r.Annotations {
+		annotations[k] = expand("annotation_"+k, v)
+	}
+	return lbls, annotations
+}
+
+// trailingSteps counts the consecutive evaluation points ending at t that are present (and not NaN).
+func trailingSteps(points []promql.FPoint, t time.Time, step time.Duration) int {
+	present := make(map[int64]bool, len(points))
+	for _, p := range points {
+		if !math.IsNaN(p.F) {
+			present[p.T] = true
+		}
+	}
+	n := 0
+	for ts := t.UnixMilli(); present[ts]; ts -= step.Milliseconds() {
+		n++
+	}
+	return n
+}
+
+// formatLabelMap renders labels as {k="v", ...} sorted by name.
+func formatLabelMap(m map[string]string) string {
+	return labels.FromMap(m).String()
+}
+
+// filterAlertingRules returns the active alerting rules whose name matches filter (all when empty),
+// printing a message and returning false when there is nothing to show.
+func filterAlertingRules(filter string) ([]AlertRule, bool) {
+	alerts := GetAlertingRules()
+	if len(alerts) == 0 {
+		fmt.Println("Alerts: none")
+		return nil, false
+	}
+	if filter == "" {
+		return alerts, true
+	}
+	re, err := regexp.Compile(filter)
+	if err != nil {
+		fmt.Printf("Invalid regex %q: %v\n", filter, err)
+		return nil, false
+	}
+	var filtered []AlertRule
+	for _, a := range alerts {
+		if re.MatchString(a.Name) {
+			filtered = append(filtered, a)
+		}
+	}
+	if len(filtered) == 0 {
+		fmt.Printf("No alerting rules match %q\n", filter)
+		return nil, false
+	}
+	return filtered, true
+}
+
+// handleAdhocAlerts lists alerting rules with their current state: .alerts [filter_regex],
+// replays them over a time range: .alerts range <start> <end> <step> [filter_regex]
+// or sends the firing ones to Alertmanager: .alerts push <alertmanager-url> [filter_regex] [--dry-run]
+func handleAdhocAlerts(query string, storage *sstorage.SimpleStorage) bool {
+	rest := strings.TrimSpace(strings.TrimPrefix(query, ".alerts"))
+	if fields := strings.Fields(rest); len(fields) > 0 && fields[0] == "range" {
+		return handleAdhocAlertsRange(fields[1:], storage)
+	}
+	if fields := strings.Fields(rest); len(fields) > 0 && fields[0] == "push" {
+		return handleAdhocAlertsPush(fields[1:], storage)
+	}
+	alerts, ok := filterAlertingRules(strings.Trim(rest, "\"'"))
+	if !ok {
+		return true
+	}
+
+	engine := replEngine
+	if engine == nil {
+		engine = evalEngine
+	}
+	if engine == nil {
+		// No engine available: list rules only
+		fmt.Printf("Alerting rules (%d):\n", len(alerts))
+		for _, a := range alerts {
+			fmt.Printf("  %s: %s\n", a.Name, a.Expr)
+		}
+		return true
+	}
+
+	t := time.Now()
+	if pinnedEvalTime != nil {
+		t = *pinnedEvalTime
+	}
+	fmt.Printf("Alerting rules (%d) @ %s:\n", len(alerts), t.UTC().Format(time.RFC3339))
+	for _, st := range EvaluateAlertStates(engine, storage, alerts, t) {
+		header := fmt.Sprintf("  %s [%s]", st.Rule.Name, st.State)
+		if st.Rule.For > 0 {
+			header += fmt.Sprintf(" for=%s", model.Duration(st.Rule.For))
+		}
+		fmt.Println(header)
+		fmt.Printf("    expr: %s\n", st.Rule.Expr)
+		if st.Err != nil {
+			fmt.Printf("    error: %v\n", st.Err)
+			continue
+		}
+		for _, in := range st.Instances {
+			line := fmt.Sprintf("    - %s %s value=%g", in.State, formatLabelMap(in.Labels), in.Value)
+			if st.Rule.For > 0 {
+				line += fmt.Sprintf(" active=%s", model.Duration(in.ActiveFor))
+			}
+			fmt.Println(line)
+			keys := make([]string, 0, len(in.Annotations))
+			for k := range in.Annotations {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Printf("        %s: %s\n", k, in.Annotations[k])
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// AlertEpisode is one continuous period during which an alert was active for a series.
+// FiredAt is zero when the alert stayed pending; ResolvedAt is zero when it was
+// still active at the end of the backtest range.
+type AlertEpisode struct {
+	Labels     map[string]string
+	ActiveAt   time.Time
+	FiredAt    time.Time
+	ResolvedAt time.Time
+}
+
+// AlertBacktest is the replay of an alerting rule over a time range.
+type AlertBacktest struct {
+	Rule     AlertRule
+	Episodes []AlertEpisode
+	Err      error
+}
+
+// BacktestAlerts replays the alerting rules over [start, end], evaluating every step like
+// the rule manager would: a series becomes pending when it appears, fires once it has been
+// present for at least `for`, and resolves at the first evaluation where it is absent.
+// Series already active before start are treated as becoming active at start.
+func BacktestAlerts(engine *promql.Engine, storage *sstorage.SimpleStorage, rules []AlertRule, start, end time.Time, step time.Duration) []AlertBacktest {
+	out := make([]AlertBacktest, 0, len(rules))
+	for _, r := range rules {
+		bt := AlertBacktest{Rule: r}
+		bt.Episodes, bt.Err = backtestAlert(engine, storage, r, start, end, step)
+		out = append(out, bt)
+	}
+	return out
+}
+
+func backtestAlert(engine *promql.Engine, storage *sstorage.SimpleStorage, r AlertRule, start, end time.Time, step time.Duration) ([]AlertEpisode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), replTimeout)
+	defer cancel()
+	q, err := engine.NewRangeQuery(ctx, QueryableFor(storage), nil, r.Expr, start, end, step)
+	if err != nil {
+		return nil, err
+	}
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	m, ok := res.Value.(promql.Matrix)
+	if !ok {
+		return nil, fmt.Errorf("unsupported result type %T", res.Value)
+	}
+
+	var episodes []AlertEpisode
+	for _, series := range m {
+		present := make(map[int64]bool, len(series.Floats))
+		for _, p := range series.Floats {
+			// Prometheus semantics: NaN is treated as "no data".
+			if !math.IsNaN(p.F) {
+				present[p.T] = true
+			}
+		}
+		lbls := series.Metric.Map()
+		delete(lbls, labels.MetricName)
+		for k, v := range r.Labels {
+			// Templated labels depend on the value at each evaluation; keep only static ones.
+			if !strings.Contains(v, "{{") {
+				lbls[k] = v
+			}
+		}
+		lbls[model.AlertNameLabel] = r.Name
+
+		var cur *AlertEpisode
+		for ts := start; !ts.After(end); ts = ts.Add(step) {
+			if !present[ts.UnixMilli()] {
+				if cur != nil {
+					cur.ResolvedAt = ts
+					episodes = append(episodes, *cur)
+					cur = nil
+				}
+				continue
+			}
+			if cur == nil {
+				cur = &AlertEpisode{Labels: lbls, ActiveAt: ts}
+			}
+			if cur.FiredAt.IsZero() && ts.Sub(cur.ActiveAt) >= r.For {
+				cur.FiredAt = ts
+			}
+		}
+		if cur != nil {
+			episodes = append(episodes, *cur)
+		}
+	}
+	sort.SliceStable(episodes, func(i, j int) bool {
+		if !episodes[i].ActiveAt.Equal(episodes[j].ActiveAt) {
+			return episodes[i].ActiveAt.Before(episodes[j].ActiveAt)
+		}
+		return labels.Compare(labels.FromMap(episodes[i].Labels), labels.FromMap(episodes[j].Labels)) < 0
+	})
+	return episodes, nil
+}
+
+// handleAdhocAlertsRange backtests alerting rules: .alerts range <start> <end> <step> [filter_regex]
+func handleAdhocAlertsRange(args []string, storage *sstorage.SimpleStorage) bool {
+	if len(args) < 3 || len(args) > 4 {
+		fmt.Println("Usage: .alerts range <start> <end> <step> [filter_regex]")
+		fmt.Println("Example: .alerts range now-6h now 1m")
+		return true
+	}
+	start, end, step, err := ParseRangeSpec(args[0], args[1], args[2])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	filter := ""
+	if len(args) == 4 {
+		filter = strings.Trim(args[3], "\"'")
+	}
+	alerts, ok := filterAlertingRules(filter)
+	if !ok {
+		return true
+	}
+	engine := replEngine
+	if engine == nil {
+		engine = evalEngine
+	}
+	if engine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+
+	fmtTime := func(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+	fmt.Printf("Alert backtest %s → %s step %s (%d rules):\n", fmtTime(start), fmtTime(end), model.Duration(step), len(alerts))
+	for _, bt := range BacktestAlerts(engine, storage, alerts, start, end, step) {
+		header := "  " + bt.Rule.Name
+		if bt.Rule.For > 0 {
+			header += fmt.Sprintf(" for=%s", model.Duration(bt.Rule.For))
+		}
+		fired := 0
+		for _, ep := range bt.Episodes {
+			if !ep.FiredAt.IsZero() {
+				fired++
+			}
+		}
+		fmt.Printf("%s: fired %d time(s)\n", header, fired)
+		fmt.Printf("    expr: %s\n", bt.Rule.Expr)
+		if bt.Err != nil {
+			fmt.Printf("    error: %v\n", bt.Err)
+			continue
+		}
+		for _, ep := range bt.Episodes {
+			line := fmt.Sprintf("    - %s pending %s", formatLabelMap(ep.Labels), fmtTime(ep.ActiveAt))
+			if !ep.FiredAt.IsZero() {
+				line += " firing " + fmtTime(ep.FiredAt)
+			}
+			switch {
+			case !ep.ResolvedAt.IsZero() && !ep.FiredAt.IsZero():
+				line += fmt.Sprintf(" resolved %s (fired %s)", fmtTime(ep.ResolvedAt), model.Duration(ep.ResolvedAt.Sub(ep.FiredAt)))
+			case !ep.ResolvedAt.IsZero():
+				line += fmt.Sprintf(" cleared %s (never fired)", fmtTime(ep.ResolvedAt))
+			default:
+				line += " (still active)"
+			}
+			fmt.Println(line)
+		}
+	}
+	return true
+}
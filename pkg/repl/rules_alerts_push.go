@@ -0,0 +1,150 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// alertmanagerAlertsPath is the Alertmanager v2 endpoint that receives alerts.
+const alertmanagerAlertsPath = "/api/v2/alerts"
+
+// AlertmanagerAlert is an alert as posted to the Alertmanager v2 API (postableAlert).
+type AlertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// FiringAlerts converts the firing instances of statuses into Alertmanager alerts. Times are
+// relative to now rather than the evaluation time, so that alerts evaluated against old or
+// synthetic data are still current for Alertmanager: they start ActiveFor before now and,
+// like Prometheus does, expire after 4 evaluation intervals unless pushed again.
+func FiringAlerts(statuses []AlertStatus, now time.Time) []AlertmanagerAlert {
+	var out []AlertmanagerAlert
+	for _, st := range statuses {
+		interval := st.Rule.Interval
+		if interval <= 0 {
+			interval = defaultRuleInterval
+		}
+		for _, in := range st.Instances {
+			if in.State != AlertStateFiring {
+				continue
+			}
+			out = append(out, AlertmanagerAlert{
+				Labels:      in.Labels,
+				Annotations: in.Annotations,
+				StartsAt:    now.Add(-in.ActiveFor).UTC(),
+				EndsAt:      now.Add(4 * interval).UTC(),
+			})
+		}
+	}
+	return out
+}
+
+// alertmanagerAlertsURL appends the v2 alerts path to an Alertmanager base URL unless present.
+func alertmanagerAlertsURL(base string) string {
+	base = strings.TrimRight(base, "/")
+	if strings.HasSuffix(base, alertmanagerAlertsPath) {
+		return base
+	}
+	return base + alertmanagerAlertsPath
+}
+
+// PushAlerts posts alerts to an Alertmanager (base URL or full /api/v2/alerts URL).
+func PushAlerts(ctx context.Context, client *http.Client, amURL string, alerts []AlertmanagerAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, alertmanagerAlertsURL(amURL), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// handleAdhocAlertsPush sends the currently firing alerts to Alertmanager:
+// .alerts push <alertmanager-url> [filter_regex] [--dry-run]
+func handleAdhocAlertsPush(args []string, storage *sstorage.SimpleStorage) bool {
+	dryRun := false
+	var pos []string
+	for _, a := range args {
+		if a == "--dry-run" {
+			dryRun = true
+			continue
+		}
+		pos = append(pos, a)
+	}
+	if len(pos) < 1 || len(pos) > 2 {
+		fmt.Println("Usage: .alerts push <alertmanager-url> [filter_regex] [--dry-run]")
+		fmt.Println("Example: .alerts push http://localhost:9093 --dry-run")
+		return true
+	}
+	filter := ""
+	if len(pos) == 2 {
+		filter = strings.Trim(pos[1], "\"'")
+	}
+	rules, ok := filterAlertingRules(filter)
+	if !ok {
+		return true
+	}
+	engine := replEngine
+	if engine == nil {
+		engine = evalEngine
+	}
+	if engine == nil {
+		fmt.Println("Error: query engine not initialized")
+		return true
+	}
+	t := time.Now()
+	if pinnedEvalTime != nil {
+		t = *pinnedEvalTime
+	}
+	statuses := EvaluateAlertStates(engine, storage, rules, t)
+	for _, st := range statuses {
+		if st.Err != nil {
+			fmt.Printf("Warning: %s: %v\n", st.Rule.Name, st.Err)
+		}
+	}
+	alerts := FiringAlerts(statuses, time.Now())
+	if len(alerts) == 0 {
+		fmt.Printf("No firing alerts @ %s; nothing to push\n", t.UTC().Format(time.RFC3339))
+		return true
+	}
+	if dryRun {
+		payload, err := json.MarshalIndent(alerts, "", "  ")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return true
+		}
+		fmt.Printf("POST %s (dry run, %d alerts):\n%s\n", alertmanagerAlertsURL(pos[0]), len(alerts), payload)
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := PushAlerts(ctx, &http.Client{}, pos[0], alerts); err != nil {
+		fmt.Printf("Failed to push alerts to %s: %v\n", pos[0], err)
+		return true
+	}
+	fmt.Printf("Pushed %d firing alerts to %s\n", len(alerts), alertmanagerAlertsURL(pos[0]))
+	return true
+}
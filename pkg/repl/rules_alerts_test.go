@@ -0,0 +1,189 @@
+package repl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestAdhoc_Alerts_States(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := sstorage.NewSimpleStorage()
+	for i := 10; i >= 0; i-- {
+		ts := now.Add(-time.Duration(i) * time.Minute).UnixMilli()
+		store.AddSample(map[string]string{"__name__": "errors", "service": "api"}, 5, ts)
+		if i <= 2 {
+			store.AddSample(map[string]string{"__name__": "errors", "service": "web"}, 7, ts)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "alerts.yaml")
+	yaml := `groups:
+- name: test
+  rules:
+  - alert: ErrorsNow
+    expr: errors{service="api"} > 1
+    labels:
+      severity: page
+    annotations:
+      summary: '{{ $labels.service }} has {{ $value }} errors'
+  - alert: ErrorsSustained
+    expr: errors > 1
+    for: 5m
+  - alert: ErrorsHuge
+    expr: errors > 100
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	SetActiveRules([]string{path}, path)
+	oldEngine, oldPinned := replEngine, pinnedEvalTime
+	replEngine, pinnedEvalTime = newTestEngine(), &now
+	defer func() {
+		SetActiveRules(nil, "")
+		replEngine, pinnedEvalTime = oldEngine, oldPinned
+	}()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".alerts", store) })
+	for _, want := range []string{
+		"Alerting rules (3)",
+		"ErrorsNow [firing]",
+		`- firing {alertname="ErrorsNow", service="api", severity="page"} value=5`,
+		"summary: api has 5 errors",
+		"ErrorsSustained [firing] for=5m",
+		`- firing {alertname="ErrorsSustained", service="api"} value=5 active=5m`,
+		`- pending {alertname="ErrorsSustained", service="web"} value=7 active=2m`,
+		"ErrorsHuge [inactive]",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".alerts 'Huge$'", store) })
+	if !strings.Contains(out, "Alerting rules (1)") || strings.Contains(out, "ErrorsNow") {
+		t.Fatalf("expected filtered output, got:\n%s", out)
+	}
+}
+
+func TestAdhoc_AlertsRange_Backtest(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	store := sstorage.NewSimpleStorage()
+	// errors > 1 from minute 2 to 9 (inclusive), then back to 0.
+	for i := 0; i <= 15; i++ {
+		v := 0.0
+		if i >= 2 && i <= 9 {
+			v = 5
+		}
+		store.AddSample(map[string]string{"__name__": "errors", "service": "api"}, v, base.Add(time.Duration(i)*time.Minute).UnixMilli())
+	}
+	path := filepath.Join(t.TempDir(), "alerts.yaml")
+	yaml := `groups:
+- name: test
+  rules:
+  - alert: ErrorsSustained
+    expr: errors > 1
+    for: 5m
+    labels:
+      severity: page
+  - alert: ErrorsLong
+    expr: errors > 1
+    for: 30m
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	SetActiveRules([]string{path}, path)
+	oldEngine := replEngine
+	replEngine = newTestEngine()
+	defer func() {
+		SetActiveRules(nil, "")
+		replEngine = oldEngine
+	}()
+
+	start, end := base.UTC().Format(time.RFC3339), base.Add(15*time.Minute).UTC().Format(time.RFC3339)
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".alerts range "+start+" "+end+" 1m", store) })
+	for _, want := range []string{
+		"(2 rules)",
+		"ErrorsSustained for=5m: fired 1 time(s)",
+		`- {alertname="ErrorsSustained", service="api", severity="page"} pending 2023-11-14T22:15:20Z firing 2023-11-14T22:20:20Z resolved 2023-11-14T22:23:20Z (fired 3m)`,
+		"ErrorsLong for=30m: fired 0 time(s)",
+		`- {alertname="ErrorsLong", service="api"} pending 2023-11-14T22:15:20Z cleared 2023-11-14T22:23:20Z (never fired)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in output:\n%s", want, out)
+		}
+	}
+
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".alerts range "+start+" "+end+" 1m Long", store) })
+	if strings.Contains(out, "ErrorsSustained") || !strings.Contains(out, "(1 rules)") {
+		t.Fatalf("expected filter to keep only ErrorsLong:\n%s", out)
+	}
+}
+
+func TestAdhoc_AlertsPush(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := sstorage.NewSimpleStorage()
+	store.AddSample(map[string]string{"__name__": "errors", "service": "api"}, 5, now.UnixMilli())
+	path := filepath.Join(t.TempDir(), "alerts.yaml")
+	yaml := `groups:
+- name: test
+  rules:
+  - alert: ErrorsNow
+    expr: errors > 1
+    labels:
+      severity: page
+    annotations:
+      summary: '{{ $labels.service }} has {{ $value }} errors'
+  - alert: ErrorsHuge
+    expr: errors > 100
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	SetActiveRules([]string{path}, path)
+	oldEngine, oldPinned := replEngine, pinnedEvalTime
+	replEngine, pinnedEvalTime = newTestEngine(), &now
+	defer func() {
+		SetActiveRules(nil, "")
+		replEngine, pinnedEvalTime = oldEngine, oldPinned
+	}()
+
+	var got []AlertmanagerAlert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v2/alerts" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	out := captureStdout(t, func() { _ = handleAdHocFunction(".alerts push "+srv.URL, store) })
+	if !strings.Contains(out, "Pushed 1 firing alerts to "+srv.URL+"/api/v2/alerts") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	if len(got) != 1 || got[0].Labels["alertname"] != "ErrorsNow" || got[0].Labels["severity"] != "page" ||
+		got[0].Annotations["summary"] != "api has 5 errors" || !got[0].EndsAt.After(time.Now()) {
+		t.Fatalf("unexpected payload: %+v", got)
+	}
+
+	got = nil
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".alerts push "+srv.URL+" --dry-run", store) })
+	if got != nil || !strings.Contains(out, "(dry run, 1 alerts)") || !strings.Contains(out, `"alertname": "ErrorsNow"`) {
+		t.Fatalf("expected dry run payload and no request, got %+v:\n%s", got, out)
+	}
+	out = captureStdout(t, func() { _ = handleAdHocFunction(".alerts push "+srv.URL+" Huge", store) })
+	if !strings.Contains(out, "No firing alerts") {
+		t.Fatalf("expected no firing alerts, got:\n%s", out)
+	}
+}
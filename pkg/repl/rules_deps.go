@@ -0,0 +1,107 @@
+package repl
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// recordingRules returns the recording rules of groups in file/group order.
+func recordingRules(groups []rulefmt.RuleGroup) []rulefmt.Rule {
+	var out []rulefmt.Rule
+	for _, g := range groups {
+		for _, r := range g.Rules {
+			if r.Record != "" {
+				out = append(out, r)
+			}
+		}
+	}
+	return out
+}
+
+// recordingRuleDeps returns, for each rule, the indices of the other rules whose output it
+// selects by metric name. Selectors without a __name__ matcher don't create dependencies.
+func recordingRuleDeps(rules []rulefmt.Rule) ([][]int, error) {
+	deps := make([][]int, len(rules))
+	for i, r := range rules {
+		expr, err := promParser.ParseExpr(r.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("recording rule %q: parse error: %w", r.Record, err)
+		}
+		seen := map[int]bool{}
+		parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+			vs, ok := node.(*parser.VectorSelector)
+			if !ok {
+				return nil
+			}
+			for j, other := range rules {
+				if j != i && !seen[j] && selectsMetric(vs, other.Record) {
+					seen[j] = true
+					deps[i] = append(deps[i], j)
+				}
+			}
+			return nil
+		})
+	}
+	return deps, nil
+}
+
+// selectsMetric reports whether vs has a __name__ matcher and all of them match name.
+func selectsMetric(vs *parser.VectorSelector, name string) bool {
+	found := false
+	for _, m := range vs.LabelMatchers {
+		if m.Name != labels.MetricName {
+			continue
+		}
+		if !m.Matches(name) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// OrderRecordingRules sorts recording rules so that each one is evaluated after the rules
+// whose output it reads, across groups and files; otherwise file order is kept. Rules on a
+// dependency cycle can't be ordered: they are evaluated last, in file order, and their
+// names are returned in cyclic.
+func OrderRecordingRules(rules []rulefmt.Rule) (ordered []rulefmt.Rule, cyclic []string, err error) {
+	deps, err := recordingRuleDeps(rules)
+	if err != nil {
+		return nil, nil, err
+	}
+	done := make([]bool, len(rules))
+	for len(ordered) < len(rules) {
+		progress := false
+		for i, r := range rules {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for _, j := range deps[i] {
+				if !done[j] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[i] = true
+				ordered = append(ordered, r)
+				progress = true
+				break // restart so earlier rules in file order go first
+			}
+		}
+		if !progress {
+			for i, r := range rules {
+				if !done[i] {
+					ordered = append(ordered, r)
+					cyclic = append(cyclic, r.Record)
+				}
+			}
+			break
+		}
+	}
+	return ordered, cyclic, nil
+}
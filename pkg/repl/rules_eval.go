@@ -73,6 +73,8 @@ func isYAML(path string) bool {
 }
 
 // EvaluateRulesOnStorage parses and evaluates Prometheus rules and applies recording results into storage.
+// Recording rules run first, ordered by their dependencies (see OrderRecordingRules), so alerts
+// and chained recording rules see this evaluation's outputs regardless of file and group order.
 // Alerts are printed via the provided printFn (or collected if nil).
 // Returns number of recording samples added and the number of alert instances detected.
 func EvaluateRulesOnStorage(engine *promql.Engine, storage *sstorage.SimpleStorage, files []string, evalTime time.Time, printFn func(string)) (int, int, error) {
@@ -80,31 +82,95 @@ func EvaluateRulesOnStorage(engine *promql.Engine, storage *sstorage.SimpleStora
 	if err != nil {
 		return 0, 0, err
 	}
+	recording, cyclic, err := OrderRecordingRules(recordingRules(groups))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(cyclic) > 0 && printFn != nil {
+		printFn(fmt.Sprintf("Warning: recording rules depend on each other in a cycle, evaluated in file order: %s", strings.Join(cyclic, ", ")))
+	}
 	added := 0
 	alerts := 0
+	for _, r := range recording {
+		n, err := evalRecordingRule(engine, storage, r, evalTime)
+		if err != nil {
+			return added, alerts, err
+		}
+		added += n
+	}
 	for _, g := range groups {
 		for _, r := range g.Rules {
-			if r.Record != "" {
-				n, err := evalRecordingRule(engine, storage, r, evalTime)
-				if err != nil {
-					return added, alerts, err
-				}
-				added += n
-				continue
-			}
 			if r.Alert != "" {
 				n, err := evalAlertingRule(engine, storage, r, evalTime, printFn)
 				if err != nil {
 					return added, alerts, err
 				}
 				alerts += n
-				continue
 			}
 		}
 	}
 	return added, alerts, nil
 }
 
+// BackfillResult summarizes a recording rule backfill.
+type BackfillResult struct {
+	Steps    int            // evaluation timestamps in the range
+	Replaced int            // previously stored samples of the recorded metrics dropped from the range
+	Samples  map[string]int // recording rule name -> samples written
+	Cyclic   []string       // recording rules on a dependency cycle, evaluated in file order
+}
+
+// BackfillRecordingRules evaluates the recording rules of files at every step in [start, end],
+// writing the results with the step's timestamp. Rules run in dependency order at each step,
+// so rules built on other recording rules see their outputs. Samples of the recorded metrics
+// already stored within the range are replaced. Alerting rules are not evaluated.
+func BackfillRecordingRules(engine *promql.Engine, storage *sstorage.SimpleStorage, files []string, start, end time.Time, step time.Duration) (BackfillResult, error) {
+	res := BackfillResult{Samples: map[string]int{}}
+	if step <= 0 {
+		return res, fmt.Errorf("step must be a positive duration")
+	}
+	groups, err := loadRuleGroups(files)
+	if err != nil {
+		return res, err
+	}
+	rules, cyclic, err := OrderRecordingRules(recordingRules(groups))
+	if err != nil {
+		return res, err
+	}
+	if len(rules) == 0 {
+		return res, fmt.Errorf("no recording rules in %d file(s)", len(files))
+	}
+	res.Cyclic = cyclic
+	mint, maxt := start.UnixMilli(), end.UnixMilli()
+	for _, r := range rules {
+		samples, ok := storage.Metrics[r.Record]
+		if !ok {
+			continue
+		}
+		kept := samples[:0]
+		for _, s := range samples {
+			if s.Timestamp < mint || s.Timestamp > maxt {
+				kept = append(kept, s)
+			}
+		}
+		res.Replaced += len(samples) - len(kept)
+		storage.Metrics[r.Record] = kept
+	}
+	// The samples were rewritten in place, which the index can't tell from its own checks.
+	storage.InvalidateIndex()
+	for t := start; !t.After(end); t = t.Add(step) {
+		res.Steps++
+		for _, r := range rules {
+			n, err := evalRecordingRule(engine, storage, r, t)
+			if err != nil {
+				return res, fmt.Errorf("at %s: %w", t.UTC().Format(time.RFC3339), err)
+			}
+			res.Samples[r.Record] += n
+		}
+	}
+	return res, nil
+}
+
 func loadRuleGroups(files []string) ([]rulefmt.RuleGroup, error) {
 	var groups []rulefmt.RuleGroup
 	for _, file := range files {
@@ -156,7 +222,11 @@ func evalRecordingRule(engine *promql.Engine, storage *sstorage.SimpleStorage, r
 			}
 			lbls["__name__"] = r.Record
 			// Use the engine's computed value; timestamp from evaluation time passed in
-			storage.AddSample(lbls, smpl.F, t.UnixMilli())
+			if smpl.H != nil {
+				storage.AddHistogramSample(lbls, smpl.H, t.UnixMilli())
+			} else {
+				storage.AddSample(lbls, smpl.F, t.UnixMilli())
+			}
 			recorded++
 		}
 	case promql.Scalar:
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/prometheus/model/rulefmt"
 	"github.com/prometheus/prometheus/promql"
 
 	sstorage "github.com/jjo/promql-cli/pkg/storage"
@@ -61,3 +62,134 @@ func TestEvaluateRulesOnStorage_RecordingRule(t *testing.T) {
 		t.Fatalf("expected recorded metric present in storage")
 	}
 }
+
+func TestBackfillRecordingRules_ChainedRules(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	for i := range 11 {
+		ts := int64(1700000000000 + i*60000)
+		store.AddSample(map[string]string{"__name__": "reqs_total", "code": "200"}, float64(i*60), ts)
+		store.AddSample(map[string]string{"__name__": "reqs_total", "code": "500"}, float64(i*6), ts)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+	rules := `groups:
+- name: chained
+  rules:
+  - record: code:reqs:rate2m
+    expr: sum by (code) (rate(reqs_total[2m]))
+  - record: reqs:rate2m
+    expr: sum(code:reqs:rate2m)
+`
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+	start, end := time.UnixMilli(1700000120000), time.UnixMilli(1700000600000)
+	res, err := BackfillRecordingRules(newTestEngine(), store, []string{path}, start, end, time.Minute)
+	if err != nil {
+		t.Fatalf("BackfillRecordingRules: %v", err)
+	}
+	if res.Steps != 9 || res.Samples["code:reqs:rate2m"] != 18 || res.Samples["reqs:rate2m"] != 9 || res.Replaced != 0 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	for _, s := range store.Metrics["reqs:rate2m"] {
+		if s.Value < 1.09 || s.Value > 1.11 {
+			t.Fatalf("expected reqs:rate2m ~1.1 at every step, got %v at %d", s.Value, s.Timestamp)
+		}
+	}
+
+	// Backfilling again replaces the samples instead of duplicating them.
+	res, err = BackfillRecordingRules(newTestEngine(), store, []string{path}, start, end, time.Minute)
+	if err != nil {
+		t.Fatalf("BackfillRecordingRules: %v", err)
+	}
+	if res.Replaced != 27 || len(store.Metrics["code:reqs:rate2m"]) != 18 || len(store.Metrics["reqs:rate2m"]) != 9 {
+		t.Fatalf("expected samples to be replaced, got %+v and %d/%d stored", res, len(store.Metrics["code:reqs:rate2m"]), len(store.Metrics["reqs:rate2m"]))
+	}
+}
+
+func TestBackfillRecordingRules_QueryAfterReplace(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	ts := time.UnixMilli(1700000000000)
+	store.AddSample(map[string]string{"__name__": "g"}, 1, ts.UnixMilli())
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte("groups:\n- name: g\n  rules:\n  - record: g2\n    expr: g * 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine := newTestEngine()
+	query := func() float64 {
+		t.Helper()
+		q, err := engine.NewInstantQuery(t.Context(), QueryableFor(store), nil, "g2", ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer q.Close()
+		v, err := q.Exec(t.Context()).Vector()
+		if err != nil || len(v) != 1 {
+			t.Fatalf("unexpected result %v: %v", v, err)
+		}
+		return v[0].F
+	}
+	for _, value := range []float64{1, 5} {
+		store.Metrics["g"][0].Value = value
+		if _, err := BackfillRecordingRules(engine, store, []string{path}, ts, ts, time.Minute); err != nil {
+			t.Fatalf("BackfillRecordingRules: %v", err)
+		}
+		if got := query(); got != value*2 {
+			t.Fatalf("g2 = %v after backfilling g = %v, want %v", got, value, value*2)
+		}
+	}
+}
+
+func TestOrderRecordingRules(t *testing.T) {
+	rules := []rulefmt.Rule{
+		{Record: "job:errors:ratio", Expr: "job:errors:rate5m / job:reqs:rate5m"},
+		{Record: "job:errors:rate5m", Expr: `sum by (job) (rate(reqs_total{code=~"5.."}[5m]))`},
+		{Record: "job:reqs:rate5m", Expr: "sum by (job) (rate(reqs_total[5m]))"},
+		{Record: "a", Expr: "b + 1"},
+		{Record: "b", Expr: `{__name__=~"a|c"}`},
+		{Record: "c", Expr: "vector(1)"},
+	}
+	ordered, cyclic, err := OrderRecordingRules(rules)
+	if err != nil {
+		t.Fatalf("OrderRecordingRules: %v", err)
+	}
+	var names []string
+	for _, r := range ordered {
+		names = append(names, r.Record)
+	}
+	want := []string{"job:errors:rate5m", "job:reqs:rate5m", "job:errors:ratio", "c", "a", "b"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("order = %v, want %v", names, want)
+	}
+	if strings.Join(cyclic, " ") != "a b" {
+		t.Fatalf("cyclic = %v, want [a b]", cyclic)
+	}
+}
+
+func TestEvaluateRulesOnStorage_DependencyOrderAcrossGroups(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	store.AddSample(map[string]string{"__name__": "reqs_total", "job": "api"}, 10, 1700000000000)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+	rules := `groups:
+- name: derived
+  rules:
+  - record: job:reqs:doubled
+    expr: job:reqs:sum * 2
+- name: base
+  rules:
+  - record: job:reqs:sum
+    expr: sum by (job) (reqs_total)
+`
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+	added, _, err := EvaluateRulesOnStorage(newTestEngine(), store, []string{path}, time.UnixMilli(1700000000000), nil)
+	if err != nil {
+		t.Fatalf("EvaluateRulesOnStorage: %v", err)
+	}
+	doubled := store.Metrics["job:reqs:doubled"]
+	if added != 2 || len(doubled) != 1 || doubled[0].Value != 20 {
+		t.Fatalf("expected the chained rule to be evaluated after its input in one pass, got added=%d %+v", added, doubled)
+	}
+}
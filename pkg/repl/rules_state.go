@@ -6,6 +6,7 @@ import (
 
 	"github.com/prometheus/prometheus/promql"
 
+	ai "github.com/jjo/promql-cli/pkg/ai"
 	sstorage "github.com/jjo/promql-cli/pkg/storage"
 )
 
@@ -17,10 +18,14 @@ var (
 	activeAlertingRules  []AlertRule
 )
 
-// AlertRule represents an alerting rule with its name and expression
+// AlertRule represents an alerting rule with its name, expression and metadata
 type AlertRule struct {
-	Name string
-	Expr string
+	Name        string
+	Expr        string
+	For         time.Duration
+	Interval    time.Duration // evaluation interval of the rule group (0 if unset)
+	Labels      map[string]string
+	Annotations map[string]string
 }
 
 // SetEvalEngine stores a reference to the promql.Engine for rule evaluations in the REPL.
@@ -32,6 +37,7 @@ func SetActiveRules(files []string, spec string) {
 	activeRuleSpec = spec
 	activeRecordingNames = collectRecordingRuleNames(files)
 	activeAlertingRules = collectAlertingRules(files)
+	ai.SetRecordingRuleNames(activeRecordingNames)
 }
 
 // GetActiveRules returns the current spec and files.
@@ -56,7 +62,8 @@ func GetAlertExpr(alertName string) string {
 }
 
 // EvaluateActiveRules evaluates currently active rule files (if any) over the provided storage.
-// Uses pinnedEvalTime when set, else time.Now(). Prints a brief summary.
+// Uses pinnedEvalTime when set, else time.Now(). Prints a brief summary, and runs the
+// on_alert_firing hooks when alerts fire.
 func EvaluateActiveRules(storage *sstorage.SimpleStorage) (added int, alerts int, err error) {
 	if evalEngine == nil || len(activeRuleFiles) == 0 {
 		return 0, 0, nil
@@ -65,7 +72,11 @@ func EvaluateActiveRules(storage *sstorage.SimpleStorage) (added int, alerts int
 	if pinnedEvalTime != nil {
 		t = *pinnedEvalTime
 	}
-	return EvaluateRulesOnStorage(evalEngine, storage, activeRuleFiles, t, func(s string) { fmt.Println(s) })
+	added, alerts, err = EvaluateRulesOnStorage(evalEngine, storage, activeRuleFiles, t, func(s string) { fmt.Println(s) })
+	if err == nil && alerts > 0 {
+		runAlertHooks(storage, t)
+	}
+	return added, alerts, err
 }
 
 // collectRecordingRuleNames parses the files and returns all recording rule names.
@@ -100,8 +111,12 @@ func collectAlertingRules(files []string) []AlertRule {
 		for _, r := range g.Rules {
 			if r.Alert != "" {
 				out = append(out, AlertRule{
-					Name: r.Alert,
-					Expr: r.Expr,
+					Name:        r.Alert,
+					Expr:        r.Expr,
+					For:         time.Duration(r.For),
+					Interval:    time.Duration(g.Interval),
+					Labels:      r.Labels,
+					Annotations: r.Annotations,
 				})
 			}
 		}
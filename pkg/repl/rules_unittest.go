@@ -0,0 +1,325 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql"
+	"go.yaml.in/yaml/v2"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// RuleTestFile is a promtool rule unit-test file (promtool test rules).
+type RuleTestFile struct {
+	RuleFiles          []string        `yaml:"rule_files"`
+	EvaluationInterval model.Duration  `yaml:"evaluation_interval,omitempty"`
+	Tests              []RuleTestGroup `yaml:"tests"`
+}
+
+// RuleTestGroup is one entry of tests: input series plus the expectations checked against them.
+type RuleTestGroup struct {
+	Name            string           `yaml:"name,omitempty"`
+	Interval        model.Duration   `yaml:"interval,omitempty"`
+	InputSeries     []RuleTestSeries `yaml:"input_series"`
+	AlertRuleTests  []AlertRuleTest  `yaml:"alert_rule_test,omitempty"`
+	PromQLExprTests []PromQLExprTest `yaml:"promql_expr_test,omitempty"`
+}
+
+// RuleTestSeries is an input series in expanding notation, e.g. values: '0+10x5 _ stale'.
+type RuleTestSeries struct {
+	Series string `yaml:"series"`
+	Values string `yaml:"values"`
+}
+
+// AlertRuleTest expects the firing alerts of one alerting rule at eval_time.
+type AlertRuleTest struct {
+	EvalTime  model.Duration `yaml:"eval_time"`
+	Alertname string         `yaml:"alertname"`
+	ExpAlerts []ExpAlert     `yaml:"exp_alerts"`
+}
+
+// ExpAlert is an expected alert; alertname is implied by the enclosing test.
+type ExpAlert struct {
+	ExpLabels      map[string]string `yaml:"exp_labels"`
+	ExpAnnotations map[string]string `yaml:"exp_annotations"`
+}
+
+// PromQLExprTest expects the result of a PromQL expression at eval_time.
+type PromQLExprTest struct {
+	Expr       string         `yaml:"expr"`
+	EvalTime   model.Duration `yaml:"eval_time"`
+	ExpSamples []ExpSample    `yaml:"exp_samples"`
+}
+
+// ExpSample is an expected sample: a series in PromQL notation and its value.
+type ExpSample struct {
+	Labels string  `yaml:"labels"`
+	Value  float64 `yaml:"value"`
+}
+
+// RuleTestResult is the outcome of one test group.
+type RuleTestResult struct {
+	Name     string
+	Failures []string // one entry per failed expectation, with a diff
+}
+
+// RunRuleTestFile runs the tests of a promtool rule unit-test file against engine, writing
+// PASS/FAIL lines and diffs to w. Rule files are resolved relative to the test file.
+func RunRuleTestFile(engine *promql.Engine, path string, w io.Writer) ([]RuleTestResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Not strict: the promtool fields not modelled here (group_eval_order, fuzzy_compare,
+	// external_labels, ...) are ignored rather than failing the file
+	var tf RuleTestFile
+	if err := yaml.Unmarshal(data, &tf); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if tf.EvaluationInterval == 0 {
+		tf.EvaluationInterval = model.Duration(defaultRuleInterval)
+	}
+	var files []string
+	for _, rf := range tf.RuleFiles {
+		if !filepath.IsAbs(rf) {
+			rf = filepath.Join(filepath.Dir(path), rf)
+		}
+		matches, err := filepath.Glob(rf)
+		if err != nil {
+			return nil, fmt.Errorf("rule_files %q: %w", rf, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("rule_files %q: no such file", rf)
+		}
+		files = append(files, matches...)
+	}
+	groups, err := loadRuleGroups(files)
+	if err != nil {
+		return nil, err
+	}
+
+	mustFprintf(w, "Unit testing: %s\n", path)
+	results := make([]RuleTestResult, 0, len(tf.Tests))
+	for i, tg := range tf.Tests {
+		name := tg.Name
+		if name == "" {
+			name = fmt.Sprintf("test #%d", i+1)
+		}
+		res := RuleTestResult{Name: name}
+		res.Failures, err = runRuleTestGroup(engine, groups, tg, time.Duration(tf.EvaluationInterval))
+		if err != nil {
+			res.Failures = append(res.Failures, err.Error())
+		}
+		if len(res.Failures) == 0 {
+			mustFprintf(w, "  PASS  %s\n", name)
+		} else {
+			mustFprintf(w, "  FAIL  %s\n", name)
+			for _, f := range res.Failures {
+				mustFprintf(w, "        %s\n", strings.ReplaceAll(f, "\n", "\n        "))
+			}
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// ruleTestAlert is an active alert tracked while replaying alerting rules.
+type ruleTestAlert struct {
+	labels, annotations map[string]string
+	activeAt            time.Duration
+	firing              bool
+}
+
+func runRuleTestGroup(engine *promql.Engine, groups []rulefmt.RuleGroup, tg RuleTestGroup, evalInterval time.Duration) ([]string, error) {
+	interval := time.Duration(tg.Interval)
+	if interval <= 0 {
+		interval = evalInterval
+	}
+	storage := sstorage.NewSimpleStorage()
+	for _, in := range tg.InputSeries {
+		lbls, values, err := promParser.ParseSeriesDesc(in.Series + " " + in.Values)
+		if err != nil {
+			return nil, fmt.Errorf("input series %s: %w", in.Series, err)
+		}
+		m := lbls.Map()
+		for i, v := range values {
+			if v.Omitted {
+				continue
+			}
+			ts := (time.Duration(i) * interval).Milliseconds()
+			if v.Histogram != nil {
+				storage.AddHistogramSample(m, v.Histogram, ts)
+			} else {
+				storage.AddSample(m, v.Value, ts)
+			}
+		}
+	}
+
+	// Replay all rules up to the latest eval_time, snapshotting alerts for each alert test
+	// at the last evaluation not after its eval_time.
+	var maxEval time.Duration
+	for _, at := range tg.AlertRuleTests {
+		maxEval = max(maxEval, time.Duration(at.EvalTime))
+	}
+	for _, et := range tg.PromQLExprTests {
+		maxEval = max(maxEval, time.Duration(et.EvalTime))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replTimeout)
+	defer cancel()
+	queryFn := alertQueryFunc(engine, storage)
+	active := map[string]map[string]*ruleTestAlert{} // alertname -> series key -> alert
+	snapshots := make([][]*ruleTestAlert, len(tg.AlertRuleTests))
+	for ts := time.Duration(0); ts <= maxEval; ts += evalInterval {
+		t := time.UnixMilli(ts.Milliseconds())
+		for _, g := range groups {
+			for _, r := range g.Rules {
+				if r.Record != "" {
+					if _, err := evalRecordingRule(engine, storage, r, t); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				rule := AlertRule{Name: r.Alert, Expr: r.Expr, For: time.Duration(r.For), Labels: r.Labels, Annotations: r.Annotations}
+				vec, err := queryFn(ctx, r.Expr, t)
+				if err != nil {
+					return nil, fmt.Errorf("alerting rule %q: %w", r.Alert, err)
+				}
+				if active[r.Alert] == nil {
+					active[r.Alert] = map[string]*ruleTestAlert{}
+				}
+				seen := map[string]bool{}
+				for _, smpl := range vec {
+					if math.IsNaN(smpl.F) {
+						continue
+					}
+					lbls, annotations := expandAlert(ctx, queryFn, rule, smpl, t)
+					key := formatLabelMap(lbls)
+					seen[key] = true
+					a := active[r.Alert][key]
+					if a == nil {
+						a = &ruleTestAlert{activeAt: ts}
+						active[r.Alert][key] = a
+					}
+					a.labels, a.annotations = lbls, annotations
+					a.firing = ts-a.activeAt >= rule.For
+					state := AlertStatePending
+					if a.firing {
+						state = AlertStateFiring
+					}
+					alertsLabels := maps.Clone(lbls)
+					alertsLabels[labels.MetricName] = "ALERTS"
+					alertsLabels["alertstate"] = state
+					storage.AddSample(alertsLabels, 1, t.UnixMilli())
+				}
+				for key := range active[r.Alert] {
+					if !seen[key] {
+						delete(active[r.Alert], key)
+					}
+				}
+			}
+		}
+		for i, at := range tg.AlertRuleTests {
+			if et := time.Duration(at.EvalTime); et >= ts && et < ts+evalInterval {
+				snapshots[i] = nil
+				for _, a := range active[at.Alertname] {
+					if a.firing {
+						snapshots[i] = append(snapshots[i], a)
+					}
+				}
+			}
+		}
+	}
+
+	var failures []string
+	for i, at := range tg.AlertRuleTests {
+		var exp, got []string
+		for _, ea := range at.ExpAlerts {
+			lbls := map[string]string{}
+			maps.Copy(lbls, ea.ExpLabels)
+			lbls[model.AlertNameLabel] = at.Alertname
+			exp = append(exp, formatTestAlert(lbls, ea.ExpAnnotations))
+		}
+		for _, a := range snapshots[i] {
+			got = append(got, formatTestAlert(a.labels, a.annotations))
+		}
+		if diff := diffSortedLines(exp, got); diff != "" {
+			failures = append(failures, fmt.Sprintf("alertname: %s, time: %s\n%s", at.Alertname, at.EvalTime, diff))
+		}
+	}
+	for _, et := range tg.PromQLExprTests {
+		if diff, err := checkPromQLExprTest(ctx, queryFn, et); err != nil {
+			failures = append(failures, fmt.Sprintf("expr: %q, time: %s\nerror: %v", et.Expr, et.EvalTime, err))
+		} else if diff != "" {
+			failures = append(failures, fmt.Sprintf("expr: %q, time: %s\n%s", et.Expr, et.EvalTime, diff))
+		}
+	}
+	return failures, nil
+}
+
+// checkPromQLExprTest evaluates et and returns a diff against its expected samples.
+func checkPromQLExprTest(ctx context.Context, queryFn func(context.Context, string, time.Time) (promql.Vector, error), et PromQLExprTest) (string, error) {
+	vec, err := queryFn(ctx, et.Expr, time.UnixMilli(time.Duration(et.EvalTime).Milliseconds()))
+	if err != nil {
+		return "", err
+	}
+	var exp, got []string
+	for _, es := range et.ExpSamples {
+		lbls, err := promParser.ParseMetric(es.Labels)
+		if err != nil && es.Labels != "{}" && es.Labels != "" {
+			return "", fmt.Errorf("exp_samples labels %q: %w", es.Labels, err)
+		}
+		exp = append(exp, fmt.Sprintf("%s %s", lbls.String(), formatTestValue(es.Value)))
+	}
+	for _, s := range vec {
+		if s.H != nil {
+			got = append(got, fmt.Sprintf("%s %s", s.Metric.String(), s.H.String()))
+			continue
+		}
+		got = append(got, fmt.Sprintf("%s %s", s.Metric.String(), formatTestValue(s.F)))
+	}
+	return diffSortedLines(exp, got), nil
+}
+
+// formatTestValue rounds a sample value to 9 significant digits so float noise doesn't fail a test.
+func formatTestValue(v float64) string {
+	return fmt.Sprintf("%.9g", v)
+}
+
+func formatTestAlert(lbls, annotations map[string]string) string {
+	return formatLabelMap(lbls) + " annotations=" + formatLabelMap(annotations)
+}
+
+// diffSortedLines compares exp and got as sets of lines, returning "" when equal and a
+// "- expected / + got" diff otherwise.
+func diffSortedLines(exp, got []string) string {
+	sort.Strings(exp)
+	sort.Strings(got)
+	var b strings.Builder
+	i, j := 0, 0
+	for i < len(exp) || j < len(got) {
+		switch {
+		case j >= len(got) || (i < len(exp) && exp[i] < got[j]):
+			b.WriteString("- " + exp[i] + "\n")
+			i++
+		case i >= len(exp) || got[j] < exp[i]:
+			b.WriteString("+ " + got[j] + "\n")
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,89 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunRuleTestFile(t *testing.T) {
+	dir := t.TempDir()
+	rules := `groups:
+- name: test
+  rules:
+  - record: job:errors:rate1m
+    expr: sum by (job) (rate(errors_total[2m]))
+  - alert: ErrorsSustained
+    expr: job:errors:rate1m > 0
+    for: 3m
+    labels:
+      severity: page
+    annotations:
+      summary: '{{ $labels.job }} errors'
+`
+	tests := `rule_files: [rules.yaml]
+evaluation_interval: 1m
+group_eval_order: [test]
+fuzzy_compare: true
+tests:
+- name: passing
+  interval: 1m
+  external_labels: {cluster: prod}
+  input_series:
+  - series: 'errors_total{job="api", instance="a"}'
+    values: '0 0 0 60+60x10'
+  promql_expr_test:
+  - expr: job:errors:rate1m
+    eval_time: 5m
+    exp_samples:
+    - labels: 'job:errors:rate1m{job="api"}'
+      value: 1
+  alert_rule_test:
+  - eval_time: 4m
+    alertname: ErrorsSustained
+    exp_alerts: []
+  - eval_time: 6m30s
+    alertname: ErrorsSustained
+    exp_alerts:
+    - exp_labels: {job: api, severity: page}
+      exp_annotations: {summary: api errors}
+- name: failing
+  input_series:
+  - series: 'errors_total{job="api"}'
+    values: '0+60x10'
+  promql_expr_test:
+  - expr: job:errors:rate1m
+    eval_time: 5m
+    exp_samples:
+    - labels: 'job:errors:rate1m{job="web"}'
+      value: 1
+`
+	if err := os.WriteFile(filepath.Join(dir, "rules.yaml"), []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "rules.test.yaml")
+	if err := os.WriteFile(path, []byte(tests), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	results, err := RunRuleTestFile(newTestEngine(), path, &out)
+	if err != nil {
+		t.Fatalf("RunRuleTestFile: %v", err)
+	}
+	if len(results) != 2 || len(results[0].Failures) != 0 || len(results[1].Failures) != 1 {
+		t.Fatalf("unexpected results %+v\n%s", results, out.String())
+	}
+	for _, want := range []string{
+		"PASS  passing",
+		"FAIL  failing",
+		`- {__name__="job:errors:rate1m", job="web"} 1`,
+		`+ {__name__="job:errors:rate1m", job="api"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package repl
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScrapeAuth holds the HTTP options of scrape requests: extra headers, credentials and
+// TLS. Config file scrape_profiles are applied to the URLs starting with one of their
+// url_prefixes, or to any scrape with profile=<name>.
+type ScrapeAuth struct {
+	URLPrefixes     []string          `yaml:"url_prefixes,omitempty"`
+	Headers         map[string]string `yaml:"headers,omitempty"`
+	BearerToken     secret            `yaml:"bearer_token,omitempty"`
+	BearerTokenFile string            `yaml:"bearer_token_file,omitempty"` // read on every request
+	BearerTokenEnv  string            `yaml:"bearer_token_env,omitempty"`  // environment variable holding the token
+	Username        string            `yaml:"username,omitempty"`
+	Password        secret            `yaml:"password,omitempty"`
+	PasswordFile    string            `yaml:"password_file,omitempty"`
+	CAFile          string            `yaml:"ca_file,omitempty"`
+	CertFile        string            `yaml:"cert_file,omitempty"`
+	KeyFile         string            `yaml:"key_file,omitempty"`
+	ServerName      string            `yaml:"server_name,omitempty"`
+	InsecureSkipTLS bool              `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// secret is a credential, hidden when the config is shown.
+type secret string
+
+func (s secret) MarshalYAML() (any, error) {
+	if s == "" {
+		return "", nil
+	}
+	return "<secret>", nil
+}
+
+// scrapeAuthKeys are the key=value arguments of the scrape commands setting a ScrapeAuth.
+var scrapeAuthKeys = []string{"profile", "header", "bearer_token", "bearer_token_file", "bearer_token_env",
+	"user", "username", "pass", "password", "password_file", "ca_file", "cert_file", "key_file", "server_name", "insecure_skip_verify"}
+
+func isScrapeAuthKey(key string) bool {
+	for _, k := range scrapeAuthKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (a ScrapeAuth) validate() error {
+	if (a.CertFile == "") != (a.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+	if a.BearerToken != "" && a.BearerTokenFile != "" {
+		return errors.New("bearer_token and bearer_token_file are mutually exclusive")
+	}
+	return nil
+}
+
+// scrapeProfileFor returns the config scrape profile of uri: the one with the longest
+// url_prefix it starts with.
+func scrapeProfileFor(uri string) ScrapeAuth {
+	var best ScrapeAuth
+	bestLen := 0
+	names := make([]string, 0, len(activeConfig.ScrapeProfiles))
+	for name := range activeConfig.ScrapeProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, prefix := range activeConfig.ScrapeProfiles[name].URLPrefixes {
+			if prefix != "" && strings.HasPrefix(uri, prefix) && len(prefix) > bestLen {
+				best, bestLen = activeConfig.ScrapeProfiles[name], len(prefix)
+			}
+		}
+	}
+	return best
+}
+
+// parseScrapeAuth returns the ScrapeAuth of a scrape of uri: its config profile (or the
+// one named with profile=), overridden by the key=value args. The other args are returned.
+func parseScrapeAuth(uri string, args []string) (ScrapeAuth, []string, error) {
+	auth := scrapeProfileFor(uri)
+	var rest, options []string
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		key = strings.ToLower(key)
+		switch {
+		case !ok || !isScrapeAuthKey(key):
+			rest = append(rest, arg)
+		case key == "profile":
+			p, found := activeConfig.ScrapeProfiles[value]
+			if !found {
+				return auth, nil, fmt.Errorf("unknown scrape profile %q", value)
+			}
+			auth = p
+		default:
+			options = append(options, arg)
+		}
+	}
+	// Copy the profile headers before adding to them
+	headers := make(map[string]string, len(auth.Headers))
+	for k, v := range auth.Headers {
+		headers[k] = v
+	}
+	auth.Headers = headers
+	for _, opt := range options {
+		key, value, _ := strings.Cut(opt, "=")
+		switch strings.ToLower(key) {
+		case "header":
+			name, hv, found := strings.Cut(value, ":")
+			if !found || strings.TrimSpace(name) == "" {
+				return auth, nil, fmt.Errorf("invalid header %q (expected 'Name: value')", value)
+			}
+			auth.Headers[strings.TrimSpace(name)] = strings.TrimSpace(hv)
+		case "bearer_token":
+			auth.BearerToken, auth.BearerTokenFile, auth.BearerTokenEnv = secret(value), "", ""
+		case "bearer_token_file":
+			auth.BearerToken, auth.BearerTokenFile, auth.BearerTokenEnv = "", value, ""
+		case "bearer_token_env":
+			auth.BearerToken, auth.BearerTokenFile, auth.BearerTokenEnv = "", "", value
+		case "user", "username":
+			auth.Username = value
+		case "pass", "password":
+			auth.Password, auth.PasswordFile = secret(value), ""
+		case "password_file":
+			auth.Password, auth.PasswordFile = "", value
+		case "ca_file":
+			auth.CAFile = value
+		case "cert_file":
+			auth.CertFile = value
+		case "key_file":
+			auth.KeyFile = value
+		case "server_name":
+			auth.ServerName = value
+		case "insecure_skip_verify":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return auth, nil, fmt.Errorf("invalid insecure_skip_verify %q", value)
+			}
+			auth.InsecureSkipTLS = b
+		}
+	}
+	return auth, rest, auth.validate()
+}
+
+// apply sets the headers and credentials on req.
+func (a ScrapeAuth) apply(req *http.Request) error {
+	for k, v := range a.Headers {
+		req.Header.Set(k, v)
+	}
+	token := string(a.BearerToken)
+	switch {
+	case a.BearerTokenFile != "":
+		data, err := os.ReadFile(a.BearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("bearer_token_file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	case a.BearerTokenEnv != "":
+		if token = os.Getenv(a.BearerTokenEnv); token == "" {
+			return fmt.Errorf("bearer_token_env: $%s is not set", a.BearerTokenEnv)
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if a.Username != "" {
+		password := string(a.Password)
+		if a.PasswordFile != "" {
+			data, err := os.ReadFile(a.PasswordFile)
+			if err != nil {
+				return fmt.Errorf("password_file: %w", err)
+			}
+			password = strings.TrimSpace(string(data))
+		}
+		req.SetBasicAuth(a.Username, password)
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS client config, or nil when no TLS option is set.
+func (a ScrapeAuth) tlsConfig() (*tls.Config, error) {
+	if a.CAFile == "" && a.CertFile == "" && a.ServerName == "" && !a.InsecureSkipTLS {
+		return nil, nil
+	}
+	cfg := &tls.Config{ServerName: a.ServerName, InsecureSkipVerify: a.InsecureSkipTLS} //nolint:gosec // requested with insecure_skip_verify
+	if a.CAFile != "" {
+		pem, err := os.ReadFile(a.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ca_file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file: no certificates in %s", a.CAFile)
+		}
+	}
+	if a.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(a.CertFile, a.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// RoundTripper returns an http.RoundTripper applying the options to every request.
+func (a ScrapeAuth) RoundTripper() (http.RoundTripper, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	cfg, err := a.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg != nil {
+		tr.TLSClientConfig = cfg
+	}
+	return scrapeAuthRoundTripper{auth: a, next: tr}, nil
+}
+
+// client returns an http.Client with the given timeout applying the options.
+func (a ScrapeAuth) client(timeout time.Duration) (*http.Client, error) {
+	rt, err := a.RoundTripper()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Timeout: timeout, Transport: rt}, nil
+}
+
+type scrapeAuthRoundTripper struct {
+	auth ScrapeAuth
+	next http.RoundTripper
+}
+
+func (rt scrapeAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if err := rt.auth.apply(req); err != nil {
+		return nil, err
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// splitArgs splits s on spaces outside single or double quotes, removing the quotes, so
+// that header='Name: value' is one argument.
+func splitArgs(s string) []string {
+	var args []string
+	var b strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			b.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, b.String())
+				b.Reset()
+				inArg = false
+			}
+		default:
+			b.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, b.String())
+	}
+	return args
+}
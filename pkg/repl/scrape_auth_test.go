@@ -0,0 +1,162 @@
+package repl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestSplitArgs(t *testing.T) {
+	got := splitArgs(`.scrape http://x '^(a|b)$' header='X-Scope-OrgID: team a' "q w"`)
+	want := []string{".scrape", "http://x", "^(a|b)$", "header=X-Scope-OrgID: team a", "q w"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseScrapeAuth(t *testing.T) {
+	defer func(c *Config) { activeConfig = c }(activeConfig)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(`scrape_profiles:
+  prod: {url_prefixes: ["https://prod"], bearer_token_file: /token, headers: {X-A: "1"}}
+  node: {url_prefixes: ["https://prod:9100"], username: n}
+  other: {ca_file: /ca.pem}
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeConfig = cfg
+
+	auth, rest, err := parseScrapeAuth("https://prod:9090/metrics", []string{"3", "header=X-B: 2", "5s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.BearerTokenFile != "/token" || auth.Headers["X-A"] != "1" || auth.Headers["X-B"] != "2" || !reflect.DeepEqual(rest, []string{"3", "5s"}) {
+		t.Fatalf("unexpected auth %+v, rest %q", auth, rest)
+	}
+	if len(activeConfig.ScrapeProfiles["prod"].Headers) != 1 {
+		t.Fatal("command headers should not change the profile")
+	}
+	// The longest prefix wins, command options override the profile
+	if auth, _, _ = parseScrapeAuth("https://prod:9100/metrics", []string{"user=u"}); auth.Username != "u" || auth.BearerTokenFile != "" {
+		t.Fatalf("unexpected auth %+v", auth)
+	}
+	if auth, _, _ = parseScrapeAuth("https://prod/metrics", []string{"profile=other", "bearer_token=t"}); auth.CAFile != "/ca.pem" || auth.BearerToken != "t" || auth.BearerTokenFile != "" {
+		t.Fatalf("unexpected auth %+v", auth)
+	}
+
+	for _, args := range [][]string{{"profile=nope"}, {"header=nocolon"}, {"cert_file=c.pem"}, {"insecure_skip_verify=maybe"}} {
+		if _, _, err := parseScrapeAuth("http://x", args); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+
+	data, err := yaml.Marshal(ScrapeAuth{BearerToken: "s3cr3t", Password: "pw"})
+	if err != nil || strings.Contains(string(data), "s3cr3t") || strings.Contains(string(data), "pw\n") {
+		t.Fatalf("secrets should be hidden: %s %v", data, err)
+	}
+}
+
+func TestAdhocScrapeAuth(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("tok\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" || r.Header.Get("X-Scope-OrgID") != "team a" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("up 1\n"))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writeClientCert(t, dir)
+	store := sstorage.NewSimpleStorage()
+
+	base := ".scrape " + srv.URL + " ca_file=" + caFile + " bearer_token_file=" + tokenFile + " header='X-Scope-OrgID: team a'"
+	for cmd, want := range map[string]string{
+		".scrape " + srv.URL: "certificate",
+		base:                 "HTTP 403",
+		base + " cert_file=" + certFile + " key_file=" + keyFile: "Scraped " + srv.URL,
+		".scrape " + srv.URL + " insecure_skip_verify=true":      "HTTP 401",
+		".scrape " + srv.URL + " ca_file=" + dir + "/nope.pem":   "ca_file",
+	} {
+		if out := captureStdout(t, func() { _ = handleAdHocFunction(cmd, store) }); !strings.Contains(out, want) {
+			t.Errorf("%s: expected %q in output: %s", cmd, want, out)
+		}
+	}
+	if len(store.Metrics["up"]) != 1 {
+		t.Fatalf("expected one scraped sample, got %d", len(store.Metrics["up"]))
+	}
+}
+
+func TestParsePromScrapeAuthArgs(t *testing.T) {
+	_, q, _, _, _, user, _, _, _, extra, authArgs, err := parsePromScrapeArgs(`http://p/federate '{job="a b"}' user=u header='X-A: b c' honor_labels=true ca_file=ca.pem`)
+	if err != nil || q != `{job="a b"}` || user != "u" || extra["honor_labels"] != "true" {
+		t.Fatalf("unexpected parse: %q %q %v %v", q, user, extra, err)
+	}
+	if want := []string{"header=X-A: b c", "ca_file=ca.pem"}; !reflect.DeepEqual(authArgs, want) {
+		t.Fatalf("got authArgs %q, want %q", authArgs, want)
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key to dir.
+func writeClientCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
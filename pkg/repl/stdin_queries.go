@@ -0,0 +1,70 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/prometheus/prometheus/promql"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+// ExecuteQueriesFromReader runs the lines read from r as the REPL would, e.g. for queries
+// piped into `promql-cli query`: ad-hoc commands, comments and pipes included, a trailing
+// backslash or unclosed brackets continue a query on the next line, and quit stops. There
+// is no prompt nor echo of the lines, so the output only holds results. It returns an
+// error when some PromQL queries failed.
+func ExecuteQueriesFromReader(engine *promql.Engine, storage *sstorage.SimpleStorage, r io.Reader) error {
+	replEngine = engine
+	queries, failed := 0, 0
+	run := func(query string) {
+		if !isReportedQuery(query) || strings.HasPrefix(query, "!") || strings.HasPrefix(query, "#") {
+			executeOne(engine, storage, query)
+			return
+		}
+		queries++
+		lastQueryResult = nil
+		executeOne(engine, storage, query)
+		if lastQueryResult == nil {
+			failed++
+		}
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	var parts []string
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") {
+			if part := strings.TrimSpace(strings.TrimSuffix(line, "\\")); part != "" {
+				parts = append(parts, part)
+			}
+			continue
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			parts = append(parts, line)
+		}
+		query := strings.Join(parts, "\n")
+		if query == "" || needsContinuation(query) {
+			continue
+		}
+		query = joinContinuedLines(parts)
+		parts = nil
+		if query == "quit" || query == ".quit" {
+			break
+		}
+		run(query)
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("reading queries: %w", err)
+	}
+	if len(parts) > 0 {
+		run(joinContinuedLines(parts))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d queries failed", failed, queries)
+	}
+	return nil
+}
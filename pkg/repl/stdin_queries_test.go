@@ -0,0 +1,50 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	sstorage "github.com/jjo/promql-cli/pkg/storage"
+)
+
+func TestExecuteQueriesFromReader(t *testing.T) {
+	store := sstorage.NewSimpleStorage()
+	store.AddSample(map[string]string{"__name__": "up", "job": "a"}, 1, 1700000000000)
+	store.AddSample(map[string]string{"__name__": "up", "job": "b"}, 0, 1700000000000)
+	prevEngine, prevPinned := replEngine, pinnedEvalTime
+	pinned := time.Unix(1700000000, 0)
+	pinnedEvalTime = &pinned
+	defer func() { replEngine, pinnedEvalTime = prevEngine, prevPinned }()
+	defer recordLastQuery("", time.Time{}, nil)
+
+	input := strings.Join([]string{
+		"# comment",
+		".metrics",
+		"sum by (job) (",
+		"  up # all targets",
+		")",
+		"count(up) \\",
+		"  > 1",
+		"quit",
+		"up",
+	}, "\n")
+	var err error
+	out := captureStdout(t, func() { err = ExecuteQueriesFromReader(newTestEngine(), store, strings.NewReader(input)) })
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out)
+	}
+	want := "Metrics (1):\n  - up\n" +
+		"Vector (2 samples):\n  [1] {job=\"a\"} => 1 @ 2023-11-14T22:13:20Z\n  [2] {job=\"b\"} => 0 @ 2023-11-14T22:13:20Z\n" +
+		"Vector (1 samples):\n  [1] {} => 2 @ 2023-11-14T22:13:20Z\n"
+	if out != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out, want)
+	}
+
+	out = captureStdout(t, func() {
+		err = ExecuteQueriesFromReader(newTestEngine(), store, strings.NewReader("sum(up)\nsum(up\n"))
+	})
+	if err == nil || err.Error() != "1 of 2 queries failed" {
+		t.Fatalf("expected a failed query, got %v\n%s", err, out)
+	}
+}
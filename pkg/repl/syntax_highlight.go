@@ -0,0 +1,175 @@
+package repl
+
+import (
+	"errors"
+	"strings"
+
+	promparser "github.com/prometheus/prometheus/promql/parser"
+)
+
+// ANSI styles of the PromQL tokens colored while typing.
+const (
+	syntaxMetric     = "\033[1m"
+	syntaxFunction   = "\033[36m"
+	syntaxKeyword    = "\033[35m"
+	syntaxString     = "\033[32m"
+	syntaxDuration   = "\033[33m"
+	syntaxNumber     = "\033[34m"
+	syntaxComment    = "\033[90m"
+	syntaxMismatched = "\033[1;37;41m"
+	syntaxError      = "\033[4;31m"
+)
+
+// syntaxHighlightEnabled reports whether the query being typed is colored: colors are on
+// and PROMQL_CLI_SYNTAX_HIGHLIGHT is not false.
+func syntaxHighlightEnabled() bool {
+	return colorEnabled() && getEnvBool("PROMQL_CLI_SYNTAX_HIGHLIGHT", true)
+}
+
+// highlightPromQL returns line with ANSI colors for metric names, functions and
+// aggregations, keywords, strings, durations and numbers. Brackets without a match are
+// marked, and so is the parse error position unless it is at the end of line, where the
+// query is most likely still being typed. Ad-hoc commands are returned as is.
+func highlightPromQL(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, ".") {
+		return line
+	}
+	styles := make([]string, len(line))
+	mark := func(pos, end int, style string) {
+		for i := max(pos, 0); i < end && i < len(line); i++ {
+			styles[i] = style
+		}
+	}
+
+	var items []promparser.Item
+	lexer := promparser.Lex(line)
+	for {
+		var it promparser.Item
+		lexer.NextItem(&it)
+		if it.Typ == promparser.EOF {
+			break
+		}
+		if it.Typ == promparser.ERROR {
+			// The lexer stops at an unexpected closing bracket, reported at or right after it
+			if strings.HasPrefix(it.Val, "unexpected right") {
+				if p := strings.LastIndexAny(line[:min(int(it.Pos)+1, len(line))], ")]}"); p >= 0 {
+					mark(p, p+1, syntaxMismatched)
+				}
+			}
+			break
+		}
+		items = append(items, it)
+	}
+
+	// open holds the positions of the brackets not closed yet
+	var open []int
+	braces, labelList := 0, false
+	for i, it := range items {
+		pos, end := int(it.Pos), int(it.Pos)+len(it.Val)
+		switch typ := it.Typ; {
+		case typ == promparser.LEFT_PAREN || typ == promparser.LEFT_BRACE || typ == promparser.LEFT_BRACKET:
+			open = append(open, pos)
+			if typ == promparser.LEFT_BRACE {
+				braces++
+			}
+			if typ == promparser.LEFT_PAREN && i > 0 && isLabelListKeyword(items[i-1].Typ) {
+				labelList = true
+			}
+		case typ == promparser.RIGHT_PAREN || typ == promparser.RIGHT_BRACE || typ == promparser.RIGHT_BRACKET:
+			if n := len(open); n > 0 && matchingBracket(line[open[n-1]]) == line[pos] {
+				open = open[:n-1]
+			} else {
+				mark(pos, end, syntaxMismatched)
+			}
+			if typ == promparser.RIGHT_BRACE {
+				braces--
+			}
+			if typ == promparser.RIGHT_PAREN {
+				labelList = false
+			}
+		case typ == promparser.IDENTIFIER || typ == promparser.METRIC_IDENTIFIER:
+			switch {
+			case braces > 0 || labelList:
+				// label names
+			case i+1 < len(items) && items[i+1].Typ == promparser.LEFT_PAREN:
+				mark(pos, end, syntaxFunction)
+			default:
+				mark(pos, end, syntaxMetric)
+			}
+		case typ.IsAggregator():
+			mark(pos, end, syntaxFunction)
+		case typ.IsKeyword() || typ.IsSetOperator() || typ == promparser.ATAN2:
+			mark(pos, end, syntaxKeyword)
+		case typ == promparser.STRING:
+			mark(pos, end, syntaxString)
+		case typ == promparser.DURATION:
+			mark(pos, end, syntaxDuration)
+		case typ == promparser.NUMBER:
+			mark(pos, end, syntaxNumber)
+		case typ == promparser.COMMENT:
+			mark(pos, end, syntaxComment)
+		}
+	}
+	for _, pos := range open {
+		mark(pos, pos+1, syntaxMismatched)
+	}
+
+	// Only the first error is marked: the parser reports follow-up errors after it
+	var perrs promparser.ParseErrors
+	if _, err := promParser.ParseExpr(line); errors.As(err, &perrs) && len(perrs) > 0 {
+		start, end := int(perrs[0].PositionRange.Start), int(perrs[0].PositionRange.End)
+		for i := start; i < max(end, start+1) && start < len(strings.TrimRight(line, " \t")); i++ {
+			if styles[i] != syntaxMismatched { // already marked
+				styles[i] = syntaxError
+			}
+		}
+	}
+
+	var b strings.Builder
+	current := ""
+	for i := 0; i < len(line); i++ {
+		if styles[i] != current {
+			if current != "" {
+				b.WriteString(highlightReset)
+			}
+			b.WriteString(styles[i])
+			current = styles[i]
+		}
+		b.WriteByte(line[i])
+	}
+	if current != "" {
+		b.WriteString(highlightReset)
+	}
+	return b.String()
+}
+
+// isLabelListKeyword reports whether typ is followed by a parenthesized list of labels.
+func isLabelListKeyword(typ promparser.ItemType) bool {
+	switch typ {
+	case promparser.BY, promparser.WITHOUT, promparser.ON, promparser.IGNORING, promparser.GROUP_LEFT, promparser.GROUP_RIGHT:
+		return true
+	}
+	return false
+}
+
+// matchingBracket returns the closing bracket of the opening bracket c.
+func matchingBracket(c byte) byte {
+	switch c {
+	case '(':
+		return ')'
+	case '{':
+		return '}'
+	}
+	return ']'
+}
+
+// syntaxPainter colors the readline input as highlightPromQL does.
+type syntaxPainter struct{}
+
+func (syntaxPainter) Paint(line []rune, _ int) []rune {
+	if !syntaxHighlightEnabled() {
+		return line
+	}
+	return []rune(highlightPromQL(string(line)))
+}
@@ -0,0 +1,54 @@
+//go:build !noprompt
+
+package repl
+
+import (
+	"strings"
+
+	prompt "github.com/c-bata/go-prompt"
+)
+
+// syntaxInputColor is set as the go-prompt input text color when highlighting, so that
+// syntaxWriter can tell the input line apart from the other text it renders.
+const syntaxInputColor = prompt.Turquoise
+
+// syntaxWriter is a go-prompt ConsoleWriter that writes the input line through
+// highlightPromQL. go-prompt computes the cursor position from the buffer, so the added
+// escape sequences don't move it.
+type syntaxWriter struct {
+	prompt.ConsoleWriter
+	input bool // the next WriteStr is the input line
+}
+
+func (w *syntaxWriter) SetColor(fg, bg prompt.Color, bold bool) {
+	w.input = fg == syntaxInputColor
+	if w.input {
+		fg = prompt.DefaultColor
+	}
+	w.ConsoleWriter.SetColor(fg, bg, bold)
+}
+
+func (w *syntaxWriter) WriteStr(data string) {
+	if !w.input {
+		w.ConsoleWriter.WriteStr(data)
+		return
+	}
+	w.input = false
+	// On Enter the line is rendered once more with a trailing newline
+	line, nl := strings.CutSuffix(data, "\n")
+	w.ConsoleWriter.WriteRaw([]byte(highlightPromQL(line)))
+	if nl {
+		w.ConsoleWriter.WriteStr("\n")
+	}
+}
+
+// syntaxHighlightOptions returns the go-prompt options coloring the input line, if enabled.
+func syntaxHighlightOptions() []prompt.Option {
+	if !syntaxHighlightEnabled() {
+		return nil
+	}
+	return []prompt.Option{
+		prompt.OptionInputTextColor(syntaxInputColor),
+		prompt.OptionWriter(&syntaxWriter{ConsoleWriter: prompt.NewStdoutWriter()}),
+	}
+}
@@ -0,0 +1,72 @@
+package repl
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHighlightPromQL(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		want  []string // styled fragments
+	}{
+		{`sum by (job) (rate(http_requests_total{code="200"}[5m])) > 0.5`, []string{
+			syntaxFunction + "sum" + highlightReset,
+			syntaxKeyword + "by" + highlightReset + " (job)",
+			syntaxFunction + "rate" + highlightReset,
+			syntaxMetric + "http_requests_total" + highlightReset + "{code=",
+			syntaxString + `"200"` + highlightReset,
+			syntaxDuration + "5m" + highlightReset,
+			syntaxNumber + "0.5" + highlightReset,
+		}},
+		{`foo and on(instance) bar offset 1h # note`, []string{
+			syntaxKeyword + "and" + highlightReset,
+			syntaxKeyword + "on" + highlightReset + "(instance)",
+			syntaxMetric + "bar" + highlightReset,
+			syntaxComment + "# note" + highlightReset,
+		}},
+		// Unclosed and unexpected brackets
+		{`sum(rate(foo[5m])`, []string{syntaxMismatched + "(" + highlightReset + syntaxFunction + "rate"}},
+		{`foo)`, []string{syntaxMismatched + ")" + highlightReset}},
+		{`foo]`, []string{syntaxMismatched + "]" + highlightReset}},
+		// Parse errors are marked, unless at the end of the line
+		{`foo +* bar`, []string{syntaxError + "*" + highlightReset}},
+	} {
+		got := highlightPromQL(tc.query)
+		for _, w := range tc.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: expected %q in %q", tc.query, w, got)
+			}
+		}
+		if plain := regexp.MustCompile("\033\\[[0-9;]*m").ReplaceAllString(got, ""); plain != tc.query {
+			t.Errorf("%s: highlighting changed the text to %q", tc.query, plain)
+		}
+	}
+
+	for _, q := range []string{`rate(foo[5m]) +`, `foo{job="a"`} {
+		if got := highlightPromQL(q); strings.Contains(got, syntaxError) {
+			t.Errorf("%s: incomplete query should not be marked as an error: %q", q, got)
+		}
+	}
+	if got := highlightPromQL(".help"); got != ".help" {
+		t.Errorf("ad-hoc commands should not be highlighted: %q", got)
+	}
+}
+
+func TestSyntaxPainter(t *testing.T) {
+	defer func() { colorMode = "auto" }()
+	colorMode = "never"
+	if got := string(syntaxPainter{}.Paint([]rune("up"), 2)); got != "up" {
+		t.Fatalf("colors off: got %q", got)
+	}
+	colorMode = "always"
+	t.Setenv("PROMQL_CLI_SYNTAX_HIGHLIGHT", "false")
+	if got := string(syntaxPainter{}.Paint([]rune("up"), 2)); got != "up" {
+		t.Fatalf("highlighting off: got %q", got)
+	}
+	t.Setenv("PROMQL_CLI_SYNTAX_HIGHLIGHT", "")
+	if got := string(syntaxPainter{}.Paint([]rune("up"), 2)); got != syntaxMetric+"up"+highlightReset {
+		t.Fatalf("got %q", got)
+	}
+}
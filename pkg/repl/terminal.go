@@ -0,0 +1,35 @@
+package repl
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// saveTerminalState returns the current state of the terminal on stdin, or nil when stdin
+// is not a terminal.
+func saveTerminalState() *term.State {
+	st, err := term.GetState(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil
+	}
+	return st
+}
+
+// restoreTerminalState puts the terminal on stdin back into a state from saveTerminalState.
+func restoreTerminalState(st *term.State) {
+	if st == nil {
+		return
+	}
+	_ = term.Restore(int(os.Stdin.Fd()), st)
+}
+
+// terminalSize returns the columns and rows of the terminal on stdout, and false when stdout
+// is not a terminal.
+func terminalSize() (cols, rows int, ok bool) {
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || cols <= 0 || rows <= 0 {
+		return 0, 0, false
+	}
+	return cols, rows, true
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+
+package repl
+
+import (
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultEditor is the editor used when none of PROMQL_EDITOR, VISUAL or EDITOR is set.
+const defaultEditor = "nano"
+
+// shellNotFoundExit is the exit code of the shell when it can't find the command.
+const shellNotFoundExit = 127
+
+// shellCommand returns a command running cmdline with /bin/sh.
+func shellCommand(cmdline string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", cmdline)
+}
+
+// shellQuote safely quotes a string for use in shell commands using POSIX single-quote escaping
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
+}
+
+// drainFD drains any immediately available bytes from the given file descriptor in non-blocking mode.
+func drainFD(fd int) {
+	// Set non-blocking; ignore errors where unsupported
+	_ = unix.SetNonblock(fd, true)
+	defer func() { _ = unix.SetNonblock(fd, false) }()
+	buf := make([]byte, 8192)
+	for {
+		n, _ := unix.Read(fd, buf)
+		if n <= 0 {
+			break
+		}
+		// Continue reading until no more data is immediately available
+	}
+}
+
+// pendingInput reads any input immediately available on fd without blocking, and reports
+// whether there was some.
+func pendingInput(fd int) bool {
+	_ = unix.SetNonblock(fd, true)
+	defer func() { _ = unix.SetNonblock(fd, false) }()
+	buf := make([]byte, 64)
+	n, _ := unix.Read(fd, buf)
+	return n > 0
+}
@@ -0,0 +1,45 @@
+//go:build windows
+
+package repl
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// defaultEditor is the editor used when none of PROMQL_EDITOR, VISUAL or EDITOR is set.
+const defaultEditor = "notepad"
+
+// shellNotFoundExit is the exit code of cmd.exe when it can't find the command.
+const shellNotFoundExit = 9009
+
+// shellCommand returns a command running cmdline with cmd.exe. The command line is passed
+// verbatim, as cmd.exe doesn't follow the quoting rules exec applies to arguments.
+func shellCommand(cmdline string) *exec.Cmd {
+	cmd := exec.Command("cmd.exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd.exe /S /C "` + cmdline + `"`}
+	return cmd
+}
+
+// shellQuote quotes a string for use in cmd.exe command lines, doubling embedded quotes.
+func shellQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// drainFD discards the pending input of the console on fd; other handles are left alone.
+func drainFD(fd int) {
+	_ = windows.FlushConsoleInputBuffer(windows.Handle(fd))
+}
+
+// pendingInput reports whether the console on fd has pending input events, discarding them.
+func pendingInput(fd int) bool {
+	var n uint32
+	if err := windows.GetNumberOfConsoleInputEvents(windows.Handle(fd), &n); err != nil || n == 0 {
+		return false
+	}
+	_ = windows.FlushConsoleInputBuffer(windows.Handle(fd))
+	return true
+}
@@ -0,0 +1,102 @@
+package simple_storage
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// MemStats reports the memory used by the samples of a SimpleStorage.
+type MemStats struct {
+	Metrics, Series, Samples int
+	// LabelSets counts the distinct label maps referenced by samples, at best one per series.
+	LabelSets int
+	// LabelStrings counts the label names and values of those maps, and StringBytes the
+	// bytes of their distinct string data (interned strings count once).
+	LabelStrings int
+	StringBytes  int64
+	// Bytes estimates the memory of samples, label maps and strings.
+	Bytes int64
+}
+
+// Approximate sizes of a map and of one of its string entries, including bucket overhead.
+const (
+	mapOverheadBytes = 48
+	mapEntryBytes    = 2*int64(unsafe.Sizeof("")) + 8
+)
+
+// MemStats returns the memory statistics of the store.
+func (s *SimpleStorage) MemStats() MemStats {
+	st := MemStats{Metrics: len(s.Metrics)}
+	seenMaps := make(map[uintptr]bool)
+	type stringData struct {
+		data *byte
+		n    int
+	}
+	seenStrings := make(map[stringData]bool)
+	addString := func(str string) {
+		if d := (stringData{unsafe.StringData(str), len(str)}); len(str) > 0 && !seenStrings[d] {
+			seenStrings[d] = true
+			st.StringBytes += int64(len(str))
+		}
+	}
+	for _, idx := range s.indexes("") {
+		st.Series += len(idx.series)
+	}
+	for _, samples := range s.Metrics {
+		st.Samples += len(samples)
+		for _, sample := range samples {
+			p := reflect.ValueOf(sample.Labels).Pointer()
+			if seenMaps[p] {
+				continue
+			}
+			seenMaps[p] = true
+			st.LabelSets++
+			st.LabelStrings += 2 * len(sample.Labels)
+			for k, v := range sample.Labels {
+				addString(k)
+				addString(v)
+			}
+		}
+	}
+	st.Bytes = int64(st.Samples)*int64(unsafe.Sizeof(MetricSample{})) +
+		int64(st.LabelSets)*mapOverheadBytes + int64(st.LabelStrings/2)*mapEntryBytes + st.StringBytes
+	return st
+}
+
+// Compact reduces the memory of the store: samples of a series share one label map, and
+// label names and values are interned, so equal strings share their data.
+func (s *SimpleStorage) Compact() {
+	strs := make(map[string]string)
+	intern := func(str string) string {
+		if v, ok := strs[str]; ok {
+			return v
+		}
+		strs[str] = str
+		return str
+	}
+	for _, samples := range s.Metrics {
+		// Series by label map, for the samples already sharing one, and by label set
+		byMap := make(map[uintptr]map[string]string)
+		bySet := make(map[string]map[string]string)
+		for i := range samples {
+			p := reflect.ValueOf(samples[i].Labels).Pointer()
+			shared, ok := byMap[p]
+			if !ok {
+				key := labels.FromMap(samples[i].Labels).String()
+				if shared, ok = bySet[key]; !ok {
+					shared = make(map[string]string, len(samples[i].Labels))
+					for k, v := range samples[i].Labels {
+						shared[intern(k)] = intern(v)
+					}
+					bySet[key] = shared
+				}
+				byMap[p] = shared
+			}
+			samples[i].Labels = shared
+		}
+	}
+	// Label maps changed in place: rebuild the indexes
+	s.InvalidateIndex()
+}
@@ -0,0 +1,36 @@
+package simple_storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	s := NewSimpleStorage()
+	for ts := int64(1); ts <= 3; ts++ {
+		for _, pod := range []string{"a", "b"} {
+			// A fresh label map and strings per sample, as parsers produce
+			s.AddSample(map[string]string{"__name__": "up", "job": strings.Clone("api"), "pod": strings.Clone(pod)}, 1, ts*1000)
+		}
+	}
+	before := s.MemStats()
+	if before.Series != 2 || before.Samples != 6 || before.LabelSets != 6 {
+		t.Fatalf("unexpected stats before compaction: %+v", before)
+	}
+	s.Compact()
+	after := s.MemStats()
+	if after.Series != 2 || after.Samples != 6 || after.LabelSets != 2 || after.LabelStrings != 12 {
+		t.Fatalf("unexpected stats after compaction: %+v", after)
+	}
+	if after.StringBytes >= before.StringBytes || after.Bytes >= before.Bytes {
+		t.Fatalf("compaction didn't save memory: %+v -> %+v", before, after)
+	}
+	samples := s.Metrics["up"]
+	if reflect.ValueOf(samples[0].Labels).Pointer() != reflect.ValueOf(samples[2].Labels).Pointer() {
+		t.Fatal("samples of a series should share their labels")
+	}
+	if got := selectSeries(t, s, 0, 5000); len(got) != 2 || !strings.HasSuffix(got[0], "1000=1 2000=1 3000=1") {
+		t.Fatalf("unexpected series after compaction: %q", got)
+	}
+}
@@ -0,0 +1,257 @@
+package simple_storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ExportFormatJSON is the JSON format supported by Export, next to SaveFormatProm and SaveFormatOpenMetrics.
+const ExportFormatJSON = "json"
+
+// ExportFormats lists the formats supported by Export.
+var ExportFormats = []string{SaveFormatOpenMetrics, SaveFormatProm, ExportFormatJSON}
+
+// setMetricType records the type of a metric family.
+func (s *SimpleStorage) setMetricType(name, typ string) {
+	if s.MetricsType == nil {
+		s.MetricsType = make(map[string]string)
+	}
+	s.MetricsType[name] = typ
+}
+
+// metricTypeName returns the exposition-format name of a parsed metric type.
+func metricTypeName(t dto.MetricType) string {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return "counter"
+	case dto.MetricType_GAUGE:
+		return "gauge"
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		return "histogram"
+	case dto.MetricType_SUMMARY:
+		return "summary"
+	default:
+		return "untyped"
+	}
+}
+
+// exportFamily groups stored metric names (e.g. foo_bucket, foo_sum, foo_count) under their family.
+type exportFamily struct {
+	Name    string
+	Type    string
+	Help    string
+	Metrics []string
+}
+
+// familyOf returns the metric family a stored metric name belongs to.
+func (s *SimpleStorage) familyOf(name string) string {
+	if _, ok := s.MetricsType[name]; ok {
+		return name
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		base, found := strings.CutSuffix(name, suffix)
+		if !found {
+			continue
+		}
+		if typ := s.MetricsType[base]; typ == "histogram" || typ == "summary" {
+			return base
+		}
+	}
+	return name
+}
+
+// MetricType returns the TYPE metadata of a stored metric name, resolving histogram and
+// summary series (foo_bucket, foo_sum, foo_count) to their family. It returns "" when unknown.
+func (s *SimpleStorage) MetricType(name string) string {
+	return s.MetricsType[s.familyOf(name)]
+}
+
+// MetricHelp returns the HELP metadata of a stored metric name, resolving histogram and
+// summary series to their family like MetricType. It returns "" when unknown.
+func (s *SimpleStorage) MetricHelp(name string) string {
+	if help, ok := s.MetricsHelp[name]; ok {
+		return help
+	}
+	return s.MetricsHelp[s.familyOf(name)]
+}
+
+// MetricFamily returns the metric family of a stored metric name: foo for the foo_bucket,
+// foo_sum and foo_count series of a histogram or summary foo, the name itself otherwise.
+func (s *SimpleStorage) MetricFamily(name string) string {
+	return s.familyOf(name)
+}
+
+// exportFamilies returns the metric families in the store, sorted by name.
+func (s *SimpleStorage) exportFamilies() []exportFamily {
+	byName := make(map[string]*exportFamily)
+	for name := range s.Metrics {
+		fam := s.familyOf(name)
+		f, ok := byName[fam]
+		if !ok {
+			typ := s.MetricsType[fam]
+			if typ == "" {
+				typ = "untyped"
+			}
+			f = &exportFamily{Name: fam, Type: typ, Help: s.MetricsHelp[fam]}
+			byName[fam] = f
+		}
+		f.Metrics = append(f.Metrics, name)
+	}
+	families := make([]exportFamily, 0, len(byName))
+	for _, f := range byName {
+		sort.Strings(f.Metrics)
+		families = append(families, *f)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	return families
+}
+
+// sortedSamples returns the samples of a metric ordered by labelset, then timestamp.
+func (s *SimpleStorage) sortedSamples(name string) []MetricSample {
+	type row struct {
+		key    string
+		sample MetricSample
+	}
+	rows := make([]row, 0, len(s.Metrics[name]))
+	for _, sample := range s.Metrics[name] {
+		rows = append(rows, row{key: formatLabelsForLine(sample.Labels), sample: sample})
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].key != rows[j].key {
+			return rows[i].key < rows[j].key
+		}
+		return rows[i].sample.Timestamp < rows[j].sample.Timestamp
+	})
+	out := make([]MetricSample, len(rows))
+	for i, r := range rows {
+		out[i] = r.sample
+	}
+	return out
+}
+
+// Export writes the whole store, including every timestamp of each series and the
+// HELP/TYPE metadata of each metric family, in format (see ExportFormats).
+func (s *SimpleStorage) Export(w io.Writer, format string) error {
+	switch format {
+	case SaveFormatProm:
+		return s.exportText(w, false)
+	case SaveFormatOpenMetrics:
+		return s.exportText(w, true)
+	case ExportFormatJSON:
+		return s.exportJSON(w)
+	default:
+		return fmt.Errorf("unknown export format %q (supported: %s)", format, strings.Join(ExportFormats, ", "))
+	}
+}
+
+func (s *SimpleStorage) exportText(w io.Writer, openMetrics bool) error {
+	for _, f := range s.exportFamilies() {
+		name, typ := f.Name, f.Type
+		if openMetrics {
+			// OpenMetrics names counter families without the _total suffix carried by their samples.
+			switch {
+			case typ == "counter" && strings.HasSuffix(name, "_total"):
+				name = strings.TrimSuffix(name, "_total")
+			case typ == "counter", typ == "untyped":
+				typ = "unknown"
+			}
+		}
+		if f.Help != "" {
+			help := strings.ReplaceAll(f.Help, `\`, `\\`)
+			if openMetrics {
+				help = escapeHelp(f.Help)
+			}
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n", name, help); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", name, typ); err != nil {
+			return err
+		}
+		for _, metric := range f.Metrics {
+			for _, sample := range s.sortedSamples(metric) {
+				// Native histograms have no representation in the text formats (see JSON)
+				if sample.Histogram != nil {
+					continue
+				}
+				labelStr := formatLabelsForLine(sample.Labels)
+				if openMetrics {
+					if _, err := io.WriteString(w, formatOpenMetricsSample(metric, labelStr, sample.Value, sample.Timestamp, true, sample.Exemplar)); err != nil {
+						return err
+					}
+					continue
+				}
+				series := metric
+				if labelStr != "" {
+					series = fmt.Sprintf("%s{%s}", metric, labelStr)
+				}
+				if _, err := fmt.Fprintf(w, "%s %s %d\n", series, formatOpenMetricsFloat(sample.Value), sample.Timestamp); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if openMetrics {
+		if _, err := io.WriteString(w, "# EOF\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type exportJSONSample struct {
+	Timestamp int64     `json:"timestamp"`
+	Value     string    `json:"value,omitempty"`
+	Histogram string    `json:"histogram,omitempty"`
+	Exemplar  *Exemplar `json:"exemplar,omitempty"`
+}
+
+type exportJSONSeries struct {
+	Labels  map[string]string  `json:"labels"`
+	Samples []exportJSONSample `json:"samples"`
+}
+
+type exportJSONFamily struct {
+	Name   string             `json:"name"`
+	Type   string             `json:"type"`
+	Help   string             `json:"help,omitempty"`
+	Series []exportJSONSeries `json:"series"`
+}
+
+// exportJSON writes {"metrics":[{name,type,help,series:[{labels,samples:[{timestamp,value}]}]}]}.
+// Values are strings (as in the Prometheus HTTP API) so NaN and ±Inf survive.
+func (s *SimpleStorage) exportJSON(w io.Writer) error {
+	out := struct {
+		Metrics []exportJSONFamily `json:"metrics"`
+	}{Metrics: []exportJSONFamily{}}
+	for _, f := range s.exportFamilies() {
+		jf := exportJSONFamily{Name: f.Name, Type: f.Type, Help: f.Help, Series: []exportJSONSeries{}}
+		for _, metric := range f.Metrics {
+			var cur *exportJSONSeries
+			curKey := ""
+			for _, sample := range s.sortedSamples(metric) {
+				key := formatLabelsForLine(sample.Labels)
+				if cur == nil || key != curKey {
+					jf.Series = append(jf.Series, exportJSONSeries{Labels: sample.Labels})
+					cur, curKey = &jf.Series[len(jf.Series)-1], key
+				}
+				js := exportJSONSample{Timestamp: sample.Timestamp, Exemplar: sample.Exemplar}
+				if sample.Histogram != nil {
+					js.Histogram = sample.Histogram.String()
+				} else {
+					js.Value = formatOpenMetricsFloat(sample.Value)
+				}
+				cur.Samples = append(cur.Samples, js)
+			}
+		}
+		out.Metrics = append(out.Metrics, jf)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,117 @@
+package simple_storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testExportInput = `# HELP api_requests_total Total API requests.
+# TYPE api_requests_total counter
+api_requests_total{code="200"} 1 1700000000000
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.5"} 3 1700000000000
+latency_seconds_bucket{le="+Inf"} 4 1700000000000
+latency_seconds_sum 1.5 1700000000000
+latency_seconds_count 4 1700000000000
+`
+
+func newExportStore(t *testing.T) *SimpleStorage {
+	t.Helper()
+	s := NewSimpleStorage()
+	if err := s.LoadFromReader(strings.NewReader(testExportInput)); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	// A second timestamp for the counter series
+	s.AddSample(map[string]string{"__name__": "api_requests_total", "code": "200"}, 5, 1700000060000)
+	return s
+}
+
+func TestExport_PromKeepsMetadataAndTimestamps(t *testing.T) {
+	s := newExportStore(t)
+	var buf bytes.Buffer
+	if err := s.Export(&buf, SaveFormatProm); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	want := `# HELP api_requests_total Total API requests.
+# TYPE api_requests_total counter
+api_requests_total{code="200"} 1 1700000000000
+api_requests_total{code="200"} 5 1700000060000
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="+Inf"} 4 1700000000000
+latency_seconds_bucket{le="0.5"} 3 1700000000000
+latency_seconds_count 4 1700000000000
+latency_seconds_sum 1.5 1700000000000
+`
+	if buf.String() != want {
+		t.Fatalf("unexpected export:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	reloaded := NewSimpleStorage()
+	if err := reloaded.LoadFromReader(&buf); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if len(reloaded.Metrics["api_requests_total"]) != 2 || reloaded.MetricsType["latency_seconds"] != "histogram" || reloaded.MetricsHelp["api_requests_total"] != "Total API requests." {
+		t.Fatalf("round trip lost data: %+v %+v", reloaded.Metrics, reloaded.MetricsType)
+	}
+}
+
+func TestExport_OpenMetricsRoundTrip(t *testing.T) {
+	s := newExportStore(t)
+	var buf bytes.Buffer
+	if err := s.Export(&buf, SaveFormatOpenMetrics); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "# TYPE api_requests counter\n") || !strings.Contains(out, "# TYPE latency_seconds histogram\n") || !strings.HasSuffix(out, "# EOF\n") {
+		t.Fatalf("unexpected OpenMetrics export:\n%s", out)
+	}
+	reloaded := NewSimpleStorage()
+	if err := reloaded.LoadFromReader(strings.NewReader(out)); err != nil {
+		t.Fatalf("reload: %v\n%s", err, out)
+	}
+	if len(reloaded.Metrics["api_requests_total"]) != 2 || reloaded.MetricsType["api_requests_total"] != "counter" || len(reloaded.Metrics["latency_seconds_bucket"]) != 2 {
+		t.Fatalf("round trip lost data: %+v %+v", reloaded.Metrics, reloaded.MetricsType)
+	}
+}
+
+func TestExport_JSON(t *testing.T) {
+	s := newExportStore(t)
+	var buf bytes.Buffer
+	if err := s.Export(&buf, ExportFormatJSON); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	var doc struct {
+		Metrics []struct {
+			Name   string
+			Type   string
+			Help   string
+			Series []struct {
+				Labels  map[string]string
+				Samples []struct {
+					Timestamp int64
+					Value     string
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if len(doc.Metrics) != 2 || doc.Metrics[0].Name != "api_requests_total" || doc.Metrics[0].Type != "counter" {
+		t.Fatalf("unexpected families: %+v", doc.Metrics)
+	}
+	series := doc.Metrics[0].Series
+	if len(series) != 1 || len(series[0].Samples) != 2 || series[0].Samples[1].Value != "5" || series[0].Labels["code"] != "200" {
+		t.Fatalf("unexpected series: %+v", series)
+	}
+	if hist := doc.Metrics[1]; hist.Type != "histogram" || len(hist.Series) != 4 {
+		t.Fatalf("unexpected histogram family: %+v", hist)
+	}
+	if err := s.Export(&buf, "yaml"); err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+}
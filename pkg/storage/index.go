@@ -0,0 +1,236 @@
+package simple_storage
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// metricIndex indexes the samples stored under one metric name: the series they belong to,
+// each with its samples sorted by timestamp, and the series of every label name and value.
+type metricIndex struct {
+	// The samples slice the index was built from, to tell when it changed
+	n, firstTS, lastTS int64
+	first              *MetricSample
+
+	series   []indexedSeries             // sorted by labels
+	postings map[string]map[string][]int // label name -> value -> series, in order
+}
+
+// indexedSeries is a series of a metricIndex.
+type indexedSeries struct {
+	labels    labels.Labels
+	labelsMap map[string]string
+	samples   []MetricSample
+}
+
+// indexFor returns the index of samples, reusing idx when it was built from them.
+func indexFor(idx *metricIndex, samples []MetricSample) *metricIndex {
+	if len(samples) == 0 {
+		return nil
+	}
+	n, first, last := int64(len(samples)), &samples[0], samples[len(samples)-1].Timestamp
+	if idx != nil && idx.n == n && idx.first == first && idx.firstTS == first.Timestamp && idx.lastTS == last {
+		return idx
+	}
+	idx = &metricIndex{n: n, first: first, firstTS: first.Timestamp, lastTS: last, postings: map[string]map[string][]int{}}
+
+	byKey := make(map[string]int)
+	for _, sample := range samples {
+		lbls := labels.FromMap(sample.Labels)
+		key := lbls.String()
+		i, ok := byKey[key]
+		if !ok {
+			i = len(idx.series)
+			byKey[key] = i
+			idx.series = append(idx.series, indexedSeries{labels: lbls, labelsMap: sample.Labels})
+		}
+		idx.series[i].samples = append(idx.series[i].samples, sample)
+	}
+	sort.Slice(idx.series, func(i, j int) bool {
+		return labels.Compare(idx.series[i].labels, idx.series[j].labels) < 0
+	})
+	for i := range idx.series {
+		s := &idx.series[i]
+		sort.SliceStable(s.samples, func(a, b int) bool { return s.samples[a].Timestamp < s.samples[b].Timestamp })
+		s.labels.Range(func(l labels.Label) {
+			values := idx.postings[l.Name]
+			if values == nil {
+				values = map[string][]int{}
+				idx.postings[l.Name] = values
+			}
+			values[l.Value] = append(values[l.Value], i)
+		})
+	}
+	return idx
+}
+
+// matching returns the series matching all matchers.
+func (idx *metricIndex) matching(matchers []*labels.Matcher) []*indexedSeries {
+	// Narrow down with the postings of the most selective equality matcher
+	var candidates []int
+	narrowed := false
+	for _, m := range matchers {
+		if m.Type != labels.MatchEqual || m.Value == "" {
+			continue
+		}
+		p := idx.postings[m.Name][m.Value]
+		if !narrowed || len(p) < len(candidates) {
+			candidates, narrowed = p, true
+		}
+	}
+	var result []*indexedSeries
+	check := func(i int) {
+		if s := &idx.series[i]; matchLabels(s.labelsMap, matchers) {
+			result = append(result, s)
+		}
+	}
+	if narrowed {
+		for _, i := range candidates {
+			check(i)
+		}
+	} else {
+		for i := range idx.series {
+			check(i)
+		}
+	}
+	return result
+}
+
+// matchLabels checks if labels match the given matchers, a missing label matching as "".
+func matchLabels(lbls map[string]string, matchers []*labels.Matcher) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(lbls[matcher.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// metricNameOf returns the metric name of an equality matcher on __name__, or "".
+func metricNameOf(matchers []*labels.Matcher) string {
+	for _, m := range matchers {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			return m.Value
+		}
+	}
+	return ""
+}
+
+// indexes returns the up-to-date indexes of the metric name, or of all metrics when name
+// is "". Indexes are built on first use and rebuilt when the samples of their metric change.
+func (s *SimpleStorage) indexes(name string) []*metricIndex {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if s.index == nil {
+		s.index = map[string]*metricIndex{}
+	}
+	refresh := func(name string) *metricIndex {
+		idx := indexFor(s.index[name], s.Metrics[name])
+		if idx == nil {
+			delete(s.index, name)
+		} else {
+			s.index[name] = idx
+		}
+		return idx
+	}
+	if name != "" {
+		if idx := refresh(name); idx != nil {
+			return []*metricIndex{idx}
+		}
+		return nil
+	}
+	result := make([]*metricIndex, 0, len(s.Metrics))
+	for name := range s.Metrics {
+		if idx := refresh(name); idx != nil {
+			result = append(result, idx)
+		}
+	}
+	// Forget the indexes of deleted metrics
+	for name := range s.index {
+		if _, ok := s.Metrics[name]; !ok {
+			delete(s.index, name)
+		}
+	}
+	return result
+}
+
+// InvalidateIndex drops the label indexes, to rebuild them on next use. Indexes notice
+// samples added to or removed from a metric; call it after modifying samples in place.
+func (s *SimpleStorage) InvalidateIndex() {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	s.index = nil
+}
+
+// LabelNamesOf returns the sorted label names, without __name__, of the metric name, or
+// of all metrics when name is "".
+func (s *SimpleStorage) LabelNamesOf(name string) []string {
+	var names []string
+	for _, idx := range s.indexes(name) {
+		for n := range idx.postings {
+			if n != labels.MetricName {
+				names = append(names, n)
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// LabelValuesOf returns the sorted values of the label of the metric name, or of all
+// metrics when name is "".
+func (s *SimpleStorage) LabelValuesOf(name, label string) []string {
+	var values []string
+	for _, idx := range s.indexes(name) {
+		for v := range idx.postings[label] {
+			values = append(values, v)
+		}
+	}
+	slices.Sort(values)
+	return slices.Compact(values)
+}
+
+// sampleRange returns the samples, sorted by timestamp, between mint and maxt included.
+func sampleRange(samples []MetricSample, mint, maxt int64) []MetricSample {
+	lo := sort.Search(len(samples), func(i int) bool { return samples[i].Timestamp >= mint })
+	hi := sort.Search(len(samples), func(i int) bool { return samples[i].Timestamp > maxt })
+	if lo >= hi {
+		return nil
+	}
+	return samples[lo:hi:hi]
+}
+
+// EstimateSeries returns an upper bound of the series matching matchers, from the label
+// indexes without matching every series: the series of the metrics whose name matches,
+// narrowed by the postings of their most selective equality matcher.
+func (s *SimpleStorage) EstimateSeries(matchers []*labels.Matcher) int {
+	total, byName := 0, nameMatchers(matchers)
+	for name := range s.Metrics {
+		if !matchLabels(map[string]string{labels.MetricName: name}, byName) {
+			continue
+		}
+		for _, idx := range s.indexes(name) {
+			n := len(idx.series)
+			for _, m := range matchers {
+				if m.Type == labels.MatchEqual && m.Value != "" && m.Name != labels.MetricName {
+					n = min(n, len(idx.postings[m.Name][m.Value]))
+				}
+			}
+			total += n
+		}
+	}
+	return total
+}
+
+// nameMatchers returns the matchers on __name__.
+func nameMatchers(matchers []*labels.Matcher) []*labels.Matcher {
+	var result []*labels.Matcher
+	for _, m := range matchers {
+		if m.Name == labels.MetricName {
+			result = append(result, m)
+		}
+	}
+	return result
+}
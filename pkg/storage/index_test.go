@@ -0,0 +1,165 @@
+package simple_storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// selectSeries returns the selected series as "labels: t=v ..." lines.
+func selectSeries(t *testing.T, s *SimpleStorage, mint, maxt int64, matchers ...*labels.Matcher) []string {
+	t.Helper()
+	q, err := s.Querier(mint, maxt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for set := q.Select(context.Background(), true, nil, matchers...); set.Next(); {
+		line := set.At().Labels().String() + ":"
+		it := set.At().Iterator(nil)
+		for it.Next() != chunkenc.ValNone {
+			ts, v := it.At()
+			line += fmt.Sprintf(" %d=%g", ts, v)
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func TestSelectWithIndex(t *testing.T) {
+	s := NewSimpleStorage()
+	// Out of order, interleaved series
+	for _, ts := range []int64{3000, 1000, 2000} {
+		s.AddSample(map[string]string{"__name__": "up", "job": "api", "pod": "a"}, float64(ts), ts)
+		s.AddSample(map[string]string{"__name__": "up", "job": "db"}, 1, ts)
+		s.AddSample(map[string]string{"__name__": "load", "job": "api"}, 2, ts)
+	}
+	eq := func(name, value string) *labels.Matcher { return labels.MustNewMatcher(labels.MatchEqual, name, value) }
+
+	for _, tc := range []struct {
+		matchers   []*labels.Matcher
+		mint, maxt int64
+		want       []string
+	}{
+		{[]*labels.Matcher{eq("__name__", "up"), eq("job", "api")}, 0, 5000, []string{`{__name__="up", job="api", pod="a"}: 1000=1000 2000=2000 3000=3000`}},
+		{[]*labels.Matcher{eq("__name__", "up"), eq("pod", "")}, 1500, 2500, []string{`{__name__="up", job="db"}: 2000=1`}},
+		{[]*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "job", "api")}, 3000, 3000, []string{
+			`{__name__="load", job="api"}: 3000=2`,
+			`{__name__="up", job="api", pod="a"}: 3000=3000`,
+		}},
+		{[]*labels.Matcher{eq("__name__", "up")}, 4000, 5000, nil},
+		{[]*labels.Matcher{eq("__name__", "nope")}, 0, 5000, nil},
+	} {
+		if got := selectSeries(t, s, tc.mint, tc.maxt, tc.matchers...); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v [%d,%d]: got %q, want %q", tc.matchers, tc.mint, tc.maxt, got, tc.want)
+		}
+	}
+
+	// The index follows added samples, and in-place changes after InvalidateIndex
+	s.AddSample(map[string]string{"__name__": "up", "job": "web"}, 1, 1000)
+	if got := selectSeries(t, s, 0, 5000, eq("job", "web")); len(got) != 1 {
+		t.Fatalf("added sample not selected: %q", got)
+	}
+	for i := range s.Metrics["load"] {
+		s.Metrics["load"][i].Timestamp += 10000
+	}
+	s.InvalidateIndex()
+	if got := selectSeries(t, s, 11000, 11000, eq("__name__", "load")); len(got) != 1 {
+		t.Fatalf("changed timestamps not seen: %q", got)
+	}
+	delete(s.Metrics, "load")
+	if got := selectSeries(t, s, 0, 20000, eq("job", "api")); len(got) != 1 || !strings.HasPrefix(got[0], `{__name__="up"`) {
+		t.Fatalf("deleted metric still selected: %q", got)
+	}
+
+	if got := s.LabelNamesOf(""); !reflect.DeepEqual(got, []string{"job", "pod"}) {
+		t.Errorf("LabelNamesOf: got %q", got)
+	}
+	if got := s.LabelValuesOf("up", "job"); !reflect.DeepEqual(got, []string{"api", "db", "web"}) {
+		t.Errorf("LabelValuesOf: got %q", got)
+	}
+	q, _ := s.Querier(0, 5000)
+	if got, _, _ := q.LabelValues(context.Background(), "job", nil, eq("pod", "a")); !reflect.DeepEqual(got, []string{"api"}) {
+		t.Errorf("LabelValues: got %q", got)
+	}
+}
+
+func TestEstimateSeries(t *testing.T) {
+	s := NewSimpleStorage()
+	for i := range 10 {
+		s.AddSample(map[string]string{"__name__": "up", "job": "api", "pod": fmt.Sprint(i)}, 1, 1000)
+		s.AddSample(map[string]string{"__name__": "up", "job": "db", "pod": fmt.Sprint(i)}, 1, 1000)
+	}
+	s.AddSample(map[string]string{"__name__": "load", "job": "api"}, 2, 1000)
+	re := func(name, value string) *labels.Matcher {
+		return labels.MustNewMatcher(labels.MatchRegexp, name, value)
+	}
+	eq := func(name, value string) *labels.Matcher { return labels.MustNewMatcher(labels.MatchEqual, name, value) }
+
+	for _, tc := range []struct {
+		matchers []*labels.Matcher
+		want     int
+	}{
+		{[]*labels.Matcher{re("__name__", ".+")}, 21},
+		{[]*labels.Matcher{eq("__name__", "up")}, 20},
+		{[]*labels.Matcher{eq("__name__", "up"), eq("job", "db")}, 10},
+		{[]*labels.Matcher{eq("job", "api"), eq("pod", "3")}, 2}, // an upper bound: pod="3" alone
+		{[]*labels.Matcher{re("__name__", "lo.*"), eq("job", "api")}, 1},
+		{[]*labels.Matcher{eq("__name__", "nope")}, 0},
+	} {
+		if got := s.EstimateSeries(tc.matchers); got != tc.want {
+			t.Errorf("%v: got %d, want %d", tc.matchers, got, tc.want)
+		}
+	}
+}
+
+func TestSimpleIteratorSeek(t *testing.T) {
+	it := &SimpleIterator{samples: []MetricSample{{Timestamp: 10}, {Timestamp: 20}, {Timestamp: 30}}, index: -1}
+	for _, tc := range []struct {
+		seek, want int64
+	}{{15, 20}, {5, 20}, {30, 30}} {
+		if it.Seek(tc.seek) == chunkenc.ValNone || it.AtT() != tc.want {
+			t.Fatalf("Seek(%d): got %d, want %d", tc.seek, it.AtT(), tc.want)
+		}
+	}
+	if it.Seek(31) != chunkenc.ValNone {
+		t.Fatal("Seek past the end should return ValNone")
+	}
+}
+
+func TestSummarySumCountNames(t *testing.T) {
+	s := NewSimpleStorage()
+	if err := s.LoadFromReader(strings.NewReader("# TYPE rpc summary\nrpc{quantile=\"0.5\"} 1\nrpc_sum 10\nrpc_count 3\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"rpc_sum", "rpc_count"} {
+		if got := selectSeries(t, s, 0, 1<<62, labels.MustNewMatcher(labels.MatchEqual, "__name__", name)); len(got) != 1 {
+			t.Errorf("%s: got %q", name, got)
+		}
+	}
+}
+
+func BenchmarkSelect(b *testing.B) {
+	s := NewSimpleStorage()
+	for m := 0; m < 100; m++ {
+		for pod := 0; pod < 100; pod++ {
+			for ts := int64(0); ts < 100; ts++ {
+				s.AddSample(map[string]string{"__name__": fmt.Sprintf("metric_%d", m), "pod": fmt.Sprintf("p%d", pod)}, 1, ts*1000)
+			}
+		}
+	}
+	q, _ := s.Querier(50000, 60000)
+	matchers := []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, "__name__", "metric_42"),
+		labels.MustNewMatcher(labels.MatchEqual, "pod", "p7"),
+	}
+	for b.Loop() {
+		for set := q.Select(context.Background(), false, nil, matchers...); set.Next(); {
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package simple_storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxOptions configures LoadInfluxLineProtocol.
+type InfluxOptions struct {
+	// Filter, when set, keeps only the lines whose measurement it accepts.
+	Filter func(measurement string) bool
+	// Precision is the unit of the line timestamps (default time.Nanosecond, as in InfluxDB).
+	Precision time.Duration
+}
+
+// InfluxStats reports what LoadInfluxLineProtocol stored.
+type InfluxStats struct {
+	Lines   int64
+	Samples int64
+	// Filtered counts lines rejected by InfluxOptions.Filter.
+	Filtered int64
+	// Skipped counts string fields, which have no numeric value.
+	Skipped int64
+}
+
+// influxField is one field of a line; strings are not numeric and are not stored.
+type influxField struct {
+	Key     string
+	Value   float64
+	Numeric bool
+}
+
+// LoadInfluxLineProtocol loads InfluxDB line protocol ("measurement,tag=v field=1 <ts>").
+// Each numeric field becomes a sample of the metric <measurement>_<field> (or <measurement>
+// for a field named "value", as Telegraf does), labeled with the line tags; names are
+// sanitized to Prometheus metric and label names. Integers, unsigned integers and booleans
+// (1 or 0) are stored as floats. Lines without a timestamp get the load time.
+func (s *SimpleStorage) LoadInfluxLineProtocol(r io.Reader, opts InfluxOptions) (InfluxStats, error) {
+	var st InfluxStats
+	precision := opts.Precision
+	if precision <= 0 {
+		precision = time.Nanosecond
+	}
+	now := time.Now().UnixMilli()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), maxStreamLineBytes)
+	for sc.Scan() {
+		st.Lines++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		measurement, tags, fields, ts, err := parseInfluxLine(line)
+		if err != nil {
+			return st, fmt.Errorf("line %d: %w", st.Lines, err)
+		}
+		if opts.Filter != nil && !opts.Filter(measurement) {
+			st.Filtered++
+			continue
+		}
+		tsMillis := now
+		if ts != nil {
+			tsMillis = (time.Duration(*ts) * precision).Milliseconds()
+		}
+		base := sanitizeInfluxName(measurement, true)
+		lbls := make(map[string]string, len(tags)+1)
+		for k, v := range tags {
+			lbls[sanitizeInfluxName(k, false)] = v
+		}
+		for _, f := range fields {
+			if !f.Numeric {
+				st.Skipped++
+				continue
+			}
+			name := base
+			if f.Key != "value" {
+				name += "_" + sanitizeInfluxName(f.Key, true)
+			}
+			lbls["__name__"] = name
+			s.AddSample(lbls, f.Value, tsMillis)
+			st.Samples++
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return st, fmt.Errorf("failed to read line protocol: %w", err)
+	}
+	return st, nil
+}
+
+// parseInfluxLine splits a line into its measurement, tags, fields and optional timestamp.
+func parseInfluxLine(line string) (string, map[string]string, []influxField, *int64, error) {
+	sections := splitInfluxUnescaped(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return "", nil, nil, nil, errors.New("expected <measurement>[,<tags>] <fields> [<timestamp>]")
+	}
+	keys := splitInfluxUnescaped(sections[0], ',', false)
+	measurement := unescapeInflux(keys[0])
+	if measurement == "" {
+		return "", nil, nil, nil, errors.New("missing measurement")
+	}
+	tags := make(map[string]string, len(keys)-1)
+	for _, kv := range keys[1:] {
+		k, v, ok := cutInfluxUnescaped(kv, '=')
+		if !ok || k == "" {
+			return "", nil, nil, nil, fmt.Errorf("invalid tag %q", kv)
+		}
+		tags[unescapeInflux(k)] = unescapeInflux(v)
+	}
+	var fields []influxField
+	for _, kv := range splitInfluxUnescaped(sections[1], ',', true) {
+		k, v, ok := cutInfluxUnescaped(kv, '=')
+		if !ok || k == "" || v == "" {
+			return "", nil, nil, nil, fmt.Errorf("invalid field %q", kv)
+		}
+		f, err := parseInfluxFieldValue(v)
+		if err != nil {
+			return "", nil, nil, nil, fmt.Errorf("field %s: %w", unescapeInflux(k), err)
+		}
+		f.Key = unescapeInflux(k)
+		fields = append(fields, f)
+	}
+	if len(sections) == 2 {
+		return measurement, tags, fields, nil, nil
+	}
+	ts, err := strconv.ParseInt(sections[2], 10, 64)
+	if err != nil {
+		return "", nil, nil, nil, fmt.Errorf("invalid timestamp %q", sections[2])
+	}
+	return measurement, tags, fields, &ts, nil
+}
+
+// parseInfluxFieldValue parses a float, integer (123i), unsigned (123u), boolean or
+// double-quoted string field value.
+func parseInfluxFieldValue(v string) (influxField, error) {
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return influxField{Value: 1, Numeric: true}, nil
+	case "f", "F", "false", "False", "FALSE":
+		return influxField{Value: 0, Numeric: true}, nil
+	}
+	if v[0] == '"' {
+		if len(v) < 2 || v[len(v)-1] != '"' {
+			return influxField{}, fmt.Errorf("unterminated string %s", v)
+		}
+		return influxField{}, nil
+	}
+	switch v[len(v)-1] {
+	case 'i':
+		n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return influxField{}, fmt.Errorf("invalid integer %q", v)
+		}
+		return influxField{Value: float64(n), Numeric: true}, nil
+	case 'u':
+		n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return influxField{}, fmt.Errorf("invalid unsigned integer %q", v)
+		}
+		return influxField{Value: float64(n), Numeric: true}, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return influxField{}, fmt.Errorf("invalid value %q", v)
+	}
+	return influxField{Value: f, Numeric: true}, nil
+}
+
+// splitInfluxUnescaped splits s at sep characters not preceded by a backslash and, when
+// quotes is set (field sets), not inside a double-quoted string.
+func splitInfluxUnescaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	start, inQuote := 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"' && quotes:
+			inQuote = !inQuote
+		case c == sep && !inQuote:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cutInfluxUnescaped is strings.Cut at the first sep not preceded by a backslash.
+func cutInfluxUnescaped(s string, sep byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// unescapeInflux removes the backslashes escaping commas, equal signs and spaces.
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ").Replace(s)
+}
+
+// sanitizeInfluxName makes s a valid Prometheus metric (metric set) or label name: other
+// characters than letters, digits, underscores and, for metrics, colons become underscores,
+// and a leading digit gets an underscore prefix.
+func sanitizeInfluxName(s string, metric bool) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == ':' && metric) {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
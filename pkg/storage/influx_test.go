@@ -0,0 +1,52 @@
+package simple_storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testInfluxLines = `# comment
+cpu,host=web-1,cpu=cpu0 usage_user=12.5,usage_system=3i 1700000000000000000
+cpu,host=web\ 2,cpu=cpu0 usage_user=7,note="busy, very" 1700000060000000000
+disk-io,host=web-1,dev\,x=sda value=1u,ok=t
+`
+
+func TestLoadInfluxLineProtocol(t *testing.T) {
+	s := NewSimpleStorage()
+	st, err := s.LoadInfluxLineProtocol(strings.NewReader(testInfluxLines), InfluxOptions{})
+	if err != nil {
+		t.Fatalf("LoadInfluxLineProtocol: %v", err)
+	}
+	if st.Samples != 5 || st.Skipped != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	user := s.Metrics["cpu_usage_user"]
+	if len(user) != 2 || user[0].Value != 12.5 || user[0].Timestamp != 1700000000000 ||
+		user[1].Labels["host"] != "web 2" || user[1].Labels["cpu"] != "cpu0" {
+		t.Fatalf("unexpected cpu_usage_user: %+v", user)
+	}
+	if sys := s.Metrics["cpu_usage_system"]; len(sys) != 1 || sys[0].Value != 3 {
+		t.Fatalf("unexpected cpu_usage_system: %+v", sys)
+	}
+	disk := s.Metrics["disk_io"]
+	if len(disk) != 1 || disk[0].Value != 1 || disk[0].Labels["dev_x"] != "sda" {
+		t.Fatalf("expected value field stored as the measurement: %+v", s.Metrics)
+	}
+	if ok := s.Metrics["disk_io_ok"]; len(ok) != 1 || ok[0].Value != 1 {
+		t.Fatalf("expected boolean field as 1: %+v", ok)
+	}
+
+	filtered := NewSimpleStorage()
+	st, err = filtered.LoadInfluxLineProtocol(strings.NewReader("cpu v=1 1700000000\nmem v=2 1700000000\n"),
+		InfluxOptions{Filter: func(m string) bool { return m == "mem" }, Precision: time.Second})
+	if err != nil || st.Filtered != 1 || len(filtered.Metrics) != 1 || filtered.Metrics["mem_v"][0].Timestamp != 1700000000000 {
+		t.Fatalf("unexpected filtered load: %+v, %v, %+v", st, err, filtered.Metrics)
+	}
+
+	for _, bad := range []string{"cpu", "cpu usage=abc", "cpu,host usage=1", "cpu usage=1 soon"} {
+		if _, err := NewSimpleStorage().LoadInfluxLineProtocol(strings.NewReader(bad), InfluxOptions{}); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
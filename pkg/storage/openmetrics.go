@@ -0,0 +1,201 @@
+package simple_storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+)
+
+// Save formats supported by SaveOptions.Format.
+const (
+	SaveFormatProm        = "prom"
+	SaveFormatOpenMetrics = "openmetrics"
+)
+
+// Exemplar is an OpenMetrics exemplar attached to a sample (e.g. a trace ID).
+// Exemplars are kept so they round-trip through .save in OpenMetrics format.
+type Exemplar struct {
+	Labels       map[string]string `json:"labels"`
+	Value        float64           `json:"value"`
+	Timestamp    int64             `json:"timestamp,omitempty"`
+	HasTimestamp bool              `json:"-"`
+}
+
+// isOpenMetrics reports whether data looks like OpenMetrics text, which must end with "# EOF".
+func isOpenMetrics(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "# EOF" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOpenMetrics loads OpenMetrics text exposition, including exemplars. The _created
+// lines of counters, histograms and summaries are not stored as series: their value is
+// kept as the Created timestamp of the family's samples. Only series whose metric family
+// name passes filter are kept (nil keeps all).
+func (s *SimpleStorage) parseOpenMetrics(data []byte, filter func(name string) bool) error {
+	// Content after "# EOF" is not part of the exposition; ensure the terminating newline.
+	if i := bytes.Index(data, []byte("# EOF")); i >= 0 {
+		data = append(data[:i:i], "# EOF\n"...)
+	}
+	return s.loadFromParser(textparse.NewOpenMetricsParser(data, labels.NewSymbolTable(), textparse.WithOMParserSTSeriesSkipped()), filter)
+}
+
+// loadFromParser stores every sample produced by a Prometheus textparse parser (OpenMetrics
+// or protobuf exposition), including exemplars, native histograms and HELP/TYPE metadata.
+// Only series whose metric family name passes filter are kept (nil keeps all).
+func (s *SimpleStorage) loadFromParser(p textparse.Parser, filter func(name string) bool) error {
+	baseTimestamp := time.Now().UnixMilli()
+	var (
+		family string
+		help   = map[string]string{}
+		types  = map[string]model.MetricType{}
+	)
+	for {
+		entry, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse metrics: %w", err)
+		}
+		switch entry {
+		case textparse.EntryType:
+			name, typ := p.Type()
+			family = string(name)
+			types[family] = typ
+		case textparse.EntryHelp:
+			name, text := p.Help()
+			help[string(name)] = strings.TrimSpace(strings.ReplaceAll(string(text), "\n", " "))
+		case textparse.EntrySeries, textparse.EntryHistogram:
+			var (
+				ts    *int64
+				value float64
+				fh    *histogram.FloatHistogram
+			)
+			if entry == textparse.EntrySeries {
+				_, ts, value = p.Series()
+			} else {
+				var h *histogram.Histogram
+				_, ts, h, fh = p.Histogram()
+				if h != nil {
+					fh = h.ToFloat(nil)
+				}
+			}
+			var lset labels.Labels
+			p.Labels(&lset)
+			name := lset.Get(labels.MetricName)
+			familyName := name
+			if family != "" && strings.HasPrefix(name, family) {
+				familyName = family
+			}
+			if filter != nil && !filter(familyName) {
+				continue
+			}
+			timestamp := baseTimestamp
+			if ts != nil {
+				timestamp = *ts
+			}
+			sample := MetricSample{Labels: lset.Map(), Value: value, Timestamp: timestamp, Histogram: fh, Created: p.StartTimestamp()}
+			var ex exemplar.Exemplar
+			if p.Exemplar(&ex) {
+				sample.Exemplar = &Exemplar{Labels: ex.Labels.Map(), Value: ex.Value, Timestamp: ex.Ts, HasTimestamp: ex.HasTs}
+			}
+			s.Metrics[name] = append(s.Metrics[name], sample)
+		default:
+			// Units and comments are not stored.
+		}
+	}
+
+	// Keep HELP and TYPE under the stored metric names, as the classic loader does (counters carry _total).
+	for name, typ := range types {
+		switch typ {
+		case model.MetricTypeCounter:
+			total := name
+			if !strings.HasSuffix(name, "_total") {
+				total = name + "_total"
+				if text := help[name]; text != "" {
+					help[total] = text
+				}
+			}
+			s.setMetricType(total, string(typ))
+		case model.MetricTypeUnknown:
+			s.setMetricType(name, "untyped")
+		default:
+			s.setMetricType(name, string(typ))
+		}
+	}
+	for name, text := range help {
+		if text != "" {
+			s.MetricsHelp[name] = text
+		}
+	}
+	return nil
+}
+
+// writeOpenMetricsFamily writes the metadata lines for one metric in OpenMetrics format.
+// The stored data carries no type information, so families are written as "unknown".
+func (s *SimpleStorage) writeOpenMetricsFamily(w io.Writer, name string) error {
+	if help, ok := s.MetricsHelp[name]; ok && help != "" {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help)); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "# TYPE %s unknown\n", name)
+	return err
+}
+
+// formatOpenMetricsSample renders a sample line, with optional timestamp (in seconds) and exemplar.
+func formatOpenMetricsSample(name, labelStr string, value float64, ts int64, writeTimestamp bool, ex *Exemplar) string {
+	var b strings.Builder
+	b.WriteString(name)
+	if labelStr != "" {
+		b.WriteString("{" + labelStr + "}")
+	}
+	b.WriteString(" " + formatOpenMetricsFloat(value))
+	if writeTimestamp {
+		b.WriteString(" " + formatOpenMetricsTimestamp(ts))
+	}
+	if ex != nil {
+		b.WriteString(" # {" + formatLabelsForLine(ex.Labels) + "} " + formatOpenMetricsFloat(ex.Value))
+		if ex.HasTimestamp {
+			b.WriteString(" " + formatOpenMetricsTimestamp(ex.Timestamp))
+		}
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+func formatOpenMetricsFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatOpenMetricsTimestamp converts milliseconds to OpenMetrics seconds.
+func formatOpenMetricsTimestamp(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,103 @@
+package simple_storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testOpenMetrics = `# HELP http_requests Total HTTP requests.
+# TYPE http_requests counter
+http_requests_total{code="200"} 10 1700000000.000 # {trace_id="abc123"} 1 1699999999.5
+http_requests_total{code="200"} 12 1700000060
+# TYPE temperature_celsius gauge
+# UNIT temperature_celsius celsius
+temperature_celsius{room="lab"} 21.5 1700000000
+# EOF
+`
+
+func TestLoadFromReader_OpenMetrics(t *testing.T) {
+	s := NewSimpleStorage()
+	if err := s.LoadFromReader(strings.NewReader(testOpenMetrics)); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	reqs := s.Metrics["http_requests_total"]
+	if len(reqs) != 2 || reqs[0].Timestamp != 1700000000000 || reqs[1].Value != 12 {
+		t.Fatalf("unexpected samples: %+v", reqs)
+	}
+	ex := reqs[0].Exemplar
+	if ex == nil || ex.Labels["trace_id"] != "abc123" || ex.Value != 1 || !ex.HasTimestamp || ex.Timestamp != 1699999999500 {
+		t.Fatalf("unexpected exemplar: %+v", ex)
+	}
+	if got := s.MetricsHelp["http_requests_total"]; got != "Total HTTP requests." {
+		t.Fatalf("unexpected help: %q", got)
+	}
+	if len(s.Metrics["temperature_celsius"]) != 1 {
+		t.Fatalf("expected temperature_celsius sample, got %+v", s.Metrics)
+	}
+
+	filtered := NewSimpleStorage()
+	if err := filtered.LoadFromReaderWithFilter(strings.NewReader(testOpenMetrics), func(name string) bool { return name == "temperature_celsius" }); err != nil {
+		t.Fatalf("LoadFromReaderWithFilter: %v", err)
+	}
+	if len(filtered.Metrics) != 1 || len(filtered.Metrics["temperature_celsius"]) != 1 {
+		t.Fatalf("unexpected filtered metrics: %+v", filtered.Metrics)
+	}
+}
+
+func TestSaveToWriter_OpenMetricsRoundTrip(t *testing.T) {
+	s := NewSimpleStorage()
+	if err := s.LoadFromReader(strings.NewReader(testOpenMetrics)); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := s.SaveToWriterWithOptions(&buf, SaveOptions{TimestampMode: "keep", Format: SaveFormatOpenMetrics}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# HELP http_requests_total Total HTTP requests.\n# TYPE http_requests_total unknown\n",
+		`http_requests_total{code="200"} 10 1700000000 # {trace_id="abc123"} 1 1699999999.5` + "\n",
+		`temperature_celsius{room="lab"} 21.5 1700000000` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+	if !strings.HasSuffix(out, "# EOF\n") {
+		t.Fatalf("expected trailing # EOF, got:\n%s", out)
+	}
+
+	reloaded := NewSimpleStorage()
+	if err := reloaded.LoadFromReader(strings.NewReader(out)); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if len(reloaded.Metrics["http_requests_total"]) != 2 || reloaded.Metrics["http_requests_total"][0].Exemplar == nil {
+		t.Fatalf("round trip lost data: %+v", reloaded.Metrics)
+	}
+}
+
+func TestLoadFromReader_OpenMetricsCreated(t *testing.T) {
+	input := `# TYPE jobs counter
+jobs_total{queue="a"} 5 1700000060 # {trace_id="t1"} 1
+jobs_created{queue="a"} 1700000000.5
+# TYPE temperature gauge
+temperature_created 3
+# EOF
+`
+	s := NewSimpleStorage()
+	if err := s.LoadFromReader(strings.NewReader(input)); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	if _, ok := s.Metrics["jobs_created"]; ok {
+		t.Fatalf("_created of a counter should not be stored as a series: %+v", s.Metrics)
+	}
+	jobs := s.Metrics["jobs_total"]
+	if len(jobs) != 1 || jobs[0].Created != 1700000000500 || jobs[0].Exemplar == nil {
+		t.Fatalf("expected created timestamp and exemplar on jobs_total, got %+v", jobs)
+	}
+	// _created is only special for counters, histograms and summaries.
+	if got := s.Metrics["temperature_created"]; len(got) != 1 || got[0].Value != 3 || got[0].Created != 0 {
+		t.Fatalf("expected temperature_created gauge sample, got %+v", got)
+	}
+}
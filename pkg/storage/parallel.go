@@ -0,0 +1,189 @@
+package simple_storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// parallelLoadMinBytes is the input size below which LoadParallel parses on a single goroutine.
+const parallelLoadMinBytes = 1 << 20
+
+// LoadParallel loads data as LoadFromReader does, parsing Prometheus text exposition input
+// with up to workers goroutines (0 or less means GOMAXPROCS, 1 parses sequentially). The
+// input is split into chunks at metric family boundaries, each parsed into its own shard,
+// and the shards are merged in input order. OpenMetrics and JSON inputs, and inputs
+// failing to parse in parallel, are loaded sequentially.
+func (s *SimpleStorage) LoadParallel(reader io.Reader, workers int) error {
+	// Read all to allow pre-sanitization of HELP directives (be tolerant of duplicates)
+	data, rerr := io.ReadAll(reader)
+	if rerr != nil {
+		return fmt.Errorf("failed to read metrics: %w", rerr)
+	}
+	// Prometheus API query response (native histogram samples are skipped)
+	if isPromAPIJSON(data) {
+		_, err := s.parsePromAPIJSON(data, nil)
+		return err
+	}
+	data = sanitizeDirectives(data)
+
+	// OpenMetrics exposition (terminated by "# EOF"), possibly with exemplars
+	if isOpenMetrics(data) {
+		return s.parseOpenMetrics(data, nil)
+	}
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > 1 && len(data) >= parallelLoadMinBytes {
+		if chunks := splitExposition(data, workers); len(chunks) > 1 {
+			if shards, err := parseChunks(chunks); err == nil {
+				s.mergeShards(shards)
+				return nil
+			}
+		}
+	}
+	return s.loadText(data)
+}
+
+// splitExposition splits data into about n chunks, each starting at a metric family. With
+// TYPE metadata a family starts at its comment lines, as histogram and summary families
+// span several metric names; without, at every change of metric name.
+func splitExposition(data []byte, n int) [][]byte {
+	byComments := bytes.HasPrefix(data, []byte("# TYPE ")) || bytes.Contains(data, []byte("\n# TYPE "))
+	var chunks [][]byte
+	start := 0
+	for len(chunks) < n-1 {
+		cut := nextFamilyStart(data, start+(len(data)-start)/(n-len(chunks)), byComments)
+		if cut <= start || cut >= len(data) {
+			break
+		}
+		chunks = append(chunks, data[start:cut])
+		start = cut
+	}
+	return append(chunks, data[start:])
+}
+
+// nextFamilyStart returns the offset of the first line after the one containing offset
+// that starts a metric family, or len(data).
+func nextFamilyStart(data []byte, offset int, byComments bool) int {
+	prev := lineAt(data, offset)
+	pos := offset
+	for {
+		nl := bytes.IndexByte(data[pos:], '\n')
+		if nl < 0 {
+			return len(data)
+		}
+		pos += nl + 1
+		line := lineAt(data, pos)
+		if len(line) == 0 {
+			continue
+		}
+		isComment, wasComment := line[0] == '#', len(prev) > 0 && prev[0] == '#'
+		switch {
+		case byComments && isComment && !wasComment && len(prev) > 0:
+			return pos
+		case !byComments && !isComment && !wasComment && !bytes.Equal(sampleName(line), sampleName(prev)):
+			return pos
+		}
+		prev = line
+	}
+}
+
+// lineAt returns the trimmed line of data containing offset.
+func lineAt(data []byte, offset int) []byte {
+	start := bytes.LastIndexByte(data[:offset], '\n') + 1
+	end := len(data)
+	if i := bytes.IndexByte(data[offset:], '\n'); i >= 0 {
+		end = offset + i
+	}
+	return bytes.TrimSpace(data[start:end])
+}
+
+// sampleName returns the metric name of an exposition sample line.
+func sampleName(line []byte) []byte {
+	if i := bytes.IndexAny(line, "{ \t"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// parseChunks parses the chunks concurrently into one shard each, as loadText does for
+// the whole input: as timestamped time-series data if all chunks are, otherwise with the
+// Prometheus text parser. Any parse error is returned, to load the input sequentially.
+func parseChunks(chunks [][]byte) ([]*SimpleStorage, error) {
+	shards, errs := make([]*SimpleStorage, len(chunks)), make([]error, len(chunks))
+	run := func(parse func(shard *SimpleStorage, chunk []byte) error) error {
+		var wg sync.WaitGroup
+		for i, chunk := range chunks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				shards[i] = NewSimpleStorage()
+				errs[i] = parse(shards[i], chunk)
+			}()
+		}
+		wg.Wait()
+		return errors.Join(errs...)
+	}
+
+	err := run(func(shard *SimpleStorage, chunk []byte) error {
+		if err := shard.parseTimeSeriesFormat(chunk); err != nil && err != errNoTimestampedSamples {
+			return err
+		}
+		return nil
+	})
+	if err == nil && shardsHaveSamples(shards) {
+		return shards, nil
+	}
+
+	// As processMetricFamilies, with the same timestamp for samples without one in all shards
+	baseTimestamp := time.Now().UnixMilli()
+	err = run(func(shard *SimpleStorage, chunk []byte) error {
+		parser := expfmt.NewTextParser(model.UTF8Validation)
+		metricFamilies, err := parser.TextToMetricFamilies(bytes.NewReader(chunk))
+		if err != nil {
+			return err
+		}
+		return shard.processMetricFamiliesAt(metricFamilies, baseTimestamp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shards, nil
+}
+
+func shardsHaveSamples(shards []*SimpleStorage) bool {
+	for _, shard := range shards {
+		if len(shard.Metrics) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeShards adds the samples and metadata of the shards, in order.
+func (s *SimpleStorage) mergeShards(shards []*SimpleStorage) {
+	for _, shard := range shards {
+		for name, samples := range shard.Metrics {
+			if len(s.Metrics[name]) == 0 {
+				s.Metrics[name] = samples
+			} else {
+				s.Metrics[name] = append(s.Metrics[name], samples...)
+			}
+		}
+		for name, help := range shard.MetricsHelp {
+			s.MetricsHelp[name] = help
+		}
+		for name, typ := range shard.MetricsType {
+			s.setMetricType(name, typ)
+		}
+	}
+}
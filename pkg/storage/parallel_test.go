@@ -0,0 +1,119 @@
+package simple_storage
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testBigExposition returns over parallelLoadMinBytes of exposition with counters and
+// histograms, with TYPE metadata when typed and sample timestamps when timestamped.
+func testBigExposition(typed, timestamped bool) []byte {
+	var b bytes.Buffer
+	ts := ""
+	if timestamped {
+		ts = " 1700000000000"
+	}
+	for f := 0; b.Len() < 2*parallelLoadMinBytes; f++ {
+		if typed {
+			fmt.Fprintf(&b, "# HELP req_%d_total Requests.\n# TYPE req_%d_total counter\n", f, f)
+		}
+		for i := 0; i < 50; i++ {
+			fmt.Fprintf(&b, "req_%d_total{code=\"200\",pod=\"p%d\"} %d%s\n", f, i, i, ts)
+		}
+		if typed {
+			fmt.Fprintf(&b, "# TYPE lat_%d_seconds histogram\n", f)
+		}
+		for i := 0; i < 20; i++ {
+			for _, le := range []string{"0.1", "1", "+Inf"} {
+				fmt.Fprintf(&b, "lat_%d_seconds_bucket{pod=\"p%d\",le=\"%s\"} %d%s\n", f, i, le, i, ts)
+			}
+			fmt.Fprintf(&b, "lat_%d_seconds_sum{pod=\"p%d\"} %d%s\nlat_%d_seconds_count{pod=\"p%d\"} %d%s\n", f, i, i, ts, f, i, i, ts)
+		}
+	}
+	return b.Bytes()
+}
+
+func TestLoadParallel_MatchesSequential(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		typed, timestamped bool
+	}{
+		{"typed", true, false},
+		{"untyped", false, false},
+		{"timestamped", true, true},
+	} {
+		data := testBigExposition(tc.typed, tc.timestamped)
+		seq, par := NewSimpleStorage(), NewSimpleStorage()
+		if err := seq.LoadParallel(bytes.NewReader(data), 1); err != nil {
+			t.Fatalf("%s: sequential: %v", tc.name, err)
+		}
+		if err := par.LoadParallel(bytes.NewReader(data), 4); err != nil {
+			t.Fatalf("%s: parallel: %v", tc.name, err)
+		}
+		if !tc.timestamped {
+			// Samples without a timestamp get the load time
+			for _, s := range []*SimpleStorage{seq, par} {
+				for _, samples := range s.Metrics {
+					for i := range samples {
+						samples[i].Timestamp = 0
+					}
+				}
+			}
+		}
+		if !reflect.DeepEqual(seq.Metrics, par.Metrics) {
+			t.Errorf("%s: parallel load differs from sequential load (%d vs %d metric names)", tc.name, len(par.Metrics), len(seq.Metrics))
+		}
+		if !reflect.DeepEqual(seq.MetricsType, par.MetricsType) || !reflect.DeepEqual(seq.MetricsHelp, par.MetricsHelp) {
+			t.Errorf("%s: parallel load metadata differs from sequential load", tc.name)
+		}
+	}
+}
+
+func TestLoadParallel_FallsBackOnErrors(t *testing.T) {
+	data := append(testBigExposition(true, false), "broken{ 1\n"...)
+	seq, par := NewSimpleStorage(), NewSimpleStorage()
+	seqErr, parErr := seq.LoadParallel(bytes.NewReader(data), 1), par.LoadParallel(bytes.NewReader(data), 4)
+	if (seqErr == nil) != (parErr == nil) || len(seq.Metrics) != len(par.Metrics) {
+		t.Fatalf("expected the sequential result, got %v/%d names vs %v/%d names", parErr, len(par.Metrics), seqErr, len(seq.Metrics))
+	}
+}
+
+func TestSplitExposition(t *testing.T) {
+	data := testBigExposition(true, false)
+	chunks := splitExposition(data, 8)
+	if len(chunks) != 8 {
+		t.Fatalf("expected 8 chunks, got %d", len(chunks))
+	}
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks don't add up to the input")
+	}
+	for i, c := range chunks[1:] {
+		if !bytes.HasPrefix(c, []byte("# ")) {
+			t.Errorf("chunk %d doesn't start at a family's metadata: %q", i+1, c[:min(len(c), 40)])
+		}
+	}
+
+	// Without metadata, at changes of metric name
+	for i, c := range splitExposition([]byte(strings.Repeat("a 1\n", 10)+strings.Repeat("b 1\n", 10)+"c 1\n"), 4)[1:] {
+		if !bytes.HasPrefix(c, []byte("b ")) && !bytes.HasPrefix(c, []byte("c ")) {
+			t.Errorf("chunk %d doesn't start at a metric name change: %q", i+1, c)
+		}
+	}
+}
+
+func BenchmarkLoadParallel(b *testing.B) {
+	data := testBigExposition(true, false)
+	for _, workers := range []int{1, 0} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				if err := NewSimpleStorage().LoadParallel(bytes.NewReader(data), workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package simple_storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// promAPIResponse is the body of a Prometheus /api/v1/query or /api/v1/query_range response.
+// Saved files may also hold only its "data" object.
+type promAPIResponse struct {
+	Status     string          `json:"status"`
+	Error      string          `json:"error"`
+	Data       *promAPIData    `json:"data"`
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+type promAPIData struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// promAPISeries is one vector sample or matrix series. Values are [<unix seconds>, <value>]
+// pairs, the value being a string in Prometheus responses and a number in some exports.
+type promAPISeries struct {
+	Metric     map[string]string `json:"metric"`
+	Value      []any             `json:"value"`
+	Values     [][]any           `json:"values"`
+	Histogram  []any             `json:"histogram"`
+	Histograms [][]any           `json:"histograms"`
+}
+
+// isPromAPIJSON reports whether data is a JSON object, as returned by the Prometheus query API.
+// Text expositions may start with "{" too (series without a metric name), but are not valid JSON.
+func isPromAPIJSON(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed)
+}
+
+// LoadPromAPIJSON loads the vector or matrix result of a Prometheus /api/v1/query or
+// /api/v1/query_range response, as saved with e.g. curl or printed by -o json, keeping the
+// sample timestamps. Series without a metric name are stored as query_result. Native
+// histogram samples can't be rebuilt from their bucket list and are skipped; their number
+// is returned.
+func (s *SimpleStorage) LoadPromAPIJSON(reader io.Reader) (int, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read JSON: %w", err)
+	}
+	return s.parsePromAPIJSON(data, nil)
+}
+
+// parsePromAPIJSON is LoadPromAPIJSON on data, keeping only the series whose metric name
+// passes filter (nil keeps all).
+func (s *SimpleStorage) parsePromAPIJSON(data []byte, filter func(name string) bool) (int, error) {
+	var resp promAPIResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return 0, fmt.Errorf("failed to parse Prometheus API JSON: %w", err)
+	}
+	if resp.Status == "error" {
+		return 0, fmt.Errorf("response is an error: %s", resp.Error)
+	}
+	resultType, result := resp.ResultType, resp.Result
+	if resp.Data != nil {
+		resultType, result = resp.Data.ResultType, resp.Data.Result
+	}
+	switch resultType {
+	case "vector", "matrix":
+	case "":
+		return 0, errors.New("not a Prometheus API query response: no resultType")
+	default:
+		return 0, fmt.Errorf("cannot load a %s result, only vector and matrix results have series", resultType)
+	}
+	var series []promAPISeries
+	if err := json.Unmarshal(result, &series); err != nil {
+		return 0, fmt.Errorf("failed to parse %s result: %w", resultType, err)
+	}
+
+	skipped := 0
+	for i, ser := range series {
+		if filter != nil && !filter(ser.Metric["__name__"]) {
+			continue
+		}
+		values := ser.Values
+		if ser.Value != nil {
+			values = append(values, ser.Value)
+		}
+		for _, v := range values {
+			ts, f, err := parsePromAPIPoint(v)
+			if err != nil {
+				return 0, fmt.Errorf("series %d: %w", i, err)
+			}
+			s.AddSample(ser.Metric, f, ts)
+		}
+		skipped += len(ser.Histograms)
+		if ser.Histogram != nil {
+			skipped++
+		}
+	}
+	return skipped, nil
+}
+
+// parsePromAPIPoint converts a [<unix seconds>, <value>] pair to a millisecond timestamp and value.
+func parsePromAPIPoint(p []any) (int64, float64, error) {
+	if len(p) != 2 {
+		return 0, 0, fmt.Errorf("expected [timestamp, value], got %d elements", len(p))
+	}
+	ts, ok := p[0].(float64)
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid timestamp %v", p[0])
+	}
+	var v float64
+	switch x := p[1].(type) {
+	case string:
+		var err error
+		if v, err = strconv.ParseFloat(x, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid value %q", x)
+		}
+	case float64:
+		v = x
+	default:
+		return 0, 0, fmt.Errorf("invalid value %v", p[1])
+	}
+	return int64(math.Round(ts * 1000)), v, nil
+}
@@ -0,0 +1,65 @@
+package simple_storage
+
+import (
+	"strings"
+	"testing"
+)
+
+const testQueryRangeJSON = `{"status":"success","data":{"resultType":"matrix","result":[
+  {"metric":{"__name__":"up","job":"api"},"values":[[1700000000,"1"],[1700000060.5,"0"]]},
+  {"metric":{"job":"db"},"values":[[1700000000,"NaN"]],"histograms":[[1700000000,{"count":"1","sum":"2","buckets":[]}]]}
+]}}`
+
+func TestLoadPromAPIJSON_Matrix(t *testing.T) {
+	s := NewSimpleStorage()
+	skipped, err := s.LoadPromAPIJSON(strings.NewReader(testQueryRangeJSON))
+	if err != nil {
+		t.Fatalf("LoadPromAPIJSON: %v", err)
+	}
+	if skipped != 1 {
+		t.Fatalf("expected 1 skipped histogram sample, got %d", skipped)
+	}
+	up := s.Metrics["up"]
+	if len(up) != 2 || up[0].Value != 1 || up[1].Timestamp != 1700000060500 || up[1].Labels["job"] != "api" {
+		t.Fatalf("unexpected up samples: %+v", up)
+	}
+	if len(s.Metrics["query_result"]) != 1 {
+		t.Fatalf("expected unnamed series stored as query_result: %+v", s.Metrics)
+	}
+}
+
+func TestLoadFromReader_PromAPIJSON(t *testing.T) {
+	// Vector as printed by -o json: numeric values, no "data" wrapper needed.
+	vector := `{"resultType":"vector","result":[{"metric":{"__name__":"up","job":"api"},"value":[1700000000,1]}]}`
+	s := NewSimpleStorage()
+	if err := s.LoadFromReader(strings.NewReader(vector)); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	if up := s.Metrics["up"]; len(up) != 1 || up[0].Timestamp != 1700000000000 || up[0].Value != 1 {
+		t.Fatalf("unexpected samples: %+v", s.Metrics)
+	}
+
+	filtered := NewSimpleStorage()
+	if err := filtered.LoadFromReaderWithFilter(strings.NewReader(testQueryRangeJSON), func(name string) bool { return name == "up" }); err != nil {
+		t.Fatalf("LoadFromReaderWithFilter: %v", err)
+	}
+	if len(filtered.Metrics) != 1 || len(filtered.Metrics["up"]) != 2 {
+		t.Fatalf("unexpected filtered metrics: %+v", filtered.Metrics)
+	}
+
+	// A text exposition line starting with "{" is not mistaken for JSON.
+	text := NewSimpleStorage()
+	if err := text.LoadFromReader(strings.NewReader(`{"my.metric", job="a"} 3` + "\n")); err != nil {
+		t.Fatalf("LoadFromReader text: %v", err)
+	}
+
+	for _, bad := range []string{
+		`{"status":"error","errorType":"bad_data","error":"parse error"}`,
+		`{"status":"success","data":{"resultType":"scalar","result":[1700000000,"1"]}}`,
+		`{"foo":1}`,
+	} {
+		if _, err := NewSimpleStorage().LoadPromAPIJSON(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package simple_storage
+
+import (
+	"fmt"
+	"io"
+	"mime"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+)
+
+// ScrapeAcceptHeader negotiates the protobuf exposition format first, as it is the only
+// one carrying native histograms, falling back to OpenMetrics and the classic text format.
+const ScrapeAcceptHeader = "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.6," +
+	"application/openmetrics-text;version=1.0.0;q=0.5,text/plain;version=0.0.4;q=0.4,*/*;q=0.1"
+
+const protobufMediaType = "application/vnd.google.protobuf"
+
+// LoadFromReaderWithContentType loads metrics served with the given HTTP Content-Type.
+// Protobuf exposition is decoded with native histograms (keeping classic buckets too);
+// any other content type is handled by LoadFromReader/LoadFromReaderWithFilter.
+func (s *SimpleStorage) LoadFromReaderWithContentType(reader io.Reader, contentType string, filter func(name string) bool) error {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != protobufMediaType {
+		if filter == nil {
+			return s.LoadFromReader(reader)
+		}
+		return s.LoadFromReaderWithFilter(reader, filter)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read metrics: %w", err)
+	}
+	p := textparse.NewProtobufParser(data, false, true, false, false, labels.NewSymbolTable())
+	return s.loadFromParser(p, filter)
+}
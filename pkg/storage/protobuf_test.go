@@ -0,0 +1,74 @@
+package simple_storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestLoadFromReaderWithContentType_NativeHistogram(t *testing.T) {
+	mf := &dto.MetricFamily{
+		Name: proto.String("rpc_duration_seconds"),
+		Help: proto.String("RPC latency."),
+		Type: dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{{
+			Label:       []*dto.LabelPair{{Name: proto.String("service"), Value: proto.String("api")}},
+			TimestampMs: proto.Int64(1700000000000),
+			Histogram: &dto.Histogram{
+				SampleCount:   proto.Uint64(5),
+				SampleSum:     proto.Float64(2.5),
+				Schema:        proto.Int32(0),
+				ZeroThreshold: proto.Float64(1e-128),
+				ZeroCount:     proto.Uint64(1),
+				PositiveSpan:  []*dto.BucketSpan{{Offset: proto.Int32(0), Length: proto.Uint32(2)}},
+				PositiveDelta: []int64{2, 0},
+			},
+		}},
+	}
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.NewFormat(expfmt.TypeProtoDelim))
+	if err := enc.Encode(mf); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	s := NewSimpleStorage()
+	if err := s.LoadFromReaderWithContentType(&buf, string(expfmt.NewFormat(expfmt.TypeProtoDelim)), nil); err != nil {
+		t.Fatalf("LoadFromReaderWithContentType: %v", err)
+	}
+	samples := s.Metrics["rpc_duration_seconds"]
+	if len(samples) != 1 || samples[0].Histogram == nil {
+		t.Fatalf("expected one native histogram sample, got %+v", s.Metrics)
+	}
+	if h := samples[0].Histogram; h.Count != 5 || h.Sum != 2.5 || samples[0].Timestamp != 1700000000000 {
+		t.Fatalf("unexpected histogram: %s @ %d", h, samples[0].Timestamp)
+	}
+	if s.MetricsType["rpc_duration_seconds"] != "histogram" || s.MetricsHelp["rpc_duration_seconds"] != "RPC latency." {
+		t.Fatalf("unexpected metadata: %v %v", s.MetricsType, s.MetricsHelp)
+	}
+
+	// The querier exposes the sample as a float histogram
+	q, _ := s.Querier(0, 1700000000000)
+	ss := q.Select(context.Background(), false, nil, labels.MustNewMatcher(labels.MatchEqual, "__name__", "rpc_duration_seconds"))
+	if !ss.Next() {
+		t.Fatalf("expected a series")
+	}
+	it := ss.At().Iterator(nil)
+	if vt := it.Next(); vt != chunkenc.ValFloatHistogram {
+		t.Fatalf("expected float histogram value type, got %v", vt)
+	}
+	if ts, fh := it.AtFloatHistogram(nil); ts != 1700000000000 || fh.Count != 5 {
+		t.Fatalf("unexpected AtFloatHistogram: %d %v", ts, fh)
+	}
+
+	// Text formats cannot represent native histograms and skip them
+	var out bytes.Buffer
+	if err := s.SaveToWriter(&out); err != nil || out.Len() != 0 {
+		t.Fatalf("expected empty text save, got %q (%v)", out.String(), err)
+	}
+}
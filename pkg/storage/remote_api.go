@@ -0,0 +1,143 @@
+package simple_storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/annotations"
+)
+
+// RemoteQueryable implements storage.Queryable on top of a Prometheus HTTP API.
+// Raw samples are fetched by evaluating a range selector (e.g. up[3600000ms]) at
+// the end of the requested window, so the engine sees the same data it would
+// read from a local TSDB.
+type RemoteQueryable struct {
+	URL string
+	API v1.API
+}
+
+// NewRemoteQueryable creates a RemoteQueryable for the Prometheus server at address.
+func NewRemoteQueryable(address string) (*RemoteQueryable, error) {
+	address = strings.TrimRight(strings.TrimSpace(address), "/")
+	if address == "" {
+		return nil, fmt.Errorf("empty Prometheus URL")
+	}
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		address = "http://" + address
+	}
+	c, err := api.NewClient(api.Config{Address: address})
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteQueryable{URL: address, API: v1.NewAPI(c)}, nil
+}
+
+// Querier implements storage.Queryable.
+func (r *RemoteQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	return &remoteQuerier{api: r.API, mint: mint, maxt: maxt}, nil
+}
+
+// remoteQuerier implements storage.Querier against the Prometheus HTTP API.
+type remoteQuerier struct {
+	api        v1.API
+	mint, maxt int64
+}
+
+func (q *remoteQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	mint, maxt := q.mint, q.maxt
+	if hints != nil {
+		mint, maxt = max(mint, hints.Start), min(maxt, hints.End)
+	}
+	if maxt < mint {
+		return &SimpleSeriesSet{index: -1}
+	}
+	// Range selectors are left-open, widen by 1ms so mint itself is included.
+	expr := fmt.Sprintf("%s[%dms]", matchersToSelector(matchers), maxt-mint+1)
+	val, warnings, err := q.api.Query(ctx, expr, time.UnixMilli(maxt))
+	if err != nil {
+		return storage.ErrSeriesSet(fmt.Errorf("remote query %s: %w", expr, err))
+	}
+	matrix, ok := val.(model.Matrix)
+	if !ok {
+		return storage.ErrSeriesSet(fmt.Errorf("remote query %s: unexpected result type %s", expr, val.Type()))
+	}
+
+	series := make([]storage.Series, 0, len(matrix))
+	for _, ss := range matrix {
+		lbls := make(map[string]string, len(ss.Metric))
+		for k, v := range ss.Metric {
+			lbls[string(k)] = string(v)
+		}
+		samples := make([]MetricSample, 0, len(ss.Values))
+		for _, p := range ss.Values {
+			samples = append(samples, MetricSample{Labels: lbls, Value: float64(p.Value), Timestamp: int64(p.Timestamp)})
+		}
+		series = append(series, &SimpleSeries{labels: labels.FromMap(lbls), samples: samples})
+	}
+	if sortSeries {
+		sort.Slice(series, func(i, j int) bool {
+			return labels.Compare(series[i].Labels(), series[j].Labels()) < 0
+		})
+	}
+	return &SimpleSeriesSet{series: series, index: -1, warnings: toAnnotations(warnings)}
+}
+
+func (q *remoteQuerier) LabelValues(ctx context.Context, name string, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	vals, warnings, err := q.api.LabelValues(ctx, name, matchersToSelectors(matchers), time.UnixMilli(q.mint), time.UnixMilli(q.maxt))
+	if err != nil {
+		return nil, nil, err
+	}
+	out := make([]string, 0, len(vals))
+	for _, v := range vals {
+		out = append(out, string(v))
+	}
+	return out, toAnnotations(warnings), nil
+}
+
+func (q *remoteQuerier) LabelNames(ctx context.Context, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	names, warnings, err := q.api.LabelNames(ctx, matchersToSelectors(matchers), time.UnixMilli(q.mint), time.UnixMilli(q.maxt))
+	if err != nil {
+		return nil, nil, err
+	}
+	return names, toAnnotations(warnings), nil
+}
+
+func (q *remoteQuerier) Close() error {
+	return nil
+}
+
+// matchersToSelector renders matchers as a PromQL series selector, e.g. {__name__="up",job="node"}.
+func matchersToSelector(matchers []*labels.Matcher) string {
+	parts := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		parts = append(parts, m.String())
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// matchersToSelectors returns the match[] parameter for label APIs (nil when unfiltered).
+func matchersToSelectors(matchers []*labels.Matcher) []string {
+	if len(matchers) == 0 {
+		return nil
+	}
+	return []string{matchersToSelector(matchers)}
+}
+
+func toAnnotations(warnings v1.Warnings) annotations.Annotations {
+	if len(warnings) == 0 {
+		return nil
+	}
+	var annos annotations.Annotations
+	for _, w := range warnings {
+		annos.Add(fmt.Errorf("remote: %s", w))
+	}
+	return annos
+}
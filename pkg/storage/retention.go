@@ -0,0 +1,81 @@
+package simple_storage
+
+// MaxTimestamp returns the timestamp of the newest sample, or 0 when the store is empty.
+func (s *SimpleStorage) MaxTimestamp() int64 {
+	var maxTS int64
+	for _, samples := range s.Metrics {
+		for _, sample := range samples {
+			maxTS = max(maxTS, sample.Timestamp)
+		}
+	}
+	return maxTS
+}
+
+// DropBefore removes the samples older than cutoff (in milliseconds), and the metrics left
+// without samples, returning the number of samples dropped.
+func (s *SimpleStorage) DropBefore(cutoff int64) int {
+	dropped := 0
+	for name, samples := range s.Metrics {
+		kept := samples[:0]
+		for _, sample := range samples {
+			if sample.Timestamp >= cutoff {
+				kept = append(kept, sample)
+			}
+		}
+		if len(kept) == len(samples) {
+			continue
+		}
+		dropped += len(samples) - len(kept)
+		// Release what the dropped samples referenced
+		clear(samples[len(kept):])
+		if len(kept) == 0 {
+			delete(s.Metrics, name)
+		} else {
+			s.Metrics[name] = kept
+		}
+	}
+	return dropped
+}
+
+// Downsample keeps, of the samples older than before (in milliseconds), the newest sample
+// of each series in every resolution interval, and returns the number of samples dropped.
+// Keeping the last sample keeps counters and gauges meaningful at the lower resolution.
+func (s *SimpleStorage) Downsample(resolution, before int64) int {
+	if resolution <= 0 {
+		return 0
+	}
+	dropped := 0
+	for name, samples := range s.Metrics {
+		ix := s.indexes(name)
+		if len(ix) != 1 {
+			continue
+		}
+		idx := ix[0]
+		kept := make([]MetricSample, 0, len(samples))
+		for _, series := range idx.series {
+			for i, sample := range series.samples {
+				// Samples are sorted: drop those followed by one in the same interval
+				if sample.Timestamp < before && i+1 < len(series.samples) &&
+					series.samples[i+1].Timestamp < before &&
+					floorDiv(series.samples[i+1].Timestamp, resolution) == floorDiv(sample.Timestamp, resolution) {
+					continue
+				}
+				kept = append(kept, sample)
+			}
+		}
+		if len(kept) < len(samples) {
+			dropped += len(samples) - len(kept)
+			s.Metrics[name] = kept
+		}
+	}
+	return dropped
+}
+
+// floorDiv returns a/b rounded down, for the intervals of negative timestamps too.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
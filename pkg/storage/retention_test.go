@@ -0,0 +1,48 @@
+package simple_storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDropBefore(t *testing.T) {
+	s := NewSimpleStorage()
+	for ts := int64(1); ts <= 5; ts++ {
+		s.AddSample(map[string]string{"__name__": "up"}, float64(ts), ts*1000)
+	}
+	s.AddSample(map[string]string{"__name__": "old"}, 1, 1000)
+	if got := s.MaxTimestamp(); got != 5000 {
+		t.Fatalf("MaxTimestamp: got %d", got)
+	}
+	if dropped := s.DropBefore(3000); dropped != 3 {
+		t.Fatalf("expected 3 dropped samples, got %d", dropped)
+	}
+	if _, ok := s.Metrics["old"]; ok {
+		t.Fatal("metrics without samples should be removed")
+	}
+	if got := selectSeries(t, s, 0, 10000); !reflect.DeepEqual(got, []string{`{__name__="up"}: 3000=3 4000=4 5000=5`}) {
+		t.Fatalf("unexpected series: %q", got)
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	s := NewSimpleStorage()
+	for ts := int64(0); ts < 10; ts++ {
+		s.AddSample(map[string]string{"__name__": "c", "pod": "a"}, float64(ts), ts*30000)
+		s.AddSample(map[string]string{"__name__": "c", "pod": "b"}, float64(ts), ts*30000)
+	}
+	// 1m intervals for samples before 4m: 0s,30s | 60s,90s | 120s,150s | 180s,210s
+	if dropped := s.Downsample(60000, 240000); dropped != 8 {
+		t.Fatalf("expected 8 dropped samples, got %d", dropped)
+	}
+	want := []string{
+		`{__name__="c", pod="a"}: 30000=1 90000=3 150000=5 210000=7 240000=8 270000=9`,
+		`{__name__="c", pod="b"}: 30000=1 90000=3 150000=5 210000=7 240000=8 270000=9`,
+	}
+	if got := selectSeries(t, s, 0, 1<<40); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if dropped := s.Downsample(60000, 240000); dropped != 0 {
+		t.Fatalf("downsampling again should be a no-op, dropped %d", dropped)
+	}
+}
@@ -3,11 +3,14 @@ package simple_storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	dto "github.com/prometheus/client_model/go"
@@ -24,6 +27,11 @@ import (
 type SimpleStorage struct {
 	Metrics     map[string][]MetricSample
 	MetricsHelp map[string]string // metric name -> help text
+	MetricsType map[string]string // metric family name -> type (counter, gauge, histogram, summary, untyped)
+
+	// index holds the label indexes of the metrics, built on first use (see indexes)
+	indexMu sync.Mutex
+	index   map[string]*metricIndex
 }
 
 // MetricSample represents a single metric sample
@@ -31,6 +39,9 @@ type MetricSample struct {
 	Labels    map[string]string
 	Value     float64
 	Timestamp int64
+	Exemplar  *Exemplar                 // optional, from OpenMetrics input
+	Histogram *histogram.FloatHistogram // native histogram sample; Value is unused when set
+	Created   int64                     // OpenMetrics _created (start) timestamp in ms, 0 when unknown
 }
 
 // NewSimpleStorage creates a new simple storage
@@ -38,6 +49,7 @@ func NewSimpleStorage() *SimpleStorage {
 	return &SimpleStorage{
 		Metrics:     make(map[string][]MetricSample),
 		MetricsHelp: make(map[string]string),
+		MetricsType: make(map[string]string),
 	}
 }
 
@@ -109,15 +121,13 @@ func sanitizeDirectives(data []byte) []byte {
 	return []byte(b.String())
 }
 
-// LoadFromReader loads Prometheus exposition format data using the official Prometheus parser
+// LoadFromReader loads Prometheus or OpenMetrics exposition format data using the official Prometheus parsers
 func (s *SimpleStorage) LoadFromReader(reader io.Reader) error {
-	// Read all to allow pre-sanitization of HELP directives (be tolerant of duplicates)
-	data, rerr := io.ReadAll(reader)
-	if rerr != nil {
-		return fmt.Errorf("failed to read metrics: %w", rerr)
-	}
-	data = sanitizeDirectives(data)
+	return s.LoadParallel(reader, 1)
+}
 
+// loadText loads Prometheus text exposition format data, after sanitizeDirectives.
+func (s *SimpleStorage) loadText(data []byte) error {
 	// First, try custom line-by-line parser for time-series data with multiple timestamps
 	if err := s.parseTimeSeriesFormat(data); err == nil {
 		// Successfully parsed as time-series format
@@ -145,7 +155,14 @@ func (s *SimpleStorage) LoadFromReaderWithFilter(reader io.Reader, filter func(n
 	if rerr != nil {
 		return fmt.Errorf("failed to read metrics: %w", rerr)
 	}
+	if isPromAPIJSON(data) {
+		_, err := s.parsePromAPIJSON(data, filter)
+		return err
+	}
 	data = sanitizeDirectives(data)
+	if isOpenMetrics(data) {
+		return s.parseOpenMetrics(data, filter)
+	}
 
 	parser := expfmt.NewTextParser(model.UTF8Validation)
 	metricFamilies, err := parser.TextToMetricFamilies(strings.NewReader(string(data)))
@@ -167,16 +184,29 @@ func (s *SimpleStorage) LoadFromReaderWithFilter(reader io.Reader, filter func(n
 	return s.processMetricFamilies(filtered)
 }
 
+// errNoTimestampedSamples is returned by parseTimeSeriesFormat for data without samples.
+var errNoTimestampedSamples = errors.New("no timestamped samples found")
+
 // parseTimeSeriesFormat parses time-series data where the same metric+labels can appear
 // multiple times with different timestamps. Format: metric{labels} value timestamp
 // Returns error if the data doesn't match this format (to fall back to standard parser).
 func (s *SimpleStorage) parseTimeSeriesFormat(data []byte) error {
 	hasTimestampedSamples := false
+	help := make(map[string]string)
+	types := make(map[string]string)
 
 	for line := range strings.SplitSeq(string(data), "\n") {
 		line = strings.TrimSpace(line)
 
-		// Skip comments and empty lines
+		// Keep HELP/TYPE metadata (e.g. from .export), skip other comments and empty lines
+		if fields := strings.SplitN(line, " ", 4); len(fields) == 4 && fields[0] == "#" {
+			switch fields[1] {
+			case "HELP":
+				help[fields[2]] = strings.ReplaceAll(fields[3], `\\`, `\`)
+			case "TYPE":
+				types[fields[2]] = fields[3]
+			}
+		}
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
@@ -217,7 +247,13 @@ func (s *SimpleStorage) parseTimeSeriesFormat(data []byte) error {
 
 	// Only succeed if we found timestamped samples
 	if !hasTimestampedSamples {
-		return fmt.Errorf("no timestamped samples found")
+		return errNoTimestampedSamples
+	}
+	for name, text := range help {
+		s.MetricsHelp[name] = text
+	}
+	for name, typ := range types {
+		s.setMetricType(name, typ)
 	}
 
 	return nil
@@ -392,8 +428,11 @@ func parseInt(s string) (int64, error) {
 // processMetricFamilies processes the parsed metric families (extracted from original LoadFromReader)
 func (s *SimpleStorage) processMetricFamilies(metricFamilies map[string]*dto.MetricFamily) error {
 	// Use a consistent base timestamp for all samples loaded in this call
-	baseTimestamp := time.Now().UnixMilli()
+	return s.processMetricFamiliesAt(metricFamilies, time.Now().UnixMilli())
+}
 
+// processMetricFamiliesAt is processMetricFamilies giving samples without a timestamp baseTimestamp.
+func (s *SimpleStorage) processMetricFamiliesAt(metricFamilies map[string]*dto.MetricFamily, baseTimestamp int64) error {
 	// Convert each metric family to individual samples
 	for _, mf := range metricFamilies {
 		metricName := mf.GetName()
@@ -409,6 +448,8 @@ func (s *SimpleStorage) processMetricFamilies(metricFamilies map[string]*dto.Met
 			}
 		}
 
+		s.setMetricType(metricName, metricTypeName(mf.GetType()))
+
 		// Process each metric within the family
 		for _, metric := range mf.GetMetric() {
 			// Create labels map starting with the metric name
@@ -489,10 +530,14 @@ func (s *SimpleStorage) processMetricFamilies(metricFamilies map[string]*dto.Met
 						s.Metrics[metricName] = append(s.Metrics[metricName], MetricSample{Labels: qLabels, Value: q.GetValue(), Timestamp: timestamp})
 					}
 					if metric.Summary.SampleSum != nil {
-						s.Metrics[metricName+"_sum"] = append(s.Metrics[metricName+"_sum"], MetricSample{Labels: lbls, Value: metric.Summary.GetSampleSum(), Timestamp: timestamp})
+						sumLabels := maps.Clone(lbls)
+						sumLabels["__name__"] = metricName + "_sum"
+						s.Metrics[metricName+"_sum"] = append(s.Metrics[metricName+"_sum"], MetricSample{Labels: sumLabels, Value: metric.Summary.GetSampleSum(), Timestamp: timestamp})
 					}
 					if metric.Summary.SampleCount != nil {
-						s.Metrics[metricName+"_count"] = append(s.Metrics[metricName+"_count"], MetricSample{Labels: lbls, Value: float64(metric.Summary.GetSampleCount()), Timestamp: timestamp})
+						countLabels := maps.Clone(lbls)
+						countLabels["__name__"] = metricName + "_count"
+						s.Metrics[metricName+"_count"] = append(s.Metrics[metricName+"_count"], MetricSample{Labels: countLabels, Value: float64(metric.Summary.GetSampleCount()), Timestamp: timestamp})
 					}
 				}
 				// Summary fully handled; proceed to next metric
@@ -528,6 +573,17 @@ func (s *SimpleStorage) AddSample(labels map[string]string, value float64, times
 	s.Metrics[name] = append(s.Metrics[name], MetricSample{Labels: lbls, Value: value, Timestamp: timestampMillis})
 }
 
+// AddHistogramSample appends a single native histogram sample to the in-memory store.
+func (s *SimpleStorage) AddHistogramSample(labels map[string]string, h *histogram.FloatHistogram, timestampMillis int64) {
+	s.AddSample(labels, 0, timestampMillis)
+	name := labels["__name__"]
+	if name == "" {
+		name = "query_result"
+	}
+	samples := s.Metrics[name]
+	samples[len(samples)-1].Histogram = h.Copy()
+}
+
 // RenameMetric renames all series with oldName to newName
 func (s *SimpleStorage) RenameMetric(oldName, newName string) error {
 	if s.Metrics == nil {
@@ -552,6 +608,10 @@ func (s *SimpleStorage) RenameMetric(oldName, newName string) error {
 		s.MetricsHelp[newName] = help
 		delete(s.MetricsHelp, oldName)
 	}
+	if typ, ok := s.MetricsType[oldName]; ok {
+		s.setMetricType(newName, typ)
+		delete(s.MetricsType, oldName)
+	}
 	return nil
 }
 
@@ -564,6 +624,9 @@ type SaveOptions struct {
 	FixedTimestamp int64
 	// SeriesRegex filters which time series to write. It matches against "name{labels}" (labels sorted, quoted), excluding value/timestamp.
 	SeriesRegex *regexp.Regexp
+	// Format selects the output format: "prom" (default, Prometheus text) or "openmetrics"
+	// (with seconds-based timestamps, exemplars and a trailing "# EOF").
+	Format string
 }
 
 // SaveToWriter writes the store content in Prometheus text exposition (line) format.
@@ -605,6 +668,8 @@ func (s *SimpleStorage) SaveToWriterWithOptions(w io.Writer, opts SaveOptions) e
 		}
 	}
 
+	openMetrics := opts.Format == SaveFormatOpenMetrics
+
 	// Collect metric names
 	names := make([]string, 0, len(s.Metrics))
 	for name := range s.Metrics {
@@ -616,13 +681,18 @@ func (s *SimpleStorage) SaveToWriterWithOptions(w io.Writer, opts SaveOptions) e
 		samples := s.Metrics[name]
 		// Build sortable representations: by labelset (excluding __name__) then timestamp
 		type row struct {
-			labels map[string]string
-			value  float64
-			ts     int64
-			key    string
+			labels   map[string]string
+			value    float64
+			ts       int64
+			key      string
+			exemplar *Exemplar
 		}
 		rows := make([]row, 0, len(samples))
 		for _, s := range samples {
+			// Native histograms have no representation in the text formats
+			if s.Histogram != nil {
+				continue
+			}
 			// Build label string excluding __name__
 			keys := make([]string, 0, len(s.Labels))
 			for k := range s.Labels {
@@ -641,7 +711,7 @@ func (s *SimpleStorage) SaveToWriterWithOptions(w io.Writer, opts SaveOptions) e
 				b.WriteByte('=')
 				b.WriteString(s.Labels[k])
 			}
-			rows = append(rows, row{labels: s.Labels, value: s.Value, ts: s.Timestamp, key: b.String()})
+			rows = append(rows, row{labels: s.Labels, value: s.Value, ts: s.Timestamp, key: b.String(), exemplar: s.Exemplar})
 		}
 		sort.Slice(rows, func(i, j int) bool {
 			if rows[i].key == rows[j].key {
@@ -650,6 +720,7 @@ func (s *SimpleStorage) SaveToWriterWithOptions(w io.Writer, opts SaveOptions) e
 			return rows[i].key < rows[j].key
 		})
 
+		wroteFamily := false
 		for _, r := range rows {
 			// Write line: name{labels} value [timestamp?]
 			labelStr := formatLabelsForLine(r.labels)
@@ -669,6 +740,18 @@ func (s *SimpleStorage) SaveToWriterWithOptions(w io.Writer, opts SaveOptions) e
 				// Apply offset to align latest timestamp to target
 				outTs = r.ts + timestampOffset
 			}
+			if openMetrics {
+				if !wroteFamily {
+					if err := s.writeOpenMetricsFamily(w, name); err != nil {
+						return err
+					}
+					wroteFamily = true
+				}
+				if _, err := io.WriteString(w, formatOpenMetricsSample(name, labelStr, r.value, outTs, writeTimestamp, r.exemplar)); err != nil {
+					return err
+				}
+				continue
+			}
 			if labelStr != "" {
 				if writeTimestamp {
 					if _, err := fmt.Fprintf(w, "%s{%s} %v %d\n", name, labelStr, r.value, outTs); err != nil {
@@ -692,6 +775,11 @@ func (s *SimpleStorage) SaveToWriterWithOptions(w io.Writer, opts SaveOptions) e
 			}
 		}
 	}
+	if openMetrics {
+		if _, err := io.WriteString(w, "# EOF\n"); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -736,46 +824,20 @@ type SimpleQuerier struct {
 
 func (q *SimpleQuerier) Select(_ context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
 	var series []storage.Series
-
-	// Find matching metrics
-	for _, samples := range q.storage.Metrics {
-		// Check if any samples match the matchers and time range
-		var matchingSamples []MetricSample
-		for _, sample := range samples {
-			// Check time range
-			if sample.Timestamp < q.mint || sample.Timestamp > q.maxt {
-				continue
-			}
-
-			// Check label matchers
-			if q.matchesLabelMatchers(sample.Labels, matchers) {
-				matchingSamples = append(matchingSamples, sample)
+	for _, idx := range q.storage.indexes(metricNameOf(matchers)) {
+		for _, s := range idx.matching(matchers) {
+			// The iterator contract requires samples in ascending timestamp order
+			if samples := sampleRange(s.samples, q.mint, q.maxt); len(samples) > 0 {
+				series = append(series, &SimpleSeries{labels: s.labels, samples: samples})
 			}
 		}
+	}
 
-		// Group samples by unique label sets
-		labelGroups := make(map[string][]MetricSample)
-		for _, sample := range matchingSamples {
-			key := q.getLabelKey(sample.Labels)
-			labelGroups[key] = append(labelGroups[key], sample)
-		}
-
-		// Create a series for each unique label set.
-		// Sort samples by timestamp: the Prometheus chunkenc.Iterator contract requires
-		// ascending order for both Next() and Seek(), and labelGroups is built from
-		// map iteration so input order is undefined.
-		for _, groupSamples := range labelGroups {
-			if len(groupSamples) > 0 {
-				sort.Slice(groupSamples, func(i, j int) bool {
-					return groupSamples[i].Timestamp < groupSamples[j].Timestamp
-				})
-				lbls := labels.FromMap(groupSamples[0].Labels)
-				series = append(series, &SimpleSeries{
-					labels:  lbls,
-					samples: groupSamples,
-				})
-			}
-		}
+	// Merging queriers (e.g. local + remote) require series sorted by labels.
+	if sortSeries {
+		sort.Slice(series, func(i, j int) bool {
+			return labels.Compare(series[i].Labels(), series[j].Labels()) < 0
+		})
 	}
 
 	return &SimpleSeriesSet{series: series, index: -1}
@@ -783,12 +845,16 @@ func (q *SimpleQuerier) Select(_ context.Context, sortSeries bool, hints *storag
 
 func (q *SimpleQuerier) LabelValues(_ context.Context, name string, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
 	values := make(map[string]struct{})
-	for _, samples := range q.storage.Metrics {
-		for _, sample := range samples {
-			if q.matchesLabelMatchers(sample.Labels, matchers) {
-				if value, ok := sample.Labels[name]; ok {
-					values[value] = struct{}{}
-				}
+	for _, idx := range q.storage.indexes(metricNameOf(matchers)) {
+		if len(matchers) == 0 {
+			for value := range idx.postings[name] {
+				values[value] = struct{}{}
+			}
+			continue
+		}
+		for _, s := range idx.matching(matchers) {
+			if value, ok := s.labelsMap[name]; ok {
+				values[value] = struct{}{}
 			}
 		}
 	}
@@ -802,12 +868,16 @@ func (q *SimpleQuerier) LabelValues(_ context.Context, name string, hints *stora
 
 func (q *SimpleQuerier) LabelNames(_ context.Context, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
 	names := make(map[string]struct{})
-	for _, samples := range q.storage.Metrics {
-		for _, sample := range samples {
-			if q.matchesLabelMatchers(sample.Labels, matchers) {
-				for name := range sample.Labels {
-					names[name] = struct{}{}
-				}
+	for _, idx := range q.storage.indexes(metricNameOf(matchers)) {
+		if len(matchers) == 0 {
+			for name := range idx.postings {
+				names[name] = struct{}{}
+			}
+			continue
+		}
+		for _, s := range idx.matching(matchers) {
+			for name := range s.labelsMap {
+				names[name] = struct{}{}
 			}
 		}
 	}
@@ -823,26 +893,6 @@ func (q *SimpleQuerier) Close() error {
 	return nil
 }
 
-// matchesLabelMatchers checks if labels match the given matchers
-func (q *SimpleQuerier) matchesLabelMatchers(sampleLabels map[string]string, matchers []*labels.Matcher) bool {
-	for _, matcher := range matchers {
-		value, exists := sampleLabels[matcher.Name]
-		if !exists {
-			value = ""
-		}
-		if !matcher.Matches(value) {
-			return false
-		}
-	}
-	return true
-}
-
-// getLabelKey creates a stable unique key for a label set by sorting labels
-func (q *SimpleQuerier) getLabelKey(lbls map[string]string) string {
-	l := labels.FromMap(lbls)
-	return l.String()
-}
-
 // SimpleSeries implements storage.Series
 type SimpleSeries struct {
 	labels  labels.Labels
@@ -868,19 +918,29 @@ func (it *SimpleIterator) Next() chunkenc.ValueType {
 	if it.index >= len(it.samples) {
 		return chunkenc.ValNone
 	}
+	return it.valueType()
+}
+
+// valueType returns the type of the current sample.
+func (it *SimpleIterator) valueType() chunkenc.ValueType {
+	if it.samples[it.index].Histogram != nil {
+		return chunkenc.ValFloatHistogram
+	}
 	return chunkenc.ValFloat
 }
 
 //nolint:govet // Seek is intentionally not io.Seeker; matches Prometheus chunkenc.Iterator semantics
 func (it *SimpleIterator) Seek(t int64) chunkenc.ValueType {
-	for i, sample := range it.samples {
-		if sample.Timestamp >= t {
-			it.index = i
-			return chunkenc.ValFloat
-		}
+	// Samples are sorted by timestamp, and Seek never moves backwards
+	start := max(it.index, 0)
+	it.index = start + sort.Search(len(it.samples)-min(start, len(it.samples)), func(i int) bool {
+		return it.samples[start+i].Timestamp >= t
+	})
+	if it.index >= len(it.samples) {
+		it.index = len(it.samples)
+		return chunkenc.ValNone
 	}
-	it.index = len(it.samples)
-	return chunkenc.ValNone
+	return it.valueType()
 }
 
 func (it *SimpleIterator) At() (int64, float64) {
@@ -895,8 +955,16 @@ func (it *SimpleIterator) AtHistogram(*histogram.Histogram) (int64, *histogram.H
 	return 0, nil
 }
 
-func (it *SimpleIterator) AtFloatHistogram(*histogram.FloatHistogram) (int64, *histogram.FloatHistogram) {
-	return 0, nil
+func (it *SimpleIterator) AtFloatHistogram(fh *histogram.FloatHistogram) (int64, *histogram.FloatHistogram) {
+	if it.index < 0 || it.index >= len(it.samples) || it.samples[it.index].Histogram == nil {
+		return 0, nil
+	}
+	sample := it.samples[it.index]
+	if fh == nil {
+		return sample.Timestamp, sample.Histogram.Copy()
+	}
+	sample.Histogram.CopyTo(fh)
+	return sample.Timestamp, fh
 }
 
 func (it *SimpleIterator) AtT() int64 {
@@ -916,9 +984,10 @@ func (it *SimpleIterator) Err() error {
 
 // SimpleSeriesSet implements storage.SeriesSet
 type SimpleSeriesSet struct {
-	series []storage.Series
-	index  int
-	err    error
+	series   []storage.Series
+	index    int
+	err      error
+	warnings annotations.Annotations
 }
 
 func (s *SimpleSeriesSet) Next() bool {
@@ -938,5 +1007,5 @@ func (s *SimpleSeriesSet) Err() error {
 }
 
 func (s *SimpleSeriesSet) Warnings() annotations.Annotations {
-	return nil
+	return s.warnings
 }
@@ -0,0 +1,155 @@
+package simple_storage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// maxStreamLineBytes bounds a single exposition line in LoadStream.
+const maxStreamLineBytes = 16 << 20
+
+// StreamOptions configures LoadStream.
+type StreamOptions struct {
+	// Filter, when set, keeps only the samples whose metric name and labels it accepts.
+	// It runs during parsing, so rejected samples are never stored.
+	Filter func(name string, labels map[string]string) bool
+	// MaxSamples stops loading once that many samples were stored (0 means no limit).
+	MaxSamples int64
+	// Progress, when set, is called about every ProgressInterval (default 1s) and once at the end.
+	Progress         func(StreamStats)
+	ProgressInterval time.Duration
+}
+
+// StreamStats reports LoadStream progress.
+type StreamStats struct {
+	Bytes   int64
+	Lines   int64
+	Samples int64
+	// Skipped counts samples rejected by StreamOptions.Filter.
+	Skipped int64
+	// Truncated is set when samples beyond StreamOptions.MaxSamples were dropped.
+	Truncated bool
+	Elapsed   time.Duration
+}
+
+// SamplesPerSecond returns the ingestion rate so far.
+func (st StreamStats) SamplesPerSecond() float64 {
+	if st.Elapsed <= 0 {
+		return 0
+	}
+	return float64(st.Samples) / st.Elapsed.Seconds()
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// LoadStream loads Prometheus text exposition line by line, without reading the whole
+// input into memory first, as LoadFromReader does. Samples without a timestamp get the
+// load time; HELP/TYPE metadata is kept and exemplars are dropped. Samples of the same
+// series share one label map, which keeps memory proportional to the number of series.
+// Use LoadFromReader for OpenMetrics (timestamps in seconds) and classic-format inputs
+// that need the full Prometheus parser.
+func (s *SimpleStorage) LoadStream(r io.Reader, opts StreamOptions) (StreamStats, error) {
+	var st StreamStats
+	start := time.Now()
+	baseTimestamp := start.UnixMilli()
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	nextProgress := start.Add(interval)
+	cr := &countingReader{r: r}
+	report := func() {
+		st.Bytes, st.Elapsed = cr.n, time.Since(start)
+		if opts.Progress != nil {
+			opts.Progress(st)
+		}
+	}
+
+	// The label map of each series by its text (everything before the value), parsed and
+	// filtered once and shared by the samples of the series, as after Compact.
+	series := make(map[string]map[string]string)
+	sc := bufio.NewScanner(cr)
+	sc.Buffer(make([]byte, 0, 64<<10), maxStreamLineBytes)
+	for sc.Scan() {
+		st.Lines++
+		if st.Lines%4096 == 0 && opts.Progress != nil && time.Now().After(nextProgress) {
+			report()
+			nextProgress = time.Now().Add(interval)
+		}
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			if fields := strings.SplitN(line, " ", 4); len(fields) == 4 {
+				switch fields[1] {
+				case "HELP":
+					s.MetricsHelp[fields[2]] = strings.ReplaceAll(fields[3], `\\`, `\`)
+				case "TYPE":
+					s.setMetricType(fields[2], fields[3])
+				}
+			}
+			continue
+		}
+		// Drop an OpenMetrics-style exemplar ("... # {trace_id="x"} 1").
+		if i := strings.LastIndex(line, " # {"); i > 0 {
+			line = line[:i]
+		}
+		name, lbls, value, ts, err := parseTimeSeriesLine(line)
+		if err != nil {
+			report()
+			return st, fmt.Errorf("line %d: %w", st.Lines, err)
+		}
+		key := line
+		if i := strings.LastIndexByte(line, '}'); i >= 0 {
+			key = line[:i+1]
+		} else if f := strings.Fields(line); len(f) > 0 {
+			key = f[0]
+		}
+		shared, ok := series[key]
+		if !ok {
+			lbls["__name__"] = name
+			if opts.Filter != nil && !opts.Filter(name, lbls) {
+				// Remember rejected series too, so the filter runs once per series.
+				series[key] = nil
+				st.Skipped++
+				continue
+			}
+			series[key] = lbls
+			shared = lbls
+		}
+		if shared == nil {
+			st.Skipped++
+			continue
+		}
+		if opts.MaxSamples > 0 && st.Samples >= opts.MaxSamples {
+			st.Truncated = true
+			break
+		}
+		// The value is followed by the timestamp, if any (which may be 0)
+		if len(strings.Fields(line[len(key):])) < 2 {
+			ts = baseTimestamp
+		}
+		s.Metrics[name] = append(s.Metrics[name], MetricSample{Labels: shared, Value: value, Timestamp: ts})
+		st.Samples++
+	}
+	if err := sc.Err(); err != nil {
+		report()
+		return st, fmt.Errorf("failed to read metrics: %w", err)
+	}
+	report()
+	return st, nil
+}
@@ -0,0 +1,105 @@
+package simple_storage
+
+import (
+	"strings"
+	"testing"
+)
+
+const testStreamInput = `# HELP http_requests_total Total HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{code="200",path="/"} 10 1700000000000
+http_requests_total{code="500",path="/"} 2 1700000000000
+http_requests_total{code="200",path="/"} 12 1700000060000 # {trace_id="abc"} 1
+node_load1 0.5 1700000000000
+http_requests_total{code="500",path="/"} 3 1700000060000
+`
+
+func TestLoadStream_MetadataAndSharedLabels(t *testing.T) {
+	s := NewSimpleStorage()
+	var reports int
+	st, err := s.LoadStream(strings.NewReader(testStreamInput), StreamOptions{Progress: func(StreamStats) { reports++ }})
+	if err != nil {
+		t.Fatalf("LoadStream: %v", err)
+	}
+	if st.Samples != 5 || st.Skipped != 0 || st.Truncated {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if st.Bytes != int64(len(testStreamInput)) || st.Lines != 7 {
+		t.Fatalf("expected %d bytes/7 lines, got %d/%d", len(testStreamInput), st.Bytes, st.Lines)
+	}
+	if reports == 0 {
+		t.Fatalf("expected a final progress report")
+	}
+	if s.MetricsHelp["http_requests_total"] != "Total HTTP requests." || s.MetricsType["http_requests_total"] != "counter" {
+		t.Fatalf("metadata not loaded: help=%q type=%q", s.MetricsHelp["http_requests_total"], s.MetricsType["http_requests_total"])
+	}
+	samples := s.Metrics["http_requests_total"]
+	if len(samples) != 4 {
+		t.Fatalf("expected 4 http_requests_total samples, got %d", len(samples))
+	}
+	// Samples 0 and 2 belong to the same series and must share the label map.
+	samples[0].Labels["probe"] = "x"
+	if samples[2].Labels["probe"] != "x" || samples[2].Value != 12 || samples[2].Exemplar != nil {
+		t.Fatalf("expected shared labels and dropped exemplar, got %+v", samples[2])
+	}
+	if samples[0].Labels["__name__"] != "http_requests_total" {
+		t.Fatalf("missing __name__ label: %v", samples[0].Labels)
+	}
+}
+
+func TestLoadStream_FilterAndMaxSamples(t *testing.T) {
+	s := NewSimpleStorage()
+	calls := 0
+	st, err := s.LoadStream(strings.NewReader(testStreamInput), StreamOptions{
+		Filter: func(name string, lbls map[string]string) bool {
+			calls++
+			return lbls["code"] == "500"
+		},
+	})
+	if err != nil {
+		t.Fatalf("LoadStream: %v", err)
+	}
+	if st.Samples != 2 || st.Skipped != 3 {
+		t.Fatalf("expected 2 kept/3 skipped, got %+v", st)
+	}
+	if calls != 3 {
+		t.Fatalf("expected the filter to run once per series (3), got %d", calls)
+	}
+	if _, ok := s.Metrics["node_load1"]; ok {
+		t.Fatalf("filtered metric should not be stored")
+	}
+
+	s = NewSimpleStorage()
+	st, err = s.LoadStream(strings.NewReader(testStreamInput), StreamOptions{MaxSamples: 3})
+	if err != nil {
+		t.Fatalf("LoadStream: %v", err)
+	}
+	if !st.Truncated || st.Samples != 3 || len(s.Metrics["http_requests_total"]) != 3 {
+		t.Fatalf("expected truncation after 3 samples, got %+v", st)
+	}
+	// Reaching the limit with the last sample drops nothing
+	s = NewSimpleStorage()
+	st, err = s.LoadStream(strings.NewReader(testStreamInput), StreamOptions{MaxSamples: 5})
+	if err != nil || st.Truncated || st.Samples != 5 {
+		t.Fatalf("expected all 5 samples without truncation, got %+v, %v", st, err)
+	}
+}
+
+func TestLoadStream_ZeroTimestamp(t *testing.T) {
+	s := NewSimpleStorage()
+	if _, err := s.LoadStream(strings.NewReader("up{job=\"a\"} 1 0\nup{job=\"b\"} 1\nnode_load1 0.5 0\n"), StreamOptions{}); err != nil {
+		t.Fatalf("LoadStream: %v", err)
+	}
+	up := s.Metrics["up"]
+	if len(up) != 2 || up[0].Timestamp != 0 || up[1].Timestamp == 0 || s.Metrics["node_load1"][0].Timestamp != 0 {
+		t.Fatalf("expected explicit 0 timestamps kept and missing ones set, got %+v %+v", up, s.Metrics["node_load1"])
+	}
+}
+
+func TestLoadStream_ParseErrorReportsLine(t *testing.T) {
+	s := NewSimpleStorage()
+	_, err := s.LoadStream(strings.NewReader("up 1\nup{job=\"a\" not-a-number\n"), StreamOptions{})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected a line 2 error, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package simple_storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// TSDBBackend persists samples to an on-disk Prometheus TSDB so data survives
+// restarts and history can grow beyond what is kept in memory.
+type TSDBBackend struct {
+	Dir string
+	DB  *tsdb.DB
+}
+
+// OpenTSDB opens (or creates) a TSDB in dir. Retention is disabled and out-of-order
+// ingestion is allowed so historical files can be backfilled in any order.
+func OpenTSDB(dir string) (*TSDBBackend, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("empty TSDB data directory")
+	}
+	opts := tsdb.DefaultOptions()
+	opts.RetentionDuration = 0
+	opts.OutOfOrderTimeWindow = int64(10 * 365 * 24 * time.Hour / time.Millisecond)
+	db, err := tsdb.Open(dir, nil, nil, opts, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open TSDB at %s: %w", dir, err)
+	}
+	return &TSDBBackend{Dir: dir, DB: db}, nil
+}
+
+// Querier implements storage.Queryable.
+func (b *TSDBBackend) Querier(mint, maxt int64) (storage.Querier, error) {
+	return b.DB.Querier(mint, maxt)
+}
+
+// Persist appends every sample in s to the TSDB and returns how many were written.
+// Samples already present with the same series and timestamp are skipped and not counted.
+func (b *TSDBBackend) Persist(s *SimpleStorage) (int, error) {
+	ctx := context.Background()
+	q, err := b.DB.Querier(math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = q.Close() }()
+	app := b.DB.Appender(ctx)
+	written := 0
+	for name, samples := range s.Metrics {
+		// The TSDB accepts an identical sample again without writing it, so look up the
+		// series and timestamps it already holds for the metric.
+		existing, err := tsdbSampleKeys(ctx, q, name)
+		if err != nil {
+			_ = app.Rollback()
+			return 0, err
+		}
+		for _, sample := range samples {
+			lset := labels.FromMap(sample.Labels)
+			key := sampleKey{lset.String(), sample.Timestamp}
+			if existing[key] {
+				continue
+			}
+			if sample.Histogram != nil {
+				_, err = app.AppendHistogram(0, lset, sample.Timestamp, nil, sample.Histogram)
+			} else {
+				_, err = app.Append(0, lset, sample.Timestamp, sample.Value)
+			}
+			switch {
+			case err == nil:
+				existing[key] = true
+				written++
+			case errors.Is(err, storage.ErrDuplicateSampleForTimestamp), errors.Is(err, storage.ErrOutOfOrderSample), errors.Is(err, storage.ErrOutOfBounds), errors.Is(err, storage.ErrTooOldSample):
+				// Conflicting or unacceptable sample; keep going with the rest.
+			default:
+				_ = app.Rollback()
+				return 0, err
+			}
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+// tsdbSampleKeys returns the series and timestamps of the samples of metric name in q.
+func tsdbSampleKeys(ctx context.Context, q storage.Querier, name string) (map[sampleKey]bool, error) {
+	keys := map[sampleKey]bool{}
+	set := q.Select(ctx, false, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, name))
+	var it chunkenc.Iterator
+	for set.Next() {
+		ser := set.At()
+		series := ser.Labels().String()
+		it = ser.Iterator(it)
+		for it.Next() != chunkenc.ValNone {
+			keys[sampleKey{series, it.AtT()}] = true
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return keys, set.Err()
+}
+
+// Stats returns the number of in-head series, persisted blocks, and the overall time range.
+// For an empty TSDB mint is greater than maxt.
+func (b *TSDBBackend) Stats() (headSeries uint64, blocks int, mint, maxt int64) {
+	head := b.DB.Head()
+	headSeries = head.NumSeries()
+	mint, maxt = head.MinTime(), head.MaxTime()
+	bs := b.DB.Blocks()
+	blocks = len(bs)
+	for _, blk := range bs {
+		meta := blk.Meta()
+		mint, maxt = min(mint, meta.MinTime), max(maxt, meta.MaxTime)
+	}
+	return headSeries, blocks, mint, maxt
+}
+
+// Close flushes and closes the underlying TSDB.
+func (b *TSDBBackend) Close() error {
+	return b.DB.Close()
+}
@@ -0,0 +1,66 @@
+package simple_storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+func TestTSDBBackend_PersistSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	store := NewSimpleStorage()
+	// Deliberately out of order to exercise backfill of older samples.
+	store.AddSample(map[string]string{"__name__": "jobs_total", "job": "a"}, 2, 1700000060000)
+	store.AddSample(map[string]string{"__name__": "jobs_total", "job": "a"}, 1, 1700000000000)
+	store.AddSample(map[string]string{"__name__": "jobs_total", "job": "b"}, 5, 1700000000000)
+
+	b, err := OpenTSDB(dir)
+	if err != nil {
+		t.Fatalf("OpenTSDB: %v", err)
+	}
+	n, err := b.Persist(store)
+	if err != nil || n != 3 {
+		t.Fatalf("Persist = %d, %v; want 3 samples", n, err)
+	}
+	// Persisting the same data again must not fail, nor count the samples again.
+	if n, err := b.Persist(store); err != nil || n != 0 {
+		t.Fatalf("second Persist = %d, %v; want 0 samples", n, err)
+	}
+	store.AddSample(map[string]string{"__name__": "jobs_total", "job": "b"}, 6, 1700000060000)
+	if n, err := b.Persist(store); err != nil || n != 1 {
+		t.Fatalf("third Persist = %d, %v; want only the new sample", n, err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	b, err = OpenTSDB(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer func() { _ = b.Close() }()
+	q, err := b.Querier(1699999999000, 1700000100000)
+	if err != nil {
+		t.Fatalf("Querier: %v", err)
+	}
+	defer func() { _ = q.Close() }()
+	ss := q.Select(context.Background(), true, nil, labels.MustNewMatcher(labels.MatchEqual, "job", "a"))
+	points := 0
+	for ss.Next() {
+		it := ss.At().Iterator(nil)
+		for it.Next() != chunkenc.ValNone {
+			points++
+		}
+	}
+	if err := ss.Err(); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if points != 2 {
+		t.Fatalf("expected 2 persisted points for job=a, got %d", points)
+	}
+	if _, _, mint, maxt := b.Stats(); mint != 1700000000000 || maxt != 1700000060000 {
+		t.Fatalf("unexpected TSDB range: %d..%d", mint, maxt)
+	}
+}
@@ -0,0 +1,152 @@
+package simple_storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// LoadTSDB copies the series matching matchers (all series when none) with samples in
+// [mint, maxt] from a Prometheus TSDB on disk into s: a data directory, a snapshot
+// directory (as made by /api/v1/admin/tsdb/snapshot) or a single block. The TSDB is
+// opened read-only and left untouched. Samples already in s, e.g. from loading the same TSDB
+// before, are skipped. It returns the number of series and samples added.
+func (s *SimpleStorage) LoadTSDB(ctx context.Context, dir string, mint, maxt int64, matchers ...*labels.Matcher) (int, int, error) {
+	if len(matchers) == 0 {
+		matchers = []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")}
+	}
+	q, closeTSDB, err := openTSDBQuerier(dir, mint, maxt)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer closeTSDB()
+
+	if s.Metrics == nil {
+		s.Metrics = make(map[string][]MetricSample)
+	}
+	// The series and timestamps of the samples in s, by metric name, built on first use
+	existing := map[string]map[sampleKey]bool{}
+	seen := func(name, series string, t int64) bool {
+		keys, ok := existing[name]
+		if !ok {
+			keys = make(map[sampleKey]bool, len(s.Metrics[name]))
+			for _, sample := range s.Metrics[name] {
+				keys[sampleKey{labels.FromMap(sample.Labels).String(), sample.Timestamp}] = true
+			}
+			existing[name] = keys
+		}
+		return keys[sampleKey{series, t}]
+	}
+
+	series, samples := 0, 0
+	set := q.Select(ctx, false, nil, matchers...)
+	var it chunkenc.Iterator
+	for set.Next() {
+		if err := ctx.Err(); err != nil {
+			return series, samples, err
+		}
+		ser := set.At()
+		// The samples of a series share its labels map
+		lbls := ser.Labels().Map()
+		name, key := lbls[labels.MetricName], ser.Labels().String()
+		added := 0
+		it = ser.Iterator(it)
+		for typ := it.Next(); typ != chunkenc.ValNone; typ = it.Next() {
+			if seen(name, key, it.AtT()) {
+				continue
+			}
+			switch typ {
+			case chunkenc.ValFloat:
+				t, v := it.At()
+				s.Metrics[name] = append(s.Metrics[name], MetricSample{Labels: lbls, Value: v, Timestamp: t})
+			case chunkenc.ValHistogram, chunkenc.ValFloatHistogram:
+				t, h := it.AtFloatHistogram(nil)
+				s.Metrics[name] = append(s.Metrics[name], MetricSample{Labels: lbls, Timestamp: t, Histogram: h})
+			}
+			added++
+		}
+		if err := it.Err(); err != nil {
+			return series, samples, err
+		}
+		if added > 0 {
+			series++
+			samples += added
+		}
+	}
+	if err := set.Err(); err != nil {
+		return series, samples, err
+	}
+	return series, samples, nil
+}
+
+// sampleKey identifies a sample by its series (labels.Labels.String) and timestamp.
+type sampleKey struct {
+	series string
+	t      int64
+}
+
+// openTSDBQuerier returns a querier over the TSDB in dir and a function closing both. A
+// data directory with a WAL is opened with tsdb.OpenDBReadOnly, which replays the WAL in
+// a temporary sandbox; otherwise dir is a block, or a snapshot holding blocks.
+func openTSDBQuerier(dir string, mint, maxt int64) (storage.Querier, func(), error) {
+	if _, err := os.Stat(filepath.Join(dir, "wal")); err == nil {
+		db, err := tsdb.OpenDBReadOnly(dir, os.TempDir(), nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open TSDB %s: %w", dir, err)
+		}
+		q, err := db.Querier(mint, maxt)
+		if err != nil {
+			_ = db.Close()
+			return nil, nil, fmt.Errorf("open TSDB %s: %w", dir, err)
+		}
+		return q, func() { _ = q.Close(); _ = db.Close() }, nil
+	}
+
+	blockDirs := []string{dir}
+	if _, err := os.Stat(filepath.Join(dir, "meta.json")); err != nil {
+		if blockDirs, err = filepath.Glob(filepath.Join(dir, "*", "meta.json")); err != nil {
+			return nil, nil, err
+		}
+		for i := range blockDirs {
+			blockDirs[i] = filepath.Dir(blockDirs[i])
+		}
+		if len(blockDirs) == 0 {
+			if _, err := os.Stat(dir); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, fmt.Errorf("%s is not a TSDB: no wal directory nor blocks", dir)
+		}
+	}
+	var blocks []*tsdb.Block
+	var queriers []storage.Querier
+	closeAll := func() {
+		for _, q := range queriers {
+			_ = q.Close()
+		}
+		for _, b := range blocks {
+			_ = b.Close()
+		}
+	}
+	for _, bd := range blockDirs {
+		b, err := tsdb.OpenBlock(nil, bd, nil, nil)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("open TSDB block %s: %w", bd, err)
+		}
+		blocks = append(blocks, b)
+		q, err := tsdb.NewBlockQuerier(b, mint, maxt)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		queriers = append(queriers, q)
+	}
+	// Overlapping blocks are merged as the TSDB does
+	return storage.NewMergeQuerier(queriers, nil, storage.ChainedSeriesMerge), closeAll, nil
+}
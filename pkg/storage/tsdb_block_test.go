@@ -0,0 +1,83 @@
+package simple_storage
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+func TestLoadTSDB(t *testing.T) {
+	dir, snap := t.TempDir(), t.TempDir()
+	store := NewSimpleStorage()
+	for i := int64(0); i < 10; i++ {
+		store.AddSample(map[string]string{"__name__": "jobs_total", "job": "api"}, float64(i), 1700000000000+i*15000)
+		store.AddSample(map[string]string{"__name__": "jobs_total", "job": "web"}, float64(i), 1700000000000+i*15000)
+	}
+	store.AddSample(map[string]string{"__name__": "up", "job": "api"}, 1, 1700000000000)
+	b, err := OpenTSDB(dir)
+	if err != nil {
+		t.Fatalf("OpenTSDB: %v", err)
+	}
+	if _, err := b.Persist(store); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	if err := b.DB.Snapshot(snap, true); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	blocks, _ := filepath.Glob(filepath.Join(snap, "*", "meta.json"))
+	if len(blocks) != 1 {
+		t.Fatalf("expected one snapshot block, got %v", blocks)
+	}
+
+	ctx := context.Background()
+	api := labels.MustNewMatcher(labels.MatchEqual, "job", "api")
+	for name, tc := range map[string]struct {
+		dir             string
+		mint, maxt      int64
+		matchers        []*labels.Matcher
+		series, samples int
+	}{
+		"data dir (WAL)": {dir, math.MinInt64, math.MaxInt64, nil, 3, 21},
+		"snapshot":       {snap, math.MinInt64, math.MaxInt64, []*labels.Matcher{api}, 2, 11},
+		"block":          {filepath.Dir(blocks[0]), 1700000030000, 1700000060000, []*labels.Matcher{api}, 1, 3},
+	} {
+		loaded := NewSimpleStorage()
+		series, samples, err := loaded.LoadTSDB(ctx, tc.dir, tc.mint, tc.maxt, tc.matchers...)
+		if err != nil || series != tc.series || samples != tc.samples {
+			t.Errorf("%s: got %d series, %d samples, %v; want %d, %d", name, series, samples, err, tc.series, tc.samples)
+			continue
+		}
+		if got := loaded.Metrics["jobs_total"]; len(got) == 0 || got[0].Labels["__name__"] != "jobs_total" {
+			t.Errorf("%s: unexpected samples %+v", name, got)
+		}
+	}
+
+	// Loading a TSDB again only adds the samples not loaded yet
+	loaded := NewSimpleStorage()
+	if _, _, err := loaded.LoadTSDB(ctx, snap, 1700000000000, 1700000060000); err != nil {
+		t.Fatalf("LoadTSDB: %v", err)
+	}
+	series, samples, err := loaded.LoadTSDB(ctx, snap, math.MinInt64, math.MaxInt64)
+	if err != nil || series != 2 || samples != 10 {
+		t.Fatalf("reload: got %d series, %d samples, %v; want 2, 10", series, samples, err)
+	}
+	if n := len(loaded.Metrics["jobs_total"]) + len(loaded.Metrics["up"]); n != 21 {
+		t.Fatalf("reload: got %d samples in the store, want 21", n)
+	}
+
+	// The TSDB is left untouched
+	entries, _ := os.ReadDir(snap)
+	if len(entries) != 1 {
+		t.Fatalf("the snapshot directory changed: %v", entries)
+	}
+	if _, _, err := NewSimpleStorage().LoadTSDB(ctx, filepath.Join(dir, "missing"), 0, 1); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
@@ -71,6 +71,13 @@ func main() {
 		}
 	}
 
+	// OpenAI-compatible server (llama.cpp, vLLM, LM Studio): only when configured, no curated list
+	if base := os.Getenv("PROMQL_CLI_OPENAI_COMPAT_BASE"); base != "" {
+		if ms, err := fetchOpenAICompat(client, os.Getenv("PROMQL_CLI_OPENAI_COMPAT_API_KEY"), base); err == nil && len(ms) > 0 {
+			cat["openai-compat"] = ms
+		}
+	}
+
 	// Order entries for stable diffs
 	for k := range cat {
 		sort.Slice(cat[k], func(i, j int) bool { return cat[k][i].ID < cat[k][j].ID })
@@ -111,7 +118,9 @@ func fetchOpenAI(c *http.Client, key, base string) ([]ModelInfo, error) {
 func fetchOpenAICompat(c *http.Client, key, base string) ([]ModelInfo, error) {
 	base = strings.TrimRight(base, "/")
 	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, base+"/models", nil)
-	req.Header.Set("Authorization", "Bearer "+key)
+	if key != "" { // local servers usually run without one
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
 	resp, err := c.Do(req)
 	if err != nil {
 		return nil, err